@@ -0,0 +1,48 @@
+// Package access restricts operational and configuration endpoints to
+// admins: PocketBase admins, and users whose role is admin
+package access
+
+import (
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/models"
+)
+
+// RoleField is the users field holding the user's role
+const RoleField = "role"
+
+// Roles. Users without a role are RoleUser.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// getter is implemented by both echo contexts and realtime clients
+type getter interface {
+	Get(key string) any
+}
+
+// IsAdmin reports whether a request or realtime client is authenticated as
+// a PocketBase admin or as a user with the admin role
+func IsAdmin(g getter) bool {
+	if admin, _ := g.Get(apis.ContextAdminKey).(*models.Admin); admin != nil {
+		return true
+	}
+	record, _ := g.Get(apis.ContextAuthRecordKey).(*models.Record)
+	return record != nil && record.Collection().Name == "users" && record.GetString(RoleField) == RoleAdmin
+}
+
+// RequireAdmin only lets admins through, see IsAdmin
+func RequireAdmin() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if IsAdmin(c) {
+				return next(c)
+			}
+			if c.Get(apis.ContextAuthRecordKey) == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+			return apis.NewForbiddenError("Admin role required", nil)
+		}
+	}
+}
@@ -0,0 +1,387 @@
+// Package account exports everything stored about a user as a zip archive
+// and deletes accounts together with their files: recordings, cached
+// channel thumbnails and subtitle exports, which deleting the user record
+// alone would leave behind.
+package account
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+
+	"iptv-backend/logging"
+	"iptv-backend/recorder"
+	"iptv-backend/subtitle"
+	"iptv-backend/thumbnail"
+)
+
+// Config gives the service the file stores an account spans
+type Config struct {
+	Recorder   *recorder.RecorderService
+	Thumbnails *thumbnail.ThumbnailService
+	Subtitles  *subtitle.SubtitleService
+}
+
+// DeleteResult summarizes what deleting an account removed
+type DeleteResult struct {
+	RecordingsStopped int      `json:"recordings_stopped"`
+	RecordingsDeleted int      `json:"recordings_deleted"`
+	ThumbnailsCleared int      `json:"thumbnails_cleared"`
+	ExportsDeleted    int      `json:"exports_deleted"`
+	Failed            []string `json:"failed,omitempty"` // Files that couldn't be deleted
+}
+
+// Service exports and deletes accounts
+type Service struct {
+	app    core.App
+	config Config
+	logger *slog.Logger
+}
+
+// NewService returns an account service
+func NewService(app core.App, config Config) *Service {
+	return &Service{app: app, config: config, logger: logging.For("account")}
+}
+
+// contextKey holds the pending deletion of a records API delete request
+const contextKey = "accountDeletion"
+
+// pending is a deletion between its before and after hooks
+type pending struct {
+	data   *data
+	result *DeleteResult
+}
+
+// data holds the records of a user, by collection
+type data struct {
+	profiles       []*models.Record
+	playlists      []*models.Record
+	channels       []*models.Record
+	channelGroups  []*models.Record
+	epgMappings    []*models.Record
+	favorites      []*models.Record
+	watchHistory   []*models.Record
+	watchTime      []*models.Record
+	reminders      []*models.Record
+	recordings     []*models.Record
+	subtitles      []*models.Record
+	settings       []*models.Record
+	subtitleIDs    []string // session_id of the subtitle sessions
+	channelIDs     []string
+	recordingFiles []string // Base names of the files of the user's recordings
+}
+
+// Export writes a zip archive of the user's account, profiles, playlists,
+// channels with their groups and guide mappings, favorites, watch history
+// and time, recording metadata, own settings and subtitle transcripts to w. Secrets
+// (password and TOTP hashes, PINs) are left out.
+func (s *Service) Export(w io.Writer, user *models.Record) error {
+	d, err := s.load(user.Id)
+	if err != nil {
+		return err
+	}
+
+	archive := zip.NewWriter(w)
+
+	files := []struct {
+		name  string
+		value interface{}
+	}{
+		{"account.json", accountInfo(user)},
+		{"profiles.json", exportRecords(d.profiles)},
+		{"playlists.json", exportRecords(d.playlists)},
+		{"channels.json", exportRecords(d.channels)},
+		{"channel_groups.json", exportRecords(d.channelGroups)},
+		{"epg_mappings.json", exportRecords(d.epgMappings)},
+		{"favorites.json", exportRecords(d.favorites)},
+		{"watch_history.json", exportRecords(d.watchHistory)},
+		{"watch_time.json", exportRecords(d.watchTime)},
+		{"reminders.json", exportRecords(d.reminders)},
+		{"recordings.json", exportRecords(d.recordings)},
+		{"subtitle_sessions.json", exportRecords(d.subtitles)},
+		{"settings.json", exportRecords(d.settings)},
+	}
+	for _, file := range files {
+		if err := writeJSON(archive, file.name, file.value); err != nil {
+			return err
+		}
+	}
+
+	store := subtitle.NewRecordStore(s.app)
+	for _, session := range d.subtitles {
+		entries, err := store.Entries(session.Id)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			continue
+		}
+
+		name := "transcripts/" + session.GetString("session_id") + "_" + session.Id
+		if err := writeJSON(archive, name+".json", entries); err != nil {
+			return err
+		}
+
+		srt, err := subtitle.FormatSubtitles(entries, subtitle.FormatSRT)
+		if err != nil {
+			return err
+		}
+		f, err := archive.Create(name + ".srt")
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, srt); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+// Register installs hooks removing the files of users deleted through the
+// records API, by admins from the dashboard or by users themselves
+func (s *Service) Register() {
+	s.app.OnRecordBeforeDeleteRequest("users").Add(func(e *core.RecordDeleteEvent) error {
+		d, result, err := s.prepare(e.Record)
+		if err != nil {
+			return err
+		}
+		e.HttpContext.Set(contextKey, &pending{data: d, result: result})
+		return nil
+	})
+
+	s.app.OnRecordAfterDeleteRequest("users").Add(func(e *core.RecordDeleteEvent) error {
+		if p, _ := e.HttpContext.Get(contextKey).(*pending); p != nil {
+			s.purge(e.Record.Id, p.data, p.result)
+		}
+		return nil
+	})
+}
+
+// Delete deletes a user and everything they own. Their running recordings
+// and subtitle sessions are stopped, the records are deleted through
+// PocketBase's cascades, then recorded files (protected ones included),
+// thumbnails of their channels and their subtitle exports are removed.
+// Files failing to delete are reported in the result, the account is gone
+// either way.
+func (s *Service) Delete(user *models.Record) (*DeleteResult, error) {
+	d, result, err := s.prepare(user)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.app.Dao().DeleteRecord(user); err != nil {
+		return nil, err
+	}
+
+	s.purge(user.Id, d, result)
+	return result, nil
+}
+
+// prepare loads the records of a user about to be deleted and stops their
+// running recordings and subtitle sessions
+func (s *Service) prepare(user *models.Record) (*data, *DeleteResult, error) {
+	d, err := s.load(user.Id)
+	if err != nil {
+		return nil, nil, err
+	}
+	result := &DeleteResult{}
+
+	if s.config.Recorder != nil {
+		for _, recording := range s.config.Recorder.GetAllRecordings() {
+			if recording.UserID != user.Id {
+				continue
+			}
+			if _, err := s.config.Recorder.StopRecording(recording.ID); err == nil {
+				result.RecordingsStopped++
+			}
+		}
+	}
+
+	if s.config.Subtitles != nil {
+		for _, session := range s.config.Subtitles.GetAllSessions() {
+			if session.UserID == user.Id {
+				s.config.Subtitles.DeleteSession(session.ID)
+			}
+		}
+	}
+
+	return d, result, nil
+}
+
+// purge removes the files of a deleted user
+func (s *Service) purge(userID string, d *data, result *DeleteResult) {
+	if s.config.Recorder != nil {
+		files := make(map[string]bool)
+		for _, name := range s.config.Recorder.OwnedFiles(userID) {
+			files[name] = true
+		}
+		for _, name := range d.recordingFiles {
+			files[name] = true
+		}
+
+		for name := range files {
+			err := s.config.Recorder.PurgeFile(name)
+			switch {
+			case err == nil:
+				result.RecordingsDeleted++
+			case !os.IsNotExist(err):
+				s.logger.Warn("failed to delete recording of deleted account", "user", userID, "file", name, "error", err)
+				result.Failed = append(result.Failed, name)
+			}
+		}
+	}
+
+	if s.config.Thumbnails != nil {
+		for _, id := range d.channelIDs {
+			s.config.Thumbnails.InvalidateThumbnail(id)
+		}
+		result.ThumbnailsCleared = len(d.channelIDs)
+	}
+
+	if s.config.Subtitles != nil {
+		sessions := make(map[string]bool, len(d.subtitleIDs))
+		for _, id := range d.subtitleIDs {
+			sessions[id] = true
+		}
+
+		exports, err := s.config.Subtitles.ListExports()
+		if err != nil {
+			s.logger.Warn("failed to list subtitle exports of deleted account", "user", userID, "error", err)
+		}
+		for _, export := range exports {
+			if sessionID, _ := subtitle.ExportSession(export.Key); !sessions[sessionID] {
+				continue
+			}
+			if err := s.config.Subtitles.DeleteExport(export.Key); err != nil {
+				s.logger.Warn("failed to delete subtitle export of deleted account", "user", userID, "file", export.Key, "error", err)
+				result.Failed = append(result.Failed, export.Key)
+				continue
+			}
+			result.ExportsDeleted++
+		}
+	}
+
+	s.logger.Info("account deleted", "user", userID,
+		"recordings_deleted", result.RecordingsDeleted,
+		"exports_deleted", result.ExportsDeleted,
+		"failed", len(result.Failed))
+}
+
+// load finds the records of a user
+func (s *Service) load(userID string) (*data, error) {
+	d := &data{}
+
+	// Note: using ~ as relation fields may be stored as arrays. Profiles
+	// may be shared by several users, they belong to all of them.
+	finds := []struct {
+		records    *[]*models.Record
+		collection string
+		filter     string
+	}{
+		{&d.profiles, "profiles", "user ~ {:user}"},
+		{&d.playlists, "playlists", "user ~ {:user}"},
+		{&d.channels, "channels", "playlist.user ~ {:user}"},
+		{&d.channelGroups, "channel_groups", "user = {:user}"},
+		{&d.epgMappings, "epg_mappings", "user = {:user}"},
+		{&d.favorites, "favorites", "profile.user ~ {:user}"},
+		{&d.watchHistory, "watch_history", "profile.user ~ {:user}"},
+		{&d.watchTime, "watch_time", "profile.user ~ {:user}"},
+		{&d.reminders, "reminders", "user = {:user}"},
+		{&d.recordings, "recordings", "profile.user ~ {:user}"},
+		{&d.subtitles, subtitle.SessionsCollectionName, "user = {:user}"},
+		{&d.settings, "app_settings", "user = {:user}"},
+	}
+	for _, f := range finds {
+		records, err := s.find(f.collection, f.filter, userID)
+		if err != nil {
+			return nil, err
+		}
+		*f.records = records
+	}
+
+	for _, record := range d.channels {
+		d.channelIDs = append(d.channelIDs, record.Id)
+	}
+	for _, record := range d.subtitles {
+		d.subtitleIDs = append(d.subtitleIDs, record.GetString("session_id"))
+	}
+
+	// Files of recordings of shared profiles stay with the other users
+	shared := make(map[string]bool)
+	for _, profile := range d.profiles {
+		if len(profile.GetStringSlice("user")) > 1 {
+			shared[profile.Id] = true
+		}
+	}
+	for _, record := range d.recordings {
+		if slices.ContainsFunc(record.GetStringSlice("profile"), func(id string) bool { return shared[id] }) {
+			continue
+		}
+		for _, field := range []string{"file_path", "subtitle_path"} {
+			if path := record.GetString(field); path != "" {
+				d.recordingFiles = append(d.recordingFiles, filepath.Base(path))
+			}
+		}
+	}
+
+	return d, nil
+}
+
+// find returns the records of a collection matching a filter on the user,
+// none when the collection doesn't exist
+func (s *Service) find(collection, filter, userID string) ([]*models.Record, error) {
+	if _, err := s.app.Dao().FindCollectionByNameOrId(collection); err != nil {
+		return nil, nil
+	}
+	return s.app.Dao().FindRecordsByFilter(collection, filter, "created", 0, 0, dbx.Params{"user": userID})
+}
+
+// accountInfo returns the exportable fields of a user
+func accountInfo(user *models.Record) map[string]interface{} {
+	return map[string]interface{}{
+		"id":               user.Id,
+		"email":            user.Email(),
+		"username":         user.Username(),
+		"name":             user.GetString("name"),
+		"role":             user.GetString("role"),
+		"verified":         user.Verified(),
+		"totp_enabled":     user.GetBool("totp_enabled"),
+		"storage_quota_mb": user.GetInt("storage_quota_mb"),
+		"created":          user.Created,
+		"updated":          user.Updated,
+		"exported":         time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+// exportRecords returns the public fields of records
+func exportRecords(records []*models.Record) []map[string]interface{} {
+	list := make([]map[string]interface{}, 0, len(records))
+	for _, record := range records {
+		list = append(list, record.PublicExport())
+	}
+	return list
+}
+
+func writeJSON(archive *zip.Writer, name string, value interface{}) error {
+	f, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+// Package apikeys lets scripts, Kodi plugins and automation call a subset
+// of the API with a static key instead of a PocketBase auth token. Each key
+// belongs to a user and is scoped to capabilities; a key-authenticated
+// request acts as its user on the routes of those capabilities only.
+package apikeys
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/types"
+
+	"iptv-backend/hashedtokens"
+	"iptv-backend/logging"
+)
+
+// Collection is where keys are stored
+const Collection = "api_keys"
+
+// Header carries keys. They aren't taken from the query string, which ends
+// up in access logs and browser history.
+const Header = "X-API-Key"
+
+// ContextKey holds the api_keys record of a key-authenticated request
+const ContextKey = "apiKey"
+
+// keyPrefix starts every key
+const keyPrefix = "sv_"
+
+// Capabilities a key can be scoped to
+const (
+	ScopeRecorder = "recorder" // Start, stop and list recordings, download recorded files
+	ScopeEPG      = "epg"      // Read channels and playlists, read and map the program guide
+	ScopeExport   = "export"   // List, search and export subtitles
+)
+
+// AllScopes lists the valid scopes
+var AllScopes = []string{ScopeRecorder, ScopeEPG, ScopeExport}
+
+// scopeCollections are the collections each scope may use through the
+// records API, read only unless the scope may write them
+var scopeCollections = map[string][]string{
+	ScopeRecorder: {"recordings"},
+	ScopeEPG:      {"channels", "playlists", "epg_sources", "epg_programs"},
+}
+
+// scopeWritableCollections may also be created, updated and deleted
+var scopeWritableCollections = map[string][]string{
+	ScopeRecorder: {"recordings"},
+}
+
+// ErrInvalidScopes is returned for keys without a valid scope
+var ErrInvalidScopes = errors.New("scopes must be one or more of recorder, epg, export")
+
+// Key describes a key without its secret
+type Key struct {
+	ID         string         `json:"id"`
+	Name       string         `json:"name"`
+	Prefix     string         `json:"prefix"`
+	Scopes     []string       `json:"scopes"`
+	Created    types.DateTime `json:"created"`
+	ExpiresAt  types.DateTime `json:"expires_at"`
+	LastUsedAt types.DateTime `json:"last_used_at"`
+}
+
+// Service creates, checks and revokes keys
+type Service struct {
+	app  core.App
+	keys *hashedtokens.Store
+}
+
+// NewService returns a key service over the app's database
+func NewService(app core.App) *Service {
+	return &Service{
+		app:  app,
+		keys: hashedtokens.NewStore(app, logging.For("apikeys"), Collection, "key_hash", keyPrefix),
+	}
+}
+
+// Create generates a key for a user and returns it with its description.
+// The key is only available now, only its hash is stored. A zero expiry
+// never expires.
+func (s *Service) Create(userID, name string, scopes []string, expiresAt time.Time) (string, Key, error) {
+	if len(scopes) == 0 {
+		return "", Key{}, ErrInvalidScopes
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(AllScopes, scope) {
+			return "", Key{}, ErrInvalidScopes
+		}
+	}
+
+	fields := map[string]any{"name": name, "scopes": scopes}
+	if !expiresAt.IsZero() {
+		fields["expires_at"] = expiresAt
+	}
+	key, record, err := s.keys.Create(userID, fields)
+	if err != nil {
+		return "", Key{}, err
+	}
+	return key, describe(record), nil
+}
+
+// List returns the keys of a user, newest first
+func (s *Service) List(userID string) ([]Key, error) {
+	records, err := s.keys.List(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]Key, 0, len(records))
+	for _, record := range records {
+		keys = append(keys, describe(record))
+	}
+	return keys, nil
+}
+
+// Revoke deletes a key of a user
+func (s *Service) Revoke(userID, id string) error {
+	return s.keys.Revoke(userID, id)
+}
+
+// Middleware authenticates requests carrying a key as the key's user, on
+// the routes of the key's scopes. routeScope returns the scope a custom
+// route declares, by its method and path. Requests with an auth token are
+// left alone. It must run after PocketBase loaded the auth token.
+func (s *Service) Middleware(routeScope func(method, path string) string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			key := c.Request().Header.Get(Header)
+			if key == "" || c.Get(apis.ContextAuthRecordKey) != nil || c.Get(apis.ContextAdminKey) != nil {
+				return next(c)
+			}
+
+			record, err := s.find(key)
+			if err != nil {
+				return apis.NewUnauthorizedError("Invalid or expired API key", nil)
+			}
+
+			var scopes []string
+			record.UnmarshalJSONField("scopes", &scopes)
+			if !Allowed(scopes, routeScope(c.Request().Method, c.Path()), c) {
+				return apis.NewForbiddenError("The API key is not allowed to use this endpoint", nil)
+			}
+
+			user, err := s.app.Dao().FindRecordById("users", record.GetString("user"))
+			if err != nil {
+				return apis.NewUnauthorizedError("Invalid or expired API key", nil)
+			}
+
+			c.Set(apis.ContextAuthRecordKey, user)
+			c.Set(ContextKey, record)
+			s.keys.Touch(record)
+
+			return next(c)
+		}
+	}
+}
+
+// Allowed reports whether a request belongs to one of scopes: its route
+// declares routeScope, or it uses a collection of the scope through the
+// records API
+func Allowed(scopes []string, routeScope string, c echo.Context) bool {
+	if routeScope != "" && slices.Contains(scopes, routeScope) {
+		return true
+	}
+
+	method := c.Request().Method
+	collection := c.PathParam("collection")
+	isRecords := collection != "" && (c.Path() == "/api/collections/:collection/records" || c.Path() == "/api/collections/:collection/records/:id")
+	if !isRecords {
+		return false
+	}
+
+	for _, scope := range scopes {
+		if method == http.MethodGet && slices.Contains(scopeCollections[scope], collection) {
+			return true
+		}
+		if slices.Contains(scopeWritableCollections[scope], collection) {
+			return true
+		}
+	}
+	return false
+}
+
+// find returns the record of a valid, unexpired key
+func (s *Service) find(key string) (*models.Record, error) {
+	record, err := s.keys.Find(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if expires := record.GetDateTime("expires_at"); !expires.IsZero() && expires.Time().Before(time.Now()) {
+		return nil, errors.New("api key expired")
+	}
+	return record, nil
+}
+
+func describe(record *models.Record) Key {
+	key := Key{
+		ID:         record.Id,
+		Name:       record.GetString("name"),
+		Prefix:     record.GetString("prefix"),
+		Created:    record.Created,
+		ExpiresAt:  record.GetDateTime("expires_at"),
+		LastUsedAt: record.GetDateTime("last_used_at"),
+	}
+	record.UnmarshalJSONField("scopes", &key.Scopes)
+	return key
+}
@@ -0,0 +1,244 @@
+// Package audit records security-sensitive actions (2FA changes, logins,
+// configuration changes, deletions, imports) in the audit_log collection
+package audit
+
+import (
+	"log/slog"
+	"strings"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+
+	"iptv-backend/logging"
+)
+
+// Collection is where entries are stored
+const Collection = "audit_log"
+
+// Audited actions
+const (
+	ActionTOTPEnable        = "totp.enable"
+	ActionTOTPDisable       = "totp.disable"
+	ActionTOTPValidate      = "totp.validate"
+	ActionLogin             = "auth.login"
+	ActionOllamaConfig      = "settings.ollama"
+	ActionSettingsUpdate    = "settings.update"
+	ActionRecordingDelete   = "recording.delete"
+	ActionPlaylistImport    = "playlist.import"
+	ActionChannelBulk       = "channel.bulk"
+	ActionAPIKeyCreate      = "apikey.create"
+	ActionAPIKeyRevoke      = "apikey.revoke"
+	ActionStreamTokenCreate = "streamtoken.create"
+	ActionStreamTokenRevoke = "streamtoken.revoke"
+	ActionProfilePIN        = "profile.pin_verify"
+	ActionProfilePINSet     = "profile.pin_change"
+	ActionSessionRevoke     = "session.revoke"
+	ActionSignOutOthers     = "session.revoke_others"
+	ActionDeviceUntrust     = "device.untrust"
+	ActionAccountExport     = "account.export"
+	ActionAccountDelete     = "account.delete"
+)
+
+// contextKey holds the entry of the request being audited
+const contextKey = "auditEntry"
+
+// Entry is one audited action
+type Entry struct {
+	Action    string
+	User      string
+	Admin     string
+	Target    string
+	Success   bool
+	Status    int
+	IP        string
+	UserAgent string
+	RequestID string
+	Details   map[string]interface{}
+}
+
+// Service writes audit entries
+type Service struct {
+	app    core.App
+	logger *slog.Logger
+}
+
+// NewService returns an audit service writing to the app's database
+func NewService(app core.App) *Service {
+	return &Service{app: app, logger: logging.For("audit")}
+}
+
+// Log stores an entry. Failures are logged, they never fail the action.
+func (s *Service) Log(entry Entry) {
+	collection, err := s.app.Dao().FindCollectionByNameOrId(Collection)
+	if err != nil {
+		s.logger.Warn("audit log collection not found", "action", entry.Action)
+		return
+	}
+
+	record := models.NewRecord(collection)
+	record.Set("action", entry.Action)
+	record.Set("user", entry.User)
+	record.Set("admin", entry.Admin)
+	record.Set("target", entry.Target)
+	record.Set("success", entry.Success)
+	record.Set("status", entry.Status)
+	record.Set("ip", entry.IP)
+	record.Set("user_agent", truncate(entry.UserAgent, 500))
+	record.Set("request_id", entry.RequestID)
+	if len(entry.Details) > 0 {
+		record.Set("details", entry.Details)
+	}
+
+	if err := s.app.Dao().SaveRecord(record); err != nil {
+		s.logger.Error("failed to write audit entry", "action", entry.Action, "error", err)
+	}
+}
+
+// LogRequest stores an entry for a request, filling in who made it and from
+// where
+func (s *Service) LogRequest(c echo.Context, entry Entry) {
+	if entry.User == "" {
+		if record, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record); record != nil {
+			entry.User = record.Id
+		}
+	}
+	if admin, _ := c.Get(apis.ContextAdminKey).(*models.Admin); admin != nil {
+		entry.Admin = admin.Id
+	}
+	entry.IP = c.RealIP()
+	entry.UserAgent = c.Request().UserAgent()
+	entry.RequestID = logging.RequestID(c.Request().Context())
+
+	s.Log(entry)
+}
+
+// Middleware records action for every request of a route once it is
+// handled, successful or not. The target defaults to the route's path
+// parameters; handlers can refine the entry with SetUser, SetTarget and
+// SetDetail.
+func (s *Service) Middleware(action string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			entry := &Entry{Action: action}
+			c.Set(contextKey, entry)
+
+			err := next(c)
+
+			entry.Status = c.Response().Status
+			if err != nil {
+				entry.Status = 500
+				if apiErr, ok := err.(*apis.ApiError); ok {
+					entry.Status = apiErr.Code
+				}
+			}
+			entry.Success = err == nil && entry.Status < 400
+
+			if entry.Target == "" {
+				values := make([]string, 0, len(c.PathParams()))
+				for _, param := range c.PathParams() {
+					values = append(values, param.Value)
+				}
+				entry.Target = strings.Join(values, "/")
+			}
+
+			s.LogRequest(c, *entry)
+			return err
+		}
+	}
+}
+
+// SetUser sets the user of the audited request, for actions made before
+// the user is authenticated such as login validations
+func SetUser(c echo.Context, userID string) {
+	if entry, _ := c.Get(contextKey).(*Entry); entry != nil {
+		entry.User = userID
+	}
+}
+
+// SetTarget sets what the audited request applies to
+func SetTarget(c echo.Context, target string) {
+	if entry, _ := c.Get(contextKey).(*Entry); entry != nil {
+		entry.Target = target
+	}
+}
+
+// SetDetail adds a detail to the entry of the audited request
+func SetDetail(c echo.Context, key string, value interface{}) {
+	if entry, _ := c.Get(contextKey).(*Entry); entry != nil {
+		if entry.Details == nil {
+			entry.Details = make(map[string]interface{})
+		}
+		entry.Details[key] = value
+	}
+}
+
+// Query selects entries
+type Query struct {
+	Action  string
+	User    string
+	Page    int
+	PerPage int
+}
+
+// Page is a page of entries, newest first, shaped like PocketBase lists
+type Page struct {
+	Page       int              `json:"page"`
+	PerPage    int              `json:"perPage"`
+	TotalItems int              `json:"totalItems"`
+	TotalPages int              `json:"totalPages"`
+	Items      []*models.Record `json:"items"`
+}
+
+// List returns a page of entries matching query
+func (s *Service) List(query Query) (*Page, error) {
+	if query.Page < 1 {
+		query.Page = 1
+	}
+	if query.PerPage < 1 || query.PerPage > 200 {
+		query.PerPage = 50
+	}
+
+	where := dbx.HashExp{}
+	if query.Action != "" {
+		where["action"] = query.Action
+	}
+	if query.User != "" {
+		where["user"] = query.User
+	}
+
+	var total int
+	if err := s.app.Dao().RecordQuery(Collection).
+		Select("count(*)").
+		Where(where).
+		Row(&total); err != nil {
+		return nil, err
+	}
+
+	records := []*models.Record{}
+	if err := s.app.Dao().RecordQuery(Collection).
+		Where(where).
+		OrderBy("created DESC", "rowid DESC").
+		Offset(int64((query.Page - 1) * query.PerPage)).
+		Limit(int64(query.PerPage)).
+		All(&records); err != nil {
+		return nil, err
+	}
+
+	return &Page{
+		Page:       query.Page,
+		PerPage:    query.PerPage,
+		TotalItems: total,
+		TotalPages: (total + query.PerPage - 1) / query.PerPage,
+		Items:      records,
+	}, nil
+}
+
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return s[:max]
+}
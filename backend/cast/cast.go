@@ -0,0 +1,453 @@
+// Package cast plays channels and recordings on the Chromecast and DLNA
+// renderers of the local network. Devices are found with mDNS and SSDP,
+// which only reach the network the backend is on: in Docker it needs host
+// networking. Renderers fetch the media themselves, from signed URLs of the
+// stream proxy, so those URLs must point at an address they can reach.
+package cast
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net"
+	"net/url"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"iptv-backend/logging"
+	"iptv-backend/stream"
+)
+
+// Kinds of devices
+const (
+	KindChromecast = "chromecast"
+	KindDLNA       = "dlna"
+)
+
+// Playback states of a device
+const (
+	StateIdle      = "idle"
+	StateBuffering = "buffering"
+	StatePlaying   = "playing"
+	StatePaused    = "paused"
+)
+
+// DiscoveryTimeout is how long a scan waits for devices to answer
+const DiscoveryTimeout = 3 * time.Second
+
+const (
+	scanInterval = 5 * time.Minute  // Age of the device list before it is scanned again
+	deviceTTL    = 15 * time.Minute // How long a device is listed after it last answered
+)
+
+var (
+	ErrNotFound    = errors.New("cast device not found")
+	ErrNotCasting  = errors.New("nothing is playing on the device")
+	ErrUnreachable = errors.New("the device can't reach the media URL: set PUBLIC_URL to the backend's address on the local network")
+)
+
+// Device is a renderer found on the network
+type Device struct {
+	ID       string    `json:"id"`
+	Kind     string    `json:"kind"`
+	Name     string    `json:"name"`
+	Model    string    `json:"model,omitempty"`
+	Address  string    `json:"address"` // host:port
+	LastSeen time.Time `json:"last_seen"`
+	Casting  *Session  `json:"casting,omitempty"` // What the backend last cast to it
+
+	avTransport upnpService // DLNA playback control
+	rendering   upnpService // DLNA volume control, may be missing
+}
+
+// Profile returns what a device plays natively, to resolve how it plays a
+// channel or recording
+func (d Device) Profile() stream.DeviceProfile {
+	profile := stream.DeviceProfile{
+		Name:        d.Name,
+		VideoCodecs: []string{"h264"},
+		AudioCodecs: []string{"aac", "mp3"},
+		Containers:  []string{"hls", "mp4"},
+		MaxHeight:   1080,
+	}
+	if d.Kind == KindDLNA {
+		// TVs play MPEG-TS, few of them play HLS
+		profile.AudioCodecs = append(profile.AudioCodecs, "ac3")
+		profile.Containers = []string{"mpegts", "mp4"}
+	}
+	return profile
+}
+
+// Media is what a device is told to play
+type Media struct {
+	URL         string // Absolute, fetched by the device
+	ContentType string
+	Title       string
+	ImageURL    string
+	Live        bool
+	Channel     string // Channel record, for live media
+	Recording   string // Recorded file
+}
+
+// Session is what was cast to a device from the backend
+type Session struct {
+	UserID    string    `json:"user"`
+	Title     string    `json:"title"`
+	Channel   string    `json:"channel,omitempty"`
+	Recording string    `json:"recording,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// Status is the playback state of a device
+type Status struct {
+	State    string  `json:"state"`              // idle, buffering, playing or paused
+	Volume   float64 `json:"volume"`             // From 0 to 1, -1 when unknown
+	Muted    bool    `json:"muted"`              // Only reported by Chromecasts
+	Position float64 `json:"position,omitempty"` // Seconds into the media
+}
+
+// renderer controls playback on a device
+type renderer interface {
+	Load(ctx context.Context, media Media) error
+	Play(ctx context.Context) error
+	Pause(ctx context.Context) error
+	Stop(ctx context.Context) error
+	SetVolume(ctx context.Context, level float64) error
+	Status(ctx context.Context) (*Status, error)
+	Alive() bool // False once the connection to the device is lost
+	Close() error
+}
+
+// Service discovers devices and keeps a connection to those it controls
+type Service struct {
+	mu        sync.Mutex
+	devices   map[string]*Device
+	sessions  map[string]*Session
+	renderers map[string]renderer
+	scanned   time.Time
+	scanMu    sync.Mutex // One scan at a time
+	logger    *slog.Logger
+}
+
+// NewService returns a cast service. The network is scanned on first use.
+func NewService() *Service {
+	return &Service{
+		devices:   make(map[string]*Device),
+		sessions:  make(map[string]*Session),
+		renderers: make(map[string]renderer),
+		logger:    logging.For("cast"),
+	}
+}
+
+// Devices returns the devices found on the network by name, scanning it
+// again when refresh is set or the list is older than scanInterval
+func (s *Service) Devices(ctx context.Context, refresh bool) []Device {
+	s.mu.Lock()
+	stale := time.Since(s.scanned) > scanInterval
+	s.mu.Unlock()
+	if refresh || stale {
+		s.scan(ctx)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	devices := make([]Device, 0, len(s.devices))
+	for id, device := range s.devices {
+		if time.Since(device.LastSeen) > deviceTTL && s.renderers[id] == nil {
+			delete(s.devices, id)
+			delete(s.sessions, id)
+			continue
+		}
+		listed := *device
+		listed.Casting = s.sessions[id]
+		devices = append(devices, listed)
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		if devices[i].Name != devices[j].Name {
+			return devices[i].Name < devices[j].Name
+		}
+		return devices[i].ID < devices[j].ID
+	})
+	return devices
+}
+
+// Device returns a device, scanning the network when it isn't known
+func (s *Service) Device(ctx context.Context, id string) (*Device, error) {
+	if device := s.known(id); device != nil {
+		return device, nil
+	}
+	s.scan(ctx)
+	if device := s.known(id); device != nil {
+		return device, nil
+	}
+	return nil, ErrNotFound
+}
+
+// known returns a copy of a device found by an earlier scan
+func (s *Service) known(id string) *Device {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	device, ok := s.devices[id]
+	if !ok {
+		return nil
+	}
+	found := *device
+	found.Casting = s.sessions[id]
+	return &found
+}
+
+// scan looks for devices with mDNS and SSDP at once. Devices that don't
+// answer are kept until deviceTTL, as multicast answers get lost.
+func (s *Service) scan(ctx context.Context) {
+	requested := time.Now()
+	s.scanMu.Lock()
+	defer s.scanMu.Unlock()
+
+	// A scan that ran while this one waited is as fresh
+	s.mu.Lock()
+	fresh := s.scanned.After(requested)
+	s.mu.Unlock()
+	if fresh {
+		return
+	}
+
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		found []*Device
+	)
+	discoverers := map[string]func(context.Context, time.Duration) ([]*Device, error){
+		KindChromecast: discoverChromecasts,
+		KindDLNA:       discoverDLNA,
+	}
+	for kind, discover := range discoverers {
+		wg.Add(1)
+		go func(kind string, discover func(context.Context, time.Duration) ([]*Device, error)) {
+			defer wg.Done()
+			devices, err := discover(ctx, DiscoveryTimeout)
+			if err != nil {
+				s.logger.Warn("cast discovery failed", "kind", kind, "error", err)
+			}
+			mu.Lock()
+			found = append(found, devices...)
+			mu.Unlock()
+		}(kind, discover)
+	}
+	wg.Wait()
+
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, device := range found {
+		device.LastSeen = now
+		s.devices[device.ID] = device
+	}
+	s.scanned = now
+	s.logger.Debug("cast devices scanned", "found", len(found))
+}
+
+// Cast plays media on a device for a user, replacing what the backend cast
+// to it before
+func (s *Service) Cast(ctx context.Context, id string, media Media, userID string) (*Status, error) {
+	device, err := s.Device(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := reachable(media.URL); err != nil {
+		return nil, err
+	}
+
+	r, err := s.renderer(ctx, device)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.Load(ctx, media); err != nil {
+		s.drop(id, r)
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.sessions[id] = &Session{
+		UserID:    userID,
+		Title:     media.Title,
+		Channel:   media.Channel,
+		Recording: media.Recording,
+		StartedAt: time.Now(),
+	}
+	s.mu.Unlock()
+
+	s.logger.Info("casting", "device_id", id, "kind", device.Kind, "title", media.Title, "user_id", userID)
+	return r.Status(ctx)
+}
+
+// Status returns the playback state of a device
+func (s *Service) Status(ctx context.Context, id string) (*Status, error) {
+	return s.control(ctx, id, func(r renderer) error { return nil })
+}
+
+// Pause pauses playback on a device
+func (s *Service) Pause(ctx context.Context, id string) (*Status, error) {
+	return s.control(ctx, id, func(r renderer) error { return r.Pause(ctx) })
+}
+
+// Resume resumes paused playback on a device
+func (s *Service) Resume(ctx context.Context, id string) (*Status, error) {
+	return s.control(ctx, id, func(r renderer) error { return r.Play(ctx) })
+}
+
+// Stop stops playback on a device and ends its session
+func (s *Service) Stop(ctx context.Context, id string) (*Status, error) {
+	status, err := s.control(ctx, id, func(r renderer) error { return r.Stop(ctx) })
+	if err == nil {
+		s.mu.Lock()
+		delete(s.sessions, id)
+		s.mu.Unlock()
+	}
+	return status, err
+}
+
+// SetVolume sets the volume of a device, from 0 to 1
+func (s *Service) SetVolume(ctx context.Context, id string, level float64) (*Status, error) {
+	level = min(max(level, 0), 1)
+	return s.control(ctx, id, func(r renderer) error { return r.SetVolume(ctx, level) })
+}
+
+// control runs an action on a device and returns its state after it. The
+// device needn't have been cast to from the backend: what another sender
+// plays on it may be controlled too.
+func (s *Service) control(ctx context.Context, id string, action func(r renderer) error) (*Status, error) {
+	device, err := s.Device(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	r, err := s.renderer(ctx, device)
+	if err != nil {
+		return nil, err
+	}
+	if err := action(r); err != nil {
+		if !r.Alive() {
+			s.drop(id, r)
+		}
+		return nil, err
+	}
+	return r.Status(ctx)
+}
+
+// renderer returns the open connection to a device, or opens one
+func (s *Service) renderer(ctx context.Context, device *Device) (renderer, error) {
+	s.mu.Lock()
+	r := s.renderers[device.ID]
+	s.mu.Unlock()
+	if r != nil && r.Alive() {
+		return r, nil
+	}
+	if r != nil {
+		s.drop(device.ID, r)
+	}
+
+	var err error
+	switch device.Kind {
+	case KindChromecast:
+		r, err = dialChromecast(ctx, device.Address)
+	default:
+		r = &dlnaRenderer{avTransport: device.avTransport, rendering: device.rendering}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if current := s.renderers[device.ID]; current != nil && current.Alive() {
+		// Another request connected meanwhile
+		r.Close()
+		return current, nil
+	}
+	s.renderers[device.ID] = r
+	return r, nil
+}
+
+// drop closes the connection to a device, unless it was replaced already
+func (s *Service) drop(id string, r renderer) {
+	s.mu.Lock()
+	if s.renderers[id] == r {
+		delete(s.renderers, id)
+	}
+	s.mu.Unlock()
+	r.Close()
+}
+
+// Close closes the connections to devices; what they play keeps playing
+func (s *Service) Close() {
+	s.mu.Lock()
+	renderers := s.renderers
+	s.renderers = make(map[string]renderer)
+	s.mu.Unlock()
+
+	for _, r := range renderers {
+		r.Close()
+	}
+}
+
+// ContentType returns the MIME type of what a playback decision points at,
+// which renderers need to pick a player
+func ContentType(decision stream.PlaybackDecision) string {
+	if decision.Transcode != nil {
+		if decision.Transcode.Format == "mp4" {
+			return "video/mp4"
+		}
+		return "video/mp2t"
+	}
+
+	container := ""
+	if decision.Media != nil {
+		container = decision.Media.Container
+	} else if u, err := url.Parse(decision.URL); err == nil {
+		// Not probed, guess from the file name
+		switch path.Ext(u.Path) {
+		case ".m3u8":
+			container = "hls"
+		case ".mp4", ".m4v":
+			container = "mp4"
+		case ".mkv":
+			container = "mkv"
+		}
+	}
+
+	switch container {
+	case "hls":
+		return "application/x-mpegurl"
+	case "mp4":
+		return "video/mp4"
+	case "mkv":
+		return "video/x-matroska"
+	}
+	return "video/mp2t"
+}
+
+// reachable refuses media URLs a device can't fetch: relative ones, and
+// those of the loopback address, which the backend gets when it is browsed
+// on the machine it runs on
+func reachable(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || !u.IsAbs() {
+		return ErrUnreachable
+	}
+	host := u.Hostname()
+	if ip := net.ParseIP(host); host == "localhost" || ip != nil && ip.IsLoopback() {
+		return ErrUnreachable
+	}
+	return nil
+}
+
+// deviceID derives a short, stable id from the id a device announces
+func deviceID(kind, id string) string {
+	sum := sha256.Sum256([]byte(kind + "|" + id))
+	return hex.EncodeToString(sum[:6])
+}
@@ -0,0 +1,705 @@
+package cast
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Chromecasts speak CASTV2: protobuf CastMessages carrying JSON payloads,
+// each preceded by its big endian length, over TLS on port 8009. Senders
+// connect to the receiver, launch the default media receiver app, connect
+// to the app's transport and load media into it.
+const (
+	namespaceConnection = "urn:x-cast:com.google.cast.tp.connection"
+	namespaceHeartbeat  = "urn:x-cast:com.google.cast.tp.heartbeat"
+	namespaceReceiver   = "urn:x-cast:com.google.cast.receiver"
+	namespaceMedia      = "urn:x-cast:com.google.cast.media"
+
+	defaultMediaReceiver = "CC1AD845" // App playing media URLs
+	senderID             = "sender-0"
+	receiverID           = "receiver-0"
+	chromecastPort       = 8009
+)
+
+const (
+	castRequestTimeout = 10 * time.Second
+	castHeartbeat      = 5 * time.Second
+	maxCastMessage     = 64 << 10
+)
+
+// mdnsAddr is where mDNS queries are multicast
+var mdnsAddr = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// chromecastService is the mDNS service Chromecasts announce
+const chromecastService = "_googlecast._tcp.local."
+
+var errCastClosed = errors.New("connection to the chromecast closed")
+
+// discoverChromecasts multicasts an mDNS query for Chromecasts from an
+// ephemeral port, which they answer directly (legacy unicast, RFC 6762),
+// and reads answers for wait
+func discoverChromecasts(ctx context.Context, wait time.Duration) ([]*Device, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	query := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: uint16(rand.Intn(1 << 16))},
+		Questions: []dnsmessage.Question{{
+			Name:  dnsmessage.MustNewName(chromecastService),
+			Type:  dnsmessage.TypePTR,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	packet, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.WriteTo(packet, mdnsAddr); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(wait)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetReadDeadline(deadline)
+
+	answers := newMDNSAnswers()
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			break
+		}
+		answers.add(buf[:n], from.IP)
+	}
+	return answers.devices(), nil
+}
+
+// mdnsInstance is a Chromecast as announced over mDNS
+type mdnsInstance struct {
+	target string // Host name
+	port   int
+	txt    map[string]string
+	from   net.IP
+}
+
+// mdnsAnswers gathers the records of mDNS answers. Devices send their
+// SRV, TXT and A records along with the PTR one.
+type mdnsAnswers struct {
+	instances map[string]*mdnsInstance
+	hosts     map[string]net.IP
+}
+
+func newMDNSAnswers() *mdnsAnswers {
+	return &mdnsAnswers{instances: make(map[string]*mdnsInstance), hosts: make(map[string]net.IP)}
+}
+
+func (a *mdnsAnswers) instance(name string, from net.IP) *mdnsInstance {
+	instance := a.instances[name]
+	if instance == nil {
+		instance = &mdnsInstance{txt: make(map[string]string), from: from}
+		a.instances[name] = instance
+	}
+	return instance
+}
+
+// add reads the records of a packet, ignoring packets that don't parse
+func (a *mdnsAnswers) add(packet []byte, from net.IP) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(packet); err != nil || !msg.Response {
+		return
+	}
+
+	records := append(append(msg.Answers, msg.Authorities...), msg.Additionals...)
+	for _, record := range records {
+		name := strings.ToLower(record.Header.Name.String())
+		switch body := record.Body.(type) {
+		case *dnsmessage.PTRResource:
+			if name == chromecastService {
+				a.instance(strings.ToLower(body.PTR.String()), from)
+			}
+		case *dnsmessage.SRVResource:
+			if strings.HasSuffix(name, "."+chromecastService) {
+				instance := a.instance(name, from)
+				instance.target = strings.ToLower(body.Target.String())
+				instance.port = int(body.Port)
+			}
+		case *dnsmessage.TXTResource:
+			if strings.HasSuffix(name, "."+chromecastService) {
+				instance := a.instance(name, from)
+				for _, entry := range body.TXT {
+					if key, value, ok := strings.Cut(entry, "="); ok {
+						instance.txt[strings.ToLower(key)] = value
+					}
+				}
+			}
+		case *dnsmessage.AResource:
+			a.hosts[name] = net.IP(body.A[:])
+		}
+	}
+}
+
+// devices returns the instances found as devices, named by their friendly
+// name (fn) and identified by their id, both from their TXT record
+func (a *mdnsAnswers) devices() []*Device {
+	devices := make([]*Device, 0, len(a.instances))
+	for name, instance := range a.instances {
+		ip := a.hosts[instance.target]
+		if ip == nil {
+			ip = instance.from
+		}
+		port := instance.port
+		if port == 0 {
+			port = chromecastPort
+		}
+
+		id := instance.txt["id"]
+		if id == "" {
+			id = name
+		}
+		friendlyName := instance.txt["fn"]
+		if friendlyName == "" {
+			friendlyName = strings.TrimSuffix(name, "."+chromecastService)
+		}
+
+		devices = append(devices, &Device{
+			ID:      deviceID(KindChromecast, id),
+			Kind:    KindChromecast,
+			Name:    friendlyName,
+			Model:   instance.txt["md"],
+			Address: net.JoinHostPort(ip.String(), strconv.Itoa(port)),
+		})
+	}
+	return devices
+}
+
+// castPayload is the JSON payload of a CASTV2 message, with the fields of
+// replies read here
+type castPayload struct {
+	Type      string          `json:"type"`
+	RequestID int             `json:"requestId"`
+	Reason    string          `json:"reason"`
+	Status    json.RawMessage `json:"status"` // An object for the receiver, a list for media
+}
+
+type castVolume struct {
+	Level *float64 `json:"level"`
+	Muted *bool    `json:"muted"`
+}
+
+type castReceiverStatus struct {
+	Applications []struct {
+		AppID       string `json:"appId"`
+		TransportID string `json:"transportId"`
+	} `json:"applications"`
+	Volume castVolume `json:"volume"`
+}
+
+type castMediaStatus struct {
+	MediaSessionID int     `json:"mediaSessionId"`
+	PlayerState    string  `json:"playerState"` // IDLE, BUFFERING, PLAYING or PAUSED
+	CurrentTime    float64 `json:"currentTime"`
+}
+
+// chromecast is a CASTV2 connection to a Chromecast. A goroutine reads its
+// messages: it answers heartbeats, tracks the receiver and media status and
+// hands replies to the requests waiting for them.
+type chromecast struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+
+	mu           sync.Mutex
+	pending      map[int]chan castPayload
+	nextID       int
+	transportID  string // Of the running media receiver, empty when there is none
+	connectedTo  string // Transport the connection is attached to
+	mediaSession int
+	status       Status
+	err          error // Set once the connection is closed
+	done         chan struct{}
+}
+
+// dialChromecast connects to the Chromecast at address
+func dialChromecast(ctx context.Context, address string) (*chromecast, error) {
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: 5 * time.Second},
+		// Chromecasts present self-signed certificates
+		Config: &tls.Config{InsecureSkipVerify: true},
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the chromecast: %w", err)
+	}
+
+	c := &chromecast{
+		conn:    conn,
+		pending: make(map[int]chan castPayload),
+		status:  Status{State: StateIdle, Volume: -1},
+		done:    make(chan struct{}),
+	}
+	if err := c.send(receiverID, namespaceConnection, map[string]interface{}{"type": "CONNECT"}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go c.read()
+	go c.heartbeat()
+	return c, nil
+}
+
+// Load launches the default media receiver when it isn't running and loads
+// media into it, playing it at once
+func (c *chromecast) Load(ctx context.Context, media Media) error {
+	if err := c.attach(ctx, true); err != nil {
+		return err
+	}
+
+	streamType := "BUFFERED"
+	if media.Live {
+		streamType = "LIVE"
+	}
+	metadata := map[string]interface{}{"metadataType": 0, "title": media.Title}
+	if media.ImageURL != "" {
+		metadata["images"] = []map[string]string{{"url": media.ImageURL}}
+	}
+
+	_, err := c.request(ctx, c.transport(), namespaceMedia, map[string]interface{}{
+		"type": "LOAD",
+		"media": map[string]interface{}{
+			"contentId":   media.URL,
+			"contentType": media.ContentType,
+			"streamType":  streamType,
+			"metadata":    metadata,
+		},
+		"autoplay": true,
+	})
+	return err
+}
+
+func (c *chromecast) Play(ctx context.Context) error {
+	return c.mediaCommand(ctx, "PLAY")
+}
+
+func (c *chromecast) Pause(ctx context.Context) error {
+	return c.mediaCommand(ctx, "PAUSE")
+}
+
+func (c *chromecast) Stop(ctx context.Context) error {
+	return c.mediaCommand(ctx, "STOP")
+}
+
+// SetVolume sets the volume of the device rather than of the media
+func (c *chromecast) SetVolume(ctx context.Context, level float64) error {
+	_, err := c.request(ctx, receiverID, namespaceReceiver, map[string]interface{}{
+		"type":   "SET_VOLUME",
+		"volume": map[string]interface{}{"level": level},
+	})
+	return err
+}
+
+// Status asks the receiver, then the media receiver when it runs, for
+// their status
+func (c *chromecast) Status(ctx context.Context) (*Status, error) {
+	if _, err := c.request(ctx, receiverID, namespaceReceiver, map[string]interface{}{"type": "GET_STATUS"}); err != nil {
+		return nil, err
+	}
+	if c.transport() != "" {
+		if err := c.attach(ctx, false); err != nil {
+			return nil, err
+		}
+		if _, err := c.request(ctx, c.transport(), namespaceMedia, map[string]interface{}{"type": "GET_STATUS"}); err != nil {
+			return nil, err
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	status := c.status
+	return &status, nil
+}
+
+func (c *chromecast) Alive() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err == nil
+}
+
+func (c *chromecast) Close() error {
+	c.fail(errCastClosed)
+	return nil
+}
+
+// attach connects to the transport of the media receiver, launching it
+// when launch is set and it isn't running
+func (c *chromecast) attach(ctx context.Context, launch bool) error {
+	if c.transport() == "" {
+		if _, err := c.request(ctx, receiverID, namespaceReceiver, map[string]interface{}{"type": "GET_STATUS"}); err != nil {
+			return err
+		}
+	}
+	if c.transport() == "" {
+		if !launch {
+			return ErrNotCasting
+		}
+		if _, err := c.request(ctx, receiverID, namespaceReceiver, map[string]interface{}{
+			"type":  "LAUNCH",
+			"appId": defaultMediaReceiver,
+		}); err != nil {
+			return err
+		}
+		if c.transport() == "" {
+			return errors.New("the chromecast didn't start its media receiver")
+		}
+	}
+
+	c.mu.Lock()
+	transportID, connected := c.transportID, c.connectedTo == c.transportID
+	c.mu.Unlock()
+	if connected {
+		return nil
+	}
+	if err := c.send(transportID, namespaceConnection, map[string]interface{}{"type": "CONNECT"}); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.connectedTo = transportID
+	c.mu.Unlock()
+	return nil
+}
+
+// mediaCommand sends a command to the media session of the media receiver,
+// whichever sender started it
+func (c *chromecast) mediaCommand(ctx context.Context, command string) error {
+	if err := c.attach(ctx, false); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	session := c.mediaSession
+	c.mu.Unlock()
+	if session == 0 {
+		if _, err := c.request(ctx, c.transport(), namespaceMedia, map[string]interface{}{"type": "GET_STATUS"}); err != nil {
+			return err
+		}
+		c.mu.Lock()
+		session = c.mediaSession
+		c.mu.Unlock()
+	}
+	if session == 0 {
+		return ErrNotCasting
+	}
+
+	_, err := c.request(ctx, c.transport(), namespaceMedia, map[string]interface{}{
+		"type":           command,
+		"mediaSessionId": session,
+	})
+	return err
+}
+
+func (c *chromecast) transport() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.transportID
+}
+
+// request sends a message and waits for its reply
+func (c *chromecast) request(ctx context.Context, destination, namespace string, payload map[string]interface{}) (castPayload, error) {
+	ctx, cancel := context.WithTimeout(ctx, castRequestTimeout)
+	defer cancel()
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return castPayload{}, c.err
+	}
+	c.nextID++
+	id := c.nextID
+	reply := make(chan castPayload, 1)
+	c.pending[id] = reply
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	payload["requestId"] = id
+	if err := c.send(destination, namespace, payload); err != nil {
+		return castPayload{}, err
+	}
+
+	select {
+	case answer := <-reply:
+		switch answer.Type {
+		case "LOAD_FAILED", "LOAD_CANCELLED", "INVALID_REQUEST", "INVALID_PLAYER_STATE", "INVALID_MEDIA_SESSION_ID", "LAUNCH_ERROR":
+			message := "the chromecast refused the request: " + answer.Type
+			if answer.Reason != "" {
+				message += " (" + answer.Reason + ")"
+			}
+			return answer, errors.New(message)
+		}
+		return answer, nil
+	case <-c.done:
+		return castPayload{}, c.closeErr()
+	case <-ctx.Done():
+		return castPayload{}, fmt.Errorf("the chromecast didn't answer: %w", ctx.Err())
+	}
+}
+
+// send writes a message to the device
+func (c *chromecast) send(destination, namespace string, payload map[string]interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	message := castMessage{Source: senderID, Destination: destination, Namespace: namespace, Payload: string(data)}.marshal()
+
+	frame := make([]byte, 4, 4+len(message))
+	binary.BigEndian.PutUint32(frame, uint32(len(message)))
+	frame = append(frame, message...)
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(castRequestTimeout))
+	if _, err := c.conn.Write(frame); err != nil {
+		c.fail(err)
+		return err
+	}
+	return nil
+}
+
+// read handles the messages of the device until the connection closes
+func (c *chromecast) read() {
+	header := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(c.conn, header); err != nil {
+			c.fail(err)
+			return
+		}
+		size := binary.BigEndian.Uint32(header)
+		if size > maxCastMessage {
+			c.fail(fmt.Errorf("chromecast message of %d bytes", size))
+			return
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(c.conn, data); err != nil {
+			c.fail(err)
+			return
+		}
+
+		message, err := unmarshalCastMessage(data)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		var payload castPayload
+		if err := json.Unmarshal([]byte(message.Payload), &payload); err != nil {
+			continue
+		}
+		c.handle(message, payload)
+	}
+}
+
+// handle applies a message of the device
+func (c *chromecast) handle(message castMessage, payload castPayload) {
+	switch {
+	case message.Namespace == namespaceHeartbeat && payload.Type == "PING":
+		c.send(message.Source, namespaceHeartbeat, map[string]interface{}{"type": "PONG"})
+		return
+	case message.Namespace == namespaceConnection && payload.Type == "CLOSE":
+		if message.Source == receiverID {
+			c.fail(errCastClosed)
+			return
+		}
+		// The media receiver stopped
+		c.mu.Lock()
+		if message.Source == c.transportID {
+			c.transportID, c.connectedTo, c.mediaSession = "", "", 0
+			c.status.State = StateIdle
+		}
+		c.mu.Unlock()
+	case payload.Type == "RECEIVER_STATUS":
+		var status castReceiverStatus
+		if json.Unmarshal(payload.Status, &status) == nil {
+			c.mu.Lock()
+			c.transportID = ""
+			for _, app := range status.Applications {
+				if app.AppID == defaultMediaReceiver {
+					c.transportID = app.TransportID
+				}
+			}
+			if c.transportID == "" {
+				c.connectedTo, c.mediaSession = "", 0
+				c.status.State, c.status.Position = StateIdle, 0
+			}
+			if status.Volume.Level != nil {
+				c.status.Volume = *status.Volume.Level
+			}
+			if status.Volume.Muted != nil {
+				c.status.Muted = *status.Volume.Muted
+			}
+			c.mu.Unlock()
+		}
+	case payload.Type == "MEDIA_STATUS":
+		var statuses []castMediaStatus
+		if json.Unmarshal(payload.Status, &statuses) == nil {
+			c.mu.Lock()
+			if len(statuses) == 0 {
+				c.mediaSession = 0
+				c.status.State, c.status.Position = StateIdle, 0
+			} else {
+				c.mediaSession = statuses[0].MediaSessionID
+				c.status.State = strings.ToLower(statuses[0].PlayerState)
+				c.status.Position = statuses[0].CurrentTime
+			}
+			c.mu.Unlock()
+		}
+	}
+
+	if payload.RequestID != 0 {
+		c.mu.Lock()
+		reply := c.pending[payload.RequestID]
+		c.mu.Unlock()
+		if reply != nil {
+			select {
+			case reply <- payload:
+			default:
+			}
+		}
+	}
+}
+
+// heartbeat pings the device, which closes silent connections
+func (c *chromecast) heartbeat() {
+	ticker := time.NewTicker(castHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.send(receiverID, namespaceHeartbeat, map[string]interface{}{"type": "PING"})
+		}
+	}
+}
+
+// fail closes the connection once, with the error requests get from then on
+func (c *chromecast) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	close(c.done)
+	c.conn.Close()
+}
+
+func (c *chromecast) closeErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// castMessage is a CASTV2 CastMessage with a string payload
+type castMessage struct {
+	Source      string
+	Destination string
+	Namespace   string
+	Payload     string
+}
+
+// marshal encodes the message as protobuf: protocol_version (1) and
+// payload_type (5) are 0 for CASTV2_1_0 and STRING, and required
+func (m castMessage) marshal() []byte {
+	var b []byte
+	b = appendVarintField(b, 1, 0)
+	b = appendStringField(b, 2, m.Source)
+	b = appendStringField(b, 3, m.Destination)
+	b = appendStringField(b, 4, m.Namespace)
+	b = appendVarintField(b, 5, 0)
+	b = appendStringField(b, 6, m.Payload)
+	return b
+}
+
+// unmarshalCastMessage decodes a protobuf CastMessage, skipping binary
+// payloads and unknown fields
+func unmarshalCastMessage(data []byte) (castMessage, error) {
+	var m castMessage
+	malformed := errors.New("malformed chromecast message")
+
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return m, malformed
+		}
+		data = data[n:]
+
+		switch key & 7 {
+		case 0: // Varint
+			if _, n = binary.Uvarint(data); n <= 0 {
+				return m, malformed
+			}
+			data = data[n:]
+		case 1: // 64-bit
+			if len(data) < 8 {
+				return m, malformed
+			}
+			data = data[8:]
+		case 5: // 32-bit
+			if len(data) < 4 {
+				return m, malformed
+			}
+			data = data[4:]
+		case 2: // Length-delimited
+			length, n := binary.Uvarint(data)
+			if n <= 0 || length > uint64(len(data)-n) {
+				return m, malformed
+			}
+			value := string(data[n : n+int(length)])
+			data = data[n+int(length):]
+
+			switch key >> 3 {
+			case 2:
+				m.Source = value
+			case 3:
+				m.Destination = value
+			case 4:
+				m.Namespace = value
+			case 6:
+				m.Payload = value
+			}
+		default:
+			return m, malformed
+		}
+	}
+	return m, nil
+}
+
+func appendVarintField(b []byte, field int, value uint64) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3)
+	return binary.AppendUvarint(b, value)
+}
+
+func appendStringField(b []byte, field int, value string) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
@@ -0,0 +1,406 @@
+package cast
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DLNA renderers are UPnP MediaRenderer devices: SSDP finds them, their
+// description lists the control URLs of their AVTransport and
+// RenderingControl services, and SOAP actions on those play media URLs and
+// set the volume.
+const (
+	mediaRenderer      = "urn:schemas-upnp-org:device:MediaRenderer:1"
+	avTransportType    = "urn:schemas-upnp-org:service:AVTransport:"
+	renderingType      = "urn:schemas-upnp-org:service:RenderingControl:"
+	maxDescriptionSize = 1 << 20
+	dlnaRequestTimeout = 10 * time.Second
+)
+
+// ssdpAddr is where SSDP searches are multicast
+var ssdpAddr = &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+
+// upnpService is a service of a UPnP device
+type upnpService struct {
+	Type       string `xml:"serviceType"`
+	ControlURL string `xml:"controlURL"`
+}
+
+// upnpDevice is a device of a UPnP description, with its embedded devices
+type upnpDevice struct {
+	FriendlyName string        `xml:"friendlyName"`
+	ModelName    string        `xml:"modelName"`
+	UDN          string        `xml:"UDN"`
+	Services     []upnpService `xml:"serviceList>service"`
+	Devices      []upnpDevice  `xml:"deviceList>device"`
+}
+
+type upnpDescription struct {
+	URLBase string     `xml:"URLBase"`
+	Device  upnpDevice `xml:"device"`
+}
+
+// discoverDLNA multicasts an SSDP search for media renderers, reads answers
+// for wait and reads the description of each renderer found
+func discoverDLNA(ctx context.Context, wait time.Duration) ([]*Device, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	search := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: 239.255.255.250:1900\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n" +
+		"ST: " + mediaRenderer + "\r\n\r\n"
+	// Sent twice as UDP datagrams get lost
+	for i := 0; i < 2; i++ {
+		if _, err := conn.WriteTo([]byte(search), ssdpAddr); err != nil {
+			return nil, err
+		}
+	}
+
+	deadline := time.Now().Add(wait)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetReadDeadline(deadline)
+
+	locations := make(map[string]bool)
+	buf := make([]byte, 4096)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			break
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+
+		// Descriptions are only read from the device that answered, so an
+		// answer can't make the backend fetch other URLs
+		location, err := url.Parse(resp.Header.Get("Location"))
+		if err != nil || location.Scheme != "http" || !net.ParseIP(location.Hostname()).Equal(from.IP) {
+			continue
+		}
+		locations[location.String()] = true
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		devices []*Device
+	)
+	for location := range locations {
+		wg.Add(1)
+		go func(location string) {
+			defer wg.Done()
+			device, err := describeRenderer(ctx, location)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			devices = append(devices, device)
+			mu.Unlock()
+		}(location)
+	}
+	wg.Wait()
+	return devices, nil
+}
+
+// describeRenderer reads the description of a renderer
+func describeRenderer(ctx context.Context, location string) (*Device, error) {
+	ctx, cancel := context.WithTimeout(ctx, DiscoveryTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", location, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("description returned status %d", resp.StatusCode)
+	}
+
+	var description upnpDescription
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, maxDescriptionSize)).Decode(&description); err != nil {
+		return nil, err
+	}
+
+	renderer := findRenderer(&description.Device)
+	if renderer == nil {
+		return nil, errors.New("no AVTransport service")
+	}
+
+	base, _ := url.Parse(location)
+	if description.URLBase != "" {
+		if parsed, err := url.Parse(description.URLBase); err == nil {
+			base = parsed
+		}
+	}
+	resolve := func(service upnpService) upnpService {
+		if service.ControlURL == "" {
+			return upnpService{}
+		}
+		control, err := base.Parse(service.ControlURL)
+		if err != nil {
+			return upnpService{}
+		}
+		service.ControlURL = control.String()
+		return service
+	}
+
+	device := &Device{
+		ID:      deviceID(KindDLNA, renderer.UDN),
+		Kind:    KindDLNA,
+		Name:    strings.TrimSpace(renderer.FriendlyName),
+		Model:   strings.TrimSpace(renderer.ModelName),
+		Address: base.Host,
+	}
+	for _, service := range renderer.Services {
+		switch {
+		case strings.HasPrefix(service.Type, avTransportType):
+			device.avTransport = resolve(service)
+		case strings.HasPrefix(service.Type, renderingType):
+			device.rendering = resolve(service)
+		}
+	}
+	if device.avTransport.ControlURL == "" {
+		return nil, errors.New("no AVTransport control URL")
+	}
+	if renderer.UDN == "" {
+		device.ID = deviceID(KindDLNA, location)
+	}
+	if device.Name == "" {
+		device.Name = device.Address
+	}
+	return device, nil
+}
+
+// findRenderer returns the device, or embedded device, with an AVTransport
+// service
+func findRenderer(device *upnpDevice) *upnpDevice {
+	for _, service := range device.Services {
+		if strings.HasPrefix(service.Type, avTransportType) {
+			return device
+		}
+	}
+	for i := range device.Devices {
+		if found := findRenderer(&device.Devices[i]); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// dlnaRenderer controls a DLNA renderer through SOAP. It keeps no
+// connection: every action is an HTTP request.
+type dlnaRenderer struct {
+	avTransport upnpService
+	rendering   upnpService
+}
+
+// soapArg is an argument of a SOAP action; UPnP wants them in order
+type soapArg struct {
+	name  string
+	value string
+}
+
+// Load sets the media URL with DIDL-Lite metadata, which many TVs need to
+// accept it, and plays it
+func (r *dlnaRenderer) Load(ctx context.Context, media Media) error {
+	class := "object.item.videoItem"
+	if media.Live {
+		class = "object.item.videoItem.videoBroadcast"
+	}
+	var metadata strings.Builder
+	metadata.WriteString(`<DIDL-Lite xmlns="urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:upnp="urn:schemas-upnp-org:metadata-1-0/upnp/">`)
+	metadata.WriteString(`<item id="0" parentID="-1" restricted="1"><dc:title>`)
+	xml.EscapeText(&metadata, []byte(media.Title))
+	metadata.WriteString(`</dc:title><upnp:class>` + class + `</upnp:class>`)
+	metadata.WriteString(`<res protocolInfo="http-get:*:` + media.ContentType + `:*">`)
+	xml.EscapeText(&metadata, []byte(media.URL))
+	metadata.WriteString(`</res></item></DIDL-Lite>`)
+
+	if _, err := soap(ctx, r.avTransport, "SetAVTransportURI", []soapArg{
+		{"InstanceID", "0"},
+		{"CurrentURI", media.URL},
+		{"CurrentURIMetaData", metadata.String()},
+	}); err != nil {
+		return err
+	}
+	return r.Play(ctx)
+}
+
+func (r *dlnaRenderer) Play(ctx context.Context) error {
+	_, err := soap(ctx, r.avTransport, "Play", []soapArg{{"InstanceID", "0"}, {"Speed", "1"}})
+	return err
+}
+
+func (r *dlnaRenderer) Pause(ctx context.Context) error {
+	_, err := soap(ctx, r.avTransport, "Pause", []soapArg{{"InstanceID", "0"}})
+	return err
+}
+
+func (r *dlnaRenderer) Stop(ctx context.Context) error {
+	_, err := soap(ctx, r.avTransport, "Stop", []soapArg{{"InstanceID", "0"}})
+	return err
+}
+
+// SetVolume sets the master volume, from 0 to 100 for UPnP
+func (r *dlnaRenderer) SetVolume(ctx context.Context, level float64) error {
+	if r.rendering.ControlURL == "" {
+		return errors.New("the device has no volume control")
+	}
+	_, err := soap(ctx, r.rendering, "SetVolume", []soapArg{
+		{"InstanceID", "0"},
+		{"Channel", "Master"},
+		{"DesiredVolume", strconv.Itoa(int(math.Round(level * 100)))},
+	})
+	return err
+}
+
+// Status reads the transport state, the position and the volume. The
+// volume is -1 when the device doesn't report it.
+func (r *dlnaRenderer) Status(ctx context.Context) (*Status, error) {
+	info, err := soap(ctx, r.avTransport, "GetTransportInfo", []soapArg{{"InstanceID", "0"}})
+	if err != nil {
+		return nil, err
+	}
+
+	status := &Status{State: StateIdle, Volume: -1}
+	switch info["CurrentTransportState"] {
+	case "PLAYING":
+		status.State = StatePlaying
+	case "PAUSED_PLAYBACK":
+		status.State = StatePaused
+	case "TRANSITIONING":
+		status.State = StateBuffering
+	}
+
+	if position, err := soap(ctx, r.avTransport, "GetPositionInfo", []soapArg{{"InstanceID", "0"}}); err == nil {
+		status.Position = parseDuration(position["RelTime"])
+	}
+	if r.rendering.ControlURL != "" {
+		volume, err := soap(ctx, r.rendering, "GetVolume", []soapArg{{"InstanceID", "0"}, {"Channel", "Master"}})
+		if level, parseErr := strconv.Atoi(volume["CurrentVolume"]); err == nil && parseErr == nil {
+			status.Volume = float64(level) / 100
+		}
+	}
+	return status, nil
+}
+
+func (r *dlnaRenderer) Alive() bool {
+	return true
+}
+
+func (r *dlnaRenderer) Close() error {
+	return nil
+}
+
+// soap calls an action of a UPnP service and returns the values of its
+// response by element name. Faults are returned with their description.
+func soap(ctx context.Context, service upnpService, action string, args []soapArg) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, dlnaRequestTimeout)
+	defer cancel()
+
+	var body strings.Builder
+	body.WriteString(`<?xml version="1.0" encoding="utf-8"?>`)
+	body.WriteString(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`)
+	body.WriteString(`<u:` + action + ` xmlns:u="`)
+	xml.EscapeText(&body, []byte(service.Type))
+	body.WriteString(`">`)
+	for _, arg := range args {
+		body.WriteString("<" + arg.name + ">")
+		xml.EscapeText(&body, []byte(arg.value))
+		body.WriteString("</" + arg.name + ">")
+	}
+	body.WriteString(`</u:` + action + `></s:Body></s:Envelope>`)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", service.ControlURL, strings.NewReader(body.String()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+service.Type+"#"+action+`"`)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("the device didn't answer %s: %w", action, err)
+	}
+	defer resp.Body.Close()
+
+	values := soapValues(io.LimitReader(resp.Body, maxDescriptionSize))
+	if resp.StatusCode != http.StatusOK {
+		reason := values["errorDescription"]
+		if reason == "" {
+			reason = resp.Status
+		}
+		return nil, fmt.Errorf("the device refused %s: %s", action, reason)
+	}
+	return values, nil
+}
+
+// soapValues reads the text of the elements of a SOAP response, by local
+// name. Responses that don't parse give the values read until then.
+func soapValues(r io.Reader) map[string]string {
+	values := make(map[string]string)
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+
+	name := ""
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return values
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			name = token.Name.Local
+		case xml.CharData:
+			if name != "" {
+				values[name] += string(token)
+			}
+		case xml.EndElement:
+			name = ""
+		}
+	}
+}
+
+// parseDuration parses UPnP durations, "H+:MM:SS[.F+]", in seconds. It
+// returns 0 when value is invalid or NOT_IMPLEMENTED.
+func parseDuration(value string) float64 {
+	parts := strings.Split(strings.TrimSpace(value), ":")
+	if len(parts) != 3 {
+		return 0
+	}
+	seconds := 0.0
+	for _, part := range parts {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil || n < 0 {
+			return 0
+		}
+		seconds = seconds*60 + n
+	}
+	return seconds
+}
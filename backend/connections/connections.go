@@ -0,0 +1,202 @@
+// Package connections counts the connections held to each provider by
+// recordings and viewers against the max_connections of its playlist, and
+// finds scheduled recordings that would exceed it.
+package connections
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+)
+
+// LimitField is the playlist field holding the connections its provider
+// allows at once, 0 for no limit
+const LimitField = "max_connections"
+
+var ErrLimitReached = errors.New("provider connection limit reached")
+
+// LimitError is returned when a playlist's provider has no connection left.
+// It wraps ErrLimitReached.
+type LimitError struct {
+	Usage
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("the provider of %q is at its limit of %d connections (%d recordings, %d viewers)",
+		e.Name, e.Limit, e.Recordings, e.Viewers)
+}
+
+func (e *LimitError) Unwrap() error {
+	return ErrLimitReached
+}
+
+// Usage is the connections in use of a playlist with a limit
+type Usage struct {
+	PlaylistID string `json:"playlist_id"`
+	Name       string `json:"name"`
+	Limit      int    `json:"max_connections"`
+	Recordings int    `json:"recordings"`
+	Viewers    int    `json:"viewers"`
+}
+
+// InUse returns the connections held now
+func (u Usage) InUse() int {
+	return u.Recordings + u.Viewers
+}
+
+// Config tells the service what holds connections
+type Config struct {
+	// Recordings returns the source URL of each running recording
+	Recordings func() []string
+	// Viewers returns the source channel of each viewing session
+	Viewers func() []string
+}
+
+// Service counts connections per playlist
+type Service struct {
+	app    core.App
+	config Config
+}
+
+// NewService creates a connection counting service
+func NewService(app core.App, config Config) *Service {
+	return &Service{app: app, config: config}
+}
+
+// limited returns the playlists with a connection limit by ID
+func (s *Service) limited() (map[string]*models.Record, error) {
+	records, err := s.app.Dao().FindRecordsByFilter("playlists", LimitField+" > 0", "", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	playlists := make(map[string]*models.Record, len(records))
+	for _, record := range records {
+		playlists[record.Id] = record
+	}
+	return playlists, nil
+}
+
+// urlPlaylists returns the playlists listing a stream URL. Channels sharing
+// a URL share the provider account it carries, so each of their playlists
+// counts the connection.
+func (s *Service) urlPlaylists(streamURL string) []string {
+	channels, err := s.app.Dao().FindRecordsByFilter("channels", "url = {:url}", "", 0, 0,
+		dbx.Params{"url": streamURL})
+	if err != nil {
+		return nil
+	}
+	var playlists []string
+	for _, channel := range channels {
+		// Relation fields may be stored as arrays
+		playlists = append(playlists, channel.GetStringSlice("playlist")...)
+	}
+	return playlists
+}
+
+// channelPlaylists returns the playlists of a channel
+func (s *Service) channelPlaylists(channelID string) []string {
+	channel, err := s.app.Dao().FindRecordById("channels", channelID)
+	if err != nil {
+		return nil
+	}
+	return channel.GetStringSlice("playlist")
+}
+
+// usage counts the connections of the limited playlists
+func (s *Service) usage(playlists map[string]*models.Record) map[string]*Usage {
+	usage := make(map[string]*Usage, len(playlists))
+	if len(playlists) == 0 {
+		return usage
+	}
+	for id, playlist := range playlists {
+		usage[id] = &Usage{
+			PlaylistID: id,
+			Name:       playlist.GetString("name"),
+			Limit:      playlist.GetInt(LimitField),
+		}
+	}
+
+	// A connection counts once per playlist even if several of its channels
+	// share the URL
+	count := func(playlistIDs []string, add func(*Usage)) {
+		seen := make(map[string]bool, len(playlistIDs))
+		for _, id := range playlistIDs {
+			if u, ok := usage[id]; ok && !seen[id] {
+				seen[id] = true
+				add(u)
+			}
+		}
+	}
+
+	if s.config.Recordings != nil {
+		urlCache := make(map[string][]string)
+		for _, sourceURL := range s.config.Recordings() {
+			ids, ok := urlCache[sourceURL]
+			if !ok {
+				ids = s.urlPlaylists(sourceURL)
+				urlCache[sourceURL] = ids
+			}
+			count(ids, func(u *Usage) { u.Recordings++ })
+		}
+	}
+	if s.config.Viewers != nil {
+		channelCache := make(map[string][]string)
+		for _, sourceID := range s.config.Viewers() {
+			ids, ok := channelCache[sourceID]
+			if !ok {
+				ids = s.channelPlaylists(sourceID)
+				channelCache[sourceID] = ids
+			}
+			count(ids, func(u *Usage) { u.Viewers++ })
+		}
+	}
+
+	return usage
+}
+
+// Usage returns the connections in use of the given playlists that have a
+// limit
+func (s *Service) Usage(playlistIDs []string) ([]Usage, error) {
+	playlists, err := s.limited()
+	if err != nil {
+		return nil, err
+	}
+	usage := s.usage(playlists)
+
+	list := make([]Usage, 0, len(playlistIDs))
+	for _, id := range playlistIDs {
+		if u, ok := usage[id]; ok {
+			list = append(list, *u)
+		}
+	}
+	return list, nil
+}
+
+// CheckRecording returns a *LimitError when recording sourceURL would take
+// one connection more than a playlist listing it allows
+func (s *Service) CheckRecording(sourceURL string) error {
+	playlists, err := s.limited()
+	if err != nil || len(playlists) == 0 {
+		return err
+	}
+
+	candidates := make(map[string]*models.Record)
+	for _, id := range s.urlPlaylists(sourceURL) {
+		if playlist, ok := playlists[id]; ok {
+			candidates[id] = playlist
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	for _, u := range s.usage(candidates) {
+		if u.InUse() >= u.Limit {
+			return &LimitError{Usage: *u}
+		}
+	}
+	return nil
+}
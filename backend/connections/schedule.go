@@ -0,0 +1,241 @@
+package connections
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// maxScheduled bounds the upcoming recordings checked for conflicts
+const maxScheduled = 5000
+
+// Schedule is a recording to check before scheduling it
+type Schedule struct {
+	ChannelID string
+	Start     time.Time
+	End       time.Time
+}
+
+// ScheduledRecording is a recording of a conflict, without an ID for the
+// schedule being checked
+type ScheduledRecording struct {
+	ID        string    `json:"id,omitempty"`
+	Title     string    `json:"title"`
+	ChannelID string    `json:"channel_id"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+}
+
+// Conflict is a time range where more recordings are scheduled on channels
+// of a playlist than its provider allows connections. Recordings of other
+// users are only counted.
+type Conflict struct {
+	PlaylistID string               `json:"playlist_id"`
+	Playlist   string               `json:"playlist"`
+	Limit      int                  `json:"max_connections"`
+	Start      time.Time            `json:"start"`
+	End        time.Time            `json:"end"`
+	Recordings []ScheduledRecording `json:"recordings"`
+	Others     int                  `json:"others"`
+}
+
+type interval struct {
+	recording ScheduledRecording
+	own       bool
+}
+
+// ScheduleConflicts returns the conflicts between the upcoming recordings
+// on channels of the user's playlists. With a schedule, only the conflicts
+// it would take part in are returned. Viewers aren't known ahead of time and
+// aren't counted.
+func (s *Service) ScheduleConflicts(userID string, schedule *Schedule) ([]Conflict, error) {
+	dao := s.app.Dao()
+
+	playlists, err := dao.FindRecordsByFilter("playlists", "user ~ {:user} && "+LimitField+" > 0", "", 0, 0,
+		dbx.Params{"user": userID})
+	if err != nil {
+		return nil, err
+	}
+	if len(playlists) == 0 {
+		return []Conflict{}, nil
+	}
+	limited := make(map[string]*models.Record, len(playlists))
+	for _, playlist := range playlists {
+		limited[playlist.Id] = playlist
+	}
+
+	channelCache := make(map[string][]string)
+	playlistsOf := func(channelID string) []string {
+		ids, ok := channelCache[channelID]
+		if !ok {
+			for _, id := range s.channelPlaylists(channelID) {
+				if limited[id] != nil {
+					ids = append(ids, id)
+				}
+			}
+			channelCache[channelID] = ids
+		}
+		return ids
+	}
+
+	now, _ := types.ParseDateTime(time.Now())
+	recordings, err := dao.FindRecordsByFilter("recordings",
+		"(status = 'scheduled' || status = 'recording') && scheduled_end > {:now}",
+		"scheduled_start", maxScheduled, 0, dbx.Params{"now": now.String()})
+	if err != nil {
+		return nil, err
+	}
+
+	profileCache := make(map[string]bool)
+	ownedProfile := func(profileID string) bool {
+		own, ok := profileCache[profileID]
+		if !ok {
+			if profile, err := dao.FindRecordById("profiles", profileID); err == nil {
+				own = recordOwner(profile, userID)
+			}
+			profileCache[profileID] = own
+		}
+		return own
+	}
+
+	intervals := make(map[string][]interval)
+	for _, recording := range recordings {
+		channels := recording.GetStringSlice("channel")
+		if len(channels) == 0 {
+			continue
+		}
+		ids := playlistsOf(channels[0])
+		if len(ids) == 0 {
+			continue
+		}
+
+		entry := interval{
+			recording: ScheduledRecording{
+				ID:        recording.Id,
+				Title:     recording.GetString("program_title"),
+				ChannelID: channels[0],
+				Start:     recording.GetDateTime("scheduled_start").Time(),
+				End:       recording.GetDateTime("scheduled_end").Time(),
+			},
+		}
+		if profiles := recording.GetStringSlice("profile"); len(profiles) > 0 {
+			entry.own = ownedProfile(profiles[0])
+		}
+		if !entry.recording.End.After(entry.recording.Start) {
+			continue
+		}
+		for _, id := range ids {
+			intervals[id] = append(intervals[id], entry)
+		}
+	}
+
+	if schedule != nil {
+		candidate := interval{
+			recording: ScheduledRecording{ChannelID: schedule.ChannelID, Start: schedule.Start, End: schedule.End},
+			own:       true,
+		}
+		ids := playlistsOf(schedule.ChannelID)
+		for _, id := range ids {
+			intervals[id] = append(intervals[id], candidate)
+		}
+		// Only the playlists of the scheduled channel can conflict
+		checked := make(map[string][]interval, len(ids))
+		for _, id := range ids {
+			checked[id] = intervals[id]
+		}
+		intervals = checked
+	}
+
+	conflicts := []Conflict{}
+	for id, entries := range intervals {
+		playlist := limited[id]
+		for _, conflict := range overlaps(entries, playlist.GetInt(LimitField)) {
+			if schedule != nil && !includesCandidate(conflict) {
+				continue
+			}
+			conflict.PlaylistID = id
+			conflict.Playlist = playlist.GetString("name")
+			conflict.Limit = playlist.GetInt(LimitField)
+			conflicts = append(conflicts, conflict)
+		}
+	}
+
+	sort.Slice(conflicts, func(i, j int) bool {
+		return conflicts[i].Start.Before(conflicts[j].Start)
+	})
+	return conflicts, nil
+}
+
+// overlaps returns the time ranges where more than limit intervals run at
+// once, merging adjacent ranges
+func overlaps(entries []interval, limit int) []Conflict {
+	times := make([]time.Time, 0, 2*len(entries))
+	for _, entry := range entries {
+		times = append(times, entry.recording.Start, entry.recording.End)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+
+	var conflicts []Conflict
+	var members map[int]bool
+	for i := 0; i+1 < len(times); i++ {
+		from, to := times[i], times[i+1]
+		if !to.After(from) {
+			continue
+		}
+
+		var active []int
+		for j, entry := range entries {
+			if entry.recording.Start.Before(to) && entry.recording.End.After(from) {
+				active = append(active, j)
+			}
+		}
+		if len(active) <= limit {
+			continue
+		}
+
+		if n := len(conflicts); n > 0 && conflicts[n-1].End.Equal(from) {
+			conflicts[n-1].End = to
+		} else {
+			conflicts = append(conflicts, Conflict{Start: from, End: to, Recordings: []ScheduledRecording{}})
+			members = make(map[int]bool)
+		}
+		conflict := &conflicts[len(conflicts)-1]
+		for _, j := range active {
+			if members[j] {
+				continue
+			}
+			members[j] = true
+			if entries[j].own {
+				conflict.Recordings = append(conflict.Recordings, entries[j].recording)
+			} else {
+				conflict.Others++
+			}
+		}
+	}
+	return conflicts
+}
+
+// includesCandidate reports whether a conflict involves the schedule being
+// checked, the only recording without an ID
+func includesCandidate(conflict Conflict) bool {
+	for _, recording := range conflict.Recordings {
+		if recording.ID == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// recordOwner reports whether a record's user relation, which may be stored
+// as an array, includes userID
+func recordOwner(record *models.Record, userID string) bool {
+	for _, id := range record.GetStringSlice("user") {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,405 @@
+// Package dedupe finds channels present several times in a user's
+// playlists, typically once per quality, and merges them into a logical
+// channel: a primary record whose duplicates (merged_into) are its other
+// sources, played by priority.
+package dedupe
+
+import (
+	"errors"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+)
+
+// Collection holds the channels
+const Collection = "channels"
+
+// Qualities detected in channel names, best first
+const (
+	Quality4K  = "4K"
+	QualityFHD = "FHD"
+	QualityHD  = "HD"
+	QualitySD  = "SD"
+)
+
+var (
+	ErrNotFound     = errors.New("channel not found")
+	ErrMergedSource = errors.New("the channel is merged into another channel, merge into that one")
+	ErrNoSources    = errors.New("no channels to merge")
+)
+
+var (
+	// Country prefixes of provider playlists: "FR: ", "UK | "
+	prefixPattern = regexp.MustCompile(`^\s*[A-Za-z]{2,3}\s*[:|]\s*`)
+	// Bracketed notes: "(backup)", "[1080p]"
+	bracketPattern = regexp.MustCompile(`[(\[][^)\]]*[)\]]`)
+	// Quality and codec tags
+	tagPattern = regexp.MustCompile(`(?i)\b(4k|uhd|fhd|full\s*hd|hd|sd|hevc|h\.?26[45]|\d{3,4}p|\d{2}\s*fps)\b`)
+	// Everything but letters, digits and "+" (timeshift channels are distinct)
+	separatorPattern = regexp.MustCompile(`[^\p{L}\p{N}+]+`)
+
+	qualityPatterns = []struct {
+		quality string
+		pattern *regexp.Regexp
+	}{
+		{Quality4K, regexp.MustCompile(`(?i)\b(4k|uhd|2160p)\b`)},
+		{QualityFHD, regexp.MustCompile(`(?i)\b(fhd|full\s*hd|1080[pi])\b`)},
+		{QualityHD, regexp.MustCompile(`(?i)\b(hd|720p)\b`)},
+		{QualitySD, regexp.MustCompile(`(?i)\b(sd|480p|576p)\b`)},
+	}
+
+	// Unknown qualities rank below HD: most unlabeled streams are
+	// 720p, some are SD
+	qualityRank = map[string]int{Quality4K: 4, QualityFHD: 3, QualityHD: 2, "": 1, QualitySD: 0}
+)
+
+// Normalize reduces a channel name to what duplicates have in common:
+// lowercase, without country prefix, bracketed notes, quality tags and
+// punctuation
+func Normalize(name string) string {
+	name = prefixPattern.ReplaceAllString(name, "")
+	name = bracketPattern.ReplaceAllString(name, " ")
+	name = tagPattern.ReplaceAllString(name, " ")
+	name = separatorPattern.ReplaceAllString(strings.ToLower(name), " ")
+	return strings.TrimSpace(name)
+}
+
+// Quality returns the quality tag of a channel name, empty when it has none
+func Quality(name string) string {
+	for _, q := range qualityPatterns {
+		if q.pattern.MatchString(name) {
+			return q.quality
+		}
+	}
+	return ""
+}
+
+// Member is a channel of a duplicate group
+type Member struct {
+	Channel *models.Record `json:"channel"`
+	Quality string         `json:"quality"`
+	Sources int            `json:"sources"` // Duplicates already merged into it
+}
+
+// Group is a set of channels that look like the same channel, in the
+// suggested priority: the first one is the suggested primary
+type Group struct {
+	Key      string   `json:"key"`
+	Channels []Member `json:"channels"`
+}
+
+// Service analyzes and merges the channels of a user
+type Service struct {
+	app core.App
+}
+
+// NewService returns a dedupe service
+func NewService(app core.App) *Service {
+	return &Service{app: app}
+}
+
+// Analyze groups the channels of the user's playlists sharing a tvg_id or
+// a normalized name. Duplicates already merged count as their primary.
+// playlistID restricts the analysis to one playlist when set.
+func (s *Service) Analyze(userID, playlistID string) ([]Group, error) {
+	filter := "playlist.user ~ {:user}"
+	if playlistID != "" {
+		filter += " && playlist ~ {:playlist}"
+	}
+	channels, err := s.app.Dao().FindRecordsByFilter(Collection, filter, "created", 0, 0,
+		dbx.Params{"user": userID, "playlist": playlistID})
+	if err != nil {
+		return nil, err
+	}
+
+	merged := make(map[string]int)
+	primaries := make([]*models.Record, 0, len(channels))
+	for _, channel := range channels {
+		if into := channel.GetString("merged_into"); into != "" {
+			merged[into]++
+			continue
+		}
+		primaries = append(primaries, channel)
+	}
+
+	// Channels sharing any key end up in the same group
+	parent := make([]int, len(primaries))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	byKey := make(map[string]int)
+	for i, channel := range primaries {
+		keys := make([]string, 0, 2)
+		if tvgID := strings.ToLower(strings.TrimSpace(channel.GetString("tvg_id"))); tvgID != "" {
+			keys = append(keys, "tvg:"+tvgID)
+		}
+		if name := Normalize(channel.GetString("name")); name != "" {
+			keys = append(keys, "name:"+name)
+		}
+		for _, key := range keys {
+			if j, ok := byKey[key]; ok {
+				parent[find(i)] = find(j)
+			} else {
+				byKey[key] = i
+			}
+		}
+	}
+
+	members := make(map[int][]int)
+	for i := range primaries {
+		root := find(i)
+		members[root] = append(members[root], i)
+	}
+
+	groups := make([]Group, 0)
+	for _, indexes := range members {
+		if len(indexes) < 2 {
+			continue
+		}
+
+		group := Group{Channels: make([]Member, len(indexes))}
+		for k, i := range indexes {
+			channel := primaries[i]
+			group.Channels[k] = Member{
+				Channel: channel,
+				Quality: Quality(channel.GetString("name")),
+				Sources: merged[channel.Id],
+			}
+		}
+		sortMembers(group.Channels)
+		group.Key = Normalize(group.Channels[0].Channel.GetString("name"))
+		groups = append(groups, group)
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Key < groups[j].Key
+	})
+	return groups, nil
+}
+
+// sortMembers orders a group by suggested priority: best quality first,
+// then channels with guide data and a logo, then the oldest
+func sortMembers(members []Member) {
+	score := func(m Member) int {
+		score := qualityRank[m.Quality] * 4
+		if m.Channel.GetString("tvg_id") != "" {
+			score += 2
+		}
+		if m.Channel.GetString("tvg_logo") != "" {
+			score++
+		}
+		return score
+	}
+	sort.SliceStable(members, func(i, j int) bool {
+		return score(members[i]) > score(members[j])
+	})
+}
+
+// Merge makes sourceIDs sources of the primary channel, in that priority
+// after the primary. Sources already merged into the primary and not
+// listed keep their order after the listed ones; the sources of a listed
+// channel move along with it. Favorites of the merged channels move to the
+// primary. The primary and its sources are returned by priority.
+func (s *Service) Merge(userID, primaryID string, sourceIDs []string) ([]*models.Record, error) {
+	dao := s.app.Dao()
+
+	primary, err := s.owned(userID, primaryID)
+	if err != nil {
+		return nil, err
+	}
+	if primary.GetString("merged_into") != "" {
+		return nil, ErrMergedSource
+	}
+
+	current, err := dao.FindRecordsByFilter(Collection, "merged_into = {:channel}", "priority", 0, 0,
+		dbx.Params{"channel": primary.Id})
+	if err != nil {
+		return nil, err
+	}
+
+	sources := make([]*models.Record, 0, len(sourceIDs)+len(current))
+	added := make(map[string]bool)
+	add := func(channel *models.Record) {
+		if channel.Id != primary.Id && !added[channel.Id] {
+			added[channel.Id] = true
+			sources = append(sources, channel)
+		}
+	}
+
+	// Channels newly merged, whose favorites move to the primary
+	moved := make([]*models.Record, 0, len(sourceIDs))
+
+	for _, id := range sourceIDs {
+		channel, err := s.owned(userID, id)
+		if err != nil {
+			return nil, err
+		}
+		if into := channel.GetString("merged_into"); into != "" && into != primary.Id {
+			return nil, ErrMergedSource
+		}
+		if channel.Id == primary.Id || added[channel.Id] {
+			continue
+		}
+		if channel.GetString("merged_into") == "" {
+			moved = append(moved, channel)
+		}
+		add(channel)
+
+		children, err := dao.FindRecordsByFilter(Collection, "merged_into = {:channel}", "priority", 0, 0,
+			dbx.Params{"channel": channel.Id})
+		if err != nil {
+			return nil, err
+		}
+		for _, child := range children {
+			add(child)
+		}
+	}
+	if len(sources) == 0 {
+		return nil, ErrNoSources
+	}
+	for _, channel := range current {
+		add(channel)
+	}
+
+	err = dao.RunInTransaction(func(txDao *daos.Dao) error {
+		primary.Set("priority", 0)
+		if err := txDao.SaveRecord(primary); err != nil {
+			return err
+		}
+
+		for i, source := range sources {
+			source.Set("merged_into", primary.Id)
+			source.Set("priority", i+1)
+			if err := txDao.SaveRecord(source); err != nil {
+				return err
+			}
+		}
+
+		for _, channel := range moved {
+			if err := moveFavorites(txDao, channel.Id, primary.Id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]*models.Record{primary}, sources...), nil
+}
+
+// Unmerge detaches a source from its logical channel, or all the sources of
+// a primary channel. The detached channels are returned.
+func (s *Service) Unmerge(userID, channelID string) ([]*models.Record, error) {
+	dao := s.app.Dao()
+
+	channel, err := s.owned(userID, channelID)
+	if err != nil {
+		return nil, err
+	}
+
+	detached := []*models.Record{channel}
+	if channel.GetString("merged_into") == "" {
+		detached, err = dao.FindRecordsByFilter(Collection, "merged_into = {:channel}", "priority", 0, 0,
+			dbx.Params{"channel": channel.Id})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = dao.RunInTransaction(func(txDao *daos.Dao) error {
+		for _, record := range detached {
+			record.Set("merged_into", "")
+			record.Set("priority", 0)
+			if err := txDao.SaveRecord(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return detached, nil
+}
+
+// owned finds a channel of a playlist the user has
+func (s *Service) owned(userID, channelID string) (*models.Record, error) {
+	dao := s.app.Dao()
+
+	channel, err := dao.FindRecordById(Collection, channelID)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	// Relation fields may be stored as arrays
+	for _, playlistID := range channel.GetStringSlice("playlist") {
+		playlist, err := dao.FindRecordById("playlists", playlistID)
+		if err == nil && slices.Contains(playlist.GetStringSlice("user"), userID) {
+			return channel, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+// moveFavorites points the favorites of a channel at another one, dropping
+// those of profiles that already have it
+func moveFavorites(dao *daos.Dao, fromID, toID string) error {
+	favorites, err := dao.FindRecordsByFilter("favorites", "channel ~ {:channel}", "", 0, 0,
+		dbx.Params{"channel": fromID})
+	if err != nil {
+		return err
+	}
+
+	for _, favorite := range favorites {
+		profiles := favorite.GetStringSlice("profile")
+
+		existing, err := dao.FindRecordsByFilter("favorites", "channel ~ {:channel}", "", 0, 0,
+			dbx.Params{"channel": toID})
+		if err != nil {
+			return err
+		}
+		duplicate := slices.ContainsFunc(existing, func(other *models.Record) bool {
+			return slices.ContainsFunc(other.GetStringSlice("profile"), func(id string) bool {
+				return slices.Contains(profiles, id)
+			})
+		})
+
+		if duplicate {
+			if err := dao.DeleteRecord(favorite); err != nil {
+				return err
+			}
+			continue
+		}
+
+		channels := favorite.GetStringSlice("channel")
+		for i, id := range channels {
+			if id == fromID {
+				channels[i] = toID
+			}
+		}
+		favorite.Set("channel", channels)
+		if err := dao.SaveRecord(favorite); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,313 @@
+// Package epg reads the programme guide: the programmes of a user's guide
+// sources (epg_sources), matched to channels by their tvg_id. When several
+// sources have a channel, the one with the lowest priority, or one the
+// channel prefers (its epg_sources), wins and the others fill its gaps.
+package epg
+
+import (
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/types"
+
+	"iptv-backend/playlist"
+)
+
+// Collections holding the guide
+const (
+	SourcesCollection  = "epg_sources"
+	ProgramsCollection = "epg_programs"
+)
+
+// NextWindow is how far ahead the next programme of a channel is looked for
+const NextWindow = 12 * time.Hour
+
+// NowNext is the programme a channel airs and the one after it, nil when
+// the guide has none
+type NowNext struct {
+	Now  *models.Record `json:"now"`
+	Next *models.Record `json:"next"`
+}
+
+// Progress is how far into the current programme at is, between 0 and 1
+func (n NowNext) Progress(at time.Time) float64 {
+	if n.Now == nil {
+		return 0
+	}
+	start := n.Now.GetDateTime("start_time").Time()
+	length := n.Now.GetDateTime("end_time").Time().Sub(start)
+	if length <= 0 {
+		return 0
+	}
+	return min(1, max(0, float64(at.Sub(start))/float64(length)))
+}
+
+// FavoriteNow is a favorite channel with what it airs
+type FavoriteNow struct {
+	Favorite string         `json:"favorite"`
+	Channel  *models.Record `json:"channel"`
+	Now      *models.Record `json:"now"`
+	Next     *models.Record `json:"next"`
+	Progress float64        `json:"progress"`
+}
+
+// Service reads the guide
+type Service struct {
+	app core.App
+}
+
+// NewService returns a guide service
+func NewService(app core.App) *Service {
+	return &Service{app: app}
+}
+
+// SourceIDs returns the ids of the guide sources of a user, by priority
+func (s *Service) SourceIDs(userID string) ([]string, error) {
+	sources, err := s.app.Dao().FindRecordsByFilter(SourcesCollection, "user ~ {:user}", "priority,created", 0, 0,
+		dbx.Params{"user": userID})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(sources))
+	for i, source := range sources {
+		ids[i] = source.Id
+	}
+	return ids, nil
+}
+
+// NowNext returns, by tvg_id, the programmes of the user's guide airing at
+// a time and the ones following them
+func (s *Service) NowNext(userID string, tvgIDs []string, at time.Time) (map[string]NowNext, error) {
+	result := make(map[string]NowNext, len(tvgIDs))
+	if len(tvgIDs) == 0 {
+		return result, nil
+	}
+
+	sourceIDs, err := s.SourceIDs(userID)
+	if err != nil || len(sourceIDs) == 0 {
+		return result, err
+	}
+
+	from, _ := types.ParseDateTime(at)
+	until, _ := types.ParseDateTime(at.Add(NextWindow))
+
+	programs := []*models.Record{}
+	err = s.app.Dao().RecordQuery(ProgramsCollection).
+		AndWhere(dbx.In("source", toAny(sourceIDs)...)).
+		AndWhere(dbx.In("channel_id", toAny(tvgIDs)...)).
+		AndWhere(dbx.NewExp("end_time > {:from} AND start_time < {:until}",
+			dbx.Params{"from": from.String(), "until": until.String()})).
+		OrderBy("start_time").
+		All(&programs)
+	if err != nil {
+		return nil, err
+	}
+	programs, err = s.merge(userID, sourceIDs, tvgIDs, programs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Programmes come by start time, the first airing one is current and
+	// the first one starting later is next
+	for _, program := range programs {
+		tvgID := program.GetString("channel_id")
+		entry := result[tvgID]
+		if program.GetDateTime("start_time").Time().After(at) {
+			if entry.Next == nil {
+				entry.Next = program
+			}
+		} else if entry.Now == nil {
+			entry.Now = program
+		}
+		result[tvgID] = entry
+	}
+
+	return result, nil
+}
+
+// Programs returns the programmes of the user's guide a channel airs
+// between two times, by start time
+func (s *Service) Programs(userID, tvgID string, from, to time.Time) ([]*models.Record, error) {
+	programs := []*models.Record{}
+	if tvgID == "" {
+		return programs, nil
+	}
+
+	sourceIDs, err := s.SourceIDs(userID)
+	if err != nil || len(sourceIDs) == 0 {
+		return programs, err
+	}
+
+	fromTime, _ := types.ParseDateTime(from)
+	toTime, _ := types.ParseDateTime(to)
+
+	err = s.app.Dao().RecordQuery(ProgramsCollection).
+		AndWhere(dbx.In("source", toAny(sourceIDs)...)).
+		AndWhere(dbx.HashExp{"channel_id": tvgID}).
+		AndWhere(dbx.NewExp("end_time > {:from} AND start_time < {:to}",
+			dbx.Params{"from": fromTime.String(), "to": toTime.String()})).
+		OrderBy("start_time").
+		All(&programs)
+	if err != nil {
+		return nil, err
+	}
+	return s.merge(userID, sourceIDs, []string{tvgID}, programs)
+}
+
+// merge keeps, for each channel, the programmes of its first source and
+// fills the times they leave empty with those of the next sources, in the
+// order the channel prefers then by priority. Programmes come back by
+// start time.
+func (s *Service) merge(userID string, sourceIDs, tvgIDs []string, programs []*models.Record) ([]*models.Record, error) {
+	if len(sourceIDs) < 2 || len(programs) < 2 {
+		return programs, nil
+	}
+
+	preferred, err := s.preferred(userID, tvgIDs)
+	if err != nil {
+		return nil, err
+	}
+	rank := func(program *models.Record) int {
+		source := program.GetString("source")
+		prefer := preferred[program.GetString("channel_id")]
+		if i := slices.Index(prefer, source); i >= 0 {
+			return i
+		}
+		return len(prefer) + slices.Index(sourceIDs, source)
+	}
+
+	byChannel := make(map[string][]*models.Record)
+	for _, program := range programs {
+		tvgID := program.GetString("channel_id")
+		byChannel[tvgID] = append(byChannel[tvgID], program)
+	}
+
+	merged := make([]*models.Record, 0, len(programs))
+	for _, candidates := range byChannel {
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return rank(candidates[i]) < rank(candidates[j])
+		})
+
+		kept := make([]*models.Record, 0, len(candidates))
+		for _, program := range candidates {
+			if !slices.ContainsFunc(kept, func(other *models.Record) bool { return overlap(program, other) }) {
+				kept = append(kept, program)
+			}
+		}
+		merged = append(merged, kept...)
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].GetDateTime("start_time").Time().Before(merged[j].GetDateTime("start_time").Time())
+	})
+	return merged, nil
+}
+
+// preferred returns, by tvg_id, the guide sources the user's channels take
+// their programmes from first
+func (s *Service) preferred(userID string, tvgIDs []string) (map[string][]string, error) {
+	preferred := make(map[string][]string)
+	if len(tvgIDs) == 0 {
+		return preferred, nil
+	}
+
+	dao := s.app.Dao()
+
+	channels := []*models.Record{}
+	err := dao.RecordQuery("channels").
+		AndWhere(dbx.In("tvg_id", toAny(tvgIDs)...)).
+		AndWhere(dbx.NewExp("[[epg_sources]] NOT IN ('', '[]')")).
+		All(&channels)
+	if err != nil || len(channels) == 0 {
+		return preferred, err
+	}
+
+	owned, err := playlist.OwnedIDs(dao, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, channel := range channels {
+		tvgID := channel.GetString("tvg_id")
+		if _, ok := preferred[tvgID]; ok ||
+			!slices.ContainsFunc(channel.GetStringSlice("playlist"), func(id string) bool { return owned[id] }) {
+			continue
+		}
+		preferred[tvgID] = channel.GetStringSlice("epg_sources")
+	}
+	return preferred, nil
+}
+
+// overlap reports whether two programmes air at the same time
+func overlap(a, b *models.Record) bool {
+	return a.GetDateTime("start_time").Time().Before(b.GetDateTime("end_time").Time()) &&
+		b.GetDateTime("start_time").Time().Before(a.GetDateTime("end_time").Time())
+}
+
+// Favorites returns the favorite channels of a profile, in their order,
+// with what they air now and next. keep filters out channels, such as
+// those hidden from the profile.
+func (s *Service) Favorites(profileID, userID string, keep func(channel *models.Record) bool) ([]FavoriteNow, error) {
+	dao := s.app.Dao()
+	now := time.Now()
+
+	favorites, err := dao.FindRecordsByFilter("favorites", "profile ~ {:profile}", "sort_order,created", 0, 0,
+		dbx.Params{"profile": profileID})
+	if err != nil {
+		return nil, err
+	}
+
+	channelIDs := make([]string, 0, len(favorites))
+	for _, favorite := range favorites {
+		channelIDs = append(channelIDs, favorite.GetStringSlice("channel")...)
+	}
+	channels, err := dao.FindRecordsByIds("channels", channelIDs)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]*models.Record, len(channels))
+	for _, channel := range channels {
+		byID[channel.Id] = channel
+	}
+
+	items := make([]FavoriteNow, 0, len(favorites))
+	tvgIDs := make([]string, 0, len(favorites))
+	for _, favorite := range favorites {
+		for _, id := range favorite.GetStringSlice("channel") {
+			channel, ok := byID[id]
+			if !ok || keep != nil && !keep(channel) {
+				continue
+			}
+			items = append(items, FavoriteNow{Favorite: favorite.Id, Channel: channel})
+			if tvgID := channel.GetString("tvg_id"); tvgID != "" {
+				tvgIDs = append(tvgIDs, tvgID)
+			}
+		}
+	}
+
+	guide, err := s.NowNext(userID, tvgIDs, now)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, item := range items {
+		entry := guide[item.Channel.GetString("tvg_id")]
+		items[i].Now = entry.Now
+		items[i].Next = entry.Next
+		items[i].Progress = entry.Progress(now)
+	}
+
+	return items, nil
+}
+
+func toAny(values []string) []interface{} {
+	result := make([]interface{}, len(values))
+	for i, value := range values {
+		result[i] = value
+	}
+	return result
+}
@@ -0,0 +1,259 @@
+package epg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// importBatchSize is the number of programmes written per transaction
+const importBatchSize = 500
+
+var ErrNoURL = errors.New("the guide source has no URL to import from")
+
+// ImportResult summarizes a guide import
+type ImportResult struct {
+	SourceID  string `json:"source_id"`
+	Format    string `json:"format"`
+	Parsed    int    `json:"parsed"`
+	Inserted  int    `json:"inserted"`
+	Updated   int    `json:"updated"`
+	Unchanged int    `json:"unchanged"`
+	Removed   int    `json:"removed"` // Dropped by the guide within the time it covers
+	Skipped   int    `json:"skipped"` // Without channel, title or valid times, or repeated
+	Failed    int    `json:"failed"`
+}
+
+// Import fetches the guide of a source and upserts its programmes, matched
+// by channel and start time. Programmes of the source the guide no longer
+// has between its first and last programme are removed; older ones are
+// kept. The error of a failed import is kept in the source's last_error.
+func (s *Service) Import(ctx context.Context, sourceID string, progress func(float64, string)) (*ImportResult, error) {
+	if progress == nil {
+		progress = func(float64, string) {}
+	}
+
+	dao := s.app.Dao()
+
+	source, err := dao.FindRecordById(SourcesCollection, sourceID)
+	if err != nil {
+		return nil, fmt.Errorf("guide source not found: %w", err)
+	}
+	if source.GetString("url") == "" {
+		return nil, ErrNoURL
+	}
+
+	progress(0, "fetching guide")
+	programmes, format, err := fetch(ctx, source.GetString("url"))
+	if err != nil {
+		s.setError(source, err)
+		return nil, err
+	}
+
+	result := &ImportResult{SourceID: sourceID, Format: format, Parsed: len(programmes)}
+	programmes = validProgrammes(programmes, result)
+
+	collection, err := dao.FindCollectionByNameOrId(ProgramsCollection)
+	if err != nil {
+		return nil, err
+	}
+
+	var from, until time.Time
+	for i, programme := range programmes {
+		if i == 0 || programme.Start.Before(from) {
+			from = programme.Start
+		}
+		if programme.Stop.After(until) {
+			until = programme.Stop
+		}
+	}
+
+	progress(10, "applying programmes")
+
+	existing := []*models.Record{}
+	if len(programmes) > 0 {
+		fromTime, _ := types.ParseDateTime(from)
+		err = dao.RecordQuery(collection).
+			AndWhere(dbx.HashExp{"source": sourceID}).
+			AndWhere(dbx.NewExp("end_time > {:from}", dbx.Params{"from": fromTime.String()})).
+			All(&existing)
+		if err != nil {
+			return nil, err
+		}
+	}
+	byKey := make(map[string]*models.Record, len(existing))
+	for _, record := range existing {
+		byKey[programmeKey(record.GetString("channel_id"), record.GetDateTime("start_time").Time())] = record
+	}
+
+	seen := make(map[string]bool, len(programmes))
+	for start := 0; start < len(programmes); start += importBatchSize {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		end := min(start+importBatchSize, len(programmes))
+		err := dao.RunInTransaction(func(txDao *daos.Dao) error {
+			for _, programme := range programmes[start:end] {
+				key := programmeKey(programme.Channel, programme.Start)
+				seen[key] = true
+
+				record, exists := byKey[key]
+				if !exists {
+					record = models.NewRecord(collection)
+					record.Set("source", sourceID)
+				}
+				if !applyProgramme(record, programme) {
+					result.Unchanged++
+					continue
+				}
+				if err := txDao.SaveRecord(record); err != nil {
+					result.Failed++
+				} else if exists {
+					result.Updated++
+				} else {
+					result.Inserted++
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		progress(10+float64(end)/float64(len(programmes))*80, fmt.Sprintf("%d/%d programmes", end, len(programmes)))
+	}
+
+	gone := make([]*models.Record, 0)
+	for key, record := range byKey {
+		start := record.GetDateTime("start_time").Time()
+		if !seen[key] && !start.Before(from) && start.Before(until) {
+			gone = append(gone, record)
+		}
+	}
+	for start := 0; start < len(gone); start += importBatchSize {
+		end := min(start+importBatchSize, len(gone))
+		err := dao.RunInTransaction(func(txDao *daos.Dao) error {
+			for _, record := range gone[start:end] {
+				if err := txDao.DeleteRecord(record); err != nil {
+					result.Failed++
+				} else {
+					result.Removed++
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	source.Set("last_synced", time.Now())
+	source.Set("last_error", "")
+	if err := dao.SaveRecord(source); err != nil {
+		return nil, err
+	}
+
+	progress(100, "done")
+	return result, nil
+}
+
+// fetch downloads and parses a guide
+func fetch(ctx context.Context, rawURL string) ([]Programme, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch guide: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("guide server returned status %d", resp.StatusCode)
+	}
+
+	return Parse(resp.Body)
+}
+
+// validProgrammes trims the programmes and leaves out those without
+// channel, title or valid times, and those starting on a channel at the
+// time of another
+func validProgrammes(programmes []Programme, result *ImportResult) []Programme {
+	kept := programmes[:0]
+	seen := make(map[string]bool, len(programmes))
+	for _, programme := range programmes {
+		programme.Channel = truncate(strings.TrimSpace(programme.Channel), 200)
+		programme.Title = truncate(strings.TrimSpace(programme.Title), 500)
+		key := programmeKey(programme.Channel, programme.Start)
+		if programme.Channel == "" || programme.Title == "" || programme.Start.IsZero() ||
+			!programme.Stop.After(programme.Start) || seen[key] {
+			result.Skipped++
+			continue
+		}
+		seen[key] = true
+		kept = append(kept, programme)
+	}
+	return kept
+}
+
+// applyProgramme copies a programme onto a record and reports whether it
+// changed
+func applyProgramme(record *models.Record, programme Programme) bool {
+	fields := map[string]string{
+		"channel_id":  programme.Channel,
+		"title":       programme.Title,
+		"description": truncate(strings.TrimSpace(programme.Description), 5000),
+		"category":    truncate(strings.TrimSpace(programme.Category), 200),
+		"icon":        truncate(strings.TrimSpace(programme.Icon), 2000),
+		"rating":      truncate(strings.TrimSpace(programme.Rating), 50),
+		"episode":     truncate(strings.TrimSpace(programme.Episode), 100),
+	}
+
+	changed := false
+	for key, value := range fields {
+		if record.GetString(key) != value {
+			record.Set(key, value)
+			changed = true
+		}
+	}
+	for key, value := range map[string]time.Time{"start_time": programme.Start, "end_time": programme.Stop} {
+		if !record.GetDateTime(key).Time().Equal(value) {
+			record.Set(key, value)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// setError keeps the error of a failed import in the source
+func (s *Service) setError(source *models.Record, err error) {
+	source.Set("last_error", truncate(err.Error(), 1000))
+	s.app.Dao().SaveRecord(source)
+}
+
+func programmeKey(channel string, start time.Time) string {
+	return fmt.Sprintf("%s\x00%d", channel, start.Unix())
+}
+
+func truncate(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max])
+}
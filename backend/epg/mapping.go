@@ -0,0 +1,347 @@
+package epg
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+
+	"iptv-backend/playlist"
+)
+
+// MappingsCollection holds the guide channels picked for channels whose
+// tvg_id doesn't match the guide
+const MappingsCollection = "epg_mappings"
+
+// MaxEpgIDLength bounds guide channel ids, as tvg_id
+const MaxEpgIDLength = 200
+
+var (
+	ErrChannelNotFound  = errors.New("channel not found")
+	ErrPlaylistNotFound = errors.New("playlist not found")
+	ErrMappingNotFound  = errors.New("the channel has no guide mapping")
+	ErrEpgID            = fmt.Errorf("epg_id must be at most %d characters", MaxEpgIDLength)
+)
+
+// Unmatched is a channel without guide data, with the guide channels it
+// may be
+type Unmatched struct {
+	Channel     *models.Record `json:"channel"`
+	Suggestions []Suggestion   `json:"suggestions"`
+}
+
+// UnmatchedQuery selects the unmatched channels of a user
+type UnmatchedQuery struct {
+	Playlist string
+	Page     int
+	PerPage  int
+}
+
+// UnmatchedPage is a page of unmatched channels, in their order, shaped
+// like PocketBase lists
+type UnmatchedPage struct {
+	Page          int         `json:"page"`
+	PerPage       int         `json:"perPage"`
+	TotalItems    int         `json:"totalItems"`
+	TotalPages    int         `json:"totalPages"`
+	GuideChannels int         `json:"guide_channels"` // Channel ids of the user's guide
+	Items         []Unmatched `json:"items"`
+}
+
+// AutoMatch is a guide channel matched to a channel automatically
+type AutoMatch struct {
+	Channel string  `json:"channel"`
+	Name    string  `json:"name"`
+	EpgID   string  `json:"epg_id"`
+	Score   float64 `json:"score"`
+}
+
+// AutoResult summarizes automatic matching
+type AutoResult struct {
+	Matched   []AutoMatch `json:"matched"`
+	Unmatched int         `json:"unmatched"` // Channels left without a match
+}
+
+// GuideIDs returns the channel ids of the programmes of a user's guide
+func (s *Service) GuideIDs(userID string) ([]string, error) {
+	ids := []string{}
+
+	sourceIDs, err := s.SourceIDs(userID)
+	if err != nil || len(sourceIDs) == 0 {
+		return ids, err
+	}
+
+	err = s.app.Dao().DB().
+		Select("channel_id").
+		Distinct(true).
+		From(ProgramsCollection).
+		Where(dbx.In("source", toAny(sourceIDs)...)).
+		OrderBy("channel_id").
+		Column(&ids)
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// Mappings returns the guide mappings of a user, newest first
+func (s *Service) Mappings(userID string) ([]*models.Record, error) {
+	return s.app.Dao().FindRecordsByFilter(MappingsCollection, "user = {:user}", "-created", 0, 0,
+		dbx.Params{"user": userID})
+}
+
+// Unmatched lists the channels of a user, or of one of the user's
+// playlists, whose tvg_id matches no channel of the guide and that have no
+// mapping, with suggestions for the channels of the page. Disabled
+// channels and merged sources are left out.
+func (s *Service) Unmatched(userID string, query UnmatchedQuery) (*UnmatchedPage, error) {
+	if query.Page < 1 {
+		query.Page = 1
+	}
+	if query.PerPage < 1 || query.PerPage > 200 {
+		query.PerPage = 50
+	}
+
+	guideIDs, err := s.GuideIDs(userID)
+	if err != nil {
+		return nil, err
+	}
+	channels, err := s.unmatched(userID, query.Playlist, guideIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &UnmatchedPage{
+		Page:          query.Page,
+		PerPage:       query.PerPage,
+		TotalItems:    len(channels),
+		TotalPages:    (len(channels) + query.PerPage - 1) / query.PerPage,
+		GuideChannels: len(guideIDs),
+		Items:         []Unmatched{},
+	}
+
+	start := min((query.Page-1)*query.PerPage, len(channels))
+	end := min(start+query.PerPage, len(channels))
+	if start == end {
+		return page, nil
+	}
+
+	m := newMatcher(guideIDs)
+	for _, channel := range channels[start:end] {
+		page.Items = append(page.Items, Unmatched{Channel: channel, Suggestions: m.Suggest(channel)})
+	}
+	return page, nil
+}
+
+// AutoMatch maps the unmatched channels of a user, or of one of the user's
+// playlists, to their best suggestion when it scores at least minScore
+// (DefaultAutoScore when 0) and no other suggestion scores as much. With
+// dryRun nothing is saved.
+func (s *Service) AutoMatch(userID, playlistID string, minScore float64, dryRun bool) (*AutoResult, error) {
+	if minScore <= 0 {
+		minScore = DefaultAutoScore
+	}
+
+	guideIDs, err := s.GuideIDs(userID)
+	if err != nil {
+		return nil, err
+	}
+	channels, err := s.unmatched(userID, playlistID, guideIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	m := newMatcher(guideIDs)
+	result := &AutoResult{Matched: []AutoMatch{}}
+	picks := make(map[*models.Record]Suggestion)
+	for _, channel := range channels {
+		suggestions := m.Suggest(channel)
+		if len(suggestions) == 0 || suggestions[0].Score < minScore ||
+			len(suggestions) > 1 && suggestions[1].Score == suggestions[0].Score {
+			result.Unmatched++
+			continue
+		}
+
+		picks[channel] = suggestions[0]
+		result.Matched = append(result.Matched, AutoMatch{
+			Channel: channel.Id,
+			Name:    channel.GetString("name"),
+			EpgID:   suggestions[0].EpgID,
+			Score:   suggestions[0].Score,
+		})
+	}
+	if dryRun || len(picks) == 0 {
+		return result, nil
+	}
+
+	err = s.app.Dao().RunInTransaction(func(txDao *daos.Dao) error {
+		for channel, pick := range picks {
+			if _, err := s.saveMapping(txDao, userID, channel, pick.EpgID, true, pick.Score); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// SetMapping maps a channel of the user to a guide channel, empty for a
+// channel without guide, and sets its tvg_id to it. Playlist syncs keep
+// the tvg_id of mapped channels.
+func (s *Service) SetMapping(userID, channelID, epgID string) (*models.Record, error) {
+	if len([]rune(epgID)) > MaxEpgIDLength {
+		return nil, ErrEpgID
+	}
+
+	var mapping *models.Record
+	err := s.app.Dao().RunInTransaction(func(txDao *daos.Dao) error {
+		channel, err := s.ownedChannel(txDao, userID, channelID)
+		if err != nil {
+			return err
+		}
+		mapping, err = s.saveMapping(txDao, userID, channel, epgID, false, 0)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mapping, nil
+}
+
+// DeleteMapping removes the mapping of a channel of the user, which gets
+// the tvg_id of its provider back
+func (s *Service) DeleteMapping(userID, channelID string) error {
+	return s.app.Dao().RunInTransaction(func(txDao *daos.Dao) error {
+		channel, err := s.ownedChannel(txDao, userID, channelID)
+		if err != nil {
+			return err
+		}
+		mapping, err := s.mappingOf(txDao, userID, channel.Id)
+		if err != nil {
+			return err
+		}
+		if mapping == nil {
+			return ErrMappingNotFound
+		}
+
+		channel.Set("tvg_id", mapping.GetString("provider_tvg_id"))
+		if err := txDao.SaveRecord(channel); err != nil {
+			return err
+		}
+		return txDao.DeleteRecord(mapping)
+	})
+}
+
+// saveMapping creates or replaces the mapping of a channel and applies it
+func (s *Service) saveMapping(dao *daos.Dao, userID string, channel *models.Record, epgID string, auto bool, score float64) (*models.Record, error) {
+	mapping, err := s.mappingOf(dao, userID, channel.Id)
+	if err != nil {
+		return nil, err
+	}
+	if mapping == nil {
+		collection, err := dao.FindCollectionByNameOrId(MappingsCollection)
+		if err != nil {
+			return nil, err
+		}
+		mapping = models.NewRecord(collection)
+		mapping.Set("user", userID)
+		mapping.Set("channel", channel.Id)
+		mapping.Set("provider_tvg_id", channel.GetString("tvg_id"))
+	}
+
+	mapping.Set("epg_id", epgID)
+	mapping.Set("auto", auto)
+	mapping.Set("score", score)
+	if err := dao.SaveRecord(mapping); err != nil {
+		return nil, err
+	}
+
+	if channel.GetString("tvg_id") != epgID {
+		channel.Set("tvg_id", epgID)
+		if err := dao.SaveRecord(channel); err != nil {
+			return nil, err
+		}
+	}
+	return mapping, nil
+}
+
+// mappingOf returns the mapping of a channel, nil when it has none
+func (s *Service) mappingOf(dao *daos.Dao, userID, channelID string) (*models.Record, error) {
+	mappings, err := dao.FindRecordsByFilter(MappingsCollection, "user = {:user} && channel = {:channel}", "", 1, 0,
+		dbx.Params{"user": userID, "channel": channelID})
+	if err != nil || len(mappings) == 0 {
+		return nil, err
+	}
+	return mappings[0], nil
+}
+
+// unmatched returns the active channels of a user, or of one of the user's
+// playlists, in their order, whose tvg_id isn't one of guideIDs and that
+// have no mapping
+func (s *Service) unmatched(userID, playlistID string, guideIDs []string) ([]*models.Record, error) {
+	dao := s.app.Dao()
+
+	// Note: using ~ as relation fields may be stored as arrays
+	filter := "playlist.user ~ {:user} && is_active = true && merged_into = ''"
+	if playlistID != "" {
+		owned, err := playlist.OwnedIDs(dao, userID)
+		if err != nil {
+			return nil, err
+		}
+		if !owned[playlistID] {
+			return nil, ErrPlaylistNotFound
+		}
+		filter += " && playlist ~ {:playlist}"
+	}
+
+	channels, err := dao.FindRecordsByFilter("channels", filter, "sort_order,name", 0, 0,
+		dbx.Params{"user": userID, "playlist": playlistID})
+	if err != nil {
+		return nil, err
+	}
+
+	mappings, err := s.Mappings(userID)
+	if err != nil {
+		return nil, err
+	}
+	mapped := make(map[string]bool, len(mappings))
+	for _, mapping := range mappings {
+		mapped[mapping.GetString("channel")] = true
+	}
+
+	guide := make(map[string]bool, len(guideIDs))
+	for _, id := range guideIDs {
+		guide[id] = true
+	}
+
+	result := make([]*models.Record, 0)
+	for _, channel := range channels {
+		if !mapped[channel.Id] && !guide[channel.GetString("tvg_id")] {
+			result = append(result, channel)
+		}
+	}
+	return result, nil
+}
+
+// ownedChannel returns a channel of the user's playlists
+func (s *Service) ownedChannel(dao *daos.Dao, userID, channelID string) (*models.Record, error) {
+	channel, err := dao.FindRecordById("channels", channelID)
+	if err != nil {
+		return nil, ErrChannelNotFound
+	}
+
+	owned, err := playlist.OwnedIDs(dao, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.ContainsFunc(channel.GetStringSlice("playlist"), func(id string) bool { return owned[id] }) {
+		return nil, ErrChannelNotFound
+	}
+	return channel, nil
+}
@@ -0,0 +1,230 @@
+package epg
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pocketbase/pocketbase/models"
+
+	"iptv-backend/dedupe"
+)
+
+// Scores of guide channel suggestions, between 0 and 1
+const (
+	// MinSuggestionScore is the lowest score suggested
+	MinSuggestionScore = 0.5
+	// DefaultAutoScore is the lowest score automatic matching applies
+	DefaultAutoScore = 0.85
+	// MaxSuggestions bounds the suggestions of a channel
+	MaxSuggestions = 3
+)
+
+// Scores of guide channels from another country than the channel's are
+// lowered by countryPenalty, those of no country by unknownCountryPenalty
+// so the channel's own country comes first
+const (
+	countryPenalty        = 0.8
+	unknownCountryPenalty = 0.95
+)
+
+var (
+	// Country prefixes of provider channel names: "FR: ", "UK | "
+	namePrefixPattern = regexp.MustCompile(`^\s*([A-Za-z]{2,3})\s*[:|]`)
+	// Country suffixes of XMLTV ids: "TF1.fr", "BBCOne.uk"
+	countrySuffixPattern = regexp.MustCompile(`^[A-Za-z]{2}$`)
+	// Word boundaries of ids written in camel case: "BBCOne", "TF1HD"
+	lowerUpperPattern = regexp.MustCompile(`([\p{Ll}\p{N}])(\p{Lu})`)
+	upperWordPattern  = regexp.MustCompile(`(\p{Lu})(\p{Lu}\p{Ll})`)
+
+	// Number words, so "BBC One" matches "bbc1"
+	numberWords = map[string]string{
+		"one": "1", "two": "2", "three": "3", "four": "4", "five": "5",
+		"six": "6", "seven": "7", "eight": "8", "nine": "9", "ten": "10",
+	}
+
+	// Country codes written in several ways
+	countryAliases = map[string]string{"gb": "uk", "uk": "uk", "en": "uk"}
+)
+
+// Suggestion is a guide channel that may be the one of a channel
+type Suggestion struct {
+	EpgID string  `json:"epg_id"`
+	Score float64 `json:"score"`
+}
+
+// guideChannel is a guide channel id reduced for matching
+type guideChannel struct {
+	id      string
+	key     string
+	country string
+	grams   int
+}
+
+// matcher suggests guide channels for channels by name, with country hints
+type matcher struct {
+	channels []guideChannel
+	byKey    map[string][]int
+	byGram   map[string][]int
+}
+
+// newMatcher indexes the guide channel ids of a user
+func newMatcher(ids []string) *matcher {
+	m := &matcher{
+		channels: make([]guideChannel, 0, len(ids)),
+		byKey:    make(map[string][]int, len(ids)),
+		byGram:   make(map[string][]int),
+	}
+	for _, id := range ids {
+		name, country := splitGuideID(id)
+		key := matchKey(name)
+		if key == "" {
+			continue
+		}
+
+		i := len(m.channels)
+		grams := bigrams(key)
+		m.channels = append(m.channels, guideChannel{id: id, key: key, country: country, grams: len(grams)})
+		m.byKey[key] = append(m.byKey[key], i)
+		for gram := range grams {
+			m.byGram[gram] = append(m.byGram[gram], i)
+		}
+	}
+	return m
+}
+
+// Suggest returns the guide channels most like a channel, best first, with
+// a score of at least MinSuggestionScore. The channel's name, tvg_name and
+// tvg_id are compared to the guide ids; when the channel has a country,
+// guide channels of another country or of none score lower.
+func (m *matcher) Suggest(channel *models.Record) []Suggestion {
+	country := channelCountry(channel)
+
+	scores := make(map[int]float64)
+	for _, name := range channelNames(channel) {
+		key := matchKey(name)
+		if key == "" {
+			continue
+		}
+
+		for _, i := range m.byKey[key] {
+			scores[i] = 1
+		}
+
+		grams := bigrams(key)
+		shared := make(map[int]int)
+		for gram := range grams {
+			for _, i := range m.byGram[gram] {
+				shared[i]++
+			}
+		}
+		for i, n := range shared {
+			score := 2 * float64(n) / float64(len(grams)+m.channels[i].grams)
+			scores[i] = max(scores[i], score)
+		}
+	}
+
+	suggestions := make([]Suggestion, 0, MaxSuggestions)
+	for i, score := range scores {
+		guide := m.channels[i]
+		switch {
+		case country == "" || country == guide.country:
+		case guide.country == "":
+			score *= unknownCountryPenalty
+		default:
+			score *= countryPenalty
+		}
+		if score >= MinSuggestionScore {
+			suggestions = append(suggestions, Suggestion{EpgID: guide.id, Score: round(score)})
+		}
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Score != suggestions[j].Score {
+			return suggestions[i].Score > suggestions[j].Score
+		}
+		return suggestions[i].EpgID < suggestions[j].EpgID
+	})
+	if len(suggestions) > MaxSuggestions {
+		suggestions = suggestions[:MaxSuggestions]
+	}
+	return suggestions
+}
+
+// splitGuideID splits an XMLTV id into a name and its country, when it
+// ends with one: "BBCOne.uk@HD" is "BBC One" of "uk"
+func splitGuideID(id string) (name, country string) {
+	name = id
+	if i := strings.IndexByte(name, '@'); i > 0 {
+		name = name[:i]
+	}
+	if i := strings.LastIndexByte(name, '.'); i > 0 && countrySuffixPattern.MatchString(name[i+1:]) {
+		country = normalizeCountry(name[i+1:])
+		name = name[:i]
+	}
+	name = upperWordPattern.ReplaceAllString(name, "$1 $2")
+	name = lowerUpperPattern.ReplaceAllString(name, "$1 $2")
+	return name, country
+}
+
+// channelNames returns the names a channel may have in the guide
+func channelNames(channel *models.Record) []string {
+	names := []string{channel.GetString("name")}
+	if tvgName := channel.GetString("tvg_name"); tvgName != "" {
+		names = append(names, tvgName)
+	}
+	if tvgID := channel.GetString("tvg_id"); tvgID != "" {
+		name, _ := splitGuideID(tvgID)
+		names = append(names, name)
+	}
+	return names
+}
+
+// channelCountry returns the country of a channel: the prefix of its
+// name, or its country field
+func channelCountry(channel *models.Record) string {
+	if match := namePrefixPattern.FindStringSubmatch(channel.GetString("name")); match != nil {
+		return normalizeCountry(match[1])
+	}
+	if country := channel.GetString("country"); len(country) == 2 {
+		return normalizeCountry(country)
+	}
+	return ""
+}
+
+func normalizeCountry(code string) string {
+	code = strings.ToLower(code)
+	if alias, ok := countryAliases[code]; ok {
+		return alias
+	}
+	return code
+}
+
+// matchKey reduces a name to what a channel and its guide id have in
+// common: normalized as duplicates are, with numbers written in digits and
+// without spaces
+func matchKey(name string) string {
+	words := strings.Fields(dedupe.Normalize(name))
+	for i, word := range words {
+		if digits, ok := numberWords[word]; ok {
+			words[i] = digits
+		}
+	}
+	return strings.Join(words, "")
+}
+
+// bigrams returns the pairs of letters of a key
+func bigrams(key string) map[string]bool {
+	runes := []rune(key)
+	grams := make(map[string]bool, len(runes))
+	if len(runes) == 1 {
+		grams[key] = true
+	}
+	for i := 0; i+1 < len(runes); i++ {
+		grams[string(runes[i:i+2])] = true
+	}
+	return grams
+}
+
+func round(score float64) float64 {
+	return float64(int(score*100+0.5)) / 100
+}
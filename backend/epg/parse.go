@@ -0,0 +1,238 @@
+package epg
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// Formats of guide sources
+const (
+	FormatXMLTV = "xmltv"
+	FormatJSON  = "json"
+)
+
+// MaxGuideSize bounds a guide once decompressed
+const MaxGuideSize = 512 << 20
+
+var ErrFormat = errors.New("the guide is neither XMLTV nor JSON")
+
+// Programme is a programme of a guide as parsed
+type Programme struct {
+	Channel     string    `json:"channel"`
+	Title       string    `json:"title"`
+	Description string    `json:"description,omitempty"`
+	Start       time.Time `json:"start"`
+	Stop        time.Time `json:"stop"`
+	Category    string    `json:"category,omitempty"`
+	Icon        string    `json:"icon,omitempty"`
+	Rating      string    `json:"rating,omitempty"`
+	Episode     string    `json:"episode,omitempty"`
+}
+
+// Parse reads the programmes of an XMLTV or JSON guide, gzipped or not,
+// and returns them with the format found. JSON guides are an array of
+// programmes, or an object listing them in programs or programmes.
+func Parse(r io.Reader) ([]Programme, string, error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid gzip guide: %w", err)
+		}
+		defer gz.Close()
+		br = bufio.NewReader(gz)
+	}
+	limited := bufio.NewReader(io.LimitReader(br, MaxGuideSize))
+
+	switch firstByte(limited) {
+	case '<':
+		programmes, err := parseXMLTV(limited)
+		return programmes, FormatXMLTV, err
+	case '[', '{':
+		programmes, err := parseJSON(limited)
+		return programmes, FormatJSON, err
+	}
+	return nil, "", ErrFormat
+}
+
+// firstByte returns the first byte of r past a byte order mark and
+// whitespace, without consuming it
+func firstByte(r *bufio.Reader) byte {
+	for {
+		b, err := r.Peek(1)
+		if err != nil {
+			return 0
+		}
+		switch {
+		case b[0] == ' ' || b[0] == '\t' || b[0] == '\r' || b[0] == '\n':
+			r.ReadByte()
+		case b[0] == 0xef:
+			if bom, _ := r.Peek(3); bytes.Equal(bom, []byte{0xef, 0xbb, 0xbf}) {
+				r.Discard(3)
+				continue
+			}
+			return b[0]
+		default:
+			return b[0]
+		}
+	}
+}
+
+type xmltvText struct {
+	Value string `xml:",chardata"`
+}
+
+type xmltvProgramme struct {
+	Start      string      `xml:"start,attr"`
+	Stop       string      `xml:"stop,attr"`
+	Channel    string      `xml:"channel,attr"`
+	Titles     []xmltvText `xml:"title"`
+	Descs      []xmltvText `xml:"desc"`
+	Categories []xmltvText `xml:"category"`
+	Icon       struct {
+		Src string `xml:"src,attr"`
+	} `xml:"icon"`
+	Ratings []struct {
+		Value string `xml:"value"`
+	} `xml:"rating"`
+	Episodes []struct {
+		System string `xml:"system,attr"`
+		Value  string `xml:",chardata"`
+	} `xml:"episode-num"`
+}
+
+// parseXMLTV reads the programme elements of an XMLTV guide one at a time
+func parseXMLTV(r io.Reader) ([]Programme, error) {
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+	decoder.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		encoding, err := htmlindex.Get(label)
+		if err != nil {
+			return nil, err
+		}
+		return encoding.NewDecoder().Reader(input), nil
+	}
+
+	programmes := []Programme{}
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return programmes, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid XMLTV guide: %w", err)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "programme" {
+			continue
+		}
+		var element xmltvProgramme
+		if err := decoder.DecodeElement(&element, &start); err != nil {
+			return nil, fmt.Errorf("invalid XMLTV programme: %w", err)
+		}
+
+		programme := Programme{
+			Channel: element.Channel,
+			Start:   parseXMLTVTime(element.Start),
+			Stop:    parseXMLTVTime(element.Stop),
+			Icon:    element.Icon.Src,
+		}
+		if len(element.Titles) > 0 {
+			programme.Title = element.Titles[0].Value
+		}
+		if len(element.Descs) > 0 {
+			programme.Description = element.Descs[0].Value
+		}
+		if len(element.Categories) > 0 {
+			programme.Category = element.Categories[0].Value
+		}
+		if len(element.Ratings) > 0 {
+			programme.Rating = element.Ratings[0].Value
+		}
+		for _, episode := range element.Episodes {
+			switch episode.System {
+			case "onscreen":
+				programme.Episode = episode.Value
+			case "xmltv_ns":
+				if programme.Episode == "" {
+					programme.Episode = xmltvEpisode(episode.Value)
+				}
+			}
+		}
+		programmes = append(programmes, programme)
+	}
+}
+
+// parseXMLTVTime parses XMLTV times, "20240101203000 +0100", in UTC when
+// they have no offset. It returns the zero time when value is invalid.
+func parseXMLTVTime(value string) time.Time {
+	value = strings.TrimSpace(value)
+	for _, layout := range []string{"20060102150405 -0700", "20060102150405 -07:00", "20060102150405-0700", "20060102150405", "200601021504 -0700", "200601021504"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC()
+		}
+	}
+	return time.Time{}
+}
+
+// xmltvEpisode turns an xmltv_ns episode number, zero based
+// "season.episode.part" with optional totals, into "S01E05"
+func xmltvEpisode(value string) string {
+	parts := strings.Split(value, ".")
+	if len(parts) < 2 {
+		return ""
+	}
+	number := func(part string) (int, bool) {
+		part, _, _ = strings.Cut(strings.TrimSpace(part), "/")
+		n, err := strconv.Atoi(part)
+		return n + 1, err == nil
+	}
+
+	season, hasSeason := number(parts[0])
+	episode, hasEpisode := number(parts[1])
+	switch {
+	case hasSeason && hasEpisode:
+		return fmt.Sprintf("S%02dE%02d", season, episode)
+	case hasEpisode:
+		return fmt.Sprintf("E%02d", episode)
+	case hasSeason:
+		return fmt.Sprintf("S%02d", season)
+	}
+	return ""
+}
+
+// parseJSON reads a JSON guide
+func parseJSON(r io.Reader) ([]Programme, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	programmes := []Programme{}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		err = json.Unmarshal(data, &programmes)
+	} else {
+		var guide struct {
+			Programs   []Programme `json:"programs"`
+			Programmes []Programme `json:"programmes"`
+		}
+		err = json.Unmarshal(data, &guide)
+		programmes = append(guide.Programs, guide.Programmes...)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON guide: %w", err)
+	}
+	return programmes, nil
+}
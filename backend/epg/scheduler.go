@@ -0,0 +1,158 @@
+package epg
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"iptv-backend/jobs"
+	"iptv-backend/logging"
+)
+
+// ImportJobType is the background job type guide imports run as
+const ImportJobType = "epg.import"
+
+// Scheduler imports guide sources as background jobs, on demand and every
+// sync_interval hours for the active sources with a URL
+type Scheduler struct {
+	service *Service
+	jobs    *jobs.Manager
+	mu      sync.Mutex
+	stop    context.CancelFunc
+	logger  *slog.Logger
+}
+
+// NewScheduler creates a scheduler and registers the import job type on
+// jobManager
+func NewScheduler(service *Service, jobManager *jobs.Manager) *Scheduler {
+	s := &Scheduler{
+		service: service,
+		jobs:    jobManager,
+		logger:  logging.For("epg"),
+	}
+
+	jobManager.Register(ImportJobType, s.runJob, jobs.TypeOptions{
+		MaxAttempts: 3,
+		Concurrency: 1,
+		Timeout:     30 * time.Minute,
+	})
+
+	return s
+}
+
+// Sync queues an import of a source, or returns the one already queued or
+// running
+func (s *Scheduler) Sync(userID, sourceID string) (*jobs.Job, error) {
+	if job := s.Job(userID, sourceID); job != nil && !job.Finished() {
+		return job, nil
+	}
+	return s.jobs.Enqueue(ImportJobType, userID, map[string]string{"source_id": sourceID})
+}
+
+// Job returns the current or latest import of a source, nil when it was
+// never imported
+func (s *Scheduler) Job(userID, sourceID string) *jobs.Job {
+	for _, job := range s.jobs.List(jobs.ListFilter{User: userID, Type: ImportJobType, Limit: 50}) {
+		if sourceOf(job) == sourceID {
+			return job
+		}
+	}
+	return nil
+}
+
+// Start begins importing sources on their interval
+func (s *Scheduler) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s.mu.Lock()
+	if s.stop != nil {
+		s.stop()
+	}
+	s.stop = cancel
+	s.mu.Unlock()
+
+	go s.loop(ctx)
+}
+
+// Stop stops the schedule; imports already queued still complete
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stop != nil {
+		s.stop()
+		s.stop = nil
+	}
+}
+
+func (s *Scheduler) loop(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.runDue()
+		}
+	}
+}
+
+// runDue queues the imports of the sources last imported, or last tried,
+// more than sync_interval hours ago
+func (s *Scheduler) runDue() {
+	sources, err := s.service.app.Dao().FindRecordsByFilter(SourcesCollection,
+		"is_active = true && url != '' && sync_interval > 0", "", 0, 0)
+	if err != nil {
+		s.logger.Warn("failed to list guide sources", "error", err)
+		return
+	}
+
+	// Latest import of each source
+	latest := make(map[string]*jobs.Job)
+	for _, job := range s.jobs.List(jobs.ListFilter{Type: ImportJobType, Limit: 1000}) {
+		if id := sourceOf(job); latest[id] == nil {
+			latest[id] = job
+		}
+	}
+
+	now := time.Now()
+	for _, source := range sources {
+		last := source.GetDateTime("last_synced").Time()
+		if job := latest[source.Id]; job != nil {
+			if !job.Finished() {
+				continue
+			}
+			if job.CreatedAt.After(last) {
+				last = job.CreatedAt
+			}
+		}
+		interval := time.Duration(source.GetFloat("sync_interval") * float64(time.Hour))
+		if !last.IsZero() && now.Before(last.Add(interval)) {
+			continue
+		}
+
+		users := source.GetStringSlice("user")
+		if len(users) == 0 {
+			continue
+		}
+		if _, err := s.jobs.Enqueue(ImportJobType, users[0], map[string]string{"source_id": source.Id}); err != nil {
+			s.logger.Warn("failed to queue guide import", "source_id", source.Id, "error", err)
+		}
+	}
+}
+
+// runJob is the job handler importing a source
+func (s *Scheduler) runJob(ctx context.Context, job *jobs.Job, progress jobs.ProgressFunc) (interface{}, error) {
+	return s.service.Import(ctx, sourceOf(job), progress)
+}
+
+// sourceOf returns the source of an import job
+func sourceOf(job *jobs.Job) string {
+	payload := struct {
+		SourceID string `json:"source_id"`
+	}{}
+	job.DecodePayload(&payload)
+	return payload.SourceID
+}
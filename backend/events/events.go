@@ -0,0 +1,142 @@
+// Package events pushes what happens in the backend to clients over a
+// single WebSocket: recordings, jobs, playlist syncs, notifications and
+// channel health, each a topic clients subscribe to, plus streamed topics
+// following one resource, such as the subtitles of a session. Clients
+// react to events instead of polling every endpoint.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"iptv-backend/logging"
+)
+
+// Topics every client may subscribe to. Events of a topic are sent to the
+// users they concern only, or to everybody for system events.
+const (
+	TopicRecorder      = "recorder"      // Recordings started, paused, resumed, finished or failed
+	TopicJobs          = "jobs"          // Background jobs queued, running, retried and finished
+	TopicPlaylists     = "playlists"     // Playlist syncs finished or failed
+	TopicNotifications = "notifications" // Notifications, as sent to the user's channels
+	TopicChannels      = "channels"      // Channels going down or back up
+)
+
+// Topics lists the topics every client may subscribe to
+var Topics = []string{TopicRecorder, TopicJobs, TopicPlaylists, TopicNotifications, TopicChannels}
+
+// Event is a message sent to the subscribers of a topic
+type Event struct {
+	Topic string      `json:"topic,omitempty"`
+	Type  string      `json:"type"`
+	Data  interface{} `json:"data,omitempty"`
+	Time  time.Time   `json:"time"`
+}
+
+// SendFunc sends an event of a streamed topic to its subscriber
+type SendFunc func(eventType string, data interface{}) error
+
+// StreamFunc follows the resource key for a client subscribed to the topic
+// prefix:key, sending its events until ctx is done or it ends. params are
+// those of the subscription, such as the language of subtitles. An error
+// returned is sent to the client, such as the resource not existing.
+type StreamFunc func(ctx context.Context, user, key string, params map[string]string, send SendFunc) error
+
+// Hub tracks the connected clients and what they subscribed to
+type Hub struct {
+	mu      sync.RWMutex
+	clients map[*client]bool
+	streams map[string]StreamFunc // By topic prefix
+	logger  *slog.Logger
+}
+
+// NewHub creates a hub without clients
+func NewHub() *Hub {
+	return &Hub{
+		clients: make(map[*client]bool),
+		streams: make(map[string]StreamFunc),
+		logger:  logging.For("events"),
+	}
+}
+
+// Stream registers the streamed topics prefix:key, followed by fn for each
+// subscription
+func (h *Hub) Stream(prefix string, fn StreamFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.streams[prefix] = fn
+}
+
+// Publish sends an event to the clients of users subscribed to topic, to
+// every subscribed client without users. It never blocks: clients too slow
+// to take their events are disconnected, to reconnect and reload.
+func (h *Hub) Publish(topic, eventType string, data interface{}, users ...string) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if len(h.clients) == 0 {
+		return
+	}
+
+	message, err := json.Marshal(Event{Topic: topic, Type: eventType, Data: data, Time: time.Now()})
+	if err != nil {
+		h.logger.Warn("failed to encode event", "topic", topic, "type", eventType, "error", err)
+		return
+	}
+
+	for c := range h.clients {
+		if !c.subscribed(topic) || (len(users) > 0 && !contains(users, c.user)) {
+			continue
+		}
+		c.push(message)
+	}
+}
+
+// Clients returns how many clients are connected
+func (h *Hub) Clients() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.clients)
+}
+
+// stream returns the function following a streamed topic and its key
+func (h *Hub) stream(topic string) (StreamFunc, string, bool) {
+	prefix, key, found := strings.Cut(topic, ":")
+	if !found || key == "" {
+		return nil, "", false
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	fn, ok := h.streams[prefix]
+	return fn, key, ok
+}
+
+func (h *Hub) add(c *client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clients[c] = true
+}
+
+func (h *Hub) remove(c *client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.clients, c)
+}
+
+// known reports whether topic is one of Topics
+func known(topic string) bool {
+	return contains(Topics, topic)
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,266 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v5"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// sendBuffer is how many events may wait for a client before it is
+	// disconnected as too slow
+	sendBuffer = 256
+	// writeTimeout bounds writing one message
+	writeTimeout = 10 * time.Second
+	// keepaliveInterval is how often idle connections get a keepalive
+	// message, so proxies don't close them and dead clients are noticed
+	keepaliveInterval = 30 * time.Second
+	// maxTopics bounds the topics of a client, streamed ones included
+	maxTopics = 32
+	// maxMessageSize bounds the messages clients send
+	maxMessageSize = 64 << 10
+)
+
+// Request is a message clients send to change their subscriptions
+type Request struct {
+	Action string   `json:"action"` // subscribe or unsubscribe
+	Topics []string `json:"topics"`
+	// Params apply to the streamed topics subscribed to, such as lang and
+	// since_revision for subtitles
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// client is a connected WebSocket
+type client struct {
+	user   string
+	send   chan []byte
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	topics  map[string]bool
+	streams map[string]context.CancelFunc
+}
+
+// subscribed reports whether the client subscribed to a topic
+func (c *client) subscribed(topic string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.topics[topic]
+}
+
+// push queues a message, disconnecting the client when its queue is full
+func (c *client) push(message []byte) bool {
+	select {
+	case c.send <- message:
+		return true
+	case <-c.ctx.Done():
+		return false
+	default:
+		c.cancel()
+		return false
+	}
+}
+
+// reply queues an event not published on a topic, such as an error
+func (c *client) reply(topic, eventType string, data interface{}) {
+	message, err := json.Marshal(Event{Topic: topic, Type: eventType, Data: data, Time: time.Now()})
+	if err == nil {
+		c.push(message)
+	}
+}
+
+// Serve upgrades the request to a WebSocket of user, subscribed to the
+// comma-separated topics of ?topics= until it changes them
+func (h *Hub) Serve(c echo.Context, user string) error {
+	var initial []string
+	for _, topic := range strings.Split(c.QueryParam("topics"), ",") {
+		if topic = strings.TrimSpace(topic); topic != "" {
+			initial = append(initial, topic)
+		}
+	}
+
+	server := websocket.Server{
+		// Clients authenticate with a token rather than cookies, so pages of
+		// other origins can't connect on their behalf
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler: func(conn *websocket.Conn) {
+			h.serve(conn, user, initial)
+		},
+	}
+	server.ServeHTTP(c.Response(), c.Request())
+	return nil
+}
+
+// serve runs a connection until the client or the server closes it
+func (h *Hub) serve(conn *websocket.Conn, user string, initial []string) {
+	defer conn.Close()
+
+	// The deadlines of the HTTP server outlive the upgrade
+	conn.SetDeadline(time.Time{})
+	conn.MaxPayloadBytes = maxMessageSize
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &client{
+		user:    user,
+		send:    make(chan []byte, sendBuffer),
+		ctx:     ctx,
+		cancel:  cancel,
+		topics:  make(map[string]bool),
+		streams: make(map[string]context.CancelFunc),
+	}
+	h.add(c)
+	h.logger.Debug("client connected", "user", user, "clients", h.Clients())
+	defer func() {
+		h.remove(c)
+		cancel()
+		h.logger.Debug("client disconnected", "user", user, "clients", h.Clients())
+	}()
+
+	go h.write(conn, c)
+	if len(initial) > 0 {
+		h.handle(c, Request{Action: "subscribe", Topics: initial})
+	}
+
+	// Reading ends when the client goes away; closing the connection on
+	// cancel ends reading when the server drops the client
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	for {
+		var req Request
+		if err := websocket.JSON.Receive(conn, &req); err != nil {
+			var syntaxErr *json.SyntaxError
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+				c.reply("", "error", map[string]string{"message": "invalid message"})
+				continue
+			}
+			return
+		}
+		h.handle(c, req)
+	}
+}
+
+// write sends the queued messages of a client and keepalives in between
+func (h *Hub) write(conn *websocket.Conn, c *client) {
+	keepalive := time.NewTicker(keepaliveInterval)
+	defer keepalive.Stop()
+
+	for {
+		var message []byte
+		select {
+		case message = <-c.send:
+		case <-keepalive.C:
+			message = []byte(`{"type":"keepalive"}`)
+		case <-c.ctx.Done():
+			return
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if err := websocket.Message.Send(conn, string(message)); err != nil {
+			c.cancel()
+			return
+		}
+	}
+}
+
+// handle applies a subscription change and replies with the topics the
+// client is subscribed to
+func (h *Hub) handle(c *client, req Request) {
+	switch req.Action {
+	case "subscribe":
+		for _, topic := range req.Topics {
+			if err := h.subscribe(c, topic, req.Params); err != nil {
+				c.reply(topic, "error", map[string]string{"message": err.Error()})
+			}
+		}
+	case "unsubscribe":
+		for _, topic := range req.Topics {
+			h.unsubscribe(c, topic)
+		}
+	default:
+		c.reply("", "error", map[string]string{"message": fmt.Sprintf("unknown action %q, subscribe or unsubscribe", req.Action)})
+		return
+	}
+
+	c.mu.Lock()
+	topics := make([]string, 0, len(c.topics))
+	for topic := range c.topics {
+		topics = append(topics, topic)
+	}
+	c.mu.Unlock()
+	sort.Strings(topics)
+	c.reply("", "subscribed", map[string][]string{"topics": topics})
+}
+
+// subscribe adds a topic, starting to follow it when it is streamed
+func (h *Hub) subscribe(c *client, topic string, params map[string]string) error {
+	fn, key, streamed := h.stream(topic)
+	if !streamed && !known(topic) {
+		return fmt.Errorf("unknown topic %q", topic)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.topics[topic] {
+		return nil
+	}
+	if len(c.topics) >= maxTopics {
+		return fmt.Errorf("too many topics, at most %d", maxTopics)
+	}
+	c.topics[topic] = true
+	if !streamed {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(c.ctx)
+	c.streams[topic] = cancel
+	go func() {
+		send := func(eventType string, data interface{}) error {
+			message, err := json.Marshal(Event{Topic: topic, Type: eventType, Data: data, Time: time.Now()})
+			if err != nil {
+				return err
+			}
+			if !c.push(message) {
+				return context.Canceled
+			}
+			return nil
+		}
+		err := fn(ctx, c.user, key, params, send)
+		if err != nil && ctx.Err() == nil {
+			c.reply(topic, "error", map[string]string{"message": err.Error()})
+		}
+
+		// The resource ended, or couldn't be followed
+		c.mu.Lock()
+		if ctx.Err() == nil {
+			delete(c.topics, topic)
+			delete(c.streams, topic)
+		}
+		c.mu.Unlock()
+		cancel()
+	}()
+	return nil
+}
+
+// unsubscribe removes a topic, no longer following it when it is streamed
+func (h *Hub) unsubscribe(c *client, topic string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.topics, topic)
+	if cancel, ok := c.streams[topic]; ok {
+		cancel()
+		delete(c.streams, topic)
+	}
+}
@@ -0,0 +1,379 @@
+// Package favorites manages the favorite channels of a profile in bulk:
+// reordering them in one call, copying them to another profile and adding
+// them from a list of channel names, such as one exported from another
+// player. Favorites are records of the favorites collection, listed by
+// sort_order.
+package favorites
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+
+	"iptv-backend/playlist"
+)
+
+// Collection holds the favorites of every profile
+const Collection = "favorites"
+
+// MaxChannels bounds the channels or names of one call
+const MaxChannels = 10000
+
+var (
+	ErrEmpty       = errors.New("no channels given")
+	ErrTooLarge    = fmt.Errorf("too many channels, at most %d per call", MaxChannels)
+	ErrSameProfile = errors.New("favorites can't be copied to the same profile")
+)
+
+// NotFavoritesError lists the channels of a reorder that aren't favorites
+// of the profile. Nothing is reordered.
+type NotFavoritesError struct {
+	IDs []string
+}
+
+func (e *NotFavoritesError) Error() string {
+	return "channels not in the favorites: " + strings.Join(e.IDs, ", ")
+}
+
+// CopyResult summarizes a copy between profiles
+type CopyResult struct {
+	Copied  int `json:"copied"`
+	Skipped int `json:"skipped"` // Already favorites of the target, or hidden from it
+}
+
+// ImportResult summarizes an import of channel names
+type ImportResult struct {
+	Added    []string `json:"added"`     // Channels added, in the order of the names
+	Existing int      `json:"existing"`  // Names of channels already favorites
+	Skipped  int      `json:"skipped"`   // Names of channels hidden from the profile
+	NotFound []string `json:"not_found"` // Names matching none of the user's channels
+}
+
+// Reorder moves the favorite channels of a profile to the top of its
+// favorites, in the order given. The favorites left out follow in their
+// current order. It returns how many favorites were reordered.
+func Reorder(app core.App, profileID string, channelIDs []string) (int, error) {
+	ids := unique(channelIDs, nil)
+	if len(ids) == 0 {
+		return 0, ErrEmpty
+	}
+	if len(ids) > MaxChannels {
+		return 0, ErrTooLarge
+	}
+
+	dao := app.Dao()
+
+	favorites, err := list(dao, profileID)
+	if err != nil {
+		return 0, err
+	}
+	byChannel := make(map[string]*models.Record, len(favorites))
+	for _, favorite := range favorites {
+		if channel := channelOf(favorite); byChannel[channel] == nil {
+			byChannel[channel] = favorite
+		}
+	}
+
+	var missing []string
+	ordered := make([]*models.Record, 0, len(favorites))
+	listed := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		favorite, ok := byChannel[id]
+		if !ok {
+			missing = append(missing, id)
+			continue
+		}
+		ordered = append(ordered, favorite)
+		listed[favorite.Id] = true
+	}
+	if len(missing) > 0 {
+		return 0, &NotFavoritesError{IDs: missing}
+	}
+	for _, favorite := range favorites {
+		if !listed[favorite.Id] {
+			ordered = append(ordered, favorite)
+		}
+	}
+
+	err = dao.RunInTransaction(func(txDao *daos.Dao) error {
+		for i, favorite := range ordered {
+			if favorite.GetInt("sort_order") == i {
+				continue
+			}
+			favorite.Set("sort_order", i)
+			if err := txDao.SaveRecord(favorite); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(ids), nil
+}
+
+// Copy adds the favorites of a profile to another one, after its own and in
+// the same order. With replace the favorites of the target are removed
+// first. Channels keep rejects, such as those hidden by the target's
+// parental controls, are skipped; keep may be nil.
+func Copy(app core.App, fromID, toID string, replace bool, keep func(channel *models.Record) bool) (*CopyResult, error) {
+	if fromID == toID {
+		return nil, ErrSameProfile
+	}
+
+	dao := app.Dao()
+
+	source, err := list(dao, fromID)
+	if err != nil {
+		return nil, err
+	}
+	channelIDs := make([]string, len(source))
+	for i, favorite := range source {
+		channelIDs[i] = channelOf(favorite)
+	}
+	channelIDs = unique(channelIDs, nil)
+
+	channels, err := channelsByID(dao, channelIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &CopyResult{}
+	err = dao.RunInTransaction(func(txDao *daos.Dao) error {
+		target, err := list(txDao, toID)
+		if err != nil {
+			return err
+		}
+		if replace {
+			for _, favorite := range target {
+				if err := txDao.DeleteRecord(favorite); err != nil {
+					return err
+				}
+			}
+			target = nil
+		}
+
+		existing := make(map[string]bool, len(target))
+		for _, favorite := range target {
+			existing[channelOf(favorite)] = true
+		}
+
+		position := nextPosition(target)
+		for _, id := range channelIDs {
+			channel, ok := channels[id]
+			if !ok || existing[id] || (keep != nil && !keep(channel)) {
+				result.Skipped++
+				continue
+			}
+			if err := add(txDao, toID, id, position); err != nil {
+				return err
+			}
+			position++
+			result.Copied++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Import adds the user's channels named in names to the favorites of a
+// profile, after its own and in the order of the names. Names match
+// channel names whatever their case; when several channels share a name,
+// an active one comes first, then the first by position. A source merged
+// into a logical channel stands for it.
+func Import(app core.App, userID, profileID string, names []string, keep func(channel *models.Record) bool) (*ImportResult, error) {
+	names = unique(names, strings.ToLower)
+	if len(names) == 0 {
+		return nil, ErrEmpty
+	}
+	if len(names) > MaxChannels {
+		return nil, ErrTooLarge
+	}
+
+	dao := app.Dao()
+
+	matches, err := channelsByName(dao, userID, names)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ImportResult{Added: []string{}, NotFound: []string{}}
+	err = dao.RunInTransaction(func(txDao *daos.Dao) error {
+		target, err := list(txDao, profileID)
+		if err != nil {
+			return err
+		}
+		existing := make(map[string]bool, len(target))
+		for _, favorite := range target {
+			existing[channelOf(favorite)] = true
+		}
+
+		position := nextPosition(target)
+		for _, name := range names {
+			channel, ok := matches[strings.ToLower(name)]
+			switch {
+			case !ok:
+				result.NotFound = append(result.NotFound, name)
+				continue
+			case existing[channel.Id]:
+				result.Existing++
+				continue
+			case keep != nil && !keep(channel):
+				result.Skipped++
+				continue
+			}
+
+			if err := add(txDao, profileID, channel.Id, position); err != nil {
+				return err
+			}
+			existing[channel.Id] = true
+			position++
+			result.Added = append(result.Added, channel.Id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// list returns the favorites of a profile in their order
+func list(dao *daos.Dao, profileID string) ([]*models.Record, error) {
+	return dao.FindRecordsByFilter(Collection, "profile ~ {:profile}", "sort_order,created", 0, 0,
+		dbx.Params{"profile": profileID})
+}
+
+// add saves a favorite of a profile
+func add(dao *daos.Dao, profileID, channelID string, position int) error {
+	collection, err := dao.FindCollectionByNameOrId(Collection)
+	if err != nil {
+		return err
+	}
+
+	favorite := models.NewRecord(collection)
+	favorite.Set("profile", profileID)
+	favorite.Set("channel", channelID)
+	favorite.Set("sort_order", position)
+	return dao.SaveRecord(favorite)
+}
+
+// nextPosition returns the position after the last of favorites
+func nextPosition(favorites []*models.Record) int {
+	position := 0
+	for _, favorite := range favorites {
+		position = max(position, favorite.GetInt("sort_order")+1)
+	}
+	return position
+}
+
+// channelOf returns the channel of a favorite. Relation fields may be
+// stored as arrays.
+func channelOf(favorite *models.Record) string {
+	if channels := favorite.GetStringSlice("channel"); len(channels) > 0 {
+		return channels[0]
+	}
+	return ""
+}
+
+// channelsByID loads channels by their id
+func channelsByID(dao *daos.Dao, ids []string) (map[string]*models.Record, error) {
+	records, err := dao.FindRecordsByIds("channels", ids)
+	if err != nil {
+		return nil, err
+	}
+
+	channels := make(map[string]*models.Record, len(records))
+	for _, record := range records {
+		channels[record.Id] = record
+	}
+	return channels, nil
+}
+
+// channelsByName finds the channels of the user's playlists named in
+// names, by lowercase name
+func channelsByName(dao *daos.Dao, userID string, names []string) (map[string]*models.Record, error) {
+	owned, err := playlist.OwnedIDs(dao, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite only lowercases ASCII letters, names in other scripts match
+	// when stored as given or in lowercase
+	values := make([]interface{}, 0, 2*len(names))
+	for _, name := range names {
+		values = append(values, name)
+		if lower := strings.ToLower(name); lower != name {
+			values = append(values, lower)
+		}
+	}
+	records := []*models.Record{}
+	err = dao.RecordQuery("channels").
+		AndWhere(dbx.Or(dbx.In("lower(name)", values...), dbx.In("name", values...))).
+		OrderBy("[[merged_into]] = '' DESC", "is_active DESC", "sort_order ASC").
+		All(&records)
+	if err != nil {
+		return nil, err
+	}
+
+	var primaryIDs []string
+	for _, record := range records {
+		if into := record.GetString("merged_into"); into != "" {
+			primaryIDs = append(primaryIDs, into)
+		}
+	}
+	primaries, err := channelsByID(dao, unique(primaryIDs, nil))
+	if err != nil {
+		return nil, err
+	}
+
+	channels := make(map[string]*models.Record, len(names))
+	for _, record := range records {
+		if !slices.ContainsFunc(record.GetStringSlice("playlist"), func(id string) bool { return owned[id] }) {
+			continue
+		}
+		name := strings.ToLower(strings.TrimSpace(record.GetString("name")))
+		if _, ok := channels[name]; ok {
+			continue
+		}
+		if into := record.GetString("merged_into"); into != "" {
+			record = primaries[into]
+		}
+		if record != nil {
+			channels[name] = record
+		}
+	}
+	return channels, nil
+}
+
+// unique returns the trimmed values without empty and duplicated ones,
+// compared by key when not nil
+func unique(values []string, key func(string) string) []string {
+	result := make([]string, 0, len(values))
+	seen := make(map[string]bool, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		k := value
+		if key != nil {
+			k = key(value)
+		}
+		if value != "" && !seen[k] {
+			seen[k] = true
+			result = append(result, value)
+		}
+	}
+	return result
+}
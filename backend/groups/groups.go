@@ -0,0 +1,658 @@
+// Package groups lets users organize their channels beyond the groups of
+// their providers. A group of channel_groups lists the channels of provider
+// groups under its own name, renaming one or merging several, and can pick
+// channels of any group, such as a "Sports HD" group made of the HD
+// channels of several provider groups; picked channels stay in their own
+// group too. Channel lists show channels under the name of their group.
+package groups
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+
+	"iptv-backend/playlist"
+)
+
+// Collection holds the groups of every user
+const Collection = "channel_groups"
+
+const (
+	// MaxNameLength bounds group names, as provider group titles
+	MaxNameLength = 100
+
+	// MaxChannels bounds the channels picked by a group
+	MaxChannels = 10000
+)
+
+// Fields added to channel records of list and view responses
+const (
+	ProviderGroupField = "provider_group" // Group of the provider, when renamed
+	VirtualGroupsField = "virtual_groups" // Groups picking the channel
+)
+
+var (
+	ErrNotFound  = errors.New("group not found")
+	ErrName      = fmt.Errorf("the name must be 1 to %d characters", MaxNameLength)
+	ErrNameTaken = errors.New("another group has this name")
+	ErrEmpty     = errors.New("a group needs provider groups or channels")
+	ErrTooLarge  = fmt.Errorf("too many channels, at most %d per group", MaxChannels)
+	ErrSameName  = errors.New("the group already has this name")
+)
+
+// ClaimedError is returned when a provider group is already listed under
+// another group. A provider group can only be renamed once.
+type ClaimedError struct {
+	ProviderGroup string
+	Group         string
+}
+
+func (e *ClaimedError) Error() string {
+	return fmt.Sprintf("provider group %q is already listed under %q", e.ProviderGroup, e.Group)
+}
+
+// MissingChannelsError lists the channels picked by a group that don't
+// exist or belong to playlists of another user. Nothing is saved.
+type MissingChannelsError struct {
+	IDs []string
+}
+
+func (e *MissingChannelsError) Error() string {
+	return "channels not found: " + strings.Join(e.IDs, ", ")
+}
+
+// Input is a group as created or changed
+type Input struct {
+	Name           string   `json:"name"`
+	ProviderGroups []string `json:"provider_groups"` // Listed under Name instead of their own
+	Channels       []string `json:"channels"`        // Picked from any group
+	SortOrder      int      `json:"sort_order"`
+}
+
+// Group is a group as the user's channel lists show it: one of channel_groups,
+// or a provider group left as is
+type Group struct {
+	ID             string   `json:"id,omitempty"` // Empty for provider groups
+	Name           string   `json:"name"`
+	ProviderGroups []string `json:"provider_groups"`
+	Channels       []string `json:"channels"`
+	SortOrder      int      `json:"sort_order"`
+	Count          int      `json:"count"` // Active channels listed, merged sources aside
+}
+
+// Service manages the groups of channel_groups
+type Service struct {
+	app core.App
+}
+
+// NewService returns a group service
+func NewService(app core.App) *Service {
+	return &Service{app: app}
+}
+
+// Mapping is how the channels of a user are grouped
+type Mapping struct {
+	names   map[string]string   // Lowercase provider group to the group listing it
+	virtual map[string][]string // Channel to the groups picking it
+}
+
+// Mapping loads the groups of a user
+func (s *Service) Mapping(userID string) (*Mapping, error) {
+	records, err := s.records(s.app.Dao(), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Mapping{names: make(map[string]string), virtual: make(map[string][]string)}
+	for _, record := range records {
+		name := record.GetString("name")
+		for _, group := range providerGroups(record) {
+			m.names[strings.ToLower(group)] = name
+		}
+		for _, id := range record.GetStringSlice("channels") {
+			m.virtual[id] = append(m.virtual[id], name)
+		}
+	}
+	return m, nil
+}
+
+// Empty reports whether the user has no groups
+func (m *Mapping) Empty() bool {
+	return len(m.names) == 0 && len(m.virtual) == 0
+}
+
+// Name returns the group listing the channels of a provider group
+func (m *Mapping) Name(providerGroup string) string {
+	if name, ok := m.names[strings.ToLower(providerGroup)]; ok {
+		return name
+	}
+	return providerGroup
+}
+
+// Apply shows channel records under their group: group_title becomes the
+// name of the group listing them, with the provider's in provider_group,
+// and virtual_groups lists the groups picking them. Records are only
+// changed for the response, never saved.
+func (m *Mapping) Apply(records ...*models.Record) {
+	for _, record := range records {
+		if record == nil || record.Collection().Name != "channels" {
+			continue
+		}
+
+		group := record.GetString("group_title")
+		record.Set(ProviderGroupField, group)
+		record.Set("group_title", m.Name(group))
+		virtual := m.virtual[record.Id]
+		if virtual == nil {
+			virtual = []string{}
+		}
+		record.Set(VirtualGroupsField, virtual)
+		record.WithUnknownData(true)
+	}
+}
+
+// ApplyRecords applies the groups of the signed in user to the channel
+// records of a list or view response
+func (s *Service) ApplyRecords(c echo.Context, records ...*models.Record) {
+	authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+	if authRecord == nil || !slices.ContainsFunc(records, isChannel) {
+		return
+	}
+
+	m, err := s.Mapping(authRecord.Id)
+	if err != nil || m.Empty() {
+		return
+	}
+	m.Apply(records...)
+}
+
+// List returns the groups of a user's channel lists: the user's groups by
+// sort_order and name, then the provider groups they leave as is by name
+func (s *Service) List(userID string) ([]Group, error) {
+	dao := s.app.Dao()
+
+	records, err := s.records(dao, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	counts, err := s.providerCounts(dao, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	active, err := s.activeChannels(dao, records)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]Group, 0, len(records)+len(counts))
+	claimed := make(map[string]bool)
+	for _, record := range records {
+		group := toGroup(record)
+		for _, providerGroup := range group.ProviderGroups {
+			claimed[strings.ToLower(providerGroup)] = true
+			group.Count += counts[strings.ToLower(providerGroup)].count
+		}
+		for _, id := range group.Channels {
+			// Picked channels already listed through a provider group
+			// count once
+			if active[id] != nil && !slices.ContainsFunc(group.ProviderGroups, func(g string) bool {
+				return strings.EqualFold(g, active[id].GetString("group_title"))
+			}) {
+				group.Count++
+			}
+		}
+		list = append(list, group)
+	}
+
+	provider := make([]Group, 0, len(counts))
+	for key, count := range counts {
+		if claimed[key] {
+			continue
+		}
+		provider = append(provider, Group{
+			Name:           count.title,
+			ProviderGroups: []string{count.title},
+			Channels:       []string{},
+			Count:          count.count,
+		})
+	}
+	sort.Slice(provider, func(i, j int) bool {
+		return strings.ToLower(provider[i].Name) < strings.ToLower(provider[j].Name)
+	})
+
+	return append(list, provider...), nil
+}
+
+// Create saves a new group of the user
+func (s *Service) Create(userID string, input Input) (*Group, error) {
+	var id string
+	err := s.app.Dao().RunInTransaction(func(txDao *daos.Dao) error {
+		collection, err := txDao.FindCollectionByNameOrId(Collection)
+		if err != nil {
+			return err
+		}
+
+		record := models.NewRecord(collection)
+		record.Set("user", userID)
+		if err := s.save(txDao, userID, record, input); err != nil {
+			return err
+		}
+		id = record.Id
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.listed(userID, id)
+}
+
+// Update replaces a group of the user
+func (s *Service) Update(userID, id string, input Input) (*Group, error) {
+	err := s.app.Dao().RunInTransaction(func(txDao *daos.Dao) error {
+		record, err := s.find(txDao, userID, id)
+		if err != nil {
+			return err
+		}
+		return s.save(txDao, userID, record, input)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.listed(userID, id)
+}
+
+// listed returns a group of the user as List does
+func (s *Service) listed(userID, id string) (*Group, error) {
+	list, err := s.List(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, group := range list {
+		if group.ID == id {
+			return &group, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Delete removes a group of the user. Its provider groups are listed
+// under their own name again.
+func (s *Service) Delete(userID, id string) error {
+	dao := s.app.Dao()
+
+	record, err := s.find(dao, userID, id)
+	if err != nil {
+		return err
+	}
+	return dao.DeleteRecord(record)
+}
+
+// Rename lists a group under a new name. A provider group gets a group of
+// its own, or joins the group already named to.
+func (s *Service) Rename(userID, from, to string) (*Group, error) {
+	from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+	if from == "" {
+		return nil, ErrNotFound
+	}
+	if from == to {
+		return nil, ErrSameName
+	}
+
+	var id string
+	err := s.app.Dao().RunInTransaction(func(txDao *daos.Dao) error {
+		records, err := s.records(txDao, userID)
+		if err != nil {
+			return err
+		}
+
+		if source := byName(records, from); source != nil {
+			input := toInput(source)
+			input.Name = to
+			if err := s.save(txDao, userID, source, input); err != nil {
+				return err
+			}
+			id = source.Id
+			return nil
+		}
+
+		merged, err := s.merge(txDao, userID, records, to, []string{from})
+		if err != nil {
+			return err
+		}
+		id = merged.Id
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.listed(userID, id)
+}
+
+// Merge lists the channels of several groups under one, named into: the
+// group already named into, or a new one. The user's groups merged are
+// removed, their provider groups and channels moving to into.
+func (s *Service) Merge(userID, into string, names []string) (*Group, error) {
+	var id string
+	err := s.app.Dao().RunInTransaction(func(txDao *daos.Dao) error {
+		records, err := s.records(txDao, userID)
+		if err != nil {
+			return err
+		}
+
+		merged, err := s.merge(txDao, userID, records, strings.TrimSpace(into), names)
+		if err != nil {
+			return err
+		}
+		id = merged.Id
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.listed(userID, id)
+}
+
+func (s *Service) merge(dao *daos.Dao, userID string, records []*models.Record, into string, names []string) (*models.Record, error) {
+	target := byName(records, into)
+	if target == nil {
+		collection, err := dao.FindCollectionByNameOrId(Collection)
+		if err != nil {
+			return nil, err
+		}
+		target = models.NewRecord(collection)
+		target.Set("user", userID)
+	}
+
+	input := toInput(target)
+	input.Name = into
+
+	var absorbed []*models.Record
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		source := byName(records, name)
+		switch {
+		case source == nil:
+			input.ProviderGroups = append(input.ProviderGroups, name)
+		case source != target:
+			input.ProviderGroups = append(input.ProviderGroups, providerGroups(source)...)
+			input.Channels = append(input.Channels, source.GetStringSlice("channels")...)
+			absorbed = append(absorbed, source)
+		}
+	}
+
+	// Absorbed groups give up their provider groups before they are claimed
+	for _, source := range absorbed {
+		if err := dao.DeleteRecord(source); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.save(dao, userID, target, input); err != nil {
+		return nil, err
+	}
+	return target, nil
+}
+
+// save validates input and saves it into record
+func (s *Service) save(dao *daos.Dao, userID string, record *models.Record, input Input) error {
+	name := strings.TrimSpace(input.Name)
+	if name == "" || len([]rune(name)) > MaxNameLength {
+		return ErrName
+	}
+
+	provider := unique(input.ProviderGroups)
+	for _, group := range provider {
+		if len([]rune(group)) > MaxNameLength {
+			return fmt.Errorf("provider group %q is longer than %d characters", group, MaxNameLength)
+		}
+	}
+	channels := unique(input.Channels)
+	if len(provider) == 0 && len(channels) == 0 {
+		return ErrEmpty
+	}
+	if len(channels) > MaxChannels {
+		return ErrTooLarge
+	}
+
+	others, err := s.records(dao, userID)
+	if err != nil {
+		return err
+	}
+	for _, other := range others {
+		if other.Id == record.Id {
+			continue
+		}
+		if strings.EqualFold(other.GetString("name"), name) {
+			return ErrNameTaken
+		}
+		for _, group := range provider {
+			if slices.ContainsFunc(providerGroups(other), func(g string) bool { return strings.EqualFold(g, group) }) {
+				return &ClaimedError{ProviderGroup: group, Group: other.GetString("name")}
+			}
+		}
+	}
+
+	channels, err = s.ownedChannels(dao, userID, channels)
+	if err != nil {
+		return err
+	}
+
+	record.Set("name", name)
+	record.Set("provider_groups", provider)
+	record.Set("channels", channels)
+	record.Set("sort_order", input.SortOrder)
+	return dao.SaveRecord(record)
+}
+
+// ownedChannels checks the channels belong to the user's playlists, and
+// replaces merged sources with their logical channel
+func (s *Service) ownedChannels(dao *daos.Dao, userID string, ids []string) ([]string, error) {
+	if len(ids) == 0 {
+		return []string{}, nil
+	}
+
+	owned, err := playlist.OwnedIDs(dao, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := dao.FindRecordsByIds("channels", ids)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]*models.Record, len(records))
+	for _, record := range records {
+		if slices.ContainsFunc(record.GetStringSlice("playlist"), func(id string) bool { return owned[id] }) {
+			found[record.Id] = record
+		}
+	}
+
+	var missing []string
+	for _, id := range ids {
+		if found[id] == nil {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, &MissingChannelsError{IDs: missing}
+	}
+
+	result := make([]string, 0, len(ids))
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if into := found[id].GetString("merged_into"); into != "" {
+			id = into
+		}
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	return result, nil
+}
+
+// providerCount is the number of channels of a provider group
+type providerCount struct {
+	title string
+	count int
+}
+
+// providerCounts counts the active channels of the user's provider groups,
+// by lowercase title. Merged sources are listed with their logical channel.
+func (s *Service) providerCounts(dao *daos.Dao, userID string) (map[string]providerCount, error) {
+	owned, err := playlist.OwnedIDs(dao, userID)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]providerCount)
+	if len(owned) == 0 {
+		return counts, nil
+	}
+
+	params := dbx.Params{}
+	placeholders := make([]string, 0, len(owned))
+	for id := range owned {
+		name := fmt.Sprintf("playlist%d", len(placeholders))
+		params[name] = id
+		placeholders = append(placeholders, "{:"+name+"}")
+	}
+
+	// Relation fields may be stored as a single id or as an array of ids
+	rows := []struct {
+		Title string `db:"title"`
+		Count int    `db:"count"`
+	}{}
+	err = dao.DB().NewQuery(
+		"SELECT COALESCE(c.group_title, '') AS title, count(*) AS count FROM channels c" +
+			" WHERE c.merged_into = '' AND c.is_active = TRUE" +
+			" AND EXISTS (SELECT 1 FROM json_each(CASE WHEN json_valid(c.playlist) THEN c.playlist ELSE json_array(c.playlist) END)" +
+			" WHERE json_each.value IN (" + strings.Join(placeholders, ", ") + "))" +
+			" GROUP BY c.group_title").
+		Bind(params).
+		All(&rows)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		key := strings.ToLower(row.Title)
+		count := counts[key]
+		if count.title == "" {
+			count.title = row.Title
+		}
+		count.count += row.Count
+		counts[key] = count
+	}
+	return counts, nil
+}
+
+// activeChannels loads the active channels picked by groups, by id
+func (s *Service) activeChannels(dao *daos.Dao, records []*models.Record) (map[string]*models.Record, error) {
+	var ids []string
+	for _, record := range records {
+		ids = append(ids, record.GetStringSlice("channels")...)
+	}
+	channels := make(map[string]*models.Record, len(ids))
+	if len(ids) == 0 {
+		return channels, nil
+	}
+
+	found, err := dao.FindRecordsByIds("channels", unique(ids))
+	if err != nil {
+		return nil, err
+	}
+	for _, channel := range found {
+		if channel.GetBool("is_active") {
+			channels[channel.Id] = channel
+		}
+	}
+	return channels, nil
+}
+
+// records returns the groups of a user by sort_order and name
+func (s *Service) records(dao *daos.Dao, userID string) ([]*models.Record, error) {
+	return dao.FindRecordsByFilter(Collection, "user = {:user}", "sort_order,name", 0, 0,
+		dbx.Params{"user": userID})
+}
+
+// find returns a group of the user
+func (s *Service) find(dao *daos.Dao, userID, id string) (*models.Record, error) {
+	record, err := dao.FindRecordById(Collection, id)
+	if err != nil || record.GetString("user") != userID {
+		return nil, ErrNotFound
+	}
+	return record, nil
+}
+
+// byName returns the group named name, whatever its case
+func byName(records []*models.Record, name string) *models.Record {
+	for _, record := range records {
+		if strings.EqualFold(record.GetString("name"), name) {
+			return record
+		}
+	}
+	return nil
+}
+
+func providerGroups(record *models.Record) []string {
+	var groups []string
+	record.UnmarshalJSONField("provider_groups", &groups)
+	return groups
+}
+
+func toGroup(record *models.Record) Group {
+	provider := providerGroups(record)
+	if provider == nil {
+		provider = []string{}
+	}
+	return Group{
+		ID:             record.Id,
+		Name:           record.GetString("name"),
+		ProviderGroups: provider,
+		Channels:       record.GetStringSlice("channels"),
+		SortOrder:      record.GetInt("sort_order"),
+	}
+}
+
+func toInput(record *models.Record) Input {
+	return Input{
+		Name:           record.GetString("name"),
+		ProviderGroups: providerGroups(record),
+		Channels:       record.GetStringSlice("channels"),
+		SortOrder:      record.GetInt("sort_order"),
+	}
+}
+
+func isChannel(record *models.Record) bool {
+	return record != nil && record.Collection().Name == "channels"
+}
+
+// unique returns the trimmed values without empty ones and duplicates,
+// whatever their case
+func unique(values []string) []string {
+	result := make([]string, 0, len(values))
+	seen := make(map[string]bool, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		key := strings.ToLower(value)
+		if value != "" && !seen[key] {
+			seen[key] = true
+			result = append(result, value)
+		}
+	}
+	return result
+}
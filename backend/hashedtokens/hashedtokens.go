@@ -0,0 +1,126 @@
+// Package hashedtokens stores the long-lived secrets users hand to scripts
+// and players, such as API keys and stream tokens. A secret is only shown
+// when it is created: its record keeps the SHA-256 of it, and its first
+// characters so users can tell their secrets apart.
+package hashedtokens
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Fields every collection of secrets has, next to its hash field
+const (
+	UserField       = "user"
+	PrefixField     = "prefix"
+	LastUsedAtField = "last_used_at"
+)
+
+// shownLength is how many characters of a secret, after its prefix, are
+// kept in clear
+const shownLength = 6
+
+// lastUseDelay is how stale last_used_at may get, so a secret in use isn't
+// saved again on every request
+const lastUseDelay = time.Minute
+
+// ErrNotFound is returned for secrets that don't exist or belong to another
+// user
+var ErrNotFound = errors.New("token not found")
+
+// Store creates, finds and revokes the secrets of a collection
+type Store struct {
+	app        core.App
+	logger     *slog.Logger
+	collection string
+	hashField  string
+	prefix     string
+}
+
+// NewStore returns a store of secrets kept in collection, hashed in
+// hashField. prefix starts every secret, so one found in a config or a leak
+// tells what it opens.
+func NewStore(app core.App, logger *slog.Logger, collection, hashField, prefix string) *Store {
+	return &Store{app: app, logger: logger, collection: collection, hashField: hashField, prefix: prefix}
+}
+
+// Create generates a secret for a user and saves its record, with fields
+// set on it. It returns the secret, which can't be read back later.
+func (s *Store) Create(userID string, fields map[string]any) (string, *models.Record, error) {
+	collection, err := s.app.Dao().FindCollectionByNameOrId(s.collection)
+	if err != nil {
+		return "", nil, err
+	}
+
+	random := make([]byte, 24)
+	if _, err := rand.Read(random); err != nil {
+		return "", nil, err
+	}
+	secret := s.prefix + hex.EncodeToString(random)
+
+	record := models.NewRecord(collection)
+	for field, value := range fields {
+		record.Set(field, value)
+	}
+	record.Set(UserField, userID)
+	record.Set(s.hashField, hash(secret))
+	record.Set(PrefixField, secret[:len(s.prefix)+shownLength])
+	if err := s.app.Dao().SaveRecord(record); err != nil {
+		return "", nil, err
+	}
+	return secret, record, nil
+}
+
+// List returns the records of a user's secrets, newest first
+func (s *Store) List(userID string) ([]*models.Record, error) {
+	return s.app.Dao().FindRecordsByFilter(s.collection, UserField+" = {:user}", "-created", 0, 0, dbx.Params{"user": userID})
+}
+
+// Revoke deletes a secret of a user
+func (s *Store) Revoke(userID, id string) error {
+	record, err := s.app.Dao().FindRecordById(s.collection, id)
+	if err != nil || record.GetString(UserField) != userID {
+		return ErrNotFound
+	}
+	return s.app.Dao().DeleteRecord(record)
+}
+
+// Find returns the record of a secret
+func (s *Store) Find(secret string) (*models.Record, error) {
+	if secret == "" {
+		return nil, ErrNotFound
+	}
+	record, err := s.app.Dao().FindFirstRecordByData(s.collection, s.hashField, hash(secret))
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return record, nil
+}
+
+// Touch records that a secret was used, unless it was already less than a
+// minute ago
+func (s *Store) Touch(record *models.Record) {
+	if time.Since(record.GetDateTime(LastUsedAtField).Time()) < lastUseDelay {
+		return
+	}
+
+	now, _ := types.ParseDateTime(time.Now())
+	record.Set(LastUsedAtField, now)
+	if err := s.app.Dao().SaveRecord(record); err != nil {
+		s.logger.Debug("failed to update last use", "collection", s.collection, "id", record.Id, "error", err)
+	}
+}
+
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,274 @@
+// Package health checks the programs and services the server depends on:
+// binaries it runs, such as ffmpeg, and servers it calls, such as Ollama.
+// Each check says what to do when it fails, so a misconfigured deployment
+// is caught at a glance rather than through failing requests.
+package health
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Outcomes of a check
+const (
+	StatusOK       = "ok"
+	StatusError    = "error"
+	StatusDisabled = "disabled" // Not used by the current configuration
+)
+
+// Overall states of a report
+const (
+	Healthy   = "healthy"
+	Degraded  = "degraded"  // An optional dependency failed
+	Unhealthy = "unhealthy" // A required dependency failed
+)
+
+// checkTimeout bounds each check
+const checkTimeout = 5 * time.Second
+
+// ProbeFunc runs a check. It returns the version found, if any, and what
+// was checked, such as the path of a binary or a URL.
+type ProbeFunc func(ctx context.Context) (version, target string, err error)
+
+// Check is a dependency to check
+type Check struct {
+	Name     string
+	Required bool // Its failure makes the server unhealthy rather than degraded
+	// Disabled, when set, is why the check doesn't apply and isn't run
+	Disabled string
+	Probe    ProbeFunc
+	// Fix tells the administrator what to do when the check fails
+	Fix string
+}
+
+// Result is the outcome of a check
+type Result struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	Required   bool   `json:"required"`
+	Version    string `json:"version,omitempty"`
+	Target     string `json:"target,omitempty"`
+	Error      string `json:"error,omitempty"`
+	Fix        string `json:"fix,omitempty"`
+	Note       string `json:"note,omitempty"` // Why a check is disabled
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// Report is the outcome of all checks
+type Report struct {
+	Status string    `json:"status"`
+	Time   time.Time `json:"time"`
+	Checks []Result  `json:"checks"`
+}
+
+// Run runs checks concurrently and returns their results in order
+func Run(ctx context.Context, checks []Check) Report {
+	report := Report{Status: Healthy, Time: time.Now(), Checks: make([]Result, len(checks))}
+
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		result := Result{Name: check.Name, Required: check.Required}
+		if check.Disabled != "" {
+			result.Status = StatusDisabled
+			result.Note = check.Disabled
+			report.Checks[i] = result
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, check Check, result Result) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+
+			start := time.Now()
+			version, target, err := check.Probe(ctx)
+			result.DurationMs = time.Since(start).Milliseconds()
+			result.Version = version
+			result.Target = target
+			result.Status = StatusOK
+			if err != nil {
+				result.Status = StatusError
+				result.Error = err.Error()
+				result.Fix = check.Fix
+			}
+			report.Checks[i] = result
+		}(i, check, result)
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if result.Status != StatusError {
+			continue
+		}
+		if result.Required {
+			report.Status = Unhealthy
+			break
+		}
+		report.Status = Degraded
+	}
+	return report
+}
+
+// Checker caches the report of checks, so frequent health probes don't
+// run programs every time
+type Checker struct {
+	checks func() []Check // Called per run, to follow configuration changes
+	ttl    time.Duration
+
+	mu     sync.Mutex
+	report *Report
+}
+
+// NewChecker creates a checker running the checks returned by checks,
+// whose report is reused for ttl
+func NewChecker(checks func() []Check, ttl time.Duration) *Checker {
+	return &Checker{checks: checks, ttl: ttl}
+}
+
+// Report returns the cached report, running the checks when it is older
+// than the TTL or fresh is set
+func (c *Checker) Report(ctx context.Context, fresh bool) Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.report != nil && !fresh && time.Since(c.report.Time) < c.ttl {
+		return *c.report
+	}
+	report := Run(ctx, c.checks())
+	c.report = &report
+	return report
+}
+
+// Command checks a program is on the PATH and runs with args. The first
+// line of its output is returned as the version, the last one as the error
+// when it fails, where errors of scripts such as Python tracebacks end.
+func Command(name string, args ...string) ProbeFunc {
+	return func(ctx context.Context) (string, string, error) {
+		path, err := exec.LookPath(name)
+		if err != nil {
+			return "", "", fmt.Errorf("%s not found in PATH", name)
+		}
+
+		output, err := exec.CommandContext(ctx, path, args...).CombinedOutput()
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", path, fmt.Errorf("%s didn't answer within %s", name, checkTimeout)
+			}
+			return "", path, errors.New(lastLine(output, err.Error()))
+		}
+		return firstLine(output, ""), path, nil
+	}
+}
+
+// HTTP checks a server answers GET url with a 2xx status. check, when
+// given, is passed the body and returns the version or an error.
+func HTTP(rawURL string, check func(body []byte) (string, error)) ProbeFunc {
+	return func(ctx context.Context) (string, string, error) {
+		target := redact(rawURL)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+		if err != nil {
+			return "", target, fmt.Errorf("invalid URL: %w", err)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return "", target, fmt.Errorf("unreachable: %s", unwrapURLError(err))
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return "", target, fmt.Errorf("answered %s", resp.Status)
+		}
+		if check == nil {
+			return "", target, nil
+		}
+
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		if err != nil {
+			return "", target, fmt.Errorf("failed to read the response: %w", err)
+		}
+		version, err := check(body)
+		return version, target, err
+	}
+}
+
+// TCP checks something listens on the host and port of a URL, for servers
+// spoken to over protocols like WebSocket
+func TCP(rawURL string) ProbeFunc {
+	return func(ctx context.Context) (string, string, error) {
+		target := redact(rawURL)
+		parsed, err := url.Parse(rawURL)
+		if err != nil || parsed.Host == "" {
+			return "", target, fmt.Errorf("invalid URL %q", target)
+		}
+
+		host := parsed.Host
+		if parsed.Port() == "" {
+			switch parsed.Scheme {
+			case "https", "wss":
+				host = net.JoinHostPort(parsed.Hostname(), "443")
+			default:
+				host = net.JoinHostPort(parsed.Hostname(), "80")
+			}
+		}
+
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", host)
+		if err != nil {
+			return "", target, fmt.Errorf("unreachable: %s", unwrapURLError(err))
+		}
+		conn.Close()
+		return "", target, nil
+	}
+}
+
+// firstLine returns the first non-empty line of output, or fallback
+func firstLine(output []byte, fallback string) string {
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			return line
+		}
+	}
+	return fallback
+}
+
+// lastLine returns the last non-empty line of output, or fallback
+func lastLine(output []byte, fallback string) string {
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if line := strings.TrimSpace(lines[len(lines)-1]); line != "" {
+		return line
+	}
+	return fallback
+}
+
+// redact removes the credentials of a URL shown in a report
+func redact(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return parsed.Redacted()
+}
+
+// unwrapURLError drops the method and URL net/http prefixes errors with
+func unwrapURLError(err error) string {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	return err.Error()
+}
@@ -0,0 +1,700 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"iptv-backend/logging"
+)
+
+type JobStatus string
+
+const (
+	StatusPending   JobStatus = "pending"
+	StatusRunning   JobStatus = "running"
+	StatusCompleted JobStatus = "completed"
+	StatusFailed    JobStatus = "failed"
+	StatusCancelled JobStatus = "cancelled"
+)
+
+// Job is a unit of background work tracked by the Manager
+type Job struct {
+	ID          string          `json:"id"`
+	Type        string          `json:"type"`
+	User        string          `json:"user,omitempty"`
+	Status      JobStatus       `json:"status"`
+	Payload     json.RawMessage `json:"payload,omitempty"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	Progress    float64         `json:"progress"`
+	Message     string          `json:"message,omitempty"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	Error       string          `json:"error,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	StartedAt   *time.Time      `json:"started_at,omitempty"`
+	FinishedAt  *time.Time      `json:"finished_at,omitempty"`
+	NextRunAt   time.Time       `json:"next_run_at"`
+}
+
+// Finished reports whether the job reached a terminal status
+func (j *Job) Finished() bool {
+	return j.Status == StatusCompleted || j.Status == StatusFailed || j.Status == StatusCancelled
+}
+
+// DecodePayload unmarshals the job payload into v
+func (j *Job) DecodePayload(v interface{}) error {
+	if len(j.Payload) == 0 {
+		return nil
+	}
+	return json.Unmarshal(j.Payload, v)
+}
+
+// ProgressFunc reports job progress (0-100) with an optional message
+type ProgressFunc func(progress float64, message string)
+
+// Handler executes a job and returns a JSON-serializable result
+type Handler func(ctx context.Context, job *Job, progress ProgressFunc) (interface{}, error)
+
+// TypeOptions configures how jobs of a given type are executed
+type TypeOptions struct {
+	MaxAttempts int           // Attempts before the job is marked failed
+	Concurrency int           // Max jobs of this type running at once
+	Timeout     time.Duration // Max duration of a single attempt (0 = none)
+}
+
+// Store persists job records
+type Store interface {
+	Save(job *Job) error
+	LoadUnfinished() ([]*Job, error)
+	Get(id string) (*Job, error)
+	List(filter ListFilter) ([]*Job, error)
+}
+
+// ListFilter narrows job listings
+type ListFilter struct {
+	User   string
+	Type   string
+	Status JobStatus
+	Limit  int
+}
+
+// Config holds configuration for the job manager
+type Config struct {
+	Concurrency  int           // Max jobs running at once across all types
+	BaseBackoff  time.Duration // Delay before the first retry
+	MaxBackoff   time.Duration // Upper bound for retry delays
+	PollInterval time.Duration // How often the dispatcher looks for due jobs
+	KeepFinished time.Duration // How long finished jobs stay in memory
+}
+
+// DefaultConfig returns the default manager configuration
+func DefaultConfig() Config {
+	return Config{
+		Concurrency:  4,
+		BaseBackoff:  5 * time.Second,
+		MaxBackoff:   5 * time.Minute,
+		PollInterval: time.Second,
+		KeepFinished: time.Hour,
+	}
+}
+
+type registeredType struct {
+	handler Handler
+	options TypeOptions
+	running int
+}
+
+// Manager schedules, executes and retries background jobs
+type Manager struct {
+	config  Config
+	store   Store
+	types   map[string]*registeredType
+	jobs    map[string]*Job
+	cancels map[string]context.CancelFunc
+	waiters map[string][]chan struct{}
+	running int
+	mu      sync.Mutex
+	wake    chan struct{}
+	ctx     context.Context
+	stop    context.CancelFunc
+	wg      sync.WaitGroup
+	logger  *slog.Logger
+
+	finishedHooks []func(job Job)
+	changeHooks   []func(job Job)
+
+	// Changes waiting to be saved and passed to the change hooks, in order,
+	// by whichever goroutine is flushing them
+	pending  []Job
+	flushing bool
+}
+
+// NewManager creates a job manager. store may be nil for in-memory only.
+func NewManager(config Config, store Store) *Manager {
+	if config.Concurrency <= 0 {
+		config.Concurrency = 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Manager{
+		config:  config,
+		store:   store,
+		types:   make(map[string]*registeredType),
+		jobs:    make(map[string]*Job),
+		cancels: make(map[string]context.CancelFunc),
+		waiters: make(map[string][]chan struct{}),
+		wake:    make(chan struct{}, 1),
+		ctx:     ctx,
+		stop:    cancel,
+		logger:  logging.For("jobs"),
+	}
+}
+
+// SetStore sets the persistence backend. Must be called before Start.
+func (m *Manager) SetStore(store Store) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.store = store
+}
+
+// OnFinished registers a callback run when a job completes, fails for good or
+// is cancelled. Callbacks get a copy of the job and run on their own goroutine.
+func (m *Manager) OnFinished(fn func(job Job)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.finishedHooks = append(m.finishedHooks, fn)
+}
+
+// OnChange registers a callback run whenever a job is queued, starts,
+// progresses (at most once a second), is retried or finishes. Callbacks get a
+// copy of the job and run in order, outside the manager lock, so they may
+// call back into it; they shouldn't block, as later changes wait for them.
+func (m *Manager) OnChange(fn func(job Job)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.changeHooks = append(m.changeHooks, fn)
+}
+
+// Register adds a handler for a job type
+func (m *Manager) Register(jobType string, handler Handler, options TypeOptions) {
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = 1
+	}
+	if options.Concurrency <= 0 {
+		options.Concurrency = m.config.Concurrency
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.types[jobType] = &registeredType{handler: handler, options: options}
+}
+
+// Start resumes unfinished persisted jobs and starts the dispatcher
+func (m *Manager) Start() {
+	if m.store != nil {
+		unfinished, err := m.store.LoadUnfinished()
+		if err != nil {
+			m.logger.Error("failed to load unfinished jobs", "error", err)
+		}
+
+		m.mu.Lock()
+		for _, job := range unfinished {
+			// Jobs interrupted mid-run are retried from scratch
+			if job.Status == StatusRunning {
+				job.Status = StatusPending
+				job.NextRunAt = time.Now()
+			}
+			m.jobs[job.ID] = job
+		}
+		m.mu.Unlock()
+
+		if len(unfinished) > 0 {
+			m.logger.Info("resumed unfinished jobs", "count", len(unfinished))
+		}
+	}
+
+	go m.dispatchLoop()
+}
+
+// Stop cancels running jobs and waits for them to return
+func (m *Manager) Stop(timeout time.Duration) {
+	m.stop()
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		m.logger.Warn("timed out waiting for running jobs")
+	}
+}
+
+// Enqueue creates a new pending job
+func (m *Manager) Enqueue(jobType, user string, payload interface{}) (*Job, error) {
+	m.mu.Lock()
+	t, ok := m.types[jobType]
+	m.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown job type %q", jobType)
+	}
+
+	rawPayload, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode job payload: %w", err)
+	}
+
+	now := time.Now()
+	job := &Job{
+		ID:          newJobID(),
+		Type:        jobType,
+		User:        user,
+		Status:      StatusPending,
+		Payload:     rawPayload,
+		MaxAttempts: t.options.MaxAttempts,
+		CreatedAt:   now,
+		NextRunAt:   now,
+	}
+
+	m.mu.Lock()
+	m.jobs[job.ID] = job
+	m.persistLocked(job)
+	snapshot := *job
+	m.mu.Unlock()
+	m.flush()
+
+	m.signal()
+
+	return &snapshot, nil
+}
+
+// Get returns a snapshot of a job, falling back to the store for old jobs
+func (m *Manager) Get(id string) (*Job, bool) {
+	m.mu.Lock()
+	job, ok := m.jobs[id]
+	if ok {
+		snapshot := *job
+		m.mu.Unlock()
+		return &snapshot, true
+	}
+	store := m.store
+	m.mu.Unlock()
+
+	if store != nil {
+		if job, err := store.Get(id); err == nil {
+			return job, true
+		}
+	}
+
+	return nil, false
+}
+
+// List returns jobs matching the filter, newest first
+func (m *Manager) List(filter ListFilter) []*Job {
+	if m.store != nil {
+		if jobs, err := m.store.List(filter); err == nil {
+			// Overlay live in-memory state, which is fresher than the store
+			m.mu.Lock()
+			for i, job := range jobs {
+				if live, ok := m.jobs[job.ID]; ok {
+					snapshot := *live
+					jobs[i] = &snapshot
+				}
+			}
+			m.mu.Unlock()
+			return jobs
+		}
+	}
+
+	m.mu.Lock()
+	result := make([]*Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		if filter.User != "" && job.User != filter.User {
+			continue
+		}
+		if filter.Type != "" && job.Type != filter.Type {
+			continue
+		}
+		if filter.Status != "" && job.Status != filter.Status {
+			continue
+		}
+		snapshot := *job
+		result = append(result, &snapshot)
+	}
+	m.mu.Unlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.After(result[j].CreatedAt)
+	})
+
+	if filter.Limit > 0 && len(result) > filter.Limit {
+		result = result[:filter.Limit]
+	}
+
+	return result
+}
+
+// Cancel stops a pending or running job
+func (m *Manager) Cancel(id string) error {
+	defer m.flush()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return fmt.Errorf("job not found")
+	}
+	if job.Finished() {
+		return fmt.Errorf("job already finished")
+	}
+
+	if cancel, ok := m.cancels[id]; ok {
+		cancel()
+	}
+
+	m.finishLocked(job, StatusCancelled, "cancelled")
+
+	return nil
+}
+
+// Report sets the result of a running job before it completes, so callers
+// can follow partial results; the handler's return value replaces it
+func (m *Manager) Report(id string, result interface{}) {
+	raw, err := json.Marshal(result)
+	if err != nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if job, ok := m.jobs[id]; ok && job.Status == StatusRunning {
+		job.Result = raw
+	}
+}
+
+// Wait blocks until the job finishes or ctx is done
+func (m *Manager) Wait(ctx context.Context, id string) (*Job, error) {
+	m.mu.Lock()
+	job, ok := m.jobs[id]
+	if !ok {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("job not found")
+	}
+	if job.Finished() {
+		snapshot := *job
+		m.mu.Unlock()
+		return &snapshot, nil
+	}
+	ch := make(chan struct{})
+	m.waiters[id] = append(m.waiters[id], ch)
+	m.mu.Unlock()
+
+	select {
+	case <-ch:
+		job, _ := m.Get(id)
+		return job, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Stats returns counts of in-memory jobs by status
+func (m *Manager) Stats() map[string]int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := map[string]int{"running_total": m.running}
+	for _, job := range m.jobs {
+		stats[string(job.Status)]++
+	}
+	return stats
+}
+
+func (m *Manager) signal() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// dispatchLoop starts due jobs while respecting concurrency limits
+func (m *Manager) dispatchLoop() {
+	ticker := time.NewTicker(m.config.PollInterval)
+	defer ticker.Stop()
+
+	lastPrune := time.Now()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+		case <-m.wake:
+		}
+
+		m.dispatch()
+
+		if time.Since(lastPrune) > time.Minute {
+			m.prune()
+			lastPrune = time.Now()
+		}
+	}
+}
+
+func (m *Manager) dispatch() {
+	defer m.flush()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+
+	due := make([]*Job, 0)
+	for _, job := range m.jobs {
+		if job.Status == StatusPending && !job.NextRunAt.After(now) {
+			due = append(due, job)
+		}
+	}
+
+	// Oldest first for fairness
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].CreatedAt.Before(due[j].CreatedAt)
+	})
+
+	for _, job := range due {
+		if m.running >= m.config.Concurrency {
+			return
+		}
+
+		t, ok := m.types[job.Type]
+		if !ok {
+			m.finishLocked(job, StatusFailed, "no handler registered for job type")
+			continue
+		}
+		if t.running >= t.options.Concurrency {
+			continue
+		}
+
+		m.startLocked(job, t)
+	}
+}
+
+func (m *Manager) startLocked(job *Job, t *registeredType) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if t.options.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(m.ctx, t.options.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(m.ctx)
+	}
+
+	now := time.Now()
+	job.Status = StatusRunning
+	job.Attempts++
+	job.StartedAt = &now
+	job.Error = ""
+	job.Result = nil // Partial results of a previous attempt
+	m.cancels[job.ID] = cancel
+	m.running++
+	t.running++
+	m.persistLocked(job)
+
+	m.wg.Add(1)
+	go m.run(ctx, job, t)
+}
+
+func (m *Manager) run(ctx context.Context, job *Job, t *registeredType) {
+	defer m.wg.Done()
+
+	logger := m.logger.With("job_id", job.ID, "job_type", job.Type, "attempt", job.Attempts)
+	logger.Debug("job started")
+
+	lastPersist := time.Time{}
+	progress := func(p float64, message string) {
+		defer m.flush()
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if job.Status != StatusRunning {
+			return
+		}
+		job.Progress = p
+		job.Message = message
+		// Throttle writes to the store
+		if time.Since(lastPersist) > time.Second {
+			m.persistLocked(job)
+			lastPersist = time.Now()
+		}
+	}
+
+	m.mu.Lock()
+	snapshot := *job
+	m.mu.Unlock()
+
+	result, err := m.safeCall(ctx, t.handler, &snapshot, progress)
+
+	defer m.flush()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if cancel, ok := m.cancels[job.ID]; ok {
+		cancel()
+		delete(m.cancels, job.ID)
+	}
+	m.running--
+	t.running--
+	defer m.signal()
+
+	// Cancelled via API while running
+	if job.Status == StatusCancelled {
+		return
+	}
+
+	if err == nil {
+		if result != nil {
+			if raw, encErr := json.Marshal(result); encErr == nil {
+				job.Result = raw
+			}
+		}
+		job.Progress = 100
+		m.finishLocked(job, StatusCompleted, "")
+		logger.Debug("job completed")
+		return
+	}
+
+	// Manager shutting down: leave the job pending so it resumes on next start
+	if m.ctx.Err() != nil {
+		job.Status = StatusPending
+		job.NextRunAt = time.Now()
+		m.persistLocked(job)
+		return
+	}
+
+	if job.Attempts < job.MaxAttempts {
+		delay := m.backoff(job.Attempts)
+		job.Status = StatusPending
+		job.Error = err.Error()
+		job.NextRunAt = time.Now().Add(delay)
+		m.persistLocked(job)
+		logger.Warn("job failed, retrying", "error", err, "retry_in", delay)
+		return
+	}
+
+	m.finishLocked(job, StatusFailed, err.Error())
+	logger.Error("job failed", "error", err)
+}
+
+// safeCall runs a handler, converting panics into errors
+func (m *Manager) safeCall(ctx context.Context, handler Handler, job *Job, progress ProgressFunc) (result interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return handler(ctx, job, progress)
+}
+
+func (m *Manager) backoff(attempt int) time.Duration {
+	delay := m.config.BaseBackoff
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if delay >= m.config.MaxBackoff {
+			return m.config.MaxBackoff
+		}
+	}
+	return delay
+}
+
+func (m *Manager) finishLocked(job *Job, status JobStatus, errMsg string) {
+	now := time.Now()
+	job.Status = status
+	job.FinishedAt = &now
+	if errMsg != "" {
+		job.Error = errMsg
+	}
+	m.persistLocked(job)
+
+	for _, ch := range m.waiters[job.ID] {
+		close(ch)
+	}
+	delete(m.waiters, job.ID)
+
+	snapshot := *job
+	for _, fn := range m.finishedHooks {
+		go fn(snapshot)
+	}
+}
+
+// persistLocked queues a snapshot of a job after a change, to be saved and
+// passed to the change hooks by flush once m.mu is released
+func (m *Manager) persistLocked(job *Job) {
+	m.pending = append(m.pending, *job)
+}
+
+// flush saves the queued job changes and passes them to the change hooks,
+// without holding m.mu so job reads don't wait for the store. One goroutine
+// flushes at a time, keeping changes in order; others leave their changes to
+// it. m.mu must not be held.
+func (m *Manager) flush() {
+	m.mu.Lock()
+	if m.flushing {
+		m.mu.Unlock()
+		return
+	}
+	m.flushing = true
+
+	for len(m.pending) > 0 {
+		batch := m.pending
+		m.pending = nil
+		hooks := m.changeHooks
+		store := m.store
+		m.mu.Unlock()
+
+		for i := range batch {
+			job := &batch[i]
+			for _, fn := range hooks {
+				fn(*job)
+			}
+			if store == nil {
+				continue
+			}
+			if err := store.Save(job); err != nil {
+				m.logger.Warn("failed to persist job", "job_id", job.ID, "error", err)
+			}
+		}
+
+		m.mu.Lock()
+	}
+
+	m.flushing = false
+	m.mu.Unlock()
+}
+
+// prune drops finished jobs from memory (they remain in the store)
+func (m *Manager) prune() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, job := range m.jobs {
+		if job.Finished() && job.FinishedAt != nil && time.Since(*job.FinishedAt) > m.config.KeepFinished {
+			delete(m.jobs, id)
+		}
+	}
+}
+
+// newJobID returns a 15 character lowercase id compatible with PocketBase record ids
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)[:15]
+}
@@ -0,0 +1,156 @@
+package jobs
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+)
+
+// CollectionName is the PocketBase collection holding job records
+const CollectionName = "jobs"
+
+// RecordStore persists jobs in the PocketBase "jobs" collection
+type RecordStore struct {
+	app core.App
+}
+
+// NewRecordStore creates a store backed by the given PocketBase app
+func NewRecordStore(app core.App) *RecordStore {
+	return &RecordStore{app: app}
+}
+
+// Save inserts or updates a job record
+func (s *RecordStore) Save(job *Job) error {
+	record, err := s.app.Dao().FindRecordById(CollectionName, job.ID)
+	if err != nil {
+		collection, err := s.app.Dao().FindCollectionByNameOrId(CollectionName)
+		if err != nil {
+			return err
+		}
+		record = models.NewRecord(collection)
+		record.SetId(job.ID)
+		record.MarkAsNew()
+	}
+
+	record.Set("type", job.Type)
+	record.Set("user", job.User)
+	record.Set("status", string(job.Status))
+	record.Set("payload", string(job.Payload))
+	record.Set("result", string(job.Result))
+	record.Set("progress", job.Progress)
+	record.Set("message", job.Message)
+	record.Set("attempts", job.Attempts)
+	record.Set("max_attempts", job.MaxAttempts)
+	record.Set("error", job.Error)
+	record.Set("next_run_at", job.NextRunAt)
+	if job.StartedAt != nil {
+		record.Set("started_at", *job.StartedAt)
+	}
+	if job.FinishedAt != nil {
+		record.Set("finished_at", *job.FinishedAt)
+	}
+
+	return s.app.Dao().SaveRecord(record)
+}
+
+// LoadUnfinished returns all pending and running jobs
+func (s *RecordStore) LoadUnfinished() ([]*Job, error) {
+	records, err := s.app.Dao().FindRecordsByFilter(
+		CollectionName,
+		"status = 'pending' || status = 'running'",
+		"created",
+		0,
+		0,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]*Job, 0, len(records))
+	for _, record := range records {
+		jobs = append(jobs, recordToJob(record))
+	}
+	return jobs, nil
+}
+
+// Get loads a single job
+func (s *RecordStore) Get(id string) (*Job, error) {
+	record, err := s.app.Dao().FindRecordById(CollectionName, id)
+	if err != nil {
+		return nil, err
+	}
+	return recordToJob(record), nil
+}
+
+// List returns jobs matching the filter, newest first
+func (s *RecordStore) List(filter ListFilter) ([]*Job, error) {
+	expr := "id != ''"
+	params := dbx.Params{}
+	if filter.User != "" {
+		expr += " && user = {:user}"
+		params["user"] = filter.User
+	}
+	if filter.Type != "" {
+		expr += " && type = {:type}"
+		params["type"] = filter.Type
+	}
+	if filter.Status != "" {
+		expr += " && status = {:status}"
+		params["status"] = string(filter.Status)
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+
+	records, err := s.app.Dao().FindRecordsByFilter(CollectionName, expr, "-created", limit, 0, params)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]*Job, 0, len(records))
+	for _, record := range records {
+		jobs = append(jobs, recordToJob(record))
+	}
+	return jobs, nil
+}
+
+func recordToJob(record *models.Record) *Job {
+	job := &Job{
+		ID:          record.Id,
+		Type:        record.GetString("type"),
+		User:        record.GetString("user"),
+		Status:      JobStatus(record.GetString("status")),
+		Progress:    record.GetFloat("progress"),
+		Message:     record.GetString("message"),
+		Attempts:    record.GetInt("attempts"),
+		MaxAttempts: record.GetInt("max_attempts"),
+		Error:       record.GetString("error"),
+		CreatedAt:   record.Created.Time(),
+		NextRunAt:   record.GetDateTime("next_run_at").Time(),
+	}
+
+	if payload := record.GetString("payload"); payload != "" && payload != "null" {
+		job.Payload = json.RawMessage(payload)
+	}
+	if result := record.GetString("result"); result != "" && result != "null" {
+		job.Result = json.RawMessage(result)
+	}
+	if started := record.GetDateTime("started_at"); !started.IsZero() {
+		t := started.Time()
+		job.StartedAt = &t
+	}
+	if finished := record.GetDateTime("finished_at"); !finished.IsZero() {
+		t := finished.Time()
+		job.FinishedAt = &t
+	}
+	if job.NextRunAt.IsZero() {
+		job.NextRunAt = time.Now()
+	}
+
+	return job
+}
@@ -0,0 +1,281 @@
+package library
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/pocketbase/pocketbase/models"
+
+	"iptv-backend/jobs"
+	"iptv-backend/recorder"
+)
+
+// AdCutJobType is the job cutting the ads found out of a recording, for
+// profiles with recording_ad_cut set
+const AdCutJobType = "recording.ad_cut"
+
+// What an ad break was found by
+const (
+	SignalBlanks   = "blanks"   // Runs of silence over black frames
+	SignalSilences = "silences" // Runs of silences, in audio recordings
+	SignalNoLogo   = "no_logo"  // The channel's logo missing
+)
+
+// Confidence of ad breaks, by what found them. Blanks also break up
+// programmes and logos go for their credits, both together rarely do.
+var signalConfidence = map[string]float64{
+	SignalBlanks + "," + SignalNoLogo: 0.9,
+	SignalBlanks:                      0.7,
+	SignalNoLogo:                      0.5,
+	SignalSilences:                    0.4,
+}
+
+// Confidence from which ad breaks are skipped by default and marked as
+// chapters, and from which they are cut automatically
+const (
+	MinSkipConfidence = 0.5
+	AutoCutConfidence = 0.7
+)
+
+// Ad breaks are runs of ads split by blanks, a fraction of a second of
+// silence over black frames. A run of at least minBreakBlanks blanks no
+// more than maxAdLength apart, lasting between minBreakLength and
+// maxBreakLength, is taken for a break; so is the logo missing for as long.
+const (
+	minBreakBlanks = 3
+	maxAdLength    = 65.0
+	minBreakLength = 30.0
+	maxBreakLength = 12 * 60.0
+
+	// Audio has no black frames to confirm its silences, which are only
+	// taken for blanks from this long
+	minAudioBlank = 1.0
+)
+
+// AdBreak is a probable ad segment of a recording, in seconds from its
+// start
+type AdBreak struct {
+	Start      float64  `json:"start"`
+	End        float64  `json:"end"`
+	Confidence float64  `json:"confidence"`
+	Signals    []string `json:"signals"`
+}
+
+// AdBreaks returns the ad breaks found in a recording with at least a
+// confidence, none until it was analyzed
+func AdBreaks(record *models.Record, minConfidence float64) []AdBreak {
+	breaks := []AdBreak{}
+	record.UnmarshalJSONField("ad_breaks", &breaks)
+
+	kept := breaks[:0]
+	for _, adBreak := range breaks {
+		if adBreak.Confidence >= minConfidence {
+			kept = append(kept, adBreak)
+		}
+	}
+	return kept
+}
+
+// detectAds returns the ad breaks told by the blanks of a recording and
+// the stretches its logo was missing for, overlapping ones merged
+func detectAds(blanks *recorder.Blanks, absences []recorder.Span, video bool) []AdBreak {
+	var candidates []AdBreak
+	signal := SignalBlanks
+	if !video {
+		signal = SignalSilences
+	}
+	for _, span := range findBreaks(blanks, video) {
+		candidates = append(candidates, AdBreak{Start: span.Start, End: span.End, Signals: []string{signal}})
+	}
+	for _, span := range absences {
+		if length := span.End - span.Start; length >= minBreakLength && length <= maxBreakLength {
+			candidates = append(candidates, AdBreak{Start: span.Start, End: span.End, Signals: []string{SignalNoLogo}})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Start < candidates[j].Start })
+
+	breaks := []AdBreak{}
+	for _, candidate := range candidates {
+		if n := len(breaks); n > 0 && candidate.Start < breaks[n-1].End {
+			last := &breaks[n-1]
+			last.End = max(last.End, candidate.End)
+			for _, signal := range candidate.Signals {
+				if !slices.Contains(last.Signals, signal) {
+					last.Signals = append(last.Signals, signal)
+				}
+			}
+			continue
+		}
+		breaks = append(breaks, candidate)
+	}
+
+	for i := range breaks {
+		sort.Strings(breaks[i].Signals)
+		breaks[i].Confidence = signalConfidence[strings.Join(breaks[i].Signals, ",")]
+		breaks[i].Start = math.Round(breaks[i].Start*1000) / 1000
+		breaks[i].End = math.Round(breaks[i].End*1000) / 1000
+	}
+	return breaks
+}
+
+// findBreaks returns the runs of blanks of a recording long enough to be
+// ad breaks. With video, blanks are silences over black frames.
+func findBreaks(blanks *recorder.Blanks, video bool) []recorder.Span {
+	var separators []recorder.Span
+	for _, silence := range blanks.Silences {
+		if !video {
+			if silence.End-silence.Start >= minAudioBlank {
+				separators = append(separators, silence)
+			}
+			continue
+		}
+		for _, black := range blanks.Blacks {
+			if black.Start < silence.End && silence.Start < black.End {
+				separators = append(separators, recorder.Span{
+					Start: max(black.Start, silence.Start),
+					End:   min(black.End, silence.End),
+				})
+			}
+		}
+	}
+	sort.Slice(separators, func(i, j int) bool { return separators[i].Start < separators[j].Start })
+
+	var breaks []recorder.Span
+	flush := func(run []recorder.Span) {
+		if len(run) < minBreakBlanks {
+			return
+		}
+		length := run[len(run)-1].End - run[0].Start
+		if length >= minBreakLength && length <= maxBreakLength {
+			breaks = append(breaks, recorder.Span{Start: run[0].Start, End: run[len(run)-1].End})
+		}
+	}
+	var run []recorder.Span
+	for _, separator := range separators {
+		if len(run) > 0 && separator.Start-run[len(run)-1].End > maxAdLength {
+			flush(run)
+			run = nil
+		}
+		run = append(run, separator)
+	}
+	flush(run)
+	return breaks
+}
+
+// EDL formats ad breaks as an edit decision list, which Kodi and mpv
+// scripts read next to a recording to skip commercials
+func EDL(breaks []AdBreak) string {
+	var b strings.Builder
+	for _, adBreak := range breaks {
+		fmt.Fprintf(&b, "%.3f\t%.3f\t3\n", adBreak.Start, adBreak.End)
+	}
+	return b.String()
+}
+
+// trimmedCopy matches the files of trimmed recordings, which aren't cut
+// again
+var trimmedCopy = regexp.MustCompile(`_trimmed(?:_\d+)?\.ts$`)
+
+// cutIfDue queues the ads of a freshly analyzed recording to be cut, when
+// its profile asks for it
+func (s *Service) cutIfDue(record *models.Record) {
+	name := record.GetString("file_path")
+	if !strings.EqualFold(filepath.Ext(name), ".ts") || trimmedCopy.MatchString(name) {
+		return
+	}
+	if len(AdBreaks(record, AutoCutConfidence)) == 0 {
+		return
+	}
+	profile, err := s.app.Dao().FindRecordById("profiles", record.GetString("profile"))
+	if err != nil || profile.GetString("recording_ad_cut") == "" {
+		return
+	}
+	if _, err := s.enqueue(AdCutJobType, record.Id); err != nil && err != ErrQueued {
+		s.logger.Warn("failed to queue ad cut", "recording_id", record.Id, "error", err)
+	}
+}
+
+// cut writes a copy of a recording without its ads. With the profile's
+// recording_ad_cut set to replace, the original goes once the copy is
+// saved, unless it is protected.
+func (s *Service) cut(ctx context.Context, job *jobs.Job, progress jobs.ProgressFunc) (interface{}, error) {
+	var payload struct {
+		RecordingID string `json:"recording_id"`
+	}
+	if err := job.DecodePayload(&payload); err != nil {
+		return nil, err
+	}
+	defer s.done(AdCutJobType, payload.RecordingID)
+
+	dao := s.app.Dao()
+	original, err := dao.FindRecordById(Collection, payload.RecordingID)
+	if err != nil {
+		return nil, nil // Deleted since
+	}
+	profile, err := dao.FindRecordById("profiles", original.GetString("profile"))
+	if err != nil {
+		return nil, nil
+	}
+
+	breaks := AdBreaks(original, AutoCutConfidence)
+	ranges := keepRanges(breaks, original.GetFloat("duration"))
+	if len(breaks) == 0 || len(ranges) == 0 {
+		return nil, nil
+	}
+
+	name := original.GetString("file_path")
+	user := s.config.Recorder.Owner(name)
+	if users := profile.GetStringSlice("user"); user == "" && len(users) > 0 {
+		user = users[0]
+	}
+
+	trimmed, err := s.Trim(ctx, original, user, ranges)
+	if err != nil {
+		return nil, err
+	}
+
+	removed := 0.0
+	for _, adBreak := range breaks {
+		removed += adBreak.End - adBreak.Start
+	}
+	result := map[string]interface{}{
+		"recording_id": trimmed.Id,
+		"file":         trimmed.GetString("file_path"),
+		"removed":      math.Round(removed),
+		"replaced":     false,
+	}
+
+	if profile.GetString("recording_ad_cut") == "replace" {
+		if err := s.config.Recorder.DeleteFile(name); err != nil {
+			s.logger.Info("kept the original of a cut recording", "recording_id", original.Id, "file", name, "error", err)
+			return result, nil
+		}
+		if err := dao.DeleteRecord(original); err != nil {
+			s.logger.Warn("failed to delete the original of a cut recording", "recording_id", original.Id, "error", err)
+		}
+		result["replaced"] = true
+	}
+	return result, nil
+}
+
+// keepRanges returns what is left of a recording without its breaks
+func keepRanges(breaks []AdBreak, duration float64) []recorder.KeepRange {
+	var ranges []recorder.KeepRange
+	cursor := 0.0
+	for _, adBreak := range breaks {
+		if adBreak.Start-cursor >= minChapterLength {
+			ranges = append(ranges, recorder.KeepRange{Start: cursor, End: adBreak.Start})
+		}
+		cursor = max(cursor, adBreak.End)
+	}
+	if duration <= 0 || duration-cursor >= minChapterLength {
+		ranges = append(ranges, recorder.KeepRange{Start: cursor})
+	}
+	return ranges
+}
@@ -0,0 +1,114 @@
+package library
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/types"
+
+	"iptv-backend/jobs"
+	"iptv-backend/recorder"
+)
+
+// AnalyzeJobType is the job giving a finished recording its chapters and
+// finding its ads
+const AnalyzeJobType = "recording.analyze"
+
+// Analyze queues a finished recording to be analyzed again, such as once
+// the guide covers it. Its ads aren't cut again.
+func (s *Service) Analyze(record *models.Record) (*jobs.Job, error) {
+	if !finished(record) {
+		return nil, fmt.Errorf("recording isn't finished")
+	}
+	return s.enqueue(AnalyzeJobType, record.Id)
+}
+
+// analyzeIfDue queues a probed recording not analyzed yet, to cut its ads
+// once done when its profile asks for it
+func (s *Service) analyzeIfDue(record *models.Record) {
+	if !finished(record) || record.GetDateTime("probed_at").IsZero() || !record.GetDateTime("chapters_at").IsZero() {
+		return
+	}
+	if _, err := s.enqueue(AnalyzeJobType, record.Id, "cut"); err != nil && err != ErrQueued {
+		s.logger.Warn("failed to queue recording analysis", "recording_id", record.Id, "error", err)
+	}
+}
+
+// analyze saves the chapters and ad breaks of a recording. Chapters are
+// the programmes the guide says it covers, the rest titled after the
+// recording, with the probable ad breaks cut out of them. Ads are found by
+// runs of blanks and, in video, the channel's logo going missing.
+func (s *Service) analyze(ctx context.Context, job *jobs.Job, progress jobs.ProgressFunc) (interface{}, error) {
+	var payload struct {
+		RecordingID string `json:"recording_id"`
+		Cut         bool   `json:"cut"`
+	}
+	if err := job.DecodePayload(&payload); err != nil {
+		return nil, err
+	}
+	defer s.done(AnalyzeJobType, payload.RecordingID)
+
+	dao := s.app.Dao()
+	record, err := dao.FindRecordById(Collection, payload.RecordingID)
+	if err != nil {
+		return nil, nil // Deleted since
+	}
+
+	duration := record.GetFloat("duration")
+	start := record.GetDateTime("actual_start").Time()
+
+	var programmes []Chapter
+	if s.config.EPG != nil && !start.IsZero() && duration > 0 {
+		programmes, err = s.programmes(record, start, duration)
+		if err != nil {
+			s.logger.Warn("failed to read the guide of a recording", "recording_id", record.Id, "error", err)
+		}
+	}
+
+	breaks := []AdBreak{}
+	result := map[string]interface{}{}
+	if s.config.DetectBreaks && duration > 0 {
+		video := !recorder.IsAudioMode(record.GetString("mode"))
+		logo := newLogoTracker()
+		options := recorder.ScanOptions{Video: video}
+		if video {
+			options.Frame = logo.add
+		}
+		blanks, err := s.config.Recorder.Scan(ctx, record.GetString("file_path"), options)
+		switch {
+		case ctx.Err() != nil:
+			return nil, ctx.Err()
+		case err != nil:
+			s.logger.Debug("failed to scan recording for ads", "recording_id", record.Id, "error", err)
+			result["error"] = err.Error()
+		default:
+			breaks = detectAds(blanks, logo.absences(), video)
+		}
+	}
+
+	var skipped []recorder.Span
+	for _, adBreak := range breaks {
+		if adBreak.Confidence >= MinSkipConfidence {
+			skipped = append(skipped, recorder.Span{Start: adBreak.Start, End: adBreak.End})
+		}
+	}
+	chapters := buildChapters(programmes, skipped, record.GetString("program_title"), duration)
+
+	now, _ := types.ParseDateTime(time.Now())
+	record.Set("chapters", chapters)
+	record.Set("ad_breaks", breaks)
+	record.Set("chapters_at", now)
+	if err := dao.SaveRecord(record); err != nil {
+		return nil, err
+	}
+
+	if payload.Cut {
+		s.cutIfDue(record)
+	}
+
+	result["chapters"] = len(chapters)
+	result["ad_breaks"] = len(breaks)
+	return result, nil
+}
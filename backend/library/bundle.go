@@ -0,0 +1,163 @@
+package library
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/pocketbase/models"
+
+	"iptv-backend/recorder"
+)
+
+// posterOffset is how far into a recording its poster is taken, unless
+// the recording is shorter than ten times that
+const posterOffset = 5 * time.Minute
+
+// bundleMetadata is metadata.json in a bundle
+type bundleMetadata struct {
+	*File
+	ChannelGroup string            `json:"channel_group,omitempty"`
+	Chapters     []Chapter         `json:"chapters,omitempty"`
+	AdBreaks     []AdBreak         `json:"ad_breaks,omitempty"`
+	Files        map[string]string `json:"files"` // Of the bundle, by role
+	ExportedAt   time.Time         `json:"exported_at"`
+}
+
+// BundleName is the file name of the bundle of a recording
+func BundleName(record *models.Record) string {
+	name := record.GetString("file_path")
+	return strings.TrimSuffix(name, filepath.Ext(name)) + ".zip"
+}
+
+// Bundle writes a zip archive of a recording to w, to archive it off the
+// server: its file, the subtitles saved next to it, a poster and
+// metadata.json, in a folder named after the file. The recording is stored
+// as is, it is compressed already. Missing subtitles and posters are left
+// out.
+func (s *Service) Bundle(ctx context.Context, w io.Writer, record *models.Record) error {
+	name := record.GetString("file_path")
+	folder := strings.TrimSuffix(name, filepath.Ext(name)) + "/"
+	dao := s.app.Dao()
+
+	file := s.fileOf(record, "")
+	metadata := bundleMetadata{
+		File:       file,
+		Chapters:   Chapters(record),
+		AdBreaks:   AdBreaks(record, 0),
+		Files:      map[string]string{"recording": folder + name},
+		ExportedAt: time.Now().UTC(),
+	}
+	var channel *models.Record
+	if id := record.GetString("channel"); id != "" {
+		if found, err := dao.FindRecordById("channels", id); err == nil {
+			channel = found
+			file.ChannelName = channel.GetString("name")
+			metadata.ChannelGroup = channel.GetString("group_title")
+		}
+	}
+
+	sidecar := filepath.Base(record.GetString("subtitle_path"))
+	if record.GetString("subtitle_path") == "" {
+		sidecar = filepath.Base(recorder.SubtitleSidecarPath(name))
+	}
+	subtitles, err := s.config.Recorder.OpenFile(sidecar)
+	hasSubtitles := err == nil
+	if hasSubtitles {
+		defer subtitles.Close()
+		metadata.Files["subtitles"] = folder + sidecar
+	}
+
+	poster, posterExt := s.poster(ctx, record, channel)
+	if poster != nil {
+		metadata.Files["poster"] = folder + "poster" + posterExt
+	}
+
+	archive := zip.NewWriter(w)
+
+	f, err := archive.Create(folder + "metadata.json")
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(metadata); err != nil {
+		return fmt.Errorf("failed to write metadata.json: %w", err)
+	}
+
+	if poster != nil {
+		f, err := archive.CreateHeader(&zip.FileHeader{Name: folder + "poster" + posterExt, Method: zip.Store, Modified: time.Now()})
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(poster); err != nil {
+			return err
+		}
+	}
+
+	if hasSubtitles {
+		f, err := archive.Create(folder + sidecar)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, subtitles); err != nil {
+			return err
+		}
+	}
+
+	src, err := s.config.Recorder.OpenFile(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	header := &zip.FileHeader{Name: folder + name, Method: zip.Store}
+	if stat, err := s.config.Recorder.StatFile(name); err == nil {
+		header.Modified = stat.ModTime
+	}
+	f, err = archive.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, src); err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+// poster returns a frame of the recording, or the thumbnail of its channel
+// when it has no video, with its extension
+func (s *Service) poster(ctx context.Context, record *models.Record, channel *models.Record) ([]byte, string) {
+	at := posterOffset.Seconds()
+	if duration := record.GetFloat("duration"); duration > 0 && duration < 10*at {
+		at = duration / 10
+	}
+
+	if !recorder.IsAudioMode(record.GetString("mode")) {
+		frame, err := s.config.Recorder.Poster(ctx, record.GetString("file_path"), at)
+		if err == nil {
+			return frame, ".jpg"
+		}
+		s.logger.Debug("failed to capture recording poster", "recording_id", record.Id, "error", err)
+	}
+
+	if channel == nil || s.config.Thumbnails == nil {
+		return nil, ""
+	}
+	path, ok := s.config.Thumbnails.GetThumbnailPath(channel.Id)
+	if !ok {
+		return nil, ""
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, ""
+	}
+	return data, filepath.Ext(path)
+}
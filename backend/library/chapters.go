@@ -0,0 +1,174 @@
+package library
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/pocketbase/models"
+
+	"iptv-backend/recorder"
+)
+
+// Kinds of chapters
+const (
+	ChapterProgramme = "programme"
+	ChapterBreak     = "break" // Probable ad break
+)
+
+// Chapters shorter than this, left over around breaks, are dropped
+const minChapterLength = 1.0
+
+// Chapter is a chapter of a recording, in seconds from its start
+type Chapter struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Title string  `json:"title"`
+	Kind  string  `json:"kind"`
+}
+
+// Chapters returns the chapters of a recording, none until generated
+func Chapters(record *models.Record) []Chapter {
+	chapters := []Chapter{}
+	record.UnmarshalJSONField("chapters", &chapters)
+	return chapters
+}
+
+// programmes returns the programmes of the guide a recording covers, as
+// chapters clipped to it
+func (s *Service) programmes(record *models.Record, start time.Time, duration float64) ([]Chapter, error) {
+	channelID := record.GetString("channel")
+	if channelID == "" {
+		return nil, nil
+	}
+	dao := s.app.Dao()
+	channel, err := dao.FindRecordById("channels", channelID)
+	if err != nil || channel.GetString("tvg_id") == "" {
+		return nil, nil
+	}
+	profile, err := dao.FindRecordById("profiles", record.GetString("profile"))
+	if err != nil {
+		return nil, nil
+	}
+	users := profile.GetStringSlice("user")
+	if len(users) == 0 {
+		return nil, nil
+	}
+
+	end := start.Add(time.Duration(duration * float64(time.Second)))
+	programs, err := s.config.EPG.Programs(users[0], channel.GetString("tvg_id"), start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	chapters := make([]Chapter, 0, len(programs))
+	for _, program := range programs {
+		title := program.GetString("title")
+		if title == "" {
+			title = record.GetString("program_title")
+		}
+		chapters = append(chapters, Chapter{
+			Start: program.GetDateTime("start_time").Time().Sub(start).Seconds(),
+			End:   program.GetDateTime("end_time").Time().Sub(start).Seconds(),
+			Title: title,
+			Kind:  ChapterProgramme,
+		})
+	}
+	return chapters, nil
+}
+
+// buildChapters lays programmes end to end over a recording, titles what
+// they leave uncovered after the recording and cuts the breaks out
+func buildChapters(programmes []Chapter, breaks []recorder.Span, title string, duration float64) []Chapter {
+	chapters := []Chapter{}
+	if duration <= 0 {
+		return chapters
+	}
+
+	var covered []Chapter
+	cursor := 0.0
+	for _, programme := range programmes {
+		start, end := max(programme.Start, cursor), min(programme.End, duration)
+		if end-start < minChapterLength {
+			continue
+		}
+		if start-cursor >= minChapterLength {
+			covered = append(covered, Chapter{Start: cursor, End: start, Title: title, Kind: ChapterProgramme})
+		}
+		programme.Start, programme.End = start, end
+		covered = append(covered, programme)
+		cursor = end
+	}
+	if duration-cursor >= minChapterLength {
+		covered = append(covered, Chapter{Start: cursor, End: duration, Title: title, Kind: ChapterProgramme})
+	}
+
+	for _, chapter := range covered {
+		pieces := []Chapter{chapter}
+		for _, span := range breaks {
+			var kept []Chapter
+			for _, piece := range pieces {
+				if span.End <= piece.Start || span.Start >= piece.End {
+					kept = append(kept, piece)
+					continue
+				}
+				if span.Start-piece.Start >= minChapterLength {
+					kept = append(kept, Chapter{Start: piece.Start, End: span.Start, Title: piece.Title, Kind: piece.Kind})
+				}
+				if piece.End-span.End >= minChapterLength {
+					kept = append(kept, Chapter{Start: span.End, End: piece.End, Title: piece.Title, Kind: piece.Kind})
+				}
+			}
+			pieces = kept
+		}
+		chapters = append(chapters, pieces...)
+	}
+	for _, span := range breaks {
+		if start, end := max(span.Start, 0), min(span.End, duration); end > start {
+			chapters = append(chapters, Chapter{Start: start, End: end, Title: "Ad break", Kind: ChapterBreak})
+		}
+	}
+	sort.Slice(chapters, func(i, j int) bool { return chapters[i].Start < chapters[j].Start })
+
+	for i := range chapters {
+		chapters[i].Start = math.Round(chapters[i].Start*1000) / 1000
+		chapters[i].End = math.Round(chapters[i].End*1000) / 1000
+	}
+	return chapters
+}
+
+// WebVTT formats chapters as a WebVTT chapters track, for players such as
+// browsers' <track kind="chapters">
+func WebVTT(chapters []Chapter) string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	for i, chapter := range chapters {
+		fmt.Fprintf(&b, "\n%d\n%s --> %s\n%s\n", i+1,
+			vttTime(chapter.Start), vttTime(chapter.End), strings.ReplaceAll(chapter.Title, "-->", "->"))
+	}
+	return b.String()
+}
+
+func vttTime(seconds float64) string {
+	ms := int64(math.Round(seconds * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// ffmetadataEscaper escapes the characters ffmpeg's metadata format gives
+// a meaning to
+var ffmetadataEscaper = strings.NewReplacer(`\`, `\\`, "=", `\=`, ";", `\;`, "#", `\#`, "\n", "\\\n")
+
+// FFMetadata formats chapters in ffmpeg's metadata format, to embed them in
+// a file with ffmpeg -i recording.ts -i chapters.txt -map_chapters 1
+func FFMetadata(chapters []Chapter) string {
+	var b strings.Builder
+	b.WriteString(";FFMETADATA1\n")
+	for _, chapter := range chapters {
+		fmt.Fprintf(&b, "\n[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n",
+			int64(math.Round(chapter.Start*1000)), int64(math.Round(chapter.End*1000)),
+			ffmetadataEscaper.Replace(chapter.Title))
+	}
+	return b.String()
+}
@@ -0,0 +1,309 @@
+package library
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+
+	"iptv-backend/recorder"
+	"iptv-backend/schedules"
+)
+
+// MaxBatchDelete bounds the recordings of one batch deletion
+const MaxBatchDelete = 1000
+
+var (
+	ErrBatchEmpty    = errors.New("give ids, files, watched or older_than")
+	ErrBatchTooLarge = fmt.Errorf("too many recordings, at most %d per batch", MaxBatchDelete)
+)
+
+// Outcomes of the items of a batch deletion
+const (
+	ItemDeleted     = "deleted"
+	ItemDeletable   = "deletable" // Dry run
+	ItemFailed      = "failed"
+	ItemNotDeleted  = "not_deleted" // Left as is because another item failed
+	itemUnprocessed = ""
+)
+
+// BatchDelete selects recordings to delete: the ones listed, and those the
+// filters match. Filters leave out protected recordings and those still
+// recording; Watched and OlderThan together match recordings both watched
+// and older.
+type BatchDelete struct {
+	User      string
+	IDs       []string  // Records in recordings
+	Files     []string  // Recorded file names
+	Watched   bool      // Recordings watched to the end
+	OlderThan time.Time // Recordings started before
+	Profile   string    // Restricts the filters to a profile of the user
+
+	// Partial deletes the items that can be, instead of none when one
+	// can't. DryRun reports what would be deleted.
+	Partial bool
+	DryRun  bool
+}
+
+// ItemResult is the outcome of one recording of a batch
+type ItemResult struct {
+	ID     string `json:"id,omitempty"` // Empty for files without a record
+	File   string `json:"file,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// BatchResult reports a batch deletion item by item
+type BatchResult struct {
+	Deleted int          `json:"deleted"`
+	Failed  int          `json:"failed"`
+	DryRun  bool         `json:"dry_run,omitempty"`
+	Items   []ItemResult `json:"items"`
+}
+
+// batchItem is a recording of a batch, a record, a file or both
+type batchItem struct {
+	record *models.Record
+	file   string
+	result ItemResult
+}
+
+func (item *batchItem) fail(reason string) {
+	item.result.Status = ItemFailed
+	item.result.Error = reason
+}
+
+// Delete deletes recordings and their files in one go. Every item is
+// checked first: unless Partial is set, nothing is deleted when one of them
+// is missing, protected or still recording. Files are then removed, and the
+// records of those removed deleted in a single transaction.
+func (s *Service) Delete(batch BatchDelete) (*BatchResult, error) {
+	if len(batch.IDs) == 0 && len(batch.Files) == 0 && !batch.Watched && batch.OlderThan.IsZero() {
+		return nil, ErrBatchEmpty
+	}
+	if len(batch.IDs)+len(batch.Files) > MaxBatchDelete {
+		return nil, ErrBatchTooLarge
+	}
+
+	dao := s.app.Dao()
+	profiles, err := dao.FindRecordsByFilter("profiles", "user ~ {:user}", "", 0, 0, dbx.Params{"user": batch.User})
+	if err != nil {
+		return nil, err
+	}
+	owned := make(map[string]bool, len(profiles))
+	for _, profile := range profiles {
+		owned[profile.Id] = true
+	}
+
+	var items []*batchItem
+	seen := make(map[string]bool)
+	add := func(item *batchItem) {
+		key := item.file
+		if item.record != nil {
+			key = item.record.Id
+			item.file = item.record.GetString("file_path")
+			item.result.ID = item.record.Id
+		}
+		item.result.File = item.file
+		if key != "" && seen[key] || item.file != "" && seen["file:"+item.file] {
+			return
+		}
+		seen[key] = true
+		if item.file != "" {
+			seen["file:"+item.file] = true
+		}
+		items = append(items, item)
+	}
+
+	for _, id := range batch.IDs {
+		record, err := dao.FindRecordById(Collection, id)
+		if err != nil || !owned[record.GetString("profile")] {
+			item := &batchItem{result: ItemResult{ID: id}}
+			item.fail("recording not found")
+			items = append(items, item)
+			continue
+		}
+		add(&batchItem{record: record})
+	}
+
+	for _, name := range batch.Files {
+		record, err := dao.FindFirstRecordByFilter(Collection, "file_path = {:name}", dbx.Params{"name": name})
+		switch {
+		case err == nil && owned[record.GetString("profile")]:
+			add(&batchItem{record: record})
+		case err != nil && s.config.Recorder.Owner(name) == batch.User:
+			// Recorded before the library, or its import failed
+			add(&batchItem{file: name})
+		default:
+			item := &batchItem{result: ItemResult{File: name}}
+			item.fail("file not found")
+			items = append(items, item)
+		}
+	}
+
+	matched, err := s.match(batch, owned)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range matched {
+		add(&batchItem{record: record})
+	}
+	if len(items) > MaxBatchDelete {
+		return nil, ErrBatchTooLarge
+	}
+
+	// Check everything before deleting anything
+	result := &BatchResult{DryRun: batch.DryRun, Items: make([]ItemResult, 0, len(items))}
+	failed := false
+	for _, item := range items {
+		if item.result.Status == ItemFailed {
+			failed = true
+			continue
+		}
+		switch {
+		case item.record != nil && item.record.GetString("status") == schedules.StatusRecording:
+			item.fail("still recording, stop it first")
+		case item.record != nil && item.record.GetString("status") == schedules.StatusScheduled:
+			item.fail("not recorded yet, cancel it instead")
+		case item.file != "" && s.config.Recorder.IsProtected(item.file):
+			item.fail("protected, unprotect it first")
+		}
+		if item.result.Status == ItemFailed {
+			failed = true
+		}
+	}
+
+	if batch.DryRun || failed && !batch.Partial {
+		for _, item := range items {
+			if item.result.Status == itemUnprocessed {
+				item.result.Status = ItemDeletable
+				if !batch.DryRun {
+					item.result.Status = ItemNotDeleted
+				}
+			}
+		}
+		return s.summarize(result, items), nil
+	}
+
+	// Files can't be put back, so they go first and the records of those
+	// gone are deleted together
+	var records []*models.Record
+	var removed []*batchItem
+	for _, item := range items {
+		if item.result.Status != itemUnprocessed {
+			continue
+		}
+		if item.file != "" {
+			if err := s.config.Recorder.DeleteFile(item.file); err != nil && !os.IsNotExist(err) {
+				switch {
+				case errors.Is(err, recorder.ErrProtected):
+					item.fail("protected, unprotect it first")
+				default:
+					item.fail(err.Error())
+				}
+				continue
+			}
+		}
+		if item.record != nil {
+			records = append(records, item.record)
+		}
+		removed = append(removed, item)
+	}
+
+	err = dao.RunInTransaction(func(txDao *daos.Dao) error {
+		for _, record := range records {
+			if err := txDao.DeleteRecord(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	for _, item := range removed {
+		if err != nil && item.record != nil {
+			item.fail("file deleted, but not its record: " + err.Error())
+			continue
+		}
+		item.result.Status = ItemDeleted
+	}
+
+	return s.summarize(result, items), nil
+}
+
+// match returns the recordings the filters of a batch select
+func (s *Service) match(batch BatchDelete, owned map[string]bool) ([]*models.Record, error) {
+	if !batch.Watched && batch.OlderThan.IsZero() {
+		return nil, nil
+	}
+
+	var profiles []interface{}
+	for id := range owned {
+		if batch.Profile == "" || id == batch.Profile {
+			profiles = append(profiles, id)
+		}
+	}
+	if len(profiles) == 0 {
+		return nil, nil
+	}
+
+	where := []dbx.Expression{
+		dbx.In("profile", profiles...),
+		dbx.NewExp("file_path != ''"),
+		dbx.In("status", schedules.StatusCompleted, schedules.StatusCompletedPartial, schedules.StatusFailed),
+	}
+	if !batch.OlderThan.IsZero() {
+		where = append(where, dbx.NewExp("actual_start < {:before}", dbx.Params{"before": dateTime(batch.OlderThan)}))
+	}
+	if batch.Watched {
+		var watched []interface{}
+		for _, profile := range profiles {
+			ids, err := s.config.Watch.WatchedRecordings(profile.(string))
+			if err != nil {
+				return nil, err
+			}
+			for _, id := range ids {
+				watched = append(watched, id)
+			}
+		}
+		if len(watched) == 0 {
+			return nil, nil
+		}
+		where = append(where, dbx.In("id", watched...))
+	}
+
+	records := []*models.Record{}
+	if err := s.app.Dao().RecordQuery(Collection).
+		Where(dbx.And(where...)).
+		OrderBy("actual_start ASC").
+		Limit(MaxBatchDelete + 1).
+		All(&records); err != nil {
+		return nil, err
+	}
+
+	kept := records[:0]
+	for _, record := range records {
+		if !s.config.Recorder.IsProtected(record.GetString("file_path")) {
+			kept = append(kept, record)
+		}
+	}
+	return kept, nil
+}
+
+func (s *Service) summarize(result *BatchResult, items []*batchItem) *BatchResult {
+	for _, item := range items {
+		switch item.result.Status {
+		case ItemDeleted:
+			result.Deleted++
+		case ItemFailed:
+			result.Failed++
+		}
+		result.Items = append(result.Items, item.result)
+	}
+	if result.Deleted > 0 {
+		s.logger.Info("recordings deleted", "deleted", result.Deleted, "failed", result.Failed)
+	}
+	return result
+}
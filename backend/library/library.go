@@ -0,0 +1,461 @@
+// Package library keeps every recorded file in the recordings collection,
+// which clients list, filter and page through. Scheduled recordings have
+// their record from the scheduler; recordings started by hand get one when
+// they start, files left on disk without one are imported at startup, and
+// finished files are probed for their duration and resolution, then
+// analyzed for chapters and ads.
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/types"
+
+	"iptv-backend/epg"
+	"iptv-backend/jobs"
+	"iptv-backend/logging"
+	"iptv-backend/recorder"
+	"iptv-backend/schedules"
+	"iptv-backend/thumbnail"
+	"iptv-backend/watch"
+)
+
+// Collection holds the recordings, one record per file
+const Collection = "recordings"
+
+// ProbeJobType is the job running ffprobe on a finished recording
+const ProbeJobType = "recording.probe"
+
+// ErrQueued is returned when a job of a recording is already queued
+var ErrQueued = errors.New("already queued")
+
+// Config wires the library to the recorder, the job manager, the watch
+// history, the guide and the channel thumbnails
+type Config struct {
+	Recorder   *recorder.RecorderService
+	Jobs       *jobs.Manager
+	Watch      *watch.Service
+	EPG        *epg.Service
+	Thumbnails *thumbnail.ThumbnailService // Posters of recordings without video
+
+	// DetectBreaks scans recordings for ad breaks when analyzing them
+	DetectBreaks bool
+}
+
+// Service keeps the recordings collection in step with the recorded files
+type Service struct {
+	app    core.App
+	config Config
+	logger *slog.Logger
+
+	mu     sync.Mutex
+	queued map[string]bool // Job types and records with a job queued
+}
+
+// NewService creates the library and registers its job types
+func NewService(app core.App, config Config) *Service {
+	s := &Service{
+		app:    app,
+		config: config,
+		logger: logging.For("library"),
+		queued: make(map[string]bool),
+	}
+
+	config.Jobs.Register(ProbeJobType, s.probe, jobs.TypeOptions{
+		MaxAttempts: 1,
+		Concurrency: 1,
+		Timeout:     2 * time.Minute,
+	})
+	// Ad detection decodes the whole recording
+	config.Jobs.Register(AnalyzeJobType, s.analyze, jobs.TypeOptions{
+		MaxAttempts: 1,
+		Concurrency: 1,
+		Timeout:     2 * time.Hour,
+	})
+	config.Jobs.Register(AdCutJobType, s.cut, jobs.TypeOptions{
+		MaxAttempts: 1,
+		Concurrency: 1,
+		Timeout:     2 * time.Hour,
+	})
+
+	return s
+}
+
+// Register probes recordings once they are saved completed, whoever
+// completed them: the scheduler, the recorder, a trim or an import. Saving
+// what the probe found has them analyzed next.
+func (s *Service) Register() {
+	s.app.OnModelAfterCreate(Collection).Add(func(e *core.ModelEvent) error {
+		if record, ok := e.Model.(*models.Record); ok {
+			s.probeIfDue(record)
+			s.analyzeIfDue(record)
+		}
+		return nil
+	})
+	s.app.OnModelAfterUpdate(Collection).Add(func(e *core.ModelEvent) error {
+		if record, ok := e.Model.(*models.Record); ok {
+			s.probeIfDue(record)
+			s.analyzeIfDue(record)
+		}
+		return nil
+	})
+}
+
+// DefaultProfile returns the first profile of a user, which recordings
+// started without a profile belong to
+func (s *Service) DefaultProfile(userID string) (*models.Record, error) {
+	profiles, err := s.app.Dao().FindRecordsByFilter("profiles", "user ~ {:user}", "created", 1, 0,
+		dbx.Params{"user": userID})
+	if err != nil {
+		return nil, err
+	}
+	if len(profiles) == 0 {
+		return nil, fmt.Errorf("user %s has no profile", userID)
+	}
+	return profiles[0], nil
+}
+
+// Track creates the record of a recording started by hand. profileID may
+// be empty for the user's first profile. Scheduled recordings already have
+// theirs and are returned as is.
+func (s *Service) Track(rec *recorder.Recording, profileID string) (*models.Record, error) {
+	dao := s.app.Dao()
+	if record, err := dao.FindRecordById(Collection, rec.ID); err == nil {
+		return record, nil
+	}
+
+	if profileID == "" {
+		profile, err := s.DefaultProfile(rec.UserID)
+		if err != nil {
+			return nil, err
+		}
+		profileID = profile.Id
+	}
+
+	collection, err := dao.FindCollectionByNameOrId(Collection)
+	if err != nil {
+		return nil, err
+	}
+
+	info := rec.Info()
+	record := models.NewRecord(collection)
+	record.Set("profile", profileID)
+	record.Set("channel", info.ChannelID)
+	record.Set("program_title", info.Title)
+	record.Set("actual_start", info.StartedAt)
+	record.Set("status", schedules.StatusRecording)
+	record.Set("file_path", filepath.Base(info.OutputPath))
+	record.Set("mode", info.Mode)
+	if err := dao.SaveRecord(record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// Forget deletes the records of a deleted file
+func (s *Service) Forget(filename string) {
+	records, err := s.app.Dao().FindRecordsByFilter(Collection, "file_path = {:name}", "", 0, 0,
+		dbx.Params{"name": filename})
+	if err != nil {
+		return
+	}
+	for _, record := range records {
+		if err := s.app.Dao().DeleteRecord(record); err != nil {
+			s.logger.Warn("failed to delete recording record", "recording_id", record.Id, "file", filename, "error", err)
+		}
+	}
+}
+
+// HandleRecorderEvent completes the records of recordings started by hand.
+// The scheduler completes those it started.
+func (s *Service) HandleRecorderEvent(event recorder.Event, rec *recorder.Recording, err error) {
+	if event == recorder.EventStarted {
+		return
+	}
+
+	dao := s.app.Dao()
+	if _, findErr := dao.FindRecordById(Collection, rec.ID); findErr == nil {
+		return
+	}
+
+	info := rec.Info()
+	record, findErr := dao.FindFirstRecordByFilter(Collection, "file_path = {:name} && status = {:status}", dbx.Params{
+		"name":   filepath.Base(info.OutputPath),
+		"status": schedules.StatusRecording,
+	})
+	if findErr != nil {
+		return
+	}
+
+	if info.StoppedAt != nil {
+		record.Set("actual_end", *info.StoppedAt)
+	}
+	record.Set("file_size", info.BytesWritten)
+	switch {
+	case event == recorder.EventFailed:
+		record.Set("status", schedules.StatusFailed)
+		record.Set("error", fmt.Sprint(err))
+	case info.Status == recorder.StatusCompletedPartial:
+		record.Set("status", schedules.StatusCompletedPartial)
+	default:
+		record.Set("status", schedules.StatusCompleted)
+	}
+	if err := dao.SaveRecord(record); err != nil {
+		s.logger.Warn("failed to save recording", "recording_id", record.Id, "error", err)
+	}
+}
+
+// recordedName matches the files the recorder writes, Title_YYYYMMDD_HHMMSS,
+// and their trimmed copies
+var recordedName = regexp.MustCompile(`^(.*)_(\d{8}_\d{6})(?:_trimmed(?:_\d+)?)?$`)
+
+// Import brings the recorder and the collection back in step at startup.
+// Recordings started by hand that a restart interrupted are completed, and
+// files without a record get one in their owner's first profile; files
+// recorded before owners were tracked are left out. Completed recordings
+// never probed or analyzed are queued for it.
+func (s *Service) Import() {
+	dao := s.app.Dao()
+
+	// The recorder doesn't resume recordings started by hand
+	interrupted, err := dao.FindRecordsByFilter(Collection, "status = {:status} && scheduled_end = ''", "", 0, 0,
+		dbx.Params{"status": schedules.StatusRecording})
+	if err != nil {
+		s.logger.Warn("failed to load interrupted recordings", "error", err)
+	}
+	active := make(map[string]bool)
+	for _, rec := range s.config.Recorder.GetAllRecordings() {
+		active[filepath.Base(rec.OutputPath)] = true
+	}
+	for _, record := range interrupted {
+		if active[record.GetString("file_path")] {
+			continue
+		}
+		if stat, err := s.config.Recorder.StatFile(record.GetString("file_path")); err == nil {
+			record.Set("file_size", stat.Size)
+			record.Set("actual_end", stat.ModTime)
+		}
+		record.Set("status", schedules.StatusCompletedPartial)
+		if err := dao.SaveRecord(record); err != nil {
+			s.logger.Warn("failed to save recording", "recording_id", record.Id, "error", err)
+		}
+	}
+
+	files, err := s.config.Recorder.ListFiles()
+	if err != nil {
+		s.logger.Warn("failed to list recorded files", "error", err)
+		return
+	}
+	names := make(map[string]bool, len(files))
+	for _, file := range files {
+		names[file.Key] = true
+	}
+
+	imported := 0
+	for _, file := range files {
+		if recorder.IsSubtitleSidecar(file.Key) || active[file.Key] {
+			continue
+		}
+		if _, err := dao.FindFirstRecordByFilter(Collection, "file_path = {:name}", dbx.Params{"name": file.Key}); err == nil {
+			continue
+		}
+		owner := s.config.Recorder.Owner(file.Key)
+		if owner == "" {
+			continue
+		}
+		profile, err := s.DefaultProfile(owner)
+		if err != nil {
+			continue
+		}
+
+		if err := s.importFile(profile.Id, file.Key, file.Size, file.ModTime, names); err != nil {
+			s.logger.Warn("failed to import recording", "file", file.Key, "error", err)
+			continue
+		}
+		imported++
+	}
+	if imported > 0 {
+		s.logger.Info("imported recorded files", "count", imported)
+	}
+
+	unprobed, err := dao.FindRecordsByFilter(Collection,
+		"(status = {:completed} || status = {:partial}) && file_path != '' && probed_at = ''", "", 0, 0,
+		dbx.Params{"completed": schedules.StatusCompleted, "partial": schedules.StatusCompletedPartial})
+	if err != nil {
+		s.logger.Warn("failed to load unprobed recordings", "error", err)
+		return
+	}
+	for _, record := range unprobed {
+		s.probeIfDue(record)
+	}
+
+	unanalyzed, err := dao.FindRecordsByFilter(Collection,
+		"(status = {:completed} || status = {:partial}) && file_path != '' && probed_at != '' && chapters_at = ''", "", 0, 0,
+		dbx.Params{"completed": schedules.StatusCompleted, "partial": schedules.StatusCompletedPartial})
+	if err != nil {
+		s.logger.Warn("failed to load unanalyzed recordings", "error", err)
+		return
+	}
+	for _, record := range unanalyzed {
+		s.analyzeIfDue(record)
+	}
+}
+
+// importFile creates the record of a file found without one, its title and
+// start read from the name the recorder gave it
+func (s *Service) importFile(profileID, name string, size int64, modTime time.Time, names map[string]bool) error {
+	collection, err := s.app.Dao().FindCollectionByNameOrId(Collection)
+	if err != nil {
+		return err
+	}
+
+	stem := strings.TrimSuffix(name, filepath.Ext(name))
+	title := stem
+	record := models.NewRecord(collection)
+	if match := recordedName.FindStringSubmatch(stem); match != nil {
+		title = match[1]
+		if start, err := time.ParseInLocation("20060102_150405", match[2], time.Local); err == nil {
+			record.Set("actual_start", start)
+		}
+	}
+	title = strings.TrimSpace(strings.ReplaceAll(title, "_", " "))
+	if title == "" {
+		title = name
+	}
+
+	record.Set("profile", profileID)
+	record.Set("program_title", title)
+	record.Set("actual_end", modTime)
+	record.Set("status", schedules.StatusCompleted)
+	record.Set("file_path", name)
+	record.Set("file_size", size)
+	record.Set("mode", modeOf(name))
+	if sidecar := filepath.Base(recorder.SubtitleSidecarPath(name)); names[sidecar] {
+		record.Set("subtitle_path", sidecar)
+	}
+	return s.app.Dao().SaveRecord(record)
+}
+
+// modeOf returns the recording mode a file was written in
+func modeOf(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".aac":
+		return recorder.ModeAAC
+	case ".mp3":
+		return recorder.ModeMP3
+	case ".opus":
+		return recorder.ModeOpus
+	}
+	return recorder.ModeVideo
+}
+
+// finished reports whether a recording is done and has a file
+func finished(record *models.Record) bool {
+	status := record.GetString("status")
+	return (status == schedules.StatusCompleted || status == schedules.StatusCompletedPartial) &&
+		record.GetString("file_path") != ""
+}
+
+// probeIfDue queues a probe of a completed recording not probed yet
+func (s *Service) probeIfDue(record *models.Record) {
+	if !finished(record) || !record.GetDateTime("probed_at").IsZero() {
+		return
+	}
+	if _, err := s.enqueue(ProbeJobType, record.Id); err != nil && err != ErrQueued {
+		s.logger.Warn("failed to queue recording probe", "recording_id", record.Id, "error", err)
+	}
+}
+
+// enqueue queues a job of a recording, unless one of the same type is
+// queued already. flags are set true in its payload.
+func (s *Service) enqueue(jobType, recordID string, flags ...string) (*jobs.Job, error) {
+	key := jobType + ":" + recordID
+	s.mu.Lock()
+	if s.queued[key] {
+		s.mu.Unlock()
+		return nil, ErrQueued
+	}
+	s.queued[key] = true
+	s.mu.Unlock()
+
+	payload := map[string]interface{}{"recording_id": recordID}
+	for _, flag := range flags {
+		payload[flag] = true
+	}
+	job, err := s.config.Jobs.Enqueue(jobType, "", payload)
+	if err != nil {
+		s.done(jobType, recordID)
+		return nil, err
+	}
+	return job, nil
+}
+
+// done lets a job of a recording be queued again
+func (s *Service) done(jobType, recordID string) {
+	s.mu.Lock()
+	delete(s.queued, jobType+":"+recordID)
+	s.mu.Unlock()
+}
+
+// probe saves what ffprobe finds in a recording. Without a duration in the
+// file, the time it was recorded for is kept instead.
+func (s *Service) probe(ctx context.Context, job *jobs.Job, progress jobs.ProgressFunc) (interface{}, error) {
+	var payload struct {
+		RecordingID string `json:"recording_id"`
+	}
+	if err := job.DecodePayload(&payload); err != nil {
+		return nil, err
+	}
+	defer s.done(ProbeJobType, payload.RecordingID)
+
+	dao := s.app.Dao()
+	record, err := dao.FindRecordById(Collection, payload.RecordingID)
+	if err != nil {
+		return nil, nil // Deleted since
+	}
+
+	info, probeErr := s.config.Recorder.ProbeFile(ctx, record.GetString("file_path"))
+	if probeErr != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		s.logger.Debug("failed to probe recording", "recording_id", record.Id, "file", record.GetString("file_path"), "error", probeErr)
+		info = &recorder.MediaInfo{}
+	}
+
+	duration := info.Duration
+	if duration == 0 {
+		start, end := record.GetDateTime("actual_start").Time(), record.GetDateTime("actual_end").Time()
+		if !start.IsZero() && end.After(start) {
+			duration = end.Sub(start).Seconds()
+		}
+	}
+
+	now, _ := types.ParseDateTime(time.Now())
+	record.Set("duration", duration)
+	record.Set("width", info.Width)
+	record.Set("height", info.Height)
+	record.Set("video_codec", info.VideoCodec)
+	record.Set("audio_codec", info.AudioCodec)
+	record.Set("probed_at", now)
+	if err := dao.SaveRecord(record); err != nil {
+		return nil, err
+	}
+
+	result := map[string]interface{}{"duration": duration, "width": info.Width, "height": info.Height}
+	if probeErr != nil {
+		result["error"] = probeErr.Error()
+	}
+	return result, nil
+}
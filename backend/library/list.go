@@ -0,0 +1,220 @@
+package library
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/types"
+
+	"iptv-backend/recorder"
+)
+
+// Sort keys of the listing, by the field they order
+var sortFields = map[string]string{
+	"started":  "actual_start",
+	"title":    "program_title",
+	"size":     "file_size",
+	"duration": "duration",
+	"channel":  "channel",
+}
+
+var ErrInvalidSort = errors.New("sort must be started, title, size, duration or channel, prefixed with - for descending")
+
+// Query selects the recorded files of a user
+type Query struct {
+	User     string
+	Profiles []string // Restricts to these profiles of the user
+	Channel  string
+	Status   string
+	From     time.Time // Started at or after
+	To       time.Time // Started before
+	Sort     string    // A key of sortFields, prefixed with - for descending; -started by default
+	Page     int
+	PerPage  int
+}
+
+// File is a recorded file with its record
+type File struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	Title       string     `json:"title"`
+	Profile     string     `json:"profile"`
+	Channel     string     `json:"channel,omitempty"`
+	ChannelName string     `json:"channel_name,omitempty"`
+	Schedule    string     `json:"schedule,omitempty"`
+	Status      string     `json:"status"`
+	Mode        string     `json:"mode"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	EndedAt     *time.Time `json:"ended_at,omitempty"`
+	Size        int64      `json:"size"`
+	Duration    float64    `json:"duration"` // Seconds, 0 until probed
+	Width       int        `json:"width,omitempty"`
+	Height      int        `json:"height,omitempty"`
+	VideoCodec  string     `json:"video_codec,omitempty"`
+	AudioCodec  string     `json:"audio_codec,omitempty"`
+	Subtitles   string     `json:"subtitles,omitempty"`
+	Protected   bool       `json:"protected"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// Page is a page of files, shaped like PocketBase lists
+type Page struct {
+	Page       int     `json:"page"`
+	PerPage    int     `json:"perPage"`
+	TotalItems int     `json:"totalItems"`
+	TotalPages int     `json:"totalPages"`
+	Items      []*File `json:"items"`
+}
+
+// List returns a page of the user's recorded files matching query.
+// Scheduled recordings that haven't started have no file and are left out.
+func (s *Service) List(query Query) (*Page, error) {
+	if query.Page < 1 {
+		query.Page = 1
+	}
+	if query.PerPage < 1 || query.PerPage > 200 {
+		query.PerPage = 50
+	}
+
+	order := []string{"actual_start DESC"}
+	if query.Sort != "" {
+		key, direction := strings.TrimPrefix(query.Sort, "-"), "ASC"
+		if strings.HasPrefix(query.Sort, "-") {
+			direction = "DESC"
+		}
+		field, ok := sortFields[key]
+		if !ok {
+			return nil, ErrInvalidSort
+		}
+		order = []string{field + " " + direction}
+	}
+	order = append(order, "rowid DESC")
+
+	dao := s.app.Dao()
+	profiles, err := dao.FindRecordsByFilter("profiles", "user ~ {:user}", "", 0, 0, dbx.Params{"user": query.User})
+	if err != nil {
+		return nil, err
+	}
+	allowed := make(map[string]bool, len(query.Profiles))
+	for _, id := range query.Profiles {
+		allowed[id] = true
+	}
+	var ids []interface{}
+	for _, profile := range profiles {
+		if len(allowed) == 0 || allowed[profile.Id] {
+			ids = append(ids, profile.Id)
+		}
+	}
+
+	page := &Page{Page: query.Page, PerPage: query.PerPage, Items: []*File{}}
+	if len(ids) == 0 {
+		return page, nil
+	}
+
+	where := []dbx.Expression{
+		dbx.In("profile", ids...),
+		dbx.NewExp("file_path != ''"),
+	}
+	if query.Channel != "" {
+		where = append(where, dbx.HashExp{"channel": query.Channel})
+	}
+	if query.Status != "" {
+		where = append(where, dbx.HashExp{"status": query.Status})
+	}
+	if !query.From.IsZero() {
+		where = append(where, dbx.NewExp("actual_start >= {:from}", dbx.Params{"from": dateTime(query.From)}))
+	}
+	if !query.To.IsZero() {
+		where = append(where, dbx.NewExp("actual_start < {:to}", dbx.Params{"to": dateTime(query.To)}))
+	}
+
+	if err := dao.RecordQuery(Collection).
+		Select("count(*)").
+		Where(dbx.And(where...)).
+		Row(&page.TotalItems); err != nil {
+		return nil, err
+	}
+	page.TotalPages = (page.TotalItems + page.PerPage - 1) / page.PerPage
+
+	records := []*models.Record{}
+	if err := dao.RecordQuery(Collection).
+		Where(dbx.And(where...)).
+		OrderBy(order...).
+		Offset(int64((query.Page - 1) * query.PerPage)).
+		Limit(int64(query.PerPage)).
+		All(&records); err != nil {
+		return nil, err
+	}
+
+	// Channel names, in one query for the page
+	var channelIDs []string
+	for _, record := range records {
+		if id := record.GetString("channel"); id != "" {
+			channelIDs = append(channelIDs, id)
+		}
+	}
+	channelNames := make(map[string]string)
+	if len(channelIDs) > 0 {
+		channels, err := dao.FindRecordsByIds("channels", channelIDs)
+		if err != nil {
+			return nil, err
+		}
+		for _, channel := range channels {
+			channelNames[channel.Id] = channel.GetString("name")
+		}
+	}
+
+	for _, record := range records {
+		page.Items = append(page.Items, s.fileOf(record, channelNames[record.GetString("channel")]))
+	}
+
+	return page, nil
+}
+
+// fileOf describes the file of a recording
+func (s *Service) fileOf(record *models.Record, channelName string) *File {
+	name := record.GetString("file_path")
+	file := &File{
+		ID:          record.Id,
+		Name:        name,
+		Title:       record.GetString("program_title"),
+		Profile:     record.GetString("profile"),
+		Channel:     record.GetString("channel"),
+		ChannelName: channelName,
+		Schedule:    record.GetString("schedule"),
+		Status:      record.GetString("status"),
+		Mode:        record.GetString("mode"),
+		StartedAt:   timeField(record, "actual_start"),
+		EndedAt:     timeField(record, "actual_end"),
+		Size:        int64(record.GetInt("file_size")),
+		Duration:    record.GetFloat("duration"),
+		Width:       record.GetInt("width"),
+		Height:      record.GetInt("height"),
+		VideoCodec:  record.GetString("video_codec"),
+		AudioCodec:  record.GetString("audio_codec"),
+		Subtitles:   record.GetString("subtitle_path"),
+		Protected:   s.config.Recorder.IsProtected(name),
+		Error:       record.GetString("error"),
+	}
+	if file.Mode == "" {
+		file.Mode = recorder.ModeVideo
+	}
+	return file
+}
+
+func timeField(record *models.Record, field string) *time.Time {
+	t := record.GetDateTime(field).Time()
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// dateTime formats t like PocketBase stores dates, so they compare as text
+func dateTime(t time.Time) string {
+	dt, _ := types.ParseDateTime(t)
+	return dt.String()
+}
@@ -0,0 +1,190 @@
+package library
+
+import (
+	"iptv-backend/recorder"
+)
+
+// Channels show their logo in a corner of the picture and drop it during
+// ads. In frames sampled across a recording, the corner with the most
+// pixels on an edge in most frames holds the logo; frames showing few of
+// those edges are without it.
+const (
+	cornerWidth  = recorder.FrameWidth / 4
+	cornerHeight = recorder.FrameHeight / 4
+
+	// Brightness step between neighbouring pixels taken for an edge
+	edgeThreshold = 24
+
+	// Share of frames a pixel of the logo is on an edge in, and share of
+	// the logo's pixels a frame shows it with
+	logoSteadiness = 0.6
+	logoPresence   = 0.5
+
+	// A logo smaller than this isn't told from noise
+	minLogoPixels = 12
+
+	// Rows or columns of steady edges wider than this share of a corner are
+	// lines, such as letterboxing, rather than a logo
+	maxLogoLine = 0.8
+
+	// A logo missing from more of the recording than this was mistaken
+	maxLogoAbsence = 0.5
+)
+
+// logoTracker collects the edges of the corners of sampled frames
+type logoTracker struct {
+	times  []float64
+	edges  [][4][]bool // By frame and corner
+	counts [4][]int    // Frames each pixel of each corner is on an edge in
+}
+
+func newLogoTracker() *logoTracker {
+	t := &logoTracker{}
+	for corner := range t.counts {
+		t.counts[corner] = make([]int, cornerWidth*cornerHeight)
+	}
+	return t
+}
+
+// add takes a frame sampled at seconds into the recording
+func (t *logoTracker) add(at float64, pixels []byte) {
+	var frame [4][]bool
+	for corner := range frame {
+		left, top := 0, 0
+		if corner%2 == 1 {
+			left = recorder.FrameWidth - cornerWidth - 1
+		}
+		if corner >= 2 {
+			top = recorder.FrameHeight - cornerHeight - 1
+		}
+
+		edges := make([]bool, cornerWidth*cornerHeight)
+		for y := 0; y < cornerHeight; y++ {
+			for x := 0; x < cornerWidth; x++ {
+				i := (top+y)*recorder.FrameWidth + left + x
+				step := abs(int(pixels[i+1])-int(pixels[i])) + abs(int(pixels[i+recorder.FrameWidth])-int(pixels[i]))
+				if step > edgeThreshold {
+					edges[y*cornerWidth+x] = true
+					t.counts[corner][y*cornerWidth+x]++
+				}
+			}
+		}
+		frame[corner] = edges
+	}
+	t.times = append(t.times, at)
+	t.edges = append(t.edges, frame)
+}
+
+// absences returns the stretches the logo was missing for, nil when no
+// logo was found
+func (t *logoTracker) absences() []recorder.Span {
+	frames := len(t.times)
+	if frames < 10 {
+		return nil
+	}
+
+	// The logo is in the corner with the most steady edges
+	best, bestMask, bestCount := -1, []bool(nil), 0
+	for corner := range t.counts {
+		mask := make([]bool, cornerWidth*cornerHeight)
+		for i, count := range t.counts[corner] {
+			mask[i] = float64(count) >= logoSteadiness*float64(frames)
+		}
+		dropLines(mask)
+
+		count := 0
+		for _, on := range mask {
+			if on {
+				count++
+			}
+		}
+		if count > bestCount {
+			best, bestMask, bestCount = corner, mask, count
+		}
+	}
+	if bestCount < minLogoPixels {
+		return nil
+	}
+
+	present := make([]bool, frames)
+	for n, frame := range t.edges {
+		shown := 0
+		for i, on := range bestMask {
+			if on && frame[best][i] {
+				shown++
+			}
+		}
+		present[n] = float64(shown) >= logoPresence*float64(bestCount)
+	}
+
+	// A single frame showing the logo within an absence is noise
+	for n := 1; n < frames-1; n++ {
+		if present[n] && !present[n-1] && !present[n+1] {
+			present[n] = false
+		}
+	}
+
+	var spans []recorder.Span
+	missing := 0
+	for n := 0; n < frames; {
+		if present[n] {
+			n++
+			continue
+		}
+		first := n
+		for n < frames && !present[n] {
+			n++
+		}
+		missing += n - first
+		spans = append(spans, recorder.Span{Start: t.times[first], End: t.times[n-1] + recorder.FrameInterval})
+	}
+	if float64(missing) > maxLogoAbsence*float64(frames) {
+		return nil
+	}
+	return spans
+}
+
+// dropLines clears the rows and columns of a corner mask too full to be
+// part of a logo
+func dropLines(mask []bool) {
+	var rows, columns []int
+	for y := 0; y < cornerHeight; y++ {
+		count := 0
+		for x := 0; x < cornerWidth; x++ {
+			if mask[y*cornerWidth+x] {
+				count++
+			}
+		}
+		if float64(count) > maxLogoLine*cornerWidth {
+			rows = append(rows, y)
+		}
+	}
+	for x := 0; x < cornerWidth; x++ {
+		count := 0
+		for y := 0; y < cornerHeight; y++ {
+			if mask[y*cornerWidth+x] {
+				count++
+			}
+		}
+		if float64(count) > maxLogoLine*cornerHeight {
+			columns = append(columns, x)
+		}
+	}
+	for _, y := range rows {
+		for x := 0; x < cornerWidth; x++ {
+			mask[y*cornerWidth+x] = false
+		}
+	}
+	for _, x := range columns {
+		for y := 0; y < cornerHeight; y++ {
+			mask[y*cornerWidth+x] = false
+		}
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,37 @@
+package library
+
+import (
+	"context"
+	"time"
+)
+
+// Prune deletes the finished recordings of every user started before a
+// time, as a partial BatchDelete with OlderThan, for the retention period
+// of the recorder. It returns how many recordings were deleted, and how
+// many were kept because they are protected or their deletion failed.
+func (s *Service) Prune(ctx context.Context, before time.Time) (deleted, kept int, err error) {
+	users, err := s.app.Dao().FindRecordsByFilter("users", "id != ''", "", 0, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, user := range users {
+		if err := ctx.Err(); err != nil {
+			return deleted, kept, err
+		}
+
+		result, err := s.Delete(BatchDelete{User: user.Id, OlderThan: before, Partial: true})
+		if err != nil {
+			// Such as more than MaxBatchDelete recordings past retention
+			s.logger.Warn("failed to prune recordings", "user", user.Id, "error", err)
+			continue
+		}
+		deleted += result.Deleted
+		kept += result.Failed
+	}
+
+	if deleted > 0 {
+		s.logger.Info("pruned recordings past retention", "deleted", deleted, "kept", kept, "before", before)
+	}
+	return deleted, kept, nil
+}
@@ -0,0 +1,44 @@
+package library
+
+import (
+	"context"
+	"time"
+
+	"github.com/pocketbase/pocketbase/models"
+
+	"iptv-backend/recorder"
+)
+
+// Trim writes the ranges of a recording to a new file owned by userID and
+// gives it its own record, copied from the original's. The original is left
+// as is.
+func (s *Service) Trim(ctx context.Context, original *models.Record, userID string, ranges []recorder.KeepRange) (*models.Record, error) {
+	ranges, err := recorder.ValidateRanges(ranges)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := s.config.Recorder.TrimFile(ctx, original.GetString("file_path"), userID, ranges)
+	if err != nil {
+		return nil, err
+	}
+
+	trimmed := models.NewRecord(original.Collection())
+	for _, field := range []string{"profile", "channel", "program_title", "scheduled_start", "scheduled_end"} {
+		trimmed.Set(field, original.Get(field))
+	}
+	// Ranges are sorted, the copy starts with the first
+	if start := original.GetDateTime("actual_start"); !start.IsZero() {
+		trimmed.Set("actual_start", start.Time().Add(time.Duration(ranges[0].Start*float64(time.Second))))
+	}
+	trimmed.Set("status", "completed")
+	trimmed.Set("file_path", file.Key)
+	trimmed.Set("file_size", file.Size)
+	if err := s.app.Dao().SaveRecord(trimmed); err != nil {
+		if err := s.config.Recorder.DeleteFile(file.Key); err != nil {
+			s.logger.Warn("failed to remove trimmed recording", "file", file.Key, "error", err)
+		}
+		return nil, err
+	}
+	return trimmed, nil
+}
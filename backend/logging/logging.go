@@ -0,0 +1,157 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/labstack/echo/v5"
+)
+
+// RequestIDHeader is the header used to propagate request IDs
+const RequestIDHeader = "X-Request-ID"
+
+// ContextLoggerKey is the echo context key holding the request-scoped logger
+const ContextLoggerKey = "logger"
+
+type requestIDKey struct{}
+
+// level is shared by every logger so it can be changed at runtime
+var level = new(slog.LevelVar)
+
+// Init configures the default structured logger.
+// levelName is one of debug, info, warn, error; format is text or json.
+func Init(levelName, format string) {
+	InitWithWriter(os.Stderr, levelName, format)
+}
+
+// InitWithWriter configures the default structured logger writing to w
+func InitWithWriter(w io.Writer, levelName, format string) {
+	if l, err := ParseLevel(levelName); err == nil {
+		level.Set(l)
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	if strings.EqualFold(format, "json") {
+		handler = slog.NewJSONHandler(w, opts)
+	} else {
+		handler = slog.NewTextHandler(w, opts)
+	}
+
+	slog.SetDefault(slog.New(handler))
+}
+
+// For returns a logger tagged with the given service name
+func For(service string) *slog.Logger {
+	return slog.Default().With("service", service)
+}
+
+// ParseLevel converts a level name to a slog level
+func ParseLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info", "":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, fmt.Errorf("unknown log level %q", name)
+}
+
+// SetLevel changes the log level of all loggers at runtime
+func SetLevel(name string) error {
+	l, err := ParseLevel(name)
+	if err != nil {
+		return err
+	}
+	level.Set(l)
+	return nil
+}
+
+// GetLevel returns the current log level name
+func GetLevel() string {
+	return strings.ToLower(level.Level().String())
+}
+
+// NewRequestID generates a random request identifier
+func NewRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// WithRequestID stores a request ID in the context
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID stored in the context, if any
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// FromContext returns the default logger enriched with the context's request ID
+func FromContext(ctx context.Context) *slog.Logger {
+	logger := slog.Default()
+	if id := RequestID(ctx); id != "" {
+		logger = logger.With("request_id", id)
+	}
+	return logger
+}
+
+// Middleware assigns a request ID to every request (reusing an incoming
+// X-Request-ID header when present), echoes it back in the response and
+// makes a request-scoped logger available via FromEcho.
+func Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+
+			id := req.Header.Get(RequestIDHeader)
+			if id == "" || len(id) > 64 {
+				id = NewRequestID()
+			}
+
+			ctx := WithRequestID(req.Context(), id)
+			c.SetRequest(req.WithContext(ctx))
+			c.Response().Header().Set(RequestIDHeader, id)
+
+			logger := slog.Default().With("request_id", id)
+			c.Set(ContextLoggerKey, logger)
+
+			err := next(c)
+
+			logger.Debug("request handled",
+				"method", req.Method,
+				"path", req.URL.Path,
+				"status", c.Response().Status,
+			)
+
+			return err
+		}
+	}
+}
+
+// FromEcho returns the request-scoped logger set by Middleware
+func FromEcho(c echo.Context) *slog.Logger {
+	if logger, ok := c.Get(ContextLoggerKey).(*slog.Logger); ok {
+		return logger
+	}
+	return FromContext(c.Request().Context())
+}
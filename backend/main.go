@@ -4,32 +4,75 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/dbx"
 	"github.com/pocketbase/pocketbase"
 	"github.com/pocketbase/pocketbase/apis"
 	"github.com/pocketbase/pocketbase/core"
 	"github.com/pocketbase/pocketbase/models"
 	"github.com/pocketbase/pocketbase/models/schema"
 	"github.com/pocketbase/pocketbase/plugins/migratecmd"
-	"github.com/pocketbase/pocketbase/tokens"
+	"github.com/pocketbase/pocketbase/resolvers"
+	pbsearch "github.com/pocketbase/pocketbase/tools/search"
 	"github.com/pocketbase/pocketbase/tools/types"
 	"github.com/pquerna/otp"
 	"github.com/pquerna/otp/totp"
 	qrcode "github.com/skip2/go-qrcode"
 
+	"iptv-backend/access"
+	"iptv-backend/account"
+	"iptv-backend/apikeys"
+	"iptv-backend/audit"
+	"iptv-backend/cast"
+	"iptv-backend/connections"
+	"iptv-backend/dedupe"
+	"iptv-backend/epg"
+	"iptv-backend/events"
+	"iptv-backend/favorites"
+	"iptv-backend/groups"
+	"iptv-backend/health"
+	"iptv-backend/jobs"
+	"iptv-backend/library"
+	"iptv-backend/logging"
+	"iptv-backend/maintenance"
 	_ "iptv-backend/migrations"
+	"iptv-backend/notifications"
+	"iptv-backend/openapi"
+	"iptv-backend/parental"
+	"iptv-backend/playlist"
+	"iptv-backend/postprocess"
+	"iptv-backend/quota"
+	"iptv-backend/ratelimit"
+	"iptv-backend/recommend"
 	"iptv-backend/recorder"
+	"iptv-backend/reminders"
+	"iptv-backend/schedules"
+	"iptv-backend/search"
+	"iptv-backend/sessions"
+	"iptv-backend/settings"
+	"iptv-backend/sso"
+	"iptv-backend/storage"
+	"iptv-backend/stream"
+	"iptv-backend/streamtokens"
 	"iptv-backend/subtitle"
 	"iptv-backend/thumbnail"
+	"iptv-backend/upstream"
+	"iptv-backend/watch"
+	"iptv-backend/webhooks"
 )
 
 // Global recorder service
@@ -41,744 +84,5841 @@ var thumbnailService *thumbnail.ThumbnailService
 // Global subtitle service
 var subtitleService *subtitle.SubtitleService
 
+// Global background job manager
+var jobManager *jobs.Manager
+
+// Global recordings library, the record of each recorded file
+var libraryService *library.Service
+
+// Counts of running thumbnail batch jobs, by job id
+var thumbnailBatches sync.Map
+
+// Global stream proxy and URL visibility service
+var streamService *stream.Service
+
+// Global notification service
+var notificationService *notifications.Service
+
+// Global maintenance task scheduler
+var maintenanceScheduler *maintenance.Scheduler
+
+// Global outbound webhook service
+var webhookService *webhooks.Service
+
+// Global hub of the clients connected to /api/events
+var eventHub *events.Hub
+
+// Global per-user storage quota service
+var quotaService *quota.Service
+
+// Global audit log of security-sensitive actions
+var auditService *audit.Service
+
+// Global API key service for scripts and plugins
+var apiKeyService *apikeys.Service
+
+// Global stream token service for players that can't sign in
+var streamTokenService *streamtokens.Service
+
+// Global cast service playing channels and recordings on LAN devices
+var castService *cast.Service
+
+// Global parental control service for restricted profiles
+var parentalService *parental.Service
+
+// Global sign-in session service
+var sessionService *sessions.Service
+
+// Global account export and deletion service
+var accountService *account.Service
+
+// Global playback progress service for continue watching
+var watchService *watch.Service
+
+// Global channel and programme recommendation service
+var recommendService *recommend.Service
+
+// Global programme reminder service
+var reminderService *reminders.Service
+
+// Global programme guide service
+var epgService *epg.Service
+
+// Global guide import scheduler
+var epgScheduler *epg.Scheduler
+
+// Global channel deduplication service
+var dedupeService *dedupe.Service
+
+// Global service of the user's own channel groups
+var groupService *groups.Service
+
+// Global channel and programme search service
+var searchService *search.Service
+
+// Global resolver of the HTTP headers providers require
+var upstreamResolver *upstream.Resolver
+
+// Global provider connection counting service
+var connectionService *connections.Service
+
+// Global recording scheduler
+var scheduleService *schedules.Service
+
+// Global settings changed at runtime
+var settingsService *settings.Service
+
+// Global checks of the programs and servers the backend depends on
+var healthChecker *health.Checker
+
+// Global rate limits of the endpoints starting expensive work
+var rateLimiter *ratelimit.Limiter
+
 func main() {
+	logging.Init(os.Getenv("LOG_LEVEL"), os.Getenv("LOG_FORMAT"))
+	logger := logging.For("app")
+
 	app := pocketbase.New()
 
-	// Initialize recorder service
-	recordingsDir := filepath.Join(app.DataDir(), "recordings")
-	recorderService = recorder.NewRecorderService(recordingsDir)
+	// Headers set per playlist, sent with every request to its provider,
+	// and the options ffmpeg reads every input with
+	upstreamResolver = upstream.NewResolver(app)
+	inputOptions := upstream.DefaultInputOptions()
+	if v, err := strconv.ParseBool(os.Getenv("FFMPEG_RECONNECT")); err == nil {
+		inputOptions.Reconnect = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("FFMPEG_RECONNECT_DELAY_MAX")); err == nil && v >= 0 {
+		inputOptions.ReconnectDelayMax = time.Duration(v) * time.Second
+	}
+	if v, err := strconv.Atoi(os.Getenv("FFMPEG_RW_TIMEOUT")); err == nil && v >= 0 {
+		inputOptions.RWTimeout = time.Duration(v) * time.Second
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("FFMPEG_ANALYZEDURATION"), 64); err == nil && v >= 0 {
+		inputOptions.AnalyzeDuration = time.Duration(v * float64(time.Second))
+	}
+	// ffmpeg refuses to probe fewer than 32 bytes
+	if v, err := strconv.ParseInt(os.Getenv("FFMPEG_PROBESIZE"), 10, 64); err == nil && (v == 0 || v >= 32) {
+		inputOptions.ProbeSize = v
+	}
+	upstreamResolver.SetInputOptions(inputOptions)
+
+	// Initialize recorder service, its directory can be moved with the
+	// recorder_config setting
+	recorderService = recorder.NewRecorderService(filepath.Join(app.DataDir(), "recordings"))
+	recorderService.SetInputArgs(upstreamResolver.FFmpegArgs)
+
+	// Normalize the loudness of recordings whose profile asks for it
+	recorderService.SetLoudness(func(recordingID string) *recorder.Loudness {
+		recording, err := app.Dao().FindRecordById("recordings", recordingID)
+		if err != nil {
+			return nil
+		}
+		profile, err := app.Dao().FindRecordById("profiles", recording.GetString("profile"))
+		if err != nil || !profile.GetBool("recording_loudnorm") {
+			return nil
+		}
+		target := profile.GetFloat("recording_loudness")
+		if target == 0 {
+			target = recorder.DefaultLoudness
+		}
+		return &recorder.Loudness{Target: target}
+	})
+
+	// Tag audio recordings with their channel and the programme the guide
+	// says it airs a few minutes in, past the padding before it starts
+	recorderService.SetTagger(func(userID, channelID, title string) recorder.Tags {
+		now := time.Now()
+		tags := recorder.Tags{Title: title, Date: now}
+		if channelID == "" {
+			return tags
+		}
+		channel, err := app.Dao().FindRecordById("channels", channelID)
+		if err != nil {
+			return tags
+		}
+		tags.Channel = channel.GetString("name")
+
+		tvgID := channel.GetString("tvg_id")
+		if tvgID == "" {
+			return tags
+		}
+		programs, err := epgService.NowNext(userID, []string{tvgID}, now.Add(5*time.Minute))
+		if err != nil {
+			return tags
+		}
+		if program := programs[tvgID].Now; program != nil {
+			tags.Title = program.GetString("title")
+			tags.Description = program.GetString("description")
+			tags.Genre = program.GetString("category")
+		}
+		return tags
+	})
+
+	// Move recordings, their subtitles and subtitle exports to another disk,
+	// a bucket or a WebDAV server. Thumbnails are a cache regenerated every
+	// few minutes, read on every channel list, and stay in pb_data.
+	forcePathStyle, _ := strconv.ParseBool(os.Getenv("STORAGE_S3_FORCE_PATH_STYLE"))
+	fileStorage, err := storage.New(storage.Config{
+		Backend:          os.Getenv("STORAGE_BACKEND"),
+		LocalDir:         os.Getenv("STORAGE_LOCAL_DIR"),
+		S3Bucket:         os.Getenv("STORAGE_S3_BUCKET"),
+		S3Region:         os.Getenv("STORAGE_S3_REGION"),
+		S3Endpoint:       os.Getenv("STORAGE_S3_ENDPOINT"),
+		S3AccessKey:      os.Getenv("STORAGE_S3_ACCESS_KEY"),
+		S3Secret:         os.Getenv("STORAGE_S3_SECRET"),
+		S3ForcePathStyle: forcePathStyle,
+		WebDAVURL:        os.Getenv("STORAGE_WEBDAV_URL"),
+		WebDAVUser:       os.Getenv("STORAGE_WEBDAV_USER"),
+		WebDAVPassword:   os.Getenv("STORAGE_WEBDAV_PASSWORD"),
+	})
+	if err != nil {
+		logger.Error("failed to open file storage", "backend", os.Getenv("STORAGE_BACKEND"), "error", err)
+		os.Exit(1)
+	}
+	if fileStorage != nil {
+		recorderService.SetStorage(fileStorage)
+		logger.Info("recordings are moved to storage", "backend", fileStorage.Name())
+	}
 
 	// Initialize thumbnail service
 	thumbnailConfig := thumbnail.DefaultConfig()
 	thumbnailConfig.CacheDir = filepath.Join(app.DataDir(), "thumbnails")
+	thumbnailConfig.Preview.Enabled, _ = strconv.ParseBool(os.Getenv("THUMBNAIL_PREVIEWS"))
+	if os.Getenv("THUMBNAIL_PREVIEW_FORMAT") == thumbnail.PreviewGIF {
+		thumbnailConfig.Preview.Format = thumbnail.PreviewGIF
+	}
+	if v, err := strconv.Atoi(os.Getenv("THUMBNAIL_CACHE_MAX_MB")); err == nil && v >= 0 {
+		thumbnailConfig.MaxCacheSize = int64(v) << 20
+	}
+	if v, err := strconv.Atoi(os.Getenv("THUMBNAIL_BLANK_RETRIES")); err == nil && v >= 0 {
+		thumbnailConfig.BlankRetries = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("THUMBNAIL_MAX_CONCURRENT")); err == nil && v > 0 {
+		thumbnailConfig.Queue.MaxConcurrent = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("THUMBNAIL_MAX_PER_HOST")); err == nil && v > 0 {
+		thumbnailConfig.Queue.MaxPerHost = v
+	}
+	thumbnailConfig.InputArgs = upstreamResolver.FFmpegArgs
 	thumbnailService = thumbnail.NewThumbnailService(thumbnailConfig)
 
 	// Initialize subtitle service
 	subtitleConfig := subtitle.DefaultSubtitleConfig()
 	subtitleConfig.CacheDir = filepath.Join(app.DataDir(), "subtitles")
 	subtitleConfig.VoskModelPath = filepath.Join(app.DataDir(), "models", "vosk")
+	subtitleConfig.WhisperModelDir = filepath.Join(app.DataDir(), "models", "whisper")
+	if model := os.Getenv("WHISPER_MODEL"); subtitle.IsWhisperModel(model) {
+		subtitleConfig.WhisperModel = model
+	}
+	if engine := os.Getenv("SUBTITLE_ENGINE"); engine != "" {
+		subtitleConfig.Engine = engine
+	}
+	if voskURL := os.Getenv("VOSK_SERVER_URL"); voskURL != "" {
+		subtitleConfig.VoskServerURL = voskURL
+	}
+	subtitleConfig.WhisperServerURL = os.Getenv("WHISPER_SERVER_URL")
+	if v, err := strconv.ParseBool(os.Getenv("SUBTITLE_EMBEDDED")); err == nil {
+		subtitleConfig.EmbeddedSubtitles = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("SUBTITLE_IDLE_MINUTES")); err == nil && v >= 0 {
+		subtitleConfig.IdleTimeout = time.Duration(v) * time.Minute
+	}
+	if v, err := strconv.Atoi(os.Getenv("SUBTITLE_MAX_SESSION_HOURS")); err == nil && v >= 0 {
+		subtitleConfig.MaxSessionDuration = time.Duration(v) * time.Hour
+	}
+	if v, err := strconv.Atoi(os.Getenv("SUBTITLE_MAX_TRANSCRIPTIONS")); err == nil && v > 0 {
+		subtitleConfig.MaxTranscriptions = v
+	}
+	if v, err := strconv.ParseBool(os.Getenv("SUBTITLE_DIARIZATION")); err == nil {
+		subtitleConfig.Diarization = v
+	}
+	if v, err := strconv.ParseBool(os.Getenv("SUBTITLE_SHARE_SESSIONS")); err == nil {
+		subtitleConfig.ShareSessions = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("SUBTITLE_BURNIN_DELAY")); err == nil && v > 0 {
+		subtitleConfig.BurnInDelay = time.Duration(v) * time.Second
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("SUBTITLE_MIN_CONFIDENCE"), 64); err == nil && v >= 0 && v <= 1 {
+		subtitleConfig.Confidence.MinConfidence = v
+	}
+	if v, err := strconv.ParseBool(os.Getenv("SUBTITLE_HALLUCINATION_FILTER")); err == nil {
+		subtitleConfig.HallucinationFilter = v
+	}
+	if v := os.Getenv("SUBTITLE_LOW_CONFIDENCE"); v == subtitle.LowConfidenceDrop || v == subtitle.LowConfidenceFlag {
+		subtitleConfig.Confidence.Action = v
+	}
+	subtitleConfig.InputArgs = upstreamResolver.FFmpegArgs
 	subtitleService = subtitle.NewSubtitleService(subtitleConfig)
+	if fileStorage != nil {
+		subtitleService.SetStorage(fileStorage)
+	}
 
-	// Register migrations
-	migratecmd.MustRegister(app, app.RootCmd, migratecmd.Config{
-		Automigrate: true,
+	// ffmpeg records, transcodes and captures thumbnails, ffprobe reads
+	// recordings and subtitle tracks; the speech engine and Ollama depend
+	// on the subtitle configuration
+	healthChecker = health.NewChecker(func() []health.Check {
+		checks := []health.Check{
+			{
+				Name:     "ffmpeg",
+				Required: true,
+				Probe:    health.Command("ffmpeg", "-hide_banner", "-version"),
+				Fix:      "Install ffmpeg and make sure it is in the PATH of the server",
+			},
+			{
+				Name:     "ffprobe",
+				Required: true,
+				Probe:    health.Command("ffprobe", "-hide_banner", "-version"),
+				Fix:      "Install ffprobe, which comes with ffmpeg, in the PATH of the server",
+			},
+		}
+		return append(checks, subtitleService.HealthChecks()...)
+	}, 30*time.Second)
+
+	// Per-user quotas on recordings and subtitle exports, checked when a
+	// recording starts
+	quotaConfig := quota.Config{
+		ListExports: subtitleService.ListExports,
+		SessionOwners: func() map[string]string {
+			owners := make(map[string]string)
+			for _, session := range subtitleService.GetAllSessions() {
+				owners[session.ID] = session.UserID
+			}
+			return owners
+		},
+	}
+	if v, err := strconv.Atoi(os.Getenv("STORAGE_QUOTA_MB")); err == nil && v >= 0 {
+		quotaConfig.DefaultQuota = int64(v) << 20
+	}
+	quotaService = quota.NewService(app, recorderService, quotaConfig)
+	recorderService.SetQuotaCheck(quotaService.Check)
+
+	// Initialize stream service (playback URLs are built from PUBLIC_URL or the request host)
+	streamService = stream.NewService(app, os.Getenv("PUBLIC_URL"))
+	streamService.SetUpstream(upstreamResolver)
+
+	// Recordings and viewers share the connections a provider allows
+	connectionService = connections.NewService(app, connections.Config{
+		Recordings: recorderService.ActiveSources,
+		Viewers: func() []string {
+			sessions := streamService.ViewingSessions()
+			sources := make([]string, len(sessions))
+			for i, session := range sessions {
+				sources[i] = session.SourceID
+			}
+			return sources
+		},
 	})
+	recorderService.SetConnectionCheck(connectionService.CheckRecording)
 
-	// Load Ollama configuration from database on startup
-	app.OnAfterBootstrap().Add(func(e *core.BootstrapEvent) error {
-		settingsCollection, err := app.Dao().FindCollectionByNameOrId("app_settings")
-		if err != nil {
-			return nil // Collection doesn't exist yet, will be created later
-		}
+	// Start scheduled recordings on time, expanding recurring schedules
+	scheduleService = schedules.NewService(app, schedules.Config{
+		Recorder: recorderService,
+		Sources:  streamService.SourceURLs,
+	})
+	recorderService.OnEvent(scheduleService.HandleRecorderEvent)
+
+	// Initialize notification service
+	notificationService = notifications.NewService(app)
+
+	// Initialize audit log
+	auditService = audit.NewService(app)
 
-		record, err := app.Dao().FindFirstRecordByFilter(settingsCollection.Id, "key = 'ollama_config'")
-		if err != nil || record == nil {
-			return nil // No saved config
+	// Initialize runtime settings, registered by the services below
+	settingsService = settings.NewService(app)
+
+	// Bound how often each user starts ffmpeg processes and outbound calls.
+	// RATE_LIMIT_<NAME> sets the requests per minute of a limit, 0 lifts it.
+	rateLimits := ratelimit.DefaultLimits()
+	for name, limit := range rateLimits {
+		if v, err := strconv.Atoi(os.Getenv("RATE_LIMIT_" + strings.ToUpper(name))); err == nil && v >= 0 {
+			limit.PerMinute = v
+			rateLimits[name] = limit
 		}
+	}
+	rateLimiter = ratelimit.New(rateLimits)
 
-		valueStr := record.GetString("value")
-		var savedConfig map[string]interface{}
-		if json.Unmarshal([]byte(valueStr), &savedConfig) == nil {
-			if url, ok := savedConfig["url"].(string); ok && url != "" {
-				subtitleService.UpdateOllamaConfig(url, "")
-				log.Printf("Loaded Ollama URL from database: %s", url)
-			}
-			if model, ok := savedConfig["model"].(string); ok && model != "" {
-				subtitleService.UpdateOllamaConfig("", model)
-				log.Printf("Loaded Ollama model from database: %s", model)
-			}
+	// Initialize API keys
+	apiKeyService = apikeys.NewService(app)
+
+	// Initialize stream tokens, which the stream proxy accepts for the
+	// channels of their exports
+	streamTokenService = streamtokens.NewService(app)
+	streamService.SetTokenCheck(streamTokenService.CanPlay)
+
+	// Initialize casting; the network is scanned for devices on first use
+	castService = cast.NewService()
+
+	// Initialize parental controls
+	parentalService = parental.NewService(app)
+
+	// Account exports, and deletions removing the user's files
+	accountService = account.NewService(app, account.Config{
+		Recorder:   recorderService,
+		Thumbnails: thumbnailService,
+		Subtitles:  subtitleService,
+	})
+	accountService.Register()
+
+	// Playback positions, written in batches
+	watchService = watch.NewService(app)
+
+	// Programme guide queries
+	epgService = epg.NewService(app)
+
+	// Duplicate channel analysis and merging
+	dedupeService = dedupe.NewService(app)
+
+	// Renamed, merged and virtual channel groups
+	groupService = groups.NewService(app)
+
+	// Full-text search of channels and programmes
+	searchService = search.NewService(app)
+
+	// Reminders before programmes start
+	reminderService = reminders.NewService(app, notificationService)
+
+	// Suggestions from watch history and the guide, refined with Ollama
+	recommendService = recommend.NewService(app, recommend.Config{
+		Generator: subtitleService,
+	})
+
+	// Initialize sign-in sessions and trusted devices
+	sessionService = sessions.NewService(app)
+
+	// Single sign-on through an OpenID Connect provider
+	ssoConfig := sso.Config{
+		Issuer:       os.Getenv("OIDC_ISSUER"),
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		DisplayName:  os.Getenv("OIDC_DISPLAY_NAME"),
+		SkipTOTP:     sessionService.Trusted,
+		MFAToken:     sessionService.MFAToken,
+	}
+	ssoConfig.AllowSignup, _ = strconv.ParseBool(os.Getenv("OIDC_ALLOW_SIGNUP"))
+	sso.Register(app, ssoConfig)
+
+	// Hold back the token of password logins with TOTP enabled, except on
+	// trusted devices; the login's MFA token and the code are exchanged for
+	// it at /api/auth/totp/validate
+	app.OnRecordAuthRequest("users").Add(func(e *core.RecordAuthEvent) error {
+		if !strings.HasSuffix(e.HttpContext.Path(), "/auth-with-password") || !e.Record.GetBool("totp_enabled") {
+			return nil
+		}
+		if sessionService.Trusted(e.HttpContext, e.Record) {
+			return nil
 		}
 
+		mfaToken, err := sessionService.MFAToken(e.Record)
+		if err != nil {
+			return apis.NewBadRequestError("Failed to create auth token.", err)
+		}
+		e.Token = ""
+		e.Meta = map[string]any{"requires_2fa": true, "mfa_token": mfaToken}
 		return nil
 	})
 
-	// Setup routes
-	app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
-		// Health check endpoint
-		e.Router.GET("/api/health", func(c echo.Context) error {
-			return c.JSON(http.StatusOK, map[string]string{
-				"status": "healthy",
-				"time":   time.Now().Format(time.RFC3339),
+	// Track sign-in sessions, after the hooks holding back tokens so logins
+	// waiting for a TOTP code get no session
+	sessionService.Register()
+
+	// Notify users about their recordings
+	recorderService.OnEvent(func(event recorder.Event, rec *recorder.Recording, err error) {
+		info := rec.Info()
+		title := info.Title
+		if title == "" {
+			title = filepath.Base(info.OutputPath)
+		}
+		data := map[string]interface{}{
+			"recording_id": info.ID,
+			"channel_id":   info.ChannelID,
+			"title":        title,
+			"output_path":  info.OutputPath,
+			"status":       info.Status,
+		}
+
+		switch event {
+		case recorder.EventStarted:
+			notificationService.Notify(notifications.Event{
+				Type:    notifications.EventRecordingStarted,
+				User:    rec.UserID,
+				Title:   "Recording started",
+				Message: fmt.Sprintf("Recording of %s started.", title),
+				Data:    data,
 			})
-		})
+		case recorder.EventFinished:
+			data["bytes_written"] = info.BytesWritten
+			data["duration_seconds"] = info.Duration
+			message := fmt.Sprintf("Recording of %s finished (%d min).", title, info.Duration/60)
+			if info.Status == recorder.StatusCompletedPartial {
+				message = fmt.Sprintf("Recording of %s was cut short by a server shutdown (%d min saved).", title, info.Duration/60)
+			}
+			notificationService.Notify(notifications.Event{
+				Type:    notifications.EventRecordingFinished,
+				User:    rec.UserID,
+				Title:   "Recording finished",
+				Message: message,
+				Data:    data,
+			})
+		case recorder.EventFailed:
+			data["error"] = err.Error()
+			notificationService.Notify(notifications.Event{
+				Type:    notifications.EventRecordingFailed,
+				User:    rec.UserID,
+				Title:   "Recording failed",
+				Message: fmt.Sprintf("Recording of %s failed: %v", title, err),
+				Data:    data,
+			})
+		}
+	})
 
-		// TOTP Setup endpoint - generates secret and QR code
-		e.Router.POST("/api/auth/totp/setup", func(c echo.Context) error {
-			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
-			if authRecord == nil {
-				return apis.NewUnauthorizedError("Authentication required", nil)
+	// Save the subtitles of a channel next to its finished recordings
+	if v, _ := strconv.ParseBool(os.Getenv("RECORDING_SUBTITLES")); v {
+		recorderService.OnEvent(func(event recorder.Event, rec *recorder.Recording, err error) {
+			if event != recorder.EventFinished || rec.ChannelID == "" {
+				return
 			}
 
-			appName := os.Getenv("NEXT_PUBLIC_APP_NAME")
-			if appName == "" {
-				appName = "StreamVault"
+			info := rec.Info()
+			stoppedAt := time.Now()
+			if info.StoppedAt != nil {
+				stoppedAt = *info.StoppedAt
 			}
 
-			// Generate new TOTP key
-			key, err := totp.Generate(totp.GenerateOpts{
-				Issuer:      appName,
-				AccountName: authRecord.Email(),
-				Period:      30,
-				SecretSize:  32,
-				Digits:      otp.DigitsSix,
-				Algorithm:   otp.AlgorithmSHA1,
-			})
+			entries := subtitleService.ChannelEntries(info.ChannelID, info.StartedAt, stoppedAt)
+			if len(entries) == 0 {
+				return
+			}
+
+			content, _ := subtitle.FormatSubtitles(entries, subtitle.FormatSRT)
+			sidecar, err := recorderService.SaveSubtitles(info.OutputPath, []byte(content))
 			if err != nil {
-				return apis.NewBadRequestError("Failed to generate TOTP key", err)
+				logger.Warn("failed to save recording subtitles", "recording_id", info.ID, "error", err)
+				return
 			}
+			logger.Info("saved recording subtitles", "recording_id", info.ID, "file", sidecar, "entries", len(entries))
 
-			// Generate QR code as base64
-			qr, err := qrcode.Encode(key.URL(), qrcode.Medium, 256)
+			// Link them in the recordings collection when a record points to the file
+			record, err := app.Dao().FindFirstRecordByFilter("recordings", "file_path = {:name} || file_path = {:path}", dbx.Params{
+				"name": filepath.Base(info.OutputPath),
+				"path": info.OutputPath,
+			})
 			if err != nil {
-				return apis.NewBadRequestError("Failed to generate QR code", err)
+				return
 			}
-			qrBase64 := base64.StdEncoding.EncodeToString(qr)
+			record.Set("subtitle_path", sidecar)
+			if err := app.Dao().SaveRecord(record); err != nil {
+				logger.Warn("failed to link recording subtitles", "recording_id", record.Id, "error", err)
+			}
+		})
+	}
 
-			// Store secret temporarily (not verified yet)
-			authRecord.Set("totp_secret_pending", key.Secret())
-			if err := app.Dao().SaveRecord(authRecord); err != nil {
-				return apis.NewBadRequestError("Failed to save TOTP secret", err)
+	// Initialize background job manager and register job types
+	jobManager = jobs.NewManager(jobs.DefaultConfig(), nil)
+
+	eventHub = events.NewHub()
+
+	jobManager.Register("thumbnail.batch", func(ctx context.Context, job *jobs.Job, progress jobs.ProgressFunc) (interface{}, error) {
+		payload := struct {
+			Channels    map[string]string `json:"channels"`
+			Concurrency int               `json:"concurrency"`
+		}{}
+		if err := job.DecodePayload(&payload); err != nil {
+			return nil, err
+		}
+
+		results := thumbnailService.BatchGenerateContext(ctx, payload.Channels, payload.Concurrency, func(counts thumbnail.BatchProgress) {
+			if ctx.Err() == nil {
+				thumbnailBatches.Store(job.ID, counts)
 			}
+			processed := counts.Done + counts.Failed
+			progress(float64(processed)/float64(counts.Total)*100, fmt.Sprintf("%d/%d channels, %d failed", processed, counts.Total, counts.Failed))
+		})
 
-			return c.JSON(http.StatusOK, map[string]interface{}{
-				"secret":     key.Secret(),
-				"qrCode":     "data:image/png;base64," + qrBase64,
-				"otpAuthUrl": key.URL(),
-			})
-		}, apis.RequireRecordAuth())
+		response := make(map[string]interface{})
+		for channelId, info := range results {
+			response[channelId] = map[string]interface{}{
+				"success":      true,
+				"generated_at": info.GeneratedAt,
+				"size":         info.Size,
+			}
+		}
 
-		// TOTP Verify endpoint - verifies code and enables 2FA
-		e.Router.POST("/api/auth/totp/verify", func(c echo.Context) error {
-			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
-			if authRecord == nil {
-				return apis.NewUnauthorizedError("Authentication required", nil)
+		// Mark failed channels
+		for channelId := range payload.Channels {
+			if _, ok := results[channelId]; !ok {
+				response[channelId] = map[string]interface{}{
+					"success": false,
+					"error":   "Failed to generate thumbnail",
+				}
 			}
+		}
 
-			data := struct {
-				Code string `json:"code"`
-			}{}
-			if err := c.Bind(&data); err != nil {
-				return apis.NewBadRequestError("Invalid request body", err)
+		return response, ctx.Err()
+	}, jobs.TypeOptions{MaxAttempts: 1, Concurrency: 2})
+
+	// Finished batches report their counts from the result
+	jobManager.OnFinished(func(job jobs.Job) {
+		if job.Type == "thumbnail.batch" {
+			thumbnailBatches.Delete(job.ID)
+		}
+	})
+
+	jobManager.Register("playlist.import", func(ctx context.Context, job *jobs.Job, progress jobs.ProgressFunc) (interface{}, error) {
+		payload := struct {
+			PlaylistID string `json:"playlist_id"`
+			Prune      bool   `json:"prune"`
+		}{}
+		if err := job.DecodePayload(&payload); err != nil {
+			return nil, err
+		}
+
+		return playlist.Import(ctx, app, payload.PlaylistID, playlist.ImportOptions{
+			Prune: payload.Prune,
+			Report: func(result playlist.ImportResult) {
+				jobManager.Report(job.ID, result)
+			},
+		}, playlist.ProgressFunc(progress))
+	}, jobs.TypeOptions{MaxAttempts: 3, Concurrency: 1, Timeout: 30 * time.Minute})
+
+	// Keep the history of playlist imports with what each one changed
+	jobManager.OnFinished(func(job jobs.Job) {
+		if job.Type != "playlist.import" || (job.Status != jobs.StatusCompleted && job.Status != jobs.StatusFailed) {
+			return
+		}
+
+		payload := struct {
+			PlaylistID string `json:"playlist_id"`
+		}{}
+		job.DecodePayload(&payload)
+
+		sync := playlist.Sync{
+			PlaylistID: payload.PlaylistID,
+			User:       job.User,
+			JobID:      job.ID,
+			Status:     string(job.Status),
+			Error:      job.Error,
+			FinishedAt: time.Now(),
+		}
+		if job.StartedAt != nil {
+			sync.StartedAt = *job.StartedAt
+		}
+		if job.Status == jobs.StatusCompleted {
+			var result playlist.ImportResult
+			if err := json.Unmarshal(job.Result, &result); err == nil {
+				sync.Result = &result
 			}
+		}
 
-			// Get pending or active secret
-			secret := authRecord.GetString("totp_secret_pending")
-			if secret == "" {
-				secret = authRecord.GetString("totp_secret")
+		if _, err := playlist.RecordSync(app, sync); err != nil {
+			logger.Warn("failed to record playlist sync", "playlist_id", payload.PlaylistID, "job_id", job.ID, "error", err)
+		}
+	})
+
+	// Guide sources are imported as background jobs, on demand and every
+	// sync_interval hours
+	epgScheduler = epg.NewScheduler(epgService, jobManager)
+
+	// Trim a recording into a new one, see POST /api/recordings/:id/trim
+	jobManager.Register("recording.trim", func(ctx context.Context, job *jobs.Job, progress jobs.ProgressFunc) (interface{}, error) {
+		payload := struct {
+			RecordingID string               `json:"recording_id"`
+			Ranges      []recorder.KeepRange `json:"ranges"`
+		}{}
+		if err := job.DecodePayload(&payload); err != nil {
+			return nil, err
+		}
+
+		original, err := app.Dao().FindRecordById("recordings", payload.RecordingID)
+		if err != nil {
+			return nil, fmt.Errorf("recording %s no longer exists", payload.RecordingID)
+		}
+
+		trimmed, err := libraryService.Trim(ctx, original, job.User, payload.Ranges)
+		if err != nil {
+			return nil, err
+		}
+
+		return map[string]interface{}{
+			"recording_id": trimmed.Id,
+			"file":         trimmed.GetString("file_path"),
+			"size":         trimmed.GetInt("file_size"),
+		}, nil
+	}, jobs.TypeOptions{MaxAttempts: 1, Concurrency: 2, Timeout: 2 * time.Hour})
+
+	// Generate thumbnails for the channels a user sees first once their
+	// playlist is imported, so the grid isn't empty on first load
+	prewarmPerGroup := 6
+	if v, err := strconv.Atoi(os.Getenv("THUMBNAIL_PREWARM_PER_GROUP")); err == nil && v >= 0 {
+		prewarmPerGroup = v
+	}
+	jobManager.OnFinished(func(job jobs.Job) {
+		if job.Type != "playlist.import" || job.Status != jobs.StatusCompleted || prewarmPerGroup == 0 {
+			return
+		}
+
+		payload := struct {
+			PlaylistID string `json:"playlist_id"`
+		}{}
+		job.DecodePayload(&payload)
+
+		channels := prewarmChannels(app, payload.PlaylistID, job.User, prewarmPerGroup, 200)
+		if len(channels) == 0 {
+			return
+		}
+
+		if _, err := jobManager.Enqueue("thumbnail.batch", job.User, map[string]interface{}{
+			"channels":    channels,
+			"concurrency": 2,
+		}); err != nil {
+			logger.Warn("failed to queue thumbnail prewarm", "playlist_id", payload.PlaylistID, "error", err)
+		}
+	})
+
+	// Notify users when a playlist sync gives up
+	jobManager.OnFinished(func(job jobs.Job) {
+		if job.Type != "playlist.import" || job.Status != jobs.StatusFailed {
+			return
+		}
+
+		payload := struct {
+			PlaylistID string `json:"playlist_id"`
+		}{}
+		job.DecodePayload(&payload)
+
+		name := payload.PlaylistID
+		if playlistRecord, err := app.Dao().FindRecordById("playlists", payload.PlaylistID); err == nil {
+			name = playlistRecord.GetString("name")
+		}
+
+		notificationService.Notify(notifications.Event{
+			Type:    notifications.EventPlaylistSyncFailed,
+			User:    job.User,
+			Title:   "Playlist sync failed",
+			Message: fmt.Sprintf("Syncing %s failed after %d attempts: %s", name, job.Attempts, job.Error),
+			Data: map[string]interface{}{
+				"playlist_id": payload.PlaylistID,
+				"job_id":      job.ID,
+				"error":       job.Error,
+			},
+		})
+	})
+
+	// Maintenance tasks run as background jobs so their history is persisted
+	maintenanceScheduler = maintenance.NewScheduler(maintenance.Env{
+		App:           app,
+		ThumbnailDir:  thumbnailConfig.CacheDir,
+		RecordingsDir: recorderService.OutputDir,
+		Storage:       fileStorage,
+		RecordingRetention: func() int {
+			return recorderService.Config().RetentionDays
+		},
+		DeleteRecordings: func(ctx context.Context, before time.Time) (int, int, error) {
+			return libraryService.Prune(ctx, before)
+		},
+		ActiveSubtitleSessions: func() []string {
+			sessions := subtitleService.GetAllSessions()
+			ids := make([]string, 0, len(sessions))
+			for _, session := range sessions {
+				ids = append(ids, session.ID)
 			}
-			if secret == "" {
-				return apis.NewBadRequestError("No TOTP secret configured", nil)
+			return ids
+		},
+		SubtitleExports:      subtitleService.ListExports,
+		DeleteSubtitleExport: subtitleService.DeleteExport,
+	}, jobManager)
+
+	// Outbound webhooks for lifecycle events, delivered as retried background jobs
+	webhookService = webhooks.NewService(app, jobManager)
+
+	recorderService.OnEvent(func(event recorder.Event, rec *recorder.Recording, err error) {
+		if event == recorder.EventStarted {
+			return
+		}
+
+		info := rec.Info()
+		data := map[string]interface{}{
+			"recording_id":     info.ID,
+			"channel_id":       info.ChannelID,
+			"title":            info.Title,
+			"file":             filepath.Base(info.OutputPath),
+			"status":           info.Status,
+			"started_at":       info.StartedAt,
+			"stopped_at":       info.StoppedAt,
+			"bytes_written":    info.BytesWritten,
+			"duration_seconds": info.Duration,
+		}
+
+		if event == recorder.EventFailed {
+			data["error"] = err.Error()
+			webhookService.Dispatch(webhooks.EventRecordingFailed, data, rec.UserID)
+			return
+		}
+		webhookService.Dispatch(webhooks.EventRecordingCompleted, data, rec.UserID)
+	})
+
+	// Post-recording hooks of the administrator: a command and a webhook
+	// receiving the metadata of each finished recording
+	hookConfig := postprocess.Config{
+		Command:       os.Getenv("RECORDING_HOOK_COMMAND"),
+		Timeout:       time.Hour,
+		URL:           os.Getenv("RECORDING_HOOK_URL"),
+		Secret:        os.Getenv("RECORDING_HOOK_SECRET"),
+		RecordingsDir: recorderService.OutputDir,
+	}
+	if v, err := strconv.Atoi(os.Getenv("RECORDING_HOOK_TIMEOUT")); err == nil && v >= 0 {
+		hookConfig.Timeout = time.Duration(v) * time.Minute
+	}
+	for _, event := range strings.Split(os.Getenv("RECORDING_HOOK_EVENTS"), ",") {
+		switch event = strings.TrimSpace(event); event {
+		case "":
+		case postprocess.EventCompleted, postprocess.EventFailed:
+			hookConfig.Events = append(hookConfig.Events, event)
+		default:
+			logger.Warn("ignoring unknown recording hook event", "event", event)
+		}
+	}
+	if fileStorage != nil {
+		hookConfig.Storage = fileStorage.Name()
+	}
+	hookService := postprocess.NewService(app, jobManager, hookConfig)
+	if hookService.Enabled() {
+		recorderService.OnEvent(hookService.HandleRecorderEvent)
+		logger.Info("recording hooks enabled", "command", hookConfig.Command != "", "url", hookConfig.URL != "")
+	}
+
+	// Keep every recorded file in the recordings collection, probed for its
+	// duration and resolution once finished, then given chapters
+	detectBreaks := true
+	if v, err := strconv.ParseBool(os.Getenv("RECORDING_BREAK_DETECTION")); err == nil {
+		detectBreaks = v
+	}
+	libraryService = library.NewService(app, library.Config{
+		Recorder:     recorderService,
+		Jobs:         jobManager,
+		Watch:        watchService,
+		EPG:          epgService,
+		Thumbnails:   thumbnailService,
+		DetectBreaks: detectBreaks,
+	})
+	libraryService.Register()
+	recorderService.OnEvent(libraryService.HandleRecorderEvent)
+
+	subtitleService.OnSessionEnded(func(info subtitle.SessionInfo) {
+		webhookService.Dispatch(webhooks.EventSubtitleSessionEnd, map[string]interface{}{
+			"session_id":     info.ID,
+			"channel_id":     info.ChannelID,
+			"status":         info.Status,
+			"language":       info.Language,
+			"target_lang":    info.TargetLang,
+			"target_langs":   info.TargetLangs,
+			"subtitle_count": info.SubCount,
+			"created_at":     info.CreatedAt,
+			"ended_at":       time.Now(),
+			"error":          info.Error,
+			"stop_reason":    info.StopReason,
+		}, info.UserID)
+	})
+
+	jobManager.OnFinished(func(job jobs.Job) {
+		if job.Type != "playlist.import" || (job.Status != jobs.StatusCompleted && job.Status != jobs.StatusFailed) {
+			return
+		}
+
+		payload := struct {
+			PlaylistID string `json:"playlist_id"`
+		}{}
+		job.DecodePayload(&payload)
+
+		data := map[string]interface{}{
+			"playlist_id": payload.PlaylistID,
+			"job_id":      job.ID,
+		}
+		if playlistRecord, err := app.Dao().FindRecordById("playlists", payload.PlaylistID); err == nil {
+			data["playlist_name"] = playlistRecord.GetString("name")
+		}
+
+		if job.Status == jobs.StatusFailed {
+			data["error"] = job.Error
+			data["attempts"] = job.Attempts
+			webhookService.Dispatch(webhooks.EventPlaylistSyncFailed, data, job.User)
+			eventHub.Publish(events.TopicPlaylists, "sync_failed", data, job.User)
+			return
+		}
+
+		var result map[string]interface{}
+		json.Unmarshal(job.Result, &result)
+		data["result"] = result
+		webhookService.Dispatch(webhooks.EventPlaylistSynced, data, job.User)
+		eventHub.Publish(events.TopicPlaylists, "synced", data, job.User)
+	})
+
+	streamService.OnChannelStatus(func(channelID string, up bool, reason string) {
+		channel, err := app.Dao().FindRecordById("channels", channelID)
+		if err != nil {
+			return
+		}
+
+		data := map[string]interface{}{
+			"channel_id":   channel.Id,
+			"channel_name": channel.GetString("name"),
+		}
+
+		event, eventType := webhooks.EventChannelUp, "up"
+		if !up {
+			event, eventType = webhooks.EventChannelDown, "down"
+			data["reason"] = reason
+		}
+
+		owners := channelOwners(app, channel)
+		webhookService.Dispatch(event, data, owners...)
+		eventHub.Publish(events.TopicChannels, eventType, data, owners...)
+	})
+
+	// Push what happens to the clients of /api/events, each event to the
+	// users it concerns
+	recorderService.OnEvent(func(event recorder.Event, rec *recorder.Recording, err error) {
+		publishRecording(string(event), rec, err)
+	})
+	jobManager.OnChange(func(job jobs.Job) {
+		if job.User == "" {
+			return
+		}
+		// Payloads can be large, the job's API has them
+		job.Payload = nil
+		eventHub.Publish(events.TopicJobs, string(job.Status), job, job.User)
+	})
+	notificationService.OnNotify(func(event notifications.Event) {
+		if event.Type == notifications.EventTest {
+			return
+		}
+		if event.User == "" {
+			eventHub.Publish(events.TopicNotifications, string(event.Type), event)
+			return
+		}
+		eventHub.Publish(events.TopicNotifications, string(event.Type), event, event.User)
+	})
+
+	// subtitle:<session id> streams the entries of a session, as its
+	// Server-Sent Events stream does, from since_revision in lang
+	eventHub.Stream("subtitle", func(ctx context.Context, user, sessionID string, params map[string]string, send events.SendFunc) error {
+		revision, _ := strconv.Atoi(params["since_revision"])
+		lang := params["lang"]
+		if _, exists := subtitleService.GetSession(sessionID); !exists {
+			return subtitle.ErrSessionNotFound
+		}
+		if _, _, err := subtitleService.GetSubtitleUpdates(sessionID, revision, lang); err != nil {
+			return err
+		}
+
+		return subtitleService.Follow(ctx, sessionID, revision, lang, time.Minute, func(event string, revision int, data interface{}) error {
+			switch event {
+			case "keepalive":
+				// The hub keeps connections alive
+				return nil
+			case "entry":
+				data = map[string]interface{}{"revision": revision, "entry": data}
+			}
+			return send(event, data)
+		})
+	})
+
+	// Register migrations
+	migratecmd.MustRegister(app, app.RootCmd, migratecmd.Config{
+		Automigrate: true,
+	})
+
+	// Settings changed at runtime are applied to the services they configure
+	// at startup, overriding the environment, and then on every change
+	settingsService.Register("ollama_config", settings.Definition{
+		Apply: func(value json.RawMessage) error {
+			var saved struct {
+				URL     string                 `json:"url"`
+				Model   string                 `json:"model"`
+				Prompts *subtitle.PromptConfig `json:"prompts"`
+			}
+			if err := json.Unmarshal(value, &saved); err != nil {
+				return err
+			}
+			if saved.Prompts != nil {
+				if err := subtitleService.UpdatePromptConfig(*saved.Prompts); err != nil {
+					return err
+				}
+			}
+			subtitleService.UpdateOllamaConfig(saved.URL, saved.Model)
+			return nil
+		},
+		Current: func() interface{} {
+			config := subtitleService.GetConfig()
+			return map[string]interface{}{
+				"url":     config.OllamaURL,
+				"model":   config.OllamaModel,
+				"prompts": subtitleService.GetPromptConfig(),
+			}
+		},
+	})
+	// The Whisper model chosen in the settings overrides WHISPER_MODEL
+	settingsService.Register("whisper_config", settings.Definition{
+		Apply: func(value json.RawMessage) error {
+			var saved struct {
+				Model string `json:"model"`
+			}
+			if err := json.Unmarshal(value, &saved); err != nil {
+				return err
+			}
+			return subtitleService.SetWhisperModel(saved.Model)
+		},
+		Current: func() interface{} {
+			return map[string]string{"model": subtitleService.GetConfig().WhisperModel}
+		},
+	})
+	settingsService.Register("subtitle_confidence", settings.Definition{
+		Apply: func(value json.RawMessage) error {
+			var config subtitle.ConfidenceConfig
+			if err := json.Unmarshal(value, &config); err != nil {
+				return err
+			}
+			return subtitleService.UpdateConfidenceConfig(config)
+		},
+		Current: func() interface{} { return subtitleService.GetConfidenceConfig() },
+	})
+	// Holds the webhook secret, see /api/subtitle/webhook/config
+	settingsService.Register("subtitle_webhook", settings.Definition{
+		Apply: func(value json.RawMessage) error {
+			var config subtitle.TranscriptWebhookConfig
+			if err := json.Unmarshal(value, &config); err != nil {
+				return err
+			}
+			return subtitleService.UpdateWebhookConfig(config)
+		},
+		Private: true,
+	})
+	settingsService.Register("maintenance_config", settings.Definition{
+		Apply: func(value json.RawMessage) error {
+			var config map[string]maintenance.TaskConfig
+			if err := json.Unmarshal(value, &config); err != nil {
+				return err
+			}
+			return maintenanceScheduler.UpdateConfig(config)
+		},
+		Current: func() interface{} { return maintenanceScheduler.Config() },
+	})
+	settingsService.Register("recorder_config", settings.Definition{
+		Apply: func(value json.RawMessage) error {
+			// Fields left out keep their value
+			config := recorderService.Config()
+			if err := json.Unmarshal(value, &config); err != nil {
+				return err
+			}
+			return recorderService.SetConfig(config)
+		},
+		Current: func() interface{} { return recorderService.Config() },
+	})
+	// Each user's subtitle defaults. The global value, set by admins, is
+	// that of users who didn't change them.
+	settingsService.Register("subtitle_display", settings.Definition{
+		Apply: func(value json.RawMessage) error {
+			// Fields left out keep their default
+			display := subtitle.DefaultDisplaySettings()
+			if err := json.Unmarshal(value, &display); err != nil {
+				return err
+			}
+			return display.Validate()
+		},
+		Current: func() interface{} {
+			display := subtitle.DefaultDisplaySettings()
+			if value, err := settingsService.Raw("subtitle_display"); err == nil {
+				json.Unmarshal(value, &display)
+			}
+			return display
+		},
+		Scope: settings.ScopeUser,
+	})
+	settingsService.Register("thumbnail_config", settings.Definition{
+		Apply: func(value json.RawMessage) error {
+			// Fields left out keep their value
+			config := thumbnailService.Config()
+			if err := json.Unmarshal(value, &config); err != nil {
+				return err
+			}
+			return thumbnailService.SetConfig(config)
+		},
+		Current: func() interface{} { return thumbnailService.Config() },
+	})
+
+	settingsService.Register("rate_limits", settings.Definition{
+		Apply: func(value json.RawMessage) error {
+			// Limits left out keep their value
+			var config map[string]ratelimit.Limit
+			if err := json.Unmarshal(value, &config); err != nil {
+				return err
+			}
+			return rateLimiter.SetConfig(config)
+		},
+		Current: func() interface{} { return rateLimiter.Config() },
+	})
+
+	// Load the settings from the database on startup
+	app.OnAfterBootstrap().Add(func(e *core.BootstrapEvent) error {
+		if err := settingsService.Load(); err != nil {
+			logger.Error("failed to load settings", "error", err)
+		}
+		return nil
+	})
+
+	// Report missing programs and unreachable servers at startup rather
+	// than through failing requests
+	app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
+		go func() {
+			report := healthChecker.Report(context.Background(), true)
+			for _, check := range report.Checks {
+				if check.Status != health.StatusError {
+					continue
+				}
+				if check.Required {
+					logger.Error("required dependency unavailable", "check", check.Name, "error", check.Error, "fix", check.Fix)
+				} else {
+					logger.Warn("dependency unavailable", "check", check.Name, "error", check.Error, "fix", check.Fix)
+				}
+			}
+		}()
+		return nil
+	})
+
+	// Start the job manager once migrations have been applied
+	app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
+		jobManager.SetStore(jobs.NewRecordStore(app))
+		jobManager.Start()
+		maintenanceScheduler.Start()
+		epgScheduler.Start()
+		watchService.Start()
+		return nil
+	})
+
+	// Persist subtitle transcripts once migrations have been applied
+	app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
+		if persist, _ := strconv.ParseBool(os.Getenv("SUBTITLE_PERSIST")); persist {
+			subtitleService.SetStore(subtitle.NewRecordStore(app))
+		}
+		return nil
+	})
+
+	// Index channels and programmes for search once migrations have been applied
+	app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
+		if err := searchService.EnsureIndex(); err != nil {
+			logger.Error("failed to create search index", "error", err)
+		}
+		return nil
+	})
+
+	// Upload the recordings left on disk by an interrupted upload or made
+	// before storage was configured
+	app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
+		go recorderService.SyncStorage()
+		return nil
+	})
+
+	// Give the recorded files found without a record one, once migrations
+	// have been applied
+	app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
+		go libraryService.Import()
+		return nil
+	})
+
+	// Start disk space and upcoming recording checks
+	app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
+		monitorConfig := notifications.DefaultMonitorConfig()
+		monitorConfig.DiskPath = recorderService.OutputDir
+		if v, err := strconv.Atoi(os.Getenv("DISK_LOW_THRESHOLD_MB")); err == nil && v >= 0 {
+			monitorConfig.DiskLowBytes = uint64(v) * 1024 * 1024
+		}
+		notificationService.StartMonitor(monitorConfig)
+		reminderService.Start()
+		scheduleService.Start()
+		return nil
+	})
+
+	app.OnTerminate().Add(func(e *core.TerminateEvent) error {
+		notificationService.StopMonitor()
+		reminderService.Stop()
+		scheduleService.Stop()
+		return nil
+	})
+
+	// Keep the thumbnail cache across restarts
+	app.OnTerminate().Add(func(e *core.TerminateEvent) error {
+		if err := thumbnailService.Close(); err != nil {
+			logger.Warn("failed to save thumbnail index", "error", err)
+		}
+		return nil
+	})
+
+	// Regenerate thumbnails before they expire so the grid rarely waits for
+	// ffmpeg
+	app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
+		if enabled, _ := strconv.ParseBool(os.Getenv("THUMBNAIL_REFRESH")); !enabled {
+			return nil
+		}
+		refreshConfig := thumbnail.DefaultRefreshConfig()
+		thumbnailService.StartRefresher(refreshConfig, func(top int) map[string]string {
+			return streamService.FavoriteTargets(top, stream.DefaultPrefetchConfig().ActiveWindow)
+		})
+		return nil
+	})
+
+	app.OnTerminate().Add(func(e *core.TerminateEvent) error {
+		thumbnailService.StopRefresher()
+		return nil
+	})
+
+	// Let go of cast devices; what they play keeps playing
+	app.OnTerminate().Add(func(e *core.TerminateEvent) error {
+		castService.Close()
+		return nil
+	})
+
+	// Keep the top favorites of active profiles warm for faster zapping
+	app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
+		prefetchConfig := stream.DefaultPrefetchConfig()
+		if v, err := strconv.Atoi(os.Getenv("PREFETCH_FAVORITES")); err == nil && v > 0 {
+			prefetchConfig.TopFavorites = v
+		}
+		streamService.StartPrefetch(prefetchConfig)
+		return nil
+	})
+
+	app.OnTerminate().Add(func(e *core.TerminateEvent) error {
+		streamService.StopPrefetch()
+		return nil
+	})
+
+	// Play channels over WebRTC (WHEP) for sub-second latency
+	app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
+		if enabled, err := strconv.ParseBool(os.Getenv("WEBRTC_ENABLED")); err == nil && !enabled {
+			return nil
+		}
+		whepConfig := stream.DefaultWHEPConfig()
+		if v, err := strconv.Atoi(os.Getenv("WEBRTC_UDP_PORT")); err == nil && v >= 0 {
+			whepConfig.UDPPort = v
+		}
+		if v, err := strconv.Atoi(os.Getenv("WEBRTC_MAX_CHANNELS")); err == nil && v >= 0 {
+			whepConfig.MaxChannels = v
+		}
+		for _, ip := range strings.Split(os.Getenv("WEBRTC_PUBLIC_IPS"), ",") {
+			if ip = strings.TrimSpace(ip); ip != "" {
+				whepConfig.PublicIPs = append(whepConfig.PublicIPs, ip)
+			}
+		}
+		if err := streamService.EnableWHEP(whepConfig); err != nil {
+			logger.Warn("WebRTC output disabled", "error", err)
+		}
+		return nil
+	})
+
+	app.OnTerminate().Add(func(e *core.TerminateEvent) error {
+		streamService.StopWHEP()
+		return nil
+	})
+
+	// Stop running jobs on shutdown; they resume on next start
+	app.OnTerminate().Add(func(e *core.TerminateEvent) error {
+		maintenanceScheduler.Stop()
+		epgScheduler.Stop()
+		watchService.Stop()
+		jobManager.Stop(10 * time.Second)
+		return nil
+	})
+
+	// Finalize ffmpeg children on shutdown so recordings aren't left truncated
+	// and no orphan processes survive the server
+	app.OnTerminate().Add(func(e *core.TerminateEvent) error {
+		timeout := 20 * time.Second
+		if v, err := strconv.Atoi(os.Getenv("SHUTDOWN_TIMEOUT")); err == nil && v > 0 {
+			timeout = time.Duration(v) * time.Second
+		}
+
+		logger.Info("shutting down media processes", "timeout", timeout.String())
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			recorderService.Shutdown(timeout)
+		}()
+		go func() {
+			defer wg.Done()
+			subtitleService.Shutdown(timeout)
+		}()
+		wg.Wait()
+
+		return nil
+	})
+
+	// Setup routes
+	app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
+		// Assign request IDs and request-scoped loggers
+		e.Router.Use(logging.Middleware())
+
+		// EventSource and WebSocket can't send headers, so subtitle streams
+		// and /api/events also take the auth token as ?token=, moved to the
+		// header and loaded from there
+		e.Router.Pre(func(next echo.HandlerFunc) echo.HandlerFunc {
+			return func(c echo.Context) error {
+				req := c.Request()
+				stream := req.URL.Path == "/api/events" ||
+					(strings.HasPrefix(req.URL.Path, "/api/subtitle/session/") && strings.HasSuffix(req.URL.Path, "/stream"))
+				if token := req.URL.Query().Get("token"); token != "" && req.Header.Get("Authorization") == "" && stream {
+					req.Header.Set("Authorization", token)
+					return apis.LoadAuthContext(app)(next)(c)
+				}
+				return next(c)
+			}
+		})
+
+		// Routes are registered through api, which describes them in the
+		// OpenAPI document; who may call each is read from its middleware,
+		// and which API keys from its Scope
+		apiSpec := openapi.NewSpec("StreamVault API", "1.0.0",
+			"Custom routes of the StreamVault backend. Collections are served by the PocketBase API under /api/collections.")
+		apiSpec.AuthMiddleware(openapi.AuthUser, apis.RequireRecordAuth())
+		apiSpec.AuthMiddleware(openapi.AuthUser, apis.RequireAdminOrRecordAuth())
+		apiSpec.AuthMiddleware(openapi.AuthAdmin, access.RequireAdmin())
+
+		// Reject auth tokens of revoked sessions
+		e.Router.Use(sessionService.Middleware())
+
+		// Authenticate scripts and plugins sending an API key
+		e.Router.Use(apiKeyService.Middleware(apiSpec.Scope))
+
+		// Keep profile PIN hashes out of record filters
+		e.Router.Use(parental.GuardPINFilter())
+
+		// Leave the channels and programs hidden from the active profile
+		// out of the records API lists
+		e.Router.Use(parentalService.FilterLists())
+
+		api := apiSpec.Router(e.Router)
+
+		// OpenAPI 3 document of the routes below, for Swagger UI and client
+		// generators
+		api.GET("/api/openapi.json", openapi.Operation{Summary: "OpenAPI document of this API"}, apiSpec.Handler)
+
+		// Health check endpoint
+		api.GET("/api/health", openapi.Operation{Summary: "Health check"}, func(c echo.Context) error {
+			return c.JSON(http.StatusOK, map[string]string{
+				"status": "healthy",
+				"time":   time.Now().Format(time.RFC3339),
+			})
+		})
+
+		// Check the external programs and servers, 503 when a required one
+		// fails. Admins see versions, paths and what to fix, and can skip
+		// the cached report with ?refresh=true.
+		api.GET("/api/health/detailed", openapi.Operation{
+			Summary:     "Check the external programs and servers, 503 when a required one fails",
+			Description: "Admins see versions, paths and what to fix, and can skip the cached report with ?refresh=true.",
+			Query:       []openapi.Param{{Name: "refresh"}},
+			Response:    health.Report{},
+		}, func(c echo.Context) error {
+			admin := access.IsAdmin(c)
+			fresh, _ := strconv.ParseBool(c.QueryParam("refresh"))
+			report := healthChecker.Report(c.Request().Context(), fresh && admin)
+
+			status := http.StatusOK
+			if report.Status == health.Unhealthy {
+				status = http.StatusServiceUnavailable
+			}
+			if !admin {
+				// The report is cached, the summary is a copy
+				checks := make([]health.Result, len(report.Checks))
+				for i, check := range report.Checks {
+					checks[i] = health.Result{Name: check.Name, Status: check.Status, Required: check.Required}
+				}
+				report.Checks = checks
+			}
+			return c.JSON(status, report)
+		})
+
+		// Get current log level
+		api.GET("/api/logging/level", openapi.Operation{Summary: "Get current log level"}, func(c echo.Context) error {
+			return c.JSON(http.StatusOK, map[string]string{
+				"level": logging.GetLevel(),
+			})
+		}, access.RequireAdmin())
+
+		// Change log level at runtime
+		api.PUT("/api/logging/level", openapi.Operation{
+			Summary: "Change log level at runtime",
+			Body: openapi.Fields{
+				"level": "string",
+			},
+		}, func(c echo.Context) error {
+			data := struct {
+				Level string `json:"level"`
+			}{}
+			if err := c.Bind(&data); err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
+			}
+
+			if err := logging.SetLevel(data.Level); err != nil {
+				return apis.NewBadRequestError("Invalid log level", err)
+			}
+
+			logging.FromEcho(c).Info("log level changed", "level", logging.GetLevel())
+
+			return c.JSON(http.StatusOK, map[string]string{
+				"level": logging.GetLevel(),
+			})
+		}, access.RequireAdmin())
+
+		// TOTP Setup endpoint - generates secret and QR code
+		api.POST("/api/auth/totp/setup", openapi.Operation{Summary: "Generate a TOTP secret and its QR code"}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			appName := os.Getenv("NEXT_PUBLIC_APP_NAME")
+			if appName == "" {
+				appName = "StreamVault"
+			}
+
+			// Generate new TOTP key
+			key, err := totp.Generate(totp.GenerateOpts{
+				Issuer:      appName,
+				AccountName: authRecord.Email(),
+				Period:      30,
+				SecretSize:  32,
+				Digits:      otp.DigitsSix,
+				Algorithm:   otp.AlgorithmSHA1,
+			})
+			if err != nil {
+				return apis.NewBadRequestError("Failed to generate TOTP key", err)
+			}
+
+			// Generate QR code as base64
+			qr, err := qrcode.Encode(key.URL(), qrcode.Medium, 256)
+			if err != nil {
+				return apis.NewBadRequestError("Failed to generate QR code", err)
+			}
+			qrBase64 := base64.StdEncoding.EncodeToString(qr)
+
+			// Store secret temporarily (not verified yet)
+			authRecord.Set("totp_secret_pending", key.Secret())
+			if err := app.Dao().SaveRecord(authRecord); err != nil {
+				return apis.NewBadRequestError("Failed to save TOTP secret", err)
+			}
+
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"secret":     key.Secret(),
+				"qrCode":     "data:image/png;base64," + qrBase64,
+				"otpAuthUrl": key.URL(),
+			})
+		}, apis.RequireRecordAuth())
+
+		// TOTP Verify endpoint - verifies code and enables 2FA
+		api.POST("/api/auth/totp/verify", openapi.Operation{
+			Summary: "Verify a TOTP code and enable 2FA",
+			Body: openapi.Fields{
+				"code": "string",
+			},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			data := struct {
+				Code string `json:"code"`
+			}{}
+			if err := c.Bind(&data); err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
+			}
+
+			// Get pending or active secret
+			secret := authRecord.GetString("totp_secret_pending")
+			if secret == "" {
+				secret = authRecord.GetString("totp_secret")
+			}
+			if secret == "" {
+				return apis.NewBadRequestError("No TOTP secret configured", nil)
+			}
+
+			// Validate the code
+			valid := totp.Validate(data.Code, secret)
+			if !valid {
+				return apis.NewBadRequestError("Invalid TOTP code", nil)
+			}
+
+			// If this was a pending secret, activate it
+			if authRecord.GetString("totp_secret_pending") != "" {
+				authRecord.Set("totp_secret", secret)
+				authRecord.Set("totp_secret_pending", "")
+				authRecord.Set("totp_enabled", true)
+				authRecord.Set("totp_verified_at", time.Now().Format(time.RFC3339))
+				if err := app.Dao().SaveRecord(authRecord); err != nil {
+					return apis.NewBadRequestError("Failed to enable TOTP", err)
+				}
+
+				// Sessions signed in without a code must sign in again
+				token, revoked, err := sessionService.RevokeOthers(c, authRecord)
+				if err != nil {
+					return apis.NewBadRequestError("Failed to sign out other sessions", err)
+				}
+				audit.SetDetail(c, "sessions_revoked", revoked)
+
+				return c.JSON(http.StatusOK, map[string]interface{}{
+					"verified": true,
+					"message":  "Two-factor authentication enabled successfully",
+					"token":    token,
+				})
+			}
+
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"verified": true,
+				"message":  "Two-factor authentication enabled successfully",
+			})
+		}, apis.RequireRecordAuth(), auditService.Middleware(audit.ActionTOTPEnable))
+
+		// TOTP Validate endpoint - validates code during login, for the MFA
+		// token the login returned in its meta
+		api.POST("/api/auth/totp/validate", openapi.Operation{
+			Summary: "Validate a TOTP code during login",
+			Body: openapi.Fields{
+				"mfaToken": "string!",
+				"code":     "string!",
+				"remember": "boolean",
+			},
+		}, func(c echo.Context) error {
+			data := struct {
+				MFAToken string `json:"mfaToken"`
+				Code     string `json:"code"`
+				Remember bool   `json:"remember"`
+			}{}
+			if err := c.Bind(&data); err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
+			}
+
+			record, err := sessionService.MFAUser(data.MFAToken)
+			if err != nil {
+				return apis.NewUnauthorizedError(err.Error(), nil)
+			}
+			audit.SetUser(c, record.Id)
+
+			// Codes are guessed one user at a time, from any address
+			if err := rateLimiter.CheckClient(c, ratelimit.TOTPValidate, "user:"+record.Id); err != nil {
+				return err
+			}
+
+			secret := record.GetString("totp_secret")
+			if secret == "" {
+				return apis.NewBadRequestError("TOTP not configured for this user", nil)
+			}
+
+			// Validate the code
+			valid := totp.Validate(data.Code, secret)
+			if !valid {
+				return apis.NewBadRequestError("Invalid TOTP code", nil)
+			}
+
+			// Generate auth token
+			token, err := sessionService.Issue(c, record)
+			if err != nil {
+				return apis.NewBadRequestError("Failed to generate token", err)
+			}
+
+			// Let this browser skip the code for a while
+			if data.Remember {
+				if err := sessionService.Trust(c, record); err != nil {
+					return apis.NewBadRequestError("Failed to remember device", err)
+				}
+				audit.SetDetail(c, "remember", true)
+			}
+
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"token":  token,
+				"record": record,
+			})
+		}, auditService.Middleware(audit.ActionTOTPValidate), rateLimiter.Middleware(ratelimit.TOTPValidate))
+
+		// TOTP Disable endpoint
+		api.POST("/api/auth/totp/disable", openapi.Operation{
+			Summary: "Disable 2FA",
+			Body: openapi.Fields{
+				"code":     "string",
+				"password": "string",
+			},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			data := struct {
+				Code     string `json:"code"`
+				Password string `json:"password"`
+			}{}
+			if err := c.Bind(&data); err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
+			}
+
+			// Verify password
+			if !authRecord.ValidatePassword(data.Password) {
+				return apis.NewBadRequestError("Invalid password", nil)
+			}
+
+			// Verify TOTP code
+			secret := authRecord.GetString("totp_secret")
+			if secret != "" && !totp.Validate(data.Code, secret) {
+				return apis.NewBadRequestError("Invalid TOTP code", nil)
+			}
+
+			// Disable TOTP
+			authRecord.Set("totp_secret", "")
+			authRecord.Set("totp_enabled", false)
+			authRecord.Set("totp_verified_at", "")
+			if err := app.Dao().SaveRecord(authRecord); err != nil {
+				return apis.NewBadRequestError("Failed to disable TOTP", err)
+			}
+
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"message": "Two-factor authentication disabled",
+			})
+		}, apis.RequireRecordAuth(), auditService.Middleware(audit.ActionTOTPDisable))
+
+		// Check TOTP status endpoint
+		api.GET("/api/auth/totp/status", openapi.Operation{Summary: "Check TOTP status"}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"enabled":    authRecord.GetBool("totp_enabled"),
+				"verifiedAt": authRecord.GetString("totp_verified_at"),
+			})
+		}, apis.RequireRecordAuth())
+
+		// Serve static files for recordings
+		api.GET("/recordings/*", openapi.Operation{
+			Summary:  "Serve static files for recordings",
+			Scope:    apikeys.ScopeRecorder,
+			Produces: "application/octet-stream",
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			name := strings.TrimPrefix(c.Request().URL.Path, "/recordings/")
+			if !canManageRecording(c, name) {
+				return apis.NewNotFoundError("File not found", nil)
+			}
+			if err := recorderService.ServeFile(c.Response(), c.Request(), name); err != nil {
+				if errors.Is(err, recorder.ErrInvalidFilename) || os.IsNotExist(err) {
+					return apis.NewNotFoundError("File not found", nil)
+				}
+				return err
+			}
+			return nil
+		}, apis.RequireRecordAuth())
+
+		// Recording API endpoints
+
+		// Start recording
+		api.POST("/api/recorder/start", openapi.Operation{
+			Summary: "Start recording",
+			Scope:   apikeys.ScopeRecorder,
+			Body: openapi.Fields{
+				"recording_id": "string!",
+				"channel_id":   "string",
+				"channel_url":  "string!",
+				"title":        "string!",
+				"mode":         "string",
+				"profile":      "string",
+			},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			data := struct {
+				RecordingID string `json:"recording_id"`
+				ChannelID   string `json:"channel_id"`
+				ChannelURL  string `json:"channel_url"`
+				Title       string `json:"title"`
+				Mode        string `json:"mode"`    // video (default), aac, mp3 or opus
+				Profile     string `json:"profile"` // The user's first profile by default
+			}{}
+			if err := c.Bind(&data); err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
+			}
+
+			if data.RecordingID == "" || data.ChannelURL == "" || data.Title == "" {
+				return apis.NewBadRequestError("Missing required fields", nil)
+			}
+			if !recorder.ValidMode(data.Mode) {
+				return apis.NewBadRequestError("Invalid mode, use video, aac, mp3 or opus", nil)
+			}
+			if data.Profile != "" {
+				profile, err := app.Dao().FindRecordById("profiles", data.Profile)
+				if err != nil || !parental.OwnedBy(profile, authRecord.Id) {
+					return apis.NewNotFoundError("Profile not found", nil)
+				}
+			}
+
+			// Viewers only know the playback URL of a channel, record from its source
+			channelID := data.ChannelID
+			if id, ok := streamService.ParsePlaybackURL(data.ChannelURL); ok {
+				channelID = id
+			}
+			sourceURL, err := streamService.ResolveSourceURL(data.ChannelURL)
+			if err != nil {
+				return apis.NewBadRequestError("Failed to resolve channel URL", err)
+			}
+
+			// Channels record from their preferred source and fall back on the
+			// other sources of a merged channel by priority
+			var fallbacks []string
+			if channelID != "" {
+				channel, err := app.Dao().FindRecordById("channels", channelID)
+				if err == nil && (sourceURL != data.ChannelURL || channel.GetString("url") == sourceURL) {
+					urls := streamService.SourceURLs(channel)
+					sourceURL, fallbacks = urls[0], urls[1:]
+				}
+			}
+
+			rec, err := recorderService.StartRecordingWithOptions(data.RecordingID, authRecord.Id, channelID, sourceURL, data.Title,
+				recorder.Options{Mode: data.Mode}, fallbacks...)
+			if err != nil {
+				if errors.Is(err, quota.ErrQuotaExceeded) {
+					return apis.NewApiError(http.StatusInsufficientStorage, "Storage quota exceeded, delete recordings or exports first", nil)
+				}
+				if errors.Is(err, connections.ErrLimitReached) {
+					return apis.NewApiError(http.StatusConflict, err.Error(), nil)
+				}
+				return apis.NewBadRequestError("Failed to start recording", err)
+			}
+
+			// Listed with the other recordings once it has a record
+			if _, err := libraryService.Track(rec, data.Profile); err != nil {
+				logging.FromEcho(c).Warn("failed to create recording record", "recording_id", rec.ID, "error", err)
+			}
+
+			return c.JSON(http.StatusOK, visibleRecordingInfo(c, rec))
+		}, apis.RequireRecordAuth(), rateLimiter.Middleware(ratelimit.RecorderStart))
+
+		// Pause recording
+		api.POST("/api/recorder/pause", openapi.Operation{
+			Summary: "Pause recording",
+			Scope:   apikeys.ScopeRecorder,
+			Body: openapi.Fields{
+				"recording_id": "string",
+			},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			data := struct {
+				RecordingID string `json:"recording_id"`
+			}{}
+			if err := c.Bind(&data); err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
+			}
+
+			if _, ok := controlledRecording(c, data.RecordingID); !ok {
+				return apis.NewNotFoundError("Recording not found", nil)
+			}
+
+			if err := recorderService.PauseRecording(data.RecordingID); err != nil {
+				return apis.NewBadRequestError("Failed to pause recording", err)
+			}
+
+			rec, _ := recorderService.GetRecording(data.RecordingID)
+			publishRecording("paused", rec, nil)
+			return c.JSON(http.StatusOK, visibleRecordingInfo(c, rec))
+		}, apis.RequireRecordAuth())
+
+		// Resume recording
+		api.POST("/api/recorder/resume", openapi.Operation{
+			Summary: "Resume recording",
+			Scope:   apikeys.ScopeRecorder,
+			Body: openapi.Fields{
+				"recording_id": "string",
+			},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			data := struct {
+				RecordingID string `json:"recording_id"`
+			}{}
+			if err := c.Bind(&data); err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
+			}
+
+			if _, ok := controlledRecording(c, data.RecordingID); !ok {
+				return apis.NewNotFoundError("Recording not found", nil)
+			}
+
+			if err := recorderService.ResumeRecording(data.RecordingID); err != nil {
+				if errors.Is(err, connections.ErrLimitReached) {
+					return apis.NewApiError(http.StatusConflict, err.Error(), nil)
+				}
+				return apis.NewBadRequestError("Failed to resume recording", err)
+			}
+
+			rec, _ := recorderService.GetRecording(data.RecordingID)
+			publishRecording("resumed", rec, nil)
+			return c.JSON(http.StatusOK, visibleRecordingInfo(c, rec))
+		}, apis.RequireRecordAuth())
+
+		// Stop recording
+		api.POST("/api/recorder/stop", openapi.Operation{
+			Summary: "Stop recording",
+			Scope:   apikeys.ScopeRecorder,
+			Body: openapi.Fields{
+				"recording_id": "string",
+			},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			data := struct {
+				RecordingID string `json:"recording_id"`
+			}{}
+			if err := c.Bind(&data); err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
+			}
+
+			if _, ok := controlledRecording(c, data.RecordingID); !ok {
+				return apis.NewNotFoundError("Recording not found", nil)
+			}
+
+			rec, err := recorderService.StopRecording(data.RecordingID)
+			if err != nil {
+				return apis.NewBadRequestError("Failed to stop recording", err)
+			}
+
+			return c.JSON(http.StatusOK, visibleRecordingInfo(c, rec))
+		}, apis.RequireRecordAuth())
+
+		// Get recording status
+		api.GET("/api/recorder/status/:id", openapi.Operation{Summary: "Get recording status", Scope: apikeys.ScopeRecorder}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			rec, ok := controlledRecording(c, c.PathParam("id"))
+			if !ok {
+				return apis.NewNotFoundError("Recording not found", nil)
+			}
+
+			return c.JSON(http.StatusOK, visibleRecordingInfo(c, rec))
+		}, apis.RequireRecordAuth())
+
+		// Get the active recordings of the user, all of them for admins
+		api.GET("/api/recorder/active", openapi.Operation{Summary: "Get active recordings", Scope: apikeys.ScopeRecorder}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			infos := []recorder.RecordingInfo{}
+			for _, rec := range recorderService.GetAllRecordings() {
+				if rec.UserID == authRecord.Id || access.IsAdmin(c) {
+					infos = append(infos, visibleRecordingInfo(c, rec))
+				}
+			}
+
+			return c.JSON(http.StatusOK, infos)
+		}, apis.RequireRecordAuth())
+
+		// Scheduled recordings exceeding the connections their provider
+		// allows. With channel, start and end, only the conflicts recording
+		// that channel then would take part in.
+		api.GET("/api/recorder/conflicts", openapi.Operation{
+			Summary:     "Scheduled recordings exceeding the connections their provider allows",
+			Scope:       apikeys.ScopeRecorder,
+			Description: "With channel, start and end, only the conflicts recording that channel then would take part in.",
+			Query:       []openapi.Param{{Name: "channel"}, {Name: "start"}, {Name: "end"}},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			var schedule *connections.Schedule
+			if channelID := c.QueryParam("channel"); channelID != "" {
+				channel, err := app.Dao().FindRecordById("channels", channelID)
+				if err != nil || !slices.Contains(channelOwners(app, channel), authRecord.Id) {
+					return apis.NewNotFoundError("Channel not found", nil)
+				}
+				start, err := types.ParseDateTime(c.QueryParam("start"))
+				if err != nil || start.IsZero() {
+					return apis.NewBadRequestError("start must be a date", nil)
+				}
+				end, err := types.ParseDateTime(c.QueryParam("end"))
+				if err != nil || !end.Time().After(start.Time()) {
+					return apis.NewBadRequestError("end must be a date after start", nil)
+				}
+				schedule = &connections.Schedule{ChannelID: channel.Id, Start: start.Time(), End: end.Time()}
+			}
+
+			conflicts, err := connectionService.ScheduleConflicts(authRecord.Id, schedule)
+			if err != nil {
+				return apis.NewBadRequestError("Failed to check recording conflicts", err)
+			}
+
+			return c.JSON(http.StatusOK, conflicts)
+		}, apis.RequireRecordAuth())
+
+		// Connections in use of the user's playlists that have a limit
+		api.GET("/api/connections", openapi.Operation{Summary: "Connections in use of the user's playlists that have a limit"}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			playlists, err := app.Dao().FindRecordsByFilter("playlists", "user ~ {:user}", "name", 0, 0,
+				dbx.Params{"user": authRecord.Id})
+			if err != nil {
+				return apis.NewBadRequestError("Failed to load playlists", err)
+			}
+			ids := make([]string, len(playlists))
+			for i, playlist := range playlists {
+				ids[i] = playlist.Id
+			}
+
+			usage, err := connectionService.Usage(ids)
+			if err != nil {
+				return apis.NewBadRequestError("Failed to count connections", err)
+			}
+
+			return c.JSON(http.StatusOK, usage)
+		}, apis.RequireRecordAuth())
+
+		// List the user's recorded files, a page at a time. Filters: channel,
+		// profile, status, from and to (dates or RFC 3339 times, on the start);
+		// sort: started, title, size, duration or channel, - for descending.
+		api.GET("/api/recorder/files", openapi.Operation{
+			Summary:     "List the user's recorded files, a page at a time",
+			Scope:       apikeys.ScopeRecorder,
+			Description: "Filters: channel, profile, status, from and to (dates or RFC 3339 times, on the start); sort: started, title, size, duration or channel, - for descending.",
+			Query:       []openapi.Param{{Name: "channel"}, {Name: "status"}, {Name: "sort"}, {Name: "page"}, {Name: "perPage"}, {Name: "profile"}, {Name: "from"}, {Name: "to"}},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			query := library.Query{
+				User:    authRecord.Id,
+				Channel: c.QueryParam("channel"),
+				Status:  c.QueryParam("status"),
+				Sort:    c.QueryParam("sort"),
+			}
+			query.Page, _ = strconv.Atoi(c.QueryParam("page"))
+			query.PerPage, _ = strconv.Atoi(c.QueryParam("perPage"))
+			if profile := c.QueryParam("profile"); profile != "" {
+				query.Profiles = []string{profile}
+			}
+			for param, t := range map[string]*time.Time{"from": &query.From, "to": &query.To} {
+				raw := c.QueryParam(param)
+				if raw == "" {
+					continue
+				}
+				parsed, err := time.Parse(time.RFC3339, raw)
+				if err != nil {
+					parsed, err = time.ParseInLocation(time.DateOnly, raw, time.Local)
+				}
+				if err != nil {
+					return apis.NewBadRequestError(fmt.Sprintf("Invalid %s date, use YYYY-MM-DD or an RFC 3339 time", param), nil)
+				}
+				*t = parsed
+			}
+
+			page, err := libraryService.List(query)
+			if err != nil {
+				if errors.Is(err, library.ErrInvalidSort) {
+					return apis.NewBadRequestError("Invalid sort, use started, title, size, duration or channel, prefixed with - for descending", nil)
+				}
+				return apis.NewBadRequestError("Failed to list recordings", err)
+			}
+
+			return c.JSON(http.StatusOK, page)
+		}, apis.RequireRecordAuth())
+
+		// Delete a recorded file, admins or the user who recorded it only
+		api.DELETE("/api/recorder/files/:filename", openapi.Operation{Summary: "Delete a recorded file, admins or the user who recorded it only", Scope: apikeys.ScopeRecorder}, func(c echo.Context) error {
+			filename := c.PathParam("filename")
+			if !canManageRecording(c, filename) {
+				return apis.NewForbiddenError("Only admins and the user who recorded a file can delete it", nil)
+			}
+
+			if err := recorderService.DeleteFile(filename); err != nil {
+				switch {
+				case errors.Is(err, recorder.ErrInvalidFilename):
+					return apis.NewBadRequestError("Invalid filename", nil)
+				case errors.Is(err, recorder.ErrProtected):
+					return apis.NewApiError(http.StatusConflict, "Recording is protected, unprotect it first", nil)
+				case os.IsNotExist(err):
+					return apis.NewNotFoundError("File not found", nil)
+				}
+				return apis.NewBadRequestError("Failed to delete file", err)
+			}
+			libraryService.Forget(filename)
+
+			return c.JSON(http.StatusOK, map[string]string{"message": "File deleted"})
+		}, apis.RequireAdminOrRecordAuth(), auditService.Middleware(audit.ActionRecordingDelete))
+
+		// Delete many recordings of the user at once: "ids" of records, "files"
+		// by name, and those "watched" to the end or started before
+		// "older_than". Nothing is deleted when an item can't be, unless
+		// "partial" is set; "dry_run" reports what would be.
+		api.POST("/api/recorder/files/delete", openapi.Operation{
+			Summary:     "Delete many recordings at once",
+			Scope:       apikeys.ScopeRecorder,
+			Description: "\"ids\" of records, \"files\" by name, and those \"watched\" to the end or started before \"older_than\". Nothing is deleted when an item can't be, unless \"partial\" is set; \"dry_run\" reports what would be.",
+			Body: openapi.Fields{
+				"ids":        "[]string",
+				"files":      "[]string",
+				"watched":    "boolean",
+				"older_than": "string",
+				"profile":    "string",
+				"partial":    "boolean",
+				"dry_run":    "boolean",
+			},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			var data struct {
+				IDs       []string `json:"ids"`
+				Files     []string `json:"files"`
+				Watched   bool     `json:"watched"`
+				OlderThan string   `json:"older_than"` // YYYY-MM-DD or RFC 3339
+				Profile   string   `json:"profile"`
+				Partial   bool     `json:"partial"`
+				DryRun    bool     `json:"dry_run"`
+			}
+			if err := c.Bind(&data); err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
+			}
+
+			batch := library.BatchDelete{
+				User:    authRecord.Id,
+				IDs:     data.IDs,
+				Files:   data.Files,
+				Watched: data.Watched,
+				Profile: data.Profile,
+				Partial: data.Partial,
+				DryRun:  data.DryRun,
+			}
+			if data.OlderThan != "" {
+				olderThan, err := time.Parse(time.RFC3339, data.OlderThan)
+				if err != nil {
+					olderThan, err = time.ParseInLocation(time.DateOnly, data.OlderThan, time.Local)
+				}
+				if err != nil {
+					return apis.NewBadRequestError("Invalid older_than date, use YYYY-MM-DD or an RFC 3339 time", nil)
+				}
+				batch.OlderThan = olderThan
+			}
+			if data.Profile != "" {
+				profile, err := app.Dao().FindRecordById("profiles", data.Profile)
+				if err != nil || !parental.OwnedBy(profile, authRecord.Id) {
+					return apis.NewNotFoundError("Profile not found", nil)
+				}
+			}
+
+			result, err := libraryService.Delete(batch)
+			switch {
+			case errors.Is(err, library.ErrBatchEmpty):
+				return apis.NewBadRequestError("Nothing to delete, give ids, files, watched or older_than", nil)
+			case errors.Is(err, library.ErrBatchTooLarge):
+				return apis.NewBadRequestError(fmt.Sprintf("Too many recordings, at most %d per batch", library.MaxBatchDelete), nil)
+			case err != nil:
+				return apis.NewBadRequestError("Failed to delete recordings", err)
+			}
+			audit.SetDetail(c, "deleted", result.Deleted)
+			audit.SetDetail(c, "failed", result.Failed)
+			audit.SetDetail(c, "dry_run", data.DryRun)
+
+			// Rolled back: nothing was deleted, the items say why
+			if result.Failed > 0 && result.Deleted == 0 && !data.Partial && !data.DryRun {
+				return c.JSON(http.StatusConflict, result)
+			}
+			return c.JSON(http.StatusOK, result)
+		}, apis.RequireRecordAuth(), auditService.Middleware(audit.ActionRecordingDelete))
+
+		// Protect a recorded file from deletion and automatic cleanup, or lift
+		// it, admins or the user who recorded it only
+		api.PUT("/api/recorder/files/:filename/protect", openapi.Operation{
+			Summary:     "Protect a recorded file or lift its protection",
+			Scope:       apikeys.ScopeRecorder,
+			Description: "Protected files are kept by deletion and automatic cleanup. Admins or the user who recorded it only.",
+			Body: openapi.Fields{
+				"protected": "boolean",
+			},
+		}, func(c echo.Context) error {
+			if !canManageRecording(c, c.PathParam("filename")) {
+				return apis.NewForbiddenError("Only admins and the user who recorded a file can protect it", nil)
+			}
+
+			var data struct {
+				Protected bool `json:"protected"`
+			}
+			if err := c.Bind(&data); err != nil {
+				return apis.NewBadRequestError("Invalid request", err)
+			}
+
+			filename := c.PathParam("filename")
+			if err := recorderService.SetProtected(filename, data.Protected); err != nil {
+				switch {
+				case errors.Is(err, recorder.ErrInvalidFilename):
+					return apis.NewBadRequestError("Invalid filename", nil)
+				case os.IsNotExist(err):
+					return apis.NewNotFoundError("File not found", nil)
+				}
+				return apis.NewBadRequestError("Failed to update recording", err)
+			}
+
+			logging.FromEcho(c).Info("recording protection changed", "file", filename, "protected", data.Protected)
+
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"name":      filename,
+				"protected": data.Protected,
+			})
+		}, apis.RequireAdminOrRecordAuth())
+
+		// Cut a finished recording down to the ranges to keep, in seconds from
+		// its start, into a new recording. {"start": 600, "end": 4200} keeps
+		// one range; "ranges" keeps several, such as the parts between ads.
+		api.POST("/api/recordings/:id/trim", openapi.Operation{
+			Summary:     "Trim a recording into a new one",
+			Scope:       apikeys.ScopeRecorder,
+			Description: "Keeps the ranges given in seconds from its start. {\"start\": 600, \"end\": 4200} keeps one range; \"ranges\" keeps several, such as the parts between ads.",
+			Body: openapi.Fields{
+				"start":  "number",
+				"end":    "number",
+				"ranges": "[]object",
+			},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			recording, err := ownedRecording(app, authRecord.Id, c.PathParam("id"))
+			if err != nil {
+				return err
+			}
+
+			switch recording.GetString("status") {
+			case "scheduled", "recording":
+				return apis.NewApiError(http.StatusConflict, "Recording isn't finished yet", nil)
+			}
+			filename := filepath.Base(recording.GetString("file_path"))
+			if recording.GetString("file_path") == "" {
+				return apis.NewBadRequestError("Recording has no file", nil)
+			}
+			if !canManageRecording(c, filename) {
+				return apis.NewForbiddenError("Only the user who recorded a file can trim it", nil)
+			}
+			if _, err := recorderService.StatFile(filename); err != nil {
+				return apis.NewNotFoundError("File not found", nil)
+			}
+
+			data := struct {
+				Start  float64              `json:"start"`
+				End    float64              `json:"end"`
+				Ranges []recorder.KeepRange `json:"ranges"`
+			}{}
+			if err := c.Bind(&data); err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
+			}
+			ranges := data.Ranges
+			if len(ranges) == 0 {
+				ranges = []recorder.KeepRange{{Start: data.Start, End: data.End}}
+			}
+			if ranges, err = recorder.ValidateRanges(ranges); err != nil {
+				return apis.NewBadRequestError("Invalid ranges: "+err.Error(), nil)
+			}
+
+			job, err := jobManager.Enqueue("recording.trim", authRecord.Id, map[string]interface{}{
+				"recording_id": recording.Id,
+				"ranges":       ranges,
+			})
+			if err != nil {
+				return apis.NewBadRequestError("Failed to queue the trim", err)
+			}
+
+			// Copying a large recording takes a while, poll GET /api/jobs/:id
+			c.Response().Header().Set("X-Job-ID", job.ID)
+			return c.JSON(http.StatusAccepted, map[string]interface{}{
+				"job_id": job.ID,
+				"status": job.Status,
+			})
+		}, apis.RequireRecordAuth())
+
+		// Download a recording with its subtitles, a poster and its metadata
+		// as a zip archive, to archive it off the server
+		api.GET("/api/recordings/:id/bundle", openapi.Operation{
+			Summary:     "Download a recording as a zip archive",
+			Scope:       apikeys.ScopeRecorder,
+			Description: "With its subtitles, a poster and its metadata, to archive it off the server.",
+			Produces:    "application/zip",
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			recording, err := ownedRecording(app, authRecord.Id, c.PathParam("id"))
+			if err != nil {
+				return err
+			}
+
+			switch recording.GetString("status") {
+			case "scheduled", "recording":
+				return apis.NewApiError(http.StatusConflict, "Recording isn't finished yet", nil)
+			}
+			if recording.GetString("file_path") == "" {
+				return apis.NewBadRequestError("Recording has no file", nil)
+			}
+			if _, err := recorderService.StatFile(filepath.Base(recording.GetString("file_path"))); err != nil {
+				return apis.NewNotFoundError("File not found", nil)
+			}
+
+			c.Response().Header().Set("Content-Type", "application/zip")
+			c.Response().Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", library.BundleName(recording)))
+			c.Response().WriteHeader(http.StatusOK)
+
+			// Headers are sent, a failure can only cut the archive short
+			if err := libraryService.Bundle(c.Request().Context(), c.Response(), recording); err != nil {
+				logging.FromEcho(c).Warn("recording bundle failed", "recording_id", recording.Id, "error", err)
+			}
+			return nil
+		}, apis.RequireRecordAuth())
+
+		// Chapters of a recording, as JSON or, with ?format=vtt or
+		// ?format=ffmetadata, ready for a player or for ffmpeg to embed
+		api.GET("/api/recordings/:id/chapters", openapi.Operation{
+			Summary:     "Chapters of a recording",
+			Scope:       apikeys.ScopeRecorder,
+			Description: "As JSON or, with ?format=vtt or ?format=ffmetadata, ready for a player or for ffmpeg to embed.",
+			Query:       []openapi.Param{{Name: "format"}},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			recording, err := ownedRecording(app, authRecord.Id, c.PathParam("id"))
+			if err != nil {
+				return err
+			}
+
+			chapters := library.Chapters(recording)
+			switch c.QueryParam("format") {
+			case "", "json":
+				var generatedAt *time.Time
+				if at := recording.GetDateTime("chapters_at"); !at.IsZero() {
+					t := at.Time()
+					generatedAt = &t
+				}
+				return c.JSON(http.StatusOK, map[string]interface{}{
+					"recording_id": recording.Id,
+					"duration":     recording.GetFloat("duration"),
+					"generated_at": generatedAt,
+					"chapters":     chapters,
+				})
+			case "vtt":
+				return c.Blob(http.StatusOK, "text/vtt; charset=utf-8", []byte(library.WebVTT(chapters)))
+			case "ffmetadata":
+				return c.Blob(http.StatusOK, "text/plain; charset=utf-8", []byte(library.FFMetadata(chapters)))
+			default:
+				return apis.NewBadRequestError("Invalid format, use json, vtt or ffmetadata", nil)
+			}
+		}, apis.RequireRecordAuth())
+
+		// Probable ad segments of a recording to skip, as JSON or, with
+		// ?format=edl, an edit decision list for Kodi. min_confidence, 0.5 by
+		// default, leaves out the less certain ones.
+		api.GET("/api/recordings/:id/skips", openapi.Operation{
+			Summary:     "Ad segments of a recording to skip",
+			Scope:       apikeys.ScopeRecorder,
+			Description: "As JSON or, with ?format=edl, an edit decision list for Kodi. min_confidence, 0.5 by default, leaves out the less certain ones.",
+			Query:       []openapi.Param{{Name: "min_confidence"}, {Name: "format"}},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			recording, err := ownedRecording(app, authRecord.Id, c.PathParam("id"))
+			if err != nil {
+				return err
+			}
+
+			minConfidence := library.MinSkipConfidence
+			if v := c.QueryParam("min_confidence"); v != "" {
+				if minConfidence, err = strconv.ParseFloat(v, 64); err != nil || minConfidence < 0 || minConfidence > 1 {
+					return apis.NewBadRequestError("Invalid min_confidence, use a number between 0 and 1", nil)
+				}
+			}
+
+			skips := library.AdBreaks(recording, minConfidence)
+			switch c.QueryParam("format") {
+			case "", "json":
+				var analyzedAt *time.Time
+				if at := recording.GetDateTime("chapters_at"); !at.IsZero() {
+					t := at.Time()
+					analyzedAt = &t
+				}
+				return c.JSON(http.StatusOK, map[string]interface{}{
+					"recording_id": recording.Id,
+					"analyzed_at":  analyzedAt,
+					"skips":        skips,
+				})
+			case "edl":
+				return c.Blob(http.StatusOK, "text/plain; charset=utf-8", []byte(library.EDL(skips)))
+			default:
+				return apis.NewBadRequestError("Invalid format, use json or edl", nil)
+			}
+		}, apis.RequireRecordAuth())
+
+		// Analyze a recording again for its chapters and ads, such as once
+		// the guide covers it
+		api.POST("/api/recordings/:id/analyze", openapi.Operation{
+			Summary:     "Analyze a recording again",
+			Scope:       apikeys.ScopeRecorder,
+			Description: "For its chapters and ads, such as once the guide covers it.",
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			recording, err := ownedRecording(app, authRecord.Id, c.PathParam("id"))
+			if err != nil {
+				return err
+			}
+
+			switch recording.GetString("status") {
+			case "scheduled", "recording":
+				return apis.NewApiError(http.StatusConflict, "Recording isn't finished yet", nil)
+			}
+			if recording.GetString("file_path") == "" {
+				return apis.NewBadRequestError("Recording has no file", nil)
+			}
+
+			job, err := libraryService.Analyze(recording)
+			if errors.Is(err, library.ErrQueued) {
+				return apis.NewApiError(http.StatusConflict, "Recording is already being analyzed", nil)
+			}
+			if err != nil {
+				return apis.NewBadRequestError("Failed to queue the analysis", err)
+			}
+
+			c.Response().Header().Set("X-Job-ID", job.ID)
+			return c.JSON(http.StatusAccepted, map[string]interface{}{
+				"job_id": job.ID,
+				"status": job.Status,
+			})
+		}, apis.RequireRecordAuth())
+
+		// =========================================
+		// Thumbnail API endpoints
+		// =========================================
+
+		// Generate and get thumbnail for a channel. ?size=small|medium|large
+		// (or 160x90, 320x180, 640x360) and ?format=jpeg|webp|avif pick the
+		// variant; without a format, WebP is served to clients accepting it.
+		api.GET("/api/thumbnail/:channelId", openapi.Operation{
+			Summary:     "Generate and get thumbnail for a channel",
+			Description: "?size=small|medium|large (or 160x90, 320x180, 640x360) and ?format=jpeg|webp|avif pick the variant; without a format, WebP is served to clients accepting it.",
+			Query:       []openapi.Param{{Name: "url"}, {Name: "size"}, {Name: "format"}},
+			Produces:    "image/*",
+		}, func(c echo.Context) error {
+			channelId := c.PathParam("channelId")
+			streamURL := c.QueryParam("url")
+			fromChannel := streamURL == ""
+			var channel *models.Record
+
+			variant, err := thumbnailService.ParseVariant(c.QueryParam("size"), c.QueryParam("format"), c.Request().Header.Get("Accept"))
+			if err != nil {
+				return apis.NewBadRequestError(err.Error(), nil)
+			}
+			if c.QueryParam("format") == "" {
+				c.Response().Header().Add("Vary", "Accept")
+			}
+
+			if streamURL == "" {
+				// Try to get from database
+				authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+				if authRecord == nil {
+					return apis.NewUnauthorizedError("Authentication required", nil)
+				}
+
+				channel, err = app.Dao().FindRecordById("channels", channelId)
+				if err != nil {
+					return apis.NewNotFoundError("Channel not found", err)
+				}
+
+				streamURL = channel.GetString("url")
+			}
+
+			if streamURL == "" && channel == nil {
+				return apis.NewBadRequestError("Stream URL is required", nil)
+			}
+
+			streamURL, err = streamService.ResolveSourceURL(streamURL)
+			if err != nil {
+				return apis.NewNotFoundError("Channel not found", err)
+			}
+
+			requestedAt := time.Now()
+			var info *thumbnail.ThumbnailInfo
+			if streamURL == "" {
+				err = errors.New("channel has no stream URL")
+			} else if _, cached := thumbnailService.GetVariantPath(channelId, variant); !cached {
+				// Only captures count against the limit, cached thumbnails are
+				// served whatever the rate. Limited clients get a fallback.
+				err = rateLimiter.Check(c, ratelimit.Thumbnails)
+			}
+			if err == nil {
+				// Clients scrolling past a channel abandon its capture
+				info, err = thumbnailService.GetThumbnailVariant(c.Request().Context(), channelId, streamURL, variant)
+			}
+
+			// ffmpeg reaching the channel's own source doubles as a health probe;
+			// cache hits and abandoned captures say nothing about the stream
+			if fromChannel {
+				var exitErr *exec.ExitError
+				if err == nil && !info.GeneratedAt.Before(requestedAt) {
+					streamService.ReportHealth(channelId, true, "")
+				} else if errors.As(err, &exitErr) {
+					streamService.ReportHealth(channelId, false, err.Error())
+				}
+			}
+
+			if err != nil {
+				// Always answer with an image: the last capture, the channel's
+				// logo, or a card with its name. Fallbacks are cached briefly so
+				// clients retry soon.
+				logging.FromEcho(c).Debug("thumbnail generation failed, serving a fallback", "channel_id", channelId, "error", err)
+				c.Response().Header().Set("Cache-Control", "public, max-age=60")
+
+				if path, exists := thumbnailService.GetStalePath(channelId, variant); exists {
+					c.Response().Header().Set("X-Thumbnail-Fallback", thumbnail.FallbackStale)
+					return serveThumbnail(c, path)
+				}
+
+				var name string
+				if channel != nil {
+					name = channel.GetString("name")
+					if logoURL := channel.GetString("tvg_logo"); logoURL != "" {
+						if logo, err := thumbnailService.GetLogo(c.Request().Context(), channelId, logoURL, variant); err == nil {
+							c.Response().Header().Set("X-Thumbnail-Fallback", thumbnail.FallbackLogo)
+							return serveThumbnail(c, logo.FilePath)
+						}
+					}
+				}
+
+				placeholder := thumbnail.Placeholder(name, variant)
+				etag := thumbnail.ContentETag(placeholder)
+				c.Response().Header().Set("X-Thumbnail-Fallback", thumbnail.FallbackPlaceholder)
+				c.Response().Header().Set("ETag", etag)
+				if thumbnail.ETagMatches(c.Request().Header.Get("If-None-Match"), etag) {
+					return c.NoContent(http.StatusNotModified)
+				}
+				return c.Blob(http.StatusOK, "image/svg+xml", placeholder)
+			}
+
+			// Set cache headers
+			c.Response().Header().Set("Cache-Control", "public, max-age=300") // 5 minutes
+			c.Response().Header().Set("Last-Modified", info.GeneratedAt.UTC().Format(http.TimeFormat))
+			if info.LowQuality {
+				// A black frame or slate, clients may rather show the logo
+				c.Response().Header().Set("Cache-Control", "public, max-age=60")
+				c.Response().Header().Set("X-Thumbnail-Low-Quality", "true")
+			}
+
+			return serveThumbnail(c, info.FilePath)
+		})
+
+		// Animated preview of a channel, a few seconds looping, for hover in the
+		// channel grid. 404 unless THUMBNAIL_PREVIEWS is enabled.
+		api.GET("/api/thumbnail/:channelId/preview", openapi.Operation{
+			Summary:     "Animated preview of a channel",
+			Description: "A few seconds looping, for hover in the channel grid. 404 unless THUMBNAIL_PREVIEWS is enabled.",
+			Query:       []openapi.Param{{Name: "url"}},
+			Produces:    "image/*",
+		}, func(c echo.Context) error {
+			if !thumbnailService.PreviewsEnabled() {
+				return apis.NewNotFoundError("Animated previews are disabled", nil)
+			}
+
+			channelId := c.PathParam("channelId")
+			streamURL := c.QueryParam("url")
+
+			if streamURL == "" {
+				authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+				if authRecord == nil {
+					return apis.NewUnauthorizedError("Authentication required", nil)
+				}
+
+				channel, err := app.Dao().FindRecordById("channels", channelId)
+				if err != nil {
+					return apis.NewNotFoundError("Channel not found", err)
+				}
+
+				streamURL = channel.GetString("url")
+			}
+
+			if streamURL == "" {
+				return apis.NewBadRequestError("Stream URL is required", nil)
+			}
+
+			streamURL, err := streamService.ResolveSourceURL(streamURL)
+			if err != nil {
+				return apis.NewNotFoundError("Channel not found", err)
+			}
+
+			info, err := thumbnailService.GetPreview(c.Request().Context(), channelId, streamURL)
+			if err != nil {
+				return apis.NewBadRequestError("Failed to generate preview: "+err.Error(), nil)
+			}
+
+			c.Response().Header().Set("Cache-Control", "public, max-age=300")
+			c.Response().Header().Set("Last-Modified", info.GeneratedAt.UTC().Format(http.TimeFormat))
+
+			return serveThumbnail(c, info.FilePath)
+		})
+
+		// Get thumbnail if cached (no generation)
+		api.GET("/api/thumbnail/:channelId/cached", openapi.Operation{
+			Summary:  "Get thumbnail if cached (no generation)",
+			Query:    []openapi.Param{{Name: "size"}, {Name: "format"}},
+			Produces: "image/*",
+		}, func(c echo.Context) error {
+			channelId := c.PathParam("channelId")
+
+			variant, err := thumbnailService.ParseVariant(c.QueryParam("size"), c.QueryParam("format"), c.Request().Header.Get("Accept"))
+			if err != nil {
+				return apis.NewBadRequestError(err.Error(), nil)
+			}
+			if c.QueryParam("format") == "" {
+				c.Response().Header().Add("Vary", "Accept")
+			}
+
+			path, exists := thumbnailService.GetVariantPath(channelId, variant)
+			if !exists {
+				return c.JSON(http.StatusOK, map[string]interface{}{
+					"cached":  false,
+					"message": "No cached thumbnail available",
+				})
+			}
+
+			c.Response().Header().Set("Cache-Control", "public, max-age=300")
+			return serveThumbnail(c, path)
+		})
+
+		// Invalidate thumbnail cache for a channel
+		api.DELETE("/api/thumbnail/:channelId", openapi.Operation{Summary: "Invalidate thumbnail cache for a channel"}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			channelId := c.PathParam("channelId")
+			thumbnailService.InvalidateThumbnail(channelId)
+
+			return c.JSON(http.StatusOK, map[string]string{"message": "Thumbnail cache invalidated"})
+		}, apis.RequireRecordAuth())
+
+		// Batch generate thumbnails for multiple channels
+		api.POST("/api/thumbnails/batch", openapi.Operation{
+			Summary: "Batch generate thumbnails for multiple channels",
+			Body: openapi.Fields{
+				"channels":    "object!",
+				"concurrency": "integer",
+			},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			data := struct {
+				Channels    map[string]string `json:"channels"` // channelId -> streamURL
+				Concurrency int               `json:"concurrency"`
+			}{}
+			if err := c.Bind(&data); err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
+			}
+
+			if len(data.Channels) == 0 {
+				return apis.NewBadRequestError("No channels provided", nil)
+			}
+
+			for channelId, streamURL := range data.Channels {
+				sourceURL, err := streamService.ResolveSourceURL(streamURL)
+				if err != nil {
+					return apis.NewBadRequestError("Failed to resolve channel URL", err)
+				}
+				data.Channels[channelId] = sourceURL
+			}
+
+			concurrency := data.Concurrency
+			if concurrency <= 0 || concurrency > 5 {
+				concurrency = 3 // Default to 3 concurrent generations
+			}
+
+			job, err := jobManager.Enqueue("thumbnail.batch", authRecord.Id, map[string]interface{}{
+				"channels":    data.Channels,
+				"concurrency": concurrency,
+			})
+			if err != nil {
+				return apis.NewBadRequestError("Failed to queue thumbnail generation", err)
+			}
+
+			// Large playlists take minutes, poll GET /api/thumbnails/batch/:jobId
+			c.Response().Header().Set("X-Job-ID", job.ID)
+			return c.JSON(http.StatusAccepted, map[string]interface{}{
+				"job_id": job.ID,
+				"status": job.Status,
+				"total":  len(data.Channels),
+			})
+		}, apis.RequireRecordAuth(), rateLimiter.Middleware(ratelimit.Thumbnails))
+
+		// Progress of a batch: channels done, failed and pending, then each
+		// channel's result once the job finished
+		api.GET("/api/thumbnails/batch/:jobId", openapi.Operation{
+			Summary:     "Progress of a thumbnail batch",
+			Description: "Channels done, failed and pending, then each channel's result once the job finished.",
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			job, exists := jobManager.Get(c.PathParam("jobId"))
+			if !exists || job.Type != "thumbnail.batch" || job.User != authRecord.Id {
+				return apis.NewNotFoundError("Batch not found", nil)
+			}
+
+			payload := struct {
+				Channels map[string]string `json:"channels"`
+			}{}
+			job.DecodePayload(&payload)
+
+			counts := thumbnail.BatchProgress{Total: len(payload.Channels), Pending: len(payload.Channels)}
+			results := make(map[string]struct {
+				Success bool `json:"success"`
+			})
+			if len(job.Result) > 0 {
+				json.Unmarshal(job.Result, &results)
+				counts.Pending = 0
+				for _, result := range results {
+					if result.Success {
+						counts.Done++
+					} else {
+						counts.Failed++
+					}
+				}
+			} else if running, ok := thumbnailBatches.Load(job.ID); ok {
+				counts = running.(thumbnail.BatchProgress)
+			}
+
+			response := map[string]interface{}{
+				"job_id":   job.ID,
+				"status":   job.Status,
+				"progress": job.Progress,
+				"total":    counts.Total,
+				"done":     counts.Done,
+				"failed":   counts.Failed,
+				"pending":  counts.Pending,
+			}
+			if job.Error != "" {
+				response["error"] = job.Error
+			}
+			if len(job.Result) > 0 {
+				response["results"] = job.Result
+			}
+
+			return c.JSON(http.StatusOK, response)
+		}, apis.RequireRecordAuth())
+
+		// Get thumbnail cache statistics (admin only)
+		api.GET("/api/thumbnails/stats", openapi.Operation{Summary: "Get thumbnail cache statistics"}, func(c echo.Context) error {
+			return c.JSON(http.StatusOK, thumbnailService.GetCacheStats())
+		}, access.RequireAdmin())
+
+		// Get thumbnail URL for a channel (returns URL instead of image)
+		api.GET("/api/thumbnail/:channelId/url", openapi.Operation{
+			Summary: "Get thumbnail URL for a channel (returns URL instead of image)",
+			Query:   []openapi.Param{{Name: "url"}, {Name: "size"}, {Name: "format"}},
+		}, func(c echo.Context) error {
+			channelId := c.PathParam("channelId")
+			streamURL := c.QueryParam("url")
+
+			if streamURL == "" {
+				authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+				if authRecord == nil {
+					return apis.NewUnauthorizedError("Authentication required", nil)
+				}
+
+				channel, err := app.Dao().FindRecordById("channels", channelId)
+				if err != nil {
+					return apis.NewNotFoundError("Channel not found", err)
+				}
+				streamURL = streamService.VisibleURL(c, channel.Id, channel.GetString("url"), "")
+			}
+
+			// Size and format are passed on to the thumbnail URL
+			variant, err := thumbnailService.ParseVariant(c.QueryParam("size"), c.QueryParam("format"), "")
+			if err != nil {
+				return apis.NewBadRequestError(err.Error(), nil)
+			}
+			params := url.Values{}
+			for _, key := range []string{"size", "format"} {
+				if value := c.QueryParam(key); value != "" {
+					params.Set(key, value)
+				}
+			}
+
+			// Check if cached
+			cacheTTL := 300 // 5 minutes in seconds
+			_, cached := thumbnailService.GetVariantPath(channelId, variant)
+
+			// Generate timestamp for cache busting
+			timestamp := strconv.FormatInt(time.Now().Unix()/int64(cacheTTL)*int64(cacheTTL), 10)
+			params.Set("t", timestamp)
+
+			response := map[string]interface{}{
+				"url":        fmt.Sprintf("/api/thumbnail/%s?%s", channelId, params.Encode()),
+				"cached":     cached,
+				"stream_url": streamURL,
+			}
+			// Lets clients paint a blurred placeholder while the image loads
+			if blurhash := thumbnailService.GetBlurhash(channelId, variant); blurhash != "" {
+				response["blurhash"] = blurhash
+			}
+
+			return c.JSON(http.StatusOK, response)
+		})
+
+		// =========================================
+		// Stream proxy endpoints
+		// =========================================
+
+		// Proxy a channel stream for a signed playback URL (no auth, the URL is the credential)
+		api.GET("/api/stream/:channelId", openapi.Operation{
+			Summary:     "Proxy a channel stream",
+			Description: "For a signed playback URL: no auth, the URL is the credential.",
+			Produces:    "video/*",
+		}, streamService.HandleStream)
+
+		// Prefetch state and measured channel start times, warm vs cold
+		api.GET("/api/stream/metrics", openapi.Operation{Summary: "Prefetch state and measured channel start times, warm vs cold"}, func(c echo.Context) error {
+			return c.JSON(http.StatusOK, streamService.Metrics())
+		}, access.RequireAdmin())
+
+		// Stream a channel converted for a device, for a signed transcode URL
+		api.GET("/api/stream/:channelId/transcode", openapi.Operation{
+			Summary:  "Stream a channel converted for a device, for a signed transcode URL",
+			Produces: "video/*",
+		}, streamService.HandleTranscode)
+
+		// Serve a recorded file, as is or transcoded, for a signed recording URL
+		api.GET("/api/stream/recording/:filename", openapi.Operation{
+			Summary:  "Serve a recorded file, as is or transcoded, for a signed recording URL",
+			Produces: "video/*",
+		}, func(c echo.Context) error {
+			return streamService.HandleRecording(c, recorderService)
+		})
+
+		// Decide how a device plays a channel or recording: direct, proxy or transcode.
+		// ?channel=<id> or ?recording=<filename>, plus ?device=<devices record id>
+		// (without one a browser playing HLS through hls.js is assumed)
+		api.GET("/api/playback/resolve", openapi.Operation{
+			Summary:     "Decide how a device plays a channel or recording: direct, proxy or transcode",
+			Description: "?channel=<id> or ?recording=<filename>, plus ?device=<devices record id> (without one a browser playing HLS through hls.js is assumed)",
+			Query:       []openapi.Param{{Name: "device"}, {Name: "channel"}, {Name: "recording"}},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			device := stream.DefaultDeviceProfile()
+			if deviceID := c.QueryParam("device"); deviceID != "" {
+				record, err := app.Dao().FindRecordById("devices", deviceID)
+				if err != nil || !recordOwnedBy(record, "user", authRecord.Id) {
+					return apis.NewNotFoundError("Device not found", nil)
+				}
+				device = stream.DeviceProfileFromRecord(record)
+
+				now, _ := types.ParseDateTime(time.Now())
+				record.Set("last_seen_at", now)
+				if err := app.Dao().SaveRecord(record); err != nil {
+					logging.FromEcho(c).Debug("failed to update device last seen", "device_id", deviceID, "error", err)
+				}
+			}
+
+			if channelID := c.QueryParam("channel"); channelID != "" {
+				channel, err := app.Dao().FindRecordById("channels", channelID)
+				if err != nil || !slices.Contains(channelOwners(app, channel), authRecord.Id) {
+					return apis.NewNotFoundError("Channel not found", nil)
+				}
+				return c.JSON(http.StatusOK, streamService.ResolveChannel(c, channel, device))
+			}
+
+			if filename := c.QueryParam("recording"); filename != "" {
+				if !recorder.ValidFilename(filename) {
+					return apis.NewBadRequestError("Invalid filename", nil)
+				}
+				if !canManageRecording(c, filename) {
+					return apis.NewNotFoundError("Recording not found", nil)
+				}
+				if _, err := recorderService.StatFile(filename); err != nil {
+					return apis.NewNotFoundError("Recording not found", nil)
+				}
+				return c.JSON(http.StatusOK, streamService.ResolveRecording(c, recorderService, filename, device))
+			}
+
+			return apis.NewBadRequestError("channel or recording is required", nil)
+		}, apis.RequireRecordAuth())
+
+		// =========================================
+		// WebRTC (WHEP) endpoints
+		// =========================================
+
+		// Play a channel over WebRTC: a WHEP endpoint answering the SDP offer
+		// of the player, with the URL of the session in Location. Players send
+		// the auth token as a bearer token.
+		api.POST("/api/whep/:channelId", openapi.Operation{
+			Summary:     "Play a channel over WebRTC (WHEP)",
+			Description: "The body is the SDP offer of the player (application/sdp), the response its SDP answer with the URL of the session in Location. Players send the auth token as a bearer token.",
+			Produces:    "application/sdp",
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+			if !strings.HasPrefix(c.Request().Header.Get("Content-Type"), "application/sdp") {
+				return apis.NewApiError(http.StatusUnsupportedMediaType, "The body must be an SDP offer (application/sdp)", nil)
+			}
+
+			channel, err := app.Dao().FindRecordById("channels", c.PathParam("channelId"))
+			if err != nil || !slices.Contains(channelOwners(app, channel), authRecord.Id) ||
+				parentalService.CheckView(c, channel) != nil {
+				return apis.NewNotFoundError("Channel not found", nil)
+			}
+
+			offer, err := io.ReadAll(io.LimitReader(c.Request().Body, 64<<10))
+			if err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
+			}
+			session, answer, err := streamService.WHEPOffer(channel, authRecord.Id, c.RealIP(), string(offer))
+			if err != nil {
+				return whepError(err)
+			}
+
+			c.Response().Header().Set("Location", "/api/whep/sessions/"+session.ID)
+			c.Response().Header().Set("Access-Control-Expose-Headers", "Location")
+			return c.Blob(http.StatusCreated, "application/sdp", []byte(answer))
+		}, apis.RequireRecordAuth())
+
+		// Add the ICE candidates a player trickles to its session
+		api.PATCH("/api/whep/sessions/:id", openapi.Operation{
+			Summary:     "Add ICE candidates to a WebRTC session",
+			Description: "The body is an SDP fragment of candidates (application/trickle-ice-sdpfrag).",
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+			if !strings.HasPrefix(c.Request().Header.Get("Content-Type"), "application/trickle-ice-sdpfrag") {
+				return apis.NewApiError(http.StatusUnsupportedMediaType, "The body must be an SDP fragment (application/trickle-ice-sdpfrag)", nil)
+			}
+
+			fragment, err := io.ReadAll(io.LimitReader(c.Request().Body, 64<<10))
+			if err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
+			}
+			if err := streamService.WHEPCandidates(c.PathParam("id"), authRecord.Id, string(fragment)); err != nil {
+				return whepError(err)
+			}
+			return c.NoContent(http.StatusNoContent)
+		}, apis.RequireRecordAuth())
+
+		// End a WebRTC session
+		api.DELETE("/api/whep/sessions/:id", openapi.Operation{Summary: "End a WebRTC session"}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+			if err := streamService.WHEPClose(c.PathParam("id"), authRecord.Id); err != nil {
+				return whepError(err)
+			}
+			return c.NoContent(http.StatusOK)
+		}, apis.RequireRecordAuth())
+
+		// =========================================
+		// Cast endpoints
+		// =========================================
+
+		// List the Chromecast and DLNA devices of the network, with what was cast to them.
+		// ?refresh=true scans the network again, otherwise it is scanned every few minutes
+		api.GET("/api/cast/devices", openapi.Operation{
+			Summary:     "List the Chromecast and DLNA devices of the network, with what was cast to them",
+			Description: "?refresh=true scans the network again, otherwise it is scanned every few minutes.",
+			Query:       []openapi.Param{{Name: "refresh"}},
+		}, func(c echo.Context) error {
+			refresh, _ := strconv.ParseBool(c.QueryParam("refresh"))
+			return c.JSON(http.StatusOK, castService.Devices(c.Request().Context(), refresh))
+		}, apis.RequireRecordAuth())
+
+		// Get a device with its playback state
+		api.GET("/api/cast/devices/:id", openapi.Operation{Summary: "Get a cast device with its playback state"}, func(c echo.Context) error {
+			device, err := castService.Device(c.Request().Context(), c.PathParam("id"))
+			if err != nil {
+				return castError(err)
+			}
+			status, err := castService.Status(c.Request().Context(), device.ID)
+			if err != nil && !errors.Is(err, cast.ErrNotCasting) {
+				return castError(err)
+			}
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"device": device,
+				"status": status,
+			})
+		}, apis.RequireRecordAuth())
+
+		// Cast a channel or a recorded file to a device. The device fetches it
+		// from the stream proxy, transcoded when it can't play it as is.
+		api.POST("/api/cast/devices/:id/play", openapi.Operation{
+			Summary:     "Cast a channel or a recorded file to a device",
+			Description: "channel=<id> or recording=<filename>. The device fetches the stream from the stream proxy, transcoded when it can't play it as is, so PUBLIC_URL must be reachable from it.",
+			Body: openapi.Fields{
+				"channel":   "string",
+				"recording": "string",
+			},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			data := struct {
+				Channel   string `json:"channel"`
+				Recording string `json:"recording"`
+			}{}
+			if err := c.Bind(&data); err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
+			}
+
+			device, err := castService.Device(c.Request().Context(), c.PathParam("id"))
+			if err != nil {
+				return castError(err)
+			}
+
+			var decision stream.PlaybackDecision
+			var media cast.Media
+			switch {
+			case data.Channel != "":
+				channel, err := app.Dao().FindRecordById("channels", data.Channel)
+				if err != nil || !slices.Contains(channelOwners(app, channel), authRecord.Id) ||
+					parentalService.CheckView(c, channel) != nil {
+					return apis.NewNotFoundError("Channel not found", nil)
+				}
+				decision = streamService.ResolveChannel(c, channel, device.Profile())
+				// Devices don't send the headers providers may require, and
+				// providers seldom allow other origins: they go through the proxy
+				if decision.Method == stream.MethodDirect {
+					decision.Method = stream.MethodProxy
+					decision.URL = streamService.PlaybackURL(streamService.BaseURL(c), channel.Id)
+				}
+				media = cast.Media{
+					Title:    channel.GetString("name"),
+					ImageURL: channel.GetString("tvg_logo"),
+					Live:     true,
+					Channel:  channel.Id,
+				}
+			case data.Recording != "":
+				filename := data.Recording
+				if !recorder.ValidFilename(filename) {
+					return apis.NewBadRequestError("Invalid filename", nil)
+				}
+				if !canManageRecording(c, filename) {
+					return apis.NewNotFoundError("Recording not found", nil)
+				}
+				if _, err := recorderService.StatFile(filename); err != nil {
+					return apis.NewNotFoundError("Recording not found", nil)
+				}
+				decision = streamService.ResolveRecording(c, recorderService, filename, device.Profile())
+
+				title := strings.TrimSuffix(filename, filepath.Ext(filename))
+				if record, err := app.Dao().FindFirstRecordByData(library.Collection, "file_path", filename); err == nil && record.GetString("program_title") != "" {
+					title = record.GetString("program_title")
+				}
+				media = cast.Media{Title: title, Recording: filename}
+			default:
+				return apis.NewBadRequestError("channel or recording is required", nil)
+			}
+			media.URL = decision.URL
+			media.ContentType = cast.ContentType(decision)
+
+			status, err := castService.Cast(c.Request().Context(), device.ID, media, authRecord.Id)
+			if err != nil {
+				return castError(err)
+			}
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"status":   status,
+				"playback": decision,
+			})
+		}, apis.RequireRecordAuth())
+
+		// Pause what a device plays, whichever app cast it
+		api.POST("/api/cast/devices/:id/pause", openapi.Operation{Summary: "Pause what a cast device plays"}, func(c echo.Context) error {
+			status, err := castService.Pause(c.Request().Context(), c.PathParam("id"))
+			if err != nil {
+				return castError(err)
+			}
+			return c.JSON(http.StatusOK, status)
+		}, apis.RequireRecordAuth())
+
+		// Resume what a device paused
+		api.POST("/api/cast/devices/:id/resume", openapi.Operation{Summary: "Resume what a cast device paused"}, func(c echo.Context) error {
+			status, err := castService.Resume(c.Request().Context(), c.PathParam("id"))
+			if err != nil {
+				return castError(err)
+			}
+			return c.JSON(http.StatusOK, status)
+		}, apis.RequireRecordAuth())
+
+		// Stop what a device plays
+		api.POST("/api/cast/devices/:id/stop", openapi.Operation{Summary: "Stop what a cast device plays"}, func(c echo.Context) error {
+			status, err := castService.Stop(c.Request().Context(), c.PathParam("id"))
+			if err != nil {
+				return castError(err)
+			}
+			return c.JSON(http.StatusOK, status)
+		}, apis.RequireRecordAuth())
+
+		// Set the volume of a device, from 0 to 1
+		api.POST("/api/cast/devices/:id/volume", openapi.Operation{
+			Summary: "Set the volume of a cast device, from 0 to 1",
+			Body: openapi.Fields{
+				"level": "number!",
+			},
+		}, func(c echo.Context) error {
+			data := struct {
+				Level *float64 `json:"level"`
+			}{}
+			if err := c.Bind(&data); err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
+			}
+			if data.Level == nil || *data.Level < 0 || *data.Level > 1 {
+				return apis.NewBadRequestError("level must be between 0 and 1", nil)
+			}
+
+			status, err := castService.SetVolume(c.Request().Context(), c.PathParam("id"), *data.Level)
+			if err != nil {
+				return castError(err)
+			}
+			return c.JSON(http.StatusOK, status)
+		}, apis.RequireRecordAuth())
+
+		// =========================================
+		// Notification API endpoints
+		// =========================================
+
+		// List event types notification settings can subscribe to
+		api.GET("/api/notifications/events", openapi.Operation{Summary: "List event types notification settings can subscribe to"}, func(c echo.Context) error {
+			return c.JSON(http.StatusOK, notifications.AllEvents)
+		}, apis.RequireRecordAuth())
+
+		// Send a test notification through one of the user's settings
+		api.POST("/api/notifications/test", openapi.Operation{
+			Summary: "Send a test notification through one of the user's settings",
+			Body: openapi.Fields{
+				"setting_id": "string",
+			},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			data := struct {
+				SettingID string `json:"setting_id"`
+			}{}
+			if err := c.Bind(&data); err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
+			}
+
+			record, err := app.Dao().FindRecordById(notifications.CollectionName, data.SettingID)
+			if err != nil || !recordOwnedBy(record, "user", authRecord.Id) {
+				return apis.NewNotFoundError("Notification setting not found", nil)
+			}
+
+			if err := notificationService.SendTest(c.Request().Context(), record); err != nil {
+				return apis.NewBadRequestError("Test notification failed: "+err.Error(), nil)
+			}
+
+			return c.JSON(http.StatusOK, map[string]string{"message": "Test notification sent"})
+		}, apis.RequireRecordAuth())
+
+		// =========================================
+		// Webhook API endpoints
+		// =========================================
+
+		// List event types webhooks can subscribe to
+		api.GET("/api/webhooks/events", openapi.Operation{Summary: "List event types webhooks can subscribe to"}, func(c echo.Context) error {
+			return c.JSON(http.StatusOK, webhooks.AllEvents)
+		}, apis.RequireRecordAuth())
+
+		// Queue a ping delivery to one of the user's webhooks.
+		// The returned job (and the webhook's last_status) reports the outcome.
+		api.POST("/api/webhooks/:id/test", openapi.Operation{
+			Summary:     "Queue a ping delivery to one of the user's webhooks",
+			Description: "The returned job (and the webhook's last_status) reports the outcome.",
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			record, err := app.Dao().FindRecordById(webhooks.CollectionName, c.PathParam("id"))
+			if err != nil || !recordOwnedBy(record, "user", authRecord.Id) {
+				return apis.NewNotFoundError("Webhook not found", nil)
+			}
+
+			job, err := webhookService.SendTest(record)
+			if err != nil {
+				return apis.NewBadRequestError("Failed to queue test delivery", err)
+			}
+
+			return c.JSON(http.StatusOK, job)
+		}, apis.RequireRecordAuth())
+
+		// =========================================
+		// Event API endpoints
+		// =========================================
+
+		// WebSocket pushing recorder, job, playlist sync, notification and
+		// channel events, and the entries of subtitle sessions, by topic
+		api.GET("/api/events", openapi.Operation{
+			Summary:     "WebSocket of backend events",
+			Description: "Upgrades to a WebSocket sending the events of the topics subscribed to, as ?topics=recorder,jobs or with {\"action\": \"subscribe\", \"topics\": [...]} messages. subtitle:<session id> streams the entries of a session.",
+			Query:       []openapi.Param{{Name: "topics"}, {Name: "token", Description: "Auth token, for clients that can't send headers"}},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+			return eventHub.Serve(c, authRecord.Id)
+		}, apis.RequireRecordAuth())
+
+		// =========================================
+		// Background job API endpoints
+		// =========================================
+
+		// List the current user's jobs
+		api.GET("/api/jobs", openapi.Operation{
+			Summary: "List the current user's jobs",
+			Query:   []openapi.Param{{Name: "limit"}, {Name: "type"}, {Name: "status"}},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			limit, _ := strconv.Atoi(c.QueryParam("limit"))
+			if limit <= 0 || limit > 200 {
+				limit = 50
+			}
+
+			list := jobManager.List(jobs.ListFilter{
+				User:   authRecord.Id,
+				Type:   c.QueryParam("type"),
+				Status: jobs.JobStatus(c.QueryParam("status")),
+				Limit:  limit,
+			})
+
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"jobs":  list,
+				"count": len(list),
+			})
+		}, apis.RequireRecordAuth())
+
+		// Get a single job
+		api.GET("/api/jobs/:id", openapi.Operation{Summary: "Get a single job"}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			job, exists := jobManager.Get(c.PathParam("id"))
+			if !exists || job.User != authRecord.Id {
+				return apis.NewNotFoundError("Job not found", nil)
+			}
+
+			return c.JSON(http.StatusOK, job)
+		}, apis.RequireRecordAuth())
+
+		// Cancel a pending or running job
+		api.POST("/api/jobs/:id/cancel", openapi.Operation{Summary: "Cancel a pending or running job"}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			job, exists := jobManager.Get(c.PathParam("id"))
+			if !exists || job.User != authRecord.Id {
+				return apis.NewNotFoundError("Job not found", nil)
+			}
+
+			if err := jobManager.Cancel(job.ID); err != nil {
+				return apis.NewBadRequestError("Failed to cancel job", err)
+			}
+
+			job, _ = jobManager.Get(job.ID)
+			return c.JSON(http.StatusOK, job)
+		}, apis.RequireRecordAuth())
+
+		// =========================================
+		// Storage quota API endpoints
+		// =========================================
+
+		// Storage used by the user's recordings and subtitle exports, and their quota
+		api.GET("/api/storage/usage", openapi.Operation{Summary: "Storage used by the user's recordings and subtitle exports, and their quota", Scope: apikeys.ScopeRecorder}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			usage, err := quotaService.Usage(authRecord.Id)
+			if err != nil {
+				return apis.NewBadRequestError("Failed to compute storage usage", err)
+			}
+
+			return c.JSON(http.StatusOK, usage)
+		}, apis.RequireRecordAuth())
+
+		// Storage used by every user, largest first (admin only)
+		api.GET("/api/admin/storage/usage", openapi.Operation{Summary: "Storage used by every user, largest first"}, func(c echo.Context) error {
+			usage, err := quotaService.AllUsage()
+			if err != nil {
+				return apis.NewBadRequestError("Failed to compute storage usage", err)
+			}
+
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"users": usage,
+				"count": len(usage),
+			})
+		}, access.RequireAdmin())
+
+		// Override a user's quota in MB: 0 restores the default, -1 lifts it (admin only)
+		api.PUT("/api/admin/storage/quota/:userId", openapi.Operation{
+			Summary: "Override a user's quota in MB: 0 restores the default, -1 lifts it",
+			Body: openapi.Fields{
+				"quota_mb": "integer",
+			},
+		}, func(c echo.Context) error {
+			var data struct {
+				QuotaMB int `json:"quota_mb"`
+			}
+			if err := c.Bind(&data); err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
+			}
+
+			userID := c.PathParam("userId")
+			if _, err := app.Dao().FindRecordById("users", userID); err != nil {
+				return apis.NewNotFoundError("User not found", nil)
+			}
+			if err := quotaService.SetQuota(userID, data.QuotaMB); err != nil {
+				return apis.NewBadRequestError("Failed to set quota", err)
+			}
+
+			usage, err := quotaService.Usage(userID)
+			if err != nil {
+				return apis.NewBadRequestError("Failed to compute storage usage", err)
+			}
+
+			return c.JSON(http.StatusOK, usage)
+		}, access.RequireAdmin())
+
+		// =========================================
+		// Audit log API endpoints (admin only)
+		// =========================================
+
+		// List audit entries, newest first. ?action= and ?user= filter them,
+		// ?page= and ?perPage= paginate.
+		api.GET("/api/admin/audit", openapi.Operation{
+			Summary:     "List audit entries, newest first",
+			Description: "?action= and ?user= filter them, ?page= and ?perPage= paginate.",
+			Query:       []openapi.Param{{Name: "page"}, {Name: "perPage"}, {Name: "action"}, {Name: "user"}},
+		}, func(c echo.Context) error {
+			page, _ := strconv.Atoi(c.QueryParam("page"))
+			perPage, _ := strconv.Atoi(c.QueryParam("perPage"))
+
+			result, err := auditService.List(audit.Query{
+				Action:  c.QueryParam("action"),
+				User:    c.QueryParam("user"),
+				Page:    page,
+				PerPage: perPage,
+			})
+			if err != nil {
+				return apis.NewBadRequestError("Failed to list audit entries", err)
+			}
+
+			return c.JSON(http.StatusOK, result)
+		}, access.RequireAdmin())
+
+		// =========================================
+		// Parental control endpoints
+		// =========================================
+
+		// Verify a profile PIN, returns a token lifting the profile's
+		// restrictions while sent in the X-Profile-Unlock header
+		api.POST("/api/profiles/:id/pin/verify", openapi.Operation{
+			Summary:     "Verify a profile PIN",
+			Description: "Returns a token lifting the profile's restrictions while sent in the X-Profile-Unlock header.",
+			Body: openapi.Fields{
+				"pin": "string",
+			},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			var data struct {
+				PIN string `json:"pin"`
+			}
+			if err := c.Bind(&data); err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
+			}
+
+			profile, err := app.Dao().FindRecordById("profiles", c.PathParam("id"))
+			if err != nil || !parental.OwnedBy(profile, authRecord.Id) {
+				return apis.NewNotFoundError("Profile not found", nil)
+			}
+
+			token, expires, err := parentalService.VerifyPIN(profile, data.PIN)
+			switch {
+			case errors.Is(err, parental.ErrLocked):
+				return apis.NewApiError(http.StatusTooManyRequests, err.Error(), nil)
+			case errors.Is(err, parental.ErrNoPIN):
+				return apis.NewBadRequestError("This profile has no PIN", nil)
+			case err != nil:
+				return apis.NewBadRequestError("Invalid PIN", nil)
+			}
+
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"token":      token,
+				"expires_at": expires.UTC().Format(time.RFC3339),
+			})
+		}, apis.RequireRecordAuth(), auditService.Middleware(audit.ActionProfilePIN))
+
+		// Set or change a profile PIN, stored as a bcrypt hash. Changing an
+		// existing PIN needs the profile to be unlocked.
+		api.PUT("/api/profiles/:id/pin", openapi.Operation{
+			Summary:     "Set or change a profile PIN, stored as a bcrypt hash",
+			Description: "Changing an existing PIN needs the profile to be unlocked.",
+			Body: openapi.Fields{
+				"pin": "string",
+			},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			var data struct {
+				PIN string `json:"pin"`
+			}
+			if err := c.Bind(&data); err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
+			}
+
+			profile, err := app.Dao().FindRecordById("profiles", c.PathParam("id"))
+			if err != nil || !parental.OwnedBy(profile, authRecord.Id) {
+				return apis.NewNotFoundError("Profile not found", nil)
+			}
+			if parental.HasPIN(profile) && !parentalService.Unlocked(c, profile.Id) {
+				return apis.NewForbiddenError("Enter the current PIN to change it", nil)
+			}
+
+			if err := parentalService.SetPIN(profile, data.PIN); errors.Is(err, parental.ErrPINFormat) {
+				return apis.NewBadRequestError(err.Error(), nil)
+			} else if err != nil {
+				return apis.NewBadRequestError("Failed to save PIN", err)
+			}
+
+			return c.JSON(http.StatusOK, map[string]bool{"has_pin": true})
+		}, apis.RequireRecordAuth(), auditService.Middleware(audit.ActionProfilePINSet))
+
+		// Remove a profile PIN, the profile must be unlocked
+		api.DELETE("/api/profiles/:id/pin", openapi.Operation{Summary: "Remove a profile PIN, the profile must be unlocked"}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			profile, err := app.Dao().FindRecordById("profiles", c.PathParam("id"))
+			if err != nil || !parental.OwnedBy(profile, authRecord.Id) {
+				return apis.NewNotFoundError("Profile not found", nil)
+			}
+			if parental.HasPIN(profile) && !parentalService.Unlocked(c, profile.Id) {
+				return apis.NewForbiddenError("Enter the current PIN to remove it", nil)
+			}
+
+			if err := parentalService.RemovePIN(profile); err != nil {
+				return apis.NewBadRequestError("Failed to remove PIN", err)
+			}
+
+			return c.JSON(http.StatusOK, map[string]bool{"has_pin": false})
+		}, apis.RequireRecordAuth(), auditService.Middleware(audit.ActionProfilePINSet))
+
+		// =========================================
+		// API key endpoints
+		// =========================================
+
+		// List the user's API keys
+		api.GET("/api/keys", openapi.Operation{Summary: "List the user's API keys"}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			keys, err := apiKeyService.List(authRecord.Id)
+			if err != nil {
+				return apis.NewBadRequestError("Failed to list API keys", err)
+			}
+
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"keys":   keys,
+				"scopes": apikeys.AllScopes,
+			})
+		}, apis.RequireRecordAuth())
+
+		// Create an API key, returned once
+		api.POST("/api/keys", openapi.Operation{
+			Summary: "Create an API key, returned once",
+			Body: openapi.Fields{
+				"name":       "string",
+				"scopes":     "[]string",
+				"expires_at": "date-time",
+			},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			var data struct {
+				Name      string    `json:"name"`
+				Scopes    []string  `json:"scopes"`
+				ExpiresAt time.Time `json:"expires_at"`
+			}
+			if err := c.Bind(&data); err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
+			}
+			if strings.TrimSpace(data.Name) == "" {
+				return apis.NewBadRequestError("name is required", nil)
+			}
+			if !data.ExpiresAt.IsZero() && data.ExpiresAt.Before(time.Now()) {
+				return apis.NewBadRequestError("expires_at must be in the future", nil)
+			}
+
+			key, info, err := apiKeyService.Create(authRecord.Id, strings.TrimSpace(data.Name), data.Scopes, data.ExpiresAt)
+			if errors.Is(err, apikeys.ErrInvalidScopes) {
+				return apis.NewBadRequestError(err.Error(), nil)
+			}
+			if err != nil {
+				return apis.NewBadRequestError("Failed to create API key", err)
+			}
+
+			audit.SetTarget(c, info.ID)
+			audit.SetDetail(c, "scopes", info.Scopes)
+
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"key":  key,
+				"info": info,
+			})
+		}, apis.RequireRecordAuth(), auditService.Middleware(audit.ActionAPIKeyCreate))
+
+		// Revoke an API key
+		api.DELETE("/api/keys/:id", openapi.Operation{Summary: "Revoke an API key"}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			if err := apiKeyService.Revoke(authRecord.Id, c.PathParam("id")); err != nil {
+				return apis.NewNotFoundError("API key not found", err)
+			}
+
+			return c.JSON(http.StatusOK, map[string]string{"status": "revoked"})
+		}, apis.RequireRecordAuth(), auditService.Middleware(audit.ActionAPIKeyRevoke))
+
+		// =========================================
+		// Stream token endpoints
+		// =========================================
+
+		// List the user's stream tokens
+		api.GET("/api/stream-tokens", openapi.Operation{Summary: "List the user's stream tokens"}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			tokens, err := streamTokenService.List(authRecord.Id)
+			if err != nil {
+				return apis.NewBadRequestError("Failed to list stream tokens", err)
+			}
+
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"tokens": tokens,
+			})
+		}, apis.RequireRecordAuth())
+
+		// Create a stream token, returned once with the URLs of its exports
+		api.POST("/api/stream-tokens", openapi.Operation{
+			Summary:     "Create a stream token, returned once with the URLs of its exports",
+			Description: "With a profile, the exports leave out the channels its parental controls hide.",
+			Body: openapi.Fields{
+				"name":    "string!",
+				"profile": "string",
+			},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			var data struct {
+				Name    string `json:"name"`
+				Profile string `json:"profile"`
+			}
+			if err := c.Bind(&data); err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
+			}
+			if strings.TrimSpace(data.Name) == "" {
+				return apis.NewBadRequestError("name is required", nil)
+			}
+
+			token, info, err := streamTokenService.Create(authRecord.Id, strings.TrimSpace(data.Name), data.Profile)
+			if errors.Is(err, streamtokens.ErrProfile) {
+				return apis.NewBadRequestError(err.Error(), nil)
+			}
+			if err != nil {
+				return apis.NewBadRequestError("Failed to create stream token", err)
+			}
+
+			audit.SetTarget(c, info.ID)
+
+			export := streamtokens.Export{BaseURL: streamService.BaseURL(c), Token: token}
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"token":         token,
+				"info":          info,
+				"m3u_url":       export.DeviceURL() + "/playlist.m3u",
+				"hdhomerun_url": export.DeviceURL(),
+			})
+		}, apis.RequireRecordAuth(), auditService.Middleware(audit.ActionStreamTokenCreate))
+
+		// Revoke a stream token
+		api.DELETE("/api/stream-tokens/:id", openapi.Operation{Summary: "Revoke a stream token"}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			if err := streamTokenService.Revoke(authRecord.Id, c.PathParam("id")); err != nil {
+				return apis.NewNotFoundError("Stream token not found", err)
+			}
+
+			return c.JSON(http.StatusOK, map[string]string{"status": "revoked"})
+		}, apis.RequireRecordAuth(), auditService.Middleware(audit.ActionStreamTokenRevoke))
+
+		// M3U playlist of the channels of a stream token, for IPTV players
+		api.GET("/api/export/:token/playlist.m3u", openapi.Operation{
+			Summary:     "M3U playlist of the channels of a stream token",
+			Description: "No auth, the token in the URL is the credential.",
+			Produces:    "audio/x-mpegurl",
+		}, func(c echo.Context) error {
+			_, export, err := streamTokenExport(c, true)
+			if err != nil {
+				return err
+			}
+
+			c.Response().Header().Set("Content-Type", "audio/x-mpegurl; charset=utf-8")
+			c.Response().Header().Set("Cache-Control", "no-cache")
+			c.Response().WriteHeader(http.StatusOK)
+			return export.WriteM3U(c.Response())
+		})
+
+		// HDHomeRun device of a stream token, for Plex, Jellyfin and Emby
+		api.GET("/api/export/:token/discover.json", openapi.Operation{
+			Summary:     "HDHomeRun device of a stream token",
+			Description: "No auth, the token in the URL is the credential.",
+		}, func(c echo.Context) error {
+			record, export, err := streamTokenExport(c, false)
+			if err != nil {
+				return err
+			}
+
+			return c.JSON(http.StatusOK, export.Discover(record, streamTokenService.Tuners(record)))
+		})
+
+		// HDHomeRun lineup of the channels of a stream token
+		api.GET("/api/export/:token/lineup.json", openapi.Operation{
+			Summary:     "HDHomeRun lineup of the channels of a stream token",
+			Description: "No auth, the token in the URL is the credential.",
+		}, func(c echo.Context) error {
+			_, export, err := streamTokenExport(c, true)
+			if err != nil {
+				return err
+			}
+
+			return c.JSON(http.StatusOK, export.Lineup())
+		})
+
+		// HDHomeRun lineup status, the lineup never needs a scan
+		api.GET("/api/export/:token/lineup_status.json", openapi.Operation{
+			Summary:     "HDHomeRun lineup status of a stream token",
+			Description: "No auth, the token in the URL is the credential.",
+		}, func(c echo.Context) error {
+			if _, _, err := streamTokenExport(c, false); err != nil {
+				return err
+			}
+
+			return c.JSON(http.StatusOK, streamtokens.LineupStatus())
+		})
+
+		// HDHomeRun channel scan, which players start before reading the
+		// lineup: there is nothing to scan
+		api.POST("/api/export/:token/lineup.post", openapi.Operation{
+			Summary:     "HDHomeRun channel scan of a stream token",
+			Description: "No auth, the token in the URL is the credential. Accepted and ignored.",
+		}, func(c echo.Context) error {
+			if _, _, err := streamTokenExport(c, false); err != nil {
+				return err
+			}
+
+			return c.NoContent(http.StatusOK)
+		})
+
+		// =========================================
+		// Session endpoints
+		// =========================================
+
+		// List the clients the user is signed in on
+		api.GET("/api/auth/sessions", openapi.Operation{Summary: "List the clients the user is signed in on"}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			list, err := sessionService.List(c, authRecord.Id)
+			if err != nil {
+				return apis.NewBadRequestError("Failed to list sessions", err)
+			}
+
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"sessions": list,
+			})
+		}, apis.RequireRecordAuth())
+
+		// Sign out everywhere else, returns the token replacing the current one
+		api.POST("/api/auth/sessions/revoke-others", openapi.Operation{Summary: "Sign out everywhere else, returns the token replacing the current one"}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			token, revoked, err := sessionService.RevokeOthers(c, authRecord)
+			if err != nil {
+				return apis.NewBadRequestError("Failed to revoke sessions", err)
+			}
+			audit.SetDetail(c, "sessions_revoked", revoked)
+
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"token":   token,
+				"revoked": revoked,
+			})
+		}, apis.RequireRecordAuth(), auditService.Middleware(audit.ActionSignOutOthers))
+
+		// Revoke a session, its token stops working immediately
+		api.DELETE("/api/auth/sessions/:id", openapi.Operation{Summary: "Revoke a session, its token stops working immediately"}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			if err := sessionService.Revoke(authRecord.Id, c.PathParam("id")); err != nil {
+				return apis.NewNotFoundError("Session not found", err)
+			}
+
+			return c.JSON(http.StatusOK, map[string]string{"status": "revoked"})
+		}, apis.RequireRecordAuth(), auditService.Middleware(audit.ActionSessionRevoke))
+
+		// List the browsers allowed to skip the TOTP code
+		api.GET("/api/auth/trusted-devices", openapi.Operation{Summary: "List the browsers allowed to skip the TOTP code"}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			devices, err := sessionService.ListTrusted(c, authRecord)
+			if err != nil {
+				return apis.NewBadRequestError("Failed to list trusted devices", err)
+			}
+
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"devices": devices,
+			})
+		}, apis.RequireRecordAuth())
+
+		// Forget a trusted device, it has to enter the TOTP code again
+		api.DELETE("/api/auth/trusted-devices/:id", openapi.Operation{Summary: "Forget a trusted device, it has to enter the TOTP code again"}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			if err := sessionService.Untrust(c, authRecord, c.PathParam("id")); err != nil {
+				return apis.NewNotFoundError("Trusted device not found", err)
+			}
+
+			return c.JSON(http.StatusOK, map[string]string{"status": "revoked"})
+		}, apis.RequireRecordAuth(), auditService.Middleware(audit.ActionDeviceUntrust))
+
+		// Sign out, ending the session of the request's token
+		api.POST("/api/auth/logout", openapi.Operation{Summary: "Sign out, ending the session of the request's token"}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			if session := sessions.Current(c); session != nil {
+				if err := sessionService.Revoke(authRecord.Id, session.Id); err != nil {
+					return apis.NewBadRequestError("Failed to end session", err)
+				}
+			}
+
+			return c.NoContent(http.StatusNoContent)
+		}, apis.RequireRecordAuth())
+
+		// =========================================
+		// Account endpoints
+		// =========================================
+
+		// Download everything stored about the user as a zip archive
+		api.POST("/api/account/export", openapi.Operation{
+			Summary:  "Download everything stored about the user as a zip archive",
+			Produces: "application/zip",
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			filename := fmt.Sprintf("streamvault-export-%s.zip", time.Now().Format("20060102"))
+			c.Response().Header().Set("Content-Type", "application/zip")
+			c.Response().Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+			c.Response().WriteHeader(http.StatusOK)
+
+			// Headers are sent, a failure can only cut the archive short
+			if err := accountService.Export(c.Response(), authRecord); err != nil {
+				logging.FromContext(c.Request().Context()).Error("account export failed", "error", err)
+				audit.SetDetail(c, "error", err.Error())
+			}
+			return nil
+		}, apis.RequireRecordAuth(), auditService.Middleware(audit.ActionAccountExport))
+
+		// Delete the account with its recordings, thumbnails and subtitle
+		// exports. Needs the password, and the TOTP code when 2FA is on.
+		api.DELETE("/api/account", openapi.Operation{
+			Summary:     "Delete the account with its recordings, thumbnails and subtitle exports",
+			Description: "Needs the password, and the TOTP code when 2FA is on.",
+			Body: openapi.Fields{
+				"password": "string",
+				"code":     "string",
+			},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			data := struct {
+				Password string `json:"password"`
+				Code     string `json:"code"`
+			}{}
+			if err := c.Bind(&data); err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
+			}
+
+			if !authRecord.ValidatePassword(data.Password) {
+				return apis.NewBadRequestError("Invalid password", nil)
+			}
+			if authRecord.GetBool("totp_enabled") && !totp.Validate(data.Code, authRecord.GetString("totp_secret")) {
+				return apis.NewBadRequestError("Invalid TOTP code", nil)
+			}
+
+			result, err := accountService.Delete(authRecord)
+			if err != nil {
+				return apis.NewBadRequestError("Failed to delete account", err)
+			}
+			audit.SetDetail(c, "recordings_deleted", result.RecordingsDeleted)
+			audit.SetDetail(c, "exports_deleted", result.ExportsDeleted)
+
+			return c.JSON(http.StatusOK, result)
+		}, apis.RequireRecordAuth(), auditService.Middleware(audit.ActionAccountDelete))
+
+		// =========================================
+		// Favorites endpoints
+		// =========================================
+
+		// Reorder the favorites of a profile in one call: the channels given
+		// come first in that order, the others follow as they were
+		api.PUT("/api/favorites/order", openapi.Operation{
+			Summary:     "Reorder the favorites of a profile",
+			Description: "The channels given come first in that order, the others follow as they were.",
+			Body: openapi.Fields{
+				"profile":  "string!",
+				"channels": "[]string",
+			},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			data := struct {
+				Profile  string   `json:"profile"`
+				Channels []string `json:"channels"`
+			}{}
+			if err := c.Bind(&data); err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
+			}
+
+			profile, _, err := favoritesProfile(app, c, authRecord.Id, data.Profile)
+			if err != nil {
+				return err
+			}
+
+			reordered, err := favorites.Reorder(app, profile.Id, data.Channels)
+			var notFavorites *favorites.NotFavoritesError
+			switch {
+			case errors.As(err, &notFavorites):
+				return apis.NewNotFoundError(notFavorites.Error(), nil)
+			case errors.Is(err, favorites.ErrEmpty), errors.Is(err, favorites.ErrTooLarge):
+				return apis.NewBadRequestError(err.Error(), nil)
+			case err != nil:
+				return apis.NewBadRequestError("Failed to reorder favorites", err)
+			}
+
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"reordered": reordered,
+			})
+		}, apis.RequireRecordAuth())
+
+		// Copy the favorites of a profile to another one of the user, after
+		// its own, or instead of them with replace. Channels hidden from the
+		// target profile are skipped unless it was unlocked.
+		api.POST("/api/favorites/copy", openapi.Operation{
+			Summary:     "Copy the favorites of a profile to another one",
+			Description: "Copied after the target's own favorites, or instead of them with replace. Channels hidden from the target profile are skipped unless it was unlocked.",
+			Body: openapi.Fields{
+				"from":    "string!",
+				"to":      "string!",
+				"replace": "boolean",
+			},
+			Response: favorites.CopyResult{},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			data := struct {
+				From    string `json:"from"`
+				To      string `json:"to"`
+				Replace bool   `json:"replace"`
+			}{}
+			if err := c.Bind(&data); err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
+			}
+
+			from, _, err := favoritesProfile(app, c, authRecord.Id, data.From)
+			if err != nil {
+				return err
+			}
+			to, keep, err := favoritesProfile(app, c, authRecord.Id, data.To)
+			if err != nil {
+				return err
+			}
+
+			result, err := favorites.Copy(app, from.Id, to.Id, data.Replace, keep)
+			switch {
+			case errors.Is(err, favorites.ErrSameProfile):
+				return apis.NewBadRequestError(err.Error(), nil)
+			case err != nil:
+				return apis.NewBadRequestError("Failed to copy favorites", err)
+			}
+
+			return c.JSON(http.StatusOK, result)
+		}, apis.RequireRecordAuth())
+
+		// Add channels to the favorites of a profile by name, such as a list
+		// exported from another player. Names are matched whatever their
+		// case, against the user's channels.
+		api.POST("/api/favorites/import", openapi.Operation{
+			Summary:     "Add channels to the favorites of a profile by name",
+			Description: "Names are matched whatever their case, against the user's channels. Channels hidden from the profile are skipped unless it was unlocked.",
+			Body: openapi.Fields{
+				"profile": "string!",
+				"names":   "[]string",
+			},
+			Response: favorites.ImportResult{},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			data := struct {
+				Profile string   `json:"profile"`
+				Names   []string `json:"names"`
+			}{}
+			if err := c.Bind(&data); err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
+			}
+
+			profile, keep, err := favoritesProfile(app, c, authRecord.Id, data.Profile)
+			if err != nil {
+				return err
+			}
+
+			result, err := favorites.Import(app, authRecord.Id, profile.Id, data.Names, keep)
+			switch {
+			case errors.Is(err, favorites.ErrEmpty), errors.Is(err, favorites.ErrTooLarge):
+				return apis.NewBadRequestError(err.Error(), nil)
+			case err != nil:
+				return apis.NewBadRequestError("Failed to import favorites", err)
+			}
+
+			return c.JSON(http.StatusOK, result)
+		}, apis.RequireRecordAuth())
+
+		// =========================================
+		// Watch progress endpoints
+		// =========================================
+
+		// Report the playback position of a channel or recording. Players
+		// call this every few seconds; positions are saved in batches.
+		api.PUT("/api/watch/progress", openapi.Operation{
+			Summary:     "Report the playback position of a channel or recording",
+			Description: "Players call this every few seconds; positions are saved in batches.",
+			Body:        watch.Progress{},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			var data watch.Progress
+			if err := c.Bind(&data); err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
+			}
+			if err := data.Validate(); err != nil {
+				return apis.NewBadRequestError(err.Error(), nil)
+			}
+
+			profile, err := app.Dao().FindRecordById("profiles", data.Profile)
+			if err != nil || !parental.OwnedBy(profile, authRecord.Id) {
+				return apis.NewNotFoundError("Profile not found", nil)
+			}
+
+			if data.Channel != "" {
+				if _, err := app.Dao().FindFirstRecordByFilter("channels",
+					"id = {:id} && playlist.user ~ {:user}",
+					dbx.Params{"id": data.Channel, "user": authRecord.Id}); err != nil {
+					return apis.NewNotFoundError("Channel not found", nil)
+				}
+			} else {
+				recording, err := app.Dao().FindRecordById("recordings", data.Recording)
+				if err != nil || recording.GetString("profile") != profile.Id {
+					return apis.NewNotFoundError("Recording not found", nil)
+				}
+			}
+
+			if err := watchService.Update(data); err != nil {
+				return apis.NewBadRequestError(err.Error(), nil)
+			}
+
+			return c.NoContent(http.StatusNoContent)
+		}, apis.RequireRecordAuth())
+
+		// List what a profile can resume, most recently watched first.
+		// Channels hidden from the profile are left out.
+		api.GET("/api/watch/continue", openapi.Operation{
+			Summary:     "List what a profile can resume, most recently watched first",
+			Description: "Channels hidden from the profile are left out.",
+			Query:       []openapi.Param{{Name: "profile"}, {Name: "limit"}},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			profile, err := app.Dao().FindRecordById("profiles", c.QueryParam("profile"))
+			if err != nil || !parental.OwnedBy(profile, authRecord.Id) {
+				return apis.NewNotFoundError("Profile not found", nil)
+			}
+
+			limit, _ := strconv.Atoi(c.QueryParam("limit"))
+
+			var keep func(item watch.Item) bool
+			if restrictions := parental.RestrictionsOf(profile); !restrictions.Empty() && !parentalService.Unlocked(c, profile.Id) {
+				keep = func(item watch.Item) bool {
+					return item.Channel == nil || !restrictions.BlocksChannel(item.Channel)
+				}
+			}
+
+			items, err := watchService.Continue(profile.Id, limit, keep)
+			if err != nil {
+				return apis.NewBadRequestError("Failed to load watch history", err)
+			}
+			for _, item := range items {
+				if item.Channel != nil {
+					streamService.ApplyChannelPolicy(c, item.Channel)
+				}
+			}
+
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"items": items,
+			})
+		}, apis.RequireRecordAuth())
+
+		// Viewing statistics of the user's profiles over a period: hours per
+		// profile, top channels and groups, and hours by hour of day in the
+		// tz time zone. Cached for a few minutes.
+		api.GET("/api/stats/watch", openapi.Operation{
+			Summary:     "Viewing statistics of the user's profiles",
+			Description: "Over a period: hours per profile, top channels and groups, and hours by hour of day in the tz time zone. Cached for a few minutes.",
+			Query:       []openapi.Param{{Name: "period"}, {Name: "profile"}, {Name: "tz"}},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			query := watch.StatsQuery{
+				User:    authRecord.Id,
+				Period:  c.QueryParam("period"),
+				Profile: c.QueryParam("profile"),
+			}
+			if query.Period == "" {
+				query.Period = "week"
+			}
+
+			if tz := c.QueryParam("tz"); tz != "" {
+				location, err := time.LoadLocation(tz)
+				if err != nil {
+					return apis.NewBadRequestError("Unknown time zone", nil)
+				}
+				query.Location = location
+			}
+
+			if query.Profile != "" {
+				profile, err := app.Dao().FindRecordById("profiles", query.Profile)
+				if err != nil || !parental.OwnedBy(profile, authRecord.Id) {
+					return apis.NewNotFoundError("Profile not found", nil)
+				}
+			}
+
+			stats, err := watchService.Stats(query)
+			if errors.Is(err, watch.ErrPeriod) {
+				return apis.NewBadRequestError(err.Error(), nil)
+			} else if err != nil {
+				return apis.NewBadRequestError("Failed to compute statistics", err)
+			}
+
+			return c.JSON(http.StatusOK, stats)
+		}, apis.RequireRecordAuth())
+
+		// Channels and programmes (airing now or within hours, 6 by default)
+		// suggested to a profile from its watch history. refine=true has the
+		// configured Ollama model pick among the programmes.
+		api.GET("/api/recommendations/:profileId", openapi.Operation{
+			Summary:     "Recommendations for a profile",
+			Description: "Channels and programmes (airing now or within hours, 6 by default) suggested from its watch history. refine=true has the configured Ollama model pick among the programmes.",
+			Query:       []openapi.Param{{Name: "limit"}, {Name: "hours"}, {Name: "refine"}},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			profile, err := app.Dao().FindRecordById("profiles", c.PathParam("profileId"))
+			if err != nil || !parental.OwnedBy(profile, authRecord.Id) {
+				return apis.NewNotFoundError("Profile not found", nil)
+			}
+
+			query := recommend.Query{
+				Profile: profile,
+				User:    authRecord.Id,
+			}
+			query.Limit, _ = strconv.Atoi(c.QueryParam("limit"))
+			if hours, err := strconv.Atoi(c.QueryParam("hours")); err == nil {
+				query.Lookahead = time.Duration(hours) * time.Hour
+			}
+			query.Refine, _ = strconv.ParseBool(c.QueryParam("refine"))
+
+			if restrictions := parental.RestrictionsOf(profile); !restrictions.Empty() && !parentalService.Unlocked(c, profile.Id) {
+				query.Keep = func(channel *models.Record) bool {
+					return !restrictions.BlocksChannel(channel)
+				}
+			}
+
+			result, err := recommendService.Recommend(c.Request().Context(), query)
+			if err != nil {
+				return apis.NewBadRequestError("Failed to compute recommendations", err)
+			}
+
+			for _, pick := range result.Channels {
+				streamService.ApplyChannelPolicy(c, pick.Channel)
+			}
+			for _, pick := range result.Programs {
+				streamService.ApplyChannelPolicy(c, pick.Channel)
+			}
+
+			return c.JSON(http.StatusOK, result)
+		}, apis.RequireRecordAuth())
+
+		// =========================================
+		// Programme guide endpoints
+		// =========================================
+
+		// What the favorite channels of a profile air now and next, in one
+		// response for the home screen
+		api.GET("/api/epg/now", openapi.Operation{
+			Summary:     "Now and next on the favorite channels of a profile",
+			Scope:       apikeys.ScopeEPG,
+			Description: "In one response for the home screen.",
+			Query:       []openapi.Param{{Name: "profile"}},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			profile, err := app.Dao().FindRecordById("profiles", c.QueryParam("profile"))
+			if err != nil || !parental.OwnedBy(profile, authRecord.Id) {
+				return apis.NewNotFoundError("Profile not found", nil)
+			}
+
+			var keep func(channel *models.Record) bool
+			if restrictions := parental.RestrictionsOf(profile); !restrictions.Empty() && !parentalService.Unlocked(c, profile.Id) {
+				keep = func(channel *models.Record) bool {
+					return !restrictions.BlocksChannel(channel)
+				}
+			}
+
+			items, err := epgService.Favorites(profile.Id, authRecord.Id, keep)
+			if err != nil {
+				return apis.NewBadRequestError("Failed to load the guide", err)
+			}
+
+			for _, item := range items {
+				streamService.ApplyChannelPolicy(c, item.Channel)
+			}
+
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"items": items,
+			})
+		}, apis.RequireRecordAuth())
+
+		// Queue an import of a guide source, or return the one already
+		// queued or running
+		api.POST("/api/epg/sources/:id/sync", openapi.Operation{
+			Summary:     "Import a guide source",
+			Scope:       apikeys.ScopeEPG,
+			Description: "Queues a background import, or returns the one already queued or running.",
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			source, err := app.Dao().FindRecordById(epg.SourcesCollection, c.PathParam("id"))
+			if err != nil || !recordOwnedBy(source, "user", authRecord.Id) {
+				return apis.NewNotFoundError("Guide source not found", err)
+			}
+			if source.GetString("url") == "" {
+				return apis.NewBadRequestError("Guide source has no URL to import from", nil)
+			}
+
+			job, err := epgScheduler.Sync(authRecord.Id, source.Id)
+			if err != nil {
+				return apis.NewBadRequestError("Failed to queue guide import", err)
+			}
+
+			return c.JSON(http.StatusOK, job)
+		}, apis.RequireRecordAuth())
+
+		// Current or latest import of a guide source, with its last error
+		api.GET("/api/epg/sources/:id/sync-status", openapi.Operation{
+			Summary:     "Import status of a guide source",
+			Scope:       apikeys.ScopeEPG,
+			Description: "The current or latest import, with the error of the last one.",
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			source, err := app.Dao().FindRecordById(epg.SourcesCollection, c.PathParam("id"))
+			if err != nil || !recordOwnedBy(source, "user", authRecord.Id) {
+				return apis.NewNotFoundError("Guide source not found", err)
+			}
+
+			var lastSynced interface{}
+			if synced := source.GetDateTime("last_synced"); !synced.IsZero() {
+				lastSynced = synced
+			}
+
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"job":         epgScheduler.Job(authRecord.Id, source.Id),
+				"last_synced": lastSynced,
+				"last_error":  source.GetString("last_error"),
+			})
+		}, apis.RequireRecordAuth())
+
+		// List the guide mappings of the user, newest first. Mappings are
+		// also listed through the epg_mappings collection.
+		api.GET("/api/epg/mappings", openapi.Operation{
+			Summary:     "List the guide mappings of the user, newest first",
+			Scope:       apikeys.ScopeEPG,
+			Description: "Mappings are also listed through the epg_mappings collection.",
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			mappings, err := epgService.Mappings(authRecord.Id)
+			if err != nil {
+				return apis.NewBadRequestError("Failed to list guide mappings", err)
+			}
+
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"items": mappings,
+			})
+		}, apis.RequireRecordAuth())
+
+		// Channels whose tvg_id matches no channel of the user's guide, with
+		// the guide channels they may be. ?playlist= keeps one playlist,
+		// ?page= and ?perPage= paginate.
+		api.GET("/api/epg/mappings/unmatched", openapi.Operation{
+			Summary:     "Channels without guide data, with suggested guide channels",
+			Scope:       apikeys.ScopeEPG,
+			Description: "Channels whose tvg_id matches no channel of the user's guide and without mapping. ?playlist= keeps one playlist, ?page= and ?perPage= paginate.",
+			Query:       []openapi.Param{{Name: "playlist"}, {Name: "page"}, {Name: "perPage"}},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			page, _ := strconv.Atoi(c.QueryParam("page"))
+			perPage, _ := strconv.Atoi(c.QueryParam("perPage"))
+
+			result, err := epgService.Unmatched(authRecord.Id, epg.UnmatchedQuery{
+				Playlist: c.QueryParam("playlist"),
+				Page:     page,
+				PerPage:  perPage,
+			})
+			if err != nil {
+				return epgMappingError(err, "Failed to list unmatched channels")
+			}
+
+			return c.JSON(http.StatusOK, result)
+		}, apis.RequireRecordAuth())
+
+		// Map unmatched channels to their best suggestion scoring at least
+		// min_score (0.85 by default), when no other scores as much.
+		// dry_run only returns what would be mapped.
+		api.POST("/api/epg/mappings/auto", openapi.Operation{
+			Summary:     "Map unmatched channels to their best suggested guide channel",
+			Scope:       apikeys.ScopeEPG,
+			Description: "When it scores at least min_score (0.85 by default) and no other suggestion scores as much. dry_run only returns what would be mapped.",
+			Body: openapi.Fields{
+				"playlist":  "string",
+				"min_score": "number",
+				"dry_run":   "boolean",
+			},
+			Response: epg.AutoResult{},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			data := struct {
+				Playlist string  `json:"playlist"`
+				MinScore float64 `json:"min_score"`
+				DryRun   bool    `json:"dry_run"`
+			}{}
+			if err := c.Bind(&data); err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
+			}
+			if data.MinScore < 0 || data.MinScore > 1 {
+				return apis.NewBadRequestError("min_score must be between 0 and 1", nil)
+			}
+
+			result, err := epgService.AutoMatch(authRecord.Id, data.Playlist, data.MinScore, data.DryRun)
+			if err != nil {
+				return epgMappingError(err, "Failed to match channels")
+			}
+
+			return c.JSON(http.StatusOK, result)
+		}, apis.RequireRecordAuth())
+
+		// Map a channel to a guide channel by hand, an empty epg_id for a
+		// channel without guide. The channel's tvg_id becomes epg_id and
+		// playlist syncs keep it.
+		api.PUT("/api/epg/mappings/:channel", openapi.Operation{
+			Summary:     "Map a channel to a guide channel",
+			Scope:       apikeys.ScopeEPG,
+			Description: "An empty epg_id for a channel without guide. The channel's tvg_id becomes epg_id and playlist syncs keep it.",
+			Body: openapi.Fields{
+				"epg_id": "string",
+			},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			data := struct {
+				EpgID string `json:"epg_id"`
+			}{}
+			if err := c.Bind(&data); err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
+			}
+
+			mapping, err := epgService.SetMapping(authRecord.Id, c.PathParam("channel"), strings.TrimSpace(data.EpgID))
+			if err != nil {
+				return epgMappingError(err, "Failed to map channel")
+			}
+
+			return c.JSON(http.StatusOK, mapping)
+		}, apis.RequireRecordAuth())
+
+		// Remove the mapping of a channel, which gets the provider's tvg_id
+		// back
+		api.DELETE("/api/epg/mappings/:channel", openapi.Operation{
+			Summary:     "Remove the guide mapping of a channel",
+			Scope:       apikeys.ScopeEPG,
+			Description: "The channel gets the provider's tvg_id back.",
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			if err := epgService.DeleteMapping(authRecord.Id, c.PathParam("channel")); err != nil {
+				return epgMappingError(err, "Failed to remove mapping")
+			}
+
+			return c.NoContent(http.StatusNoContent)
+		}, apis.RequireRecordAuth())
+
+		// =========================================
+		// Reminder endpoints
+		// =========================================
+
+		// Ask to be notified minutes_before a programme starts (10 by
+		// default). Reminders are listed and deleted through the reminders
+		// collection, and pushed to its realtime subscribers when sent.
+		api.POST("/api/reminders", openapi.Operation{
+			Summary:     "Ask to be notified minutes_before a programme starts (10 by default)",
+			Description: "Reminders are listed and deleted through the reminders collection, and pushed to its realtime subscribers when sent.",
+			Body: openapi.Fields{
+				"program":        "string",
+				"profile":        "string",
+				"minutes_before": "integer",
+			},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			data := struct {
+				Program       string `json:"program"`
+				Profile       string `json:"profile"`
+				MinutesBefore *int   `json:"minutes_before"`
+			}{}
+			if err := c.Bind(&data); err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
+			}
+
+			if data.Profile != "" {
+				profile, err := app.Dao().FindRecordById("profiles", data.Profile)
+				if err != nil || !parental.OwnedBy(profile, authRecord.Id) {
+					return apis.NewNotFoundError("Profile not found", nil)
+				}
+			}
+
+			minutes := reminders.DefaultMinutesBefore
+			if data.MinutesBefore != nil {
+				minutes = *data.MinutesBefore
+			}
+
+			record, err := reminderService.Create(authRecord.Id, data.Program, data.Profile, minutes)
+			switch {
+			case errors.Is(err, reminders.ErrProgramNotFound):
+				return apis.NewNotFoundError("Programme not found", nil)
+			case errors.Is(err, reminders.ErrStarted), errors.Is(err, reminders.ErrMinutes):
+				return apis.NewBadRequestError(err.Error(), nil)
+			case err != nil:
+				return apis.NewBadRequestError("Failed to save reminder", err)
+			}
+
+			return c.JSON(http.StatusOK, record)
+		}, apis.RequireRecordAuth())
+
+		// Turn a reminder into a scheduled recording of its programme, for
+		// the reminder's profile or the one given
+		api.POST("/api/reminders/:id/record", openapi.Operation{
+			Summary:     "Record the programme of a reminder",
+			Description: "Schedules a recording for the reminder's profile or the one given.",
+			Body: openapi.Fields{
+				"profile": "string",
+			},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			data := struct {
+				Profile string `json:"profile"`
+			}{}
+			if err := c.Bind(&data); err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
+			}
+
+			if data.Profile != "" {
+				profile, err := app.Dao().FindRecordById("profiles", data.Profile)
+				if err != nil || !parental.OwnedBy(profile, authRecord.Id) {
+					return apis.NewNotFoundError("Profile not found", nil)
+				}
+			}
+
+			recording, err := reminderService.Record(authRecord.Id, c.PathParam("id"), data.Profile)
+			switch {
+			case errors.Is(err, reminders.ErrNotFound):
+				return apis.NewNotFoundError("Reminder not found", nil)
+			case errors.Is(err, reminders.ErrProgramNotFound):
+				return apis.NewNotFoundError("Programme not found", nil)
+			case errors.Is(err, reminders.ErrNoProfile), errors.Is(err, reminders.ErrNoChannel), errors.Is(err, reminders.ErrEnded):
+				return apis.NewBadRequestError(err.Error(), nil)
+			case err != nil:
+				return apis.NewBadRequestError("Failed to schedule recording", err)
+			}
+
+			return c.JSON(http.StatusOK, recording)
+		}, apis.RequireRecordAuth())
+
+		// =========================================
+		// Maintenance API endpoints (admin only)
+		// =========================================
+
+		// List the runtime settings with the values in effect (admin only)
+		api.GET("/api/settings", openapi.Operation{Summary: "List the runtime settings with the values in effect"}, func(c echo.Context) error {
+			values := make(map[string]interface{})
+			for _, key := range settingsService.Keys() {
+				value, err := settingsService.Current(key)
+				if err != nil {
+					return apis.NewApiError(http.StatusInternalServerError, "Failed to read settings", err)
+				}
+				values[key] = value
+			}
+			return c.JSON(http.StatusOK, values)
+		}, access.RequireAdmin())
+
+		// Get a runtime setting (admin only)
+		api.GET("/api/settings/:key", openapi.Operation{Summary: "Get a runtime setting"}, func(c echo.Context) error {
+			key := c.PathParam("key")
+			value, err := settingsService.Current(key)
+			if errors.Is(err, settings.ErrUnknownKey) {
+				return apis.NewNotFoundError("Unknown setting", nil)
+			}
+			if err != nil {
+				return apis.NewApiError(http.StatusInternalServerError, "Failed to read setting", err)
+			}
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"key":   key,
+				"value": value,
+			})
+		}, access.RequireAdmin())
+
+		// Replace a runtime setting, applied to its service at once (admin only)
+		api.PUT("/api/settings/:key", openapi.Operation{
+			Summary: "Replace a runtime setting, applied to its service at once",
+			Body:    json.RawMessage{},
+		}, func(c echo.Context) error {
+			key := c.PathParam("key")
+			body, err := io.ReadAll(io.LimitReader(c.Request().Body, 1<<20))
+			if err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
+			}
+			audit.SetDetail(c, "key", key)
+
+			if err := settingsService.Update(key, body); err != nil {
+				if errors.Is(err, settings.ErrUnknownKey) {
+					return apis.NewNotFoundError("Unknown setting", nil)
+				}
+				return settingError(err, "Invalid setting")
+			}
+
+			value, _ := settingsService.Current(key)
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"key":   key,
+				"value": value,
+			})
+		}, access.RequireAdmin(), auditService.Middleware(audit.ActionSettingsUpdate))
+
+		// List the user's own settings, with the values in effect for them
+		api.GET("/api/account/settings", openapi.Operation{Summary: "List the user's own settings, with the values in effect for them"}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			values := make(map[string]interface{})
+			for _, key := range settingsService.UserKeys() {
+				value, err := settingsService.CurrentFor(authRecord.Id, key)
+				if err != nil {
+					return apis.NewApiError(http.StatusInternalServerError, "Failed to read settings", err)
+				}
+				values[key] = value
+			}
+			return c.JSON(http.StatusOK, values)
+		}, apis.RequireRecordAuth())
+
+		// Get one of the user's own settings
+		api.GET("/api/account/settings/:key", openapi.Operation{Summary: "Get one of the user's own settings"}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			key := c.PathParam("key")
+			value, err := settingsService.CurrentFor(authRecord.Id, key)
+			if errors.Is(err, settings.ErrUnknownKey) {
+				return apis.NewNotFoundError("Unknown setting", nil)
+			}
+			if err != nil {
+				return apis.NewApiError(http.StatusInternalServerError, "Failed to read setting", err)
+			}
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"key":   key,
+				"value": value,
+			})
+		}, apis.RequireRecordAuth())
+
+		// Replace one of the user's own settings. Fields left out keep the
+		// global value.
+		api.PUT("/api/account/settings/:key", openapi.Operation{
+			Summary:     "Replace one of the user's own settings",
+			Description: "Fields left out keep the global value.",
+			Body:        json.RawMessage{},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			key := c.PathParam("key")
+			body, err := io.ReadAll(io.LimitReader(c.Request().Body, 1<<20))
+			if err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
+			}
+
+			if err := settingsService.UpdateFor(authRecord.Id, key, body); err != nil {
+				if errors.Is(err, settings.ErrUnknownKey) {
+					return apis.NewNotFoundError("Unknown setting", nil)
+				}
+				return settingError(err, "Invalid setting")
+			}
+
+			value, _ := settingsService.CurrentFor(authRecord.Id, key)
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"key":   key,
+				"value": value,
+			})
+		}, apis.RequireRecordAuth())
+
+		// Reset one of the user's own settings to the global value
+		api.DELETE("/api/account/settings/:key", openapi.Operation{Summary: "Reset one of the user's own settings to the global value"}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			key := c.PathParam("key")
+			if _, err := settingsService.CurrentFor(authRecord.Id, key); errors.Is(err, settings.ErrUnknownKey) {
+				return apis.NewNotFoundError("Unknown setting", nil)
+			}
+			if err := settingsService.ResetFor(authRecord.Id, key); err != nil {
+				return apis.NewApiError(http.StatusInternalServerError, "Failed to reset setting", err)
+			}
+
+			value, _ := settingsService.CurrentFor(authRecord.Id, key)
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"key":   key,
+				"value": value,
+			})
+		}, apis.RequireRecordAuth())
+
+		// Recorder output directory, retention and default mode
+		api.GET("/api/admin/recorder/config", openapi.Operation{
+			Summary:  "Recorder output directory, retention and default mode",
+			Response: recorder.Config{},
+		}, func(c echo.Context) error {
+			return c.JSON(http.StatusOK, recorderService.Config())
+		}, access.RequireAdmin())
+
+		// Update the recorder configuration (persist to database). A new
+		// output directory moves the recorded files there.
+		api.PUT("/api/admin/recorder/config", openapi.Operation{
+			Summary:     "Update the recorder configuration (persist to database)",
+			Description: "A new output directory moves the recorded files there.",
+			Body:        recorder.Config{},
+			Response:    recorder.Config{},
+		}, func(c echo.Context) error {
+			// Fields left out keep their value
+			config := recorderService.Config()
+			if err := c.Bind(&config); err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
+			}
+			audit.SetDetail(c, "key", "recorder_config")
+
+			if err := settingsService.Set("recorder_config", config); err != nil {
+				if errors.Is(err, recorder.ErrRecordingsActive) {
+					return apis.NewApiError(http.StatusConflict, err.Error(), nil)
+				}
+				return settingError(err, "Invalid recorder configuration")
+			}
+
+			return c.JSON(http.StatusOK, recorderService.Config())
+		}, access.RequireAdmin(), auditService.Middleware(audit.ActionSettingsUpdate))
+
+		// Thumbnail cache TTL, size, quality and capture timeout
+		api.GET("/api/admin/thumbnails/config", openapi.Operation{
+			Summary:  "Thumbnail cache TTL, size, quality and capture timeout",
+			Response: thumbnail.RuntimeConfig{},
+		}, func(c echo.Context) error {
+			return c.JSON(http.StatusOK, thumbnailService.Config())
+		}, access.RequireAdmin())
+
+		// Update the thumbnail configuration (persist to database)
+		api.PUT("/api/admin/thumbnails/config", openapi.Operation{
+			Summary:  "Update the thumbnail configuration (persist to database)",
+			Body:     thumbnail.RuntimeConfig{},
+			Response: thumbnail.RuntimeConfig{},
+		}, func(c echo.Context) error {
+			config := thumbnailService.Config()
+			if err := c.Bind(&config); err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
+			}
+			audit.SetDetail(c, "key", "thumbnail_config")
+
+			if err := settingsService.Set("thumbnail_config", config); err != nil {
+				return settingError(err, "Invalid thumbnail configuration")
+			}
+
+			return c.JSON(http.StatusOK, thumbnailService.Config())
+		}, access.RequireAdmin(), auditService.Middleware(audit.ActionSettingsUpdate))
+
+		// List maintenance tasks with their configuration and schedule
+		api.GET("/api/admin/maintenance/tasks", openapi.Operation{Summary: "List maintenance tasks with their configuration and schedule"}, func(c echo.Context) error {
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"tasks": maintenanceScheduler.Tasks(),
+			})
+		}, access.RequireAdmin())
+
+		// Update maintenance task configuration (persist to database)
+		api.PUT("/api/admin/maintenance/config", openapi.Operation{
+			Summary: "Update maintenance task configuration (persist to database)",
+			Body:    map[string]maintenance.TaskConfig{},
+		}, func(c echo.Context) error {
+			var data map[string]maintenance.TaskConfig
+			if err := c.Bind(&data); err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
+			}
+
+			// Tasks left out keep their configuration
+			config := maintenanceScheduler.Config()
+			for name, task := range data {
+				config[name] = task
+			}
+			if err := settingsService.Set("maintenance_config", config); err != nil {
+				return settingError(err, "Invalid maintenance configuration")
+			}
+
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"success": true,
+				"tasks":   maintenanceScheduler.Tasks(),
+			})
+		}, access.RequireAdmin())
+
+		// Run a maintenance task now
+		api.POST("/api/admin/maintenance/tasks/:name/run", openapi.Operation{Summary: "Run a maintenance task now"}, func(c echo.Context) error {
+			job, err := maintenanceScheduler.RunNow(c.PathParam("name"), "")
+			if err != nil {
+				return apis.NewBadRequestError("Failed to queue maintenance task", err)
+			}
+
+			return c.JSON(http.StatusOK, job)
+		}, access.RequireAdmin())
+
+		// List past and queued maintenance runs, newest first
+		api.GET("/api/admin/maintenance/runs", openapi.Operation{
+			Summary: "List past and queued maintenance runs, newest first",
+			Query:   []openapi.Param{{Name: "limit"}, {Name: "task"}},
+		}, func(c echo.Context) error {
+			limit, _ := strconv.Atoi(c.QueryParam("limit"))
+			if limit <= 0 || limit > 200 {
+				limit = 50
+			}
+
+			runs := maintenanceScheduler.History(c.QueryParam("task"), limit)
+
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"runs":  runs,
+				"count": len(runs),
+			})
+		}, access.RequireAdmin())
+
+		// =========================================
+		// Playlist API endpoints
+		// =========================================
+
+		// Import/sync a playlist from its URL as a background job
+		api.POST("/api/playlists/:id/sync", openapi.Operation{
+			Summary: "Import/sync a playlist from its URL as a background job",
+			Body: openapi.Fields{
+				"prune": "boolean",
+			},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			playlistRecord, err := app.Dao().FindRecordById("playlists", c.PathParam("id"))
+			if err != nil || !recordOwnedBy(playlistRecord, "user", authRecord.Id) {
+				return apis.NewNotFoundError("Playlist not found", err)
+			}
+
+			if playlistRecord.GetString("url") == "" {
+				return apis.NewBadRequestError("Playlist has no URL to sync from", nil)
+			}
+
+			data := struct {
+				Prune bool `json:"prune"`
+			}{}
+			c.Bind(&data)
+
+			// A sync already queued or running covers this one
+			if job := playlistSyncJob(authRecord.Id, playlistRecord.Id); job != nil && !job.Finished() {
+				return c.JSON(http.StatusOK, job)
+			}
+
+			job, err := jobManager.Enqueue("playlist.import", authRecord.Id, map[string]interface{}{
+				"playlist_id": playlistRecord.Id,
+				"prune":       data.Prune,
+			})
+			if err != nil {
+				return apis.NewBadRequestError("Failed to queue playlist sync", err)
+			}
+			audit.SetDetail(c, "job_id", job.ID)
+			audit.SetDetail(c, "prune", data.Prune)
+
+			return c.JSON(http.StatusOK, job)
+		}, apis.RequireRecordAuth(), auditService.Middleware(audit.ActionPlaylistImport))
+
+		// Progress of the playlist's current or latest sync, and the last
+		// finished one with what it changed
+		api.GET("/api/playlists/:id/sync-status", openapi.Operation{
+			Summary:     "Sync status of a playlist",
+			Description: "Progress of the current or latest sync, and the last finished one with what it changed.",
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			playlistRecord, err := app.Dao().FindRecordById("playlists", c.PathParam("id"))
+			if err != nil || !recordOwnedBy(playlistRecord, "user", authRecord.Id) {
+				return apis.NewNotFoundError("Playlist not found", err)
+			}
+
+			var lastSynced interface{}
+			if synced := playlistRecord.GetDateTime("last_synced"); !synced.IsZero() {
+				lastSynced = synced
+			}
+
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"playlist_id": playlistRecord.Id,
+				"last_synced": lastSynced,
+				"job":         playlistSyncJob(authRecord.Id, playlistRecord.Id),
+				"last_sync":   playlist.LastSync(app, playlistRecord.Id),
+			})
+		}, apis.RequireRecordAuth())
+
+		// List channels like the channels collection does, with what they
+		// air, their thumbnail and their health joined in
+		api.GET("/api/channels", openapi.Operation{
+			Summary:     "List channels with what they air, their thumbnail and their health",
+			Scope:       apikeys.ScopeEPG,
+			Description: "Takes the page, perPage, sort, filter and expand parameters of the channels collection, and joins the extras listed in with: now_next, thumbnail_url and health. size and format pick the thumbnail variant.",
+			Query:       []openapi.Param{{Name: "with"}, {Name: "page"}, {Name: "perPage"}, {Name: "sort"}, {Name: "filter"}, {Name: "expand"}, {Name: "size"}, {Name: "format"}},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			extras, err := channelExtras(c.QueryParam("with"))
+			if err != nil {
+				return apis.NewBadRequestError(err.Error(), nil)
+			}
+			variant, err := thumbnailService.ParseVariant(c.QueryParam("size"), c.QueryParam("format"), "")
+			if err != nil {
+				return apis.NewBadRequestError(err.Error(), nil)
+			}
+
+			collection, err := app.Dao().FindCollectionByNameOrId("channels")
+			if err != nil || collection.ListRule == nil {
+				return apis.NewNotFoundError("", err)
+			}
+
+			// The same checks as the collection's list: users can't filter
+			// or sort by other collections or the request
+			query := c.QueryParams()
+			for _, param := range []string{pbsearch.FilterQueryParam, pbsearch.SortQueryParam} {
+				if value := query.Get(param); strings.Contains(value, "@collection.") || strings.Contains(value, "@request.") {
+					return apis.NewForbiddenError("Only admins can filter by @collection and @request fields", nil)
+				}
+			}
+			if len(query.Encode()) > 2048 {
+				return apis.NewBadRequestError("Query string is too large", nil)
+			}
+
+			// Channels hidden from the active profile are left out by the
+			// query, so pages stay full
+			listQuery := app.Dao().RecordQuery(collection)
+			if filter := parentalService.ListFilter(c, collection.Name); filter != nil {
+				listQuery.AndWhere(filter)
+			}
+			provider := pbsearch.NewProvider(resolvers.NewRecordFieldResolver(app.Dao(), collection, apis.RequestInfo(c), false)).
+				Query(listQuery)
+			provider.AddFilter(pbsearch.FilterData(*collection.ListRule))
+
+			records := []*models.Record{}
+			result, err := provider.ParseAndExec(query.Encode(), &records)
+			if err != nil {
+				return apis.NewBadRequestError("Invalid channel list parameters", err)
+			}
+
+			// Hooks of the collection's list apply: groups and URLs the
+			// viewer may see
+			event := new(core.RecordsListEvent)
+			event.HttpContext = c
+			event.Collection = collection
+			event.Records = records
+			event.Result = result
+			return app.OnRecordsListRequest().Trigger(event, func(e *core.RecordsListEvent) error {
+				if e.HttpContext.Response().Committed {
+					return nil
+				}
+				if err := apis.EnrichRecords(e.HttpContext, app.Dao(), e.Records); err != nil {
+					return apis.NewBadRequestError("Failed to expand channels", err)
+				}
+				if err := enrichChannels(app, e.HttpContext, authRecord.Id, e.Records, extras, variant); err != nil {
+					return apis.NewBadRequestError("Failed to load channel extras", err)
+				}
+				e.Result.Items = e.Records
+				return e.HttpContext.JSON(http.StatusOK, e.Result)
+			})
+		}, apis.RequireRecordAuth())
+
+		// Enable, disable, regroup, reorder or delete many channels at once
+		api.POST("/api/channels/bulk", openapi.Operation{
+			Summary: "Enable, disable, regroup, reorder or delete many channels at once",
+			Body:    playlist.BulkOperation{},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			var op playlist.BulkOperation
+			if err := c.Bind(&op); err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
+			}
+			audit.SetDetail(c, "action", op.Action)
+			audit.SetDetail(c, "channels", len(op.IDs))
+
+			result, err := playlist.Bulk(app, authRecord.Id, op)
+			var missing *playlist.MissingChannelsError
+			switch {
+			case errors.As(err, &missing):
+				return apis.NewNotFoundError(missing.Error(), nil)
+			case errors.Is(err, playlist.ErrBulkAction), errors.Is(err, playlist.ErrBulkEmpty),
+				errors.Is(err, playlist.ErrBulkTooLarge), errors.Is(err, playlist.ErrBulkGroup):
+				return apis.NewBadRequestError(err.Error(), nil)
+			case err != nil:
+				return apis.NewBadRequestError("Failed to update channels", err)
+			}
+			audit.SetDetail(c, "affected", result.Affected)
+
+			return c.JSON(http.StatusOK, result)
+		}, apis.RequireRecordAuth(), auditService.Middleware(audit.ActionChannelBulk))
+
+		// =========================================
+		// Search endpoints
+		// =========================================
+
+		// Search channels and programmes of the guide. ?type= is all,
+		// channels or programs; ?page= and ?perPage= paginate each list.
+		api.GET("/api/search", openapi.Operation{
+			Summary:     "Search channels and programmes of the guide",
+			Scope:       apikeys.ScopeEPG,
+			Description: "?type= is all, channels or programs; ?page= and ?perPage= paginate each list.",
+			Query:       []openapi.Param{{Name: "page"}, {Name: "perPage"}, {Name: "q", Required: true}, {Name: "type"}},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			page, _ := strconv.Atoi(c.QueryParam("page"))
+			perPage, _ := strconv.Atoi(c.QueryParam("perPage"))
+
+			query := search.Query{
+				User:    authRecord.Id,
+				Text:    c.QueryParam("q"),
+				Type:    c.QueryParam("type"),
+				Page:    page,
+				PerPage: perPage,
+			}
+			if restrictions := parentalService.ActiveRestrictions(c); restrictions != nil {
+				query.Hidden = restrictions.BlocksChannel
+			}
+
+			result, err := searchService.Search(query)
+			switch {
+			case errors.Is(err, search.ErrQuery), errors.Is(err, search.ErrType):
+				return apis.NewBadRequestError(err.Error(), nil)
+			case err != nil:
+				return apis.NewBadRequestError("Failed to search", err)
+			}
+
+			if result.Channels != nil {
+				streamService.ApplyChannelPolicy(c, result.Channels.Items...)
+			}
+			if result.Programs != nil {
+				for _, hit := range result.Programs.Items {
+					if hit.Channel != nil {
+						streamService.ApplyChannelPolicy(c, hit.Channel)
+					}
+				}
+			}
+
+			return c.JSON(http.StatusOK, result)
+		}, apis.RequireRecordAuth())
+
+		// =========================================
+		// Channel deduplication endpoints
+		// =========================================
+
+		// Channels of the user's playlists that look like the same channel
+		api.GET("/api/channels/duplicates", openapi.Operation{
+			Summary: "Channels of the user's playlists that look like the same channel",
+			Query:   []openapi.Param{{Name: "playlist"}},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
 			}
 
-			// Validate the code
-			valid := totp.Validate(data.Code, secret)
-			if !valid {
-				return apis.NewBadRequestError("Invalid TOTP code", nil)
+			groups, err := dedupeService.Analyze(authRecord.Id, c.QueryParam("playlist"))
+			if err != nil {
+				return apis.NewBadRequestError("Failed to analyze channels", err)
 			}
 
-			// If this was a pending secret, activate it
-			if authRecord.GetString("totp_secret_pending") != "" {
-				authRecord.Set("totp_secret", secret)
-				authRecord.Set("totp_secret_pending", "")
-				authRecord.Set("totp_enabled", true)
-				authRecord.Set("totp_verified_at", time.Now().Format(time.RFC3339))
-				if err := app.Dao().SaveRecord(authRecord); err != nil {
-					return apis.NewBadRequestError("Failed to enable TOTP", err)
+			for _, group := range groups {
+				for _, member := range group.Channels {
+					streamService.ApplyChannelPolicy(c, member.Channel)
 				}
 			}
 
 			return c.JSON(http.StatusOK, map[string]interface{}{
-				"verified": true,
-				"message":  "Two-factor authentication enabled successfully",
+				"groups": groups,
+				"total":  len(groups),
 			})
 		}, apis.RequireRecordAuth())
 
-		// TOTP Validate endpoint - validates code during login
-		e.Router.POST("/api/auth/totp/validate", func(c echo.Context) error {
-			data := struct {
-				UserId string `json:"userId"`
-				Code   string `json:"code"`
-			}{}
-			if err := c.Bind(&data); err != nil {
-				return apis.NewBadRequestError("Invalid request body", err)
-			}
-
-			// Find user
-			record, err := app.Dao().FindRecordById("users", data.UserId)
-			if err != nil {
-				return apis.NewNotFoundError("User not found", err)
-			}
-
-			secret := record.GetString("totp_secret")
-			if secret == "" {
-				return apis.NewBadRequestError("TOTP not configured for this user", nil)
+		// Sources of a channel by priority, with their health
+		api.GET("/api/channels/:id/sources", openapi.Operation{Summary: "Sources of a channel by priority, with their health"}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
 			}
 
-			// Validate the code
-			valid := totp.Validate(data.Code, secret)
-			if !valid {
-				return apis.NewBadRequestError("Invalid TOTP code", nil)
+			channel, err := app.Dao().FindRecordById("channels", c.PathParam("id"))
+			if err != nil || !slices.Contains(channelOwners(app, channel), authRecord.Id) {
+				return apis.NewNotFoundError("Channel not found", nil)
 			}
 
-			// Generate auth token
-			token, err := tokens.NewRecordAuthToken(app, record)
-			if err != nil {
-				return apis.NewBadRequestError("Failed to generate token", err)
+			sources := streamService.Sources(channel)
+			current := streamService.Source(channel)
+			result := make([]map[string]interface{}, len(sources))
+			for i, source := range sources {
+				streamService.ApplyChannelPolicy(c, source)
+				_, down := streamService.IsDown(source.Id)
+				result[i] = map[string]interface{}{
+					"channel": source,
+					"quality": dedupe.Quality(source.GetString("name")),
+					"down":    down,
+					"current": source.Id == current.Id,
+				}
 			}
 
 			return c.JSON(http.StatusOK, map[string]interface{}{
-				"token":  token,
-				"record": record,
+				"sources": result,
 			})
-		})
+		}, apis.RequireRecordAuth())
 
-		// TOTP Disable endpoint
-		e.Router.POST("/api/auth/totp/disable", func(c echo.Context) error {
+		// Merge channels into this one as its sources, in priority order
+		api.POST("/api/channels/:id/merge", openapi.Operation{
+			Summary: "Merge channels into this one as its sources, in priority order",
+			Body: openapi.Fields{
+				"sources": "[]string",
+			},
+		}, func(c echo.Context) error {
 			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
 			if authRecord == nil {
 				return apis.NewUnauthorizedError("Authentication required", nil)
 			}
 
 			data := struct {
-				Code     string `json:"code"`
-				Password string `json:"password"`
+				Sources []string `json:"sources"`
 			}{}
 			if err := c.Bind(&data); err != nil {
 				return apis.NewBadRequestError("Invalid request body", err)
 			}
 
-			// Verify password
-			if !authRecord.ValidatePassword(data.Password) {
-				return apis.NewBadRequestError("Invalid password", nil)
+			sources, err := dedupeService.Merge(authRecord.Id, c.PathParam("id"), data.Sources)
+			switch {
+			case errors.Is(err, dedupe.ErrNotFound):
+				return apis.NewNotFoundError("Channel not found", nil)
+			case errors.Is(err, dedupe.ErrMergedSource), errors.Is(err, dedupe.ErrNoSources):
+				return apis.NewBadRequestError(err.Error(), nil)
+			case err != nil:
+				return apis.NewBadRequestError("Failed to merge channels", err)
 			}
 
-			// Verify TOTP code
-			secret := authRecord.GetString("totp_secret")
-			if secret != "" && !totp.Validate(data.Code, secret) {
-				return apis.NewBadRequestError("Invalid TOTP code", nil)
+			streamService.ApplyChannelPolicy(c, sources...)
+
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"channel": sources[0],
+				"sources": sources,
+			})
+		}, apis.RequireRecordAuth())
+
+		// Detach a source from its channel, or all sources of a channel
+		api.POST("/api/channels/:id/unmerge", openapi.Operation{Summary: "Detach a source from its channel, or all sources of a channel"}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
 			}
 
-			// Disable TOTP
-			authRecord.Set("totp_secret", "")
-			authRecord.Set("totp_enabled", false)
-			authRecord.Set("totp_verified_at", "")
-			if err := app.Dao().SaveRecord(authRecord); err != nil {
-				return apis.NewBadRequestError("Failed to disable TOTP", err)
+			detached, err := dedupeService.Unmerge(authRecord.Id, c.PathParam("id"))
+			switch {
+			case errors.Is(err, dedupe.ErrNotFound):
+				return apis.NewNotFoundError("Channel not found", nil)
+			case err != nil:
+				return apis.NewBadRequestError("Failed to unmerge channels", err)
 			}
 
+			streamService.ApplyChannelPolicy(c, detached...)
+
 			return c.JSON(http.StatusOK, map[string]interface{}{
-				"message": "Two-factor authentication disabled",
+				"detached": detached,
 			})
 		}, apis.RequireRecordAuth())
 
-		// Check TOTP status endpoint
-		e.Router.GET("/api/auth/totp/status", func(c echo.Context) error {
+		// =========================================
+		// Channel group endpoints
+		// =========================================
+
+		// List the groups of the user's channel lists with their number of
+		// channels: the user's own groups, then the provider groups they
+		// leave as is
+		api.GET("/api/groups", openapi.Operation{
+			Summary:     "List the groups of the user's channel lists",
+			Description: "The user's own groups first, then the provider groups they leave as is, each with its number of active channels.",
+		}, func(c echo.Context) error {
 			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
 			if authRecord == nil {
 				return apis.NewUnauthorizedError("Authentication required", nil)
 			}
 
+			list, err := groupService.List(authRecord.Id)
+			if err != nil {
+				return apis.NewBadRequestError("Failed to list groups", err)
+			}
+
 			return c.JSON(http.StatusOK, map[string]interface{}{
-				"enabled":    authRecord.GetBool("totp_enabled"),
-				"verifiedAt": authRecord.GetString("totp_verified_at"),
+				"items": list,
 			})
 		}, apis.RequireRecordAuth())
 
-		// Serve static files for recordings
-		e.Router.GET("/recordings/*", func(c echo.Context) error {
+		// Create a group listing provider groups under its name, channels
+		// picked from any group, or both
+		api.POST("/api/groups", openapi.Operation{
+			Summary:     "Create a channel group",
+			Description: "The group lists the channels of provider_groups under its name instead of theirs, and the channels picked from any group.",
+			Body:        groups.Input{},
+			Response:    groups.Group{},
+		}, func(c echo.Context) error {
 			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
 			if authRecord == nil {
 				return apis.NewUnauthorizedError("Authentication required", nil)
 			}
 
-			path := strings.TrimPrefix(c.Request().URL.Path, "/recordings/")
-			filePath := "./pb_data/recordings/" + path
-			return c.File(filePath)
-		}, apis.RequireRecordAuth())
+			var input groups.Input
+			if err := c.Bind(&input); err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
+			}
 
-		// Recording API endpoints
+			group, err := groupService.Create(authRecord.Id, input)
+			if err != nil {
+				return groupError(err, "Failed to create group")
+			}
 
-		// Start recording
-		e.Router.POST("/api/recorder/start", func(c echo.Context) error {
+			return c.JSON(http.StatusOK, group)
+		}, apis.RequireRecordAuth())
+
+		// Replace the name, provider groups and channels of a group
+		api.PUT("/api/groups/:id", openapi.Operation{
+			Summary:  "Replace the name, provider groups and channels of a group",
+			Body:     groups.Input{},
+			Response: groups.Group{},
+		}, func(c echo.Context) error {
 			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
 			if authRecord == nil {
 				return apis.NewUnauthorizedError("Authentication required", nil)
 			}
 
-			data := struct {
-				RecordingID string `json:"recording_id"`
-				ChannelURL  string `json:"channel_url"`
-				Title       string `json:"title"`
-			}{}
-			if err := c.Bind(&data); err != nil {
+			var input groups.Input
+			if err := c.Bind(&input); err != nil {
 				return apis.NewBadRequestError("Invalid request body", err)
 			}
 
-			if data.RecordingID == "" || data.ChannelURL == "" || data.Title == "" {
-				return apis.NewBadRequestError("Missing required fields", nil)
+			group, err := groupService.Update(authRecord.Id, c.PathParam("id"), input)
+			if err != nil {
+				return groupError(err, "Failed to update group")
 			}
 
-			rec, err := recorderService.StartRecording(data.RecordingID, data.ChannelURL, data.Title)
-			if err != nil {
-				return apis.NewBadRequestError("Failed to start recording", err)
+			return c.JSON(http.StatusOK, group)
+		}, apis.RequireRecordAuth())
+
+		// Delete a group, its provider groups are listed under their own
+		// name again
+		api.DELETE("/api/groups/:id", openapi.Operation{
+			Summary:     "Delete a channel group",
+			Description: "Its provider groups are listed under their own name again.",
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			if err := groupService.Delete(authRecord.Id, c.PathParam("id")); err != nil {
+				return groupError(err, "Failed to delete group")
 			}
 
-			return c.JSON(http.StatusOK, rec.Info())
+			return c.NoContent(http.StatusNoContent)
 		}, apis.RequireRecordAuth())
 
-		// Pause recording
-		e.Router.POST("/api/recorder/pause", func(c echo.Context) error {
+		// Rename a group of the user or a provider group. A provider group
+		// renamed to the name of a group of the user joins it.
+		api.POST("/api/groups/rename", openapi.Operation{
+			Summary:     "Rename a group of the user or a provider group",
+			Description: "A provider group renamed to the name of a group of the user joins it.",
+			Body: openapi.Fields{
+				"from": "string!",
+				"to":   "string!",
+			},
+			Response: groups.Group{},
+		}, func(c echo.Context) error {
 			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
 			if authRecord == nil {
 				return apis.NewUnauthorizedError("Authentication required", nil)
 			}
 
 			data := struct {
-				RecordingID string `json:"recording_id"`
+				From string `json:"from"`
+				To   string `json:"to"`
 			}{}
 			if err := c.Bind(&data); err != nil {
 				return apis.NewBadRequestError("Invalid request body", err)
 			}
 
-			if err := recorderService.PauseRecording(data.RecordingID); err != nil {
-				return apis.NewBadRequestError("Failed to pause recording", err)
+			group, err := groupService.Rename(authRecord.Id, data.From, data.To)
+			if err != nil {
+				return groupError(err, "Failed to rename group")
 			}
 
-			rec, _ := recorderService.GetRecording(data.RecordingID)
-			return c.JSON(http.StatusOK, rec.Info())
+			return c.JSON(http.StatusOK, group)
 		}, apis.RequireRecordAuth())
 
-		// Resume recording
-		e.Router.POST("/api/recorder/resume", func(c echo.Context) error {
+		// List several groups under one: the group of the user named into,
+		// or a new one. Groups of the user merged into it are removed.
+		api.POST("/api/groups/merge", openapi.Operation{
+			Summary:     "List several groups under one",
+			Description: "Merges into the group of the user named into, or a new one. Groups of the user merged into it are removed.",
+			Body: openapi.Fields{
+				"into":   "string!",
+				"groups": "[]string",
+			},
+			Response: groups.Group{},
+		}, func(c echo.Context) error {
 			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
 			if authRecord == nil {
 				return apis.NewUnauthorizedError("Authentication required", nil)
 			}
 
 			data := struct {
-				RecordingID string `json:"recording_id"`
+				Into   string   `json:"into"`
+				Groups []string `json:"groups"`
 			}{}
 			if err := c.Bind(&data); err != nil {
 				return apis.NewBadRequestError("Invalid request body", err)
 			}
 
-			if err := recorderService.ResumeRecording(data.RecordingID); err != nil {
-				return apis.NewBadRequestError("Failed to resume recording", err)
+			group, err := groupService.Merge(authRecord.Id, data.Into, data.Groups)
+			if err != nil {
+				return groupError(err, "Failed to merge groups")
 			}
 
-			rec, _ := recorderService.GetRecording(data.RecordingID)
-			return c.JSON(http.StatusOK, rec.Info())
+			return c.JSON(http.StatusOK, group)
 		}, apis.RequireRecordAuth())
 
-		// Stop recording
-		e.Router.POST("/api/recorder/stop", func(c echo.Context) error {
+		// =========================================
+		// Subtitle API endpoints
+		// =========================================
+
+		// Start subtitle generation session
+		api.POST("/api/subtitle/start", openapi.Operation{
+			Summary: "Start subtitle generation session",
+			Body: openapi.Fields{
+				"session_id":   "string!",
+				"channel_id":   "string!",
+				"stream_url":   "string!",
+				"language":     "string!",
+				"target_lang":  "string",
+				"target_langs": "[]string",
+				"profile_id":   "string",
+				"filters":      "object",
+			},
+		}, func(c echo.Context) error {
 			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
 			if authRecord == nil {
 				return apis.NewUnauthorizedError("Authentication required", nil)
 			}
 
 			data := struct {
-				RecordingID string `json:"recording_id"`
+				SessionID   string               `json:"session_id"`
+				ChannelID   string               `json:"channel_id"`
+				StreamURL   string               `json:"stream_url"`
+				Language    string               `json:"language"`
+				TargetLang  string               `json:"target_lang"`
+				TargetLangs []string             `json:"target_langs"`
+				ProfileID   string               `json:"profile_id"`
+				Filters     subtitle.TextFilters `json:"filters"`
 			}{}
 			if err := c.Bind(&data); err != nil {
 				return apis.NewBadRequestError("Invalid request body", err)
 			}
 
-			rec, err := recorderService.StopRecording(data.RecordingID)
+			if data.SessionID == "" || data.ChannelID == "" || data.StreamURL == "" {
+				return apis.NewBadRequestError("Missing required fields", nil)
+			}
+
+			// The filters of the watching profile replace those of the request,
+			// and kids profiles always get masked captions
+			if data.ProfileID != "" {
+				profile, err := app.Dao().FindRecordById("profiles", data.ProfileID)
+				if err != nil || !parental.OwnedBy(profile, authRecord.Id) {
+					return apis.NewNotFoundError("Profile not found", nil)
+				}
+				data.Filters = subtitle.TextFilters{}
+				if err := profile.UnmarshalJSONField("subtitle_filters", &data.Filters); err != nil {
+					logging.FromEcho(c).Warn("invalid profile subtitle filters", "profile_id", profile.Id, "error", err)
+				}
+				if profile.GetBool("is_kids") {
+					data.Filters.MaskProfanity = true
+				}
+			}
+			if err := data.Filters.Normalize(); err != nil {
+				return apis.NewBadRequestError("Invalid subtitle filters", err)
+			}
+
+			// Default language to auto-detect
+			if data.Language == "" {
+				data.Language = subtitle.LanguageAuto
+			}
+
+			streamURL, err := streamService.ResolveSourceURL(data.StreamURL)
 			if err != nil {
-				return apis.NewBadRequestError("Failed to stop recording", err)
+				return apis.NewBadRequestError("Failed to resolve stream URL", err)
 			}
 
-			return c.JSON(http.StatusOK, rec.Info())
-		}, apis.RequireRecordAuth())
+			// target_lang is kept for older clients, it comes first when both are set
+			targetLangs := data.TargetLangs
+			if data.TargetLang != "" {
+				targetLangs = append([]string{data.TargetLang}, targetLangs...)
+			}
 
-		// Get recording status
-		e.Router.GET("/api/recorder/status/:id", func(c echo.Context) error {
-			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
-			if authRecord == nil {
-				return apis.NewUnauthorizedError("Authentication required", nil)
+			logging.FromEcho(c).Info("starting subtitle session", "session_id", data.SessionID, "language", data.Language, "target_langs", targetLangs)
+
+			if _, err := subtitleService.StartSession(c.Request().Context(), data.SessionID, authRecord.Id, data.ChannelID, streamURL, data.Language, targetLangs, data.Filters); err != nil {
+				return apis.NewBadRequestError("Failed to start subtitle session", err)
 			}
 
-			id := c.PathParam("id")
-			rec, exists := recorderService.GetRecording(id)
+			// As the viewer sees it, when they joined the session of another
+			info, exists := subtitleService.GetSession(data.SessionID)
 			if !exists {
-				return apis.NewNotFoundError("Recording not found", nil)
+				return apis.NewNotFoundError("Session not found", nil)
 			}
 
-			return c.JSON(http.StatusOK, rec.Info())
-		}, apis.RequireRecordAuth())
-
-		// Get all active recordings
-		e.Router.GET("/api/recorder/active", func(c echo.Context) error {
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"session_id":   info.ID,
+				"status":       info.Status,
+				"language":     info.Language,
+				"target_lang":  info.TargetLang,
+				"target_langs": info.TargetLangs,
+				"filters":      info.Filters,
+				"viewers":      info.Viewers,
+			})
+		}, apis.RequireRecordAuth(), rateLimiter.Middleware(ratelimit.SubtitleSessions))
+
+		// Render a short clip of a channel with a caption burned in, so users
+		// can check how their subtitle settings look before starting a session
+		api.POST("/api/subtitle/preview", openapi.Operation{
+			Summary:     "Preview subtitle settings",
+			Description: "Renders a short clip of a channel with a caption burned in, to check how subtitle settings look before starting a session.",
+			Body: openapi.Fields{
+				"channel_id": "string",
+				"stream_url": "string",
+				"session_id": "string",
+				"text":       "string",
+				"duration":   "integer",
+				"style":      "object",
+			},
+		}, func(c echo.Context) error {
 			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
 			if authRecord == nil {
 				return apis.NewUnauthorizedError("Authentication required", nil)
 			}
 
-			recs := recorderService.GetAllRecordings()
-			infos := make([]recorder.RecordingInfo, len(recs))
-			for i, rec := range recs {
-				infos[i] = rec.Info()
+			data := struct {
+				ChannelID string                `json:"channel_id"`
+				StreamURL string                `json:"stream_url"`
+				SessionID string                `json:"session_id"`
+				Text      string                `json:"text"`
+				Duration  int                   `json:"duration"` // Seconds
+				Style     subtitle.PreviewStyle `json:"style"`
+			}{}
+			if err := c.Bind(&data); err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
 			}
 
-			return c.JSON(http.StatusOK, infos)
-		}, apis.RequireRecordAuth())
+			if err := data.Style.Normalize(); err != nil {
+				return apis.NewBadRequestError("Invalid subtitle style", err)
+			}
 
-		// List all recorded files
-		e.Router.GET("/api/recorder/files", func(c echo.Context) error {
-			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
-			if authRecord == nil {
-				return apis.NewUnauthorizedError("Authentication required", nil)
+			streamURL := data.StreamURL
+			if streamURL == "" && data.ChannelID != "" {
+				channel, err := app.Dao().FindRecordById("channels", data.ChannelID)
+				if err != nil {
+					return apis.NewNotFoundError("Channel not found", err)
+				}
+				streamURL = streamService.Source(channel).GetString("url")
+			}
+			if streamURL == "" {
+				return apis.NewBadRequestError("channel_id or stream_url is required", nil)
 			}
 
-			recordingsDir := filepath.Join(app.DataDir(), "recordings")
-			files, err := os.ReadDir(recordingsDir)
+			streamURL, err := streamService.ResolveSourceURL(streamURL)
 			if err != nil {
-				if os.IsNotExist(err) {
-					return c.JSON(http.StatusOK, []map[string]interface{}{})
-				}
-				return apis.NewBadRequestError("Failed to read recordings directory", err)
+				return apis.NewBadRequestError("Failed to resolve stream URL", err)
 			}
 
-			var recordings []map[string]interface{}
-			for _, file := range files {
-				if file.IsDir() {
-					continue
-				}
-				info, err := file.Info()
-				if err != nil {
-					continue
-				}
-				recordings = append(recordings, map[string]interface{}{
-					"name":       file.Name(),
-					"size":       info.Size(),
-					"created_at": info.ModTime().Format(time.RFC3339),
-				})
+			clip, err := subtitleService.RenderPreview(c.Request().Context(), streamURL, data.SessionID, data.Text, data.Style, time.Duration(data.Duration)*time.Second)
+			if err != nil {
+				logging.FromEcho(c).Warn("subtitle preview failed", "channel_id", data.ChannelID, "error", err)
+				return apis.NewBadRequestError("Failed to render preview: "+err.Error(), nil)
 			}
 
-			return c.JSON(http.StatusOK, recordings)
+			c.Response().Header().Set("Cache-Control", "no-store")
+			return c.Blob(http.StatusOK, "video/mp4", clip)
 		}, apis.RequireRecordAuth())
 
-		// Delete a recorded file
-		e.Router.DELETE("/api/recorder/files/:filename", func(c echo.Context) error {
+		// Stop subtitle session
+		api.POST("/api/subtitle/stop", openapi.Operation{
+			Summary: "Stop subtitle session",
+			Body: openapi.Fields{
+				"session_id": "string",
+			},
+		}, func(c echo.Context) error {
 			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
 			if authRecord == nil {
 				return apis.NewUnauthorizedError("Authentication required", nil)
 			}
 
-			filename := c.PathParam("filename")
-			// Security: prevent path traversal
-			if strings.Contains(filename, "/") || strings.Contains(filename, "..") {
-				return apis.NewBadRequestError("Invalid filename", nil)
+			data := struct {
+				SessionID string `json:"session_id"`
+			}{}
+			if err := c.Bind(&data); err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
 			}
 
-			filePath := filepath.Join(app.DataDir(), "recordings", filename)
-			if err := os.Remove(filePath); err != nil {
-				if os.IsNotExist(err) {
-					return apis.NewNotFoundError("File not found", nil)
-				}
-				return apis.NewBadRequestError("Failed to delete file", err)
+			if err := subtitleService.StopSession(data.SessionID); err != nil {
+				return apis.NewBadRequestError("Failed to stop session", err)
 			}
 
-			return c.JSON(http.StatusOK, map[string]string{"message": "File deleted"})
+			return c.JSON(http.StatusOK, map[string]string{"message": "Session stopped"})
 		}, apis.RequireRecordAuth())
 
-		// =========================================
-		// Thumbnail API endpoints
-		// =========================================
-
-		// Generate and get thumbnail for a channel
-		e.Router.GET("/api/thumbnail/:channelId", func(c echo.Context) error {
-			channelId := c.PathParam("channelId")
-			streamURL := c.QueryParam("url")
-
-			if streamURL == "" {
-				// Try to get from database
-				authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
-				if authRecord == nil {
-					return apis.NewUnauthorizedError("Authentication required", nil)
-				}
-
-				channel, err := app.Dao().FindRecordById("channels", channelId)
-				if err != nil {
-					return apis.NewNotFoundError("Channel not found", err)
-				}
-
-				streamURL = channel.GetString("url")
+		// Get subtitle session status
+		api.GET("/api/subtitle/session/:id", openapi.Operation{Summary: "Get subtitle session status"}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
 			}
 
-			if streamURL == "" {
-				return apis.NewBadRequestError("Stream URL is required", nil)
+			sessionID := c.PathParam("id")
+			info, exists := subtitleService.GetSession(sessionID)
+			if !exists {
+				return apis.NewNotFoundError("Session not found", nil)
 			}
 
-			// Check for If-Modified-Since header for caching
-			if ifModifiedSince := c.Request().Header.Get("If-Modified-Since"); ifModifiedSince != "" {
-				if path, exists := thumbnailService.GetThumbnailPath(channelId); exists {
-					if info, err := os.Stat(path); err == nil {
-						parsedTime, err := http.ParseTime(ifModifiedSince)
-						if err == nil && !info.ModTime().After(parsedTime) {
-							return c.NoContent(http.StatusNotModified)
-						}
-					}
-				}
+			return c.JSON(http.StatusOK, info)
+		}, apis.RequireRecordAuth())
+
+		// Keep a session alive without polling for subtitles, sessions
+		// nobody polls are stopped after SUBTITLE_IDLE_MINUTES
+		api.POST("/api/subtitle/session/:id/heartbeat", openapi.Operation{
+			Summary:     "Keep a subtitle session alive",
+			Description: "Without polling for subtitles; sessions nobody polls are stopped after SUBTITLE_IDLE_MINUTES.",
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
 			}
 
-			info, err := thumbnailService.GetThumbnail(channelId, streamURL)
+			info, err := subtitleService.Heartbeat(c.PathParam("id"))
 			if err != nil {
-				return apis.NewBadRequestError("Failed to generate thumbnail: "+err.Error(), nil)
+				return apis.NewNotFoundError("Session not found", nil)
 			}
 
-			// Set cache headers
-			c.Response().Header().Set("Cache-Control", "public, max-age=300") // 5 minutes
-			c.Response().Header().Set("Last-Modified", info.GeneratedAt.UTC().Format(http.TimeFormat))
-
-			return c.File(info.FilePath)
-		})
+			return c.JSON(http.StatusOK, info)
+		}, apis.RequireRecordAuth())
 
-		// Get thumbnail if cached (no generation)
-		e.Router.GET("/api/thumbnail/:channelId/cached", func(c echo.Context) error {
-			channelId := c.PathParam("channelId")
+		// Shift the times of the entries a session serves by an offset in
+		// seconds, positive to show subtitles later, or with auto by the
+		// estimated delay of its pipeline
+		api.PATCH("/api/subtitle/session/:id/offset", openapi.Operation{
+			Summary:     "Shift the subtitles of a session",
+			Description: "By an offset in seconds, positive to show subtitles later, or with auto by the estimated delay of its pipeline.",
+			Body: openapi.Fields{
+				"offset": "number",
+				"auto":   "boolean",
+			},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
 
-			path, exists := thumbnailService.GetThumbnailPath(channelId)
-			if !exists {
-				return c.JSON(http.StatusOK, map[string]interface{}{
-					"cached":  false,
-					"message": "No cached thumbnail available",
-				})
+			data := struct {
+				Offset *float64 `json:"offset"`
+				Auto   bool     `json:"auto"`
+			}{}
+			if err := c.Bind(&data); err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
+			}
+			if data.Offset == nil && !data.Auto {
+				return apis.NewBadRequestError("offset or auto is required", nil)
+			}
+			offset := 0.0
+			if data.Offset != nil {
+				offset = *data.Offset
 			}
 
-			c.Response().Header().Set("Cache-Control", "public, max-age=300")
-			return c.File(path)
-		})
+			info, err := subtitleService.SetOffset(c.PathParam("id"), offset, data.Auto)
+			if errors.Is(err, subtitle.ErrSessionNotFound) {
+				return apis.NewNotFoundError("Session not found", nil)
+			}
+			if err != nil {
+				return apis.NewBadRequestError(err.Error(), nil)
+			}
 
-		// Invalidate thumbnail cache for a channel
-		e.Router.DELETE("/api/thumbnail/:channelId", func(c echo.Context) error {
+			return c.JSON(http.StatusOK, info)
+		}, apis.RequireRecordAuth())
+
+		// Timing of a session by stage: audio wait, Whisper, Ollama and the
+		// resulting lag behind live
+		api.GET("/api/subtitle/session/:id/metrics", openapi.Operation{
+			Summary:     "Timing of a subtitle session by stage",
+			Description: "Audio wait, Whisper, Ollama and the resulting lag behind live.",
+		}, func(c echo.Context) error {
 			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
 			if authRecord == nil {
 				return apis.NewUnauthorizedError("Authentication required", nil)
 			}
 
-			channelId := c.PathParam("channelId")
-			thumbnailService.InvalidateThumbnail(channelId)
+			metrics, err := subtitleService.GetSessionMetrics(c.PathParam("id"))
+			if err != nil {
+				return apis.NewNotFoundError("Session not found", nil)
+			}
 
-			return c.JSON(http.StatusOK, map[string]string{"message": "Thumbnail cache invalidated"})
+			return c.JSON(http.StatusOK, metrics)
 		}, apis.RequireRecordAuth())
 
-		// Batch generate thumbnails for multiple channels
-		e.Router.POST("/api/thumbnails/batch", func(c echo.Context) error {
+		// Summary and key points of a session's transcript, optionally of its
+		// last minutes only, written by the Ollama model
+		api.POST("/api/subtitle/session/:id/summarize", openapi.Operation{
+			Summary:     "Summarize a subtitle session",
+			Description: "Summary and key points of its transcript, optionally of its last minutes only, written by the Ollama model.",
+			Body: openapi.Fields{
+				"lang":    "string",
+				"minutes": "integer",
+			},
+		}, func(c echo.Context) error {
 			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
 			if authRecord == nil {
 				return apis.NewUnauthorizedError("Authentication required", nil)
 			}
 
 			data := struct {
-				Channels    map[string]string `json:"channels"` // channelId -> streamURL
-				Concurrency int               `json:"concurrency"`
+				Lang    string `json:"lang"`
+				Minutes int    `json:"minutes"`
 			}{}
 			if err := c.Bind(&data); err != nil {
 				return apis.NewBadRequestError("Invalid request body", err)
 			}
-
-			if len(data.Channels) == 0 {
-				return apis.NewBadRequestError("No channels provided", nil)
-			}
-
-			concurrency := data.Concurrency
-			if concurrency <= 0 || concurrency > 5 {
-				concurrency = 3 // Default to 3 concurrent generations
+			if data.Minutes < 0 {
+				return apis.NewBadRequestError("minutes must be positive", nil)
 			}
 
-			results := thumbnailService.BatchGenerate(data.Channels, concurrency)
-
-			response := make(map[string]interface{})
-			for channelId, info := range results {
-				response[channelId] = map[string]interface{}{
-					"success":      true,
-					"generated_at": info.GeneratedAt,
-					"size":         info.Size,
+			summary, err := subtitleService.Summarize(c.Request().Context(), c.PathParam("id"), data.Lang, data.Minutes)
+			if err != nil {
+				if errors.Is(err, subtitle.ErrEmptyTranscript) {
+					return apis.NewBadRequestError("Nothing to summarize yet", nil)
 				}
-			}
-
-			// Mark failed channels
-			for channelId := range data.Channels {
-				if _, ok := results[channelId]; !ok {
-					response[channelId] = map[string]interface{}{
-						"success": false,
-						"error":   "Failed to generate thumbnail",
-					}
+				if errors.Is(err, subtitle.ErrSessionNotFound) {
+					return apis.NewNotFoundError("Session not found", nil)
 				}
+				return apis.NewApiError(http.StatusBadGateway, "Failed to summarize transcript: "+err.Error(), nil)
 			}
 
-			return c.JSON(http.StatusOK, response)
+			return c.JSON(http.StatusOK, summary)
 		}, apis.RequireRecordAuth())
 
-		// Get thumbnail cache statistics
-		e.Router.GET("/api/thumbnails/stats", func(c echo.Context) error {
+		// Get subtitles (polling endpoint)
+		api.GET("/api/subtitle/session/:id/subtitles", openapi.Operation{
+			Summary: "Get subtitles (polling endpoint)",
+			Query:   []openapi.Param{{Name: "since"}, {Name: "since_revision"}, {Name: "lang"}},
+		}, func(c echo.Context) error {
 			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
 			if authRecord == nil {
 				return apis.NewUnauthorizedError("Authentication required", nil)
 			}
 
-			return c.JSON(http.StatusOK, thumbnailService.GetCacheStats())
-		}, apis.RequireRecordAuth())
-
-		// Get thumbnail URL for a channel (returns URL instead of image)
-		e.Router.GET("/api/thumbnail/:channelId/url", func(c echo.Context) error {
-			channelId := c.PathParam("channelId")
-			streamURL := c.QueryParam("url")
-
-			if streamURL == "" {
-				authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
-				if authRecord == nil {
-					return apis.NewUnauthorizedError("Authentication required", nil)
-				}
+			sessionID := c.PathParam("id")
+			sinceStr := c.QueryParam("since")
+			since := 0
+			if sinceStr != "" {
+				since, _ = strconv.Atoi(sinceStr)
+			}
 
-				channel, err := app.Dao().FindRecordById("channels", channelId)
-				if err != nil {
-					return apis.NewNotFoundError("Channel not found", err)
-				}
-				streamURL = channel.GetString("url")
+			// lang picks one of the session languages, the primary target by default.
+			// since_revision also returns pending entries completed since then.
+			var subtitles []subtitle.SubtitleEntry
+			var err error
+			revision := 0
+			if sinceRevision := c.QueryParam("since_revision"); sinceRevision != "" {
+				revision, _ = strconv.Atoi(sinceRevision)
+				subtitles, revision, err = subtitleService.GetSubtitleUpdates(sessionID, revision, c.QueryParam("lang"))
+			} else {
+				subtitles, err = subtitleService.GetSubtitles(sessionID, since, c.QueryParam("lang"))
+			}
+			if errors.Is(err, subtitle.ErrLanguageNotAvailable) {
+				return apis.NewBadRequestError(err.Error(), nil)
+			}
+			if err != nil {
+				logging.FromEcho(c).Debug("get subtitles failed", "session_id", sessionID, "error", err)
+				return c.JSON(http.StatusOK, map[string]interface{}{
+					"subtitles": []interface{}{},
+					"count":     0,
+				})
 			}
 
-			// Check if cached
-			cacheTTL := 300 // 5 minutes in seconds
-			_, cached := thumbnailService.GetThumbnailPath(channelId)
+			if len(subtitles) > 0 {
+				logging.FromEcho(c).Debug("returning subtitles", "session_id", sessionID, "count", len(subtitles), "since", since)
+			}
 
-			// Generate timestamp for cache busting
-			timestamp := strconv.FormatInt(time.Now().Unix()/int64(cacheTTL)*int64(cacheTTL), 10)
+			// Words of the utterance in progress (vosk engine), not yet an entry
+			partial := ""
+			if info, ok := subtitleService.GetSession(sessionID); ok {
+				partial = info.Partial
+			}
 
 			return c.JSON(http.StatusOK, map[string]interface{}{
-				"url":       fmt.Sprintf("/api/thumbnail/%s?t=%s", channelId, timestamp),
-				"cached":    cached,
-				"stream_url": streamURL,
+				"subtitles": subtitles,
+				"count":     len(subtitles),
+				"partial":   partial,
+				"revision":  revision, // Only with since_revision
 			})
-		})
-
-		// =========================================
-		// Subtitle API endpoints
-		// =========================================
+		}, apis.RequireRecordAuth())
 
-		// Start subtitle generation session
-		e.Router.POST("/api/subtitle/start", func(c echo.Context) error {
+		// Stream a session as Server-Sent Events, for players that can't use
+		// WebSockets: entry events for entries added or completed, status
+		// events when the session changes and partial events for the words
+		// of the utterance in progress, until an end event
+		api.GET("/api/subtitle/session/:id/stream", openapi.Operation{
+			Summary:     "Stream a subtitle session as Server-Sent Events",
+			Description: "For players that can't use WebSockets: entry events for entries added or completed, status events when the session changes and partial events for the words of the utterance in progress, until an end event.",
+			Query:       []openapi.Param{{Name: "lang"}, {Name: "since_revision"}},
+			Produces:    "text/event-stream",
+		}, func(c echo.Context) error {
 			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
 			if authRecord == nil {
 				return apis.NewUnauthorizedError("Authentication required", nil)
 			}
 
-			data := struct {
-				SessionID  string `json:"session_id"`
-				ChannelID  string `json:"channel_id"`
-				StreamURL  string `json:"stream_url"`
-				Language   string `json:"language"`
-				TargetLang string `json:"target_lang"`
-			}{}
-			if err := c.Bind(&data); err != nil {
-				return apis.NewBadRequestError("Invalid request body", err)
+			sessionID := c.PathParam("id")
+			lang := c.QueryParam("lang")
+
+			// Resume after the last entry received, as browsers do on reconnect
+			revision := 0
+			if lastID := c.Request().Header.Get("Last-Event-ID"); lastID != "" {
+				revision, _ = strconv.Atoi(lastID)
+			} else if sinceRevision := c.QueryParam("since_revision"); sinceRevision != "" {
+				revision, _ = strconv.Atoi(sinceRevision)
 			}
 
-			if data.SessionID == "" || data.ChannelID == "" || data.StreamURL == "" {
-				return apis.NewBadRequestError("Missing required fields", nil)
+			if _, exists := subtitleService.GetSession(sessionID); !exists {
+				return apis.NewNotFoundError("Session not found", nil)
 			}
-
-			// Default language to auto-detect
-			if data.Language == "" {
-				data.Language = "en"
+			if _, _, err := subtitleService.GetSubtitleUpdates(sessionID, revision, lang); errors.Is(err, subtitle.ErrLanguageNotAvailable) {
+				return apis.NewBadRequestError(err.Error(), nil)
 			}
 
-			log.Printf("Starting subtitle session: language=%s, target_lang=%s", data.Language, data.TargetLang)
-
-			session, err := subtitleService.StartSession(data.SessionID, data.ChannelID, data.StreamURL, data.Language, data.TargetLang)
-			if err != nil {
-				return apis.NewBadRequestError("Failed to start subtitle session", err)
+			res := c.Response()
+			res.Header().Set(echo.HeaderContentType, "text/event-stream")
+			res.Header().Set(echo.HeaderCacheControl, "no-cache")
+			res.Header().Set(echo.HeaderConnection, "keep-alive")
+			res.Header().Set("X-Accel-Buffering", "no") // Keep nginx from buffering events
+			res.WriteHeader(http.StatusOK)
+			res.Flush()
+
+			send := func(event string, revision int, data interface{}) error {
+				if event == "keepalive" {
+					_, err := fmt.Fprint(res, ": keepalive\n\n")
+					res.Flush()
+					return err
+				}
+				payload, err := json.Marshal(data)
+				if err != nil {
+					return err
+				}
+				if event == "entry" {
+					fmt.Fprintf(res, "id: %d\n", revision)
+				}
+				if _, err := fmt.Fprintf(res, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+					return err
+				}
+				res.Flush()
+				return nil
 			}
 
-			return c.JSON(http.StatusOK, map[string]interface{}{
-				"session_id": session.ID,
-				"status":     session.Status,
-				"language":   session.Language,
-				"target_lang": session.TargetLang,
-			})
+			// Errors are those of writing to a client gone away
+			subtitleService.Follow(c.Request().Context(), sessionID, revision, lang, 15*time.Second, send)
+			return nil
 		}, apis.RequireRecordAuth())
 
-		// Stop subtitle session
-		e.Router.POST("/api/subtitle/stop", func(c echo.Context) error {
+		// Re-encode the session's stream with its subtitles burned in, as an
+		// HLS stream for TVs and casting targets that can't overlay captions.
+		// Returns the existing one when already started.
+		api.POST("/api/subtitle/session/:id/burnin", openapi.Operation{
+			Summary:     "Burn the subtitles of a session into its stream",
+			Description: "Re-encodes it as an HLS stream for TVs and casting targets that can't overlay captions. Returns the existing one when already started.",
+			Body: openapi.Fields{
+				"lang":  "string",
+				"style": "object",
+			},
+		}, func(c echo.Context) error {
 			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
 			if authRecord == nil {
 				return apis.NewUnauthorizedError("Authentication required", nil)
 			}
 
 			data := struct {
-				SessionID string `json:"session_id"`
+				Lang  string                `json:"lang"`
+				Style subtitle.PreviewStyle `json:"style"`
 			}{}
 			if err := c.Bind(&data); err != nil {
 				return apis.NewBadRequestError("Invalid request body", err)
 			}
 
-			if err := subtitleService.StopSession(data.SessionID); err != nil {
-				return apis.NewBadRequestError("Failed to stop session", err)
+			info, err := subtitleService.StartBurnIn(c.PathParam("id"), data.Lang, data.Style)
+			if errors.Is(err, subtitle.ErrSessionNotFound) {
+				return apis.NewNotFoundError("Session not found", nil)
+			}
+			if err != nil {
+				return apis.NewBadRequestError(err.Error(), nil)
 			}
 
-			return c.JSON(http.StatusOK, map[string]string{"message": "Session stopped"})
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"burnin": info,
+				"url":    streamService.BaseURL(c) + info.Path,
+			})
 		}, apis.RequireRecordAuth())
 
-		// Get subtitle session status
-		e.Router.GET("/api/subtitle/session/:id", func(c echo.Context) error {
+		// Get the burned in stream of a session
+		api.GET("/api/subtitle/session/:id/burnin", openapi.Operation{Summary: "Get the burned in stream of a session"}, func(c echo.Context) error {
 			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
 			if authRecord == nil {
 				return apis.NewUnauthorizedError("Authentication required", nil)
 			}
 
-			sessionID := c.PathParam("id")
-			info, exists := subtitleService.GetSession(sessionID)
-			if !exists {
+			info, err := subtitleService.GetBurnIn(c.PathParam("id"))
+			if err != nil {
 				return apis.NewNotFoundError("Session not found", nil)
 			}
+			if info == nil {
+				return apis.NewNotFoundError("Burned in stream not started", nil)
+			}
 
-			return c.JSON(http.StatusOK, info)
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"burnin": info,
+				"url":    streamService.BaseURL(c) + info.Path,
+			})
 		}, apis.RequireRecordAuth())
 
-		// Get subtitles (polling endpoint)
-		e.Router.GET("/api/subtitle/session/:id/subtitles", func(c echo.Context) error {
+		// Stop the burned in stream of a session
+		api.DELETE("/api/subtitle/session/:id/burnin", openapi.Operation{Summary: "Stop the burned in stream of a session"}, func(c echo.Context) error {
 			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
 			if authRecord == nil {
 				return apis.NewUnauthorizedError("Authentication required", nil)
 			}
 
-			sessionID := c.PathParam("id")
-			sinceStr := c.QueryParam("since")
-			since := 0
-			if sinceStr != "" {
-				since, _ = strconv.Atoi(sinceStr)
+			if err := subtitleService.StopBurnIn(c.PathParam("id")); err != nil {
+				if errors.Is(err, subtitle.ErrSessionNotFound) {
+					return apis.NewNotFoundError("Session not found", nil)
+				}
+				return apis.NewNotFoundError("Burned in stream not started", nil)
 			}
 
-			subtitles, err := subtitleService.GetSubtitles(sessionID, since)
+			return c.JSON(http.StatusOK, map[string]string{"message": "Burned in stream stopped"})
+		}, apis.RequireRecordAuth())
+
+		// Serve the playlist and segments of a burned in stream (no auth, the
+		// token in the path is the credential, which segment URLs inherit).
+		// The playlist is waited for while the stream starts.
+		api.GET("/api/subtitle/burnin/:token/:file", openapi.Operation{
+			Summary:     "Serve a burned in stream",
+			Description: "Its playlist and segments. No auth, the token in the path is the credential, which segment URLs inherit. The playlist is waited for while the stream starts.",
+			Produces:    "application/vnd.apple.mpegurl",
+		}, func(c echo.Context) error {
+			token, file := c.PathParam("token"), c.PathParam("file")
+			path, err := subtitleService.BurnInFile(token, file)
 			if err != nil {
-				log.Printf("[DEBUG] GetSubtitles error for session %s: %v", sessionID, err)
-				return c.JSON(http.StatusOK, map[string]interface{}{
-					"subtitles": []interface{}{},
-					"count":     0,
-				})
+				return apis.NewNotFoundError("Stream not found", nil)
 			}
 
-			if len(subtitles) > 0 {
-				log.Printf("[DEBUG] Returning %d subtitles for session %s (since=%d)", len(subtitles), sessionID, since)
+			if file == "index.m3u8" {
+				deadline := time.Now().Add(subtitleConfig.BurnInDelay + 30*time.Second)
+				for {
+					if _, err := os.Stat(path); err == nil {
+						break
+					}
+					if time.Now().After(deadline) {
+						return apis.NewNotFoundError("Stream not ready", nil)
+					}
+					select {
+					case <-c.Request().Context().Done():
+						return nil
+					case <-time.After(500 * time.Millisecond):
+					}
+					// Stopped while waiting
+					if _, err := subtitleService.BurnInFile(token, file); err != nil {
+						return apis.NewNotFoundError("Stream not found", nil)
+					}
+				}
+				c.Response().Header().Set(echo.HeaderContentType, "application/vnd.apple.mpegurl")
+				c.Response().Header().Set(echo.HeaderCacheControl, "no-cache")
+			} else {
+				c.Response().Header().Set(echo.HeaderContentType, "video/mp2t")
 			}
 
-			return c.JSON(http.StatusOK, map[string]interface{}{
-				"subtitles": subtitles,
-				"count":     len(subtitles),
-			})
-		}, apis.RequireRecordAuth())
+			return c.File(path)
+		})
 
 		// Get latest subtitle only
-		e.Router.GET("/api/subtitle/session/:id/latest", func(c echo.Context) error {
+		api.GET("/api/subtitle/session/:id/latest", openapi.Operation{
+			Summary: "Get latest subtitle only",
+			Query:   []openapi.Param{{Name: "lang"}},
+		}, func(c echo.Context) error {
 			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
 			if authRecord == nil {
 				return apis.NewUnauthorizedError("Authentication required", nil)
 			}
 
 			sessionID := c.PathParam("id")
-			latest, err := subtitleService.GetLatestSubtitle(sessionID)
+			latest, err := subtitleService.GetLatestSubtitle(sessionID, c.QueryParam("lang"))
 			if err != nil {
 				return apis.NewBadRequestError("Failed to get latest subtitle", err)
 			}
@@ -794,44 +5934,144 @@ func main() {
 			})
 		}, apis.RequireRecordAuth())
 
-		// Export subtitles as SRT
-		e.Router.POST("/api/subtitle/session/:id/export", func(c echo.Context) error {
+		// Search the user's stored transcripts and recording subtitles for
+		// ?q=, returning each matching line with the time to jump to.
+		// ?source=sessions or ?source=recordings searches only one of them.
+		api.GET("/api/subtitle/search", openapi.Operation{
+			Summary:     "Search transcripts and recording subtitles",
+			Scope:       apikeys.ScopeExport,
+			Description: "Searches the user's stored transcripts and recording subtitles for ?q=, returning each matching line with the time to jump to. ?source=sessions or ?source=recordings searches only one of them.",
+			Query:       []openapi.Param{{Name: "q", Required: true}, {Name: "source"}, {Name: "limit"}},
+		}, func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewUnauthorizedError("Authentication required", nil)
+			}
+
+			query, err := subtitle.NormalizeQuery(c.QueryParam("q"))
+			if err != nil {
+				return apis.NewBadRequestError("q must be at least 2 characters", nil)
+			}
+			source := c.QueryParam("source")
+			if source != "" && source != "sessions" && source != "recordings" {
+				return apis.NewBadRequestError("source must be sessions or recordings", nil)
+			}
+			limit, _ := strconv.Atoi(c.QueryParam("limit"))
+			if limit <= 0 || limit > subtitle.MaxSearchLimit {
+				limit = subtitle.DefaultSearchLimit
+			}
+
+			matches := []subtitle.SearchMatch{}
+
+			if source != "recordings" {
+				found, err := subtitleService.SearchTranscripts(authRecord.Id, query, limit)
+				if err != nil {
+					return apis.NewBadRequestError("Failed to search transcripts", err)
+				}
+				matches = append(matches, found...)
+			}
+
+			if source != "sessions" && len(matches) < limit {
+				recordings, err := app.Dao().FindRecordsByFilter(
+					"recordings",
+					"profile.user = {:user} && subtitle_path != ''",
+					"-created",
+					0,
+					0,
+					dbx.Params{"user": authRecord.Id},
+				)
+				if err != nil {
+					return apis.NewBadRequestError("Failed to search recordings", err)
+				}
+
+				for _, record := range recordings {
+					sidecar, err := recorderService.OpenFile(filepath.Base(record.GetString("subtitle_path")))
+					if err != nil {
+						continue // Sidecar deleted with the file
+					}
+					found := subtitle.SearchSRT(sidecar, query, limit-len(matches))
+					sidecar.Close()
+
+					date := record.GetDateTime("actual_start").Time()
+					if date.IsZero() {
+						date = record.Created.Time()
+					}
+					for _, match := range found {
+						match.RecordingID = record.Id
+						match.ChannelID = record.GetString("channel")
+						match.Date = date
+						matches = append(matches, match)
+					}
+					if len(matches) >= limit {
+						break
+					}
+				}
+			}
+
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"query":   query,
+				"matches": matches,
+				"count":   len(matches),
+			})
+		}, apis.RequireRecordAuth())
+
+		// Export subtitles as SRT, WebVTT (?format=vtt) or ASS (?format=ass)
+		api.POST("/api/subtitle/session/:id/export", openapi.Operation{
+			Summary: "Export subtitles as SRT, WebVTT (?format=vtt) or ASS (?format=ass)",
+			Scope:   apikeys.ScopeExport,
+			Query:   []openapi.Param{{Name: "format"}},
+		}, func(c echo.Context) error {
 			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
 			if authRecord == nil {
 				return apis.NewUnauthorizedError("Authentication required", nil)
 			}
 
 			sessionID := c.PathParam("id")
-			filepath, err := subtitleService.ExportSRT(sessionID)
+			name, err := subtitleService.ExportSubtitles(sessionID, c.QueryParam("format"))
 			if err != nil {
-				return apis.NewBadRequestError("Failed to export SRT", err)
+				return apis.NewBadRequestError("Failed to export subtitles", err)
 			}
 
 			return c.JSON(http.StatusOK, map[string]string{
-				"filepath": filepath,
-				"message":  "SRT file exported successfully",
+				"file":    name,
+				"message": "Subtitle file exported successfully",
 			})
 		}, apis.RequireRecordAuth())
 
-		// Download SRT file
-		e.Router.GET("/api/subtitle/session/:id/download", func(c echo.Context) error {
+		// Download subtitle file, SRT unless ?format=vtt or ?format=ass
+		api.GET("/api/subtitle/session/:id/download", openapi.Operation{
+			Summary:  "Download subtitle file, SRT unless ?format=vtt or ?format=ass",
+			Scope:    apikeys.ScopeExport,
+			Query:    []openapi.Param{{Name: "format"}},
+			Produces: "text/plain",
+		}, func(c echo.Context) error {
 			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
 			if authRecord == nil {
 				return apis.NewUnauthorizedError("Authentication required", nil)
 			}
 
 			sessionID := c.PathParam("id")
-			filepath, err := subtitleService.ExportSRT(sessionID)
+			format := c.QueryParam("format")
+			if format == "" {
+				format = subtitle.FormatSRT
+			}
+
+			name, err := subtitleService.ExportSubtitles(sessionID, format)
+			if err != nil {
+				return apis.NewBadRequestError("Failed to export subtitles", err)
+			}
+			r, err := subtitleService.OpenExport(name)
 			if err != nil {
-				return apis.NewBadRequestError("Failed to export SRT", err)
+				return apis.NewBadRequestError("Failed to export subtitles", err)
 			}
+			defer r.Close()
 
-			c.Response().Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.srt\"", sessionID))
-			return c.File(filepath)
+			c.Response().Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.%s\"", sessionID, format))
+			return c.Stream(http.StatusOK, subtitle.FormatContentType(format), r)
 		}, apis.RequireRecordAuth())
 
 		// Delete subtitle session
-		e.Router.DELETE("/api/subtitle/session/:id", func(c echo.Context) error {
+		api.DELETE("/api/subtitle/session/:id", openapi.Operation{Summary: "Delete subtitle session"}, func(c echo.Context) error {
 			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
 			if authRecord == nil {
 				return apis.NewUnauthorizedError("Authentication required", nil)
@@ -846,7 +6086,7 @@ func main() {
 		}, apis.RequireRecordAuth())
 
 		// Get all active subtitle sessions
-		e.Router.GET("/api/subtitle/sessions", func(c echo.Context) error {
+		api.GET("/api/subtitle/sessions", openapi.Operation{Summary: "Get all active subtitle sessions", Scope: apikeys.ScopeExport}, func(c echo.Context) error {
 			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
 			if authRecord == nil {
 				return apis.NewUnauthorizedError("Authentication required", nil)
@@ -856,113 +6096,255 @@ func main() {
 			return c.JSON(http.StatusOK, sessions)
 		}, apis.RequireRecordAuth())
 
-		// Get available languages for speech recognition
-		e.Router.GET("/api/subtitle/languages", func(c echo.Context) error {
-			return c.JSON(http.StatusOK, subtitleService.GetAvailableLanguages())
-		})
+		// Get available languages for speech recognition
+		api.GET("/api/subtitle/languages", openapi.Operation{Summary: "Get available languages for speech recognition"}, func(c echo.Context) error {
+			return c.JSON(http.StatusOK, subtitleService.GetAvailableLanguages())
+		})
+
+		// Check Ollama status (for translation)
+		api.GET("/api/subtitle/ollama/status", openapi.Operation{Summary: "Check Ollama status (for translation)"}, func(c echo.Context) error {
+			available, message := subtitleService.CheckOllamaStatus(c.Request().Context())
+			config := subtitleService.GetConfig()
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"available":         available,
+				"message":           message,
+				"url":               config.OllamaURL,
+				"model":             config.OllamaModel,
+				"translation_cache": subtitleService.TranslationCacheStats(),
+			})
+		}, apis.RequireRecordAuth())
+
+		// Get Ollama configuration (admin only)
+		api.GET("/api/subtitle/ollama/config", openapi.Operation{Summary: "Get Ollama configuration"}, func(c echo.Context) error {
+			config := subtitleService.GetConfig()
+			available, _ := subtitleService.CheckOllamaStatus(c.Request().Context())
+			availableModels := []string{}
+			if available {
+				availableModels, _ = subtitleService.GetOllamaModels(c.Request().Context())
+			}
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"url":              config.OllamaURL,
+				"model":            config.OllamaModel,
+				"available":        available,
+				"available_models": availableModels,
+				"prompts":          subtitleService.GetPromptConfig(),
+				"default_prompt":   subtitle.DefaultPromptTemplate,
+			})
+		}, access.RequireAdmin())
+
+		// Update Ollama configuration (persist to database, admin only)
+		api.POST("/api/subtitle/ollama/config", openapi.Operation{
+			Summary: "Update Ollama configuration (persist to database)",
+			Body: openapi.Fields{
+				"url":     "string",
+				"model":   "string",
+				"prompts": "object",
+			},
+		}, func(c echo.Context) error {
+			data := struct {
+				URL     string                 `json:"url"`
+				Model   string                 `json:"model"`
+				Prompts *subtitle.PromptConfig `json:"prompts"` // Unchanged when omitted
+			}{}
+			if err := c.Bind(&data); err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
+			}
+
+			audit.SetDetail(c, "url", data.URL)
+			audit.SetDetail(c, "model", data.Model)
+
+			// Empty fields keep their value
+			config := subtitleService.GetConfig()
+			value := map[string]interface{}{
+				"url":     config.OllamaURL,
+				"model":   config.OllamaModel,
+				"prompts": subtitleService.GetPromptConfig(),
+			}
+			if data.URL != "" {
+				value["url"] = data.URL
+			}
+			if data.Model != "" {
+				value["model"] = data.Model
+			}
+			if data.Prompts != nil {
+				value["prompts"] = data.Prompts
+			}
+			if err := settingsService.Set("ollama_config", value); err != nil {
+				return settingError(err, "Invalid Ollama configuration")
+			}
+			logging.FromEcho(c).Info("Ollama config saved", "url", data.URL, "model", data.Model)
+
+			// Check if the new configuration works
+			available, message := subtitleService.CheckOllamaStatus(c.Request().Context())
+
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"success":   true,
+				"available": available,
+				"message":   message,
+				"url":       data.URL,
+				"model":     data.Model,
+				"prompts":   subtitleService.GetPromptConfig(),
+			})
+		}, access.RequireAdmin(), auditService.Middleware(audit.ActionOllamaConfig))
+
+		// Get Whisper model configuration
+		api.GET("/api/subtitle/whisper/config", openapi.Operation{Summary: "Get Whisper model configuration"}, func(c echo.Context) error {
+			config := subtitleService.GetConfig()
+			status, _ := subtitleService.Models().Status(config.WhisperModel)
+
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"model":            config.WhisperModel,
+				"model_status":     status,
+				"available_models": subtitle.WhisperModels,
+				"backend":          subtitleService.SpeechBackendName(),
+			})
+		}, apis.RequireRecordAuth())
+
+		// Select the Whisper model (persist to database, admin only), downloading it if needed
+		api.POST("/api/subtitle/whisper/config", openapi.Operation{
+			Summary: "Select the Whisper model (persist to database), downloading it if needed",
+			Body: openapi.Fields{
+				"model": "string",
+			},
+		}, func(c echo.Context) error {
+			data := struct {
+				Model string `json:"model"`
+			}{}
+			if err := c.Bind(&data); err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
+			}
+
+			if err := settingsService.Set("whisper_config", map[string]string{"model": data.Model}); err != nil {
+				return settingError(err, "Invalid Whisper model")
+			}
+
+			status, _ := subtitleService.Models().Status(data.Model)
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"success":      true,
+				"model":        data.Model,
+				"model_status": status,
+			})
+		}, access.RequireAdmin())
+
+		// List Whisper models with their download state
+		api.GET("/api/subtitle/whisper/models", openapi.Operation{Summary: "List Whisper models with their download state"}, func(c echo.Context) error {
+			return c.JSON(http.StatusOK, subtitleService.Models().List())
+		}, apis.RequireRecordAuth())
+
+		// Download progress of a Whisper model
+		api.GET("/api/subtitle/whisper/models/:name", openapi.Operation{Summary: "Download progress of a Whisper model"}, func(c echo.Context) error {
+			status, err := subtitleService.Models().Status(c.PathParam("name"))
+			if err != nil {
+				return apis.NewNotFoundError("Unknown Whisper model", nil)
+			}
+			return c.JSON(http.StatusOK, status)
+		}, apis.RequireRecordAuth())
+
+		// Start downloading a Whisper model, poll its progress with the GET above (admin only)
+		api.POST("/api/subtitle/whisper/models/:name/download", openapi.Operation{
+			Summary:     "Start downloading a Whisper model",
+			Description: "Its progress is polled with GET /api/subtitle/whisper/models/{name}.",
+		}, func(c echo.Context) error {
+			name := c.PathParam("name")
+			if err := subtitleService.Models().Download(name); err != nil {
+				return apis.NewNotFoundError("Unknown Whisper model", nil)
+			}
+
+			status, _ := subtitleService.Models().Status(name)
+			return c.JSON(http.StatusAccepted, status)
+		}, access.RequireAdmin())
 
-		// Check Ollama status (for translation)
-		e.Router.GET("/api/subtitle/ollama/status", func(c echo.Context) error {
-			available, message := subtitleService.CheckOllamaStatus()
-			config := subtitleService.GetConfig()
-			return c.JSON(http.StatusOK, map[string]interface{}{
-				"available": available,
-				"message":   message,
-				"url":       config.OllamaURL,
-				"model":     config.OllamaModel,
-			})
-		})
+		// Get the confidence threshold of speech recognition entries
+		api.GET("/api/subtitle/confidence/config", openapi.Operation{Summary: "Get the confidence threshold of speech recognition entries"}, func(c echo.Context) error {
+			return c.JSON(http.StatusOK, subtitleService.GetConfidenceConfig())
+		}, apis.RequireRecordAuth())
 
-		// Get Ollama configuration (load from database if available)
-		e.Router.GET("/api/subtitle/ollama/config", func(c echo.Context) error {
-			// Try to load from database first
-			settingsCollection, err := app.Dao().FindCollectionByNameOrId("app_settings")
-			if err == nil {
-				record, err := app.Dao().FindFirstRecordByFilter(settingsCollection.Id, "key = 'ollama_config'")
-				if err == nil && record != nil {
-					valueStr := record.GetString("value")
-					var savedConfig map[string]interface{}
-					if json.Unmarshal([]byte(valueStr), &savedConfig) == nil {
-						if url, ok := savedConfig["url"].(string); ok && url != "" {
-							subtitleService.UpdateOllamaConfig(url, "")
-						}
-						if model, ok := savedConfig["model"].(string); ok && model != "" {
-							subtitleService.UpdateOllamaConfig("", model)
-						}
-					}
-				}
+		// Update the confidence threshold (persist to database, admin only)
+		api.POST("/api/subtitle/confidence/config", openapi.Operation{
+			Summary: "Update the confidence threshold (persist to database)",
+			Body:    subtitle.ConfidenceConfig{},
+		}, func(c echo.Context) error {
+			var config subtitle.ConfidenceConfig
+			if err := c.Bind(&config); err != nil {
+				return apis.NewBadRequestError("Invalid request body", err)
 			}
 
-			config := subtitleService.GetConfig()
-			available, _ := subtitleService.CheckOllamaStatus()
-			availableModels := []string{}
-			if available {
-				availableModels, _ = subtitleService.GetOllamaModels()
+			if err := settingsService.Set("subtitle_confidence", config); err != nil {
+				return settingError(err, "Invalid confidence configuration")
 			}
+
+			config = subtitleService.GetConfidenceConfig()
+
 			return c.JSON(http.StatusOK, map[string]interface{}{
-				"url":              config.OllamaURL,
-				"model":            config.OllamaModel,
-				"available":        available,
-				"available_models": availableModels,
+				"success": true,
+				"config":  config,
 			})
-		})
+		}, access.RequireAdmin())
 
-		// Update Ollama configuration (persist to database)
-		e.Router.POST("/api/subtitle/ollama/config", func(c echo.Context) error {
-			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
-			if authRecord == nil {
-				return apis.NewUnauthorizedError("Authentication required", nil)
-			}
+		// Get transcript webhook configuration (secret is never returned, admin only)
+		api.GET("/api/subtitle/webhook/config", openapi.Operation{Summary: "Get transcript webhook configuration (secret is never returned)"}, func(c echo.Context) error {
+			config := subtitleService.GetWebhookConfig()
+			hasSecret := config.Secret != ""
+			config.Secret = ""
 
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"config":     config,
+				"has_secret": hasSecret,
+			})
+		}, access.RequireAdmin())
+
+		// Update transcript webhook configuration (persist to database, admin only)
+		api.POST("/api/subtitle/webhook/config", openapi.Operation{
+			Summary: "Update transcript webhook configuration (persist to database)",
+			Body: openapi.Fields{
+				"enabled": "boolean",
+				"url":     "string",
+				"secret":  "string",
+				"mode":    "string",
+			},
+		}, func(c echo.Context) error {
 			data := struct {
-				URL   string `json:"url"`
-				Model string `json:"model"`
+				Enabled bool    `json:"enabled"`
+				URL     string  `json:"url"`
+				Secret  *string `json:"secret"`
+				Mode    string  `json:"mode"`
 			}{}
 			if err := c.Bind(&data); err != nil {
 				return apis.NewBadRequestError("Invalid request body", err)
 			}
 
-			// Update in-memory config
-			subtitleService.UpdateOllamaConfig(data.URL, data.Model)
-
-			// Persist to database
-			settingsCollection, err := app.Dao().FindCollectionByNameOrId("app_settings")
-			if err == nil {
-				configValue := map[string]string{
-					"url":   data.URL,
-					"model": data.Model,
-				}
-				configJSON, _ := json.Marshal(configValue)
+			config := subtitle.TranscriptWebhookConfig{
+				Enabled: data.Enabled,
+				URL:     data.URL,
+				Mode:    data.Mode,
+			}
+			// Keep the existing secret unless a new one is provided
+			if data.Secret != nil {
+				config.Secret = *data.Secret
+			} else {
+				config.Secret = subtitleService.GetWebhookConfig().Secret
+			}
 
-				// Try to find existing record
-				record, err := app.Dao().FindFirstRecordByFilter(settingsCollection.Id, "key = 'ollama_config'")
-				if err != nil || record == nil {
-					// Create new record
-					record = models.NewRecord(settingsCollection)
-					record.Set("key", "ollama_config")
-				}
-				record.Set("value", string(configJSON))
-				if err := app.Dao().SaveRecord(record); err != nil {
-					log.Printf("Failed to save Ollama config: %v", err)
-				} else {
-					log.Printf("Ollama config saved: url=%s, model=%s", data.URL, data.Model)
-				}
+			if err := settingsService.Set("subtitle_webhook", config); err != nil {
+				return settingError(err, "Invalid webhook configuration")
 			}
 
-			// Check if the new configuration works
-			available, message := subtitleService.CheckOllamaStatus()
+			config = subtitleService.GetWebhookConfig()
 
+			config.Secret = ""
 			return c.JSON(http.StatusOK, map[string]interface{}{
-				"success":   true,
-				"available": available,
-				"message":   message,
-				"url":       data.URL,
-				"model":     data.Model,
+				"success": true,
+				"config":  config,
 			})
-		}, apis.RequireRecordAuth())
-
-		// Test Ollama connection with specific URL
-		e.Router.POST("/api/subtitle/ollama/test", func(c echo.Context) error {
+		}, access.RequireAdmin())
+
+		// Test Ollama connection with specific URL (admin only, the server makes the request)
+		api.POST("/api/subtitle/ollama/test", openapi.Operation{
+			Summary: "Test Ollama connection with specific URL (the server makes the request)",
+			Body: openapi.Fields{
+				"url": "string",
+			},
+		}, func(c echo.Context) error {
 			data := struct {
 				URL string `json:"url"`
 			}{}
@@ -971,7 +6353,7 @@ func main() {
 			}
 
 			// Temporarily test the connection
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			ctx, cancel := context.WithTimeout(c.Request().Context(), 5*time.Second)
 			defer cancel()
 
 			testURL := data.URL
@@ -1022,8 +6404,196 @@ func main() {
 				"available": false,
 				"message":   fmt.Sprintf("Server returned status %d", resp.StatusCode),
 			})
+		}, access.RequireAdmin(), rateLimiter.Middleware(ratelimit.OllamaTest))
+
+		return nil
+	})
+
+	// Stream URL visibility: playlist owners and admins see source URLs,
+	// other users get playback URLs that go through the stream proxy
+	app.OnRecordsListRequest().Add(func(e *core.RecordsListEvent) error {
+		streamService.ApplyChannelPolicy(e.HttpContext, e.Records...)
+		return nil
+	})
+
+	app.OnRecordViewRequest().Add(func(e *core.RecordViewEvent) error {
+		streamService.ApplyChannelPolicy(e.HttpContext, e.Record)
+		return nil
+	})
+
+	// Parental controls: hide blocked channels and programs from the
+	// profile being watched, and keep its restrictions behind its PIN.
+	// Lists are filtered by parentalService.FilterLists.
+	app.OnRecordViewRequest("channels", "epg_programs").Add(func(e *core.RecordViewEvent) error {
+		return parentalService.CheckView(e.HttpContext, e.Record)
+	})
+
+	// Channels are listed under the user's own groups. Parental controls
+	// above still match the provider's group.
+	app.OnRecordsListRequest("channels").Add(func(e *core.RecordsListEvent) error {
+		groupService.ApplyRecords(e.HttpContext, e.Records...)
+		return nil
+	})
+
+	app.OnRecordViewRequest("channels").Add(func(e *core.RecordViewEvent) error {
+		groupService.ApplyRecords(e.HttpContext, e.Record)
+		return nil
+	})
+
+	app.OnRecordBeforeCreateRequest("profiles").Add(func(e *core.RecordCreateEvent) error {
+		return parental.CheckNewProfile(e.Record)
+	})
+
+	app.OnRecordBeforeUpdateRequest("profiles").Add(func(e *core.RecordUpdateEvent) error {
+		return parentalService.ProtectProfile(e.HttpContext, e.Record, false)
+	})
+
+	app.OnRecordBeforeDeleteRequest("profiles").Add(func(e *core.RecordDeleteEvent) error {
+		return parentalService.ProtectProfile(e.HttpContext, e.Record, true)
+	})
+
+	app.OnRecordAfterCreateRequest().Add(func(e *core.RecordCreateEvent) error {
+		streamService.ApplyChannelPolicy(e.HttpContext, e.Record)
+		return nil
+	})
+
+	app.OnRecordBeforeUpdateRequest("channels").Add(func(e *core.RecordUpdateEvent) error {
+		return streamService.ProtectSourceURL(e.HttpContext, e.Record)
+	})
+
+	// Provider headers end up in upstream requests and ffmpeg arguments,
+	// reject those that could inject others
+	app.OnRecordBeforeCreateRequest("playlists").Add(func(e *core.RecordCreateEvent) error {
+		if _, err := upstream.FromPlaylist(e.Record); err != nil {
+			return apis.NewBadRequestError(err.Error(), nil)
+		}
+		return nil
+	})
+
+	app.OnRecordBeforeUpdateRequest("playlists").Add(func(e *core.RecordUpdateEvent) error {
+		if _, err := upstream.FromPlaylist(e.Record); err != nil {
+			return apis.NewBadRequestError(err.Error(), nil)
+		}
+		return nil
+	})
+
+	// Recurring schedules record a channel and profile of their user, and
+	// are expanded into scheduled recordings as soon as they are saved
+	validateSchedule := func(schedule *models.Record) error {
+		if err := scheduleService.Validate(schedule); err != nil {
+			return apis.NewBadRequestError(err.Error(), nil)
+		}
+		channel, err := app.Dao().FindRecordById("channels", schedule.GetString("channel"))
+		if err != nil || !slices.Contains(channelOwners(app, channel), schedule.GetString("user")) {
+			return apis.NewBadRequestError("Channel not found", nil)
+		}
+		return nil
+	}
+
+	app.OnRecordBeforeCreateRequest(schedules.Collection).Add(func(e *core.RecordCreateEvent) error {
+		return validateSchedule(e.Record)
+	})
+
+	app.OnRecordBeforeUpdateRequest(schedules.Collection).Add(func(e *core.RecordUpdateEvent) error {
+		return validateSchedule(e.Record)
+	})
+
+	app.OnRecordAfterCreateRequest(schedules.Collection).Add(func(e *core.RecordCreateEvent) error {
+		if err := scheduleService.Refresh(e.Record); err != nil {
+			logger.Warn("failed to expand schedule", "schedule_id", e.Record.Id, "error", err)
+		}
+		return nil
+	})
+
+	app.OnRecordAfterUpdateRequest(schedules.Collection).Add(func(e *core.RecordUpdateEvent) error {
+		if err := scheduleService.Refresh(e.Record); err != nil {
+			logger.Warn("failed to expand schedule", "schedule_id", e.Record.Id, "error", err)
+		}
+		return nil
+	})
+
+	// Recordings that already ran stay, unlinked
+	app.OnRecordBeforeDeleteRequest(schedules.Collection).Add(func(e *core.RecordDeleteEvent) error {
+		return scheduleService.DeletePending(e.Record.Id)
+	})
+
+	app.OnRecordAfterUpdateRequest("playlists").Add(func(e *core.RecordUpdateEvent) error {
+		upstreamResolver.Invalidate()
+		return nil
+	})
+
+	app.OnRecordAfterDeleteRequest("playlists").Add(func(e *core.RecordDeleteEvent) error {
+		upstreamResolver.Invalidate()
+		return nil
+	})
+
+	app.OnRecordAfterUpdateRequest().Add(func(e *core.RecordUpdateEvent) error {
+		streamService.ApplyChannelPolicy(e.HttpContext, e.Record)
+		return nil
+	})
+
+	// Roles and quota overrides are set by admins, users can't raise their own
+	guardNewUser := func(c echo.Context, user *models.Record) error {
+		if access.IsAdmin(c) {
+			return nil
+		}
+		if role := user.GetString(access.RoleField); role != "" && role != access.RoleUser {
+			return apis.NewForbiddenError("Only admins can set roles", nil)
+		}
+		if user.GetInt(quota.QuotaField) != 0 {
+			return apis.NewForbiddenError("Only admins can set storage quotas", nil)
+		}
+		return nil
+	}
+	app.OnRecordBeforeCreateRequest("users").Add(func(e *core.RecordCreateEvent) error {
+		return guardNewUser(e.HttpContext, e.Record)
+	})
+	sso.GuardSignup(app, guardNewUser)
+
+	app.OnRecordBeforeUpdateRequest("users").Add(func(e *core.RecordUpdateEvent) error {
+		if access.IsAdmin(e.HttpContext) {
+			return nil
+		}
+		original := e.Record.OriginalCopy()
+		if e.Record.GetString(access.RoleField) != original.GetString(access.RoleField) {
+			return apis.NewForbiddenError("Only admins can set roles", nil)
+		}
+		if e.Record.GetInt(quota.QuotaField) != original.GetInt(quota.QuotaField) {
+			return apis.NewForbiddenError("Only admins can set storage quotas", nil)
+		}
+		return nil
+	})
+
+	app.OnRealtimeBeforeMessageSend().Add(func(e *core.RealtimeMessageEvent) error {
+		streamService.ApplyRealtimePolicy(e.Client, e.Message)
+		return nil
+	})
+
+	// Audit password logins, TOTP validations are audited by their route
+	app.OnRecordAfterAuthWithPasswordRequest("users").Add(func(e *core.RecordAuthWithPasswordEvent) error {
+		auditService.LogRequest(e.HttpContext, audit.Entry{
+			Action:  audit.ActionLogin,
+			User:    e.Record.Id,
+			Success: true,
+			Status:  http.StatusOK,
+			Details: map[string]interface{}{"totp_required": e.Record.GetBool("totp_enabled")},
 		})
+		return nil
+	})
 
+	// Audit SSO logins and identity links
+	app.OnRecordAfterAuthWithOAuth2Request("users").Add(func(e *core.RecordAuthWithOAuth2Event) error {
+		auditService.LogRequest(e.HttpContext, audit.Entry{
+			Action:  audit.ActionLogin,
+			User:    e.Record.Id,
+			Success: true,
+			Status:  http.StatusOK,
+			Details: map[string]interface{}{
+				"provider":      e.ProviderName,
+				"new_user":      e.IsNewRecord,
+				"totp_required": e.Record.GetBool("totp_enabled"),
+			},
+		})
 		return nil
 	})
 
@@ -1041,13 +6611,13 @@ func main() {
 	app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
 		usersCollection, err := app.Dao().FindCollectionByNameOrId("users")
 		if err != nil {
-			log.Println("Users collection not found, will be created on first admin setup")
+			logger.Info("users collection not found, will be created on first admin setup")
 			return nil
 		}
 
 		// Check if totp_enabled field exists
 		if usersCollection.Schema.GetFieldByName("totp_enabled") == nil {
-			log.Println("Adding TOTP fields to users collection...")
+			logger.Info("adding TOTP fields to users collection")
 
 			usersCollection.Schema.AddField(&schema.SchemaField{
 				Name:    "totp_enabled",
@@ -1077,15 +6647,15 @@ func main() {
 			})
 
 			if err := app.Dao().SaveCollection(usersCollection); err != nil {
-				log.Printf("Failed to add TOTP fields: %v", err)
+				logger.Error("failed to add TOTP fields", "error", err)
 			} else {
-				log.Println("TOTP fields added successfully")
+				logger.Info("TOTP fields added successfully")
 			}
 		}
 
 		// Create profiles collection if not exists
 		if _, err := app.Dao().FindCollectionByNameOrId("profiles"); err != nil {
-			log.Println("Creating profiles collection...")
+			logger.Info("creating collection", "collection", "profiles")
 			profilesCollection := &models.Collection{
 				Name:       "profiles",
 				Type:       models.CollectionTypeBase,
@@ -1102,20 +6672,20 @@ func main() {
 					&schema.SchemaField{Name: "avatar", Type: schema.FieldTypeFile, Required: false,
 						Options: &schema.FileOptions{MaxSelect: 1, MaxSize: 5242880, MimeTypes: []string{"image/jpeg", "image/png", "image/gif", "image/webp"}}},
 					&schema.SchemaField{Name: "is_kids", Type: schema.FieldTypeBool, Required: false, Options: &schema.BoolOptions{}},
-					&schema.SchemaField{Name: "pin", Type: schema.FieldTypeText, Required: false, Options: &schema.TextOptions{Max: types.Pointer(4)}},
+					&schema.SchemaField{Name: "has_pin", Type: schema.FieldTypeBool, Required: false, Options: &schema.BoolOptions{}},
 					&schema.SchemaField{Name: "language", Type: schema.FieldTypeText, Required: false, Options: &schema.TextOptions{Max: types.Pointer(10)}},
 				),
 			}
 			if err := app.Dao().SaveCollection(profilesCollection); err != nil {
-				log.Printf("Failed to create profiles collection: %v", err)
+				logger.Error("failed to create collection", "collection", "profiles", "error", err)
 			} else {
-				log.Println("Profiles collection created")
+				logger.Info("collection created", "collection", "profiles")
 			}
 		}
 
 		// Create playlists collection if not exists
 		if _, err := app.Dao().FindCollectionByNameOrId("playlists"); err != nil {
-			log.Println("Creating playlists collection...")
+			logger.Info("creating collection", "collection", "playlists")
 			playlistsCollection := &models.Collection{
 				Name:       "playlists",
 				Type:       models.CollectionTypeBase,
@@ -1137,16 +6707,16 @@ func main() {
 				),
 			}
 			if err := app.Dao().SaveCollection(playlistsCollection); err != nil {
-				log.Printf("Failed to create playlists collection: %v", err)
+				logger.Error("failed to create collection", "collection", "playlists", "error", err)
 			} else {
-				log.Println("Playlists collection created")
+				logger.Info("collection created", "collection", "playlists")
 			}
 		}
 
 		// Create channels collection if not exists
 		playlistsCollection, _ := app.Dao().FindCollectionByNameOrId("playlists")
 		if _, err := app.Dao().FindCollectionByNameOrId("channels"); err != nil && playlistsCollection != nil {
-			log.Println("Creating channels collection...")
+			logger.Info("creating collection", "collection", "channels")
 			channelsCollection := &models.Collection{
 				Name:       "channels",
 				Type:       models.CollectionTypeBase,
@@ -1178,9 +6748,9 @@ func main() {
 				),
 			}
 			if err := app.Dao().SaveCollection(channelsCollection); err != nil {
-				log.Printf("Failed to create channels collection: %v", err)
+				logger.Error("failed to create collection", "collection", "channels", "error", err)
 			} else {
-				log.Println("Channels collection created")
+				logger.Info("collection created", "collection", "channels")
 			}
 		}
 
@@ -1188,7 +6758,7 @@ func main() {
 		profilesCollection, _ := app.Dao().FindCollectionByNameOrId("profiles")
 		channelsCollection, _ := app.Dao().FindCollectionByNameOrId("channels")
 		if _, err := app.Dao().FindCollectionByNameOrId("favorites"); err != nil && profilesCollection != nil && channelsCollection != nil {
-			log.Println("Creating favorites collection...")
+			logger.Info("creating collection", "collection", "favorites")
 			favoritesCollection := &models.Collection{
 				Name:       "favorites",
 				Type:       models.CollectionTypeBase,
@@ -1206,15 +6776,15 @@ func main() {
 				),
 			}
 			if err := app.Dao().SaveCollection(favoritesCollection); err != nil {
-				log.Printf("Failed to create favorites collection: %v", err)
+				logger.Error("failed to create collection", "collection", "favorites", "error", err)
 			} else {
-				log.Println("Favorites collection created")
+				logger.Info("collection created", "collection", "favorites")
 			}
 		}
 
 		// Create watch_history collection if not exists
 		if _, err := app.Dao().FindCollectionByNameOrId("watch_history"); err != nil && profilesCollection != nil && channelsCollection != nil {
-			log.Println("Creating watch_history collection...")
+			logger.Info("creating collection", "collection", "watch_history")
 			watchHistoryCollection := &models.Collection{
 				Name:       "watch_history",
 				Type:       models.CollectionTypeBase,
@@ -1226,39 +6796,47 @@ func main() {
 				Schema: schema.NewSchema(
 					&schema.SchemaField{Name: "profile", Type: schema.FieldTypeRelation, Required: true,
 						Options: &schema.RelationOptions{CollectionId: profilesCollection.Id, CascadeDelete: true}},
-					&schema.SchemaField{Name: "channel", Type: schema.FieldTypeRelation, Required: true,
+					&schema.SchemaField{Name: "channel", Type: schema.FieldTypeRelation, Required: false,
 						Options: &schema.RelationOptions{CollectionId: channelsCollection.Id, CascadeDelete: true}},
 					&schema.SchemaField{Name: "watched_at", Type: schema.FieldTypeDate, Required: true, Options: &schema.DateOptions{}},
 					&schema.SchemaField{Name: "duration", Type: schema.FieldTypeNumber, Required: false, Options: &schema.NumberOptions{}},
+					&schema.SchemaField{Name: "position", Type: schema.FieldTypeNumber, Required: false, Options: &schema.NumberOptions{}},
 				),
 			}
 			if err := app.Dao().SaveCollection(watchHistoryCollection); err != nil {
-				log.Printf("Failed to create watch_history collection: %v", err)
+				logger.Error("failed to create collection", "collection", "watch_history", "error", err)
 			} else {
-				log.Println("Watch history collection created")
+				logger.Info("collection created", "collection", "watch_history")
 			}
 		}
 
 		// Create app_settings collection if not exists (for persistent configuration)
 		if _, err := app.Dao().FindCollectionByNameOrId("app_settings"); err != nil {
-			log.Println("Creating app_settings collection...")
+			logger.Info("creating collection", "collection", "app_settings")
+			// Global values, without a user, are the admins'
+			ownerRule := "user = @request.auth.id || (user = '' && @request.auth.role = 'admin')"
 			appSettingsCollection := &models.Collection{
 				Name:       "app_settings",
 				Type:       models.CollectionTypeBase,
-				ListRule:   types.Pointer("@request.auth.id != ''"),
-				ViewRule:   types.Pointer("@request.auth.id != ''"),
-				CreateRule: types.Pointer("@request.auth.id != ''"),
-				UpdateRule: types.Pointer("@request.auth.id != ''"),
-				DeleteRule: types.Pointer("@request.auth.id != ''"),
+				ListRule:   types.Pointer(ownerRule),
+				ViewRule:   types.Pointer(ownerRule),
+				CreateRule: types.Pointer("@request.auth.id != '' && (" + ownerRule + ")"),
+				UpdateRule: types.Pointer("(" + ownerRule + ") && (@request.data.user:isset = false || @request.data.user = user)"),
+				DeleteRule: types.Pointer(ownerRule),
 				Schema: schema.NewSchema(
 					&schema.SchemaField{Name: "key", Type: schema.FieldTypeText, Required: true, Options: &schema.TextOptions{}},
-					&schema.SchemaField{Name: "value", Type: schema.FieldTypeJson, Required: false, Options: &schema.JsonOptions{}},
+					&schema.SchemaField{Name: "value", Type: schema.FieldTypeJson, Required: false, Options: &schema.JsonOptions{MaxSize: 2000000}},
+					&schema.SchemaField{Name: "user", Type: schema.FieldTypeRelation, Required: false,
+						Options: &schema.RelationOptions{CollectionId: usersCollection.Id, CascadeDelete: true, MaxSelect: types.Pointer(1)}},
 				),
+				Indexes: types.JsonArray[string]{
+					"CREATE UNIQUE INDEX idx_app_settings_key_user ON app_settings (key, user)",
+				},
 			}
 			if err := app.Dao().SaveCollection(appSettingsCollection); err != nil {
-				log.Printf("Failed to create app_settings collection: %v", err)
+				logger.Error("failed to create collection", "collection", "app_settings", "error", err)
 			} else {
-				log.Println("App settings collection created")
+				logger.Info("collection created", "collection", "app_settings")
 			}
 		}
 
@@ -1266,6 +6844,413 @@ func main() {
 	})
 
 	if err := app.Start(); err != nil {
-		log.Fatal(err)
+		logger.Error("application stopped", "error", err)
+		os.Exit(1)
+	}
+}
+
+// settingError turns an error saving a setting into an API error, a bad
+// request when the service it configures refused the value
+func settingError(err error, message string) error {
+	var invalid *settings.InvalidError
+	if errors.As(err, &invalid) {
+		return apis.NewBadRequestError(message+": "+invalid.Err.Error(), nil)
+	}
+	return apis.NewApiError(http.StatusInternalServerError, "Failed to save settings", err)
+}
+
+// whepError turns an error of WebRTC output into an API error
+func whepError(err error) error {
+	switch {
+	case errors.Is(err, stream.ErrWHEPSession):
+		return apis.NewNotFoundError("WebRTC session not found", nil)
+	case errors.Is(err, stream.ErrWHEPOffer):
+		return apis.NewBadRequestError(err.Error(), nil)
+	case errors.Is(err, stream.ErrWHEPDisabled), errors.Is(err, stream.ErrWHEPBusy):
+		return apis.NewApiError(http.StatusServiceUnavailable, err.Error(), nil)
+	}
+	return apis.NewApiError(http.StatusInternalServerError, "Failed to start WebRTC playback", err)
+}
+
+// castError turns an error of the cast service into an API error. Other
+// errors come from the device.
+func castError(err error) error {
+	switch {
+	case errors.Is(err, cast.ErrNotFound):
+		return apis.NewNotFoundError("Cast device not found", nil)
+	case errors.Is(err, cast.ErrNotCasting):
+		return apis.NewApiError(http.StatusConflict, err.Error(), nil)
+	case errors.Is(err, cast.ErrUnreachable):
+		return apis.NewBadRequestError(err.Error(), nil)
+	}
+	return apis.NewApiError(http.StatusBadGateway, "Cast device error: "+err.Error(), nil)
+}
+
+// groupError turns an error of the group service into an API error
+func groupError(err error, message string) error {
+	var claimed *groups.ClaimedError
+	var missing *groups.MissingChannelsError
+	switch {
+	case errors.Is(err, groups.ErrNotFound):
+		return apis.NewNotFoundError("Group not found", nil)
+	case errors.As(err, &missing):
+		return apis.NewNotFoundError(missing.Error(), nil)
+	case errors.As(err, &claimed), errors.Is(err, groups.ErrName), errors.Is(err, groups.ErrNameTaken),
+		errors.Is(err, groups.ErrEmpty), errors.Is(err, groups.ErrTooLarge), errors.Is(err, groups.ErrSameName):
+		return apis.NewBadRequestError(err.Error(), nil)
+	}
+	return apis.NewBadRequestError(message, err)
+}
+
+// epgMappingError turns an error of the guide mappings into an API error
+func epgMappingError(err error, message string) error {
+	switch {
+	case errors.Is(err, epg.ErrChannelNotFound):
+		return apis.NewNotFoundError("Channel not found", nil)
+	case errors.Is(err, epg.ErrPlaylistNotFound):
+		return apis.NewNotFoundError("Playlist not found", nil)
+	case errors.Is(err, epg.ErrMappingNotFound):
+		return apis.NewNotFoundError(err.Error(), nil)
+	case errors.Is(err, epg.ErrEpgID):
+		return apis.NewBadRequestError(err.Error(), nil)
+	}
+	return apis.NewBadRequestError(message, err)
+}
+
+// prewarmChannels picks the channels of a playlist to generate thumbnails
+// for: the user's favorites, then the first perGroup channels of each group
+// in playlist order, up to limit and skipping those already cached
+func prewarmChannels(app *pocketbase.PocketBase, playlistID, userID string, perGroup, limit int) map[string]string {
+	selected := make(map[string]string)
+	add := func(channel *models.Record) {
+		if len(selected) >= limit || channel.GetString("url") == "" {
+			return
+		}
+		if _, cached := thumbnailService.GetThumbnailPath(channel.Id); cached {
+			return
+		}
+		selected[channel.Id] = channel.GetString("url")
+	}
+
+	favorites, err := app.Dao().FindRecordsByFilter(
+		"favorites",
+		"profile.user ~ {:user} && channel.playlist ~ {:playlist}",
+		"sort_order",
+		limit,
+		0,
+		dbx.Params{"user": userID, "playlist": playlistID},
+	)
+	if err == nil {
+		for _, favorite := range favorites {
+			for _, channelID := range favorite.GetStringSlice("channel") {
+				if channel, err := app.Dao().FindRecordById("channels", channelID); err == nil {
+					add(channel)
+				}
+			}
+		}
+	}
+
+	channels, err := app.Dao().FindRecordsByFilter(
+		"channels",
+		"playlist ~ {:playlist}",
+		"created",
+		0,
+		0,
+		dbx.Params{"playlist": playlistID},
+	)
+	if err != nil {
+		return selected
+	}
+
+	perGroupCount := make(map[string]int)
+	for _, channel := range channels {
+		group := channel.GetString("group_title")
+		if perGroupCount[group] >= perGroup {
+			continue
+		}
+		perGroupCount[group]++
+		add(channel)
+	}
+
+	return selected
+}
+
+// channelOwners returns the users owning the playlists a channel belongs to
+func channelOwners(app *pocketbase.PocketBase, channel *models.Record) []string {
+	owners := make([]string, 0)
+	for _, playlistID := range channel.GetStringSlice("playlist") {
+		playlistRecord, err := app.Dao().FindRecordById("playlists", playlistID)
+		if err != nil {
+			continue
+		}
+		owners = append(owners, playlistRecord.GetStringSlice("user")...)
+	}
+	return owners
+}
+
+// recordOwnedBy reports whether the relation field of record references userID.
+// Relation fields may be stored as a single id or as an array of ids.
+func recordOwnedBy(record *models.Record, field, userID string) bool {
+	for _, id := range record.GetStringSlice(field) {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// streamTokenExport finds the stream token of an export request, and its
+// channels under the user's groups when withChannels is set
+func streamTokenExport(c echo.Context, withChannels bool) (*models.Record, streamtokens.Export, error) {
+	token := c.PathParam("token")
+	export := streamtokens.Export{BaseURL: streamService.BaseURL(c), Token: token}
+
+	record, err := streamTokenService.Find(token)
+	if err != nil {
+		return nil, export, apis.NewUnauthorizedError("Invalid or revoked stream token", nil)
+	}
+	if !withChannels {
+		return record, export, nil
+	}
+
+	export.Channels, err = streamTokenService.Channels(record)
+	if err != nil {
+		return nil, export, apis.NewBadRequestError("Failed to load channels", err)
+	}
+	if mapping, err := groupService.Mapping(record.GetString("user")); err == nil && !mapping.Empty() {
+		mapping.Apply(export.Channels...)
+	}
+	return record, export, nil
+}
+
+// Extras the channel list joins to channels with its with parameter
+const (
+	channelWithNowNext   = "now_next"
+	channelWithThumbnail = "thumbnail_url"
+	channelWithHealth    = "health"
+)
+
+// channelExtras parses the with parameter of the channel list
+func channelExtras(with string) ([]string, error) {
+	extras := []string{}
+	for _, extra := range strings.Split(with, ",") {
+		extra = strings.TrimSpace(extra)
+		switch extra {
+		case "":
+		case channelWithNowNext, channelWithThumbnail, channelWithHealth:
+			extras = append(extras, extra)
+		default:
+			return nil, fmt.Errorf("unknown extra %q, with takes %s, %s and %s",
+				extra, channelWithNowNext, channelWithThumbnail, channelWithHealth)
+		}
+	}
+	return extras, nil
+}
+
+// enrichChannels joins the extras to the channels of a list, each loaded
+// for all of them at once: now_next, what the user's guide says they air
+// and the progress of it, thumbnail_url, the thumbnail of the variant the
+// request asks for, and health, whether their sources are up. Records are
+// only changed for the response.
+func enrichChannels(app *pocketbase.PocketBase, c echo.Context, userID string, channels []*models.Record, extras []string, variant thumbnail.Variant) error {
+	if len(channels) == 0 {
+		return nil
+	}
+	now := time.Now()
+
+	var guide map[string]epg.NowNext
+	if slices.Contains(extras, channelWithNowNext) {
+		tvgIDs := make([]string, 0, len(channels))
+		for _, channel := range channels {
+			if tvgID := channel.GetString("tvg_id"); tvgID != "" {
+				tvgIDs = append(tvgIDs, tvgID)
+			}
+		}
+		var err error
+		if guide, err = epgService.NowNext(userID, tvgIDs, now); err != nil {
+			return err
+		}
+	}
+
+	// Sources merged into the channels, for their health
+	var merged map[string][]string
+	if slices.Contains(extras, channelWithHealth) {
+		ids := make([]interface{}, len(channels))
+		for i, channel := range channels {
+			ids[i] = channel.Id
+		}
+		duplicates := []*models.Record{}
+		err := app.Dao().RecordQuery("channels").
+			AndWhere(dbx.In("merged_into", ids...)).
+			All(&duplicates)
+		if err != nil {
+			return err
+		}
+		merged = make(map[string][]string)
+		for _, duplicate := range duplicates {
+			into := duplicate.GetString("merged_into")
+			merged[into] = append(merged[into], duplicate.Id)
+		}
+	}
+
+	// Thumbnail URLs change every 5 minutes so browsers refresh them
+	params := url.Values{}
+	for _, key := range []string{"size", "format"} {
+		if value := c.QueryParam(key); value != "" {
+			params.Set(key, value)
+		}
+	}
+	params.Set("t", strconv.FormatInt(now.Unix()/300*300, 10))
+
+	for _, channel := range channels {
+		if guide != nil {
+			entry := guide[channel.GetString("tvg_id")]
+			channel.Set(channelWithNowNext, map[string]interface{}{
+				"now":      entry.Now,
+				"next":     entry.Next,
+				"progress": entry.Progress(now),
+			})
+		}
+
+		if slices.Contains(extras, channelWithThumbnail) {
+			channel.Set(channelWithThumbnail, fmt.Sprintf("/api/thumbnail/%s?%s", channel.Id, params.Encode()))
+			if blurhash := thumbnailService.GetBlurhash(channel.Id, variant); blurhash != "" {
+				channel.Set("thumbnail_blurhash", blurhash)
+			}
+		}
+
+		if merged != nil {
+			// A channel is down when all its sources are, since the last
+			// one went down
+			sources := append([]string{channel.Id}, merged[channel.Id]...)
+			down := 0
+			var since time.Time
+			for _, id := range sources {
+				if at, isDown := streamService.IsDown(id); isDown {
+					down++
+					if at.After(since) {
+						since = at
+					}
+				}
+			}
+			health := map[string]interface{}{
+				"status":       "up",
+				"sources":      len(sources),
+				"sources_down": down,
+			}
+			switch {
+			case down == len(sources):
+				health["status"] = "down"
+				health["down_since"] = since
+			case down > 0:
+				health["status"] = "degraded"
+			}
+			channel.Set(channelWithHealth, health)
+		}
+
+		channel.WithUnknownData(true)
+	}
+	return nil
+}
+
+// playlistSyncJob returns the latest import job of a user's playlist, nil
+// when there is none
+func playlistSyncJob(userID, playlistID string) *jobs.Job {
+	for _, job := range jobManager.List(jobs.ListFilter{User: userID, Type: "playlist.import", Limit: 50}) {
+		payload := struct {
+			PlaylistID string `json:"playlist_id"`
+		}{}
+		if job.DecodePayload(&payload) == nil && payload.PlaylistID == playlistID {
+			return job
+		}
+	}
+	return nil
+}
+
+// publishRecording sends the status of a recording to the clients of its
+// user on /api/events. The source URL is left out, as some users can't see
+// it.
+func publishRecording(eventType string, rec *recorder.Recording, err error) {
+	data := struct {
+		recorder.RecordingInfo
+		Error string `json:"error,omitempty"`
+	}{RecordingInfo: rec.Info()}
+	data.ChannelURL = ""
+	if err != nil {
+		data.Error = err.Error()
+	}
+	eventHub.Publish(events.TopicRecorder, eventType, data, rec.UserID)
+}
+
+// visibleRecordingInfo returns recording info with the channel URL the requester may see
+func visibleRecordingInfo(c echo.Context, rec *recorder.Recording) recorder.RecordingInfo {
+	info := rec.Info()
+	info.ChannelURL = streamService.VisibleURL(c, rec.ChannelID, info.ChannelURL, rec.UserID)
+	return info
+}
+
+// serveThumbnail sends a cached image with its ETag. c.File answers
+// If-None-Match and If-Modified-Since with 304 on its own.
+func serveThumbnail(c echo.Context, path string) error {
+	if etag := thumbnailService.ETag(path); etag != "" {
+		c.Response().Header().Set("ETag", etag)
+	}
+	return c.File(path)
+}
+
+// favoritesProfile finds a profile of the user whose favorites a request
+// changes, with the filter of the channels it may get: those its parental
+// controls don't hide, or all of them, nil, when the request unlocked it
+func favoritesProfile(app *pocketbase.PocketBase, c echo.Context, userID, id string) (*models.Record, func(channel *models.Record) bool, error) {
+	profile, err := app.Dao().FindRecordById("profiles", id)
+	if err != nil || !parental.OwnedBy(profile, userID) {
+		return nil, nil, apis.NewNotFoundError("Profile not found", nil)
+	}
+
+	var keep func(channel *models.Record) bool
+	if restrictions := parental.RestrictionsOf(profile); !restrictions.Empty() && !parentalService.Unlocked(c, profile.Id) {
+		keep = func(channel *models.Record) bool {
+			return !restrictions.BlocksChannel(channel)
+		}
+	}
+	return profile, keep, nil
+}
+
+// ownedRecording finds a recording listed in a profile of the user, a 404
+// error otherwise
+func ownedRecording(app *pocketbase.PocketBase, userID, id string) (*models.Record, error) {
+	recording, err := app.Dao().FindRecordById("recordings", id)
+	if err != nil {
+		return nil, apis.NewNotFoundError("Recording not found", nil)
+	}
+	profile, err := app.Dao().FindRecordById("profiles", recording.GetString("profile"))
+	if err != nil || !parental.OwnedBy(profile, userID) {
+		return nil, apis.NewNotFoundError("Recording not found", nil)
+	}
+	return recording, nil
+}
+
+// canManageRecording reports whether the request may use a recorded file,
+// to play, delete or protect it: admins may, and so may the user who
+// recorded it
+func canManageRecording(c echo.Context, filename string) bool {
+	if access.IsAdmin(c) {
+		return true
+	}
+	authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+	return authRecord != nil && recorderService.Owner(filename) == authRecord.Id
+}
+
+// controlledRecording returns a recording in progress the request may
+// pause, resume or stop: admins may control any, users those they started
+func controlledRecording(c echo.Context, id string) (*recorder.Recording, bool) {
+	rec, exists := recorderService.GetRecording(id)
+	if !exists {
+		return nil, false
+	}
+	authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+	if !access.IsAdmin(c) && (authRecord == nil || rec.UserID != authRecord.Id) {
+		return nil, false
 	}
+	return rec, true
 }
@@ -0,0 +1,367 @@
+package maintenance
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+
+	"iptv-backend/jobs"
+	"iptv-backend/logging"
+	"iptv-backend/storage"
+)
+
+// JobType is the background job type maintenance runs are executed as.
+// Run history is the list of jobs of this type.
+const JobType = "maintenance"
+
+// Env gives tasks access to the app and the directories they maintain
+type Env struct {
+	App          core.App
+	ThumbnailDir string
+
+	// RecordingsDir returns the directory recordings are written to, which
+	// administrators can change
+	RecordingsDir func() string
+
+	// Storage holds the recordings moved off RecordingsDir, nil when
+	// recordings stay local
+	Storage storage.Backend
+
+	// RecordingRetention returns how many days finished recordings are
+	// kept, 0 to keep them forever
+	RecordingRetention func() int
+
+	// DeleteRecordings deletes the finished recordings started before a
+	// time, protected ones excepted. It returns how many were deleted and
+	// how many were kept because they couldn't be.
+	DeleteRecordings func(ctx context.Context, before time.Time) (deleted, kept int, err error)
+
+	// ActiveSubtitleSessions returns the IDs of running subtitle sessions
+	ActiveSubtitleSessions func() []string
+
+	// SubtitleExports returns the subtitle exports, local or stored, and
+	// DeleteSubtitleExport removes one
+	SubtitleExports      func() ([]storage.Object, error)
+	DeleteSubtitleExport func(name string) error
+}
+
+// TaskFunc runs a task and returns a summary of what it did
+type TaskFunc func(ctx context.Context, env *Env, config TaskConfig) (map[string]interface{}, error)
+
+// Task is a built-in maintenance task
+type Task struct {
+	Name        string
+	Description string
+	Defaults    TaskConfig
+	Run         TaskFunc
+}
+
+// TaskConfig is the editable configuration of a task
+type TaskConfig struct {
+	Enabled       bool `json:"enabled"`
+	IntervalHours int  `json:"interval_hours"`
+	RetentionDays int  `json:"retention_days,omitempty"` // Only used by pruning tasks
+	FutureDays    int  `json:"future_days,omitempty"`    // Only used by prune_guide
+}
+
+// TaskInfo describes a task and its schedule for the API
+type TaskInfo struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Config      TaskConfig `json:"config"`
+	LastRun     *time.Time `json:"last_run,omitempty"`
+	NextRun     *time.Time `json:"next_run,omitempty"`
+}
+
+// Scheduler runs maintenance tasks periodically and on demand
+type Scheduler struct {
+	env     Env
+	jobs    *jobs.Manager
+	tasks   map[string]*Task
+	order   []string
+	config  map[string]TaskConfig
+	lastRun map[string]time.Time
+	mu      sync.RWMutex
+	stop    context.CancelFunc
+	logger  *slog.Logger
+}
+
+// NewScheduler creates a scheduler with the built-in tasks and registers
+// the maintenance job type on jobManager
+func NewScheduler(env Env, jobManager *jobs.Manager) *Scheduler {
+	s := &Scheduler{
+		env:     env,
+		jobs:    jobManager,
+		tasks:   make(map[string]*Task),
+		config:  make(map[string]TaskConfig),
+		lastRun: make(map[string]time.Time),
+		logger:  logging.For("maintenance"),
+	}
+
+	for _, task := range builtinTasks() {
+		s.addTask(task)
+	}
+
+	jobManager.Register(JobType, s.runJob, jobs.TypeOptions{
+		MaxAttempts: 1,
+		Concurrency: 1,
+		Timeout:     time.Hour,
+	})
+
+	return s
+}
+
+func (s *Scheduler) addTask(task *Task) {
+	s.tasks[task.Name] = task
+	s.order = append(s.order, task.Name)
+	s.config[task.Name] = task.Defaults
+}
+
+// Config returns the configuration of all tasks
+func (s *Scheduler) Config() map[string]TaskConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	config := make(map[string]TaskConfig, len(s.config))
+	for name, c := range s.config {
+		config[name] = c
+	}
+	return config
+}
+
+// UpdateConfig replaces the configuration of the given tasks
+func (s *Scheduler) UpdateConfig(config map[string]TaskConfig) error {
+	for name, c := range config {
+		if _, ok := s.tasks[name]; !ok {
+			return fmt.Errorf("unknown task %q", name)
+		}
+		if c.IntervalHours < 1 {
+			return fmt.Errorf("interval_hours of %s must be at least 1", name)
+		}
+		if c.RetentionDays < 0 {
+			return fmt.Errorf("retention_days of %s can't be negative", name)
+		}
+		if c.FutureDays < 0 {
+			return fmt.Errorf("future_days of %s can't be negative", name)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, c := range config {
+		s.config[name] = c
+	}
+	return nil
+}
+
+// Tasks returns all tasks with their schedule
+func (s *Scheduler) Tasks() []TaskInfo {
+	now := time.Now()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	infos := make([]TaskInfo, 0, len(s.order))
+	for _, name := range s.order {
+		task := s.tasks[name]
+		info := TaskInfo{
+			Name:        task.Name,
+			Description: task.Description,
+			Config:      s.config[name],
+		}
+		if last, ok := s.lastRun[name]; ok {
+			info.LastRun = &last
+		}
+		if info.Config.Enabled {
+			next := s.nextRunLocked(name, now)
+			info.NextRun = &next
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// RunNow queues a task run outside of its schedule
+func (s *Scheduler) RunNow(name, user string) (*jobs.Job, error) {
+	if _, ok := s.tasks[name]; !ok {
+		return nil, fmt.Errorf("unknown task %q", name)
+	}
+	return s.enqueue(name, user, "manual")
+}
+
+// History returns recent runs, newest first, optionally for a single task
+func (s *Scheduler) History(name string, limit int) []*jobs.Job {
+	if limit <= 0 {
+		limit = 50
+	}
+
+	// Task names live in the payload, so over-fetch when filtering
+	fetch := limit
+	if name != "" {
+		fetch = limit * len(s.tasks)
+	}
+
+	runs := s.jobs.List(jobs.ListFilter{Type: JobType, Limit: fetch})
+	if name == "" {
+		return runs
+	}
+
+	filtered := make([]*jobs.Job, 0, limit)
+	for _, run := range runs {
+		if taskOf(run) == name {
+			filtered = append(filtered, run)
+			if len(filtered) == limit {
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// Start begins running tasks on their schedule
+func (s *Scheduler) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s.mu.Lock()
+	if s.stop != nil {
+		s.stop()
+	}
+	s.stop = cancel
+	s.mu.Unlock()
+
+	s.loadLastRuns()
+
+	go s.loop(ctx)
+}
+
+// Stop stops the schedule; runs already queued still complete
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stop != nil {
+		s.stop()
+		s.stop = nil
+	}
+}
+
+// loadLastRuns restores last run times from the job history so restarts
+// don't reset every schedule
+func (s *Scheduler) loadLastRuns() {
+	runs := s.jobs.List(jobs.ListFilter{Type: JobType, Limit: 500})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, run := range runs {
+		name := taskOf(run)
+		if _, seen := s.lastRun[name]; !seen && name != "" {
+			s.lastRun[name] = run.CreatedAt
+		}
+	}
+}
+
+func (s *Scheduler) loop(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.runDue()
+		}
+	}
+}
+
+// runDue queues every enabled task whose interval has elapsed
+func (s *Scheduler) runDue() {
+	now := time.Now()
+
+	s.mu.RLock()
+	due := make([]string, 0)
+	for _, name := range s.order {
+		if s.config[name].Enabled && !s.nextRunLocked(name, now).After(now) {
+			due = append(due, name)
+		}
+	}
+	s.mu.RUnlock()
+
+	for _, name := range due {
+		if _, err := s.enqueue(name, "", "schedule"); err != nil {
+			s.logger.Warn("failed to queue maintenance task", "task", name, "error", err)
+		}
+	}
+}
+
+// nextRunLocked returns when a task is next due. Tasks that never ran are
+// due at now.
+func (s *Scheduler) nextRunLocked(name string, now time.Time) time.Time {
+	last, ok := s.lastRun[name]
+	if !ok {
+		return now
+	}
+	return last.Add(time.Duration(s.config[name].IntervalHours) * time.Hour)
+}
+
+func (s *Scheduler) enqueue(name, user, trigger string) (*jobs.Job, error) {
+	job, err := s.jobs.Enqueue(JobType, user, map[string]string{
+		"task":    name,
+		"trigger": trigger,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.lastRun[name] = job.CreatedAt
+	s.mu.Unlock()
+
+	return job, nil
+}
+
+// runJob is the job handler executing a single task
+func (s *Scheduler) runJob(ctx context.Context, job *jobs.Job, progress jobs.ProgressFunc) (interface{}, error) {
+	name := taskOf(job)
+	task, ok := s.tasks[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown task %q", name)
+	}
+
+	s.mu.RLock()
+	config := s.config[name]
+	s.mu.RUnlock()
+
+	logger := s.logger.With("task", name, "job_id", job.ID)
+	logger.Info("running maintenance task")
+	progress(0, "running "+name)
+
+	started := time.Now()
+	result, err := task.Run(ctx, &s.env, config)
+	if err != nil {
+		logger.Error("maintenance task failed", "error", err)
+		return result, err
+	}
+
+	if result == nil {
+		result = make(map[string]interface{})
+	}
+	result["task"] = name
+	result["duration_ms"] = time.Since(started).Milliseconds()
+
+	logger.Info("maintenance task completed", "duration", time.Since(started).String())
+
+	return result, nil
+}
+
+// taskOf returns the task name of a maintenance job
+func taskOf(job *jobs.Job) string {
+	payload := struct {
+		Task string `json:"task"`
+	}{}
+	job.DecodePayload(&payload)
+	return payload.Task
+}
@@ -0,0 +1,500 @@
+package maintenance
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/tools/types"
+
+	"iptv-backend/recorder"
+	"iptv-backend/subtitle"
+	"iptv-backend/thumbnail"
+)
+
+// builtinTasks returns the tasks every scheduler starts with
+func builtinTasks() []*Task {
+	return []*Task{
+		{
+			Name:        "vacuum",
+			Description: "Rebuild the SQLite databases to reclaim free space",
+			Defaults:    TaskConfig{Enabled: true, IntervalHours: 7 * 24},
+			Run:         runVacuum,
+		},
+		{
+			Name:        "prune_watch_history",
+			Description: "Delete watch history entries and watch time statistics older than the retention period",
+			Defaults:    TaskConfig{Enabled: true, IntervalHours: 24, RetentionDays: 180},
+			Run:         runPruneWatchHistory,
+		},
+		{
+			Name:        "prune_guide",
+			Description: "Delete guide programmes that ended more than the retention period ago or start more than future_days ahead, then refresh the guide's query statistics",
+			Defaults:    TaskConfig{Enabled: true, IntervalHours: 24, RetentionDays: 7, FutureDays: 14},
+			Run:         runPruneGuide,
+		},
+		{
+			Name:        "prune_sessions",
+			Description: "Delete sign-in sessions inactive for longer than the retention period and expired trusted devices",
+			Defaults:    TaskConfig{Enabled: true, IntervalHours: 24, RetentionDays: 30},
+			Run:         runPruneSessions,
+		},
+		{
+			Name:        "clean_orphaned_files",
+			Description: "Remove thumbnails of deleted channels and old subtitle exports",
+			Defaults:    TaskConfig{Enabled: true, IntervalHours: 24, RetentionDays: 7},
+			Run:         runCleanOrphanedFiles,
+		},
+		{
+			Name:        "prune_recordings",
+			Description: "Delete finished recordings older than the recorder's retention period, protected ones excepted",
+			Defaults:    TaskConfig{Enabled: true, IntervalHours: 24},
+			Run:         runPruneRecordings,
+		},
+		{
+			Name:        "verify_recordings",
+			Description: "Compare the recording index with the files on disk",
+			Defaults:    TaskConfig{Enabled: true, IntervalHours: 24},
+			Run:         runVerifyRecordings,
+		},
+	}
+}
+
+// runVacuum vacuums the main and logs databases
+func runVacuum(ctx context.Context, env *Env, config TaskConfig) (map[string]interface{}, error) {
+	dataDB := filepath.Join(env.App.DataDir(), "data.db")
+	logsDB := filepath.Join(env.App.DataDir(), "logs.db")
+
+	dataBefore, logsBefore := fileSize(dataDB), fileSize(logsDB)
+
+	if err := env.App.Dao().Vacuum(); err != nil {
+		return nil, err
+	}
+	if err := env.App.LogsDao().Vacuum(); err != nil {
+		return nil, err
+	}
+
+	dataAfter, logsAfter := fileSize(dataDB), fileSize(logsDB)
+
+	return map[string]interface{}{
+		"data_db_before": dataBefore,
+		"data_db_after":  dataAfter,
+		"logs_db_before": logsBefore,
+		"logs_db_after":  logsAfter,
+		"reclaimed":      (dataBefore + logsBefore) - (dataAfter + logsAfter),
+	}, nil
+}
+
+// runPruneWatchHistory deletes watch history and watch time older than
+// RetentionDays
+func runPruneWatchHistory(ctx context.Context, env *Env, config TaskConfig) (map[string]interface{}, error) {
+	if config.RetentionDays <= 0 {
+		return map[string]interface{}{"skipped": "retention_days is 0"}, nil
+	}
+
+	cutoff, err := types.ParseDateTime(time.Now().AddDate(0, 0, -config.RetentionDays))
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := env.App.Dao().DB().
+		NewQuery("DELETE FROM watch_history WHERE watched_at != '' AND watched_at < {:cutoff}").
+		WithContext(ctx).
+		Bind(dbx.Params{"cutoff": cutoff.String()}).
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+
+	deleted, _ := result.RowsAffected()
+
+	result, err = env.App.Dao().DB().
+		NewQuery("DELETE FROM watch_time WHERE hour < {:cutoff}").
+		WithContext(ctx).
+		Bind(dbx.Params{"cutoff": cutoff.String()}).
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+
+	timeDeleted, _ := result.RowsAffected()
+
+	return map[string]interface{}{
+		"cutoff":             cutoff.String(),
+		"deleted":            deleted,
+		"watch_time_deleted": timeDeleted,
+	}, nil
+}
+
+// guidePruneBatch is the number of programmes deleted per statement, so
+// imports and reads aren't locked out for long on slow disks
+const guidePruneBatch = 5000
+
+// guideVacuumRatio is the share of free pages of the main database from
+// which pruning the guide vacuums it
+const guideVacuumRatio = 0.25
+
+// runPruneGuide deletes programmes that ended more than RetentionDays ago,
+// with their reminders as deleting them one by one would, and programmes
+// starting more than FutureDays ahead, those with reminders excepted. The
+// guide's statistics are refreshed for the query planner and the database
+// is vacuumed when pruning left a quarter of it free.
+func runPruneGuide(ctx context.Context, env *Env, config TaskConfig) (map[string]interface{}, error) {
+	if config.RetentionDays <= 0 && config.FutureDays <= 0 {
+		return map[string]interface{}{"skipped": "retention_days and future_days are 0"}, nil
+	}
+
+	db := env.App.Dao().DB()
+	result := map[string]interface{}{}
+
+	// deleteBatches runs a batched delete of the programmes matching where
+	// until none is left
+	deleteBatches := func(where string, params dbx.Params) (int64, error) {
+		var total int64
+		for {
+			if ctx.Err() != nil {
+				return total, ctx.Err()
+			}
+			res, err := db.NewQuery("DELETE FROM epg_programs WHERE id IN (SELECT id FROM epg_programs WHERE " + where +
+				" LIMIT " + strconv.Itoa(guidePruneBatch) + ")").
+				WithContext(ctx).
+				Bind(params).
+				Execute()
+			if err != nil {
+				return total, err
+			}
+			deleted, _ := res.RowsAffected()
+			total += deleted
+			if deleted < guidePruneBatch {
+				return total, nil
+			}
+		}
+	}
+
+	var deleted int64
+	if config.RetentionDays > 0 {
+		cutoff, err := types.ParseDateTime(time.Now().AddDate(0, 0, -config.RetentionDays))
+		if err != nil {
+			return nil, err
+		}
+		params := dbx.Params{"cutoff": cutoff.String()}
+
+		res, err := db.NewQuery("DELETE FROM reminders WHERE program IN (SELECT id FROM epg_programs WHERE end_time < {:cutoff})").
+			WithContext(ctx).
+			Bind(params).
+			Execute()
+		if err != nil {
+			return nil, err
+		}
+		remindersDeleted, _ := res.RowsAffected()
+
+		past, err := deleteBatches("end_time < {:cutoff}", params)
+		if err != nil {
+			return nil, err
+		}
+		deleted += past
+
+		result["cutoff"] = cutoff.String()
+		result["past_deleted"] = past
+		result["reminders_deleted"] = remindersDeleted
+	}
+
+	if config.FutureDays > 0 {
+		until, err := types.ParseDateTime(time.Now().AddDate(0, 0, config.FutureDays))
+		if err != nil {
+			return nil, err
+		}
+
+		future, err := deleteBatches("start_time > {:until} AND id NOT IN (SELECT program FROM reminders)",
+			dbx.Params{"until": until.String()})
+		if err != nil {
+			return nil, err
+		}
+		deleted += future
+
+		result["until"] = until.String()
+		result["future_deleted"] = future
+	}
+
+	if _, err := db.NewQuery("ANALYZE epg_programs").WithContext(ctx).Execute(); err != nil {
+		return nil, err
+	}
+
+	var pages, free int64
+	db.NewQuery("PRAGMA page_count").WithContext(ctx).Row(&pages)
+	db.NewQuery("PRAGMA freelist_count").WithContext(ctx).Row(&free)
+
+	vacuumed := false
+	if deleted > 0 && pages > 0 && float64(free)/float64(pages) >= guideVacuumRatio {
+		if err := env.App.Dao().Vacuum(); err != nil {
+			return nil, err
+		}
+		vacuumed = true
+	}
+
+	result["deleted"] = deleted
+	result["free_pages"] = free
+	result["vacuumed"] = vacuumed
+	return result, nil
+}
+
+// runPruneSessions deletes sessions not seen for RetentionDays, clients
+// signed in on them have to sign in again, and expired trusted devices
+func runPruneSessions(ctx context.Context, env *Env, config TaskConfig) (map[string]interface{}, error) {
+	if config.RetentionDays <= 0 {
+		return map[string]interface{}{"skipped": "retention_days is 0"}, nil
+	}
+
+	cutoff, err := types.ParseDateTime(time.Now().AddDate(0, 0, -config.RetentionDays))
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := env.App.Dao().DB().
+		NewQuery("DELETE FROM sessions WHERE last_seen < {:cutoff}").
+		WithContext(ctx).
+		Bind(dbx.Params{"cutoff": cutoff.String()}).
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+
+	deleted, _ := result.RowsAffected()
+
+	now, _ := types.ParseDateTime(time.Now())
+	result, err = env.App.Dao().DB().
+		NewQuery("DELETE FROM trusted_devices WHERE expires_at < {:now}").
+		WithContext(ctx).
+		Bind(dbx.Params{"now": now.String()}).
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+
+	expired, _ := result.RowsAffected()
+
+	return map[string]interface{}{
+		"cutoff":                  cutoff.String(),
+		"deleted":                 deleted,
+		"trusted_devices_deleted": expired,
+	}, nil
+}
+
+// runCleanOrphanedFiles removes cached thumbnails of channels that no longer
+// exist and subtitle exports older than RetentionDays whose session ended
+func runCleanOrphanedFiles(ctx context.Context, env *Env, config TaskConfig) (map[string]interface{}, error) {
+	result := map[string]interface{}{}
+
+	if env.ThumbnailDir != "" {
+		var channelIDs []string
+		if err := env.App.Dao().DB().Select("id").From("channels").Column(&channelIDs); err != nil {
+			return nil, err
+		}
+
+		known := make(map[string]bool, len(channelIDs))
+		for _, id := range channelIDs {
+			hash := md5.Sum([]byte(id))
+			known[hex.EncodeToString(hash[:])] = true
+		}
+
+		// Files are named after the channel hash, followed by the variant
+		// (-640x360-webp) or -preview, then the extension
+		removed, freed := removeFiles(ctx, env.ThumbnailDir, func(name string, info os.FileInfo) bool {
+			if strings.HasPrefix(name, thumbnail.IndexFile) {
+				return false
+			}
+			key, _, _ := strings.Cut(name, ".")
+			key, _, _ = strings.Cut(key, "-")
+			return !known[key]
+		})
+		result["thumbnails_removed"] = removed
+		result["thumbnails_freed"] = freed
+	}
+
+	if env.SubtitleExports != nil && config.RetentionDays > 0 {
+		active := make(map[string]bool)
+		if env.ActiveSubtitleSessions != nil {
+			for _, id := range env.ActiveSubtitleSessions() {
+				active[id] = true
+			}
+		}
+
+		exports, err := env.SubtitleExports()
+		if err != nil {
+			return nil, err
+		}
+
+		cutoff := time.Now().AddDate(0, 0, -config.RetentionDays)
+		removed := 0
+		var freed int64
+		for _, export := range exports {
+			if ctx.Err() != nil {
+				break
+			}
+			sessionID, _ := subtitle.ExportSession(export.Key)
+			if export.ModTime.After(cutoff) || active[sessionID] {
+				continue
+			}
+			if env.DeleteSubtitleExport(export.Key) == nil {
+				removed++
+				freed += export.Size
+			}
+		}
+		result["subtitles_removed"] = removed
+		result["subtitles_freed"] = freed
+	}
+
+	return result, ctx.Err()
+}
+
+// runPruneRecordings deletes finished recordings older than the retention
+// period of the recorder
+func runPruneRecordings(ctx context.Context, env *Env, config TaskConfig) (map[string]interface{}, error) {
+	retention := env.RecordingRetention()
+	if retention <= 0 {
+		return map[string]interface{}{"skipped": "recording retention_days is 0"}, nil
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -retention)
+	deleted, kept, err := env.DeleteRecordings(ctx, cutoff)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"cutoff":  cutoff.UTC().Format(time.RFC3339),
+		"deleted": deleted,
+		"kept":    kept,
+	}, nil
+}
+
+// runVerifyRecordings reports recording records whose file is missing, locally
+// and in the storage backend, and files in the recordings directory no
+// record points to. File sizes of
+// existing records are refreshed; nothing is deleted.
+func runVerifyRecordings(ctx context.Context, env *Env, config TaskConfig) (map[string]interface{}, error) {
+	dao := env.App.Dao()
+
+	if _, err := dao.FindCollectionByNameOrId("recordings"); err != nil {
+		return map[string]interface{}{"skipped": "recordings collection not found"}, nil
+	}
+
+	records, err := dao.FindRecordsByFilter("recordings", "file_path != ''", "", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	recordingsDir := env.RecordingsDir()
+
+	indexed := make(map[string]bool, len(records))
+	missing := make([]string, 0)
+	updated := 0
+
+	for _, record := range records {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		path := record.GetString("file_path")
+		if !filepath.IsAbs(path) && recordingsDir != "" {
+			path = filepath.Join(recordingsDir, path)
+		}
+		indexed[filepath.Clean(path)] = true
+
+		size, err := recordingSize(env, path)
+		if err != nil {
+			missing = append(missing, record.Id)
+			continue
+		}
+
+		if record.GetInt("file_size") != int(size) {
+			record.Set("file_size", size)
+			if err := dao.SaveRecord(record); err == nil {
+				updated++
+			}
+		}
+	}
+
+	untracked := make([]string, 0)
+	if entries, err := os.ReadDir(recordingsDir); err == nil {
+		for _, entry := range entries {
+			if entry.IsDir() || strings.HasSuffix(entry.Name(), ".temp") || strings.HasPrefix(entry.Name(), ".") || recorder.IsSubtitleSidecar(entry.Name()) {
+				continue
+			}
+			if !indexed[filepath.Join(recordingsDir, entry.Name())] {
+				untracked = append(untracked, entry.Name())
+			}
+		}
+	}
+
+	return map[string]interface{}{
+		"checked":         len(records),
+		"missing_files":   missing,
+		"untracked_files": untracked,
+		"sizes_updated":   updated,
+	}, nil
+}
+
+// recordingSize returns the size of a recording on disk, or in the storage
+// backend it was moved to
+func recordingSize(env *Env, path string) (int64, error) {
+	info, err := os.Stat(path)
+	if err == nil {
+		return info.Size(), nil
+	}
+	if env.Storage == nil || !os.IsNotExist(err) {
+		return 0, err
+	}
+
+	obj, err := env.Storage.Stat(filepath.Base(path))
+	if err != nil {
+		return 0, err
+	}
+	return obj.Size, nil
+}
+
+// removeFiles deletes the regular files of dir matching the predicate and
+// returns how many were removed and the bytes freed
+func removeFiles(ctx context.Context, dir string, match func(name string, info os.FileInfo) bool) (int, int64) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, 0
+	}
+
+	removed := 0
+	var freed int64
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			break
+		}
+		if entry.IsDir() {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil || !match(entry.Name(), info) {
+			continue
+		}
+
+		if os.Remove(filepath.Join(dir, entry.Name())) == nil {
+			removed++
+			freed += info.Size()
+		}
+	}
+
+	return removed, freed
+}
+
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
@@ -0,0 +1,133 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		usersCollection, err := dao.FindCollectionByNameOrId("users")
+		if err != nil {
+			return err
+		}
+
+		// Create jobs collection (background job queue records)
+		// Jobs are written by the backend only; users can read their own jobs
+		jobsCollection := &models.Collection{
+			Name:     "jobs",
+			Type:     models.CollectionTypeBase,
+			ListRule: types.Pointer("user = @request.auth.id"),
+			ViewRule: types.Pointer("user = @request.auth.id"),
+			Schema: schema.NewSchema(
+				&schema.SchemaField{
+					Name:     "type",
+					Type:     schema.FieldTypeText,
+					Required: true,
+					Options: &schema.TextOptions{
+						Max: types.Pointer(100),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "user",
+					Type:     schema.FieldTypeRelation,
+					Required: false,
+					Options: &schema.RelationOptions{
+						CollectionId:  usersCollection.Id,
+						CascadeDelete: true,
+						MaxSelect:     types.Pointer(1),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "status",
+					Type:     schema.FieldTypeText,
+					Required: true,
+					Options: &schema.TextOptions{
+						Max: types.Pointer(20),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "payload",
+					Type:     schema.FieldTypeJson,
+					Required: false,
+					Options:  &schema.JsonOptions{MaxSize: 2000000},
+				},
+				&schema.SchemaField{
+					Name:     "result",
+					Type:     schema.FieldTypeJson,
+					Required: false,
+					Options:  &schema.JsonOptions{MaxSize: 2000000},
+				},
+				&schema.SchemaField{
+					Name:     "progress",
+					Type:     schema.FieldTypeNumber,
+					Required: false,
+					Options:  &schema.NumberOptions{},
+				},
+				&schema.SchemaField{
+					Name:     "message",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options:  &schema.TextOptions{},
+				},
+				&schema.SchemaField{
+					Name:     "attempts",
+					Type:     schema.FieldTypeNumber,
+					Required: false,
+					Options:  &schema.NumberOptions{},
+				},
+				&schema.SchemaField{
+					Name:     "max_attempts",
+					Type:     schema.FieldTypeNumber,
+					Required: false,
+					Options:  &schema.NumberOptions{},
+				},
+				&schema.SchemaField{
+					Name:     "error",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options:  &schema.TextOptions{},
+				},
+				&schema.SchemaField{
+					Name:     "started_at",
+					Type:     schema.FieldTypeDate,
+					Required: false,
+					Options:  &schema.DateOptions{},
+				},
+				&schema.SchemaField{
+					Name:     "finished_at",
+					Type:     schema.FieldTypeDate,
+					Required: false,
+					Options:  &schema.DateOptions{},
+				},
+				&schema.SchemaField{
+					Name:     "next_run_at",
+					Type:     schema.FieldTypeDate,
+					Required: false,
+					Options:  &schema.DateOptions{},
+				},
+			),
+			Indexes: types.JsonArray[string]{
+				"CREATE INDEX idx_jobs_status ON jobs (status)",
+				"CREATE INDEX idx_jobs_user ON jobs (user)",
+			},
+		}
+
+		return dao.SaveCollection(jobsCollection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("jobs")
+		if err != nil {
+			return nil
+		}
+
+		return dao.DeleteCollection(collection)
+	})
+}
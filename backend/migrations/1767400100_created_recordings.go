@@ -0,0 +1,132 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		// The frontend already reads this collection, it may have been created by hand
+		if _, err := dao.FindCollectionByNameOrId("recordings"); err == nil {
+			return nil
+		}
+
+		profilesCollection, err := dao.FindCollectionByNameOrId("profiles")
+		if err != nil {
+			return err
+		}
+
+		channelsCollection, err := dao.FindCollectionByNameOrId("channels")
+		if err != nil {
+			return err
+		}
+
+		// Create recordings collection (scheduled and finished recordings)
+		// Note: Using ~ instead of = for relation fields as they are stored as arrays
+		recordingsCollection := &models.Collection{
+			Name:       "recordings",
+			Type:       models.CollectionTypeBase,
+			ListRule:   types.Pointer("profile.user ~ @request.auth.id"),
+			ViewRule:   types.Pointer("profile.user ~ @request.auth.id"),
+			CreateRule: types.Pointer("@request.auth.id != ''"),
+			UpdateRule: types.Pointer("profile.user ~ @request.auth.id"),
+			DeleteRule: types.Pointer("profile.user ~ @request.auth.id"),
+			Schema: schema.NewSchema(
+				&schema.SchemaField{
+					Name:     "profile",
+					Type:     schema.FieldTypeRelation,
+					Required: true,
+					Options: &schema.RelationOptions{
+						CollectionId:  profilesCollection.Id,
+						CascadeDelete: true,
+						MaxSelect:     types.Pointer(1),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "channel",
+					Type:     schema.FieldTypeRelation,
+					Required: false,
+					Options: &schema.RelationOptions{
+						CollectionId:  channelsCollection.Id,
+						CascadeDelete: false,
+						MaxSelect:     types.Pointer(1),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "program_title",
+					Type:     schema.FieldTypeText,
+					Required: true,
+					Options: &schema.TextOptions{
+						Max: types.Pointer(300),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "scheduled_start",
+					Type:     schema.FieldTypeDate,
+					Required: false,
+					Options:  &schema.DateOptions{},
+				},
+				&schema.SchemaField{
+					Name:     "scheduled_end",
+					Type:     schema.FieldTypeDate,
+					Required: false,
+					Options:  &schema.DateOptions{},
+				},
+				&schema.SchemaField{
+					Name:     "actual_start",
+					Type:     schema.FieldTypeDate,
+					Required: false,
+					Options:  &schema.DateOptions{},
+				},
+				&schema.SchemaField{
+					Name:     "actual_end",
+					Type:     schema.FieldTypeDate,
+					Required: false,
+					Options:  &schema.DateOptions{},
+				},
+				&schema.SchemaField{
+					// scheduled, recording, completed, completed_partial, failed
+					Name:     "status",
+					Type:     schema.FieldTypeText,
+					Required: true,
+					Options: &schema.TextOptions{
+						Max: types.Pointer(20),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "file_path",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options:  &schema.TextOptions{},
+				},
+				&schema.SchemaField{
+					Name:     "file_size",
+					Type:     schema.FieldTypeNumber,
+					Required: false,
+					Options:  &schema.NumberOptions{},
+				},
+			),
+			Indexes: types.JsonArray[string]{
+				"CREATE INDEX idx_recordings_status_start ON recordings (status, scheduled_start)",
+			},
+		}
+
+		return dao.SaveCollection(recordingsCollection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("recordings")
+		if err != nil {
+			return nil
+		}
+
+		return dao.DeleteCollection(collection)
+	})
+}
@@ -0,0 +1,95 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		usersCollection, err := dao.FindCollectionByNameOrId("users")
+		if err != nil {
+			return err
+		}
+
+		// Create notification_settings collection (one record per delivery channel)
+		notificationSettingsCollection := &models.Collection{
+			Name:       "notification_settings",
+			Type:       models.CollectionTypeBase,
+			ListRule:   types.Pointer("user = @request.auth.id"),
+			ViewRule:   types.Pointer("user = @request.auth.id"),
+			CreateRule: types.Pointer("@request.auth.id != '' && @request.data.user = @request.auth.id"),
+			UpdateRule: types.Pointer("user = @request.auth.id && (@request.data.user:isset = false || @request.data.user = @request.auth.id)"),
+			DeleteRule: types.Pointer("user = @request.auth.id"),
+			Schema: schema.NewSchema(
+				&schema.SchemaField{
+					Name:     "user",
+					Type:     schema.FieldTypeRelation,
+					Required: true,
+					Options: &schema.RelationOptions{
+						CollectionId:  usersCollection.Id,
+						CascadeDelete: true,
+						MaxSelect:     types.Pointer(1),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "name",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options: &schema.TextOptions{
+						Max: types.Pointer(100),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "type",
+					Type:     schema.FieldTypeSelect,
+					Required: true,
+					Options: &schema.SelectOptions{
+						MaxSelect: 1,
+						Values:    []string{"email", "webhook", "telegram", "ntfy"},
+					},
+				},
+				&schema.SchemaField{
+					Name:     "enabled",
+					Type:     schema.FieldTypeBool,
+					Required: false,
+					Options:  &schema.BoolOptions{},
+				},
+				&schema.SchemaField{
+					// Event types to deliver, empty for all
+					Name:     "events",
+					Type:     schema.FieldTypeJson,
+					Required: false,
+					Options:  &schema.JsonOptions{MaxSize: 10000},
+				},
+				&schema.SchemaField{
+					// Sender specific settings (address, url, bot token, topic...)
+					Name:     "config",
+					Type:     schema.FieldTypeJson,
+					Required: false,
+					Options:  &schema.JsonOptions{MaxSize: 10000},
+				},
+			),
+			Indexes: types.JsonArray[string]{
+				"CREATE INDEX idx_notification_settings_user ON notification_settings (user)",
+			},
+		}
+
+		return dao.SaveCollection(notificationSettingsCollection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("notification_settings")
+		if err != nil {
+			return nil
+		}
+
+		return dao.DeleteCollection(collection)
+	})
+}
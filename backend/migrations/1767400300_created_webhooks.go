@@ -0,0 +1,113 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		usersCollection, err := dao.FindCollectionByNameOrId("users")
+		if err != nil {
+			return err
+		}
+
+		// Create webhooks collection (outbound lifecycle event subscriptions)
+		webhooksCollection := &models.Collection{
+			Name:       "webhooks",
+			Type:       models.CollectionTypeBase,
+			ListRule:   types.Pointer("user = @request.auth.id"),
+			ViewRule:   types.Pointer("user = @request.auth.id"),
+			CreateRule: types.Pointer("@request.auth.id != '' && @request.data.user = @request.auth.id"),
+			UpdateRule: types.Pointer("user = @request.auth.id && (@request.data.user:isset = false || @request.data.user = @request.auth.id)"),
+			DeleteRule: types.Pointer("user = @request.auth.id"),
+			Schema: schema.NewSchema(
+				&schema.SchemaField{
+					Name:     "user",
+					Type:     schema.FieldTypeRelation,
+					Required: true,
+					Options: &schema.RelationOptions{
+						CollectionId:  usersCollection.Id,
+						CascadeDelete: true,
+						MaxSelect:     types.Pointer(1),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "name",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options: &schema.TextOptions{
+						Max: types.Pointer(100),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "url",
+					Type:     schema.FieldTypeUrl,
+					Required: true,
+					Options:  &schema.UrlOptions{},
+				},
+				&schema.SchemaField{
+					// HMAC-SHA256 signing key, optional
+					Name:     "secret",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options: &schema.TextOptions{
+						Max: types.Pointer(200),
+					},
+				},
+				&schema.SchemaField{
+					// Event types to deliver, empty for all
+					Name:     "events",
+					Type:     schema.FieldTypeJson,
+					Required: false,
+					Options:  &schema.JsonOptions{MaxSize: 10000},
+				},
+				&schema.SchemaField{
+					Name:     "enabled",
+					Type:     schema.FieldTypeBool,
+					Required: false,
+					Options:  &schema.BoolOptions{},
+				},
+				&schema.SchemaField{
+					// Outcome of the last delivery attempt, maintained by the server
+					Name:     "last_status",
+					Type:     schema.FieldTypeNumber,
+					Required: false,
+					Options:  &schema.NumberOptions{},
+				},
+				&schema.SchemaField{
+					Name:     "last_error",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options:  &schema.TextOptions{},
+				},
+				&schema.SchemaField{
+					Name:     "last_delivery_at",
+					Type:     schema.FieldTypeDate,
+					Required: false,
+					Options:  &schema.DateOptions{},
+				},
+			),
+			Indexes: types.JsonArray[string]{
+				"CREATE INDEX idx_webhooks_user ON webhooks (user)",
+			},
+		}
+
+		return dao.SaveCollection(webhooksCollection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("webhooks")
+		if err != nil {
+			return nil
+		}
+
+		return dao.DeleteCollection(collection)
+	})
+}
@@ -0,0 +1,110 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		usersCollection, err := dao.FindCollectionByNameOrId("users")
+		if err != nil {
+			return err
+		}
+
+		// Create devices collection (playback capabilities of each client device)
+		devicesCollection := &models.Collection{
+			Name:       "devices",
+			Type:       models.CollectionTypeBase,
+			ListRule:   types.Pointer("user = @request.auth.id"),
+			ViewRule:   types.Pointer("user = @request.auth.id"),
+			CreateRule: types.Pointer("@request.auth.id != '' && @request.data.user = @request.auth.id"),
+			UpdateRule: types.Pointer("user = @request.auth.id && (@request.data.user:isset = false || @request.data.user = @request.auth.id)"),
+			DeleteRule: types.Pointer("user = @request.auth.id"),
+			Schema: schema.NewSchema(
+				&schema.SchemaField{
+					Name:     "user",
+					Type:     schema.FieldTypeRelation,
+					Required: true,
+					Options: &schema.RelationOptions{
+						CollectionId:  usersCollection.Id,
+						CascadeDelete: true,
+						MaxSelect:     types.Pointer(1),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "name",
+					Type:     schema.FieldTypeText,
+					Required: true,
+					Options: &schema.TextOptions{
+						Min: types.Pointer(1),
+						Max: types.Pointer(100),
+					},
+				},
+				&schema.SchemaField{
+					// Video codecs the device decodes: h264, hevc, vp9, av1, mpeg2video
+					Name:     "video_codecs",
+					Type:     schema.FieldTypeJson,
+					Required: false,
+					Options:  &schema.JsonOptions{MaxSize: 2000},
+				},
+				&schema.SchemaField{
+					// Audio codecs the device decodes: aac, mp3, ac3, eac3, opus
+					Name:     "audio_codecs",
+					Type:     schema.FieldTypeJson,
+					Required: false,
+					Options:  &schema.JsonOptions{MaxSize: 2000},
+				},
+				&schema.SchemaField{
+					// Containers the device plays natively: hls, mpegts, mp4
+					Name:     "containers",
+					Type:     schema.FieldTypeJson,
+					Required: false,
+					Options:  &schema.JsonOptions{MaxSize: 2000},
+				},
+				&schema.SchemaField{
+					// Highest vertical resolution, 0 for no limit
+					Name:     "max_height",
+					Type:     schema.FieldTypeNumber,
+					Required: false,
+					Options: &schema.NumberOptions{
+						Min:       types.Pointer(0.0),
+						NoDecimal: true,
+					},
+				},
+				&schema.SchemaField{
+					Name:     "hdr",
+					Type:     schema.FieldTypeBool,
+					Required: false,
+					Options:  &schema.BoolOptions{},
+				},
+				&schema.SchemaField{
+					Name:     "last_seen_at",
+					Type:     schema.FieldTypeDate,
+					Required: false,
+					Options:  &schema.DateOptions{},
+				},
+			),
+			Indexes: types.JsonArray[string]{
+				"CREATE INDEX idx_devices_user ON devices (user)",
+			},
+		}
+
+		return dao.SaveCollection(devicesCollection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("devices")
+		if err != nil {
+			return nil
+		}
+
+		return dao.DeleteCollection(collection)
+	})
+}
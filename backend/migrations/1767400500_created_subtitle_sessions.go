@@ -0,0 +1,211 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		usersCollection, err := dao.FindCollectionByNameOrId("users")
+		if err != nil {
+			return err
+		}
+
+		// Create subtitle_sessions collection (transcripts kept after a session ends)
+		// Records are written by the server only, users can read and delete theirs
+		sessionsCollection := &models.Collection{
+			Name:       "subtitle_sessions",
+			Type:       models.CollectionTypeBase,
+			ListRule:   types.Pointer("user = @request.auth.id"),
+			ViewRule:   types.Pointer("user = @request.auth.id"),
+			CreateRule: nil,
+			UpdateRule: nil,
+			DeleteRule: types.Pointer("user = @request.auth.id"),
+			Schema: schema.NewSchema(
+				&schema.SchemaField{
+					Name:     "user",
+					Type:     schema.FieldTypeRelation,
+					Required: false,
+					Options: &schema.RelationOptions{
+						CollectionId:  usersCollection.Id,
+						CascadeDelete: true,
+						MaxSelect:     types.Pointer(1),
+					},
+				},
+				&schema.SchemaField{
+					// Client chosen ID, may be reused after a restart
+					Name:     "session_id",
+					Type:     schema.FieldTypeText,
+					Required: true,
+					Options: &schema.TextOptions{
+						Max: types.Pointer(200),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "channel_id",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options:  &schema.TextOptions{},
+				},
+				&schema.SchemaField{
+					Name:     "language",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options: &schema.TextOptions{
+						Max: types.Pointer(10),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "target_langs",
+					Type:     schema.FieldTypeJson,
+					Required: false,
+					Options:  &schema.JsonOptions{MaxSize: 1000},
+				},
+				&schema.SchemaField{
+					// speech, teletext or closed_captions
+					Name:     "source",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options:  &schema.TextOptions{},
+				},
+				&schema.SchemaField{
+					Name:     "status",
+					Type:     schema.FieldTypeSelect,
+					Required: true,
+					Options: &schema.SelectOptions{
+						MaxSelect: 1,
+						Values:    []string{"starting", "running", "stopped", "error"},
+					},
+				},
+				&schema.SchemaField{
+					Name:     "error",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options:  &schema.TextOptions{},
+				},
+				&schema.SchemaField{
+					Name:     "subtitle_count",
+					Type:     schema.FieldTypeNumber,
+					Required: false,
+					Options: &schema.NumberOptions{
+						Min:       types.Pointer(0.0),
+						NoDecimal: true,
+					},
+				},
+				&schema.SchemaField{
+					Name:     "ended_at",
+					Type:     schema.FieldTypeDate,
+					Required: false,
+					Options:  &schema.DateOptions{},
+				},
+			),
+			Indexes: types.JsonArray[string]{
+				"CREATE INDEX idx_subtitle_sessions_user ON subtitle_sessions (user)",
+				"CREATE INDEX idx_subtitle_sessions_session_id ON subtitle_sessions (session_id)",
+			},
+		}
+
+		if err := dao.SaveCollection(sessionsCollection); err != nil {
+			return err
+		}
+
+		// Create subtitle_entries collection (one record per subtitle line)
+		entriesCollection := &models.Collection{
+			Name:       "subtitle_entries",
+			Type:       models.CollectionTypeBase,
+			ListRule:   types.Pointer("session.user = @request.auth.id"),
+			ViewRule:   types.Pointer("session.user = @request.auth.id"),
+			CreateRule: nil,
+			UpdateRule: nil,
+			DeleteRule: nil,
+			Schema: schema.NewSchema(
+				&schema.SchemaField{
+					Name:     "session",
+					Type:     schema.FieldTypeRelation,
+					Required: true,
+					Options: &schema.RelationOptions{
+						CollectionId:  sessionsCollection.Id,
+						CascadeDelete: true,
+						MaxSelect:     types.Pointer(1),
+					},
+				},
+				&schema.SchemaField{
+					// Entry ID within the session, in order of recognition
+					Name:     "entry_id",
+					Type:     schema.FieldTypeNumber,
+					Required: true,
+					Options: &schema.NumberOptions{
+						Min:       types.Pointer(1.0),
+						NoDecimal: true,
+					},
+				},
+				&schema.SchemaField{
+					Name:     "start_time",
+					Type:     schema.FieldTypeNumber,
+					Required: false,
+					Options:  &schema.NumberOptions{},
+				},
+				&schema.SchemaField{
+					Name:     "end_time",
+					Type:     schema.FieldTypeNumber,
+					Required: false,
+					Options:  &schema.NumberOptions{},
+				},
+				&schema.SchemaField{
+					Name:     "text",
+					Type:     schema.FieldTypeText,
+					Required: true,
+					Options:  &schema.TextOptions{},
+				},
+				&schema.SchemaField{
+					Name:     "language",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options: &schema.TextOptions{
+						Max: types.Pointer(10),
+					},
+				},
+				&schema.SchemaField{
+					// Recognized text, when text is a translation
+					Name:     "original",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options:  &schema.TextOptions{},
+				},
+				&schema.SchemaField{
+					// Text in each target language
+					Name:     "translations",
+					Type:     schema.FieldTypeJson,
+					Required: false,
+					Options:  &schema.JsonOptions{MaxSize: 100000},
+				},
+			),
+			Indexes: types.JsonArray[string]{
+				"CREATE UNIQUE INDEX idx_subtitle_entries_session_entry ON subtitle_entries (session, entry_id)",
+			},
+		}
+
+		return dao.SaveCollection(entriesCollection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		for _, name := range []string{"subtitle_entries", "subtitle_sessions"} {
+			collection, err := dao.FindCollectionByNameOrId(name)
+			if err != nil {
+				continue
+			}
+			if err := dao.DeleteCollection(collection); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
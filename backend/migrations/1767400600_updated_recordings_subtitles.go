@@ -0,0 +1,44 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("recordings")
+		if err != nil {
+			return err
+		}
+
+		// Sidecar subtitles saved next to file_path, maintained by the server
+		if collection.Schema.GetFieldByName("subtitle_path") == nil {
+			collection.Schema.AddField(&schema.SchemaField{
+				Name:     "subtitle_path",
+				Type:     schema.FieldTypeText,
+				Required: false,
+				Options:  &schema.TextOptions{},
+			})
+		}
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("recordings")
+		if err != nil {
+			return nil
+		}
+
+		if field := collection.Schema.GetFieldByName("subtitle_path"); field != nil {
+			collection.Schema.RemoveField(field.Id)
+		}
+
+		return dao.SaveCollection(collection)
+	})
+}
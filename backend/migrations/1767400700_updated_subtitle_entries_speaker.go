@@ -0,0 +1,48 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("subtitle_entries")
+		if err != nil {
+			return err
+		}
+
+		// Speaker number from diarization, empty when not diarized
+		if collection.Schema.GetFieldByName("speaker") == nil {
+			collection.Schema.AddField(&schema.SchemaField{
+				Name:     "speaker",
+				Type:     schema.FieldTypeNumber,
+				Required: false,
+				Options: &schema.NumberOptions{
+					Min:       types.Pointer(0.0),
+					NoDecimal: true,
+				},
+			})
+		}
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("subtitle_entries")
+		if err != nil {
+			return nil
+		}
+
+		if field := collection.Schema.GetFieldByName("speaker"); field != nil {
+			collection.Schema.RemoveField(field.Id)
+		}
+
+		return dao.SaveCollection(collection)
+	})
+}
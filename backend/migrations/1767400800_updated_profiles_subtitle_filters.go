@@ -0,0 +1,45 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("profiles")
+		if err != nil {
+			return err
+		}
+
+		// Subtitle post-processing of the profile: mask_profanity,
+		// restore_casing and max_line_length. Kids profiles always mask.
+		if collection.Schema.GetFieldByName("subtitle_filters") == nil {
+			collection.Schema.AddField(&schema.SchemaField{
+				Name:     "subtitle_filters",
+				Type:     schema.FieldTypeJson,
+				Required: false,
+				Options:  &schema.JsonOptions{MaxSize: 1000},
+			})
+		}
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("profiles")
+		if err != nil {
+			return nil
+		}
+
+		if field := collection.Schema.GetFieldByName("subtitle_filters"); field != nil {
+			collection.Schema.RemoveField(field.Id)
+		}
+
+		return dao.SaveCollection(collection)
+	})
+}
@@ -0,0 +1,44 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("subtitle_entries")
+		if err != nil {
+			return err
+		}
+
+		// Word timings of the recognized text, empty when the engine doesn't report them
+		if collection.Schema.GetFieldByName("words") == nil {
+			collection.Schema.AddField(&schema.SchemaField{
+				Name:     "words",
+				Type:     schema.FieldTypeJson,
+				Required: false,
+				Options:  &schema.JsonOptions{MaxSize: 100000},
+			})
+		}
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("subtitle_entries")
+		if err != nil {
+			return nil
+		}
+
+		if field := collection.Schema.GetFieldByName("words"); field != nil {
+			collection.Schema.RemoveField(field.Id)
+		}
+
+		return dao.SaveCollection(collection)
+	})
+}
@@ -0,0 +1,45 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("users")
+		if err != nil {
+			return err
+		}
+
+		// Admin override of the storage quota in MB: 0 uses STORAGE_QUOTA_MB,
+		// -1 is unlimited
+		if collection.Schema.GetFieldByName("storage_quota_mb") == nil {
+			collection.Schema.AddField(&schema.SchemaField{
+				Name:     "storage_quota_mb",
+				Type:     schema.FieldTypeNumber,
+				Required: false,
+				Options:  &schema.NumberOptions{NoDecimal: true},
+			})
+		}
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("users")
+		if err != nil {
+			return nil
+		}
+
+		if field := collection.Schema.GetFieldByName("storage_quota_mb"); field != nil {
+			collection.Schema.RemoveField(field.Id)
+		}
+
+		return dao.SaveCollection(collection)
+	})
+}
@@ -0,0 +1,48 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("users")
+		if err != nil {
+			return err
+		}
+
+		// Users with the admin role may use operational and configuration
+		// endpoints. Only admins can change roles.
+		if collection.Schema.GetFieldByName("role") == nil {
+			collection.Schema.AddField(&schema.SchemaField{
+				Name:     "role",
+				Type:     schema.FieldTypeSelect,
+				Required: false,
+				Options: &schema.SelectOptions{
+					MaxSelect: 1,
+					Values:    []string{"user", "admin"},
+				},
+			})
+		}
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("users")
+		if err != nil {
+			return nil
+		}
+
+		if field := collection.Schema.GetFieldByName("role"); field != nil {
+			collection.Schema.RemoveField(field.Id)
+		}
+
+		return dao.SaveCollection(collection)
+	})
+}
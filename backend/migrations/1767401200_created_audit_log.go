@@ -0,0 +1,121 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		// Create audit_log collection (security-sensitive actions, written by
+		// the server and read by admins only)
+		auditCollection := &models.Collection{
+			Name: "audit_log",
+			Type: models.CollectionTypeBase,
+			Schema: schema.NewSchema(
+				&schema.SchemaField{
+					// totp.enable, totp.disable, totp.validate, auth.login,
+					// settings.ollama, recording.delete, playlist.import
+					Name:     "action",
+					Type:     schema.FieldTypeText,
+					Required: true,
+					Options: &schema.TextOptions{
+						Max: types.Pointer(50),
+					},
+				},
+				&schema.SchemaField{
+					// User ID, kept as text so entries outlive deleted users
+					Name:     "user",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options: &schema.TextOptions{
+						Max: types.Pointer(50),
+					},
+				},
+				&schema.SchemaField{
+					// PocketBase admin ID when an admin acted
+					Name:     "admin",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options: &schema.TextOptions{
+						Max: types.Pointer(50),
+					},
+				},
+				&schema.SchemaField{
+					// What the action applied to: a file, playlist or user ID
+					Name:     "target",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options: &schema.TextOptions{
+						Max: types.Pointer(300),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "success",
+					Type:     schema.FieldTypeBool,
+					Required: false,
+					Options:  &schema.BoolOptions{},
+				},
+				&schema.SchemaField{
+					// HTTP status of the response
+					Name:     "status",
+					Type:     schema.FieldTypeNumber,
+					Required: false,
+					Options:  &schema.NumberOptions{NoDecimal: true},
+				},
+				&schema.SchemaField{
+					Name:     "ip",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options: &schema.TextOptions{
+						Max: types.Pointer(100),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "user_agent",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options: &schema.TextOptions{
+						Max: types.Pointer(500),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "request_id",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options: &schema.TextOptions{
+						Max: types.Pointer(64),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "details",
+					Type:     schema.FieldTypeJson,
+					Required: false,
+					Options:  &schema.JsonOptions{MaxSize: 10000},
+				},
+			),
+			Indexes: types.JsonArray[string]{
+				"CREATE INDEX idx_audit_log_created ON audit_log (created)",
+				"CREATE INDEX idx_audit_log_action ON audit_log (action, created)",
+				"CREATE INDEX idx_audit_log_user ON audit_log (user, created)",
+			},
+		}
+
+		return dao.SaveCollection(auditCollection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("audit_log")
+		if err != nil {
+			return nil
+		}
+
+		return dao.DeleteCollection(collection)
+	})
+}
@@ -0,0 +1,100 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		usersCollection, err := dao.FindCollectionByNameOrId("users")
+		if err != nil {
+			return err
+		}
+
+		// Create api_keys collection (static keys for scripts and plugins).
+		// Managed through /api/keys so the hashes are never exposed.
+		apiKeysCollection := &models.Collection{
+			Name: "api_keys",
+			Type: models.CollectionTypeBase,
+			Schema: schema.NewSchema(
+				&schema.SchemaField{
+					Name:     "user",
+					Type:     schema.FieldTypeRelation,
+					Required: true,
+					Options: &schema.RelationOptions{
+						CollectionId:  usersCollection.Id,
+						CascadeDelete: true,
+						MaxSelect:     types.Pointer(1),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "name",
+					Type:     schema.FieldTypeText,
+					Required: true,
+					Options: &schema.TextOptions{
+						Max: types.Pointer(100),
+					},
+				},
+				&schema.SchemaField{
+					// SHA-256 of the key, the key itself is only shown once
+					Name:     "key_hash",
+					Type:     schema.FieldTypeText,
+					Required: true,
+					Options: &schema.TextOptions{
+						Max: types.Pointer(64),
+					},
+				},
+				&schema.SchemaField{
+					// First characters of the key, to tell keys apart
+					Name:     "prefix",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options: &schema.TextOptions{
+						Max: types.Pointer(20),
+					},
+				},
+				&schema.SchemaField{
+					// Capabilities: recorder, epg, export
+					Name:     "scopes",
+					Type:     schema.FieldTypeJson,
+					Required: false,
+					Options:  &schema.JsonOptions{MaxSize: 1000},
+				},
+				&schema.SchemaField{
+					Name:     "expires_at",
+					Type:     schema.FieldTypeDate,
+					Required: false,
+					Options:  &schema.DateOptions{},
+				},
+				&schema.SchemaField{
+					Name:     "last_used_at",
+					Type:     schema.FieldTypeDate,
+					Required: false,
+					Options:  &schema.DateOptions{},
+				},
+			),
+			Indexes: types.JsonArray[string]{
+				"CREATE UNIQUE INDEX idx_api_keys_hash ON api_keys (key_hash)",
+				"CREATE INDEX idx_api_keys_user ON api_keys (user)",
+			},
+		}
+
+		return dao.SaveCollection(apiKeysCollection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("api_keys")
+		if err != nil {
+			return nil
+		}
+
+		return dao.DeleteCollection(collection)
+	})
+}
@@ -0,0 +1,49 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("profiles")
+		if err != nil {
+			return err
+		}
+
+		// Channel groups (group_title) and channel ids hidden from the
+		// profile until its PIN is entered
+		for _, name := range []string{"blocked_groups", "blocked_channels"} {
+			if collection.Schema.GetFieldByName(name) == nil {
+				collection.Schema.AddField(&schema.SchemaField{
+					Name:     name,
+					Type:     schema.FieldTypeJson,
+					Required: false,
+					Options:  &schema.JsonOptions{MaxSize: 20000},
+				})
+			}
+		}
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("profiles")
+		if err != nil {
+			return nil
+		}
+
+		for _, name := range []string{"blocked_groups", "blocked_channels"} {
+			if field := collection.Schema.GetFieldByName(name); field != nil {
+				collection.Schema.RemoveField(field.Id)
+			}
+		}
+
+		return dao.SaveCollection(collection)
+	})
+}
@@ -0,0 +1,129 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		profiles, err := dao.FindCollectionByNameOrId("profiles")
+		if err != nil {
+			return err
+		}
+
+		// bcrypt hashes of profile PINs, no API rules so only the backend
+		// reads them
+		pins, err := dao.FindCollectionByNameOrId("profile_pins")
+		if err != nil {
+			pins = &models.Collection{
+				Name: "profile_pins",
+				Type: models.CollectionTypeBase,
+				Schema: schema.NewSchema(
+					&schema.SchemaField{
+						Name:     "profile",
+						Type:     schema.FieldTypeRelation,
+						Required: true,
+						Options: &schema.RelationOptions{
+							CollectionId:  profiles.Id,
+							CascadeDelete: true,
+							MaxSelect:     types.Pointer(1),
+						},
+					},
+					&schema.SchemaField{
+						Name:     "hash",
+						Type:     schema.FieldTypeText,
+						Required: true,
+						Options:  &schema.TextOptions{Max: types.Pointer(100)},
+					},
+				),
+				Indexes: types.JsonArray[string]{
+					"CREATE UNIQUE INDEX idx_profile_pins_profile ON profile_pins (profile)",
+				},
+			}
+			if err := dao.SaveCollection(pins); err != nil {
+				return err
+			}
+		}
+
+		// Whether the profile has a PIN, set by the backend
+		if profiles.Schema.GetFieldByName("has_pin") == nil {
+			profiles.Schema.AddField(&schema.SchemaField{
+				Name:     "has_pin",
+				Type:     schema.FieldTypeBool,
+				Required: false,
+				Options:  &schema.BoolOptions{},
+			})
+			if err := dao.SaveCollection(profiles); err != nil {
+				return err
+			}
+		}
+
+		// Hash the plaintext PINs and drop them
+		pinField := profiles.Schema.GetFieldByName("pin")
+		if pinField == nil {
+			return nil
+		}
+
+		records := []*models.Record{}
+		if err := dao.RecordQuery(profiles).AndWhere(dbx.NewExp("pin != ''")).All(&records); err != nil {
+			return err
+		}
+		for _, profile := range records {
+			hash, err := bcrypt.GenerateFromPassword([]byte(profile.GetString("pin")), bcrypt.DefaultCost)
+			if err != nil {
+				return err
+			}
+
+			pin := models.NewRecord(pins)
+			pin.Set("profile", profile.Id)
+			pin.Set("hash", string(hash))
+			if err := dao.SaveRecord(pin); err != nil {
+				return err
+			}
+
+			profile.Set("has_pin", true)
+			if err := dao.SaveRecord(profile); err != nil {
+				return err
+			}
+		}
+
+		profiles.Schema.RemoveField(pinField.Id)
+		return dao.SaveCollection(profiles)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		// Hashed PINs can't be restored, profiles come back without a PIN
+		if pins, err := dao.FindCollectionByNameOrId("profile_pins"); err == nil {
+			if err := dao.DeleteCollection(pins); err != nil {
+				return err
+			}
+		}
+
+		profiles, err := dao.FindCollectionByNameOrId("profiles")
+		if err != nil {
+			return nil
+		}
+
+		if profiles.Schema.GetFieldByName("pin") == nil {
+			profiles.Schema.AddField(&schema.SchemaField{
+				Name:     "pin",
+				Type:     schema.FieldTypeText,
+				Required: false,
+				Options:  &schema.TextOptions{Max: types.Pointer(4)},
+			})
+		}
+		if field := profiles.Schema.GetFieldByName("has_pin"); field != nil {
+			profiles.Schema.RemoveField(field.Id)
+		}
+
+		return dao.SaveCollection(profiles)
+	})
+}
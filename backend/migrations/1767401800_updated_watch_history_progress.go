@@ -0,0 +1,73 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("watch_history")
+		if err != nil {
+			return err
+		}
+
+		recordingsCollection, err := dao.FindCollectionByNameOrId("recordings")
+		if err != nil {
+			return err
+		}
+
+		// Entries are for a channel or a recording (VOD)
+		if field := collection.Schema.GetFieldByName("channel"); field != nil {
+			field.Required = false
+		}
+
+		if collection.Schema.GetFieldByName("recording") == nil {
+			collection.Schema.AddField(&schema.SchemaField{
+				Name:     "recording",
+				Type:     schema.FieldTypeRelation,
+				Required: false,
+				Options: &schema.RelationOptions{
+					CollectionId:  recordingsCollection.Id,
+					CascadeDelete: true,
+					MaxSelect:     types.Pointer(1),
+				},
+			})
+		}
+
+		// Playback position in seconds, duration is the item's length
+		if collection.Schema.GetFieldByName("position") == nil {
+			collection.Schema.AddField(&schema.SchemaField{
+				Name:     "position",
+				Type:     schema.FieldTypeNumber,
+				Required: false,
+				Options:  &schema.NumberOptions{Min: types.Pointer(0.0)},
+			})
+		}
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("watch_history")
+		if err != nil {
+			return nil
+		}
+
+		for _, name := range []string{"recording", "position"} {
+			if field := collection.Schema.GetFieldByName(name); field != nil {
+				collection.Schema.RemoveField(field.Id)
+			}
+		}
+		if field := collection.Schema.GetFieldByName("channel"); field != nil {
+			field.Required = true
+		}
+
+		return dao.SaveCollection(collection)
+	})
+}
@@ -0,0 +1,99 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		profilesCollection, err := dao.FindCollectionByNameOrId("profiles")
+		if err != nil {
+			return err
+		}
+
+		channelsCollection, err := dao.FindCollectionByNameOrId("channels")
+		if err != nil {
+			return err
+		}
+
+		recordingsCollection, err := dao.FindCollectionByNameOrId("recordings")
+		if err != nil {
+			return err
+		}
+
+		// Create watch_time collection (seconds watched per profile, item and
+		// hour, filled from playback reports). Read through /api/stats/watch.
+		watchTimeCollection := &models.Collection{
+			Name: "watch_time",
+			Type: models.CollectionTypeBase,
+			Schema: schema.NewSchema(
+				&schema.SchemaField{
+					Name:     "profile",
+					Type:     schema.FieldTypeRelation,
+					Required: true,
+					Options: &schema.RelationOptions{
+						CollectionId:  profilesCollection.Id,
+						CascadeDelete: true,
+						MaxSelect:     types.Pointer(1),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "channel",
+					Type:     schema.FieldTypeRelation,
+					Required: false,
+					Options: &schema.RelationOptions{
+						CollectionId:  channelsCollection.Id,
+						CascadeDelete: true,
+						MaxSelect:     types.Pointer(1),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "recording",
+					Type:     schema.FieldTypeRelation,
+					Required: false,
+					Options: &schema.RelationOptions{
+						CollectionId:  recordingsCollection.Id,
+						CascadeDelete: true,
+						MaxSelect:     types.Pointer(1),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "hour",
+					Type:     schema.FieldTypeDate,
+					Required: true,
+					Options:  &schema.DateOptions{},
+				},
+				&schema.SchemaField{
+					Name:     "seconds",
+					Type:     schema.FieldTypeNumber,
+					Required: false,
+					Options: &schema.NumberOptions{
+						Min: types.Pointer(0.0),
+					},
+				},
+			),
+			Indexes: types.JsonArray[string]{
+				"CREATE INDEX idx_watch_time_profile_hour ON watch_time (profile, hour)",
+				"CREATE INDEX idx_watch_time_hour ON watch_time (hour)",
+			},
+		}
+
+		return dao.SaveCollection(watchTimeCollection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("watch_time")
+		if err != nil {
+			return nil
+		}
+
+		return dao.DeleteCollection(collection)
+	})
+}
@@ -0,0 +1,200 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		usersCollection, err := dao.FindCollectionByNameOrId("users")
+		if err != nil {
+			return err
+		}
+
+		// Create epg_sources collection (XMLTV guides of a user)
+		// Note: Using ~ instead of = for relation fields as they are stored as arrays
+		sourcesCollection, _ := dao.FindCollectionByNameOrId("epg_sources")
+		if sourcesCollection == nil {
+			sourcesCollection = &models.Collection{
+				Name:       "epg_sources",
+				Type:       models.CollectionTypeBase,
+				ListRule:   types.Pointer("user ~ @request.auth.id"),
+				ViewRule:   types.Pointer("user ~ @request.auth.id"),
+				CreateRule: types.Pointer("@request.auth.id != ''"),
+				UpdateRule: types.Pointer("user ~ @request.auth.id"),
+				DeleteRule: types.Pointer("user ~ @request.auth.id"),
+				Schema: schema.NewSchema(
+					&schema.SchemaField{
+						Name:     "user",
+						Type:     schema.FieldTypeRelation,
+						Required: true,
+						Options: &schema.RelationOptions{
+							CollectionId:  usersCollection.Id,
+							CascadeDelete: true,
+						},
+					},
+					&schema.SchemaField{
+						Name:     "name",
+						Type:     schema.FieldTypeText,
+						Required: true,
+						Options: &schema.TextOptions{
+							Min: types.Pointer(1),
+							Max: types.Pointer(100),
+						},
+					},
+					&schema.SchemaField{
+						Name:     "url",
+						Type:     schema.FieldTypeUrl,
+						Required: false,
+						Options:  &schema.UrlOptions{},
+					},
+					&schema.SchemaField{
+						Name:     "is_active",
+						Type:     schema.FieldTypeBool,
+						Required: false,
+						Options:  &schema.BoolOptions{},
+					},
+					&schema.SchemaField{
+						Name:     "last_synced",
+						Type:     schema.FieldTypeDate,
+						Required: false,
+						Options:  &schema.DateOptions{},
+					},
+					&schema.SchemaField{
+						Name:     "sync_interval",
+						Type:     schema.FieldTypeNumber,
+						Required: false,
+						Options: &schema.NumberOptions{
+							Min: types.Pointer(0.0),
+						},
+					},
+				),
+			}
+
+			if err := dao.SaveCollection(sourcesCollection); err != nil {
+				return err
+			}
+		}
+
+		// Create epg_programs collection. channel_id is the XMLTV channel
+		// id, matched against the tvg_id of channels.
+		if _, err := dao.FindCollectionByNameOrId("epg_programs"); err == nil {
+			return nil
+		}
+
+		programsCollection := &models.Collection{
+			Name:       "epg_programs",
+			Type:       models.CollectionTypeBase,
+			ListRule:   types.Pointer("source.user ~ @request.auth.id"),
+			ViewRule:   types.Pointer("source.user ~ @request.auth.id"),
+			CreateRule: types.Pointer("@request.auth.id != ''"),
+			UpdateRule: types.Pointer("source.user ~ @request.auth.id"),
+			DeleteRule: types.Pointer("source.user ~ @request.auth.id"),
+			Schema: schema.NewSchema(
+				&schema.SchemaField{
+					Name:     "source",
+					Type:     schema.FieldTypeRelation,
+					Required: true,
+					Options: &schema.RelationOptions{
+						CollectionId:  sourcesCollection.Id,
+						CascadeDelete: true,
+						MaxSelect:     types.Pointer(1),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "channel_id",
+					Type:     schema.FieldTypeText,
+					Required: true,
+					Options: &schema.TextOptions{
+						Max: types.Pointer(200),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "title",
+					Type:     schema.FieldTypeText,
+					Required: true,
+					Options: &schema.TextOptions{
+						Max: types.Pointer(500),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "description",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options: &schema.TextOptions{
+						Max: types.Pointer(5000),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "start_time",
+					Type:     schema.FieldTypeDate,
+					Required: true,
+					Options:  &schema.DateOptions{},
+				},
+				&schema.SchemaField{
+					Name:     "end_time",
+					Type:     schema.FieldTypeDate,
+					Required: true,
+					Options:  &schema.DateOptions{},
+				},
+				&schema.SchemaField{
+					Name:     "category",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options: &schema.TextOptions{
+						Max: types.Pointer(200),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "icon",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options: &schema.TextOptions{
+						Max: types.Pointer(2000),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "rating",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options: &schema.TextOptions{
+						Max: types.Pointer(50),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "episode",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options: &schema.TextOptions{
+						Max: types.Pointer(100),
+					},
+				},
+			),
+			Indexes: types.JsonArray[string]{
+				"CREATE INDEX idx_epg_programs_channel_start ON epg_programs (channel_id, start_time)",
+				"CREATE INDEX idx_epg_programs_end ON epg_programs (end_time)",
+			},
+		}
+
+		return dao.SaveCollection(programsCollection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		for _, name := range []string{"epg_programs", "epg_sources"} {
+			if collection, err := dao.FindCollectionByNameOrId(name); err == nil {
+				if err := dao.DeleteCollection(collection); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+}
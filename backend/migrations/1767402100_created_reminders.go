@@ -0,0 +1,147 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		usersCollection, err := dao.FindCollectionByNameOrId("users")
+		if err != nil {
+			return err
+		}
+
+		profilesCollection, err := dao.FindCollectionByNameOrId("profiles")
+		if err != nil {
+			return err
+		}
+
+		channelsCollection, err := dao.FindCollectionByNameOrId("channels")
+		if err != nil {
+			return err
+		}
+
+		programsCollection, err := dao.FindCollectionByNameOrId("epg_programs")
+		if err != nil {
+			return err
+		}
+
+		// Create reminders collection (notify a user before a programme
+		// starts). Created through /api/reminders; users can list, watch in
+		// realtime and delete theirs.
+		remindersCollection := &models.Collection{
+			Name:       "reminders",
+			Type:       models.CollectionTypeBase,
+			ListRule:   types.Pointer("user = @request.auth.id"),
+			ViewRule:   types.Pointer("user = @request.auth.id"),
+			DeleteRule: types.Pointer("user = @request.auth.id"),
+			Schema: schema.NewSchema(
+				&schema.SchemaField{
+					Name:     "user",
+					Type:     schema.FieldTypeRelation,
+					Required: true,
+					Options: &schema.RelationOptions{
+						CollectionId:  usersCollection.Id,
+						CascadeDelete: true,
+						MaxSelect:     types.Pointer(1),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "profile",
+					Type:     schema.FieldTypeRelation,
+					Required: false,
+					Options: &schema.RelationOptions{
+						CollectionId:  profilesCollection.Id,
+						CascadeDelete: true,
+						MaxSelect:     types.Pointer(1),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "program",
+					Type:     schema.FieldTypeRelation,
+					Required: true,
+					Options: &schema.RelationOptions{
+						CollectionId:  programsCollection.Id,
+						CascadeDelete: true,
+						MaxSelect:     types.Pointer(1),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "channel",
+					Type:     schema.FieldTypeRelation,
+					Required: false,
+					Options: &schema.RelationOptions{
+						CollectionId:  channelsCollection.Id,
+						CascadeDelete: true,
+						MaxSelect:     types.Pointer(1),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "title",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options: &schema.TextOptions{
+						Max: types.Pointer(500),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "start_time",
+					Type:     schema.FieldTypeDate,
+					Required: true,
+					Options:  &schema.DateOptions{},
+				},
+				&schema.SchemaField{
+					Name:     "minutes_before",
+					Type:     schema.FieldTypeNumber,
+					Required: false,
+					Options: &schema.NumberOptions{
+						Min: types.Pointer(0.0),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "remind_at",
+					Type:     schema.FieldTypeDate,
+					Required: true,
+					Options:  &schema.DateOptions{},
+				},
+				&schema.SchemaField{
+					// pending, sent, missed
+					Name:     "status",
+					Type:     schema.FieldTypeText,
+					Required: true,
+					Options: &schema.TextOptions{
+						Max: types.Pointer(20),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "sent_at",
+					Type:     schema.FieldTypeDate,
+					Required: false,
+					Options:  &schema.DateOptions{},
+				},
+			),
+			Indexes: types.JsonArray[string]{
+				"CREATE UNIQUE INDEX idx_reminders_user_program ON reminders (user, program)",
+				"CREATE INDEX idx_reminders_status_remind_at ON reminders (status, remind_at)",
+			},
+		}
+
+		return dao.SaveCollection(remindersCollection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("reminders")
+		if err != nil {
+			return nil
+		}
+
+		return dao.DeleteCollection(collection)
+	})
+}
@@ -0,0 +1,72 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("channels")
+		if err != nil {
+			return err
+		}
+
+		// Duplicates merged into a logical channel point at its primary
+		// record, priority orders the sources of a logical channel
+		if collection.Schema.GetFieldByName("merged_into") == nil {
+			collection.Schema.AddField(&schema.SchemaField{
+				Name:     "merged_into",
+				Type:     schema.FieldTypeRelation,
+				Required: false,
+				Options: &schema.RelationOptions{
+					CollectionId:  collection.Id,
+					CascadeDelete: false,
+					MaxSelect:     types.Pointer(1),
+				},
+			})
+		}
+
+		if collection.Schema.GetFieldByName("priority") == nil {
+			collection.Schema.AddField(&schema.SchemaField{
+				Name:     "priority",
+				Type:     schema.FieldTypeNumber,
+				Required: false,
+				Options:  &schema.NumberOptions{Min: types.Pointer(0.0)},
+			})
+		}
+
+		collection.Indexes = append(collection.Indexes,
+			"CREATE INDEX `idx_channels_merged_into` ON `channels` (`merged_into`)")
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("channels")
+		if err != nil {
+			return nil
+		}
+
+		indexes := collection.Indexes[:0]
+		for _, index := range collection.Indexes {
+			if index != "CREATE INDEX `idx_channels_merged_into` ON `channels` (`merged_into`)" {
+				indexes = append(indexes, index)
+			}
+		}
+		collection.Indexes = indexes
+
+		for _, name := range []string{"merged_into", "priority"} {
+			if field := collection.Schema.GetFieldByName(name); field != nil {
+				collection.Schema.RemoveField(field.Id)
+			}
+		}
+
+		return dao.SaveCollection(collection)
+	})
+}
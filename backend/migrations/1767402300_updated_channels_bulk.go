@@ -0,0 +1,74 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("channels")
+		if err != nil {
+			return err
+		}
+
+		// Channels created by the initial migration lack the fields the
+		// bootstrap schema has
+		addActive := collection.Schema.GetFieldByName("is_active") == nil
+		if addActive {
+			collection.Schema.AddField(&schema.SchemaField{
+				Name:     "is_active",
+				Type:     schema.FieldTypeBool,
+				Required: false,
+				Options:  &schema.BoolOptions{},
+			})
+		}
+
+		if collection.Schema.GetFieldByName("sort_order") == nil {
+			collection.Schema.AddField(&schema.SchemaField{
+				Name:     "sort_order",
+				Type:     schema.FieldTypeNumber,
+				Required: false,
+				Options:  &schema.NumberOptions{},
+			})
+		}
+
+		// Set when the group or position of a channel is changed in bulk,
+		// playlist syncs then keep them
+		if collection.Schema.GetFieldByName("locked") == nil {
+			collection.Schema.AddField(&schema.SchemaField{
+				Name:     "locked",
+				Type:     schema.FieldTypeBool,
+				Required: false,
+				Options:  &schema.BoolOptions{},
+			})
+		}
+
+		if err := dao.SaveCollection(collection); err != nil {
+			return err
+		}
+
+		// Existing channels stay visible
+		if addActive {
+			_, err = db.NewQuery("UPDATE {{channels}} SET [[is_active]] = TRUE").Execute()
+		}
+		return err
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("channels")
+		if err != nil {
+			return nil
+		}
+
+		if field := collection.Schema.GetFieldByName("locked"); field != nil {
+			collection.Schema.RemoveField(field.Id)
+		}
+
+		return dao.SaveCollection(collection)
+	})
+}
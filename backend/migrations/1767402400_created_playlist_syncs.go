@@ -0,0 +1,159 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		usersCollection, err := dao.FindCollectionByNameOrId("users")
+		if err != nil {
+			return err
+		}
+
+		playlistsCollection, err := dao.FindCollectionByNameOrId("playlists")
+		if err != nil {
+			return err
+		}
+
+		// Create playlist_syncs collection (history of playlist imports, with
+		// what each one changed). Written by the import job; users can list
+		// theirs.
+		syncsCollection := &models.Collection{
+			Name:     "playlist_syncs",
+			Type:     models.CollectionTypeBase,
+			ListRule: types.Pointer("user = @request.auth.id"),
+			ViewRule: types.Pointer("user = @request.auth.id"),
+			Schema: schema.NewSchema(
+				&schema.SchemaField{
+					Name:     "playlist",
+					Type:     schema.FieldTypeRelation,
+					Required: true,
+					Options: &schema.RelationOptions{
+						CollectionId:  playlistsCollection.Id,
+						CascadeDelete: true,
+						MaxSelect:     types.Pointer(1),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "user",
+					Type:     schema.FieldTypeRelation,
+					Required: true,
+					Options: &schema.RelationOptions{
+						CollectionId:  usersCollection.Id,
+						CascadeDelete: true,
+						MaxSelect:     types.Pointer(1),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "job",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options: &schema.TextOptions{
+						Max: types.Pointer(50),
+					},
+				},
+				&schema.SchemaField{
+					// completed, failed
+					Name:     "status",
+					Type:     schema.FieldTypeText,
+					Required: true,
+					Options: &schema.TextOptions{
+						Max: types.Pointer(20),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "error",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options: &schema.TextOptions{
+						Max: types.Pointer(2000),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "parsed",
+					Type:     schema.FieldTypeNumber,
+					Required: false,
+					Options:  &schema.NumberOptions{Min: types.Pointer(0.0)},
+				},
+				&schema.SchemaField{
+					Name:     "inserted",
+					Type:     schema.FieldTypeNumber,
+					Required: false,
+					Options:  &schema.NumberOptions{Min: types.Pointer(0.0)},
+				},
+				&schema.SchemaField{
+					// Channels updated, "updated" is the record's own timestamp
+					Name:     "changed",
+					Type:     schema.FieldTypeNumber,
+					Required: false,
+					Options:  &schema.NumberOptions{Min: types.Pointer(0.0)},
+				},
+				&schema.SchemaField{
+					Name:     "unchanged",
+					Type:     schema.FieldTypeNumber,
+					Required: false,
+					Options:  &schema.NumberOptions{Min: types.Pointer(0.0)},
+				},
+				&schema.SchemaField{
+					Name:     "removed",
+					Type:     schema.FieldTypeNumber,
+					Required: false,
+					Options:  &schema.NumberOptions{Min: types.Pointer(0.0)},
+				},
+				&schema.SchemaField{
+					Name:     "missing",
+					Type:     schema.FieldTypeNumber,
+					Required: false,
+					Options:  &schema.NumberOptions{Min: types.Pointer(0.0)},
+				},
+				&schema.SchemaField{
+					Name:     "failed",
+					Type:     schema.FieldTypeNumber,
+					Required: false,
+					Options:  &schema.NumberOptions{Min: types.Pointer(0.0)},
+				},
+				&schema.SchemaField{
+					// Channels added, changed, removed and missing
+					Name:     "diff",
+					Type:     schema.FieldTypeJson,
+					Required: false,
+					Options:  &schema.JsonOptions{MaxSize: 2000000},
+				},
+				&schema.SchemaField{
+					Name:     "started_at",
+					Type:     schema.FieldTypeDate,
+					Required: false,
+					Options:  &schema.DateOptions{},
+				},
+				&schema.SchemaField{
+					Name:     "finished_at",
+					Type:     schema.FieldTypeDate,
+					Required: false,
+					Options:  &schema.DateOptions{},
+				},
+			),
+			Indexes: types.JsonArray[string]{
+				"CREATE INDEX idx_playlist_syncs_playlist_created ON playlist_syncs (playlist, created)",
+			},
+		}
+
+		return dao.SaveCollection(syncsCollection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("playlist_syncs")
+		if err != nil {
+			return nil
+		}
+
+		return dao.DeleteCollection(collection)
+	})
+}
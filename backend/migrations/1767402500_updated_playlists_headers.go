@@ -0,0 +1,73 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("playlists")
+		if err != nil {
+			return err
+		}
+
+		// Headers some providers require on every request, sent when
+		// fetching the playlist, proxying its streams and by ffmpeg
+		if collection.Schema.GetFieldByName("user_agent") == nil {
+			collection.Schema.AddField(&schema.SchemaField{
+				Name:     "user_agent",
+				Type:     schema.FieldTypeText,
+				Required: false,
+				Options: &schema.TextOptions{
+					Max: types.Pointer(500),
+				},
+			})
+		}
+
+		if collection.Schema.GetFieldByName("referrer") == nil {
+			collection.Schema.AddField(&schema.SchemaField{
+				Name:     "referrer",
+				Type:     schema.FieldTypeText,
+				Required: false,
+				Options: &schema.TextOptions{
+					Max: types.Pointer(2000),
+				},
+			})
+		}
+
+		// Other headers, as an object of header names to values
+		if collection.Schema.GetFieldByName("headers") == nil {
+			collection.Schema.AddField(&schema.SchemaField{
+				Name:     "headers",
+				Type:     schema.FieldTypeJson,
+				Required: false,
+				Options: &schema.JsonOptions{
+					MaxSize: 20000,
+				},
+			})
+		}
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("playlists")
+		if err != nil {
+			return nil
+		}
+
+		for _, name := range []string{"user_agent", "referrer", "headers"} {
+			if field := collection.Schema.GetFieldByName(name); field != nil {
+				collection.Schema.RemoveField(field.Id)
+			}
+		}
+
+		return dao.SaveCollection(collection)
+	})
+}
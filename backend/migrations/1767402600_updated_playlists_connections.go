@@ -0,0 +1,49 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("playlists")
+		if err != nil {
+			return err
+		}
+
+		// Connections the provider allows at once, shared by recordings and
+		// viewers; 0 is unlimited
+		if collection.Schema.GetFieldByName("max_connections") == nil {
+			collection.Schema.AddField(&schema.SchemaField{
+				Name:     "max_connections",
+				Type:     schema.FieldTypeNumber,
+				Required: false,
+				Options: &schema.NumberOptions{
+					Min:       types.Pointer(0.0),
+					NoDecimal: true,
+				},
+			})
+		}
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("playlists")
+		if err != nil {
+			return nil
+		}
+
+		if field := collection.Schema.GetFieldByName("max_connections"); field != nil {
+			collection.Schema.RemoveField(field.Id)
+		}
+
+		return dao.SaveCollection(collection)
+	})
+}
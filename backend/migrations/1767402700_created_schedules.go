@@ -0,0 +1,232 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		usersCollection, err := dao.FindCollectionByNameOrId("users")
+		if err != nil {
+			return err
+		}
+
+		profilesCollection, err := dao.FindCollectionByNameOrId("profiles")
+		if err != nil {
+			return err
+		}
+
+		channelsCollection, err := dao.FindCollectionByNameOrId("channels")
+		if err != nil {
+			return err
+		}
+
+		// Create schedules collection (recurring recordings, expanded into
+		// scheduled recordings a week ahead by the scheduler)
+		schedulesCollection := &models.Collection{
+			Name:       "schedules",
+			Type:       models.CollectionTypeBase,
+			ListRule:   types.Pointer("user = @request.auth.id"),
+			ViewRule:   types.Pointer("user = @request.auth.id"),
+			CreateRule: types.Pointer("@request.auth.id != '' && @request.data.user = @request.auth.id"),
+			UpdateRule: types.Pointer("user = @request.auth.id && (@request.data.user:isset = false || @request.data.user = @request.auth.id)"),
+			DeleteRule: types.Pointer("user = @request.auth.id"),
+			Schema: schema.NewSchema(
+				&schema.SchemaField{
+					Name:     "user",
+					Type:     schema.FieldTypeRelation,
+					Required: true,
+					Options: &schema.RelationOptions{
+						CollectionId:  usersCollection.Id,
+						CascadeDelete: true,
+						MaxSelect:     types.Pointer(1),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "profile",
+					Type:     schema.FieldTypeRelation,
+					Required: true,
+					Options: &schema.RelationOptions{
+						CollectionId:  profilesCollection.Id,
+						CascadeDelete: true,
+						MaxSelect:     types.Pointer(1),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "channel",
+					Type:     schema.FieldTypeRelation,
+					Required: true,
+					Options: &schema.RelationOptions{
+						CollectionId:  channelsCollection.Id,
+						CascadeDelete: true,
+						MaxSelect:     types.Pointer(1),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "title",
+					Type:     schema.FieldTypeText,
+					Required: true,
+					Options: &schema.TextOptions{
+						Max: types.Pointer(200),
+					},
+				},
+				&schema.SchemaField{
+					// daily, weekly (on days)
+					Name:     "frequency",
+					Type:     schema.FieldTypeSelect,
+					Required: true,
+					Options: &schema.SelectOptions{
+						MaxSelect: 1,
+						Values:    []string{"daily", "weekly"},
+					},
+				},
+				&schema.SchemaField{
+					Name:     "days",
+					Type:     schema.FieldTypeSelect,
+					Required: false,
+					Options: &schema.SelectOptions{
+						MaxSelect: 7,
+						Values:    []string{"mon", "tue", "wed", "thu", "fri", "sat", "sun"},
+					},
+				},
+				&schema.SchemaField{
+					// HH:MM in timezone
+					Name:     "start_time",
+					Type:     schema.FieldTypeText,
+					Required: true,
+					Options: &schema.TextOptions{
+						Pattern: `^([01]\d|2[0-3]):[0-5]\d$`,
+					},
+				},
+				&schema.SchemaField{
+					// Minutes
+					Name:     "duration",
+					Type:     schema.FieldTypeNumber,
+					Required: true,
+					Options: &schema.NumberOptions{
+						Min:       types.Pointer(1.0),
+						Max:       types.Pointer(1440.0),
+						NoDecimal: true,
+					},
+				},
+				&schema.SchemaField{
+					// IANA time zone such as Europe/Paris, UTC when empty
+					Name:     "timezone",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options: &schema.TextOptions{
+						Max: types.Pointer(64),
+					},
+				},
+				&schema.SchemaField{
+					// First and last days recorded (YYYY-MM-DD), unbounded when empty
+					Name:     "start_date",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options: &schema.TextOptions{
+						Pattern: `^\d{4}-\d{2}-\d{2}$`,
+					},
+				},
+				&schema.SchemaField{
+					Name:     "end_date",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options: &schema.TextOptions{
+						Pattern: `^\d{4}-\d{2}-\d{2}$`,
+					},
+				},
+				&schema.SchemaField{
+					// Days not recorded, as YYYY-MM-DD
+					Name:     "skip_dates",
+					Type:     schema.FieldTypeJson,
+					Required: false,
+					Options: &schema.JsonOptions{
+						MaxSize: 20000,
+					},
+				},
+				&schema.SchemaField{
+					Name:     "is_active",
+					Type:     schema.FieldTypeBool,
+					Required: false,
+					Options:  &schema.BoolOptions{},
+				},
+				&schema.SchemaField{
+					// Occurrences starting before it have been created, set by
+					// the scheduler
+					Name:     "expanded_until",
+					Type:     schema.FieldTypeDate,
+					Required: false,
+					Options:  &schema.DateOptions{},
+				},
+			),
+			Indexes: types.JsonArray[string]{
+				"CREATE INDEX idx_schedules_active ON schedules (is_active, expanded_until)",
+			},
+		}
+
+		if err := dao.SaveCollection(schedulesCollection); err != nil {
+			return err
+		}
+
+		recordings, err := dao.FindCollectionByNameOrId("recordings")
+		if err != nil {
+			return err
+		}
+
+		// Occurrences of a schedule keep it; deleting the schedule removes
+		// those that haven't started
+		if recordings.Schema.GetFieldByName("schedule") == nil {
+			recordings.Schema.AddField(&schema.SchemaField{
+				Name:     "schedule",
+				Type:     schema.FieldTypeRelation,
+				Required: false,
+				Options: &schema.RelationOptions{
+					CollectionId:  schedulesCollection.Id,
+					CascadeDelete: false,
+					MaxSelect:     types.Pointer(1),
+				},
+			})
+		}
+
+		// Why a recording failed, or waits to start
+		if recordings.Schema.GetFieldByName("error") == nil {
+			recordings.Schema.AddField(&schema.SchemaField{
+				Name:     "error",
+				Type:     schema.FieldTypeText,
+				Required: false,
+				Options: &schema.TextOptions{
+					Max: types.Pointer(2000),
+				},
+			})
+		}
+
+		return dao.SaveCollection(recordings)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		if recordings, err := dao.FindCollectionByNameOrId("recordings"); err == nil {
+			for _, name := range []string{"schedule", "error"} {
+				if field := recordings.Schema.GetFieldByName(name); field != nil {
+					recordings.Schema.RemoveField(field.Id)
+				}
+			}
+			if err := dao.SaveCollection(recordings); err != nil {
+				return err
+			}
+		}
+
+		collection, err := dao.FindCollectionByNameOrId("schedules")
+		if err != nil {
+			return nil
+		}
+
+		return dao.DeleteCollection(collection)
+	})
+}
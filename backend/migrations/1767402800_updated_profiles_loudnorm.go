@@ -0,0 +1,58 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("profiles")
+		if err != nil {
+			return err
+		}
+
+		// Normalize the loudness of the profile's recordings (EBU R128), to
+		// recording_loudness LUFS or -23 when 0
+		if collection.Schema.GetFieldByName("recording_loudnorm") == nil {
+			collection.Schema.AddField(&schema.SchemaField{
+				Name:     "recording_loudnorm",
+				Type:     schema.FieldTypeBool,
+				Required: false,
+			})
+		}
+		if collection.Schema.GetFieldByName("recording_loudness") == nil {
+			collection.Schema.AddField(&schema.SchemaField{
+				Name:     "recording_loudness",
+				Type:     schema.FieldTypeNumber,
+				Required: false,
+				Options: &schema.NumberOptions{
+					Min: types.Pointer(-70.0),
+					Max: types.Pointer(-5.0),
+				},
+			})
+		}
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("profiles")
+		if err != nil {
+			return nil
+		}
+
+		for _, name := range []string{"recording_loudnorm", "recording_loudness"} {
+			if field := collection.Schema.GetFieldByName(name); field != nil {
+				collection.Schema.RemoveField(field.Id)
+			}
+		}
+
+		return dao.SaveCollection(collection)
+	})
+}
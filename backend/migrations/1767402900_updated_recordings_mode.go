@@ -0,0 +1,60 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		// What scheduled recordings keep: the video, or the audio only as
+		// AAC, MP3 or Opus. Empty records the video.
+		for _, name := range []string{"recordings", "schedules"} {
+			collection, err := dao.FindCollectionByNameOrId(name)
+			if err != nil {
+				return err
+			}
+
+			if collection.Schema.GetFieldByName("mode") == nil {
+				collection.Schema.AddField(&schema.SchemaField{
+					Name:     "mode",
+					Type:     schema.FieldTypeSelect,
+					Required: false,
+					Options: &schema.SelectOptions{
+						MaxSelect: 1,
+						Values:    []string{"video", "aac", "mp3", "opus"},
+					},
+				})
+			}
+
+			if err := dao.SaveCollection(collection); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		for _, name := range []string{"recordings", "schedules"} {
+			collection, err := dao.FindCollectionByNameOrId(name)
+			if err != nil {
+				continue
+			}
+
+			if field := collection.Schema.GetFieldByName("mode"); field != nil {
+				collection.Schema.RemoveField(field.Id)
+			}
+
+			if err := dao.SaveCollection(collection); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
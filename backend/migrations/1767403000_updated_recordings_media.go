@@ -0,0 +1,80 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("recordings")
+		if err != nil {
+			return err
+		}
+
+		// What ffprobe found in the finished file, maintained by the server.
+		// probed_at is set once it ran, even when it found nothing.
+		for _, name := range []string{"duration", "width", "height"} {
+			if collection.Schema.GetFieldByName(name) == nil {
+				collection.Schema.AddField(&schema.SchemaField{
+					Name:     name,
+					Type:     schema.FieldTypeNumber,
+					Required: false,
+					Options:  &schema.NumberOptions{Min: types.Pointer(0.0)},
+				})
+			}
+		}
+		for _, name := range []string{"video_codec", "audio_codec"} {
+			if collection.Schema.GetFieldByName(name) == nil {
+				collection.Schema.AddField(&schema.SchemaField{
+					Name:     name,
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options:  &schema.TextOptions{},
+				})
+			}
+		}
+		if collection.Schema.GetFieldByName("probed_at") == nil {
+			collection.Schema.AddField(&schema.SchemaField{
+				Name:     "probed_at",
+				Type:     schema.FieldTypeDate,
+				Required: false,
+				Options:  &schema.DateOptions{},
+			})
+		}
+
+		// Recordings are looked up by file
+		collection.Indexes = append(collection.Indexes,
+			"CREATE INDEX `idx_recordings_file_path` ON `recordings` (`file_path`)")
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("recordings")
+		if err != nil {
+			return nil
+		}
+
+		indexes := collection.Indexes[:0]
+		for _, index := range collection.Indexes {
+			if index != "CREATE INDEX `idx_recordings_file_path` ON `recordings` (`file_path`)" {
+				indexes = append(indexes, index)
+			}
+		}
+		collection.Indexes = indexes
+
+		for _, name := range []string{"duration", "width", "height", "video_codec", "audio_codec", "probed_at"} {
+			if field := collection.Schema.GetFieldByName(name); field != nil {
+				collection.Schema.RemoveField(field.Id)
+			}
+		}
+
+		return dao.SaveCollection(collection)
+	})
+}
@@ -0,0 +1,56 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("recordings")
+		if err != nil {
+			return err
+		}
+
+		// Chapter markers of the finished file, from the guide and ad break
+		// detection, maintained by the server. chapters_at is set once they
+		// were generated, even when there are none.
+		if collection.Schema.GetFieldByName("chapters") == nil {
+			collection.Schema.AddField(&schema.SchemaField{
+				Name:     "chapters",
+				Type:     schema.FieldTypeJson,
+				Required: false,
+				Options:  &schema.JsonOptions{MaxSize: 200000},
+			})
+		}
+		if collection.Schema.GetFieldByName("chapters_at") == nil {
+			collection.Schema.AddField(&schema.SchemaField{
+				Name:     "chapters_at",
+				Type:     schema.FieldTypeDate,
+				Required: false,
+				Options:  &schema.DateOptions{},
+			})
+		}
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("recordings")
+		if err != nil {
+			return nil
+		}
+
+		for _, name := range []string{"chapters", "chapters_at"} {
+			if field := collection.Schema.GetFieldByName(name); field != nil {
+				collection.Schema.RemoveField(field.Id)
+			}
+		}
+
+		return dao.SaveCollection(collection)
+	})
+}
@@ -0,0 +1,74 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		recordings, err := dao.FindCollectionByNameOrId("recordings")
+		if err != nil {
+			return err
+		}
+
+		// Probable ad segments found with the chapters, maintained by the
+		// server
+		if recordings.Schema.GetFieldByName("ad_breaks") == nil {
+			recordings.Schema.AddField(&schema.SchemaField{
+				Name:     "ad_breaks",
+				Type:     schema.FieldTypeJson,
+				Required: false,
+				Options:  &schema.JsonOptions{MaxSize: 200000},
+			})
+		}
+		if err := dao.SaveCollection(recordings); err != nil {
+			return err
+		}
+
+		profiles, err := dao.FindCollectionByNameOrId("profiles")
+		if err != nil {
+			return err
+		}
+
+		// Cut the ads found out of the profile's recordings, into a copy or
+		// in place of the original; empty leaves them
+		if profiles.Schema.GetFieldByName("recording_ad_cut") == nil {
+			profiles.Schema.AddField(&schema.SchemaField{
+				Name:     "recording_ad_cut",
+				Type:     schema.FieldTypeSelect,
+				Required: false,
+				Options: &schema.SelectOptions{
+					MaxSelect: 1,
+					Values:    []string{"copy", "replace"},
+				},
+			})
+		}
+
+		return dao.SaveCollection(profiles)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		if recordings, err := dao.FindCollectionByNameOrId("recordings"); err == nil {
+			if field := recordings.Schema.GetFieldByName("ad_breaks"); field != nil {
+				recordings.Schema.RemoveField(field.Id)
+				if err := dao.SaveCollection(recordings); err != nil {
+					return err
+				}
+			}
+		}
+
+		if profiles, err := dao.FindCollectionByNameOrId("profiles"); err == nil {
+			if field := profiles.Schema.GetFieldByName("recording_ad_cut"); field != nil {
+				profiles.Schema.RemoveField(field.Id)
+				return dao.SaveCollection(profiles)
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,95 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Settings are global, changed by admins, or belong to a user. Users only
+// see and change their own, admins with the admin role the global ones.
+const (
+	appSettingsOwnerRule  = "user = @request.auth.id || (user = '' && @request.auth.role = 'admin')"
+	appSettingsCreateRule = "@request.auth.id != '' && (" + appSettingsOwnerRule + ")"
+	appSettingsUpdateRule = "(" + appSettingsOwnerRule + ") && (@request.data.user:isset = false || @request.data.user = user)"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("app_settings")
+		if err != nil {
+			return err
+		}
+
+		usersCollection, err := dao.FindCollectionByNameOrId("users")
+		if err != nil {
+			return err
+		}
+
+		// Values without a user are global
+		if collection.Schema.GetFieldByName("user") == nil {
+			collection.Schema.AddField(&schema.SchemaField{
+				Name:     "user",
+				Type:     schema.FieldTypeRelation,
+				Required: false,
+				Options: &schema.RelationOptions{
+					CollectionId:  usersCollection.Id,
+					CascadeDelete: true,
+					MaxSelect:     types.Pointer(1),
+				},
+			})
+		}
+
+		// The initial migration left the size unset, which the admin UI
+		// refuses when saving the collection
+		if field := collection.Schema.GetFieldByName("value"); field != nil {
+			field.Options = &schema.JsonOptions{MaxSize: 2000000}
+		}
+
+		collection.ListRule = types.Pointer(appSettingsOwnerRule)
+		collection.ViewRule = types.Pointer(appSettingsOwnerRule)
+		collection.CreateRule = types.Pointer(appSettingsCreateRule)
+		collection.UpdateRule = types.Pointer(appSettingsUpdateRule)
+		collection.DeleteRule = types.Pointer(appSettingsOwnerRule)
+
+		// Only the last value saved under a key is kept for the unique index
+		if _, err := db.NewQuery("DELETE FROM {{app_settings}} WHERE [[rowid]] NOT IN (SELECT MAX([[rowid]]) FROM {{app_settings}} GROUP BY [[key]])").Execute(); err != nil {
+			return err
+		}
+		collection.Indexes = types.JsonArray[string]{
+			"CREATE UNIQUE INDEX idx_app_settings_key_user ON app_settings (key, user)",
+		}
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("app_settings")
+		if err != nil {
+			return nil
+		}
+
+		// Values of users would override the global ones
+		if _, err := db.NewQuery("DELETE FROM {{app_settings}} WHERE [[user]] != ''").Execute(); err != nil {
+			return err
+		}
+
+		collection.Indexes = types.JsonArray[string]{}
+		if field := collection.Schema.GetFieldByName("user"); field != nil {
+			collection.Schema.RemoveField(field.Id)
+		}
+
+		rule := types.Pointer("@request.auth.id != ''")
+		collection.ListRule = rule
+		collection.ViewRule = rule
+		collection.CreateRule = rule
+		collection.UpdateRule = rule
+		collection.DeleteRule = rule
+
+		return dao.SaveCollection(collection)
+	})
+}
@@ -0,0 +1,96 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		usersCollection, err := dao.FindCollectionByNameOrId("users")
+		if err != nil {
+			return err
+		}
+
+		channelsCollection, err := dao.FindCollectionByNameOrId("channels")
+		if err != nil {
+			return err
+		}
+
+		// Create channel_groups collection (groups of a user renaming or
+		// merging provider groups, or picking channels from several).
+		// Changed through /api/groups only, which checks them.
+		groupsCollection := &models.Collection{
+			Name:     "channel_groups",
+			Type:     models.CollectionTypeBase,
+			ListRule: types.Pointer("user = @request.auth.id"),
+			ViewRule: types.Pointer("user = @request.auth.id"),
+			Schema: schema.NewSchema(
+				&schema.SchemaField{
+					Name:     "user",
+					Type:     schema.FieldTypeRelation,
+					Required: true,
+					Options: &schema.RelationOptions{
+						CollectionId:  usersCollection.Id,
+						CascadeDelete: true,
+						MaxSelect:     types.Pointer(1),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "name",
+					Type:     schema.FieldTypeText,
+					Required: true,
+					Options: &schema.TextOptions{
+						Max: types.Pointer(100),
+					},
+				},
+				&schema.SchemaField{
+					// Provider group titles whose channels are listed under
+					// name instead: a rename with one, a merge with several
+					Name:     "provider_groups",
+					Type:     schema.FieldTypeJson,
+					Required: false,
+					Options: &schema.JsonOptions{
+						MaxSize: 20000,
+					},
+				},
+				&schema.SchemaField{
+					// Channels also listed in the group, whatever their own
+					Name:     "channels",
+					Type:     schema.FieldTypeRelation,
+					Required: false,
+					Options: &schema.RelationOptions{
+						CollectionId:  channelsCollection.Id,
+						CascadeDelete: false,
+					},
+				},
+				&schema.SchemaField{
+					Name:     "sort_order",
+					Type:     schema.FieldTypeNumber,
+					Required: false,
+					Options:  &schema.NumberOptions{NoDecimal: true},
+				},
+			),
+			Indexes: types.JsonArray[string]{
+				"CREATE UNIQUE INDEX idx_channel_groups_user_name ON channel_groups (user, name COLLATE NOCASE)",
+			},
+		}
+
+		return dao.SaveCollection(groupsCollection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("channel_groups")
+		if err != nil {
+			return nil
+		}
+
+		return dao.DeleteCollection(collection)
+	})
+}
@@ -0,0 +1,105 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		usersCollection, err := dao.FindCollectionByNameOrId("users")
+		if err != nil {
+			return err
+		}
+
+		channelsCollection, err := dao.FindCollectionByNameOrId("channels")
+		if err != nil {
+			return err
+		}
+
+		// Create epg_mappings collection (guide channel picked for a
+		// channel whose tvg_id doesn't match the guide). Changed through
+		// /api/epg/mappings only, which applies them to the channel.
+		mappingsCollection := &models.Collection{
+			Name:     "epg_mappings",
+			Type:     models.CollectionTypeBase,
+			ListRule: types.Pointer("user = @request.auth.id"),
+			ViewRule: types.Pointer("user = @request.auth.id"),
+			Schema: schema.NewSchema(
+				&schema.SchemaField{
+					Name:     "user",
+					Type:     schema.FieldTypeRelation,
+					Required: true,
+					Options: &schema.RelationOptions{
+						CollectionId:  usersCollection.Id,
+						CascadeDelete: true,
+						MaxSelect:     types.Pointer(1),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "channel",
+					Type:     schema.FieldTypeRelation,
+					Required: true,
+					Options: &schema.RelationOptions{
+						CollectionId:  channelsCollection.Id,
+						CascadeDelete: true,
+						MaxSelect:     types.Pointer(1),
+					},
+				},
+				&schema.SchemaField{
+					// XMLTV channel id, empty for a channel without guide
+					Name:     "epg_id",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options: &schema.TextOptions{
+						Max: types.Pointer(200),
+					},
+				},
+				&schema.SchemaField{
+					// tvg_id of the provider, given back when the mapping
+					// is removed
+					Name:     "provider_tvg_id",
+					Type:     schema.FieldTypeText,
+					Required: false,
+					Options: &schema.TextOptions{
+						Max: types.Pointer(200),
+					},
+				},
+				&schema.SchemaField{
+					// Set by automatic matching rather than by the user
+					Name:     "auto",
+					Type:     schema.FieldTypeBool,
+					Required: false,
+					Options:  &schema.BoolOptions{},
+				},
+				&schema.SchemaField{
+					Name:     "score",
+					Type:     schema.FieldTypeNumber,
+					Required: false,
+					Options:  &schema.NumberOptions{},
+				},
+			),
+			Indexes: types.JsonArray[string]{
+				"CREATE UNIQUE INDEX idx_epg_mappings_channel ON epg_mappings (channel)",
+				"CREATE INDEX idx_epg_mappings_user ON epg_mappings (user)",
+			},
+		}
+
+		return dao.SaveCollection(mappingsCollection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("epg_mappings")
+		if err != nil {
+			return nil
+		}
+
+		return dao.DeleteCollection(collection)
+	})
+}
@@ -0,0 +1,93 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		sources, err := dao.FindCollectionByNameOrId("epg_sources")
+		if err != nil {
+			return err
+		}
+
+		// Sources with a lower priority come first when several have a
+		// channel, the others fill the gaps
+		if sources.Schema.GetFieldByName("priority") == nil {
+			sources.Schema.AddField(&schema.SchemaField{
+				Name:     "priority",
+				Type:     schema.FieldTypeNumber,
+				Required: false,
+				Options: &schema.NumberOptions{
+					Min:       types.Pointer(0.0),
+					NoDecimal: true,
+				},
+			})
+		}
+
+		// Error of the last import, empty when it succeeded
+		if sources.Schema.GetFieldByName("last_error") == nil {
+			sources.Schema.AddField(&schema.SchemaField{
+				Name:     "last_error",
+				Type:     schema.FieldTypeText,
+				Required: false,
+				Options: &schema.TextOptions{
+					Max: types.Pointer(1000),
+				},
+			})
+		}
+
+		if err := dao.SaveCollection(sources); err != nil {
+			return err
+		}
+
+		channels, err := dao.FindCollectionByNameOrId("channels")
+		if err != nil {
+			return err
+		}
+
+		// Guide sources a channel takes its programmes from first, in
+		// that order, before the others by priority
+		if channels.Schema.GetFieldByName("epg_sources") == nil {
+			channels.Schema.AddField(&schema.SchemaField{
+				Name:     "epg_sources",
+				Type:     schema.FieldTypeRelation,
+				Required: false,
+				Options: &schema.RelationOptions{
+					CollectionId:  sources.Id,
+					CascadeDelete: false,
+				},
+			})
+		}
+
+		return dao.SaveCollection(channels)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		if channels, err := dao.FindCollectionByNameOrId("channels"); err == nil {
+			if field := channels.Schema.GetFieldByName("epg_sources"); field != nil {
+				channels.Schema.RemoveField(field.Id)
+				if err := dao.SaveCollection(channels); err != nil {
+					return err
+				}
+			}
+		}
+
+		sources, err := dao.FindCollectionByNameOrId("epg_sources")
+		if err != nil {
+			return nil
+		}
+		for _, name := range []string{"priority", "last_error"} {
+			if field := sources.Schema.GetFieldByName(name); field != nil {
+				sources.Schema.RemoveField(field.Id)
+			}
+		}
+		return dao.SaveCollection(sources)
+	})
+}
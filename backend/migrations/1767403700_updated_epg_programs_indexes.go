@@ -0,0 +1,49 @@
+package migrations
+
+import (
+	"slices"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("epg_programs")
+		if err != nil {
+			return err
+		}
+
+		// What's on by source and channel, and programmes starting too far
+		// ahead for the prune_guide maintenance task
+		collection.Indexes = append(collection.Indexes,
+			"CREATE INDEX `idx_epg_programs_source_channel_start` ON `epg_programs` (`source`, `channel_id`, `start_time`)",
+			"CREATE INDEX `idx_epg_programs_start` ON `epg_programs` (`start_time`)")
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("epg_programs")
+		if err != nil {
+			return nil
+		}
+
+		added := []string{
+			"CREATE INDEX `idx_epg_programs_source_channel_start` ON `epg_programs` (`source`, `channel_id`, `start_time`)",
+			"CREATE INDEX `idx_epg_programs_start` ON `epg_programs` (`start_time`)",
+		}
+		indexes := collection.Indexes[:0]
+		for _, index := range collection.Indexes {
+			if !slices.Contains(added, index) {
+				indexes = append(indexes, index)
+			}
+		}
+		collection.Indexes = indexes
+
+		return dao.SaveCollection(collection)
+	})
+}
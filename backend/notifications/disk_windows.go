@@ -0,0 +1,10 @@
+//go:build windows
+
+package notifications
+
+import "errors"
+
+// diskUsage is not implemented on Windows, disk.low events are never raised
+func diskUsage(path string) (free, total uint64, err error) {
+	return 0, 0, errors.New("disk usage not supported on windows")
+}
@@ -0,0 +1,188 @@
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// MonitorConfig configures the periodic checks that raise system events
+type MonitorConfig struct {
+	DiskPath     func() string // Returns the directory whose filesystem is watched (recordings)
+	DiskLowBytes uint64        // Raise disk.low when free space drops below this
+	UpcomingLead time.Duration // Remind this long before a scheduled recording starts
+	Interval     time.Duration // How often checks run
+}
+
+// DefaultMonitorConfig returns default monitor settings
+func DefaultMonitorConfig() MonitorConfig {
+	return MonitorConfig{
+		DiskLowBytes: 2 * 1024 * 1024 * 1024, // 2 GB
+		UpcomingLead: 10 * time.Minute,
+		Interval:     time.Minute,
+	}
+}
+
+// StartMonitor starts the disk space and upcoming recording checks
+func (s *Service) StartMonitor(config MonitorConfig) {
+	if config.Interval <= 0 {
+		config.Interval = time.Minute
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.mu.Lock()
+	if s.monitorStop != nil {
+		s.monitorStop()
+	}
+	s.monitorStop = cancel
+	s.mu.Unlock()
+
+	go s.monitorLoop(ctx, config)
+}
+
+// StopMonitor stops the periodic checks
+func (s *Service) StopMonitor() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.monitorStop != nil {
+		s.monitorStop()
+		s.monitorStop = nil
+	}
+}
+
+func (s *Service) monitorLoop(ctx context.Context, config MonitorConfig) {
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+
+	diskLow := false
+	reminded := make(map[string]time.Time) // recording id -> scheduled start
+
+	for {
+		if config.DiskPath != nil && config.DiskLowBytes > 0 {
+			diskLow = s.checkDiskSpace(config, diskLow)
+		}
+		if config.UpcomingLead > 0 {
+			s.checkUpcomingRecordings(config.UpcomingLead, reminded)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkDiskSpace raises disk.low once per low-space episode and returns the new state
+func (s *Service) checkDiskSpace(config MonitorConfig, wasLow bool) bool {
+	path := config.DiskPath()
+	free, total, err := diskUsage(path)
+	if err != nil {
+		s.logger.Debug("disk space check failed", "path", path, "error", err)
+		return wasLow
+	}
+
+	if free < config.DiskLowBytes {
+		if !wasLow {
+			s.logger.Warn("disk space low", "path", path, "free_bytes", free)
+			s.Notify(Event{
+				Type:    EventDiskSpaceLow,
+				Title:   "Disk space low",
+				Message: fmt.Sprintf("Only %s free of %s for recordings.", formatBytes(free), formatBytes(total)),
+				Data: map[string]interface{}{
+					"path":        path,
+					"free_bytes":  free,
+					"total_bytes": total,
+				},
+			})
+		}
+		return true
+	}
+
+	// Small hysteresis so hovering around the threshold doesn't spam
+	if wasLow && free < config.DiskLowBytes+config.DiskLowBytes/10 {
+		return true
+	}
+	return false
+}
+
+// checkUpcomingRecordings reminds users of scheduled recordings starting soon
+func (s *Service) checkUpcomingRecordings(lead time.Duration, reminded map[string]time.Time) {
+	now := time.Now()
+
+	for id, start := range reminded {
+		if start.Before(now) {
+			delete(reminded, id)
+		}
+	}
+
+	nowDT, _ := types.ParseDateTime(now)
+	untilDT, _ := types.ParseDateTime(now.Add(lead))
+
+	records, err := s.app.Dao().FindRecordsByFilter(
+		"recordings",
+		"status = 'scheduled' && scheduled_start > {:now} && scheduled_start <= {:until}",
+		"scheduled_start",
+		0,
+		0,
+		dbx.Params{"now": nowDT.String(), "until": untilDT.String()},
+	)
+	if err != nil {
+		return
+	}
+
+	for _, record := range records {
+		if _, ok := reminded[record.Id]; ok {
+			continue
+		}
+		start := record.GetDateTime("scheduled_start").Time()
+		reminded[record.Id] = start
+
+		profile, err := s.app.Dao().FindRecordById("profiles", record.GetString("profile"))
+		if err != nil {
+			continue
+		}
+
+		channelName := ""
+		if channel, err := s.app.Dao().FindRecordById("channels", record.GetString("channel")); err == nil {
+			channelName = channel.GetString("name")
+		}
+
+		title := record.GetString("program_title")
+		message := fmt.Sprintf("%s starts recording at %s", title, start.Local().Format("15:04"))
+		if channelName != "" {
+			message += " on " + channelName
+		}
+
+		for _, user := range profile.GetStringSlice("user") {
+			s.Notify(Event{
+				Type:    EventRecordingUpcoming,
+				User:    user,
+				Title:   "Upcoming recording",
+				Message: message + ".",
+				Data: map[string]interface{}{
+					"recording_id":    record.Id,
+					"program_title":   title,
+					"channel":         channelName,
+					"scheduled_start": start,
+				},
+			})
+		}
+	}
+}
+
+func formatBytes(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(b)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,268 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+
+	"iptv-backend/logging"
+)
+
+// CollectionName is the PocketBase collection holding delivery channels
+const CollectionName = "notification_settings"
+
+// EventType identifies what happened
+type EventType string
+
+const (
+	EventRecordingStarted   EventType = "recording.started"
+	EventRecordingFinished  EventType = "recording.finished"
+	EventRecordingFailed    EventType = "recording.failed"
+	EventRecordingUpcoming  EventType = "recording.upcoming"
+	EventDiskSpaceLow       EventType = "disk.low"
+	EventPlaylistSyncFailed EventType = "playlist.sync_failed"
+	EventProgramReminder    EventType = "program.reminder"
+	EventTest               EventType = "test"
+)
+
+// AllEvents lists the event types users can subscribe to
+var AllEvents = []EventType{
+	EventRecordingStarted,
+	EventRecordingFinished,
+	EventRecordingFailed,
+	EventRecordingUpcoming,
+	EventDiskSpaceLow,
+	EventPlaylistSyncFailed,
+	EventProgramReminder,
+}
+
+// Event is a notification to deliver
+type Event struct {
+	Type    EventType              `json:"type"`
+	User    string                 `json:"user,omitempty"` // Empty for system events (all users)
+	Title   string                 `json:"title"`
+	Message string                 `json:"message"`
+	Data    map[string]interface{} `json:"data,omitempty"`
+	Time    time.Time              `json:"time"`
+}
+
+// Setting is a user's delivery channel, stored in notification_settings
+type Setting struct {
+	ID      string            `json:"id"`
+	User    string            `json:"user"`
+	Name    string            `json:"name"`
+	Type    string            `json:"type"`
+	Enabled bool              `json:"enabled"`
+	Events  []string          `json:"events"`
+	Config  map[string]string `json:"config"`
+}
+
+// Wants reports whether the setting subscribes to an event type
+func (s Setting) Wants(eventType EventType) bool {
+	if eventType == EventTest || len(s.Events) == 0 {
+		return true
+	}
+	for _, e := range s.Events {
+		if e == string(eventType) {
+			return true
+		}
+	}
+	return false
+}
+
+// Sender delivers an event through one kind of channel
+type Sender interface {
+	Send(ctx context.Context, setting Setting, event Event) error
+}
+
+// Service fans events out to the users' configured channels
+type Service struct {
+	app     core.App
+	senders map[string]Sender
+	mu      sync.RWMutex
+	queue   chan Event
+	logger  *slog.Logger
+
+	listeners []func(event Event)
+
+	monitorStop context.CancelFunc
+}
+
+// NewService creates a notification service with the built-in senders
+func NewService(app core.App) *Service {
+	s := &Service{
+		app:     app,
+		senders: make(map[string]Sender),
+		queue:   make(chan Event, 500),
+		logger:  logging.For("notifications"),
+	}
+
+	s.RegisterSender("email", &EmailSender{app: app})
+	s.RegisterSender("webhook", &WebhookSender{})
+	s.RegisterSender("telegram", &TelegramSender{})
+	s.RegisterSender("ntfy", &NtfySender{})
+
+	go s.deliveryLoop()
+
+	return s
+}
+
+// RegisterSender adds or replaces the sender for a channel type
+func (s *Service) RegisterSender(channelType string, sender Sender) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.senders[channelType] = sender
+}
+
+func (s *Service) sender(channelType string) (Sender, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sender, ok := s.senders[channelType]
+	return sender, ok
+}
+
+// OnNotify registers a callback receiving every event as it is notified,
+// before its delivery. Callbacks run on the caller's goroutine and must not
+// block.
+func (s *Service) OnNotify(fn func(event Event)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, fn)
+}
+
+// Notify queues an event for delivery without blocking the caller
+func (s *Service) Notify(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	s.mu.RLock()
+	listeners := s.listeners
+	s.mu.RUnlock()
+	for _, fn := range listeners {
+		fn(event)
+	}
+
+	select {
+	case s.queue <- event:
+	default:
+		s.logger.Warn("notification queue full, dropping event", "event", event.Type)
+	}
+}
+
+// SendTest delivers a test notification through a single setting
+func (s *Service) SendTest(ctx context.Context, record *models.Record) error {
+	setting := recordToSetting(record)
+
+	sender, ok := s.sender(setting.Type)
+	if !ok {
+		return fmt.Errorf("unsupported notification type %q", setting.Type)
+	}
+
+	return sender.Send(ctx, setting, Event{
+		Type:    EventTest,
+		User:    setting.User,
+		Title:   "StreamVault test notification",
+		Message: "Notifications are working.",
+		Time:    time.Now(),
+	})
+}
+
+// deliveryLoop delivers queued events in order
+func (s *Service) deliveryLoop() {
+	for event := range s.queue {
+		settings, err := s.settingsFor(event)
+		if err != nil {
+			s.logger.Debug("failed to load notification settings", "event", event.Type, "error", err)
+			continue
+		}
+
+		for _, setting := range settings {
+			s.deliver(setting, event)
+		}
+	}
+}
+
+// deliver sends an event through one setting, retrying once on failure
+func (s *Service) deliver(setting Setting, event Event) {
+	sender, ok := s.sender(setting.Type)
+	if !ok {
+		s.logger.Warn("unsupported notification type", "type", setting.Type, "setting_id", setting.ID)
+		return
+	}
+
+	var err error
+	for attempt := 1; attempt <= 2; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		err = sender.Send(ctx, setting, event)
+		cancel()
+		if err == nil {
+			return
+		}
+		time.Sleep(2 * time.Second)
+	}
+
+	s.logger.Warn("notification delivery failed",
+		"event", event.Type,
+		"type", setting.Type,
+		"setting_id", setting.ID,
+		"error", err,
+	)
+}
+
+// settingsFor returns the enabled settings that should receive an event
+func (s *Service) settingsFor(event Event) ([]Setting, error) {
+	filter := "enabled = true"
+	params := dbx.Params{}
+	if event.User != "" {
+		filter += " && user = {:user}"
+		params["user"] = event.User
+	}
+
+	records, err := s.app.Dao().FindRecordsByFilter(CollectionName, filter, "", 0, 0, params)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := make([]Setting, 0, len(records))
+	for _, record := range records {
+		setting := recordToSetting(record)
+		if setting.Wants(event.Type) {
+			settings = append(settings, setting)
+		}
+	}
+
+	return settings, nil
+}
+
+func recordToSetting(record *models.Record) Setting {
+	setting := Setting{
+		ID:      record.Id,
+		User:    record.GetString("user"),
+		Name:    record.GetString("name"),
+		Type:    record.GetString("type"),
+		Enabled: record.GetBool("enabled"),
+		Config:  make(map[string]string),
+	}
+
+	json.Unmarshal([]byte(record.GetString("events")), &setting.Events)
+
+	// Config values are stringified so numbers and booleans are accepted too
+	var config map[string]interface{}
+	if json.Unmarshal([]byte(record.GetString("config")), &config) == nil {
+		for key, value := range config {
+			if value != nil {
+				setting.Config[key] = fmt.Sprint(value)
+			}
+		}
+	}
+
+	return setting
+}
@@ -0,0 +1,168 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/mailer"
+)
+
+var httpClient = &http.Client{Timeout: 15 * time.Second}
+
+// postJSON sends body to url and fails on non-2xx responses
+func postJSON(ctx context.Context, url string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	return doRequest(req)
+}
+
+func doRequest(req *http.Request) error {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("server returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+
+	return nil
+}
+
+// EmailSender delivers notifications with the SMTP settings configured in PocketBase.
+// Config: "address" (defaults to the user's account email).
+type EmailSender struct {
+	app core.App
+}
+
+func (s *EmailSender) Send(ctx context.Context, setting Setting, event Event) error {
+	address := setting.Config["address"]
+	if address == "" {
+		user, err := s.app.Dao().FindRecordById("users", setting.User)
+		if err != nil {
+			return fmt.Errorf("user not found: %w", err)
+		}
+		address = user.Email()
+	}
+	if address == "" {
+		return fmt.Errorf("no email address configured")
+	}
+
+	meta := s.app.Settings().Meta
+
+	return s.app.NewMailClient().Send(&mailer.Message{
+		From: mail.Address{
+			Name:    meta.SenderName,
+			Address: meta.SenderAddress,
+		},
+		To:      []mail.Address{{Address: address}},
+		Subject: event.Title,
+		Text:    event.Message,
+	})
+}
+
+// WebhookSender posts the event as JSON.
+// Config: "url" (required), "secret" (optional HMAC-SHA256 signing key).
+type WebhookSender struct{}
+
+func (s *WebhookSender) Send(ctx context.Context, setting Setting, event Event) error {
+	url := setting.Config["url"]
+	if url == "" {
+		return fmt.Errorf("webhook url is required")
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	headers := map[string]string{
+		"X-StreamVault-Event":     string(event.Type),
+		"X-StreamVault-Timestamp": timestamp,
+	}
+	if secret := setting.Config["secret"]; secret != "" {
+		// Same scheme as the transcript webhook: HMAC of "<timestamp>.<body>"
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(timestamp + "."))
+		mac.Write(body)
+		headers["X-StreamVault-Signature"] = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	return postJSON(ctx, url, body, headers)
+}
+
+// TelegramSender delivers notifications through a Telegram bot.
+// Config: "bot_token" and "chat_id" (required).
+type TelegramSender struct{}
+
+func (s *TelegramSender) Send(ctx context.Context, setting Setting, event Event) error {
+	token := setting.Config["bot_token"]
+	chatID := setting.Config["chat_id"]
+	if token == "" || chatID == "" {
+		return fmt.Errorf("telegram bot_token and chat_id are required")
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"chat_id": chatID,
+		"text":    event.Title + "\n" + event.Message,
+	})
+	if err != nil {
+		return err
+	}
+
+	return postJSON(ctx, "https://api.telegram.org/bot"+token+"/sendMessage", body, nil)
+}
+
+// NtfySender publishes notifications to an ntfy topic.
+// Config: "topic" (required), "server" (defaults to https://ntfy.sh),
+// "token" (optional access token), "priority" (optional, 1-5).
+type NtfySender struct{}
+
+func (s *NtfySender) Send(ctx context.Context, setting Setting, event Event) error {
+	topic := setting.Config["topic"]
+	if topic == "" {
+		return fmt.Errorf("ntfy topic is required")
+	}
+
+	server := strings.TrimRight(setting.Config["server"], "/")
+	if server == "" {
+		server = "https://ntfy.sh"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", server+"/"+topic, strings.NewReader(event.Message))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", event.Title)
+	req.Header.Set("Tags", strings.ReplaceAll(string(event.Type), ".", "_"))
+	if priority := setting.Config["priority"]; priority != "" {
+		req.Header.Set("Priority", priority)
+	}
+	if token := setting.Config["token"]; token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return doRequest(req)
+}
@@ -0,0 +1,377 @@
+// Package openapi describes the custom API routes in an OpenAPI 3 document.
+// Routes are registered through a Router, which records each one with the
+// Operation describing it, so the document lists exactly the routes the
+// server has. Who may call a route is inferred from its middleware.
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/labstack/echo/v5"
+)
+
+// Who may call a route
+const (
+	AuthPublic = "public"
+	AuthUser   = "user"
+	AuthAdmin  = "admin"
+)
+
+// Operation describes a route
+type Operation struct {
+	Summary     string
+	Description string
+	// Auth is inferred from the middleware of the route when empty
+	Auth string
+	// Scope is the API key scope that may call the route, none when empty
+	Scope string
+	Query []Param
+	// Body and Response describe the JSON request and response: a Fields,
+	// or a value whose type is described through reflection. nil for none.
+	Body     interface{}
+	Response interface{}
+	// Produces is the content type of responses other than JSON, such as
+	// video/mp2t or text/event-stream
+	Produces string
+}
+
+// Param is a query parameter
+type Param struct {
+	Name        string
+	Description string
+	Required    bool
+}
+
+// Fields describes a JSON object by its field names and their types:
+// string, integer, number, boolean, object, or an array of them as
+// []string. Types ending in ! are required.
+type Fields map[string]string
+
+// route is a registered route and its description
+type route struct {
+	method    string
+	path      string
+	operation Operation
+}
+
+// authMiddleware is a middleware granting access to callers of a level
+type authMiddleware struct {
+	level string
+	code  uintptr
+}
+
+// Spec collects the routes of the API
+type Spec struct {
+	title       string
+	version     string
+	description string
+
+	mu     sync.RWMutex
+	routes []route
+	scopes map[string]string
+	auth   []authMiddleware
+}
+
+// NewSpec creates an empty API description
+func NewSpec(title, version, description string) *Spec {
+	return &Spec{title: title, version: version, description: description, scopes: make(map[string]string)}
+}
+
+// AuthMiddleware declares that routes using middleware, or another made by
+// the same function, require callers of level
+func (s *Spec) AuthMiddleware(level string, middleware echo.MiddlewareFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.auth = append(s.auth, authMiddleware{level: level, code: reflect.ValueOf(middleware).Pointer()})
+}
+
+// authLevel returns the level the middleware of a route require, admin
+// taking precedence
+func (s *Spec) authLevel(middleware []echo.MiddlewareFunc) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	level := AuthPublic
+	for _, m := range middleware {
+		code := reflect.ValueOf(m).Pointer()
+		for _, auth := range s.auth {
+			if auth.code == code && level != AuthAdmin {
+				level = auth.level
+			}
+		}
+	}
+	return level
+}
+
+// Scope returns the API key scope of a route, by its method and echo path,
+// or "" when no key may call it
+func (s *Spec) Scope(method, path string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.scopes[method+" "+path]
+}
+
+// Router registers routes on an echo router and records them in a spec
+type Router struct {
+	router *echo.Echo
+	spec   *Spec
+}
+
+// Router returns a Router registering routes on router
+func (s *Spec) Router(router *echo.Echo) *Router {
+	return &Router{router: router, spec: s}
+}
+
+// Add registers a route described by operation
+func (r *Router) Add(method, path string, operation Operation, handler echo.HandlerFunc, middleware ...echo.MiddlewareFunc) echo.RouteInfo {
+	if operation.Auth == "" {
+		operation.Auth = r.spec.authLevel(middleware)
+	}
+
+	r.spec.mu.Lock()
+	r.spec.routes = append(r.spec.routes, route{method: method, path: path, operation: operation})
+	if operation.Scope != "" {
+		r.spec.scopes[method+" "+path] = operation.Scope
+	}
+	r.spec.mu.Unlock()
+
+	return r.router.Add(method, path, handler, middleware...)
+}
+
+func (r *Router) GET(path string, operation Operation, handler echo.HandlerFunc, middleware ...echo.MiddlewareFunc) echo.RouteInfo {
+	return r.Add(http.MethodGet, path, operation, handler, middleware...)
+}
+
+func (r *Router) POST(path string, operation Operation, handler echo.HandlerFunc, middleware ...echo.MiddlewareFunc) echo.RouteInfo {
+	return r.Add(http.MethodPost, path, operation, handler, middleware...)
+}
+
+func (r *Router) PUT(path string, operation Operation, handler echo.HandlerFunc, middleware ...echo.MiddlewareFunc) echo.RouteInfo {
+	return r.Add(http.MethodPut, path, operation, handler, middleware...)
+}
+
+func (r *Router) PATCH(path string, operation Operation, handler echo.HandlerFunc, middleware ...echo.MiddlewareFunc) echo.RouteInfo {
+	return r.Add(http.MethodPatch, path, operation, handler, middleware...)
+}
+
+func (r *Router) DELETE(path string, operation Operation, handler echo.HandlerFunc, middleware ...echo.MiddlewareFunc) echo.RouteInfo {
+	return r.Add(http.MethodDelete, path, operation, handler, middleware...)
+}
+
+// Document returns the OpenAPI 3 document of the registered routes
+func (s *Spec) Document() map[string]interface{} {
+	s.mu.RLock()
+	routes := append([]route(nil), s.routes...)
+	s.mu.RUnlock()
+
+	paths := make(map[string]map[string]interface{})
+	tags := make(map[string]bool)
+	for _, rt := range routes {
+		path, params := openAPIPath(rt.path)
+		if paths[path] == nil {
+			paths[path] = make(map[string]interface{})
+		}
+		tag := routeTag(rt.path)
+		tags[tag] = true
+		paths[path][strings.ToLower(rt.method)] = operationObject(rt, tag, params)
+	}
+
+	tagList := make([]map[string]string, 0, len(tags))
+	for tag := range tags {
+		tagList = append(tagList, map[string]string{"name": tag})
+	}
+	sort.Slice(tagList, func(i, j int) bool { return tagList[i]["name"] < tagList[j]["name"] })
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]string{
+			"title":       s.title,
+			"version":     s.version,
+			"description": s.description,
+		},
+		"tags":  tagList,
+		"paths": paths,
+		"components": map[string]interface{}{
+			"securitySchemes": map[string]interface{}{
+				"token": map[string]string{
+					"type":        "apiKey",
+					"in":          "header",
+					"name":        "Authorization",
+					"description": "Auth token of a user or PocketBase admin",
+				},
+				"apiKey": map[string]string{
+					"type":        "apiKey",
+					"in":          "header",
+					"name":        "X-API-Key",
+					"description": "API key, for the routes of its scopes",
+				},
+			},
+			"schemas": map[string]interface{}{
+				"Error": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"code":    map[string]string{"type": "integer"},
+						"message": map[string]string{"type": "string"},
+						"data":    map[string]string{"type": "object"},
+					},
+				},
+			},
+		},
+	}
+}
+
+// Handler serves the document as JSON
+func (s *Spec) Handler(c echo.Context) error {
+	data, err := json.Marshal(s.Document())
+	if err != nil {
+		return err
+	}
+	return c.JSONBlob(http.StatusOK, data)
+}
+
+// operationObject describes a route in the document
+func operationObject(rt route, tag string, pathParams []string) map[string]interface{} {
+	op := rt.operation
+	object := map[string]interface{}{
+		"operationId": operationID(rt.method, rt.path),
+		"tags":        []string{tag},
+		"summary":     op.Summary,
+		"x-auth":      op.Auth,
+	}
+	description := op.Description
+	if op.Auth == AuthAdmin {
+		description = strings.TrimSpace("Admin only. " + description)
+	}
+	if description != "" {
+		object["description"] = description
+	}
+	switch {
+	case op.Scope != "":
+		object["x-scope"] = op.Scope
+		object["security"] = []map[string][]string{{"token": {}}, {"apiKey": {}}}
+	case op.Auth != AuthPublic:
+		object["security"] = []map[string][]string{{"token": {}}}
+	}
+
+	var params []map[string]interface{}
+	for _, name := range pathParams {
+		params = append(params, map[string]interface{}{
+			"name": name, "in": "path", "required": true,
+			"schema": map[string]string{"type": "string"},
+		})
+	}
+	for _, param := range op.Query {
+		p := map[string]interface{}{
+			"name": param.Name, "in": "query", "required": param.Required,
+			"schema": map[string]string{"type": "string"},
+		}
+		if param.Description != "" {
+			p["description"] = param.Description
+		}
+		params = append(params, p)
+	}
+	if len(params) > 0 {
+		object["parameters"] = params
+	}
+
+	if op.Body != nil {
+		object["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": Schema(op.Body)},
+			},
+		}
+	}
+
+	success := map[string]interface{}{"description": "Success"}
+	switch {
+	case op.Produces != "":
+		success["content"] = map[string]interface{}{
+			op.Produces: map[string]interface{}{"schema": map[string]string{"type": "string", "format": "binary"}},
+		}
+	case op.Response != nil:
+		success["content"] = map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": Schema(op.Response)},
+		}
+	}
+	errorResponse := map[string]interface{}{
+		"description": "Error",
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": map[string]string{"$ref": "#/components/schemas/Error"}},
+		},
+	}
+	object["responses"] = map[string]interface{}{
+		"200":     success,
+		"default": errorResponse,
+	}
+	return object
+}
+
+// openAPIPath turns the :name and * parameters of an echo path into
+// {name} and {path}, and returns their names
+func openAPIPath(path string) (string, []string) {
+	segments := strings.Split(path, "/")
+	var params []string
+	for i, segment := range segments {
+		switch {
+		case strings.HasPrefix(segment, ":"):
+			params = append(params, segment[1:])
+			segments[i] = "{" + segment[1:] + "}"
+		case segment == "*":
+			params = append(params, "path")
+			segments[i] = "{path}"
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// routeTag groups routes by the segment following /api, recorder for
+// /api/recorder/start
+func routeTag(path string) string {
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if segments[0] == "api" && len(segments) > 1 {
+		segments = segments[1:]
+	}
+	if segments[0] == "admin" && len(segments) > 1 {
+		return "admin"
+	}
+	return strings.TrimSuffix(segments[0], ".json")
+}
+
+// operationID names a route in camel case, getRecorderStatusById for
+// GET /api/recorder/status/:id
+func operationID(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	for _, segment := range strings.Split(strings.TrimPrefix(path, "/api/"), "/") {
+		switch {
+		case segment == "":
+			continue
+		case segment == "*":
+			b.WriteString("ByPath")
+			continue
+		case strings.HasPrefix(segment, ":"):
+			b.WriteString("By")
+			segment = segment[1:]
+		}
+		upper := true
+		for _, r := range segment {
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+				upper = true
+				continue
+			}
+			if upper {
+				r = unicode.ToUpper(r)
+				upper = false
+			}
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,137 @@
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	durationType   = reflect.TypeOf(time.Duration(0))
+	rawMessageType = reflect.TypeOf(json.RawMessage(nil))
+)
+
+// Schema returns the JSON schema of v: a Fields, or any other value whose
+// type is described through its json tags
+func Schema(v interface{}) map[string]interface{} {
+	if fields, ok := v.(Fields); ok {
+		return fields.schema()
+	}
+	return typeSchema(reflect.TypeOf(v), map[reflect.Type]bool{})
+}
+
+// schema describes the object of fields
+func (f Fields) schema() map[string]interface{} {
+	properties := make(map[string]interface{}, len(f))
+	var required []string
+	for name, typ := range f {
+		if strings.HasSuffix(typ, "!") {
+			typ = strings.TrimSuffix(typ, "!")
+			required = append(required, name)
+		}
+		properties[name] = fieldSchema(typ)
+	}
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		sort.Strings(required)
+		schema["required"] = required
+	}
+	return schema
+}
+
+// fieldSchema describes a type of Fields, []string for an array of strings
+func fieldSchema(typ string) map[string]interface{} {
+	if strings.HasPrefix(typ, "[]") {
+		return map[string]interface{}{"type": "array", "items": fieldSchema(typ[2:])}
+	}
+	if typ == "date-time" {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	return map[string]interface{}{"type": typ}
+}
+
+// typeSchema describes a Go type. seen holds the structs being described,
+// so recursive types end in a plain object.
+func typeSchema(t reflect.Type, seen map[reflect.Type]bool) map[string]interface{} {
+	if t == nil {
+		return map[string]interface{}{}
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == durationType:
+		return map[string]interface{}{"type": "integer", "description": "Nanoseconds"}
+	case t == rawMessageType:
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem(), seen)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem(), seen)}
+	case reflect.Struct:
+		if seen[t] {
+			return map[string]interface{}{"type": "object"}
+		}
+		seen[t] = true
+		defer delete(seen, t)
+
+		properties := make(map[string]interface{})
+		structFields(t, properties, seen)
+		return map[string]interface{}{"type": "object", "properties": properties}
+	}
+	// Interfaces, which can hold anything
+	return map[string]interface{}{}
+}
+
+// structFields adds the JSON fields of a struct to properties, those of its
+// embedded structs included
+func structFields(t reflect.Type, properties map[string]interface{}, seen map[reflect.Type]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				structFields(embedded, properties, seen)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = typeSchema(field.Type, seen)
+	}
+}
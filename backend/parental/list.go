@@ -0,0 +1,159 @@
+package parental
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/resolvers"
+	"github.com/pocketbase/pocketbase/tools/search"
+
+	"iptv-backend/playlist"
+)
+
+// visibleChannels is the condition on channels keeping those the
+// restrictions don't hide
+func (r Restrictions) visibleChannels() dbx.Expression {
+	var conditions []dbx.Expression
+	if len(r.BlockedChannels) > 0 {
+		conditions = append(conditions, dbx.NotIn("channels.id", toAny(r.BlockedChannels)...))
+	}
+	if len(r.BlockedGroups) > 0 {
+		groups := make([]string, 0, len(r.BlockedGroups))
+		for _, group := range r.BlockedGroups {
+			groups = append(groups, strings.ToLower(group))
+		}
+		conditions = append(conditions, dbx.NotIn("LOWER([[channels.group_title]])", toAny(groups)...))
+	}
+	if r.Kids {
+		for _, keyword := range KidsBlockedKeywords {
+			conditions = append(conditions, dbx.NotLike("channels.group_title", keyword))
+		}
+	}
+	return dbx.And(conditions...)
+}
+
+// ListFilter returns the condition keeping the channels or programs the
+// active profile may see, nil when nothing is hidden from it. Added to a
+// list query, pages stay full and their counts right.
+func (s *Service) ListFilter(c echo.Context, collection string) dbx.Expression {
+	if collection != "channels" && collection != "epg_programs" {
+		return nil
+	}
+
+	restrictions := s.ActiveRestrictions(c)
+	if restrictions == nil {
+		return nil
+	}
+	if collection == "channels" {
+		return restrictions.visibleChannels()
+	}
+
+	// Programs are hidden by the guide ids of the user's hidden channels
+	authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+	owned, err := playlist.OwnedIDs(s.app.Dao(), authRecord.Id)
+	if err != nil || len(owned) == 0 {
+		return nil
+	}
+	playlistIDs := make([]any, 0, len(owned))
+	for id := range owned {
+		playlistIDs = append(playlistIDs, id)
+	}
+
+	var tvgIDs []string
+	err = s.app.Dao().RecordQuery("channels").
+		Select("channels.tvg_id").
+		Distinct(true).
+		AndWhere(dbx.In("channels.playlist", playlistIDs...)).
+		AndWhere(dbx.NewExp("[[channels.tvg_id]] != ''")).
+		AndWhere(dbx.Not(restrictions.visibleChannels())).
+		Column(&tvgIDs)
+	if err != nil {
+		s.logger.Warn("failed to load hidden channels", "user_id", authRecord.Id, "error", err)
+		return nil
+	}
+	if len(tvgIDs) == 0 {
+		return nil
+	}
+	return dbx.NotIn("epg_programs.channel_id", toAny(tvgIDs)...)
+}
+
+// FilterLists serves the channel and program lists of the records API with
+// the ListFilter of the active profile in their query, so hidden records
+// don't leave pages short. Other requests are left to PocketBase.
+func (s *Service) FilterLists() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if c.Request().Method != http.MethodGet || c.Path() != "/api/collections/:collection/records" {
+				return next(c)
+			}
+
+			collection, err := s.app.Dao().FindCollectionByNameOrId(c.PathParam("collection"))
+			if err != nil {
+				return next(c)
+			}
+			filter := s.ListFilter(c, collection.Name)
+			if filter == nil {
+				return next(c)
+			}
+			return s.list(c, collection, filter)
+		}
+	}
+}
+
+// list answers like the records API list of a user, with filter added to
+// the query
+func (s *Service) list(c echo.Context, collection *models.Collection, filter dbx.Expression) error {
+	if collection.ListRule == nil {
+		return apis.NewForbiddenError("Only admins can perform this action.", nil)
+	}
+
+	query := c.QueryParams()
+	for _, param := range []string{search.FilterQueryParam, search.SortQueryParam} {
+		if value := query.Get(param); strings.Contains(value, "@collection.") || strings.Contains(value, "@request.") {
+			return apis.NewForbiddenError("Only admins can filter by @collection and @request fields", nil)
+		}
+	}
+	if len(query.Encode()) > 2048 {
+		return apis.NewBadRequestError("Query string is too large", nil)
+	}
+
+	provider := search.NewProvider(resolvers.NewRecordFieldResolver(s.app.Dao(), collection, apis.RequestInfo(c), false)).
+		Query(s.app.Dao().RecordQuery(collection).AndWhere(filter))
+	provider.AddFilter(search.FilterData(*collection.ListRule))
+
+	records := []*models.Record{}
+	result, err := provider.ParseAndExec(query.Encode(), &records)
+	if err != nil {
+		return apis.NewBadRequestError("", err)
+	}
+
+	// The collection's list hooks still apply, such as the groups and URLs
+	// the viewer may see
+	event := new(core.RecordsListEvent)
+	event.HttpContext = c
+	event.Collection = collection
+	event.Records = records
+	event.Result = result
+	return s.app.OnRecordsListRequest().Trigger(event, func(e *core.RecordsListEvent) error {
+		if e.HttpContext.Response().Committed {
+			return nil
+		}
+		if err := apis.EnrichRecords(e.HttpContext, s.app.Dao(), e.Records); err != nil {
+			s.logger.Debug("failed to expand records", "collection", collection.Name, "error", err)
+		}
+		return e.HttpContext.JSON(http.StatusOK, e.Result)
+	})
+}
+
+func toAny(values []string) []any {
+	items := make([]any, len(values))
+	for i, value := range values {
+		items[i] = value
+	}
+	return items
+}
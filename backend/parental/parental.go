@@ -0,0 +1,418 @@
+// Package parental enforces profile restrictions on the server: channels
+// and groups blocked for a profile, and kids profiles, are left out of the
+// channel and program guide responses unless the profile was unlocked with
+// its PIN. The active profile is sent by the client in ProfileHeader; only
+// a PIN protected profile the request unlocked lifts the restrictions, any
+// other request gets those of every profile of the user.
+package parental
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+	"golang.org/x/crypto/bcrypt"
+
+	"iptv-backend/logging"
+)
+
+// Headers sent by the client: the profile being watched, and the token
+// returned by VerifyPIN while the profile is unlocked
+const (
+	ProfileHeader = "X-Profile-Id"
+	UnlockHeader  = "X-Profile-Unlock"
+)
+
+// Profile fields holding the restrictions, and telling whether it has a PIN
+const (
+	BlockedGroupsField   = "blocked_groups"
+	BlockedChannelsField = "blocked_channels"
+	HasPINField          = "has_pin"
+)
+
+// PINCollection holds the bcrypt hashes of profile PINs. It has no API
+// rules, so hashes are never part of a record response.
+const PINCollection = "profile_pins"
+
+const (
+	// MaxPINAttempts wrong PINs lock a profile for LockoutDuration
+	MaxPINAttempts  = 5
+	LockoutDuration = 15 * time.Minute
+
+	// UnlockTTL is how long a verified PIN lifts the restrictions
+	UnlockTTL = 30 * time.Minute
+)
+
+// KidsBlockedKeywords hide the groups containing them from kids profiles,
+// on top of the groups blocked explicitly
+var KidsBlockedKeywords = []string{"adult", "xxx", "18+"}
+
+// pinPattern is the format of PINs
+var pinPattern = regexp.MustCompile(`^[0-9]{4}$`)
+
+var (
+	ErrPINFormat  = errors.New("the PIN must be 4 digits")
+	ErrNoPIN      = errors.New("profile has no PIN")
+	ErrInvalidPIN = errors.New("invalid PIN")
+	ErrLocked     = errors.New("too many wrong PINs, try again later")
+)
+
+// restrictedFields can only be changed while a profile with a PIN is unlocked
+var restrictedFields = []string{"is_kids", BlockedGroupsField, BlockedChannelsField}
+
+// Restrictions are the channels hidden from a profile
+type Restrictions struct {
+	Kids            bool
+	BlockedGroups   []string
+	BlockedChannels []string
+}
+
+// RestrictionsOf reads the restrictions of a profile
+func RestrictionsOf(profile *models.Record) Restrictions {
+	r := Restrictions{Kids: profile.GetBool("is_kids")}
+	profile.UnmarshalJSONField(BlockedGroupsField, &r.BlockedGroups)
+	profile.UnmarshalJSONField(BlockedChannelsField, &r.BlockedChannels)
+	return r
+}
+
+// Empty reports whether nothing is hidden
+func (r Restrictions) Empty() bool {
+	return !r.Kids && len(r.BlockedGroups) == 0 && len(r.BlockedChannels) == 0
+}
+
+// BlocksGroup reports whether a channel group is hidden
+func (r Restrictions) BlocksGroup(group string) bool {
+	for _, blocked := range r.BlockedGroups {
+		if strings.EqualFold(blocked, group) {
+			return true
+		}
+	}
+	if r.Kids {
+		lower := strings.ToLower(group)
+		for _, keyword := range KidsBlockedKeywords {
+			if strings.Contains(lower, keyword) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// BlocksChannel reports whether a channel record is hidden
+func (r Restrictions) BlocksChannel(channel *models.Record) bool {
+	return slices.Contains(r.BlockedChannels, channel.Id) || r.BlocksGroup(channel.GetString("group_title"))
+}
+
+// OwnedBy reports whether a profile belongs to a user, its user relation
+// holds one or several ids depending on how the collection was created
+func OwnedBy(profile *models.Record, userID string) bool {
+	return slices.Contains(profile.GetStringSlice("user"), userID)
+}
+
+// attempts counts the wrong PINs of a profile
+type attempts struct {
+	failures    int
+	first       time.Time
+	lockedUntil time.Time
+}
+
+// Service verifies PINs and filters responses for restricted profiles
+type Service struct {
+	app    core.App
+	secret []byte
+	logger *slog.Logger
+
+	mu       sync.Mutex
+	attempts map[string]*attempts
+}
+
+// NewService returns a parental control service. Unlock tokens are signed
+// with a key generated at startup, so a restart locks profiles again.
+func NewService(app core.App) *Service {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(fmt.Sprintf("parental: failed to generate unlock key: %v", err))
+	}
+
+	return &Service{
+		app:      app,
+		secret:   secret,
+		logger:   logging.For("parental"),
+		attempts: make(map[string]*attempts),
+	}
+}
+
+// VerifyPIN checks the PIN of a profile and returns an unlock token valid
+// for UnlockTTL. Profiles are locked after MaxPINAttempts wrong PINs.
+func (s *Service) VerifyPIN(profile *models.Record, pin string) (string, time.Time, error) {
+	stored, err := s.app.Dao().FindFirstRecordByData(PINCollection, "profile", profile.Id)
+	if !HasPIN(profile) || err != nil {
+		return "", time.Time{}, ErrNoPIN
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	a := s.attempts[profile.Id]
+	if a != nil && now.Before(a.lockedUntil) {
+		return "", time.Time{}, ErrLocked
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(stored.GetString("hash")), []byte(pin)) != nil {
+		if a == nil || now.Sub(a.first) > LockoutDuration {
+			a = &attempts{first: now}
+			s.attempts[profile.Id] = a
+		}
+		a.failures++
+		if a.failures >= MaxPINAttempts {
+			a.lockedUntil = now.Add(LockoutDuration)
+			s.logger.Warn("profile locked after wrong PINs", "profile_id", profile.Id, "attempts", a.failures)
+		}
+		return "", time.Time{}, ErrInvalidPIN
+	}
+
+	delete(s.attempts, profile.Id)
+	expires := now.Add(UnlockTTL)
+	return s.sign(profile.Id, expires), expires, nil
+}
+
+// SetPIN stores the bcrypt hash of a new PIN for a profile
+func (s *Service) SetPIN(profile *models.Record, pin string) error {
+	if !pinPattern.MatchString(pin) {
+		return ErrPINFormat
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(pin), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	return s.app.Dao().RunInTransaction(func(txDao *daos.Dao) error {
+		stored, err := txDao.FindFirstRecordByData(PINCollection, "profile", profile.Id)
+		if err != nil {
+			collection, err := txDao.FindCollectionByNameOrId(PINCollection)
+			if err != nil {
+				return err
+			}
+			stored = models.NewRecord(collection)
+			stored.Set("profile", profile.Id)
+		}
+		stored.Set("hash", string(hash))
+		if err := txDao.SaveRecord(stored); err != nil {
+			return err
+		}
+
+		profile.Set(HasPINField, true)
+		return txDao.SaveRecord(profile)
+	})
+}
+
+// RemovePIN deletes the PIN of a profile
+func (s *Service) RemovePIN(profile *models.Record) error {
+	return s.app.Dao().RunInTransaction(func(txDao *daos.Dao) error {
+		if stored, err := txDao.FindFirstRecordByData(PINCollection, "profile", profile.Id); err == nil {
+			if err := txDao.DeleteRecord(stored); err != nil {
+				return err
+			}
+		}
+
+		profile.Set(HasPINField, false)
+		return txDao.SaveRecord(profile)
+	})
+}
+
+// HasPIN reports whether a profile is protected by a PIN
+func HasPIN(profile *models.Record) bool {
+	return profile.GetBool(HasPINField)
+}
+
+// GuardPINFilter refuses record queries filtering or sorting on PIN hashes
+// (through @collection or a back relation), which would let clients read
+// them back one character at a time
+func GuardPINFilter() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !strings.HasPrefix(c.Path(), "/api/collections/") {
+				return next(c)
+			}
+			for _, param := range []string{"filter", "sort"} {
+				if strings.Contains(c.QueryParam(param), PINCollection) {
+					return apis.NewBadRequestError("Profiles can't be filtered or sorted by PIN", nil)
+				}
+			}
+			return next(c)
+		}
+	}
+}
+
+func (s *Service) sign(profileID string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(profileID + "|" + exp))
+	return exp + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Unlocked reports whether a request carries a valid unlock token for a profile
+func (s *Service) Unlocked(c echo.Context, profileID string) bool {
+	token := c.Request().Header.Get(UnlockHeader)
+	exp, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return false
+	}
+
+	return hmac.Equal([]byte(token), []byte(s.sign(profileID, time.Unix(unix, 0))))
+}
+
+// ActiveRestrictions returns the restrictions a request is made under, nil
+// when there are none. Requests for a PIN protected profile of the user they
+// unlocked have none. Any other request gets the restrictions of all the
+// user's locked profiles combined, so naming a profile in ProfileHeader
+// without its PIN lifts nothing.
+func (s *Service) ActiveRestrictions(c echo.Context) *Restrictions {
+	authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+	if authRecord == nil {
+		return nil
+	}
+
+	if profileID := c.Request().Header.Get(ProfileHeader); profileID != "" {
+		profile, err := s.app.Dao().FindRecordById("profiles", profileID)
+		if err == nil && OwnedBy(profile, authRecord.Id) && HasPIN(profile) && s.Unlocked(c, profile.Id) {
+			return nil
+		}
+	}
+
+	profiles, err := s.app.Dao().FindRecordsByFilter("profiles", "user ~ {:user}", "", 0, 0, dbx.Params{"user": authRecord.Id})
+	if err != nil {
+		s.logger.Warn("failed to load profiles", "user_id", authRecord.Id, "error", err)
+		return nil
+	}
+
+	var combined Restrictions
+	for _, profile := range profiles {
+		restrictions := s.lockedRestrictions(c, profile)
+		if restrictions == nil {
+			continue
+		}
+		combined.Kids = combined.Kids || restrictions.Kids
+		combined.BlockedGroups = append(combined.BlockedGroups, restrictions.BlockedGroups...)
+		combined.BlockedChannels = append(combined.BlockedChannels, restrictions.BlockedChannels...)
+	}
+	if combined.Empty() {
+		return nil
+	}
+	return &combined
+}
+
+// lockedRestrictions returns the restrictions of a profile, nil when there
+// are none or the request unlocked it
+func (s *Service) lockedRestrictions(c echo.Context, profile *models.Record) *Restrictions {
+	restrictions := RestrictionsOf(profile)
+	if restrictions.Empty() || s.Unlocked(c, profile.Id) {
+		return nil
+	}
+	return &restrictions
+}
+
+// CheckView refuses to show a channel or program hidden from the active profile
+func (s *Service) CheckView(c echo.Context, record *models.Record) error {
+	name := record.Collection().Name
+	if name != "channels" && name != "epg_programs" {
+		return nil
+	}
+
+	restrictions := s.ActiveRestrictions(c)
+	if restrictions == nil {
+		return nil
+	}
+
+	if name == "channels" && restrictions.BlocksChannel(record) ||
+		name == "epg_programs" && s.blockedTvgIDs(restrictions, []*models.Record{record})[record.GetString("channel_id")] {
+		return apis.NewNotFoundError("", nil)
+	}
+	return nil
+}
+
+// blockedTvgIDs returns the EPG ids, among those of programs, of the
+// channels hidden by restrictions
+func (s *Service) blockedTvgIDs(restrictions *Restrictions, programs []*models.Record) map[string]bool {
+	ids := make([]any, 0, len(programs))
+	for _, program := range programs {
+		if id := program.GetString("channel_id"); id != "" {
+			ids = append(ids, id)
+		}
+	}
+
+	blocked := make(map[string]bool)
+	if len(ids) == 0 {
+		return blocked
+	}
+
+	channels := []*models.Record{}
+	err := s.app.Dao().RecordQuery("channels").
+		AndWhere(dbx.In("tvg_id", ids...)).
+		All(&channels)
+	if err != nil {
+		s.logger.Warn("failed to load channels of programs", "error", err)
+		return blocked
+	}
+
+	for _, channel := range channels {
+		if restrictions.BlocksChannel(channel) {
+			blocked[channel.GetString("tvg_id")] = true
+		}
+	}
+	return blocked
+}
+
+// CheckNewProfile refuses profiles created with has_pin, PINs are set
+// through the PIN endpoint so they are always hashed
+func CheckNewProfile(profile *models.Record) error {
+	if HasPIN(profile) {
+		return apis.NewBadRequestError("Set the PIN with PUT /api/profiles/:id/pin", nil)
+	}
+	return nil
+}
+
+// ProtectProfile refuses changes to the PIN and restrictions of a profile
+// with a PIN, and its deletion, unless the request unlocked it
+func (s *Service) ProtectProfile(c echo.Context, profile *models.Record, deleting bool) error {
+	original := profile.OriginalCopy()
+	if !deleting && HasPIN(original) != HasPIN(profile) {
+		return apis.NewBadRequestError("Set the PIN with PUT /api/profiles/:id/pin", nil)
+	}
+	if !HasPIN(original) || s.Unlocked(c, profile.Id) {
+		return nil
+	}
+
+	if deleting {
+		return apis.NewForbiddenError("Enter the profile PIN to delete it", nil)
+	}
+
+	for _, field := range restrictedFields {
+		if fmt.Sprint(original.Get(field)) != fmt.Sprint(profile.Get(field)) {
+			return apis.NewForbiddenError("Enter the profile PIN to change its parental controls", nil)
+		}
+	}
+	return nil
+}
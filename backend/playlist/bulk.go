@@ -0,0 +1,155 @@
+package playlist
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+)
+
+// Bulk channel actions
+const (
+	BulkEnable   = "enable"
+	BulkDisable  = "disable"
+	BulkSetGroup = "set_group"
+	BulkReorder  = "reorder"
+	BulkReset    = "reset" // Let syncs update the group and position again
+	BulkDelete   = "delete"
+)
+
+// MaxBulkChannels bounds the channels of one bulk operation
+const MaxBulkChannels = 10000
+
+var (
+	ErrBulkAction   = errors.New("unknown bulk action")
+	ErrBulkEmpty    = errors.New("no channels given")
+	ErrBulkTooLarge = fmt.Errorf("too many channels, at most %d per operation", MaxBulkChannels)
+	ErrBulkGroup    = errors.New("group must be at most 100 characters")
+)
+
+// MissingChannelsError lists the channels of a bulk operation that don't
+// exist or belong to playlists of another user. Nothing is applied.
+type MissingChannelsError struct {
+	IDs []string
+}
+
+func (e *MissingChannelsError) Error() string {
+	return "channels not found: " + strings.Join(e.IDs, ", ")
+}
+
+// BulkOperation applies one action to many channels
+type BulkOperation struct {
+	Action string   `json:"action"`
+	IDs    []string `json:"ids"`
+	Group  string   `json:"group"` // set_group: the new group, empty to ungroup
+	Start  int      `json:"start"` // reorder: position of the first channel, the others follow in order
+}
+
+// BulkResult summarizes a bulk operation
+type BulkResult struct {
+	Action   string `json:"action"`
+	Affected int    `json:"affected"`
+}
+
+// Bulk applies an operation to channels of the user's playlists in one
+// transaction: either every channel is updated or none is. Regrouped and
+// reordered channels are locked so playlist syncs keep their group and
+// position; deleted channels come back at the next sync if the playlist
+// still has them, disabling them is what lasts.
+func Bulk(app core.App, userID string, op BulkOperation) (*BulkResult, error) {
+	switch op.Action {
+	case BulkEnable, BulkDisable, BulkSetGroup, BulkReorder, BulkReset, BulkDelete:
+	default:
+		return nil, ErrBulkAction
+	}
+
+	// Duplicated IDs would get several positions
+	ids := make([]string, 0, len(op.IDs))
+	seen := make(map[string]bool, len(op.IDs))
+	for _, id := range op.IDs {
+		if id = strings.TrimSpace(id); id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, ErrBulkEmpty
+	}
+	if len(ids) > MaxBulkChannels {
+		return nil, ErrBulkTooLarge
+	}
+
+	group := strings.TrimSpace(op.Group)
+	if len([]rune(group)) > 100 {
+		return nil, ErrBulkGroup
+	}
+
+	dao := app.Dao()
+
+	owned, err := OwnedIDs(dao, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	channels, err := dao.FindRecordsByIds("channels", ids)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]bool, len(channels))
+	for _, channel := range channels {
+		// Relation fields may be stored as arrays
+		if slices.ContainsFunc(channel.GetStringSlice("playlist"), func(id string) bool { return owned[id] }) {
+			found[channel.Id] = true
+		}
+	}
+	if len(found) < len(ids) {
+		missing := make([]string, 0, len(ids)-len(found))
+		for _, id := range ids {
+			if !found[id] {
+				missing = append(missing, id)
+			}
+		}
+		return nil, &MissingChannelsError{IDs: missing}
+	}
+
+	// Positions follow the order of the request, not of the query
+	position := make(map[string]int, len(ids))
+	for i, id := range ids {
+		position[id] = op.Start + i
+	}
+
+	err = dao.RunInTransaction(func(txDao *daos.Dao) error {
+		for _, channel := range channels {
+			switch op.Action {
+			case BulkDelete:
+				if err := txDao.DeleteRecord(channel); err != nil {
+					return err
+				}
+				continue
+			case BulkEnable, BulkDisable:
+				channel.Set("is_active", op.Action == BulkEnable)
+			case BulkSetGroup:
+				channel.Set("group_title", group)
+				channel.Set("locked", true)
+			case BulkReorder:
+				channel.Set("sort_order", position[channel.Id])
+				channel.Set("locked", true)
+			case BulkReset:
+				channel.Set("locked", false)
+			}
+			if err := txDao.SaveRecord(channel); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &BulkResult{Action: op.Action, Affected: len(channels)}, nil
+}
@@ -0,0 +1,390 @@
+package playlist
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+
+	"iptv-backend/upstream"
+)
+
+// ImportOptions controls how a playlist import is applied
+type ImportOptions struct {
+	Prune bool `json:"prune"` // Remove channels no longer present upstream
+
+	// Report receives the counts so far while channels are applied
+	Report func(result ImportResult) `json:"-"`
+}
+
+// ImportResult summarizes a playlist import
+type ImportResult struct {
+	PlaylistID string `json:"playlist_id"`
+	Parsed     int    `json:"parsed"`
+	Inserted   int    `json:"inserted"`
+	Updated    int    `json:"updated"`
+	Unchanged  int    `json:"unchanged"`
+	Removed    int    `json:"removed"`
+	Missing    int    `json:"missing"` // Gone upstream, kept as the import doesn't prune
+	Failed     int    `json:"failed"`
+	EPGURL     string `json:"epg_url,omitempty"`
+	Diff       *Diff  `json:"diff,omitempty"`
+}
+
+// MaxDiffEntries bounds each list of a diff, the counts of the result
+// cover the rest
+const MaxDiffEntries = 100
+
+// Diff lists what an import changed, so users can see what their provider
+// updated
+type Diff struct {
+	Added   []ChannelRef    `json:"added"`
+	Changed []ChannelChange `json:"changed"`
+	Removed []ChannelRef    `json:"removed"`
+	Missing []ChannelRef    `json:"missing"`
+}
+
+// ChannelRef names a channel of a diff
+type ChannelRef struct {
+	ID    string `json:"id,omitempty"` // Empty for removed channels
+	Name  string `json:"name"`
+	Group string `json:"group,omitempty"`
+}
+
+// ChannelChange is a channel updated by an import, with its previous name
+// when it was renamed
+type ChannelChange struct {
+	ChannelRef
+	Fields  []string `json:"fields"`
+	OldName string   `json:"old_name,omitempty"`
+}
+
+func newDiff() *Diff {
+	return &Diff{
+		Added:   []ChannelRef{},
+		Changed: []ChannelChange{},
+		Removed: []ChannelRef{},
+		Missing: []ChannelRef{},
+	}
+}
+
+func refOf(record *models.Record) ChannelRef {
+	return ChannelRef{ID: record.Id, Name: record.GetString("name"), Group: record.GetString("group_title")}
+}
+
+// importBatchSize is how many channels are written per transaction
+const importBatchSize = 500
+
+// ProgressFunc reports import progress (0-100)
+type ProgressFunc func(progress float64, message string)
+
+// Fetch downloads and parses the playlist at rawURL, sending the headers
+// its provider requires
+func Fetch(ctx context.Context, rawURL string, headers upstream.Headers) (*Parsed, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	headers.Apply(req)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch playlist: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("playlist server returned status %d", resp.StatusCode)
+	}
+
+	return ParseM3U(resp.Body)
+}
+
+// Import fetches a playlist's URL and upserts its channels (matched by stream URL)
+func Import(ctx context.Context, app core.App, playlistID string, opts ImportOptions, progress ProgressFunc) (*ImportResult, error) {
+	if progress == nil {
+		progress = func(float64, string) {}
+	}
+
+	playlistRecord, err := app.Dao().FindRecordById("playlists", playlistID)
+	if err != nil {
+		return nil, fmt.Errorf("playlist not found: %w", err)
+	}
+
+	playlistURL := playlistRecord.GetString("url")
+	if playlistURL == "" {
+		return nil, fmt.Errorf("playlist has no URL")
+	}
+
+	headers, err := upstream.FromPlaylist(playlistRecord)
+	if err != nil {
+		return nil, fmt.Errorf("invalid playlist headers: %w", err)
+	}
+
+	progress(0, "fetching playlist")
+	parsed, err := Fetch(ctx, playlistURL, headers)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ImportResult{
+		PlaylistID: playlistID,
+		Parsed:     len(parsed.Entries),
+		EPGURL:     parsed.EPGURL,
+	}
+	diff := newDiff()
+
+	report := func() {
+		if opts.Report != nil {
+			opts.Report(*result)
+		}
+	}
+
+	channelsCollection, err := app.Dao().FindCollectionByNameOrId("channels")
+	if err != nil {
+		return nil, err
+	}
+
+	progress(10, "applying channels")
+
+	// Note: using ~ as relation fields may be stored as arrays
+	existing, err := app.Dao().FindRecordsByFilter(
+		channelsCollection.Id,
+		"playlist ~ {:playlist}",
+		"",
+		0,
+		0,
+		dbx.Params{"playlist": playlistID},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	byURL := make(map[string]*models.Record, len(existing))
+	for _, record := range existing {
+		byURL[record.GetString("url")] = record
+	}
+
+	// Channels mapped to a guide channel by their owner keep its id as
+	// tvg_id, the mapping records the provider's
+	mappings, err := app.Dao().FindRecordsByFilter(
+		"epg_mappings",
+		"channel.playlist ~ {:playlist}",
+		"",
+		0,
+		0,
+		dbx.Params{"playlist": playlistID},
+	)
+	if err != nil {
+		return nil, err
+	}
+	mapped := make(map[string]*models.Record, len(mappings))
+	for _, mapping := range mappings {
+		mapped[mapping.GetString("channel")] = mapping
+	}
+
+	seen := make(map[string]bool, len(parsed.Entries))
+
+	apply := func(txDao *daos.Dao, i int, entry Entry) {
+		seen[entry.URL] = true
+
+		record, exists := byURL[entry.URL]
+		if !exists {
+			record = models.NewRecord(channelsCollection)
+			record.Set("playlist", playlistID)
+			record.Set("is_active", true)
+		}
+
+		oldName := record.GetString("name")
+		mapping := mapped[record.Id]
+		changed := applyEntry(record, entry, i, mapping != nil)
+		if tvgID := truncate(entry.TvgID, 200); mapping != nil && mapping.GetString("provider_tvg_id") != tvgID {
+			mapping.Set("provider_tvg_id", tvgID)
+			if err := txDao.SaveRecord(mapping); err != nil {
+				result.Failed++
+			}
+		}
+
+		switch {
+		case !exists:
+			if err := txDao.SaveRecord(record); err != nil {
+				result.Failed++
+			} else {
+				result.Inserted++
+				byURL[entry.URL] = record
+				if len(diff.Added) < MaxDiffEntries {
+					diff.Added = append(diff.Added, refOf(record))
+				}
+			}
+		case len(changed) > 0:
+			if err := txDao.SaveRecord(record); err != nil {
+				result.Failed++
+			} else {
+				result.Updated++
+				if len(diff.Changed) < MaxDiffEntries {
+					change := ChannelChange{ChannelRef: refOf(record), Fields: changed}
+					if oldName != record.GetString("name") {
+						change.OldName = oldName
+					}
+					diff.Changed = append(diff.Changed, change)
+				}
+			}
+		default:
+			result.Unchanged++
+		}
+	}
+
+	// Channels are applied in batches so progress can be saved and other
+	// writes go through between them: a transaction holds the database's
+	// only write connection. Upserts are idempotent, a retried import picks
+	// up where a failed one stopped.
+	for start := 0; start < len(parsed.Entries); start += importBatchSize {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		end := min(start+importBatchSize, len(parsed.Entries))
+		err := app.Dao().RunInTransaction(func(txDao *daos.Dao) error {
+			for i := start; i < end; i++ {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				apply(txDao, i, parsed.Entries[i])
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		progress(10+float64(end)/float64(len(parsed.Entries))*80, fmt.Sprintf("%d/%d channels", end, len(parsed.Entries)))
+		report()
+	}
+
+	if opts.Prune {
+		progress(90, "removing missing channels")
+	}
+
+	gone := make([]*models.Record, 0)
+	for channelURL, record := range byURL {
+		if seen[channelURL] {
+			continue
+		}
+		if !opts.Prune {
+			result.Missing++
+			if len(diff.Missing) < MaxDiffEntries {
+				diff.Missing = append(diff.Missing, refOf(record))
+			}
+			continue
+		}
+		gone = append(gone, record)
+	}
+
+	for start := 0; start < len(gone); start += importBatchSize {
+		end := min(start+importBatchSize, len(gone))
+		err := app.Dao().RunInTransaction(func(txDao *daos.Dao) error {
+			for _, record := range gone[start:end] {
+				if err := txDao.DeleteRecord(record); err != nil {
+					result.Failed++
+					continue
+				}
+				result.Removed++
+				if len(diff.Removed) < MaxDiffEntries {
+					ref := refOf(record)
+					ref.ID = ""
+					diff.Removed = append(diff.Removed, ref)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		report()
+	}
+
+	playlistRecord.Set("last_synced", time.Now())
+	if err := app.Dao().SaveRecord(playlistRecord); err != nil {
+		return nil, err
+	}
+
+	progress(100, "done")
+
+	result.Diff = diff
+	return result, nil
+}
+
+// applyEntry copies entry fields onto a channel record and returns the fields
+// that changed, sorted. The tvg_id of a channel mapped to a guide channel
+// is kept.
+func applyEntry(record *models.Record, entry Entry, index int, mapped bool) []string {
+	logo := entry.TvgLogo
+	if logo != "" {
+		// tvg_logo is a URL field, invalid values would fail validation
+		if u, err := url.ParseRequestURI(logo); err != nil || u.Host == "" {
+			logo = ""
+		}
+	}
+
+	fields := map[string]string{
+		"name":     truncate(entry.Name, 200),
+		"url":      entry.URL,
+		"tvg_id":   truncate(entry.TvgID, 200),
+		"tvg_name": truncate(entry.TvgName, 200),
+		"tvg_logo": logo,
+		"language": truncate(entry.Language, 50),
+		"country":  truncate(entry.Country, 50),
+	}
+
+	if mapped {
+		delete(fields, "tvg_id")
+	}
+
+	// Older schemas may lack some optional fields, skip those
+	schema := record.Collection().Schema
+
+	// Channels regrouped or reordered by their owner keep their group and
+	// position
+	locked := record.GetBool("locked")
+	if !locked {
+		fields["group_title"] = truncate(entry.GroupTitle, 100)
+	}
+
+	changed := []string{}
+	for key, value := range fields {
+		if schema.GetFieldByName(key) == nil {
+			continue
+		}
+		if record.GetString(key) != value {
+			record.Set(key, value)
+			changed = append(changed, key)
+		}
+	}
+
+	if !locked && schema.GetFieldByName("sort_order") != nil && record.GetInt("sort_order") != index {
+		record.Set("sort_order", index)
+		changed = append(changed, "sort_order")
+	}
+
+	sort.Strings(changed)
+	return changed
+}
+
+func truncate(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max])
+}
@@ -0,0 +1,125 @@
+package playlist
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Entry is a single channel parsed from an M3U playlist
+type Entry struct {
+	TvgID      string `json:"tvg_id,omitempty"`
+	TvgName    string `json:"tvg_name,omitempty"`
+	TvgLogo    string `json:"tvg_logo,omitempty"`
+	GroupTitle string `json:"group_title"`
+	Name       string `json:"name"`
+	URL        string `json:"url"`
+	Language   string `json:"language,omitempty"`
+	Country    string `json:"country,omitempty"`
+}
+
+// Parsed is the result of parsing an M3U playlist
+type Parsed struct {
+	Name    string  `json:"name,omitempty"`
+	EPGURL  string  `json:"epg_url,omitempty"`
+	Entries []Entry `json:"entries"`
+}
+
+var attributePattern = regexp.MustCompile(`([a-zA-Z0-9-]+)="([^"]*)"`)
+
+// ParseM3U parses an extended M3U playlist.
+// It mirrors the frontend parser so both produce the same channel fields.
+func ParseM3U(r io.Reader) (*Parsed, error) {
+	result := &Parsed{Entries: make([]Entry, 0)}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var currentExtInf string
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		// Parse #EXTM3U header and extract EPG URL
+		if strings.HasPrefix(line, "#EXTM3U") {
+			attrs := parseAttributes(line)
+			for _, key := range []string{"url-tvg", "x-tvg-url", "tvg-url"} {
+				if attrs[key] != "" {
+					result.EPGURL = attrs[key]
+					break
+				}
+			}
+			continue
+		}
+
+		if strings.HasPrefix(line, "#PLAYLIST:") {
+			result.Name = strings.TrimSpace(strings.TrimPrefix(line, "#PLAYLIST:"))
+			continue
+		}
+
+		if strings.HasPrefix(line, "#EXTINF:") {
+			currentExtInf = line
+			continue
+		}
+
+		// Skip other directives
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// This should be a URL line
+		if currentExtInf != "" && (strings.HasPrefix(line, "http://") || strings.HasPrefix(line, "https://")) {
+			attrs := parseAttributes(currentExtInf)
+			name := parseChannelName(currentExtInf)
+
+			entry := Entry{
+				TvgID:      attrs["tvg-id"],
+				TvgName:    attrs["tvg-name"],
+				TvgLogo:    attrs["tvg-logo"],
+				GroupTitle: attrs["group-title"],
+				Name:       name,
+				URL:        line,
+				Language:   attrs["tvg-language"],
+				Country:    attrs["tvg-country"],
+			}
+			if entry.TvgName == "" {
+				entry.TvgName = name
+			}
+			if entry.GroupTitle == "" {
+				entry.GroupTitle = "Uncategorized"
+			}
+
+			result.Entries = append(result.Entries, entry)
+			currentExtInf = ""
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// parseAttributes extracts key="value" pairs from an M3U directive line
+func parseAttributes(line string) map[string]string {
+	attrs := make(map[string]string)
+	for _, match := range attributePattern.FindAllStringSubmatch(line, -1) {
+		attrs[strings.ToLower(match[1])] = match[2]
+	}
+	return attrs
+}
+
+// parseChannelName returns the channel name after the last comma
+func parseChannelName(line string) string {
+	if idx := strings.LastIndex(line, ","); idx != -1 {
+		if name := strings.TrimSpace(line[idx+1:]); name != "" {
+			return name
+		}
+	}
+	return "Unknown Channel"
+}
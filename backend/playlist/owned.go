@@ -0,0 +1,20 @@
+package playlist
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+)
+
+// OwnedIDs returns the set of ids of the user's playlists
+func OwnedIDs(dao *daos.Dao, userID string) (map[string]bool, error) {
+	playlists, err := dao.FindRecordsByFilter("playlists", "user ~ {:user}", "", 0, 0,
+		dbx.Params{"user": userID})
+	if err != nil {
+		return nil, err
+	}
+	owned := make(map[string]bool, len(playlists))
+	for _, playlist := range playlists {
+		owned[playlist.Id] = true
+	}
+	return owned, nil
+}
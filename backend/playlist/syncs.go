@@ -0,0 +1,87 @@
+package playlist
+
+import (
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+)
+
+// SyncsCollection holds the history of playlist imports
+const SyncsCollection = "playlist_syncs"
+
+// SyncHistory is how many imports are kept per playlist
+const SyncHistory = 20
+
+// Sync is a finished playlist import
+type Sync struct {
+	PlaylistID string
+	User       string
+	JobID      string
+	Status     string // completed, failed
+	Error      string
+	Result     *ImportResult // Nil when the import failed
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+// RecordSync stores a finished import and its diff, dropping the oldest of
+// the playlist beyond SyncHistory
+func RecordSync(app core.App, sync Sync) (*models.Record, error) {
+	dao := app.Dao()
+
+	collection, err := dao.FindCollectionByNameOrId(SyncsCollection)
+	if err != nil {
+		return nil, err
+	}
+
+	record := models.NewRecord(collection)
+	record.Set("playlist", sync.PlaylistID)
+	record.Set("user", sync.User)
+	record.Set("job", sync.JobID)
+	record.Set("status", sync.Status)
+	record.Set("error", truncate(sync.Error, 2000))
+	if result := sync.Result; result != nil {
+		record.Set("parsed", result.Parsed)
+		record.Set("inserted", result.Inserted)
+		record.Set("changed", result.Updated)
+		record.Set("unchanged", result.Unchanged)
+		record.Set("removed", result.Removed)
+		record.Set("missing", result.Missing)
+		record.Set("failed", result.Failed)
+		record.Set("diff", result.Diff)
+	}
+	if !sync.StartedAt.IsZero() {
+		record.Set("started_at", sync.StartedAt)
+	}
+	record.Set("finished_at", sync.FinishedAt)
+
+	if err := dao.SaveRecord(record); err != nil {
+		return nil, err
+	}
+
+	old, err := dao.FindRecordsByFilter(SyncsCollection, "playlist = {:playlist}", "-created", 0, SyncHistory,
+		dbx.Params{"playlist": sync.PlaylistID})
+	if err != nil {
+		return record, err
+	}
+	for _, stale := range old {
+		if err := dao.DeleteRecord(stale); err != nil {
+			return record, err
+		}
+	}
+
+	return record, nil
+}
+
+// LastSync returns the latest finished import of a playlist, nil when it
+// was never imported
+func LastSync(app core.App, playlistID string) *models.Record {
+	records, err := app.Dao().FindRecordsByFilter(SyncsCollection, "playlist = {:playlist}", "-created", 1, 0,
+		dbx.Params{"playlist": playlistID})
+	if err != nil || len(records) == 0 {
+		return nil
+	}
+	return records[0]
+}
@@ -0,0 +1,376 @@
+// Package postprocess runs the server's post-recording hooks: a shell
+// command and a webhook, configured by the administrator, that receive the
+// metadata of each finished recording so files can be transcoded, moved or
+// announced to other tools.
+package postprocess
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+
+	"iptv-backend/jobs"
+	"iptv-backend/logging"
+	"iptv-backend/recorder"
+	"iptv-backend/webhooks"
+)
+
+// Job types of the hooks. Commands run once, since they may move or delete
+// files; webhook deliveries are retried with backoff.
+const (
+	CommandJobType = "recording.hook_command"
+	URLJobType     = "recording.hook_url"
+)
+
+// Events hooks can run on
+const (
+	EventCompleted = string(webhooks.EventRecordingCompleted) // Including recordings cut short
+	EventFailed    = string(webhooks.EventRecordingFailed)
+)
+
+// maxOutput bounds the command output kept in the job result
+const maxOutput = 4096
+
+// Config configures the hooks. Hooks left empty don't run.
+type Config struct {
+	Command string        // Run with sh -c
+	Timeout time.Duration // Of the command, 0 for no limit
+	URL     string        // POSTed the metadata
+	Secret  string        // Signs webhook deliveries
+	Events  []string      // EventCompleted by default
+
+	// RecordingsDir returns the directory holding the recordings. With a
+	// storage backend they are moved off it, and hooks get no local path.
+	RecordingsDir func() string
+	Storage       string // Name of the storage backend, empty for none
+}
+
+// Recording is the metadata hooks receive
+type Recording struct {
+	Event           string     `json:"event"`
+	ID              string     `json:"recording_id"`
+	User            string     `json:"user"`
+	Profile         string     `json:"profile,omitempty"`
+	Schedule        string     `json:"schedule,omitempty"`
+	ChannelID       string     `json:"channel_id,omitempty"`
+	ChannelName     string     `json:"channel_name,omitempty"`
+	ChannelGroup    string     `json:"channel_group,omitempty"`
+	Title           string     `json:"title"`
+	Status          string     `json:"status"`
+	File            string     `json:"file"`
+	Path            string     `json:"path,omitempty"`
+	Storage         string     `json:"storage,omitempty"`
+	ScheduledStart  *time.Time `json:"scheduled_start,omitempty"`
+	ScheduledEnd    *time.Time `json:"scheduled_end,omitempty"`
+	StartedAt       time.Time  `json:"started_at"`
+	StoppedAt       *time.Time `json:"stopped_at,omitempty"`
+	DurationSeconds int64      `json:"duration_seconds"`
+	BytesWritten    int64      `json:"bytes_written"`
+	Error           string     `json:"error,omitempty"`
+}
+
+// hookJob is the job payload of a hook run
+type hookJob struct {
+	Recording Recording       `json:"recording"`
+	Body      json.RawMessage `json:"body"` // Webhook payload, identical across retries
+}
+
+// Service runs the hooks as background jobs
+type Service struct {
+	app    core.App
+	jobs   *jobs.Manager
+	config Config
+	client *http.Client
+	logger *slog.Logger
+}
+
+// NewService creates the hook service and registers the job types of the
+// configured hooks on jobManager
+func NewService(app core.App, jobManager *jobs.Manager, config Config) *Service {
+	if len(config.Events) == 0 {
+		config.Events = []string{EventCompleted}
+	}
+
+	s := &Service{
+		app:    app,
+		jobs:   jobManager,
+		config: config,
+		client: &http.Client{Timeout: 30 * time.Second},
+		logger: logging.For("postprocess"),
+	}
+
+	if config.Command != "" {
+		jobManager.Register(CommandJobType, s.runCommand, jobs.TypeOptions{
+			MaxAttempts: 1,
+			Concurrency: 2,
+			Timeout:     config.Timeout,
+		})
+	}
+	if config.URL != "" {
+		jobManager.Register(URLJobType, s.deliver, jobs.TypeOptions{
+			MaxAttempts: 5,
+			Concurrency: 4,
+			Timeout:     time.Minute,
+		})
+	}
+
+	return s
+}
+
+// Enabled reports whether a hook is configured
+func (s *Service) Enabled() bool {
+	return s.config.Command != "" || s.config.URL != ""
+}
+
+// HandleRecorderEvent queues the hooks for a finished or failed recording
+func (s *Service) HandleRecorderEvent(event recorder.Event, rec *recorder.Recording, err error) {
+	var name string
+	switch event {
+	case recorder.EventFinished:
+		name = EventCompleted
+	case recorder.EventFailed:
+		name = EventFailed
+	default:
+		return
+	}
+	if !s.Enabled() || !s.wants(name) {
+		return
+	}
+
+	recording := s.describe(name, rec, err)
+	body, marshalErr := json.Marshal(webhooks.Payload{
+		ID:    newDeliveryID(),
+		Event: webhooks.EventType(name),
+		Time:  time.Now().UTC(),
+		Data:  recording.data(),
+	})
+	if marshalErr != nil {
+		return
+	}
+
+	// Hooks are the administrator's, their jobs belong to no user
+	payload := hookJob{Recording: recording, Body: body}
+	if s.config.Command != "" {
+		if _, err := s.jobs.Enqueue(CommandJobType, "", payload); err != nil {
+			s.logger.Warn("failed to queue recording hook", "recording_id", rec.ID, "error", err)
+		}
+	}
+	if s.config.URL != "" {
+		if _, err := s.jobs.Enqueue(URLJobType, "", payload); err != nil {
+			s.logger.Warn("failed to queue recording webhook", "recording_id", rec.ID, "error", err)
+		}
+	}
+}
+
+func (s *Service) wants(event string) bool {
+	for _, e := range s.config.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// describe gathers the metadata of a recording, with what the recordings
+// collection and the channel know about it
+func (s *Service) describe(event string, rec *recorder.Recording, err error) Recording {
+	info := rec.Info()
+	recording := Recording{
+		Event:           event,
+		ID:              info.ID,
+		User:            rec.UserID,
+		ChannelID:       info.ChannelID,
+		Title:           info.Title,
+		Status:          string(info.Status),
+		File:            filepath.Base(info.OutputPath),
+		Storage:         s.config.Storage,
+		StartedAt:       info.StartedAt,
+		StoppedAt:       info.StoppedAt,
+		DurationSeconds: info.Duration,
+		BytesWritten:    info.BytesWritten,
+	}
+	if s.config.Storage == "" {
+		recording.Path = info.OutputPath
+	}
+	if err != nil {
+		recording.Error = err.Error()
+	}
+
+	// Scheduled recordings have the recorder's id, those started by hand
+	// are found by their file
+	dao := s.app.Dao()
+	record, findErr := dao.FindRecordById("recordings", info.ID)
+	if findErr != nil {
+		record, findErr = dao.FindFirstRecordByFilter("recordings", "file_path = {:name}", dbx.Params{"name": recording.File})
+	}
+	if findErr == nil {
+		recording.Profile = record.GetString("profile")
+		recording.Schedule = record.GetString("schedule")
+		if start := record.GetDateTime("scheduled_start"); !start.IsZero() {
+			t := start.Time()
+			recording.ScheduledStart = &t
+		}
+		if end := record.GetDateTime("scheduled_end"); !end.IsZero() {
+			t := end.Time()
+			recording.ScheduledEnd = &t
+		}
+	}
+	if info.ChannelID != "" {
+		if channel, findErr := dao.FindRecordById("channels", info.ChannelID); findErr == nil {
+			recording.ChannelName = channel.GetString("name")
+			recording.ChannelGroup = channel.GetString("group_title")
+		}
+	}
+
+	return recording
+}
+
+// data returns the metadata as webhook payload data
+func (r Recording) data() map[string]interface{} {
+	raw, _ := json.Marshal(r)
+	data := make(map[string]interface{})
+	json.Unmarshal(raw, &data)
+	delete(data, "event")
+	return data
+}
+
+// env returns the metadata as STREAMVAULT_* environment variables
+func (r Recording) env() []string {
+	vars := map[string]string{
+		"EVENT":            r.Event,
+		"RECORDING_ID":     r.ID,
+		"USER_ID":          r.User,
+		"PROFILE_ID":       r.Profile,
+		"SCHEDULE_ID":      r.Schedule,
+		"CHANNEL_ID":       r.ChannelID,
+		"CHANNEL_NAME":     r.ChannelName,
+		"CHANNEL_GROUP":    r.ChannelGroup,
+		"TITLE":            r.Title,
+		"STATUS":           r.Status,
+		"FILE":             r.File,
+		"PATH":             r.Path,
+		"STORAGE":          r.Storage,
+		"STARTED_AT":       r.StartedAt.UTC().Format(time.RFC3339),
+		"DURATION_SECONDS": strconv.FormatInt(r.DurationSeconds, 10),
+		"BYTES_WRITTEN":    strconv.FormatInt(r.BytesWritten, 10),
+		"ERROR":            r.Error,
+	}
+	if r.StoppedAt != nil {
+		vars["STOPPED_AT"] = r.StoppedAt.UTC().Format(time.RFC3339)
+	}
+
+	env := make([]string, 0, len(vars))
+	for name, value := range vars {
+		env = append(env, "STREAMVAULT_"+name+"="+value)
+	}
+	return env
+}
+
+// runCommand is the job handler running the hook command, with the
+// metadata in its environment and as JSON on its standard input
+func (s *Service) runCommand(ctx context.Context, job *jobs.Job, progress jobs.ProgressFunc) (interface{}, error) {
+	var payload hookJob
+	if err := job.DecodePayload(&payload); err != nil {
+		return nil, err
+	}
+	recording := payload.Recording
+
+	stdin, err := json.Marshal(recording)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", s.config.Command)
+	cmd.Dir = s.config.RecordingsDir()
+	cmd.Env = append(os.Environ(), recording.env()...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	output := &tailBuffer{max: maxOutput}
+	cmd.Stdout = output
+	cmd.Stderr = output
+	// Don't wait on pipes held open by processes the command left behind
+	cmd.WaitDelay = 5 * time.Second
+
+	started := time.Now()
+	err = cmd.Run()
+	result := map[string]interface{}{
+		"recording_id": recording.ID,
+		"exit_code":    cmd.ProcessState.ExitCode(),
+		"duration_ms":  time.Since(started).Milliseconds(),
+		"output":       output.String(),
+	}
+
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			err = fmt.Errorf("hook command exited with status %d: %s", exitErr.ExitCode(), lastLine(output.String()))
+		}
+		s.logger.Warn("recording hook failed", "recording_id", recording.ID, "error", err)
+		return result, err
+	}
+
+	s.logger.Info("recording hook ran", "recording_id", recording.ID, "duration", time.Since(started).String())
+	return result, nil
+}
+
+// deliver is the job handler POSTing the metadata to the hook URL, signed
+// like user webhooks. Returning an error makes the job manager retry it.
+func (s *Service) deliver(ctx context.Context, job *jobs.Job, progress jobs.ProgressFunc) (interface{}, error) {
+	var payload hookJob
+	if err := job.DecodePayload(&payload); err != nil {
+		return nil, err
+	}
+
+	var delivery struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(payload.Body, &delivery)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.URL, bytes.NewReader(payload.Body))
+	if err != nil {
+		return nil, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "StreamVault-Webhooks/1.0")
+	req.Header.Set(webhooks.EventHeader, payload.Recording.Event)
+	req.Header.Set(webhooks.DeliveryHeader, delivery.ID)
+	req.Header.Set(webhooks.TimestampHeader, timestamp)
+	if s.config.Secret != "" {
+		req.Header.Set(webhooks.SignatureHeader, webhooks.Sign(s.config.Secret, timestamp, payload.Body))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		s.logger.Warn("recording webhook failed", "recording_id", payload.Recording.ID, "error", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		err := fmt.Errorf("endpoint returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+		s.logger.Warn("recording webhook failed", "recording_id", payload.Recording.ID, "error", err)
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"recording_id": payload.Recording.ID,
+		"delivery_id":  delivery.ID,
+		"status":       resp.StatusCode,
+	}, nil
+}
@@ -0,0 +1,240 @@
+// Package quota limits how much disk each user's recordings and subtitle
+// exports take, so one user can't fill a shared instance
+package quota
+
+import (
+	"errors"
+	"log/slog"
+	"sort"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+
+	"iptv-backend/logging"
+	"iptv-backend/recorder"
+	"iptv-backend/storage"
+	"iptv-backend/subtitle"
+)
+
+// ErrQuotaExceeded is returned when a user has used up their quota
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// QuotaField is the users field holding an admin override of the quota in
+// MB: 0 uses the default, Unlimited lifts the quota
+const QuotaField = "storage_quota_mb"
+
+// Unlimited is the QuotaField value lifting a user's quota
+const Unlimited = -1
+
+// Config configures quotas
+type Config struct {
+	DefaultQuota int64 // Bytes per user, 0 is unlimited
+
+	// ListExports returns the subtitle exports, local or stored. Optional.
+	ListExports func() ([]storage.Object, error)
+
+	// SessionOwners returns the user of each running subtitle session.
+	// Exports of ended sessions are attributed through subtitle_sessions.
+	SessionOwners func() map[string]string
+}
+
+// Usage is the storage used by a user and their quota
+type Usage struct {
+	UserID          string `json:"user_id"`
+	Recordings      int    `json:"recordings"`
+	RecordingsBytes int64  `json:"recordings_bytes"`
+	Exports         int    `json:"exports"`
+	ExportsBytes    int64  `json:"exports_bytes"`
+	UsedBytes       int64  `json:"used_bytes"`
+	QuotaBytes      int64  `json:"quota_bytes"` // 0 is unlimited
+	Override        bool   `json:"override"`    // The quota is set for this user
+	Exceeded        bool   `json:"exceeded"`
+}
+
+// Service computes storage usage and enforces quotas
+type Service struct {
+	app      core.App
+	recorder *recorder.RecorderService
+	config   Config
+	logger   *slog.Logger
+}
+
+// NewService returns a quota service over the recorder's files and the
+// subtitle exports
+func NewService(app core.App, rs *recorder.RecorderService, config Config) *Service {
+	return &Service{
+		app:      app,
+		recorder: rs,
+		config:   config,
+		logger:   logging.For("quota"),
+	}
+}
+
+// Check returns ErrQuotaExceeded when the user has used up their quota
+func (s *Service) Check(userID string) error {
+	usage, err := s.Usage(userID)
+	if err != nil {
+		// Don't refuse recordings because usage couldn't be computed
+		s.logger.Warn("failed to compute storage usage", "user_id", userID, "error", err)
+		return nil
+	}
+	if usage.Exceeded {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// Usage returns the storage used by a user
+func (s *Service) Usage(userID string) (Usage, error) {
+	all, err := s.usage()
+	if err != nil {
+		return Usage{}, err
+	}
+
+	usage, ok := all[userID]
+	if !ok {
+		usage = &Usage{UserID: userID}
+	}
+	s.applyQuota(usage)
+
+	return *usage, nil
+}
+
+// AllUsage returns the storage used by every user with files, and by the
+// users with a quota override, sorted by decreasing usage. Files recorded
+// before owners were tracked are reported under an empty user ID.
+func (s *Service) AllUsage() ([]Usage, error) {
+	all, err := s.usage()
+	if err != nil {
+		return nil, err
+	}
+
+	var overridden []string
+	if err := s.app.Dao().DB().
+		Select("id").
+		From("users").
+		Where(dbx.NewExp(QuotaField + " != 0")).
+		Column(&overridden); err != nil {
+		return nil, err
+	}
+	for _, id := range overridden {
+		if _, ok := all[id]; !ok {
+			all[id] = &Usage{UserID: id}
+		}
+	}
+
+	list := make([]Usage, 0, len(all))
+	for _, usage := range all {
+		if usage.UserID != "" {
+			s.applyQuota(usage)
+		}
+		list = append(list, *usage)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].UsedBytes != list[j].UsedBytes {
+			return list[i].UsedBytes > list[j].UsedBytes
+		}
+		return list[i].UserID < list[j].UserID
+	})
+
+	return list, nil
+}
+
+// SetQuota sets a user's quota override in MB: 0 restores the default,
+// Unlimited lifts the quota
+func (s *Service) SetQuota(userID string, quotaMB int) error {
+	if quotaMB < Unlimited {
+		return errors.New("quota must be -1, 0 or a number of MB")
+	}
+
+	user, err := s.app.Dao().FindRecordById("users", userID)
+	if err != nil {
+		return err
+	}
+	user.Set(QuotaField, quotaMB)
+	return s.app.Dao().SaveRecord(user)
+}
+
+// applyQuota fills in the quota of a user and whether it is exceeded
+func (s *Service) applyQuota(usage *Usage) {
+	usage.QuotaBytes = s.config.DefaultQuota
+
+	if user, err := s.app.Dao().FindRecordById("users", usage.UserID); err == nil {
+		switch override := user.GetInt(QuotaField); {
+		case override == Unlimited:
+			usage.QuotaBytes = 0
+			usage.Override = true
+		case override > 0:
+			usage.QuotaBytes = int64(override) << 20
+			usage.Override = true
+		}
+	}
+
+	usage.Exceeded = usage.QuotaBytes > 0 && usage.UsedBytes >= usage.QuotaBytes
+}
+
+// usage adds up the recordings and exports of every user. Protected
+// recordings count too: they take the same room.
+func (s *Service) usage() (map[string]*Usage, error) {
+	all := make(map[string]*Usage)
+	get := func(userID string) *Usage {
+		if all[userID] == nil {
+			all[userID] = &Usage{UserID: userID}
+		}
+		return all[userID]
+	}
+
+	files, err := s.recorder.ListFiles()
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		usage := get(s.recorder.Owner(file.Key))
+		if !recorder.IsSubtitleSidecar(file.Key) {
+			usage.Recordings++
+		}
+		usage.RecordingsBytes += file.Size
+		usage.UsedBytes += file.Size
+	}
+
+	if s.config.ListExports != nil {
+		exports, err := s.config.ListExports()
+		if err != nil {
+			return nil, err
+		}
+
+		owners := s.sessionOwners()
+		for _, export := range exports {
+			sessionID, _ := subtitle.ExportSession(export.Key)
+			usage := get(owners[sessionID])
+			usage.Exports++
+			usage.ExportsBytes += export.Size
+			usage.UsedBytes += export.Size
+		}
+	}
+
+	return all, nil
+}
+
+// sessionOwners maps subtitle session IDs to their user
+func (s *Service) sessionOwners() map[string]string {
+	owners := make(map[string]string)
+
+	var rows []struct {
+		SessionID string `db:"session_id"`
+		User      string `db:"user"`
+	}
+	if err := s.app.Dao().DB().Select("session_id", "user").From("subtitle_sessions").All(&rows); err == nil {
+		for _, row := range rows {
+			owners[row.SessionID] = row.User
+		}
+	}
+
+	if s.config.SessionOwners != nil {
+		for id, user := range s.config.SessionOwners() {
+			owners[id] = user
+		}
+	}
+
+	return owners
+}
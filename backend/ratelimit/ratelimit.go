@@ -0,0 +1,222 @@
+// Package ratelimit bounds how often each user, or each IP address for
+// anonymous requests, may call the endpoints starting expensive work such
+// as ffmpeg processes, so a buggy client or a script can't start hundreds
+// of them at once, and the attempts at a TOTP code, so it can't be guessed.
+package ratelimit
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/models"
+	"golang.org/x/time/rate"
+
+	"iptv-backend/logging"
+)
+
+// Limits of the expensive endpoints
+const (
+	Thumbnails       = "thumbnails"        // Thumbnail captures, batches included
+	SubtitleSessions = "subtitle_sessions" // Subtitle sessions started
+	OllamaTest       = "ollama_test"       // Ollama connection tests
+	RecorderStart    = "recorder_start"    // Recordings started
+	TOTPValidate     = "totp_validate"     // TOTP codes entered at login, per address and per user
+)
+
+// idleTimeout is how long the bucket of a client that stopped calling is
+// kept, full buckets are the same as new ones
+const idleTimeout = 10 * time.Minute
+
+// Limit is how many requests a client may make: PerMinute on average, up
+// to Burst at once. PerMinute 0 lifts the limit.
+type Limit struct {
+	PerMinute int `json:"per_minute"`
+	Burst     int `json:"burst"` // PerMinute when 0
+}
+
+// DefaultLimits are the limits of clients when not configured
+func DefaultLimits() map[string]Limit {
+	return map[string]Limit{
+		Thumbnails:       {PerMinute: 120, Burst: 60},
+		SubtitleSessions: {PerMinute: 10, Burst: 5},
+		OllamaTest:       {PerMinute: 10, Burst: 5},
+		RecorderStart:    {PerMinute: 20, Burst: 10},
+		TOTPValidate:     {PerMinute: 5, Burst: 5},
+	}
+}
+
+// bucket is the token bucket of a client for a limit
+type bucket struct {
+	limiter *rate.Limiter
+	seen    time.Time
+}
+
+// Limiter tracks the requests of every client against the named limits
+type Limiter struct {
+	logger *slog.Logger
+
+	mu        sync.Mutex
+	limits    map[string]Limit
+	buckets   map[string]map[string]*bucket // By limit and client
+	lastSweep time.Time
+}
+
+// New returns a limiter enforcing limits, unknown names being refused by
+// SetConfig
+func New(limits map[string]Limit) *Limiter {
+	l := &Limiter{
+		logger:    logging.For("ratelimit"),
+		limits:    make(map[string]Limit, len(limits)),
+		buckets:   make(map[string]map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+	for name, limit := range limits {
+		l.limits[name] = limit
+		l.buckets[name] = make(map[string]*bucket)
+	}
+	return l
+}
+
+// Config returns the limits in effect
+func (l *Limiter) Config() map[string]Limit {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	config := make(map[string]Limit, len(l.limits))
+	for name, limit := range l.limits {
+		config[name] = limit
+	}
+	return config
+}
+
+// SetConfig validates and applies limits. Limits left out keep their
+// value; clients start over with full buckets.
+func (l *Limiter) SetConfig(config map[string]Limit) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for name, limit := range config {
+		if _, ok := l.limits[name]; !ok {
+			return fmt.Errorf("unknown rate limit %q, one of %v", name, names(l.limits))
+		}
+		if limit.PerMinute < 0 || limit.Burst < 0 {
+			return fmt.Errorf("rate limit %s can't be negative", name)
+		}
+	}
+
+	for name, limit := range config {
+		l.limits[name] = limit
+		l.buckets[name] = make(map[string]*bucket)
+	}
+	return nil
+}
+
+// Allow takes a request of client from the bucket of a limit. When the
+// bucket is empty it returns false and how long until the next request is
+// allowed.
+func (l *Limiter) Allow(name, client string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limit, ok := l.limits[name]
+	if !ok || limit.PerMinute <= 0 {
+		return true, 0
+	}
+
+	now := time.Now()
+	if now.Sub(l.lastSweep) > idleTimeout {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[name][client]
+	if !ok {
+		burst := limit.Burst
+		if burst <= 0 {
+			burst = limit.PerMinute
+		}
+		b = &bucket{limiter: rate.NewLimiter(rate.Limit(float64(limit.PerMinute)/60), burst)}
+		l.buckets[name][client] = b
+	}
+	b.seen = now
+
+	reservation := b.limiter.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		// Refused requests don't use up the next token
+		reservation.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// sweep forgets the clients idle for longer than idleTimeout
+func (l *Limiter) sweep(now time.Time) {
+	for _, clients := range l.buckets {
+		for client, b := range clients {
+			if now.Sub(b.seen) > idleTimeout {
+				delete(clients, client)
+			}
+		}
+	}
+	l.lastSweep = now
+}
+
+// Check takes a request of the client of c from the bucket of a limit. It
+// returns a 429 error with a Retry-After header when the bucket is empty.
+func (l *Limiter) Check(c echo.Context, name string) error {
+	return l.CheckClient(c, name, Client(c))
+}
+
+// CheckClient is Check for a request counted against client, such as the
+// user an anonymous request acts for
+func (l *Limiter) CheckClient(c echo.Context, name, client string) error {
+	allowed, delay := l.Allow(name, client)
+	if allowed {
+		return nil
+	}
+
+	seconds := int(math.Ceil(delay.Seconds()))
+	c.Response().Header().Set("Retry-After", strconv.Itoa(seconds))
+	l.logger.Warn("request rate limited", "limit", name, "client", client, "path", c.Request().URL.Path, "retry_after", seconds)
+	return apis.NewApiError(http.StatusTooManyRequests, fmt.Sprintf("Too many requests, retry in %ds", seconds), nil)
+}
+
+// Middleware refuses the requests of clients over a limit with a 429
+func (l *Limiter) Middleware(name string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if err := l.Check(c, name); err != nil {
+				return err
+			}
+			return next(c)
+		}
+	}
+}
+
+// Client identifies the client of a request: its user or admin, or its IP
+// address when anonymous
+func Client(c echo.Context) string {
+	if record, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record); record != nil {
+		return "user:" + record.Id
+	}
+	if admin, _ := c.Get(apis.ContextAdminKey).(*models.Admin); admin != nil {
+		return "admin:" + admin.Id
+	}
+	return "ip:" + c.RealIP()
+}
+
+func names(limits map[string]Limit) []string {
+	list := make([]string, 0, len(limits))
+	for name := range limits {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
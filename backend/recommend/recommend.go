@@ -0,0 +1,670 @@
+// Package recommend suggests channels and upcoming programmes to a profile
+// from what it watched: the groups and channels it spends time on, the
+// hours of the day it watches at and the categories of the programmes it
+// watched. Suggestions can be reordered by the configured Ollama model.
+package recommend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/types"
+
+	"iptv-backend/logging"
+)
+
+const (
+	// HistoryWindow is how far back watch time is taken into account
+	HistoryWindow = 30 * 24 * time.Hour
+
+	// DefaultLimit and MaxLimit bound the number of suggestions of each kind
+	DefaultLimit = 20
+	MaxLimit     = 50
+
+	// DefaultLookahead and MaxLookahead bound how far ahead programmes are
+	// suggested
+	DefaultLookahead = 6 * time.Hour
+	MaxLookahead     = 24 * time.Hour
+
+	// topGroups favorite groups are searched for channels not watched yet,
+	// at most groupCandidates channels each
+	topGroups       = 5
+	groupCandidates = 200
+
+	// categoryChannels most watched channels are used to learn the
+	// categories of programmes the profile likes
+	categoryChannels = 20
+
+	// refineCandidates programmes are sent to the model
+	refineCandidates = 30
+)
+
+// Generator runs a prompt through a language model
+type Generator interface {
+	Generate(ctx context.Context, prompt, format string) (string, error)
+}
+
+// Config holds the dependencies of the service
+type Config struct {
+	Generator Generator // Nil disables refinement
+}
+
+// Query selects the suggestions of a profile
+type Query struct {
+	Profile   *models.Record
+	User      string
+	Limit     int
+	Lookahead time.Duration
+	Refine    bool // Reorder programmes with the language model
+
+	// Keep filters out channels, such as those hidden from the profile
+	Keep func(channel *models.Record) bool
+}
+
+// ChannelPick is a suggested channel
+type ChannelPick struct {
+	Channel *models.Record `json:"channel"`
+	Score   float64        `json:"score"`
+	Reasons []string       `json:"reasons"`
+}
+
+// ProgramPick is a suggested programme, airing now or soon
+type ProgramPick struct {
+	Program *models.Record `json:"program"`
+	Channel *models.Record `json:"channel"`
+	Score   float64        `json:"score"`
+	OnNow   bool           `json:"on_now"`
+	Reasons []string       `json:"reasons"`
+}
+
+// Result are the suggestions of a profile, best first
+type Result struct {
+	Profile     string         `json:"profile"`
+	GeneratedAt types.DateTime `json:"generated_at"`
+	Refined     bool           `json:"refined"`
+	RefineError string         `json:"refine_error,omitempty"`
+	Channels    []ChannelPick  `json:"channels"`
+	Programs    []ProgramPick  `json:"programs"`
+}
+
+// taste is what a profile watched over HistoryWindow, in seconds
+type taste struct {
+	channels   map[string]float64 // By channel id
+	groups     map[string]float64
+	now        map[string]float64 // By channel id, around the current hour of day
+	hours      [24]float64        // By UTC hour of day
+	categories map[string]float64
+	favorites  map[string]bool
+}
+
+// timeRow is a watch_time entry with its channel
+type timeRow struct {
+	Hour    string  `db:"hour"`
+	Seconds float64 `db:"seconds"`
+	Channel string  `db:"channel_id"`
+	TvgID   string  `db:"tvg_id"`
+	Group   string  `db:"group_title"`
+}
+
+// Service computes suggestions
+type Service struct {
+	app    core.App
+	config Config
+	logger *slog.Logger
+}
+
+// NewService returns a recommendation service
+func NewService(app core.App, config Config) *Service {
+	return &Service{app: app, config: config, logger: logging.For("recommend")}
+}
+
+// Recommend returns the channels and programmes suggested to a profile
+func (s *Service) Recommend(ctx context.Context, query Query) (*Result, error) {
+	if query.Limit < 1 || query.Limit > MaxLimit {
+		query.Limit = DefaultLimit
+	}
+	if query.Lookahead <= 0 || query.Lookahead > MaxLookahead {
+		query.Lookahead = DefaultLookahead
+	}
+
+	now := time.Now()
+	generatedAt, _ := types.ParseDateTime(now)
+	result := &Result{
+		Profile:     query.Profile.Id,
+		GeneratedAt: generatedAt,
+		Channels:    []ChannelPick{},
+		Programs:    []ProgramPick{},
+	}
+
+	t, err := s.taste(query.Profile.Id, now)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates, err := s.candidates(query, t)
+	if err != nil {
+		return nil, err
+	}
+
+	channels := scoreChannels(candidates, t, now)
+	if len(channels) == 0 {
+		return result, nil
+	}
+
+	programs, err := s.programs(query, t, channels, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if query.Refine && len(programs) > 0 {
+		if s.config.Generator == nil {
+			result.RefineError = "no language model configured"
+		} else if refined, err := s.refine(ctx, t, programs); err != nil {
+			s.logger.Warn("failed to refine recommendations", "profile", query.Profile.Id, "error", err)
+			result.RefineError = err.Error()
+		} else {
+			programs = refined
+			result.Refined = true
+		}
+	}
+
+	if len(channels) > query.Limit {
+		channels = channels[:query.Limit]
+	}
+	if len(programs) > query.Limit {
+		programs = programs[:query.Limit]
+	}
+	result.Channels = channels
+	result.Programs = programs
+
+	return result, nil
+}
+
+// taste sums up the watch time of a profile
+func (s *Service) taste(profileID string, now time.Time) (*taste, error) {
+	dao := s.app.Dao()
+
+	t := &taste{
+		channels:   make(map[string]float64),
+		groups:     make(map[string]float64),
+		now:        make(map[string]float64),
+		categories: make(map[string]float64),
+		favorites:  make(map[string]bool),
+	}
+
+	from, _ := types.ParseDateTime(now.Add(-HistoryWindow))
+
+	rows := []timeRow{}
+	err := dao.DB().
+		Select(
+			"wt.hour",
+			"wt.seconds",
+			"c.id AS channel_id",
+			"c.tvg_id",
+			"c.group_title",
+		).
+		From("watch_time wt").
+		LeftJoin("recordings r", dbx.NewExp("r.id = wt.recording")).
+		InnerJoin("channels c", dbx.NewExp("c.id = (CASE WHEN wt.channel != '' THEN wt.channel ELSE r.channel END)")).
+		Where(dbx.HashExp{"wt.profile": profileID}).
+		AndWhere(dbx.NewExp("wt.hour >= {:from}", dbx.Params{"from": from.String()})).
+		All(&rows)
+	if err != nil {
+		return nil, err
+	}
+
+	nowHour := now.UTC().Hour()
+	for _, row := range rows {
+		t.channels[row.Channel] += row.Seconds
+		if row.Group != "" {
+			t.groups[row.Group] += row.Seconds
+		}
+
+		hour, err := types.ParseDateTime(row.Hour)
+		if err != nil {
+			continue
+		}
+		h := hour.Time().UTC().Hour()
+		t.hours[h] += row.Seconds
+		if hourDistance(h, nowHour) <= 1 {
+			t.now[row.Channel] += row.Seconds
+		}
+	}
+
+	if err := s.learnCategories(t, rows, from); err != nil {
+		return nil, err
+	}
+
+	favorites, err := dao.FindRecordsByFilter("favorites", "profile ~ {:profile}", "", 0, 0,
+		dbx.Params{"profile": profileID})
+	if err != nil {
+		return nil, err
+	}
+	for _, favorite := range favorites {
+		for _, id := range favorite.GetStringSlice("channel") {
+			t.favorites[id] = true
+		}
+	}
+
+	return t, nil
+}
+
+// learnCategories adds the time watched during programmes to their
+// category, for the most watched channels
+func (s *Service) learnCategories(t *taste, rows []timeRow, from types.DateTime) error {
+	tvgIDs := make([]interface{}, 0, categoryChannels)
+	for _, id := range topKeys(t.channels, categoryChannels) {
+		for _, row := range rows {
+			if row.Channel == id && row.TvgID != "" {
+				tvgIDs = append(tvgIDs, row.TvgID)
+				break
+			}
+		}
+	}
+	if len(tvgIDs) == 0 {
+		return nil
+	}
+
+	programs := []*models.Record{}
+	err := s.app.Dao().RecordQuery("epg_programs").
+		AndWhere(dbx.In("channel_id", tvgIDs...)).
+		AndWhere(dbx.NewExp("end_time >= {:from}", dbx.Params{"from": from.String()})).
+		AndWhere(dbx.NewExp("category != ''")).
+		All(&programs)
+	if err != nil {
+		return err
+	}
+
+	byTvgID := make(map[string][]*models.Record)
+	for _, program := range programs {
+		tvgID := program.GetString("channel_id")
+		byTvgID[tvgID] = append(byTvgID[tvgID], program)
+	}
+
+	for _, row := range rows {
+		hour, err := types.ParseDateTime(row.Hour)
+		if err != nil {
+			continue
+		}
+		start := hour.Time()
+		end := start.Add(time.Hour)
+
+		// Spread the time of the hour over the programmes aired during it
+		for _, program := range byTvgID[row.TvgID] {
+			overlap := minTime(end, program.GetDateTime("end_time").Time()).
+				Sub(maxTime(start, program.GetDateTime("start_time").Time()))
+			if overlap > 0 {
+				t.categories[normalizeCategory(program.GetString("category"))] += row.Seconds * overlap.Hours()
+			}
+		}
+	}
+
+	return nil
+}
+
+// candidates returns the channels that may be suggested: watched and
+// favorite channels, and channels of the most watched groups
+func (s *Service) candidates(query Query, t *taste) ([]*models.Record, error) {
+	dao := s.app.Dao()
+
+	ids := make([]string, 0, len(t.channels)+len(t.favorites))
+	for id := range t.channels {
+		ids = append(ids, id)
+	}
+	for id := range t.favorites {
+		if _, ok := t.channels[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+
+	channels := []*models.Record{}
+	if len(ids) > 0 {
+		known, err := dao.FindRecordsByIds("channels", ids)
+		if err != nil {
+			return nil, err
+		}
+		channels = append(channels, known...)
+	}
+
+	for _, group := range topKeys(t.groups, topGroups) {
+		records, err := dao.FindRecordsByFilter("channels",
+			"playlist.user ~ {:user} && group_title = {:group}", "name", groupCandidates, 0,
+			dbx.Params{"user": query.User, "group": group})
+		if err != nil {
+			return nil, err
+		}
+		channels = append(channels, records...)
+	}
+
+	seen := make(map[string]bool, len(channels))
+	kept := channels[:0]
+	for _, channel := range channels {
+		if seen[channel.Id] {
+			continue
+		}
+		seen[channel.Id] = true
+		if query.Keep != nil && !query.Keep(channel) {
+			continue
+		}
+		kept = append(kept, channel)
+	}
+
+	return kept, nil
+}
+
+// scoreChannels ranks channels between 0 and 1 by how much the profile
+// watches their group, the channel itself, the channel at this time of day
+// and whether it is a favorite
+func scoreChannels(channels []*models.Record, t *taste, now time.Time) []ChannelPick {
+	maxGroup, maxChannel, maxNow := maxValue(t.groups), maxValue(t.channels), maxValue(t.now)
+
+	picks := make([]ChannelPick, 0, len(channels))
+	for _, channel := range channels {
+		group := channel.GetString("group_title")
+		groupScore := ratio(t.groups[group], maxGroup)
+		channelScore := ratio(t.channels[channel.Id], maxChannel)
+		nowScore := ratio(t.now[channel.Id], maxNow)
+		favorite := t.favorites[channel.Id]
+
+		score := 0.45*groupScore + 0.3*channelScore + 0.15*nowScore
+		reasons := []string{}
+		if favorite {
+			score += 0.1
+			reasons = append(reasons, "In your favorites")
+		}
+		if channelScore >= 0.25 {
+			reasons = append(reasons, "You watch this channel often")
+		} else if channelScore == 0 && groupScore > 0 {
+			reasons = append(reasons, "New to you in "+group)
+		}
+		if groupScore >= 0.5 && channelScore > 0 {
+			reasons = append(reasons, "You watch a lot of "+group)
+		}
+		if nowScore >= 0.25 {
+			reasons = append(reasons, "You usually watch it at this time")
+		}
+		if score == 0 {
+			continue
+		}
+
+		picks = append(picks, ChannelPick{Channel: channel, Score: round(score), Reasons: reasons})
+	}
+
+	sort.SliceStable(picks, func(i, j int) bool {
+		if picks[i].Score != picks[j].Score {
+			return picks[i].Score > picks[j].Score
+		}
+		return picks[i].Channel.GetString("name") < picks[j].Channel.GetString("name")
+	})
+
+	return picks
+}
+
+// programs ranks the programmes airing now or within the lookahead on the
+// suggested channels, by the score of their channel, their category and
+// whether they start at a time the profile usually watches
+func (s *Service) programs(query Query, t *taste, channels []ChannelPick, now time.Time) ([]ProgramPick, error) {
+	dao := s.app.Dao()
+
+	sources, err := dao.FindRecordsByFilter("epg_sources", "user ~ {:user}", "", 0, 0,
+		dbx.Params{"user": query.User})
+	if err != nil || len(sources) == 0 {
+		return []ProgramPick{}, err
+	}
+	sourceIDs := make([]interface{}, len(sources))
+	for i, source := range sources {
+		sourceIDs[i] = source.Id
+	}
+
+	// Best channel of each guide id, channels are sorted by score
+	byTvgID := make(map[string]ChannelPick)
+	tvgIDs := []interface{}{}
+	for _, pick := range channels {
+		tvgID := pick.Channel.GetString("tvg_id")
+		if tvgID == "" {
+			continue
+		}
+		if _, ok := byTvgID[tvgID]; !ok {
+			byTvgID[tvgID] = pick
+			tvgIDs = append(tvgIDs, tvgID)
+		}
+	}
+	if len(tvgIDs) == 0 {
+		return []ProgramPick{}, nil
+	}
+
+	from, _ := types.ParseDateTime(now)
+	until, _ := types.ParseDateTime(now.Add(query.Lookahead))
+
+	records := []*models.Record{}
+	err = dao.RecordQuery("epg_programs").
+		AndWhere(dbx.In("source", sourceIDs...)).
+		AndWhere(dbx.In("channel_id", tvgIDs...)).
+		AndWhere(dbx.NewExp("end_time > {:from} AND start_time < {:until}",
+			dbx.Params{"from": from.String(), "until": until.String()})).
+		OrderBy("start_time").
+		All(&records)
+	if err != nil {
+		return nil, err
+	}
+
+	maxCategory, maxHour := maxValue(t.categories), 0.0
+	for _, seconds := range t.hours {
+		maxHour = math.Max(maxHour, seconds)
+	}
+
+	seen := make(map[string]bool)
+	picks := make([]ProgramPick, 0, len(records))
+	for _, program := range records {
+		// Several guides may list the same programme
+		key := program.GetString("channel_id") + "@" + program.GetDateTime("start_time").String()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		channel := byTvgID[program.GetString("channel_id")]
+		start := program.GetDateTime("start_time").Time()
+		category := program.GetString("category")
+		categoryScore := ratio(t.categories[normalizeCategory(category)], maxCategory)
+		hourScore := ratio(t.hours[start.UTC().Hour()], maxHour)
+		onNow := !start.After(now)
+
+		reasons := append([]string{}, channel.Reasons...)
+		if categoryScore >= 0.25 {
+			reasons = append(reasons, "You like "+category)
+		}
+		if !onNow && hourScore >= 0.5 {
+			reasons = append(reasons, "Starts at a time you usually watch")
+		}
+
+		picks = append(picks, ProgramPick{
+			Program: program,
+			Channel: channel.Channel,
+			Score:   round(0.5*channel.Score + 0.3*categoryScore + 0.2*hourScore),
+			OnNow:   onNow,
+			Reasons: reasons,
+		})
+	}
+
+	sort.SliceStable(picks, func(i, j int) bool {
+		return picks[i].Score > picks[j].Score
+	})
+
+	return picks, nil
+}
+
+// refine asks the language model to pick, among the best programmes, those
+// the profile would enjoy most. Picked programmes come first, in the
+// model's order, followed by the others.
+func (s *Service) refine(ctx context.Context, t *taste, programs []ProgramPick) ([]ProgramPick, error) {
+	candidates := programs
+	if len(candidates) > refineCandidates {
+		candidates = candidates[:refineCandidates]
+	}
+
+	lines := make([]string, len(candidates))
+	for i, pick := range candidates {
+		line := fmt.Sprintf("%d. %s (%s", i+1, pick.Program.GetString("title"), pick.Channel.GetString("name"))
+		if category := pick.Program.GetString("category"); category != "" {
+			line += ", " + category
+		}
+		line += ")"
+		if description := pick.Program.GetString("description"); description != "" {
+			line += ": " + truncate(description, 200)
+		}
+		lines[i] = strings.ReplaceAll(line, "\n", " ")
+	}
+
+	prompt := fmt.Sprintf(
+		`You recommend TV programmes. The viewer mostly watches these channel groups: %s.
+Programme categories they watched most: %s.
+
+Pick up to 10 of the programmes below the viewer would enjoy most, best first, and give a short reason for each.
+Reply with a JSON object:
+{"picks": [{"number": 1, "reason": "one short sentence"}]}
+
+Programmes:
+%s`,
+		listOrNone(topKeys(t.groups, 5)),
+		listOrNone(topKeys(t.categories, 5)),
+		strings.Join(lines, "\n"),
+	)
+
+	response, err := s.config.Generator.Generate(ctx, prompt, "json")
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Picks []struct {
+			Number int    `json:"number"`
+			Reason string `json:"reason"`
+		} `json:"picks"`
+	}
+	if err := json.Unmarshal([]byte(response), &result); err != nil {
+		return nil, fmt.Errorf("failed to parse recommendations: %w", err)
+	}
+
+	picked := make(map[int]bool)
+	refined := make([]ProgramPick, 0, len(programs))
+	for _, p := range result.Picks {
+		i := p.Number - 1
+		if i < 0 || i >= len(candidates) || picked[i] {
+			continue
+		}
+		picked[i] = true
+
+		pick := candidates[i]
+		if reason := strings.TrimSpace(p.Reason); reason != "" {
+			pick.Reasons = append([]string{reason}, pick.Reasons...)
+		}
+		refined = append(refined, pick)
+	}
+	if len(refined) == 0 {
+		return nil, fmt.Errorf("the model picked no programme")
+	}
+
+	for i, pick := range programs {
+		if !picked[i] {
+			refined = append(refined, pick)
+		}
+	}
+
+	return refined, nil
+}
+
+// topKeys returns the n keys with the highest values
+func topKeys(values map[string]float64, n int) []string {
+	keys := make([]string, 0, len(values))
+	for key, value := range values {
+		if value > 0 {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if values[keys[i]] != values[keys[j]] {
+			return values[keys[i]] > values[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
+
+func maxValue(values map[string]float64) float64 {
+	max := 0.0
+	for _, value := range values {
+		max = math.Max(max, value)
+	}
+	return max
+}
+
+func ratio(value, max float64) float64 {
+	if max <= 0 {
+		return 0
+	}
+	return value / max
+}
+
+// hourDistance is the number of hours between two hours of the day
+func hourDistance(a, b int) int {
+	d := a - b
+	if d < 0 {
+		d = -d
+	}
+	if d > 12 {
+		d = 24 - d
+	}
+	return d
+}
+
+// normalizeCategory matches categories spelled differently by guides
+func normalizeCategory(category string) string {
+	return strings.ToLower(strings.TrimSpace(category))
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func listOrNone(values []string) string {
+	if len(values) == 0 {
+		return "none yet"
+	}
+	return strings.Join(values, ", ")
+}
+
+func truncate(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max]) + "…"
+}
+
+// round keeps three decimals of scores
+func round(score float64) float64 {
+	return math.Round(score*1000) / 1000
+}
@@ -0,0 +1,119 @@
+package recorder
+
+import (
+	"errors"
+	"time"
+)
+
+// Recording modes. Video copies the video and re-encodes the audio into
+// MPEG-TS; the audio modes drop the video, for radio channels or programmes
+// listened to like podcasts.
+const (
+	ModeVideo = "video"
+	ModeAAC   = "aac"
+	ModeMP3   = "mp3"
+	ModeOpus  = "opus"
+)
+
+var ErrInvalidMode = errors.New("mode must be video, aac, mp3 or opus")
+
+// audioFormat is the output of an audio mode. The containers are streams of
+// frames, so a file stays playable while it grows and the parts written
+// after ffmpeg restarts join by appending, like MPEG-TS.
+type audioFormat struct {
+	ext  string
+	args []string
+}
+
+var audioFormats = map[string]audioFormat{
+	ModeAAC:  {ext: ".aac", args: []string{"-c:a", "aac", "-b:a", "128k", "-f", "adts", "-write_id3v2", "1"}},
+	ModeMP3:  {ext: ".mp3", args: []string{"-c:a", "libmp3lame", "-b:a", "192k", "-f", "mp3", "-id3v2_version", "3"}},
+	ModeOpus: {ext: ".opus", args: []string{"-c:a", "libopus", "-b:a", "96k", "-f", "ogg"}},
+}
+
+// ValidMode reports whether mode is a recording mode, empty being video
+func ValidMode(mode string) bool {
+	_, audio := audioFormats[mode]
+	return audio || mode == "" || mode == ModeVideo
+}
+
+// IsAudioMode reports whether mode records the audio only
+func IsAudioMode(mode string) bool {
+	_, audio := audioFormats[mode]
+	return audio
+}
+
+// Options are the choices made for a recording when it starts
+type Options struct {
+	Mode string // ModeVideo when empty
+}
+
+// Tags are the metadata written into audio recordings
+type Tags struct {
+	Title       string // The programme, from the guide when it knows it
+	Channel     string
+	Date        time.Time
+	Description string
+	Genre       string
+}
+
+// args returns the ffmpeg options writing the tags. The channel is the
+// artist and album, so players group a channel's recordings together.
+func (t Tags) args() []string {
+	var args []string
+	add := func(key, value string) {
+		if value != "" {
+			args = append(args, "-metadata", key+"="+value)
+		}
+	}
+	add("title", t.Title)
+	add("artist", t.Channel)
+	add("album", t.Channel)
+	if !t.Date.IsZero() {
+		add("date", t.Date.Format("2006-01-02"))
+	}
+	add("comment", t.Description)
+	add("genre", t.Genre)
+	return args
+}
+
+// SetTagger sets a function returning the tags of an audio recording when
+// it starts, such as the programme the guide says the channel airs
+func (rs *RecorderService) SetTagger(fn func(userID, channelID, title string) Tags) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.tagger = fn
+}
+
+// recordingTags returns the tags of an audio recording, its title only
+// without a tagger
+func (rs *RecorderService) recordingTags(userID, channelID, title string) Tags {
+	rs.mu.RLock()
+	fn := rs.tagger
+	rs.mu.RUnlock()
+	if fn == nil {
+		return Tags{Title: title, Date: time.Now()}
+	}
+	return fn(userID, channelID, title)
+}
+
+// outputArgs returns the ffmpeg options mapping and encoding the streams of
+// a recording, up to its output file
+func (recording *Recording) outputArgs() []string {
+	format, audio := audioFormats[recording.Mode]
+	if !audio {
+		// -map 0:v:0 -map 0:a:0: select first video and first audio stream
+		// -c:v copy: copy video without re-encoding
+		// -c:a aac: re-encode audio to standard AAC (fixes SSR/HE-AAC
+		// issues), through loudnorm when the recording is normalized
+		// -f mpegts: output format
+		args := []string{"-map", "0:v:0", "-map", "0:a:0", "-c:v", "copy"}
+		args = append(args, recording.Loudness.args()...)
+		return append(args, "-c:a", "aac", "-b:a", "128k", "-f", "mpegts")
+	}
+
+	args := []string{"-map", "0:a:0", "-vn"}
+	args = append(args, recording.Loudness.args()...)
+	args = append(args, format.args...)
+	return append(args, recording.Tags.args()...)
+}
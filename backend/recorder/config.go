@@ -0,0 +1,186 @@
+package recorder
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ErrRecordingsActive is returned when moving the recordings directory
+// while recordings are in progress
+var ErrRecordingsActive = errors.New("recordings are in progress, stop them before changing the output directory")
+
+// Config holds the recorder options administrators change while the server
+// runs
+type Config struct {
+	// OutputDir is the directory recordings are written to. Changing it
+	// moves the recorded files there.
+	OutputDir string `json:"output_dir"`
+	// RetentionDays is how long finished recordings are kept, deleted by the
+	// prune_recordings maintenance task. 0 keeps them forever.
+	RetentionDays int `json:"retention_days"`
+	// DefaultMode is the recording mode used when none is asked for
+	DefaultMode string `json:"default_mode"`
+}
+
+// dir returns the directory recordings are written to
+func (rs *RecorderService) dir() string {
+	rs.dirMu.RLock()
+	defer rs.dirMu.RUnlock()
+	return rs.outputDir
+}
+
+// OutputDir returns the directory recordings are written to
+func (rs *RecorderService) OutputDir() string {
+	return rs.dir()
+}
+
+// Config returns the runtime configuration in effect
+func (rs *RecorderService) Config() Config {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	return Config{
+		OutputDir:     rs.dir(),
+		RetentionDays: rs.retentionDays,
+		DefaultMode:   rs.defaultMode,
+	}
+}
+
+// SetConfig validates and applies a runtime configuration. An empty output
+// directory or mode keeps the current one. A new output directory is
+// refused while recordings are in progress; the files of the current one
+// are moved to it, with the protected and owners indexes.
+func (rs *RecorderService) SetConfig(config Config) error {
+	if config.RetentionDays < 0 {
+		return fmt.Errorf("retention_days can't be negative")
+	}
+	if !ValidMode(config.DefaultMode) {
+		return fmt.Errorf("default_mode: %w", ErrInvalidMode)
+	}
+	if config.OutputDir != "" {
+		if !filepath.IsAbs(config.OutputDir) {
+			return fmt.Errorf("output_dir must be an absolute path")
+		}
+		config.OutputDir = filepath.Clean(config.OutputDir)
+		if config.OutputDir != rs.dir() {
+			if err := rs.moveOutputDir(config.OutputDir); err != nil {
+				return err
+			}
+		}
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.retentionDays = config.RetentionDays
+	if config.DefaultMode != "" {
+		rs.defaultMode = config.DefaultMode
+	}
+	return nil
+}
+
+// moveOutputDir makes dir the output directory and moves the recorded files
+// to it. No recording can start meanwhile.
+func (rs *RecorderService) moveOutputDir(dir string) error {
+	rs.startMu.Lock()
+	defer rs.startMu.Unlock()
+
+	rs.mu.RLock()
+	active := len(rs.recordings)
+	rs.mu.RUnlock()
+	if active > 0 {
+		return ErrRecordingsActive
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create output_dir: %w", err)
+	}
+	probe, err := os.CreateTemp(dir, ".write-test-*")
+	if err != nil {
+		return fmt.Errorf("output_dir isn't writable: %w", err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+
+	previous := rs.dir()
+	entries, err := os.ReadDir(previous)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	moved := 0
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || name == ProtectedIndexFile || name == OwnersIndexFile || !isStorable(name) {
+			continue
+		}
+		target := filepath.Join(dir, name)
+		if _, err := os.Stat(target); err == nil {
+			rs.logger.Warn("file already in the new output directory, leaving it", "file", name, "dir", previous)
+			continue
+		}
+		if err := moveFile(filepath.Join(previous, name), target); err != nil {
+			rs.logger.Warn("failed to move recording", "file", name, "error", err)
+			continue
+		}
+		moved++
+	}
+
+	rs.dirMu.Lock()
+	rs.outputDir = dir
+	rs.dirMu.Unlock()
+
+	// The indexes follow the files, merged with those of recordings
+	// already in the new directory, such as when it was used before
+	rs.protectMu.Lock()
+	rs.readProtected(dir)
+	if err := rs.saveProtected(); err != nil {
+		rs.logger.Warn("failed to save protected recordings", "error", err)
+	}
+	rs.protectMu.Unlock()
+	rs.ownerMu.Lock()
+	rs.readOwners(dir)
+	if err := rs.saveOwners(); err != nil {
+		rs.logger.Warn("failed to save recording owners", "error", err)
+	}
+	rs.ownerMu.Unlock()
+	os.Remove(filepath.Join(previous, ProtectedIndexFile))
+	os.Remove(filepath.Join(previous, OwnersIndexFile))
+
+	rs.logger.Info("recordings output directory changed", "from", previous, "to", dir, "moved", moved)
+	return nil
+}
+
+// moveFile renames a file, or copies then removes it across filesystems
+func moveFile(from, to string) error {
+	if err := os.Rename(from, to); err == nil {
+		return nil
+	}
+
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	temp := filepath.Join(filepath.Dir(to), "."+filepath.Base(to)+".move.tmp")
+	dst, err := os.Create(temp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(temp)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	if err := os.Rename(temp, to); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	return os.Remove(from)
+}
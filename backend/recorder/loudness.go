@@ -0,0 +1,52 @@
+package recorder
+
+import "fmt"
+
+// DefaultLoudness is the integrated loudness EBU R128 recommends, in LUFS
+const DefaultLoudness = -23.0
+
+// Loudnorm bounds other than the target: the true peak (dBTP) stays under
+// what AAC encoding may overshoot, and the loudness range is narrow enough
+// for ad breaks to come out as loud as the programme
+const (
+	loudnessTruePeak = -2.0
+	loudnessRange    = 7.0
+)
+
+// Loudness normalizes the audio of a recording with ffmpeg's loudnorm
+// filter. Live streams can't be measured ahead, so it runs in a single
+// pass, adjusting the gain as the stream goes.
+type Loudness struct {
+	Target float64 // Integrated loudness in LUFS
+}
+
+// args returns the ffmpeg output options applying the filter. loudnorm
+// upsamples to 192 kHz, so the sample rate is set back to 48 kHz.
+func (l *Loudness) args() []string {
+	if l == nil {
+		return nil
+	}
+	return []string{
+		"-af", fmt.Sprintf("loudnorm=I=%g:TP=%g:LRA=%g", l.Target, loudnessTruePeak, loudnessRange),
+		"-ar", "48000",
+	}
+}
+
+// SetLoudness sets a function returning how to normalize the loudness of a
+// recording when it starts, nil to keep its audio as is
+func (rs *RecorderService) SetLoudness(fn func(recordingID string) *Loudness) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.loudness = fn
+}
+
+// recordingLoudness returns the normalization of a recording, if any
+func (rs *RecorderService) recordingLoudness(recordingID string) *Loudness {
+	rs.mu.RLock()
+	fn := rs.loudness
+	rs.mu.RUnlock()
+	if fn == nil {
+		return nil
+	}
+	return fn(recordingID)
+}
@@ -0,0 +1,281 @@
+package recorder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+const (
+	// probeTimeout bounds ffprobe and poster captures on a recorded file
+	probeTimeout = time.Minute
+
+	// maxPipedPosterOffset bounds how far into files read from the storage
+	// backend posters are taken, as everything before is downloaded
+	maxPipedPosterOffset = 30.0
+)
+
+// Span is a stretch of a recorded file, in seconds from its start
+type Span struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+// Blanks are the silences and black frames of a recorded file, which
+// separate ads from each other and from the programme
+type Blanks struct {
+	Silences []Span
+	Blacks   []Span // Empty for files without video
+}
+
+// MediaInfo is what ffprobe finds in a recorded file
+type MediaInfo struct {
+	Duration   float64 // Seconds, 0 when unknown
+	Width      int
+	Height     int
+	VideoCodec string
+	AudioCodec string
+}
+
+// ProbeFile runs ffprobe on a recorded file. Files moved to the storage
+// backend are streamed to it; MPEG-TS read that way can't be seeked, so
+// their duration may come back unknown.
+func (rs *RecorderService) ProbeFile(ctx context.Context, filename string) (*MediaInfo, error) {
+	if !ValidFilename(filename) || IsSubtitleSidecar(filename) {
+		return nil, ErrInvalidFilename
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	args := []string{"-v", "error", "-print_format", "json", "-show_format", "-show_streams"}
+	path := filepath.Join(rs.dir(), filename)
+	var cmd *exec.Cmd
+	if _, err := os.Stat(path); err == nil {
+		cmd = exec.CommandContext(ctx, "ffprobe", append(args, path)...)
+	} else {
+		src, err := rs.OpenFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		defer src.Close()
+		cmd = exec.CommandContext(ctx, "ffprobe", append(args, "-i", "pipe:0")...)
+		cmd.Stdin = src
+	}
+
+	output, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("probe timed out")
+		}
+		return nil, fmt.Errorf("ffprobe failed: %w", err)
+	}
+
+	var result struct {
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+		Streams []struct {
+			CodecType string `json:"codec_type"`
+			CodecName string `json:"codec_name"`
+			Width     int    `json:"width"`
+			Height    int    `json:"height"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+
+	info := &MediaInfo{}
+	if duration, err := strconv.ParseFloat(result.Format.Duration, 64); err == nil && duration > 0 {
+		info.Duration = duration
+	}
+	for _, stream := range result.Streams {
+		switch stream.CodecType {
+		case "video":
+			if info.VideoCodec == "" {
+				info.VideoCodec = stream.CodecName
+				info.Width = stream.Width
+				info.Height = stream.Height
+			}
+		case "audio":
+			if info.AudioCodec == "" {
+				info.AudioCodec = stream.CodecName
+			}
+		}
+	}
+	return info, nil
+}
+
+// Poster returns a JPEG frame of a recorded file, at seconds into it. Files
+// moved to the storage backend are decoded from their start up to it, so
+// the offset is kept short for them.
+func (rs *RecorderService) Poster(ctx context.Context, filename string, at float64) ([]byte, error) {
+	if !ValidFilename(filename) || IsSubtitleSidecar(filename) {
+		return nil, ErrInvalidFilename
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	// Local files seek to the offset before the input, piped ones decode
+	// up to it after
+	args := []string{"-hide_banner", "-loglevel", "error"}
+	path := filepath.Join(rs.dir(), filename)
+	var stdin io.ReadCloser
+	if _, err := os.Stat(path); err == nil {
+		args = append(args, "-ss", formatSeconds(at), "-i", path)
+	} else {
+		if stdin, err = rs.OpenFile(filename); err != nil {
+			return nil, err
+		}
+		defer stdin.Close()
+		args = append(args, "-i", "pipe:0", "-ss", formatSeconds(min(at, maxPipedPosterOffset)))
+	}
+	args = append(args,
+		"-frames:v", "1",
+		"-vf", "scale='min(1280,iw)':-2",
+		"-f", "image2", "-c:v", "mjpeg", "-q:v", "3",
+		"pipe:1",
+	)
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
+	output, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("poster capture timed out")
+		}
+		return nil, fmt.Errorf("ffmpeg failed: %w", err)
+	}
+	if len(output) == 0 {
+		return nil, fmt.Errorf("no video frame found")
+	}
+	return output, nil
+}
+
+// Output of ffmpeg's silencedetect and blackdetect filters
+var (
+	silenceStart = regexp.MustCompile(`silence_start: (-?[0-9.]+)`)
+	silenceEnd   = regexp.MustCompile(`silence_end: (-?[0-9.]+)`)
+	blackSpan    = regexp.MustCompile(`black_start:(-?[0-9.]+) black_end:(-?[0-9.]+)`)
+)
+
+// Frames sampled by Scan: grayscale, one byte per pixel, row by row
+const (
+	FrameWidth    = 160
+	FrameHeight   = 90
+	FrameInterval = 2.0 // Seconds between frames
+)
+
+// ScanOptions tell Scan what to look for
+type ScanOptions struct {
+	Video bool // Look for black frames too
+
+	// Frame, when set, is given a frame of the video every FrameInterval
+	// seconds, to look for what blanks don't show
+	Frame func(at float64, pixels []byte)
+}
+
+// Scan decodes a whole recorded file to find its silences and, with video,
+// its black frames. Frames are checked at 5 per second in a small size,
+// which is plenty for blanks lasting a few frames and keeps it cheap.
+// Files moved to the storage backend are streamed to ffmpeg.
+func (rs *RecorderService) Scan(ctx context.Context, filename string, options ScanOptions) (*Blanks, error) {
+	if !ValidFilename(filename) || IsSubtitleSidecar(filename) {
+		return nil, ErrInvalidFilename
+	}
+
+	args := []string{"-hide_banner", "-nostats", "-loglevel", "info"}
+	path := filepath.Join(rs.dir(), filename)
+	var stdin io.ReadCloser
+	if _, err := os.Stat(path); err == nil {
+		args = append(args, "-i", path)
+	} else {
+		if stdin, err = rs.OpenFile(filename); err != nil {
+			return nil, err
+		}
+		defer stdin.Close()
+		args = append(args, "-i", "pipe:0")
+	}
+	args = append(args, "-af", "silencedetect=noise=-50dB:d=0.3")
+	if options.Video {
+		args = append(args, "-vf", "fps=5,scale=160:-2,blackdetect=d=0.2:pix_th=0.10")
+	} else {
+		args = append(args, "-vn")
+	}
+	args = append(args, "-f", "null", "-")
+	frames := options.Video && options.Frame != nil
+	if frames {
+		// A second output of the same decode
+		args = append(args,
+			"-map", "0:v:0", "-an",
+			"-vf", fmt.Sprintf("fps=1/%g,scale=%d:%d,format=gray", FrameInterval, FrameWidth, FrameHeight),
+			"-f", "rawvideo", "pipe:1",
+		)
+	}
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	var stdout io.ReadCloser
+	if frames {
+		var err error
+		if stdout, err = cmd.StdoutPipe(); err != nil {
+			return nil, err
+		}
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	if frames {
+		pixels := make([]byte, FrameWidth*FrameHeight)
+		for n := 0; ; n++ {
+			if _, err := io.ReadFull(stdout, pixels); err != nil {
+				break
+			}
+			options.Frame(float64(n)*FrameInterval, pixels)
+		}
+		io.Copy(io.Discard, stdout)
+	}
+	if err := cmd.Wait(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("ffmpeg failed: %w", err)
+	}
+
+	blanks := &Blanks{}
+	start := -1.0
+	for _, line := range bytes.Split(stderr.Bytes(), []byte("\n")) {
+		if match := silenceStart.FindSubmatch(line); match != nil {
+			start, _ = strconv.ParseFloat(string(match[1]), 64)
+			start = max(start, 0)
+		} else if match := silenceEnd.FindSubmatch(line); match != nil && start >= 0 {
+			if end, err := strconv.ParseFloat(string(match[1]), 64); err == nil && end > start {
+				blanks.Silences = append(blanks.Silences, Span{Start: start, End: end})
+			}
+			start = -1
+		} else if match := blackSpan.FindSubmatch(line); match != nil {
+			from, err1 := strconv.ParseFloat(string(match[1]), 64)
+			to, err2 := strconv.ParseFloat(string(match[2]), 64)
+			if err1 == nil && err2 == nil && to > from {
+				blanks.Blacks = append(blanks.Blacks, Span{Start: max(from, 0), End: to})
+			}
+		}
+	}
+	return blanks, nil
+}
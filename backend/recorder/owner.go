@@ -0,0 +1,113 @@
+package recorder
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// OwnersIndexFile maps recorded files to the user who recorded them, so
+// their size counts toward that user's storage quota
+const OwnersIndexFile = ".owners.json"
+
+// loadOwners reads the owners index
+func (rs *RecorderService) loadOwners() {
+	rs.owners = make(map[string]string)
+	rs.readOwners(rs.outputDir)
+}
+
+// readOwners adds the owners of the index of dir to rs.owners, keeping
+// those already known
+func (rs *RecorderService) readOwners(dir string) {
+	data, err := os.ReadFile(filepath.Join(dir, OwnersIndexFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			rs.logger.Warn("failed to read recording owners", "error", err)
+		}
+		return
+	}
+
+	var owners map[string]string
+	if err := json.Unmarshal(data, &owners); err != nil {
+		rs.logger.Warn("failed to parse recording owners", "error", err)
+		return
+	}
+	for name, owner := range owners {
+		if _, ok := rs.owners[name]; !ok {
+			rs.owners[name] = owner
+		}
+	}
+}
+
+// saveOwners writes the index, rs.ownerMu must be held
+func (rs *RecorderService) saveOwners() error {
+	data, err := json.MarshalIndent(rs.owners, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write then rename so a crash never leaves a truncated index
+	path := filepath.Join(rs.dir(), OwnersIndexFile)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// setOwner records who a file belongs to, an empty user forgets the file
+func (rs *RecorderService) setOwner(filename, userID string) {
+	rs.ownerMu.Lock()
+	defer rs.ownerMu.Unlock()
+
+	if rs.owners[filename] == userID {
+		return
+	}
+	if userID == "" {
+		delete(rs.owners, filename)
+	} else {
+		rs.owners[filename] = userID
+	}
+
+	if err := rs.saveOwners(); err != nil {
+		rs.logger.Warn("failed to save recording owners", "error", err)
+	}
+}
+
+// Owner returns the user who recorded a file, empty for files recorded
+// before owners were tracked. Subtitle sidecars belong to the owner of
+// their recording.
+func (rs *RecorderService) Owner(filename string) string {
+	rs.ownerMu.Lock()
+	defer rs.ownerMu.Unlock()
+
+	name := filepath.Base(filename)
+	if owner, ok := rs.owners[name]; ok || !IsSubtitleSidecar(name) {
+		return owner
+	}
+
+	stem := strings.TrimSuffix(name, filepath.Ext(name))
+	for file, owner := range rs.owners {
+		if strings.TrimSuffix(file, filepath.Ext(file)) == stem {
+			return owner
+		}
+	}
+	return ""
+}
+
+// OwnedFiles returns the files recorded by a user, subtitle sidecars
+// excluded
+func (rs *RecorderService) OwnedFiles(userID string) []string {
+	rs.ownerMu.Lock()
+	defer rs.ownerMu.Unlock()
+
+	files := make([]string, 0)
+	for file, owner := range rs.owners {
+		if owner == userID && !IsSubtitleSidecar(file) {
+			files = append(files, file)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
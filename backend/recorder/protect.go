@@ -0,0 +1,171 @@
+package recorder
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ProtectedIndexFile lists the protected recordings. It lives next to the
+// recordings so the flags move with the files.
+const ProtectedIndexFile = ".protected.json"
+
+// ErrProtected is returned when deleting a protected recording
+var ErrProtected = errors.New("recording is protected")
+
+// ErrInvalidFilename is returned for names that aren't plain files of the
+// recordings directory
+var ErrInvalidFilename = errors.New("invalid filename")
+
+// loadProtected reads the protected recordings index
+func (rs *RecorderService) loadProtected() {
+	rs.protected = make(map[string]bool)
+	rs.readProtected(rs.outputDir)
+}
+
+// readProtected adds the recordings protected by the index of dir to
+// rs.protected
+func (rs *RecorderService) readProtected(dir string) {
+	data, err := os.ReadFile(filepath.Join(dir, ProtectedIndexFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			rs.logger.Warn("failed to read protected recordings", "error", err)
+		}
+		return
+	}
+
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		rs.logger.Warn("failed to parse protected recordings", "error", err)
+		return
+	}
+	for _, name := range names {
+		rs.protected[name] = true
+	}
+}
+
+// saveProtected writes the index, rs.protectMu must be held
+func (rs *RecorderService) saveProtected() error {
+	names := make([]string, 0, len(rs.protected))
+	for name := range rs.protected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	data, err := json.MarshalIndent(names, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write then rename so a crash never leaves a truncated index
+	path := filepath.Join(rs.dir(), ProtectedIndexFile)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// ValidFilename reports whether name is a plain file name of the
+// recordings directory, not a path nor a hidden file such as the indexes
+func ValidFilename(name string) bool {
+	return name != "" && !strings.HasPrefix(name, ".") &&
+		!strings.Contains(name, "/") && !strings.Contains(name, "..")
+}
+
+// SetProtected sets or clears the protect flag of a recorded file. Protected
+// recordings are never deleted, neither by users nor by automatic cleanup.
+func (rs *RecorderService) SetProtected(filename string, protected bool) error {
+	if !ValidFilename(filename) {
+		return ErrInvalidFilename
+	}
+
+	rs.protectMu.Lock()
+	defer rs.protectMu.Unlock()
+
+	if protected {
+		if _, err := rs.StatFile(filename); err != nil {
+			return err
+		}
+		rs.protected[filename] = true
+	} else {
+		delete(rs.protected, filename)
+	}
+
+	return rs.saveProtected()
+}
+
+// IsProtected reports whether a recorded file is protected. Paths are
+// matched by their base name.
+func (rs *RecorderService) IsProtected(filename string) bool {
+	rs.protectMu.Lock()
+	defer rs.protectMu.Unlock()
+	return rs.protected[filepath.Base(filename)]
+}
+
+// DeleteFile removes a recorded file and its subtitles unless it is protected
+func (rs *RecorderService) DeleteFile(filename string) error {
+	if !ValidFilename(filename) {
+		return ErrInvalidFilename
+	}
+
+	rs.protectMu.Lock()
+	defer rs.protectMu.Unlock()
+
+	if rs.protected[filename] {
+		return ErrProtected
+	}
+
+	if err := rs.removeFile(filename); err != nil {
+		return err
+	}
+	rs.setOwner(filename, "")
+
+	// Subtitles saved for the recording go with it
+	if !IsSubtitleSidecar(filename) {
+		if err := rs.removeFile(filepath.Base(SubtitleSidecarPath(filename))); err != nil && !os.IsNotExist(err) {
+			rs.logger.Warn("failed to delete recording subtitles", "file", filename, "error", err)
+		}
+	}
+
+	return nil
+}
+
+// PurgeFile removes a recorded file and its subtitles even when it is
+// protected, for files whose user deleted their account
+func (rs *RecorderService) PurgeFile(filename string) error {
+	if !ValidFilename(filename) {
+		return ErrInvalidFilename
+	}
+
+	rs.protectMu.Lock()
+	if rs.protected[filename] {
+		delete(rs.protected, filename)
+		if err := rs.saveProtected(); err != nil {
+			rs.logger.Warn("failed to save protected recordings", "error", err)
+		}
+	}
+	rs.protectMu.Unlock()
+
+	return rs.DeleteFile(filename)
+}
+
+// removeFile deletes a file from the recordings directory and the storage
+// backend. It fails with a not exist error when neither had it.
+func (rs *RecorderService) removeFile(name string) error {
+	err := os.Remove(filepath.Join(rs.dir(), name))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	backend := rs.getStorage()
+	if backend == nil {
+		return err
+	}
+	if _, statErr := backend.Stat(name); os.IsNotExist(statErr) {
+		return err // nil when the local copy was removed
+	}
+	return backend.Delete(name)
+}
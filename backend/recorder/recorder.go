@@ -3,13 +3,16 @@ package recorder
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 	"sync"
 	"time"
+
+	"iptv-backend/logging"
+	"iptv-backend/storage"
 )
 
 type RecordingStatus string
@@ -19,11 +22,34 @@ const (
 	StatusPaused    RecordingStatus = "paused"
 	StatusCompleted RecordingStatus = "completed"
 	StatusFailed    RecordingStatus = "failed"
+
+	// StatusCompletedPartial marks a recording finalized early by a server shutdown
+	StatusCompletedPartial RecordingStatus = "completed_partial"
 )
 
+// maxConsecutiveFailures is how many times ffmpeg may fail in a row before a
+// recording is marked failed
+const maxConsecutiveFailures = 5
+
+// Event is a recording lifecycle event
+type Event string
+
+const (
+	EventStarted  Event = "started"
+	EventFinished Event = "finished"
+	EventFailed   Event = "failed"
+)
+
+// EventHandler receives recording lifecycle events. err is set for EventFailed.
+type EventHandler func(event Event, recording *Recording, err error)
+
 type Recording struct {
 	ID           string
-	ChannelURL   string
+	UserID       string   // User who started the recording
+	ChannelID    string   // Channel record, empty for ad-hoc URLs
+	ChannelURL   string   // Source being recorded, guarded by pauseMu
+	Sources      []string // Sources of a merged channel by priority, ChannelURL first
+	Title        string
 	OutputPath   string
 	Status       RecordingStatus
 	StartedAt    time.Time
@@ -31,31 +57,187 @@ type Recording struct {
 	StoppedAt    *time.Time
 	BytesWritten int64
 	Segments     int
+	Mode         string    // ModeVideo or an audio mode
+	Tags         Tags      // Of audio recordings
+	Loudness     *Loudness // Audio normalization, nil for none
 	ctx          context.Context
 	cancel       context.CancelFunc
 	paused       bool
 	pauseMu      sync.RWMutex
 	cmd          *exec.Cmd
 	cmdMu        sync.Mutex
+	finishing    bool // Set on shutdown, guarded by cmdMu
 }
 
 type RecorderService struct {
 	recordings map[string]*Recording
 	mu         sync.RWMutex
-	outputDir  string
+	logger     *slog.Logger
+	workers    sync.WaitGroup
+	handlers   []EventHandler
+	storage    storage.Backend // Where recordings are moved, nil to keep them local
+
+	// Changed at runtime, see SetConfig. dirMu is never held while taking
+	// another lock, so the directory can be read under any of them.
+	dirMu         sync.RWMutex
+	outputDir     string
+	retentionDays int    // Guarded by mu
+	defaultMode   string // Guarded by mu
+
+	protectMu sync.Mutex
+	protected map[string]bool // File names exempt from deletion
+
+	ownerMu sync.Mutex
+	owners  map[string]string // File name -> user who recorded it
+
+	quotaCheck func(userID string) error
+	inputArgs  func(input string) []string
+	loudness   func(recordingID string) *Loudness
+	tagger     func(userID, channelID, title string) Tags
+
+	// Checks a source can be connected to, such as its provider's connection
+	// limit. startMu serializes checks with the starts they allow.
+	connectionCheck func(sourceURL string) error
+	startMu         sync.Mutex
 }
 
 func NewRecorderService(outputDir string) *RecorderService {
 	// Create output directory if not exists
 	os.MkdirAll(outputDir, 0755)
 
-	return &RecorderService{
-		recordings: make(map[string]*Recording),
-		outputDir:  outputDir,
+	rs := &RecorderService{
+		recordings:  make(map[string]*Recording),
+		outputDir:   outputDir,
+		defaultMode: ModeVideo,
+		logger:      logging.For("recorder"),
+	}
+	rs.loadProtected()
+	rs.loadOwners()
+
+	return rs
+}
+
+// SetQuotaCheck sets a function StartRecording calls with the user starting
+// a recording. Its error, typically a quota being exceeded, refuses the
+// recording.
+func (rs *RecorderService) SetQuotaCheck(check func(userID string) error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.quotaCheck = check
+}
+
+// SetInputArgs sets a function returning the ffmpeg options to read an
+// input with, such as the headers its provider requires
+func (rs *RecorderService) SetInputArgs(fn func(input string) []string) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.inputArgs = fn
+}
+
+// SetConnectionCheck sets a function StartRecording and ResumeRecording call
+// with the source to record. Its error, typically the provider's connection
+// limit being reached, refuses the recording.
+func (rs *RecorderService) SetConnectionCheck(check func(sourceURL string) error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.connectionCheck = check
+}
+
+// checkConnection runs the connection check for a source, if any
+func (rs *RecorderService) checkConnection(sourceURL string) error {
+	rs.mu.RLock()
+	check := rs.connectionCheck
+	rs.mu.RUnlock()
+	if check == nil {
+		return nil
+	}
+	return check(sourceURL)
+}
+
+// ActiveSources returns the sources being recorded now, one per recording
+// that isn't paused
+func (rs *RecorderService) ActiveSources() []string {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	sources := make([]string, 0, len(rs.recordings))
+	for _, recording := range rs.recordings {
+		if !recording.isPaused() {
+			sources = append(sources, recording.sourceURL())
+		}
+	}
+	return sources
+}
+
+// inputOptions returns the ffmpeg options for an input, none by default
+func (rs *RecorderService) inputOptions(input string) []string {
+	rs.mu.RLock()
+	fn := rs.inputArgs
+	rs.mu.RUnlock()
+	if fn == nil {
+		return nil
+	}
+	return fn(input)
+}
+
+// OnEvent registers a handler for recording lifecycle events.
+// Handlers run on their own goroutine.
+func (rs *RecorderService) OnEvent(handler EventHandler) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.handlers = append(rs.handlers, handler)
+}
+
+func (rs *RecorderService) emit(event Event, recording *Recording, err error) {
+	rs.mu.RLock()
+	handlers := rs.handlers
+	rs.mu.RUnlock()
+
+	for _, handler := range handlers {
+		go handler(event, recording, err)
 	}
 }
 
-func (rs *RecorderService) StartRecording(id, channelURL, title string) (*Recording, error) {
+// StartRecording records channelURL with its video. fallbacks are other
+// sources of the channel, switched to in turn when ffmpeg fails on the
+// current one.
+func (rs *RecorderService) StartRecording(id, userID, channelID, channelURL, title string, fallbacks ...string) (*Recording, error) {
+	return rs.StartRecordingWithOptions(id, userID, channelID, channelURL, title, Options{}, fallbacks...)
+}
+
+// StartRecordingWithOptions records channelURL like StartRecording, in the
+// mode of options, the default mode when it has none
+func (rs *RecorderService) StartRecordingWithOptions(id, userID, channelID, channelURL, title string, options Options, fallbacks ...string) (*Recording, error) {
+	if !ValidMode(options.Mode) {
+		return nil, ErrInvalidMode
+	}
+
+	// The check lists files, which takes rs.mu
+	rs.mu.RLock()
+	quotaCheck := rs.quotaCheck
+	if options.Mode == "" {
+		options.Mode = rs.defaultMode
+	}
+	rs.mu.RUnlock()
+	if quotaCheck != nil {
+		if err := quotaCheck(userID); err != nil {
+			return nil, err
+		}
+	}
+
+	// Looked up outside the locks, they may read the database
+	loudness := rs.recordingLoudness(id)
+	var tags Tags
+	if IsAudioMode(options.Mode) {
+		tags = rs.recordingTags(userID, channelID, title)
+	}
+
+	rs.startMu.Lock()
+	defer rs.startMu.Unlock()
+	if err := rs.checkConnection(channelURL); err != nil {
+		return nil, err
+	}
+
 	rs.mu.Lock()
 	defer rs.mu.Unlock()
 
@@ -68,26 +250,47 @@ func (rs *RecorderService) StartRecording(id, channelURL, title string) (*Record
 	timestamp := time.Now().Format("20060102_150405")
 	safeTitle := strings.ReplaceAll(title, "/", "_")
 	safeTitle = strings.ReplaceAll(safeTitle, " ", "_")
-	filename := fmt.Sprintf("%s_%s.ts", safeTitle, timestamp)
-	outputPath := filepath.Join(rs.outputDir, filename)
+	ext := ".ts"
+	if format, audio := audioFormats[options.Mode]; audio {
+		ext = format.ext
+	}
+	filename := fmt.Sprintf("%s_%s%s", safeTitle, timestamp, ext)
+	outputPath := filepath.Join(rs.dir(), filename)
 
 	ctx, cancel := context.WithCancel(context.Background())
 
 	recording := &Recording{
 		ID:         id,
+		UserID:     userID,
+		ChannelID:  channelID,
 		ChannelURL: channelURL,
+		Sources:    append([]string{channelURL}, fallbacks...),
+		Title:      title,
 		OutputPath: outputPath,
 		Status:     StatusRecording,
 		StartedAt:  time.Now(),
+		Mode:       options.Mode,
+		Tags:       tags,
+		Loudness:   loudness,
 		ctx:        ctx,
 		cancel:     cancel,
 	}
 
 	rs.recordings[id] = recording
+	rs.setOwner(filename, userID)
 
 	// Start recording in background using ffmpeg
+	rs.workers.Add(1)
 	go rs.recordWithFFmpeg(recording)
 
+	if rs.storage != nil {
+		go rs.upload(rs.storage, recording)
+	}
+
+	for _, handler := range rs.handlers {
+		go handler(EventStarted, recording, nil)
+	}
+
 	return recording, nil
 }
 
@@ -131,6 +334,15 @@ func (rs *RecorderService) ResumeRecording(id string) error {
 		return fmt.Errorf("recording not found")
 	}
 
+	rs.startMu.Lock()
+	defer rs.startMu.Unlock()
+	if !recording.isPaused() {
+		return fmt.Errorf("recording not paused")
+	}
+	if err := rs.checkConnection(recording.sourceURL()); err != nil {
+		return err
+	}
+
 	recording.pauseMu.Lock()
 	if !recording.paused {
 		recording.pauseMu.Unlock()
@@ -142,6 +354,7 @@ func (rs *RecorderService) ResumeRecording(id string) error {
 	recording.pauseMu.Unlock()
 
 	// Restart ffmpeg process (append mode)
+	rs.workers.Add(1)
 	go rs.recordWithFFmpeg(recording)
 
 	return nil
@@ -177,9 +390,133 @@ func (rs *RecorderService) StopRecording(id string) (*Recording, error) {
 	recording.StoppedAt = &now
 	recording.Status = StatusCompleted
 
+	rs.emit(EventFinished, recording, nil)
+
 	return recording, nil
 }
 
+// Shutdown finalizes all active recordings before the server exits.
+// ffmpeg is sent SIGINT so it flushes and closes its output cleanly; processes
+// still running after timeout are killed. Finalized recordings are marked
+// completed_partial and returned.
+func (rs *RecorderService) Shutdown(timeout time.Duration) []*Recording {
+	rs.mu.Lock()
+	recs := make([]*Recording, 0, len(rs.recordings))
+	for id, rec := range rs.recordings {
+		recs = append(recs, rec)
+		delete(rs.recordings, id)
+	}
+	rs.mu.Unlock()
+
+	if len(recs) == 0 {
+		return recs
+	}
+
+	rs.logger.Info("finalizing recordings", "count", len(recs), "timeout", timeout.String())
+
+	for _, rec := range recs {
+		rec.finish()
+	}
+
+	if !waitTimeout(&rs.workers, timeout) {
+		rs.logger.Warn("ffmpeg did not exit in time, killing remaining processes")
+		for _, rec := range recs {
+			rec.cancel()
+		}
+		waitTimeout(&rs.workers, 5*time.Second)
+	}
+
+	now := time.Now()
+	for _, rec := range recs {
+		rec.cancel()
+
+		if info, err := os.Stat(rec.OutputPath); err == nil {
+			rec.BytesWritten = info.Size()
+		}
+
+		rec.pauseMu.Lock()
+		rec.StoppedAt = &now
+		rec.Status = StatusCompletedPartial
+		rec.pauseMu.Unlock()
+
+		rs.logger.Info("recording finalized",
+			"recording_id", rec.ID,
+			"output", rec.OutputPath,
+			"bytes_written", rec.BytesWritten,
+		)
+
+		rs.emit(EventFinished, rec, nil)
+	}
+
+	return recs
+}
+
+// finish asks the running ffmpeg process to exit gracefully and prevents restarts
+func (r *Recording) finish() {
+	r.cmdMu.Lock()
+	defer r.cmdMu.Unlock()
+
+	r.finishing = true
+	if r.cmd != nil && r.cmd.Process != nil {
+		r.cmd.Process.Signal(os.Interrupt)
+	}
+}
+
+// sourceURL returns the source being recorded
+func (r *Recording) sourceURL() string {
+	r.pauseMu.RLock()
+	defer r.pauseMu.RUnlock()
+	return r.ChannelURL
+}
+
+// nextSource switches to the next source of the channel after a failure and
+// reports whether there was another one
+func (r *Recording) nextSource() bool {
+	if len(r.Sources) < 2 {
+		return false
+	}
+
+	r.pauseMu.Lock()
+	defer r.pauseMu.Unlock()
+	next := 0
+	for i, source := range r.Sources {
+		if source == r.ChannelURL {
+			next = (i + 1) % len(r.Sources)
+			break
+		}
+	}
+	r.ChannelURL = r.Sources[next]
+	return true
+}
+
+func (r *Recording) isPaused() bool {
+	r.pauseMu.RLock()
+	defer r.pauseMu.RUnlock()
+	return r.paused
+}
+
+func (r *Recording) isFinishing() bool {
+	r.cmdMu.Lock()
+	defer r.cmdMu.Unlock()
+	return r.finishing
+}
+
+// waitTimeout waits for wg and reports whether it completed before timeout
+func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
 func (rs *RecorderService) GetRecording(id string) (*Recording, bool) {
 	rs.mu.RLock()
 	defer rs.mu.RUnlock()
@@ -199,16 +536,26 @@ func (rs *RecorderService) GetAllRecordings() []*Recording {
 }
 
 func (rs *RecorderService) recordWithFFmpeg(recording *Recording) {
-	log.Printf("Starting ffmpeg recording for %s: %s -> %s", recording.ID, recording.ChannelURL, recording.OutputPath)
+	defer rs.workers.Done()
+
+	logger := rs.logger.With("recording_id", recording.ID)
+	logger.Info("starting ffmpeg recording", "channel_url", recording.sourceURL(), "sources", len(recording.Sources), "output", recording.OutputPath)
+
+	failures := 0
 
 	for {
 		select {
 		case <-recording.ctx.Done():
-			log.Printf("Recording %s stopped (context cancelled)", recording.ID)
+			logger.Info("recording stopped (context cancelled)")
 			return
 		default:
 		}
 
+		if recording.isFinishing() {
+			logger.Info("recording finalized (shutdown)")
+			return
+		}
+
 		// Check if paused
 		recording.pauseMu.RLock()
 		isPaused := recording.paused
@@ -221,21 +568,12 @@ func (rs *RecorderService) recordWithFFmpeg(recording *Recording) {
 
 		// Build ffmpeg command
 		// -y: overwrite output file
-		// -i: input URL
-		// -map 0:v:0 -map 0:a:0: select first video and first audio stream
-		// -c:v copy: copy video without re-encoding
-		// -c:a aac: re-encode audio to standard AAC (fixes SSR/HE-AAC issues)
-		// -f mpegts: output format
-		args := []string{
-			"-y",
-			"-i", recording.ChannelURL,
-			"-map", "0:v:0",
-			"-map", "0:a:0",
-			"-c:v", "copy",
-			"-c:a", "aac",
-			"-b:a", "128k",
-			"-f", "mpegts",
-		}
+		// -i: input URL, after the options its provider requires
+		// then the streams and format of the recording's mode
+		source := recording.sourceURL()
+		args := append([]string{"-y"}, rs.inputOptions(source)...)
+		args = append(args, "-i", source)
+		args = append(args, recording.outputArgs()...)
 
 		// If file exists, append to it
 		if _, err := os.Stat(recording.OutputPath); err == nil {
@@ -244,14 +582,12 @@ func (rs *RecorderService) recordWithFFmpeg(recording *Recording) {
 			tempPath := recording.OutputPath + ".temp"
 			args = append(args, tempPath)
 
-			cmd := exec.CommandContext(recording.ctx, "ffmpeg", args...)
-			cmd.Stderr = os.Stderr // Log ffmpeg errors
-			recording.cmdMu.Lock()
-			recording.cmd = cmd
-			recording.cmdMu.Unlock()
-
-			log.Printf("Recording %s: starting ffmpeg (append mode) with args: %v", recording.ID, args)
-			err := cmd.Run()
+			logger.Debug("starting ffmpeg (append mode)", "args", args)
+			runStart := time.Now()
+			err := rs.runFFmpeg(recording, args)
+			if time.Since(runStart) > 30*time.Second {
+				failures = 0
+			}
 
 			if err != nil {
 				select {
@@ -260,7 +596,11 @@ func (rs *RecorderService) recordWithFFmpeg(recording *Recording) {
 					os.Remove(tempPath)
 					return
 				default:
-					log.Printf("Recording %s: ffmpeg error: %v", recording.ID, err)
+					if !recording.isFinishing() && !recording.isPaused() {
+						logger.Warn("ffmpeg error", "error", err)
+						failures++
+						rs.switchSource(recording, logger)
+					}
 				}
 			}
 
@@ -273,14 +613,12 @@ func (rs *RecorderService) recordWithFFmpeg(recording *Recording) {
 			// New file
 			args = append(args, recording.OutputPath)
 
-			cmd := exec.CommandContext(recording.ctx, "ffmpeg", args...)
-			cmd.Stderr = os.Stderr // Log ffmpeg errors
-			recording.cmdMu.Lock()
-			recording.cmd = cmd
-			recording.cmdMu.Unlock()
-
-			log.Printf("Recording %s: starting ffmpeg with args: %v", recording.ID, args)
-			err := cmd.Run()
+			logger.Debug("starting ffmpeg", "args", args)
+			runStart := time.Now()
+			err := rs.runFFmpeg(recording, args)
+			if time.Since(runStart) > 30*time.Second {
+				failures = 0
+			}
 
 			if err != nil {
 				select {
@@ -288,24 +626,96 @@ func (rs *RecorderService) recordWithFFmpeg(recording *Recording) {
 					// Context was cancelled, normal exit
 					return
 				default:
-					log.Printf("Recording %s: ffmpeg error: %v", recording.ID, err)
+					if recording.isFinishing() || recording.isPaused() {
+						continue
+					}
+					logger.Warn("ffmpeg error", "error", err)
+					failures++
+					if failures >= maxConsecutiveFailures {
+						rs.failRecording(recording, err)
+						return
+					}
+					rs.switchSource(recording, logger)
 					time.Sleep(2 * time.Second)
 					continue
 				}
 			}
 		}
 
+		if failures >= maxConsecutiveFailures {
+			rs.failRecording(recording, fmt.Errorf("ffmpeg failed %d times in a row", failures))
+			return
+		}
+
 		// Update file size
 		if info, err := os.Stat(recording.OutputPath); err == nil {
 			recording.BytesWritten = info.Size()
 		}
 
+		if recording.isFinishing() {
+			logger.Info("recording finalized (shutdown)")
+			return
+		}
+
 		// If we get here without error, ffmpeg exited normally (stream ended?)
 		// Wait a bit and retry
 		time.Sleep(2 * time.Second)
 	}
 }
 
+// switchSource moves a failing recording to the next source of its channel
+func (rs *RecorderService) switchSource(recording *Recording, logger *slog.Logger) {
+	if recording.nextSource() {
+		logger.Info("switching recording source", "channel_url", recording.sourceURL())
+	}
+}
+
+// failRecording gives up on a recording whose ffmpeg keeps failing
+func (rs *RecorderService) failRecording(recording *Recording, err error) {
+	rs.logger.Error("recording failed", "recording_id", recording.ID, "error", err)
+
+	rs.mu.Lock()
+	if current, ok := rs.recordings[recording.ID]; ok && current == recording {
+		delete(rs.recordings, recording.ID)
+	}
+	rs.mu.Unlock()
+
+	recording.cancel()
+
+	if info, statErr := os.Stat(recording.OutputPath); statErr == nil {
+		recording.BytesWritten = info.Size()
+	}
+
+	now := time.Now()
+	recording.pauseMu.Lock()
+	recording.StoppedAt = &now
+	recording.Status = StatusFailed
+	recording.pauseMu.Unlock()
+
+	rs.emit(EventFailed, recording, err)
+}
+
+// runFFmpeg starts ffmpeg for a recording and waits for it to exit.
+// The process is not started once the recording is finishing.
+func (rs *RecorderService) runFFmpeg(recording *Recording, args []string) error {
+	recording.cmdMu.Lock()
+	if recording.finishing {
+		recording.cmdMu.Unlock()
+		return fmt.Errorf("recording is finishing")
+	}
+
+	cmd := exec.CommandContext(recording.ctx, "ffmpeg", args...)
+	cmd.Stderr = os.Stderr // Log ffmpeg errors
+	if err := cmd.Start(); err != nil {
+		recording.cmdMu.Unlock()
+		return err
+	}
+	recording.cmd = cmd
+	recording.cmdMu.Unlock()
+
+	return cmd.Wait()
+}
+
 func (rs *RecorderService) appendFile(dst, src string) error {
 	srcFile, err := os.Open(src)
 	if err != nil {
@@ -336,7 +746,9 @@ func (rs *RecorderService) appendFile(dst, src string) error {
 // RecordingInfo returns a safe struct for JSON serialization
 type RecordingInfo struct {
 	ID           string          `json:"id"`
+	ChannelID    string          `json:"channel_id,omitempty"`
 	ChannelURL   string          `json:"channel_url"`
+	Title        string          `json:"title,omitempty"`
 	OutputPath   string          `json:"output_path"`
 	Status       RecordingStatus `json:"status"`
 	StartedAt    time.Time       `json:"started_at"`
@@ -345,6 +757,8 @@ type RecordingInfo struct {
 	BytesWritten int64           `json:"bytes_written"`
 	Segments     int             `json:"segments"`
 	Duration     int64           `json:"duration_seconds"`
+	Mode         string          `json:"mode"`
+	Loudness     *float64        `json:"loudness_target,omitempty"` // LUFS, when normalized
 }
 
 func (r *Recording) Info() RecordingInfo {
@@ -358,9 +772,11 @@ func (r *Recording) Info() RecordingInfo {
 		r.BytesWritten = info.Size()
 	}
 
-	return RecordingInfo{
+	info := RecordingInfo{
 		ID:           r.ID,
-		ChannelURL:   r.ChannelURL,
+		ChannelID:    r.ChannelID,
+		ChannelURL:   r.sourceURL(),
+		Title:        r.Title,
 		OutputPath:   r.OutputPath,
 		Status:       r.Status,
 		StartedAt:    r.StartedAt,
@@ -369,5 +785,10 @@ func (r *Recording) Info() RecordingInfo {
 		BytesWritten: r.BytesWritten,
 		Segments:     r.Segments,
 		Duration:     int64(duration),
+		Mode:         r.Mode,
+	}
+	if r.Loudness != nil {
+		info.Loudness = &r.Loudness.Target
 	}
+	return info
 }
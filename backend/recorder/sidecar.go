@@ -0,0 +1,51 @@
+package recorder
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+)
+
+// SubtitleSidecarExt is the extension of subtitles saved next to a
+// recording. MPEG-TS can't carry text subtitles, so they aren't muxed in.
+const SubtitleSidecarExt = ".srt"
+
+// SubtitleSidecarPath returns where the subtitles of a recorded file go
+func SubtitleSidecarPath(outputPath string) string {
+	return strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + SubtitleSidecarExt
+}
+
+// IsSubtitleSidecar reports whether a file of the recordings directory
+// holds the subtitles of a recording
+func IsSubtitleSidecar(name string) bool {
+	return strings.EqualFold(filepath.Ext(name), SubtitleSidecarExt)
+}
+
+// SaveSubtitles writes subtitles next to a recorded file, moving them to the
+// storage backend when one is set, and returns the sidecar file name
+func (rs *RecorderService) SaveSubtitles(outputPath string, content []byte) (string, error) {
+	path := SubtitleSidecarPath(outputPath)
+
+	// Write then rename so players never pick up a half written file
+	if err := os.WriteFile(path+".tmp", content, 0644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return "", err
+	}
+
+	name := filepath.Base(path)
+	if backend := rs.getStorage(); backend != nil {
+		err := backend.Put(name, &filesystem.PathReader{Path: path})
+		if err == nil {
+			err = rs.releaseLocal(backend, name)
+		}
+		if err != nil {
+			rs.logger.Warn("failed to move recording subtitles to storage, keeping the local file", "file", name, "error", err)
+		}
+	}
+
+	return name, nil
+}
@@ -0,0 +1,290 @@
+package recorder
+
+import (
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+
+	"iptv-backend/storage"
+)
+
+// errStoredMismatch is returned by releaseLocal when the backend's copy of a
+// file differs from the local one
+var errStoredMismatch = errors.New("stored copy doesn't match the local file")
+
+// SetStorage moves recordings and their subtitles to backend. Recordings
+// are uploaded while ffmpeg writes them, and the local copy is removed once
+// the upload is complete. Files are still read from the recordings
+// directory first, so a failed upload loses nothing.
+func (rs *RecorderService) SetStorage(backend storage.Backend) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.storage = backend
+}
+
+func (rs *RecorderService) getStorage() storage.Backend {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	return rs.storage
+}
+
+// upload streams a recording to the storage backend as it is written, until
+// the recording stops
+func (rs *RecorderService) upload(backend storage.Backend, recording *Recording) {
+	logger := rs.logger.With("recording_id", recording.ID, "storage", backend.Name())
+	name := filepath.Base(recording.OutputPath)
+
+	if err := backend.Put(name, storage.Follow(recording.OutputPath, recording.ctx.Done())); err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warn("failed to upload recording, keeping the local file", "file", name, "error", err)
+		}
+		return
+	}
+
+	err := rs.releaseLocal(backend, name)
+	if errors.Is(err, errStoredMismatch) {
+		// ffmpeg went back to rewrite part of the file after the upload read
+		// it, such as the header of an mp3: upload the finished file
+		logger.Debug("recording changed while uploading, uploading it again", "file", name)
+		if err = backend.Put(name, &filesystem.PathReader{Path: recording.OutputPath}); err == nil {
+			err = rs.releaseLocal(backend, name)
+		}
+	}
+	if err != nil {
+		logger.Warn("failed to move recording to storage, keeping the local file", "file", name, "error", err)
+		return
+	}
+	logger.Info("recording uploaded", "file", name)
+}
+
+// releaseLocal removes the local copy of a file once the backend holds the
+// same content. The stored copy is read back and compared by checksum, as
+// equal sizes don't tell whether a muxer rewrote a header after the upload
+// read it.
+func (rs *RecorderService) releaseLocal(backend storage.Backend, name string) error {
+	path := filepath.Join(rs.dir(), name)
+
+	local, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	stored, err := backend.Stat(name)
+	if err != nil {
+		return err
+	}
+	if stored.Size != local.Size() {
+		return errStoredMismatch
+	}
+
+	localSum, err := checksumFile(path)
+	if err != nil {
+		return err
+	}
+	r, err := backend.Open(name)
+	if err != nil {
+		return err
+	}
+	storedSum, err := checksum(r)
+	r.Close()
+	if err != nil {
+		return err
+	}
+	if storedSum != localSum {
+		return errStoredMismatch
+	}
+
+	return os.Remove(path)
+}
+
+// checksumFile returns the SHA-256 of a file
+func checksumFile(path string) ([sha256.Size]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	defer f.Close()
+	return checksum(f)
+}
+
+// checksum returns the SHA-256 of what r yields
+func checksum(r io.Reader) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
+
+// SyncStorage uploads the files left in the recordings directory, such as
+// recordings made before a storage backend was configured or whose upload
+// was interrupted by a restart
+func (rs *RecorderService) SyncStorage() {
+	backend := rs.getStorage()
+	if backend == nil {
+		return
+	}
+
+	active := make(map[string]bool)
+	for _, rec := range rs.GetAllRecordings() {
+		active[filepath.Base(rec.OutputPath)] = true
+	}
+
+	entries, err := os.ReadDir(rs.dir())
+	if err != nil {
+		return
+	}
+
+	uploaded := 0
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || active[name] || !isStorable(name) {
+			continue
+		}
+
+		path := filepath.Join(rs.dir(), name)
+		if err := backend.Put(name, &filesystem.PathReader{Path: path}); err != nil {
+			rs.logger.Warn("failed to upload recording", "file", name, "storage", backend.Name(), "error", err)
+			continue
+		}
+		if err := rs.releaseLocal(backend, name); err != nil {
+			rs.logger.Warn("failed to move recording to storage, keeping the local file", "file", name, "storage", backend.Name(), "error", err)
+			continue
+		}
+		uploaded++
+	}
+
+	if uploaded > 0 {
+		rs.logger.Info("moved recordings to storage", "count", uploaded, "storage", backend.Name())
+	}
+}
+
+// isStorable reports whether a file of the recordings directory is a
+// recording or subtitles, rather than the index or a temporary file
+func isStorable(name string) bool {
+	return !strings.HasPrefix(name, ".") && !strings.HasSuffix(name, ".temp") && !strings.HasSuffix(name, ".tmp")
+}
+
+// LocalPath returns the path of a recorded file still in the recordings
+// directory, false when it was moved to the storage backend or doesn't exist
+func (rs *RecorderService) LocalPath(name string) (string, bool) {
+	if !ValidFilename(name) {
+		return "", false
+	}
+
+	path := filepath.Join(rs.dir(), name)
+	if _, err := os.Stat(path); err != nil {
+		return "", false
+	}
+	return path, true
+}
+
+// StatFile returns the size and modification time of a recorded file,
+// local or stored
+func (rs *RecorderService) StatFile(name string) (storage.Object, error) {
+	if !ValidFilename(name) {
+		return storage.Object{}, ErrInvalidFilename
+	}
+
+	info, err := os.Stat(filepath.Join(rs.dir(), name))
+	if err == nil {
+		return storage.Object{Key: name, Size: info.Size(), ModTime: info.ModTime()}, nil
+	}
+	backend := rs.getStorage()
+	if backend == nil || !os.IsNotExist(err) {
+		return storage.Object{}, err
+	}
+	return backend.Stat(name)
+}
+
+// OpenFile returns the content of a recorded file, local or stored
+func (rs *RecorderService) OpenFile(name string) (io.ReadCloser, error) {
+	if !ValidFilename(name) {
+		return nil, ErrInvalidFilename
+	}
+
+	f, err := os.Open(filepath.Join(rs.dir(), name))
+	if err == nil {
+		return f, nil
+	}
+	backend := rs.getStorage()
+	if backend == nil || !os.IsNotExist(err) {
+		return nil, err
+	}
+	return backend.Open(name)
+}
+
+// ServeFile writes a recorded file, local or stored, honoring range
+// requests so players can seek
+func (rs *RecorderService) ServeFile(res http.ResponseWriter, req *http.Request, name string) error {
+	if !ValidFilename(name) {
+		return ErrInvalidFilename
+	}
+
+	f, err := os.Open(filepath.Join(rs.dir(), name))
+	if err == nil {
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		http.ServeContent(res, req, name, info.ModTime(), f)
+		return nil
+	}
+
+	backend := rs.getStorage()
+	if backend == nil || !os.IsNotExist(err) {
+		return err
+	}
+	return backend.Serve(res, req, name, name)
+}
+
+// ListFiles returns the recordings and subtitles of the recordings
+// directory and of the storage backend, sorted by name
+func (rs *RecorderService) ListFiles() ([]storage.Object, error) {
+	files := make(map[string]storage.Object)
+
+	entries, err := os.ReadDir(rs.dir())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !isStorable(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files[entry.Name()] = storage.Object{Key: entry.Name(), Size: info.Size(), ModTime: info.ModTime()}
+	}
+
+	if backend := rs.getStorage(); backend != nil {
+		stored, err := backend.List("")
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range stored {
+			// The local copy is the most recent while a recording uploads
+			if _, exists := files[obj.Key]; !exists && ValidFilename(obj.Key) && isStorable(obj.Key) {
+				files[obj.Key] = obj
+			}
+		}
+	}
+
+	list := make([]storage.Object, 0, len(files))
+	for _, obj := range files {
+		list = append(list, obj)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+
+	return list, nil
+}
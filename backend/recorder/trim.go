@@ -0,0 +1,225 @@
+package recorder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+
+	"iptv-backend/storage"
+)
+
+// MaxKeepRanges bounds the parts of a recording kept by a single trim
+const MaxKeepRanges = 50
+
+var (
+	ErrInvalidRanges = errors.New("ranges must start at 0 or later, end after they start and not overlap")
+	ErrNotTrimmable  = errors.New("only MPEG-TS recordings can be trimmed")
+	ErrEmptyTrim     = errors.New("nothing was left after trimming, check the ranges against the recording's duration")
+)
+
+// KeepRange is a part of a recording kept when trimming, in seconds from
+// its start. An End of 0 keeps the rest of the recording.
+type KeepRange struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+// ValidateRanges returns the ranges sorted by start, or ErrInvalidRanges.
+// Only the last range may run to the end of the recording.
+func ValidateRanges(ranges []KeepRange) ([]KeepRange, error) {
+	if len(ranges) == 0 || len(ranges) > MaxKeepRanges {
+		return nil, fmt.Errorf("between 1 and %d ranges can be kept", MaxKeepRanges)
+	}
+
+	sorted := append([]KeepRange(nil), ranges...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+
+	for i, r := range sorted {
+		last := i == len(sorted)-1
+		if r.Start < 0 || r.End < 0 || (r.End == 0 && !last) || (r.End != 0 && r.End <= r.Start) {
+			return nil, ErrInvalidRanges
+		}
+		if !last && sorted[i+1].Start < r.End {
+			return nil, ErrInvalidRanges
+		}
+	}
+	return sorted, nil
+}
+
+// TrimFile writes the ranges of a recorded file to a new recording owned by
+// userID, copying the streams without re-encoding, and returns it. Cuts
+// snap to the keyframe before each start. The new file counts toward the
+// user's quota and goes to the storage backend like recordings do.
+func (rs *RecorderService) TrimFile(ctx context.Context, filename, userID string, ranges []KeepRange) (storage.Object, error) {
+	if !ValidFilename(filename) || IsSubtitleSidecar(filename) {
+		return storage.Object{}, ErrInvalidFilename
+	}
+	if !strings.EqualFold(filepath.Ext(filename), ".ts") {
+		return storage.Object{}, ErrNotTrimmable
+	}
+	ranges, err := ValidateRanges(ranges)
+	if err != nil {
+		return storage.Object{}, err
+	}
+
+	rs.mu.RLock()
+	quotaCheck := rs.quotaCheck
+	rs.mu.RUnlock()
+	if quotaCheck != nil {
+		if err := quotaCheck(userID); err != nil {
+			return storage.Object{}, err
+		}
+	}
+
+	input, cleanup, err := rs.localCopy(filename)
+	if err != nil {
+		return storage.Object{}, err
+	}
+	defer cleanup()
+
+	name, err := rs.trimmedName(filename)
+	if err != nil {
+		return storage.Object{}, err
+	}
+	output := filepath.Join(rs.dir(), name)
+
+	// Parts are MPEG-TS, so they join by appending. isStorable skips the
+	// temporary names until the file is complete.
+	temp := output + ".temp"
+	if err := os.WriteFile(temp, nil, 0644); err != nil {
+		return storage.Object{}, err
+	}
+	defer os.Remove(temp)
+
+	part := filepath.Join(rs.dir(), "."+name+".part.tmp")
+	defer os.Remove(part)
+
+	var offset float64
+	for _, r := range ranges {
+		if err := rs.cutPart(ctx, input, part, r, offset); err != nil {
+			return storage.Object{}, err
+		}
+		if err := rs.appendFile(temp, part); err != nil {
+			return storage.Object{}, err
+		}
+		offset += r.End - r.Start
+	}
+
+	info, err := os.Stat(temp)
+	if err != nil {
+		return storage.Object{}, err
+	}
+	if info.Size() == 0 {
+		return storage.Object{}, ErrEmptyTrim
+	}
+	if err := os.Rename(temp, output); err != nil {
+		return storage.Object{}, err
+	}
+	rs.setOwner(name, userID)
+
+	if backend := rs.getStorage(); backend != nil {
+		err := backend.Put(name, &filesystem.PathReader{Path: output})
+		if err == nil {
+			err = rs.releaseLocal(backend, name)
+		}
+		if err != nil {
+			rs.logger.Warn("failed to move trimmed recording to storage, keeping the local file", "file", name, "error", err)
+		}
+	}
+
+	rs.logger.Info("recording trimmed", "file", filename, "trimmed", name, "ranges", len(ranges), "size", info.Size())
+	return storage.Object{Key: name, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// cutPart copies one range of input to part. offset shifts its timestamps
+// to follow the parts before it, so players see one continuous stream.
+func (rs *RecorderService) cutPart(ctx context.Context, input, part string, r KeepRange, offset float64) error {
+	args := []string{"-hide_banner", "-loglevel", "error", "-y",
+		"-ss", formatSeconds(r.Start)}
+	if r.End > 0 {
+		args = append(args, "-t", formatSeconds(r.End-r.Start))
+	}
+	args = append(args,
+		"-i", input,
+		"-map", "0",
+		"-c", "copy",
+		"-avoid_negative_ts", "make_zero",
+		"-output_ts_offset", formatSeconds(offset),
+		"-f", "mpegts",
+		part,
+	)
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		detail := strings.TrimSpace(string(output))
+		if i := strings.LastIndexByte(detail, '\n'); i >= 0 {
+			detail = detail[i+1:]
+		}
+		return fmt.Errorf("ffmpeg failed: %v: %s", err, detail)
+	}
+	return nil
+}
+
+// localCopy returns the local path of a recorded file, downloading it from
+// the storage backend to a temporary file when it was moved there
+func (rs *RecorderService) localCopy(filename string) (string, func(), error) {
+	path := filepath.Join(rs.dir(), filename)
+	if _, err := os.Stat(path); err == nil {
+		return path, func() {}, nil
+	}
+
+	src, err := rs.OpenFile(filename)
+	if err != nil {
+		return "", nil, err
+	}
+	defer src.Close()
+
+	temp := filepath.Join(rs.dir(), "."+filename+".download.tmp")
+	dst, err := os.Create(temp)
+	if err != nil {
+		return "", nil, err
+	}
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(temp)
+		return "", nil, err
+	}
+	return temp, func() { os.Remove(temp) }, nil
+}
+
+// trimmedName returns a free name for the trimmed copy of a file
+func (rs *RecorderService) trimmedName(filename string) (string, error) {
+	ext := filepath.Ext(filename)
+	stem := strings.TrimSuffix(filename, ext) + "_trimmed"
+	for i := 1; i <= 100; i++ {
+		name := stem + ext
+		if i > 1 {
+			name = stem + "_" + strconv.Itoa(i) + ext
+		}
+		if _, err := rs.StatFile(name); os.IsNotExist(err) {
+			return name, nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("too many trimmed copies of %s", filename)
+}
+
+func formatSeconds(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', 3, 64)
+}
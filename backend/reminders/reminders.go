@@ -0,0 +1,316 @@
+// Package reminders notifies users a few minutes before a programme of the
+// guide starts, through their notification channels and a realtime update
+// of the reminder record, and turns reminders into scheduled recordings.
+package reminders
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/types"
+
+	"iptv-backend/logging"
+	"iptv-backend/notifications"
+)
+
+// Collection holds the reminders
+const Collection = "reminders"
+
+// Reminder statuses
+const (
+	StatusPending = "pending"
+	StatusSent    = "sent"
+	StatusMissed  = "missed" // The server was down when it was due
+)
+
+const (
+	// DefaultMinutesBefore and MaxMinutesBefore bound how early reminders
+	// are sent
+	DefaultMinutesBefore = 10
+	MaxMinutesBefore     = 24 * 60
+
+	// CheckInterval is how often due reminders are sent
+	CheckInterval = 30 * time.Second
+
+	// MissedAfter is how long after a programme started its reminder is
+	// still sent, later ones are marked missed
+	MissedAfter = 5 * time.Minute
+)
+
+var (
+	ErrNotFound        = errors.New("reminder not found")
+	ErrProgramNotFound = errors.New("programme not found")
+	ErrStarted         = errors.New("the programme has already started")
+	ErrEnded           = errors.New("the programme has already ended")
+	ErrMinutes         = fmt.Errorf("minutes_before must be between 0 and %d", MaxMinutesBefore)
+	ErrNoProfile       = errors.New("a profile is required to record")
+	ErrNoChannel       = errors.New("none of your channels airs this programme")
+)
+
+// Service creates and sends reminders
+type Service struct {
+	app      core.App
+	notifier *notifications.Service
+	logger   *slog.Logger
+
+	mu   sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewService returns a reminder service sending through notifier
+func NewService(app core.App, notifier *notifications.Service) *Service {
+	return &Service{
+		app:      app,
+		notifier: notifier,
+		logger:   logging.For("reminders"),
+	}
+}
+
+// Start sends due reminders every CheckInterval
+func (s *Service) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stop != nil {
+		return
+	}
+
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go s.loop(s.stop, s.done)
+}
+
+// Stop stops sending reminders
+func (s *Service) Stop() {
+	s.mu.Lock()
+	stop, done := s.stop, s.done
+	s.stop, s.done = nil, nil
+	s.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+func (s *Service) loop(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		s.sendDue()
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Create adds a reminder minutesBefore a programme of the user's guide, or
+// updates it if the user already has one for the programme. profileID is
+// the profile it is for, optional.
+func (s *Service) Create(userID, programID, profileID string, minutesBefore int) (*models.Record, error) {
+	if minutesBefore < 0 || minutesBefore > MaxMinutesBefore {
+		return nil, ErrMinutes
+	}
+
+	dao := s.app.Dao()
+
+	program, err := s.program(userID, programID)
+	if err != nil {
+		return nil, err
+	}
+
+	start := program.GetDateTime("start_time").Time()
+	if !start.After(time.Now()) {
+		return nil, ErrStarted
+	}
+
+	record, err := dao.FindFirstRecordByFilter(Collection, "user = {:user} && program = {:program}",
+		dbx.Params{"user": userID, "program": program.Id})
+	if err != nil {
+		collection, err := dao.FindCollectionByNameOrId(Collection)
+		if err != nil {
+			return nil, err
+		}
+		record = models.NewRecord(collection)
+		record.Set("user", userID)
+		record.Set("program", program.Id)
+	}
+
+	// The channel of the user's playlists airing the programme
+	channelID := ""
+	if tvgID := program.GetString("channel_id"); tvgID != "" {
+		if channel, err := dao.FindFirstRecordByFilter("channels",
+			"playlist.user ~ {:user} && tvg_id = {:tvg}",
+			dbx.Params{"user": userID, "tvg": tvgID}); err == nil {
+			channelID = channel.Id
+		}
+	}
+
+	// Due right away when it is already later than the asked lead
+	remindAt := start.Add(-time.Duration(minutesBefore) * time.Minute)
+	if remindAt.Before(time.Now()) {
+		remindAt = time.Now()
+	}
+
+	record.Set("profile", profileID)
+	record.Set("channel", channelID)
+	record.Set("title", program.GetString("title"))
+	record.Set("start_time", program.GetDateTime("start_time"))
+	record.Set("minutes_before", minutesBefore)
+	record.Set("remind_at", mustDateTime(remindAt))
+	record.Set("status", StatusPending)
+	record.Set("sent_at", "")
+
+	if err := dao.SaveRecord(record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// Record turns a reminder into a scheduled recording of its programme for
+// a profile, the reminder's own when profileID is empty. The reminder is
+// removed.
+func (s *Service) Record(userID, reminderID, profileID string) (*models.Record, error) {
+	dao := s.app.Dao()
+
+	reminder, err := dao.FindRecordById(Collection, reminderID)
+	if err != nil || reminder.GetString("user") != userID {
+		return nil, ErrNotFound
+	}
+
+	if profileID == "" {
+		profileID = reminder.GetString("profile")
+	}
+	if profileID == "" {
+		return nil, ErrNoProfile
+	}
+	if reminder.GetString("channel") == "" {
+		return nil, ErrNoChannel
+	}
+
+	program, err := s.program(userID, reminder.GetString("program"))
+	if err != nil {
+		return nil, err
+	}
+	if !program.GetDateTime("end_time").Time().After(time.Now()) {
+		return nil, ErrEnded
+	}
+
+	collection, err := dao.FindCollectionByNameOrId("recordings")
+	if err != nil {
+		return nil, err
+	}
+
+	recording := models.NewRecord(collection)
+	recording.Set("profile", profileID)
+	recording.Set("channel", reminder.GetString("channel"))
+	recording.Set("program_title", program.GetString("title"))
+	recording.Set("scheduled_start", program.GetDateTime("start_time"))
+	recording.Set("scheduled_end", program.GetDateTime("end_time"))
+	recording.Set("status", "scheduled")
+
+	err = dao.RunInTransaction(func(txDao *daos.Dao) error {
+		if err := txDao.SaveRecord(recording); err != nil {
+			return err
+		}
+		return txDao.DeleteRecord(reminder)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return recording, nil
+}
+
+// program finds a programme of the user's guide sources
+func (s *Service) program(userID, programID string) (*models.Record, error) {
+	dao := s.app.Dao()
+
+	program, err := dao.FindRecordById("epg_programs", programID)
+	if err != nil {
+		return nil, ErrProgramNotFound
+	}
+
+	source, err := dao.FindRecordById("epg_sources", program.GetString("source"))
+	if err != nil || !slices.Contains(source.GetStringSlice("user"), userID) {
+		return nil, ErrProgramNotFound
+	}
+
+	return program, nil
+}
+
+// sendDue notifies the users of due reminders. Updating the record pushes
+// it to clients subscribed to the collection.
+func (s *Service) sendDue() {
+	dao := s.app.Dao()
+	now := time.Now()
+
+	records, err := dao.FindRecordsByFilter(Collection,
+		"status = {:status} && remind_at <= {:now}", "remind_at", 100, 0,
+		dbx.Params{"status": StatusPending, "now": mustDateTime(now).String()})
+	if err != nil {
+		s.logger.Warn("failed to load due reminders", "error", err)
+		return
+	}
+
+	for _, record := range records {
+		start := record.GetDateTime("start_time").Time()
+
+		if start.Before(now.Add(-MissedAfter)) {
+			record.Set("status", StatusMissed)
+		} else {
+			s.notify(record, start)
+			record.Set("status", StatusSent)
+			record.Set("sent_at", mustDateTime(now))
+		}
+
+		if err := dao.SaveRecord(record); err != nil {
+			s.logger.Warn("failed to update reminder", "reminder_id", record.Id, "error", err)
+		}
+	}
+}
+
+func (s *Service) notify(record *models.Record, start time.Time) {
+	title := record.GetString("title")
+	message := fmt.Sprintf("%s starts at %s", title, start.Local().Format("15:04"))
+
+	channelName := ""
+	if channel, err := s.app.Dao().FindRecordById("channels", record.GetString("channel")); err == nil {
+		channelName = channel.GetString("name")
+		message += " on " + channelName
+	}
+
+	s.notifier.Notify(notifications.Event{
+		Type:    notifications.EventProgramReminder,
+		User:    record.GetString("user"),
+		Title:   "Programme reminder",
+		Message: message + ".",
+		Data: map[string]interface{}{
+			"reminder_id": record.Id,
+			"program_id":  record.GetString("program"),
+			"channel_id":  record.GetString("channel"),
+			"channel":     channelName,
+			"title":       title,
+			"start_time":  start,
+		},
+	})
+}
+
+func mustDateTime(t time.Time) types.DateTime {
+	dt, _ := types.ParseDateTime(t)
+	return dt
+}
@@ -0,0 +1,159 @@
+package schedules
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pocketbase/pocketbase/models"
+)
+
+// Schedule frequencies
+const (
+	FrequencyDaily  = "daily"
+	FrequencyWeekly = "weekly" // On the days of the schedule
+)
+
+// dateLayout is the layout of start_date, end_date and skip_dates
+const dateLayout = "2006-01-02"
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+var (
+	ErrFrequency = errors.New("frequency must be daily or weekly")
+	ErrDays      = errors.New("weekly schedules need at least one day")
+	ErrStartTime = errors.New("the start_time must be HH:MM")
+	ErrDuration  = errors.New("duration must be between 1 and 1440 minutes")
+	ErrTimezone  = errors.New("unknown timezone")
+	ErrDate      = errors.New("dates must be YYYY-MM-DD")
+	ErrDateRange = errors.New("the end_date is before the start_date")
+	ErrSkipDates = errors.New("the skip_dates must be a list of YYYY-MM-DD dates")
+)
+
+// Rule is the recurrence of a schedule
+type Rule struct {
+	Frequency string
+	Days      map[time.Weekday]bool // Weekly schedules
+	Hour      int
+	Minute    int
+	Duration  time.Duration
+	Location  *time.Location
+	StartDate string // YYYY-MM-DD, empty for no bound
+	EndDate   string
+	Skip      map[string]bool // YYYY-MM-DD
+}
+
+// RuleFromRecord reads and validates the recurrence of a schedule record
+func RuleFromRecord(record *models.Record) (*Rule, error) {
+	rule := &Rule{
+		Frequency: record.GetString("frequency"),
+		Days:      make(map[time.Weekday]bool),
+		Duration:  time.Duration(record.GetInt("duration")) * time.Minute,
+		StartDate: record.GetString("start_date"),
+		EndDate:   record.GetString("end_date"),
+		Skip:      make(map[string]bool),
+	}
+
+	switch rule.Frequency {
+	case FrequencyDaily:
+	case FrequencyWeekly:
+		for _, day := range record.GetStringSlice("days") {
+			weekday, ok := weekdays[day]
+			if !ok {
+				return nil, fmt.Errorf("unknown day %q", day)
+			}
+			rule.Days[weekday] = true
+		}
+		if len(rule.Days) == 0 {
+			return nil, ErrDays
+		}
+	default:
+		return nil, ErrFrequency
+	}
+
+	start, err := time.Parse("15:04", record.GetString("start_time"))
+	if err != nil {
+		return nil, ErrStartTime
+	}
+	rule.Hour, rule.Minute = start.Hour(), start.Minute()
+
+	if rule.Duration < time.Minute || rule.Duration > 24*time.Hour {
+		return nil, ErrDuration
+	}
+
+	rule.Location = time.UTC
+	if name := record.GetString("timezone"); name != "" {
+		if rule.Location, err = time.LoadLocation(name); err != nil {
+			return nil, fmt.Errorf("%w %q", ErrTimezone, name)
+		}
+	}
+
+	for _, date := range []string{rule.StartDate, rule.EndDate} {
+		if _, err := time.Parse(dateLayout, date); date != "" && err != nil {
+			return nil, ErrDate
+		}
+	}
+	if rule.StartDate != "" && rule.EndDate != "" && rule.EndDate < rule.StartDate {
+		return nil, ErrDateRange
+	}
+
+	if raw := record.GetString("skip_dates"); raw != "" && raw != "null" {
+		var dates []string
+		if err := json.Unmarshal([]byte(raw), &dates); err != nil {
+			return nil, ErrSkipDates
+		}
+		for _, date := range dates {
+			if _, err := time.Parse(dateLayout, date); err != nil {
+				return nil, ErrSkipDates
+			}
+			rule.Skip[date] = true
+		}
+	}
+
+	return rule, nil
+}
+
+// Occurrences returns the starts of the occurrences starting from from
+// (inclusive) to until (exclusive) that haven't ended by now, in order.
+// Days are those of the rule's time zone, so occurrences keep their local
+// time across daylight saving changes.
+func (r *Rule) Occurrences(from, now, until time.Time) []time.Time {
+	// An occurrence started before now may still be running
+	first := now.Add(-r.Duration)
+	if from.After(first) {
+		first = from
+	}
+
+	var starts []time.Time
+	day := first.In(r.Location)
+	day = time.Date(day.Year(), day.Month(), day.Day()-1, 0, 0, 0, 0, r.Location)
+	for !day.After(until) {
+		date := day.Format(dateLayout)
+		start := time.Date(day.Year(), day.Month(), day.Day(), r.Hour, r.Minute, 0, 0, r.Location)
+		day = time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, r.Location)
+
+		if r.EndDate != "" && date > r.EndDate {
+			break
+		}
+		if r.StartDate != "" && date < r.StartDate || r.Skip[date] {
+			continue
+		}
+		if r.Frequency == FrequencyWeekly && !r.Days[start.Weekday()] {
+			continue
+		}
+		if start.Before(from) || !start.Before(until) || !start.Add(r.Duration).After(now) {
+			continue
+		}
+		starts = append(starts, start)
+	}
+	return starts
+}
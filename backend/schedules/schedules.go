@@ -0,0 +1,422 @@
+// Package schedules records programmes: it expands recurring schedules
+// (every weekday at 20:00 on a channel for an hour) into scheduled
+// recordings, then starts each scheduled recording on time and stops it at
+// its end.
+package schedules
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/types"
+
+	"iptv-backend/connections"
+	"iptv-backend/logging"
+	"iptv-backend/recorder"
+)
+
+// Collection holds the recurring schedules
+const Collection = "schedules"
+
+// Recording statuses in the recordings collection
+const (
+	StatusScheduled        = "scheduled"
+	StatusRecording        = "recording"
+	StatusCompleted        = "completed"
+	StatusCompletedPartial = "completed_partial"
+	StatusFailed           = "failed"
+)
+
+const (
+	// Horizon is how far ahead schedules are expanded into recordings
+	Horizon = 7 * 24 * time.Hour
+
+	// expandEvery is how often the horizon of a schedule moves forward
+	expandEvery = time.Hour
+
+	// CheckInterval is how often due recordings are started and stopped
+	CheckInterval = 10 * time.Second
+)
+
+var ErrProfile = errors.New("profile not found")
+
+// Config gives the scheduler what it records with
+type Config struct {
+	Recorder *recorder.RecorderService
+
+	// Sources returns the source URLs of a channel by priority, the first
+	// one preferred
+	Sources func(channel *models.Record) []string
+}
+
+// Service expands schedules and runs scheduled recordings
+type Service struct {
+	app    core.App
+	config Config
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	stop    chan struct{}
+	done    chan struct{}
+	started map[string]bool // Recordings started since the server started
+}
+
+// NewService returns a scheduler recording with config
+func NewService(app core.App, config Config) *Service {
+	return &Service{
+		app:     app,
+		config:  config,
+		logger:  logging.For("schedules"),
+		started: make(map[string]bool),
+	}
+}
+
+// Start expands schedules and starts and stops recordings every
+// CheckInterval
+func (s *Service) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stop != nil {
+		return
+	}
+
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go s.loop(s.stop, s.done)
+}
+
+// Stop stops the scheduler. Running recordings are finalized by the
+// recorder's shutdown.
+func (s *Service) Stop() {
+	s.mu.Lock()
+	stop, done := s.stop, s.done
+	s.stop, s.done = nil, nil
+	s.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+func (s *Service) loop(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		now := time.Now()
+		s.expandDue(now)
+		s.stopDue(now)
+		s.startDue(now)
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func dateTime(t time.Time) string {
+	dt, _ := types.ParseDateTime(t)
+	return dt.String()
+}
+
+// Validate checks a schedule before it is saved: its recurrence, and that
+// its profile belongs to its user
+func (s *Service) Validate(schedule *models.Record) error {
+	if _, err := RuleFromRecord(schedule); err != nil {
+		return err
+	}
+
+	profile, err := s.app.Dao().FindRecordById("profiles", schedule.GetString("profile"))
+	if err != nil || !slices.Contains(profile.GetStringSlice("user"), schedule.GetString("user")) {
+		return ErrProfile
+	}
+	return nil
+}
+
+// expandDue creates the recordings of active schedules whose horizon is due
+// to move forward
+func (s *Service) expandDue(now time.Time) {
+	schedules, err := s.app.Dao().FindRecordsByFilter(Collection,
+		"is_active = true && (expanded_until = '' || expanded_until < {:due})", "", 100, 0,
+		dbx.Params{"due": dateTime(now.Add(Horizon - expandEvery))})
+	if err != nil {
+		s.logger.Warn("failed to load schedules", "error", err)
+		return
+	}
+
+	for _, schedule := range schedules {
+		if err := s.expand(schedule, now); err != nil {
+			s.logger.Warn("failed to expand schedule", "schedule_id", schedule.Id, "error", err)
+		}
+	}
+}
+
+// expand creates the recordings of a schedule up to the horizon
+func (s *Service) expand(schedule *models.Record, now time.Time) error {
+	dao := s.app.Dao()
+
+	until := now.Add(Horizon)
+
+	rule, err := RuleFromRecord(schedule)
+	if err != nil {
+		// Saved by an admin, bypassing validation: retried at the next
+		// horizon move
+		schedule.Set("expanded_until", until)
+		return errors.Join(err, dao.SaveRecord(schedule))
+	}
+
+	collection, err := dao.FindCollectionByNameOrId("recordings")
+	if err != nil {
+		return err
+	}
+
+	from := schedule.GetDateTime("expanded_until").Time()
+	for _, start := range rule.Occurrences(from, now, until) {
+		scheduledStart := dateTime(start)
+
+		// Occurrences running while the schedule was edited are kept
+		existing, _ := dao.FindFirstRecordByFilter("recordings", "schedule = {:schedule} && scheduled_start = {:start}",
+			dbx.Params{"schedule": schedule.Id, "start": scheduledStart})
+		if existing != nil {
+			continue
+		}
+
+		recording := models.NewRecord(collection)
+		recording.Set("profile", schedule.GetString("profile"))
+		recording.Set("channel", schedule.GetString("channel"))
+		recording.Set("schedule", schedule.Id)
+		recording.Set("program_title", schedule.GetString("title"))
+		recording.Set("mode", schedule.GetString("mode"))
+		recording.Set("scheduled_start", scheduledStart)
+		recording.Set("scheduled_end", dateTime(start.Add(rule.Duration)))
+		recording.Set("status", StatusScheduled)
+		if err := dao.SaveRecord(recording); err != nil {
+			return err
+		}
+	}
+
+	schedule.Set("expanded_until", until)
+	return dao.SaveRecord(schedule)
+}
+
+// Refresh recreates the upcoming recordings of a schedule after it was
+// created or changed. Recordings already running are left alone.
+func (s *Service) Refresh(schedule *models.Record) error {
+	if err := s.DeletePending(schedule.Id); err != nil {
+		return err
+	}
+
+	schedule.Set("expanded_until", "")
+	if !schedule.GetBool("is_active") {
+		return s.app.Dao().SaveRecord(schedule)
+	}
+	return s.expand(schedule, time.Now())
+}
+
+// DeletePending deletes the recordings of a schedule that haven't started
+func (s *Service) DeletePending(scheduleID string) error {
+	dao := s.app.Dao()
+
+	pending, err := dao.FindRecordsByFilter("recordings", "schedule = {:schedule} && status = {:status}", "", 0, 0,
+		dbx.Params{"schedule": scheduleID, "status": StatusScheduled})
+	if err != nil {
+		return err
+	}
+	for _, recording := range pending {
+		if err := dao.DeleteRecord(recording); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startDue starts the scheduled recordings whose start has come, and
+// restarts those a crash interrupted
+func (s *Service) startDue(now time.Time) {
+	dao := s.app.Dao()
+
+	// Missed entirely, the server was down
+	missed, err := dao.FindRecordsByFilter("recordings", "status = {:status} && scheduled_end <= {:now}", "", 100, 0,
+		dbx.Params{"status": StatusScheduled, "now": dateTime(now)})
+	if err != nil {
+		s.logger.Warn("failed to load missed recordings", "error", err)
+	}
+	for _, recording := range missed {
+		reason := recording.GetString("error")
+		if reason == "" {
+			reason = "the server wasn't running during the recording"
+		}
+		s.setStatus(recording, StatusFailed, reason)
+	}
+
+	due, err := dao.FindRecordsByFilter("recordings",
+		"(status = {:scheduled} || status = {:recording}) && scheduled_start <= {:now} && scheduled_end > {:now}",
+		"scheduled_start", 100, 0,
+		dbx.Params{"scheduled": StatusScheduled, "recording": StatusRecording, "now": dateTime(now)})
+	if err != nil {
+		s.logger.Warn("failed to load due recordings", "error", err)
+		return
+	}
+
+	for _, recording := range due {
+		if recording.GetString("status") == StatusRecording {
+			// Running, or stopped by a crash before the server restarted
+			if _, running := s.config.Recorder.GetRecording(recording.Id); running || s.wasStarted(recording.Id) {
+				continue
+			}
+			s.logger.Info("restarting interrupted recording", "recording_id", recording.Id)
+		}
+		s.start(recording)
+	}
+}
+
+func (s *Service) wasStarted(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.started[id]
+}
+
+// start starts a scheduled recording. Recordings waiting for a connection
+// to their provider stay scheduled, with the reason in their error.
+func (s *Service) start(recording *models.Record) {
+	dao := s.app.Dao()
+
+	channel, err := dao.FindRecordById("channels", recording.GetString("channel"))
+	if err != nil {
+		s.setStatus(recording, StatusFailed, "channel not found")
+		return
+	}
+	profile, err := dao.FindRecordById("profiles", recording.GetString("profile"))
+	if err != nil || len(profile.GetStringSlice("user")) == 0 {
+		s.setStatus(recording, StatusFailed, "profile not found")
+		return
+	}
+	userID := profile.GetStringSlice("user")[0]
+
+	sources := s.config.Sources(channel)
+	if len(sources) == 0 || sources[0] == "" {
+		s.setStatus(recording, StatusFailed, "channel has no stream URL")
+		return
+	}
+
+	rec, err := s.config.Recorder.StartRecordingWithOptions(recording.Id, userID, channel.Id, sources[0],
+		recording.GetString("program_title"), recorder.Options{Mode: recording.GetString("mode")}, sources[1:]...)
+	if err != nil {
+		if errors.Is(err, connections.ErrLimitReached) {
+			reason := "waiting for a free connection: " + err.Error()
+			if recording.GetString("error") != reason {
+				s.logger.Info("scheduled recording waiting for a connection", "recording_id", recording.Id, "reason", err)
+				recording.Set("error", reason)
+				if err := dao.SaveRecord(recording); err != nil {
+					s.logger.Warn("failed to save recording", "recording_id", recording.Id, "error", err)
+				}
+			}
+			return
+		}
+		s.setStatus(recording, StatusFailed, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	s.started[recording.Id] = true
+	s.mu.Unlock()
+
+	s.logger.Info("scheduled recording started", "recording_id", recording.Id, "title", rec.Title)
+	recording.Set("status", StatusRecording)
+	recording.Set("error", "")
+	recording.Set("actual_start", rec.StartedAt)
+	recording.Set("file_path", filepath.Base(rec.OutputPath))
+	if err := dao.SaveRecord(recording); err != nil {
+		s.logger.Warn("failed to save recording", "recording_id", recording.Id, "error", err)
+	}
+}
+
+// stopDue stops the recordings whose end has come. Recordings started by
+// hand have no end and are stopped by their user.
+func (s *Service) stopDue(now time.Time) {
+	due, err := s.app.Dao().FindRecordsByFilter("recordings", "status = {:status} && scheduled_end != '' && scheduled_end <= {:now}", "", 100, 0,
+		dbx.Params{"status": StatusRecording, "now": dateTime(now)})
+	if err != nil {
+		s.logger.Warn("failed to load ending recordings", "error", err)
+		return
+	}
+
+	for _, recording := range due {
+		if _, running := s.config.Recorder.GetRecording(recording.Id); running {
+			// The finished event completes the record
+			if _, err := s.config.Recorder.StopRecording(recording.Id); err != nil {
+				s.logger.Warn("failed to stop scheduled recording", "recording_id", recording.Id, "error", err)
+			}
+			continue
+		}
+
+		// Stopped or failed by the recorder, its event updates the record
+		if s.wasStarted(recording.Id) {
+			continue
+		}
+
+		// Interrupted by a crash and never restarted
+		s.setStatus(recording, StatusCompletedPartial, "")
+	}
+}
+
+// HandleRecorderEvent keeps the record of a scheduled recording in step
+// with the recorder, which finishes or fails it. Other recordings have no
+// record and are ignored.
+func (s *Service) HandleRecorderEvent(event recorder.Event, rec *recorder.Recording, err error) {
+	if event == recorder.EventStarted {
+		return
+	}
+
+	recording, findErr := s.app.Dao().FindRecordById("recordings", rec.ID)
+	if findErr != nil {
+		return
+	}
+
+	s.mu.Lock()
+	delete(s.started, rec.ID)
+	s.mu.Unlock()
+
+	info := rec.Info()
+	if info.StoppedAt != nil {
+		recording.Set("actual_end", *info.StoppedAt)
+	}
+	recording.Set("file_size", info.BytesWritten)
+
+	switch event {
+	case recorder.EventFailed:
+		s.setStatus(recording, StatusFailed, fmt.Sprint(err))
+	case recorder.EventFinished:
+		status := StatusCompleted
+		if info.Status == recorder.StatusCompletedPartial {
+			status = StatusCompletedPartial
+		}
+		s.setStatus(recording, status, "")
+	}
+}
+
+// setStatus saves the status of a recording, with the reason it failed
+func (s *Service) setStatus(recording *models.Record, status, reason string) {
+	if status == StatusFailed {
+		s.logger.Warn("scheduled recording failed", "recording_id", recording.Id, "reason", reason)
+	}
+
+	recording.Set("status", status)
+	recording.Set("error", reason)
+	if err := s.app.Dao().SaveRecord(recording); err != nil {
+		s.logger.Warn("failed to save recording", "recording_id", recording.Id, "error", err)
+	}
+}
@@ -1,7 +1,7 @@
 #!/usr/bin/env python3
 """
 Fast audio transcription using faster-whisper.
-Usage: python3 transcribe.py <audio_file> [language]
+Usage: python3 transcribe.py <audio_file> [language] [model] [model_dir]
 Output: JSON with transcription result
 """
 
@@ -9,14 +9,23 @@ import sys
 import json
 import os
 
-def transcribe(audio_path: str, language: str = "en") -> dict:
-    """Transcribe audio file using faster-whisper."""
+def transcribe(audio_path: str, language: str = "en", model_size: str = "", model_dir: str = "") -> dict:
+    """Transcribe audio file using faster-whisper.
+
+    An empty language (or "auto") lets Whisper detect it from the audio.
+    model_dir is a model downloaded by the server, model_size is used when
+    it is empty.
+    """
+    if language == "auto":
+        language = ""
+
     try:
         from faster_whisper import WhisperModel
 
         # Use base model for better accuracy (tiny misses too much speech)
         # Options: tiny, base, small, medium, large
-        model_size = os.environ.get("WHISPER_MODEL", "base")
+        if not model_size:
+            model_size = os.environ.get("WHISPER_MODEL", "base")
 
         # Use CPU by default, GPU if available
         device = "cpu"
@@ -31,20 +40,32 @@ def transcribe(audio_path: str, language: str = "en") -> dict:
         except:
             pass
 
-        model = WhisperModel(model_size, device=device, compute_type=compute_type)
+        model_path = model_dir if model_dir and os.path.isdir(model_dir) else model_size
+        model = WhisperModel(model_path, device=device, compute_type=compute_type)
 
         segments, info = model.transcribe(
             audio_path,
             language=language if language else None,
             beam_size=5,
-            word_timestamps=False,
+            word_timestamps=True,
             vad_filter=True,  # Filter out silence
         )
 
-        # Collect all segments
+        # Collect all segments, and their words for karaoke display and
+        # splitting long subtitles
         text_parts = []
+        words = []
+        scores = []
         for segment in segments:
             text_parts.append(segment.text.strip())
+            # Scored by the server, which drops what is likely made up
+            scores.append({
+                "text": segment.text.strip(),
+                "avg_logprob": round(segment.avg_logprob, 4),
+                "no_speech_prob": round(segment.no_speech_prob, 4),
+            })
+            for word in segment.words or []:
+                words.append({"word": word.word.strip(), "start": round(word.start, 3), "end": round(word.end, 3), "probability": round(word.probability, 3)})
 
         full_text = " ".join(text_parts)
 
@@ -52,7 +73,10 @@ def transcribe(audio_path: str, language: str = "en") -> dict:
             "success": True,
             "text": full_text,
             "language": info.language if info.language else language,
+            "language_probability": info.language_probability,
             "duration": info.duration,
+            "words": words,
+            "segments": scores,
         }
 
     except ImportError:
@@ -60,13 +84,26 @@ def transcribe(audio_path: str, language: str = "en") -> dict:
         try:
             import whisper
 
-            model = whisper.load_model("base")
-            result = model.transcribe(audio_path, language=language)
+            model = whisper.load_model(model_size or os.environ.get("WHISPER_MODEL", "base"))
+            result = model.transcribe(audio_path, language=language if language else None, word_timestamps=True)
+
+            words = []
+            scores = []
+            for segment in result.get("segments", []):
+                scores.append({
+                    "text": segment.get("text", "").strip(),
+                    "avg_logprob": round(segment.get("avg_logprob", 0), 4),
+                    "no_speech_prob": round(segment.get("no_speech_prob", 0), 4),
+                })
+                for word in segment.get("words", []):
+                    words.append({"word": word["word"].strip(), "start": round(word["start"], 3), "end": round(word["end"], 3), "probability": round(word.get("probability", 0), 3)})
 
             return {
                 "success": True,
                 "text": result["text"].strip(),
                 "language": result.get("language", language),
+                "words": words,
+                "segments": scores,
             }
         except Exception as e:
             return {
@@ -90,10 +127,12 @@ if __name__ == "__main__":
 
     audio_file = sys.argv[1]
     language = sys.argv[2] if len(sys.argv) > 2 else "en"
+    model_size = sys.argv[3] if len(sys.argv) > 3 else ""
+    model_dir = sys.argv[4] if len(sys.argv) > 4 else ""
 
     if not os.path.exists(audio_file):
         print(json.dumps({"success": False, "error": f"File not found: {audio_file}"}))
         sys.exit(1)
 
-    result = transcribe(audio_file, language)
+    result = transcribe(audio_file, language, model_size, model_dir)
     print(json.dumps(result))
@@ -0,0 +1,537 @@
+// Package search finds channels by name, tvg name and group, and guide
+// programmes by title and description. Matching uses SQLite FTS5 indexes
+// kept up to date by triggers; SQLite builds without FTS5 (the cgo driver
+// of development builds) fall back to LIKE matching.
+package search
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/types"
+
+	"iptv-backend/logging"
+)
+
+// What to search
+const (
+	TypeAll      = "all"
+	TypeChannels = "channels"
+	TypePrograms = "programs"
+)
+
+const (
+	DefaultPerPage = 20
+	MaxPerPage     = 100
+
+	// MaxTerms bounds the words of a query
+	MaxTerms = 10
+)
+
+var (
+	ErrQuery = errors.New("the query has no words to search for")
+	ErrType  = fmt.Errorf("type must be %s, %s or %s", TypeAll, TypeChannels, TypePrograms)
+)
+
+var termPattern = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// index is an FTS5 index of text columns of a collection. weights rank
+// matches in each column for bm25.
+type index struct {
+	name    string
+	source  string
+	columns []string
+	weights []float64
+}
+
+var (
+	channelsIndex = index{
+		name:    "channels_fts",
+		source:  "channels",
+		columns: []string{"name", "tvg_name", "group_title"},
+		weights: []float64{10, 5, 2},
+	}
+	programsIndex = index{
+		name:    "epg_programs_fts",
+		source:  "epg_programs",
+		columns: []string{"title", "description"},
+		weights: []float64{10, 1},
+	}
+	indexes = []index{channelsIndex, programsIndex}
+)
+
+// Query is a search of the channels and guide of a user
+type Query struct {
+	User    string
+	Text    string
+	Type    string
+	Page    int
+	PerPage int
+
+	// Hidden hides channels, and their programmes, from the results
+	Hidden func(channel *models.Record) bool
+}
+
+// Page is a page of results, best match first, shaped like PocketBase lists
+type Page[T any] struct {
+	Page       int `json:"page"`
+	PerPage    int `json:"perPage"`
+	TotalItems int `json:"totalItems"`
+	TotalPages int `json:"totalPages"`
+	Items      []T `json:"items"`
+}
+
+// ProgramHit is a matching programme and the channel airing it, nil when
+// none of the user's channels has its guide id
+type ProgramHit struct {
+	Program *models.Record `json:"program"`
+	Channel *models.Record `json:"channel"`
+}
+
+// Result holds the pages of the searched types
+type Result struct {
+	Query    string                `json:"query"`
+	FullText bool                  `json:"full_text"`
+	Channels *Page[*models.Record] `json:"channels,omitempty"`
+	Programs *Page[ProgramHit]     `json:"programs,omitempty"`
+}
+
+// Service searches channels and programmes
+type Service struct {
+	app    core.App
+	logger *slog.Logger
+	fts    bool
+}
+
+// NewService returns a search service. EnsureIndex must run once the
+// collections exist.
+func NewService(app core.App) *Service {
+	return &Service{
+		app:    app,
+		logger: logging.For("search"),
+	}
+}
+
+// EnsureIndex creates the full-text indexes and their triggers, rebuilding
+// an index whose triggers were missing. Without FTS5 the triggers are
+// dropped, as writes to the collections would fail on them, and searches
+// use LIKE matching.
+func (s *Service) EnsureIndex() error {
+	db := s.app.Dao().DB()
+
+	if _, err := db.NewQuery("CREATE VIRTUAL TABLE temp.fts5_probe USING fts5(x)").Execute(); err != nil {
+		s.logger.Warn("SQLite has no FTS5, search uses LIKE matching", "error", err)
+		for _, idx := range indexes {
+			for _, trigger := range idx.triggerNames() {
+				if _, err := db.NewQuery("DROP TRIGGER IF EXISTS " + trigger).Execute(); err != nil {
+					return err
+				}
+			}
+		}
+		s.fts = false
+		return nil
+	}
+	db.NewQuery("DROP TABLE temp.fts5_probe").Execute()
+
+	for _, idx := range indexes {
+		if err := s.ensure(idx); err != nil {
+			return fmt.Errorf("%s: %w", idx.name, err)
+		}
+	}
+	s.fts = true
+	return nil
+}
+
+func (s *Service) ensure(idx index) error {
+	db := s.app.Dao().DB()
+
+	exists := func(kind, name string) bool {
+		var count int
+		db.NewQuery("SELECT count(*) FROM sqlite_master WHERE type = {:type} AND name = {:name}").
+			Bind(dbx.Params{"type": kind, "name": name}).
+			Row(&count)
+		return count > 0
+	}
+
+	if !exists("table", idx.source) {
+		return nil
+	}
+
+	columns := strings.Join(idx.columns, ", ")
+	rebuild := false
+
+	if !exists("table", idx.name) {
+		_, err := db.NewQuery(fmt.Sprintf(
+			"CREATE VIRTUAL TABLE %s USING fts5(%s, content='%s', content_rowid='rowid', tokenize='unicode61 remove_diacritics 2')",
+			idx.name, columns, idx.source)).Execute()
+		if err != nil {
+			return err
+		}
+		rebuild = true
+	}
+
+	prefixed := func(prefix string) string {
+		values := make([]string, len(idx.columns))
+		for i, column := range idx.columns {
+			values[i] = prefix + column
+		}
+		return strings.Join(values, ", ")
+	}
+	insert := fmt.Sprintf("INSERT INTO %s(rowid, %s) VALUES (new.rowid, %s);", idx.name, columns, prefixed("new."))
+	remove := fmt.Sprintf("INSERT INTO %s(%s, rowid, %s) VALUES ('delete', old.rowid, %s);", idx.name, idx.name, columns, prefixed("old."))
+
+	names := idx.triggerNames()
+	bodies := []string{
+		fmt.Sprintf("AFTER INSERT ON %s BEGIN %s END", idx.source, insert),
+		fmt.Sprintf("AFTER DELETE ON %s BEGIN %s END", idx.source, remove),
+		fmt.Sprintf("AFTER UPDATE OF %s ON %s BEGIN %s %s END", columns, idx.source, remove, insert),
+	}
+	for i, name := range names {
+		if exists("trigger", name) {
+			continue
+		}
+		if _, err := db.NewQuery(fmt.Sprintf("CREATE TRIGGER %s %s", name, bodies[i])).Execute(); err != nil {
+			return err
+		}
+		// Writes made without the trigger are missing from the index
+		rebuild = true
+	}
+
+	if rebuild {
+		start := time.Now()
+		if _, err := db.NewQuery(fmt.Sprintf("INSERT INTO %s(%s) VALUES ('rebuild')", idx.name, idx.name)).Execute(); err != nil {
+			return err
+		}
+		s.logger.Info("search index rebuilt", "index", idx.name, "duration", time.Since(start).String())
+	}
+
+	return nil
+}
+
+func (idx index) triggerNames() []string {
+	return []string{idx.name + "_insert", idx.name + "_delete", idx.name + "_update"}
+}
+
+// rank is the bm25 ranking expression of an index
+func (idx index) rank() string {
+	weights := make([]string, len(idx.weights))
+	for i, weight := range idx.weights {
+		weights[i] = fmt.Sprintf("%.1f", weight)
+	}
+	return fmt.Sprintf("bm25(%s, %s)", idx.name, strings.Join(weights, ", "))
+}
+
+// Terms returns the words of a query
+func Terms(text string) []string {
+	return termPattern.FindAllString(strings.ToLower(text), MaxTerms)
+}
+
+// Search returns the channels and programmes of the user's playlists and
+// guide sources matching all the words of the query, by relevance.
+// Programmes that have ended are left out.
+func (s *Service) Search(query Query) (*Result, error) {
+	terms := Terms(query.Text)
+	if len(terms) == 0 {
+		return nil, ErrQuery
+	}
+
+	switch query.Type {
+	case "":
+		query.Type = TypeAll
+	case TypeAll, TypeChannels, TypePrograms:
+	default:
+		return nil, ErrType
+	}
+	if query.Page < 1 {
+		query.Page = 1
+	}
+	if query.PerPage < 1 || query.PerPage > MaxPerPage {
+		query.PerPage = DefaultPerPage
+	}
+
+	result := &Result{Query: strings.Join(terms, " "), FullText: s.fts}
+
+	playlistIDs, err := s.ids("playlists", "user ~ {:user}", query.User)
+	if err != nil {
+		return nil, err
+	}
+
+	if query.Type != TypePrograms {
+		result.Channels, err = s.channels(query, terms, playlistIDs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if query.Type != TypeChannels {
+		sourceIDs, err := s.ids("epg_sources", "user ~ {:user}", query.User)
+		if err != nil {
+			return nil, err
+		}
+		result.Programs, err = s.programs(query, terms, playlistIDs, sourceIDs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+func (s *Service) channels(query Query, terms []string, playlistIDs []string) (*Page[*models.Record], error) {
+	page := newPage[*models.Record](query)
+	if len(playlistIDs) == 0 {
+		return page, nil
+	}
+
+	params := dbx.Params{}
+	where := []string{"c.merged_into = ''", inPlaylists("c", playlistIDs, params)}
+	from := "channels c"
+	order := "c.name"
+
+	if s.fts {
+		from = channelsIndex.name + " JOIN channels c ON c.rowid = " + channelsIndex.name + ".rowid"
+		where = append(where, matchExpr(channelsIndex, terms, params))
+		order = channelsIndex.rank() + ", c.name"
+	} else {
+		where = append(where, likeExpr("c", channelsIndex.columns, terms, params))
+		params["prefix"] = terms[0] + "%"
+		order = "lower(c.name) LIKE {:prefix} DESC, c.name"
+	}
+
+	ids, total, err := s.find("c", from, strings.Join(where, " AND "), order, params, page)
+	if err != nil {
+		return nil, err
+	}
+
+	channels, err := s.load("channels", ids)
+	if err != nil {
+		return nil, err
+	}
+
+	page.Items = channels[:0]
+	for _, channel := range channels {
+		if query.Hidden != nil && query.Hidden(channel) {
+			total--
+			continue
+		}
+		page.Items = append(page.Items, channel)
+	}
+	page.setTotal(total)
+	return page, nil
+}
+
+func (s *Service) programs(query Query, terms []string, playlistIDs, sourceIDs []string) (*Page[ProgramHit], error) {
+	page := newPage[ProgramHit](query)
+	if len(sourceIDs) == 0 {
+		return page, nil
+	}
+
+	now, _ := types.ParseDateTime(time.Now())
+	params := dbx.Params{"now": now.String()}
+	where := []string{"p.end_time > {:now}", inList("p.source", "source", sourceIDs, params)}
+	from := "epg_programs p"
+	order := "p.start_time"
+
+	if s.fts {
+		from = programsIndex.name + " JOIN epg_programs p ON p.rowid = " + programsIndex.name + ".rowid"
+		where = append(where, matchExpr(programsIndex, terms, params))
+		order = programsIndex.rank() + ", p.start_time"
+	} else {
+		where = append(where, likeExpr("p", programsIndex.columns, terms, params))
+		params["prefix"] = terms[0] + "%"
+		order = "lower(p.title) LIKE {:prefix} DESC, p.start_time"
+	}
+
+	ids, total, err := s.find("p", from, strings.Join(where, " AND "), order, params, page)
+	if err != nil {
+		return nil, err
+	}
+
+	programs, err := s.load("epg_programs", ids)
+	if err != nil {
+		return nil, err
+	}
+
+	channels, err := s.channelsByTvgID(programs, playlistIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	page.Items = make([]ProgramHit, 0, len(programs))
+	for _, program := range programs {
+		channel := channels[program.GetString("channel_id")]
+		if channel != nil && query.Hidden != nil && query.Hidden(channel) {
+			total--
+			continue
+		}
+		page.Items = append(page.Items, ProgramHit{Program: program, Channel: channel})
+	}
+	page.setTotal(total)
+	return page, nil
+}
+
+// channelsByTvgID maps the guide ids of programmes to the user's channels
+// having them. A duplicate merged into another channel maps to that one.
+func (s *Service) channelsByTvgID(programs []*models.Record, playlistIDs []string) (map[string]*models.Record, error) {
+	result := make(map[string]*models.Record)
+
+	tvgIDs := make([]string, 0, len(programs))
+	for _, program := range programs {
+		if id := program.GetString("channel_id"); id != "" {
+			tvgIDs = append(tvgIDs, id)
+		}
+	}
+	if len(tvgIDs) == 0 || len(playlistIDs) == 0 {
+		return result, nil
+	}
+
+	params := dbx.Params{}
+	where := inList("channels.tvg_id", "tvg", tvgIDs, params) + " AND " + inPlaylists("channels", playlistIDs, params)
+
+	channels := []*models.Record{}
+	err := s.app.Dao().RecordQuery("channels").
+		AndWhere(dbx.NewExp(where, params)).
+		OrderBy("merged_into", "created").
+		All(&channels)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, channel := range channels {
+		tvgID := channel.GetString("tvg_id")
+		if _, ok := result[tvgID]; ok {
+			continue
+		}
+		// Merged duplicates are played through their primary channel
+		if into := channel.GetString("merged_into"); into != "" {
+			if primary, err := s.app.Dao().FindRecordById("channels", into); err == nil {
+				channel = primary
+			}
+		}
+		result[tvgID] = channel
+	}
+	return result, nil
+}
+
+// find returns the ids of a page of matching rows of alias and their total
+func (s *Service) find(alias, from, where, order string, params dbx.Params, page interface{ bounds() (int, int) }) ([]string, int, error) {
+	db := s.app.Dao().DB()
+
+	var total int
+	if err := db.NewQuery("SELECT count(*) FROM " + from + " WHERE " + where).Bind(params).Row(&total); err != nil {
+		return nil, 0, err
+	}
+
+	limit, offset := page.bounds()
+	ids := []string{}
+	err := db.NewQuery(fmt.Sprintf("SELECT %s.id FROM %s WHERE %s ORDER BY %s LIMIT %d OFFSET %d",
+		alias, from, where, order, limit, offset)).
+		Bind(params).
+		Column(&ids)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return ids, total, nil
+}
+
+// load returns records in the order of their ids
+func (s *Service) load(collection string, ids []string) ([]*models.Record, error) {
+	records, err := s.app.Dao().FindRecordsByIds(collection, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string]*models.Record, len(records))
+	for _, record := range records {
+		byID[record.Id] = record
+	}
+
+	ordered := make([]*models.Record, 0, len(ids))
+	for _, id := range ids {
+		if record, ok := byID[id]; ok {
+			ordered = append(ordered, record)
+		}
+	}
+	return ordered, nil
+}
+
+// ids returns the ids of the records of a collection matching a filter on a user
+func (s *Service) ids(collection, filter, userID string) ([]string, error) {
+	records, err := s.app.Dao().FindRecordsByFilter(collection, filter, "", 0, 0, dbx.Params{"user": userID})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(records))
+	for i, record := range records {
+		ids[i] = record.Id
+	}
+	return ids, nil
+}
+
+func newPage[T any](query Query) *Page[T] {
+	return &Page[T]{Page: query.Page, PerPage: query.PerPage, Items: []T{}}
+}
+
+func (p *Page[T]) bounds() (int, int) {
+	return p.PerPage, (p.Page - 1) * p.PerPage
+}
+
+func (p *Page[T]) setTotal(total int) {
+	p.TotalItems = max(total, 0)
+	p.TotalPages = (p.TotalItems + p.PerPage - 1) / p.PerPage
+}
+
+// matchExpr matches rows of an index containing every term, as a prefix so
+// results come while typing
+func matchExpr(idx index, terms []string, params dbx.Params) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + term + `"*`
+	}
+	params["match"] = strings.Join(quoted, " ")
+	return idx.name + " MATCH {:match}"
+}
+
+// likeExpr matches rows with every term in one of the columns
+func likeExpr(alias string, columns []string, terms []string, params dbx.Params) string {
+	clauses := make([]string, len(terms))
+	for i, term := range terms {
+		name := fmt.Sprintf("term%d", i)
+		params[name] = "%" + term + "%"
+
+		matches := make([]string, len(columns))
+		for j, column := range columns {
+			matches[j] = fmt.Sprintf("%s.%s LIKE {:%s}", alias, column, name)
+		}
+		clauses[i] = "(" + strings.Join(matches, " OR ") + ")"
+	}
+	return strings.Join(clauses, " AND ")
+}
+
+// inList matches a column against values
+func inList(column, prefix string, values []string, params dbx.Params) string {
+	placeholders := make([]string, len(values))
+	for i, value := range values {
+		name := fmt.Sprintf("%s%d", prefix, i)
+		params[name] = value
+		placeholders[i] = "{:" + name + "}"
+	}
+	return column + " IN (" + strings.Join(placeholders, ", ") + ")"
+}
+
+// inPlaylists matches channels of the playlists. Relation fields may be
+// stored as a single id or as an array of ids.
+func inPlaylists(alias string, playlistIDs []string, params dbx.Params) string {
+	return fmt.Sprintf(
+		"EXISTS (SELECT 1 FROM json_each(CASE WHEN json_valid(%[1]s.playlist) THEN %[1]s.playlist ELSE json_array(%[1]s.playlist) END) WHERE %[2]s)",
+		alias, inList("json_each.value", "playlist", playlistIDs, params))
+}
@@ -0,0 +1,54 @@
+package sessions
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/security"
+	"github.com/spf13/cast"
+)
+
+// MFATokenDuration is how long a login waiting for a TOTP code may take
+const MFATokenDuration = 5 * time.Minute
+
+// mfaTokenType tells pending login tokens apart from auth tokens signed
+// with the same key
+const mfaTokenType = "pendingMFA"
+
+// ErrInvalidMFAToken is returned for pending login tokens that are
+// malformed, expired or were signed for another user
+var ErrInvalidMFAToken = errors.New("invalid or expired login, sign in again")
+
+// MFAToken returns the token of a login of user that passed its first
+// factor, exchanged with a TOTP code for an auth token within
+// MFATokenDuration. It is signed with the user's token key, so
+// changing the password voids it.
+func (s *Service) MFAToken(user *models.Record) (string, error) {
+	return security.NewJWT(
+		jwt.MapClaims{
+			"id":   user.Id,
+			"type": mfaTokenType,
+		},
+		user.TokenKey()+s.app.Settings().RecordAuthToken.Secret,
+		int64(MFATokenDuration.Seconds()),
+	)
+}
+
+// MFAUser returns the user a pending login token was issued to
+func (s *Service) MFAUser(token string) (*models.Record, error) {
+	unverified, err := security.ParseUnverifiedJWT(token)
+	if err != nil || cast.ToString(unverified["type"]) != mfaTokenType {
+		return nil, ErrInvalidMFAToken
+	}
+
+	user, err := s.app.Dao().FindRecordById("users", cast.ToString(unverified["id"]))
+	if err != nil {
+		return nil, ErrInvalidMFAToken
+	}
+	if _, err := security.ParseJWT(token, user.TokenKey()+s.app.Settings().RecordAuthToken.Secret); err != nil {
+		return nil, ErrInvalidMFAToken
+	}
+	return user, nil
+}
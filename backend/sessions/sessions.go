@@ -0,0 +1,320 @@
+// Package sessions tracks the clients a user is signed in on. Every auth
+// token issued to a user carries the ID of a session record holding the
+// client's user agent, IP and last activity; deleting the record revokes
+// the token, so users can sign out devices they no longer trust. Browsers
+// can also be remembered as trusted devices that skip the TOTP code.
+package sessions
+
+import (
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tokens"
+	"github.com/pocketbase/pocketbase/tools/security"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/spf13/cast"
+
+	"iptv-backend/logging"
+)
+
+// Collection is where sessions are stored
+const Collection = "sessions"
+
+// ContextKey holds the sessions record of the request's auth token
+const ContextKey = "session"
+
+// claim is the auth token claim holding the session ID
+const claim = "sid"
+
+// touchInterval limits how often last_seen is written
+const touchInterval = time.Minute
+
+// ErrNotFound is returned for sessions that don't exist or belong to
+// another user
+var ErrNotFound = errors.New("session not found")
+
+// Session describes a session for the API
+type Session struct {
+	ID        string         `json:"id"`
+	Device    string         `json:"device"`
+	UserAgent string         `json:"user_agent"`
+	IP        string         `json:"ip"`
+	Created   types.DateTime `json:"created"`
+	LastSeen  types.DateTime `json:"last_seen"`
+	Current   bool           `json:"current"`
+}
+
+// Service issues session tokens and revokes sessions
+type Service struct {
+	app    core.App
+	logger *slog.Logger
+}
+
+// NewService returns a session service over the app's database
+func NewService(app core.App) *Service {
+	return &Service{app: app, logger: logging.For("sessions")}
+}
+
+// Register installs the hook replacing the token of every users auth
+// response with a session token. Responses without a token, like logins
+// waiting for a TOTP code, are left alone, so it must be added after the
+// hooks withholding tokens.
+func (s *Service) Register() {
+	s.app.OnRecordAuthRequest("users").Add(func(e *core.RecordAuthEvent) error {
+		if e.Token == "" {
+			return nil
+		}
+
+		token, err := s.Issue(e.HttpContext, e.Record)
+		if err != nil {
+			return apis.NewBadRequestError("Failed to create auth token.", err)
+		}
+		e.Token = token
+		return nil
+	})
+}
+
+// Issue returns an auth token for record. Requests already authenticated
+// as record, like token refreshes, keep their session; others start one.
+func (s *Service) Issue(c echo.Context, record *models.Record) (string, error) {
+	session := Current(c)
+	if session == nil || session.GetString("user") != record.Id {
+		var err error
+		if session, err = s.start(c, record.Id); err != nil {
+			return "", err
+		}
+	}
+	return s.token(record, session.Id)
+}
+
+// Middleware checks the session of auth tokens loaded by PocketBase.
+// Requests whose session was revoked are handled as anonymous; tokens
+// issued before sessions existed carry none and are accepted until they
+// expire. It must run after PocketBase loaded the auth token.
+func (s *Service) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			record, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if record == nil {
+				return next(c)
+			}
+
+			token := strings.TrimPrefix(c.Request().Header.Get("Authorization"), "Bearer ")
+			claims, _ := security.ParseUnverifiedJWT(token)
+			id := cast.ToString(claims[claim])
+			if id == "" {
+				return next(c)
+			}
+
+			session, err := s.app.Dao().FindRecordById(Collection, id)
+			if err != nil || session.GetString("user") != record.Id {
+				c.Set(apis.ContextAuthRecordKey, nil)
+				return next(c)
+			}
+
+			c.Set(ContextKey, session)
+			s.touch(c, session)
+
+			return next(c)
+		}
+	}
+}
+
+// Current returns the session of the request's auth token, nil for
+// anonymous requests and tokens without a session
+func Current(c echo.Context) *models.Record {
+	session, _ := c.Get(ContextKey).(*models.Record)
+	return session
+}
+
+// List returns the sessions of a user, most recently active first. The
+// session of the request is flagged as current.
+func (s *Service) List(c echo.Context, userID string) ([]Session, error) {
+	records, err := s.app.Dao().FindRecordsByFilter(Collection, "user = {:user}", "-last_seen", 0, 0, dbx.Params{"user": userID})
+	if err != nil {
+		return nil, err
+	}
+
+	current := ""
+	if session := Current(c); session != nil {
+		current = session.Id
+	}
+
+	list := make([]Session, 0, len(records))
+	for _, record := range records {
+		session := describe(record)
+		session.Current = record.Id == current
+		list = append(list, session)
+	}
+	return list, nil
+}
+
+// Revoke deletes a session of a user, its token stops working immediately
+func (s *Service) Revoke(userID, id string) error {
+	record, err := s.app.Dao().FindRecordById(Collection, id)
+	if err != nil || record.GetString("user") != userID {
+		return ErrNotFound
+	}
+	return s.app.Dao().DeleteRecord(record)
+}
+
+// RevokeOthers signs user out everywhere but the current request. Other
+// sessions are deleted and the user's token key is rotated, which also
+// invalidates tokens without a session and trusted device cookies, so
+// trusted devices are forgotten too. The returned token replaces the
+// request's token.
+func (s *Service) RevokeOthers(c echo.Context, user *models.Record) (string, int, error) {
+	current := Current(c)
+
+	revoked := 0
+	err := s.app.Dao().RunInTransaction(func(txDao *daos.Dao) error {
+		records, err := txDao.FindRecordsByFilter(Collection, "user = {:user}", "", 0, 0, dbx.Params{"user": user.Id})
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			if current != nil && record.Id == current.Id {
+				continue
+			}
+			if err := txDao.DeleteRecord(record); err != nil {
+				return err
+			}
+			revoked++
+		}
+
+		_, err = txDao.DB().Delete(TrustedCollection, dbx.HashExp{"user": user.Id}).Execute()
+		if err != nil {
+			return err
+		}
+
+		user.RefreshTokenKey()
+		return txDao.SaveRecord(user)
+	})
+	if err != nil {
+		return "", 0, err
+	}
+
+	token, err := s.Issue(c, user)
+	return token, revoked, err
+}
+
+// start creates a session for a client of user
+func (s *Service) start(c echo.Context, userID string) (*models.Record, error) {
+	collection, err := s.app.Dao().FindCollectionByNameOrId(Collection)
+	if err != nil {
+		return nil, err
+	}
+
+	userAgent := c.Request().UserAgent()
+	if len(userAgent) > 500 {
+		userAgent = userAgent[:500]
+	}
+	now, _ := types.ParseDateTime(time.Now())
+
+	record := models.NewRecord(collection)
+	record.Set("user", userID)
+	record.Set("user_agent", userAgent)
+	record.Set("ip", c.RealIP())
+	record.Set("last_seen", now)
+	if err := s.app.Dao().SaveRecord(record); err != nil {
+		return nil, err
+	}
+
+	s.logger.Debug("session started", "user", userID, "session_id", record.Id)
+	return record, nil
+}
+
+// token signs an auth token like PocketBase's, with the session claim
+func (s *Service) token(record *models.Record, sessionID string) (string, error) {
+	return security.NewJWT(
+		jwt.MapClaims{
+			"id":           record.Id,
+			"type":         tokens.TypeAuthRecord,
+			"collectionId": record.Collection().Id,
+			claim:          sessionID,
+		},
+		record.TokenKey()+s.app.Settings().RecordAuthToken.Secret,
+		s.app.Settings().RecordAuthToken.Duration,
+	)
+}
+
+// touch records the session's last activity and address, at most every
+// touchInterval
+func (s *Service) touch(c echo.Context, session *models.Record) {
+	if time.Since(session.GetDateTime("last_seen").Time()) < touchInterval {
+		return
+	}
+
+	now, _ := types.ParseDateTime(time.Now())
+	session.Set("last_seen", now)
+	session.Set("ip", c.RealIP())
+	if err := s.app.Dao().SaveRecord(session); err != nil {
+		s.logger.Debug("failed to update session activity", "session_id", session.Id, "error", err)
+	}
+}
+
+func describe(record *models.Record) Session {
+	return Session{
+		ID:        record.Id,
+		Device:    Device(record.GetString("user_agent")),
+		UserAgent: record.GetString("user_agent"),
+		IP:        record.GetString("ip"),
+		Created:   record.Created,
+		LastSeen:  record.GetDateTime("last_seen"),
+	}
+}
+
+// Device returns a short "Browser on OS" label for a user agent
+func Device(userAgent string) string {
+	if userAgent == "" {
+		return "Unknown device"
+	}
+
+	browser := "Unknown browser"
+	for _, b := range []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"Kodi/", "Kodi"},
+		{"VLC/", "VLC"},
+		{"curl/", "curl"},
+		{"python-requests/", "Python"},
+	} {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	system := ""
+	for _, o := range []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(userAgent, o.token) {
+			system = o.name
+			break
+		}
+	}
+
+	if system == "" {
+		return browser
+	}
+	return browser + " on " + system
+}
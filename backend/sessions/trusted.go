@@ -0,0 +1,185 @@
+package sessions
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/security"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/spf13/cast"
+)
+
+// TrustedCollection is where trusted devices are stored
+const TrustedCollection = "trusted_devices"
+
+// TrustCookie holds the signed token of a trusted device
+const TrustCookie = "sv_trusted_device"
+
+// TrustDuration is how long a device may skip the TOTP code
+const TrustDuration = 30 * 24 * time.Hour
+
+// trustTokenType tells device tokens apart from auth tokens signed with
+// the same key
+const trustTokenType = "trustedDevice"
+
+// TrustedDevice describes a trusted device for the API
+type TrustedDevice struct {
+	ID         string         `json:"id"`
+	Device     string         `json:"device"`
+	UserAgent  string         `json:"user_agent"`
+	IP         string         `json:"ip"`
+	Created    types.DateTime `json:"created"`
+	ExpiresAt  types.DateTime `json:"expires_at"`
+	LastUsedAt types.DateTime `json:"last_used_at"`
+	Current    bool           `json:"current"`
+}
+
+// Trust remembers the requesting browser as a device of user that may skip
+// the TOTP code for TrustDuration, and sets its cookie. The cookie is signed
+// with the user's token key, so rotating the key forgets every device.
+func (s *Service) Trust(c echo.Context, user *models.Record) error {
+	collection, err := s.app.Dao().FindCollectionByNameOrId(TrustedCollection)
+	if err != nil {
+		return err
+	}
+
+	userAgent := c.Request().UserAgent()
+	if len(userAgent) > 500 {
+		userAgent = userAgent[:500]
+	}
+	expires := time.Now().Add(TrustDuration)
+	expiresAt, _ := types.ParseDateTime(expires)
+
+	record := models.NewRecord(collection)
+	record.Set("user", user.Id)
+	record.Set("user_agent", userAgent)
+	record.Set("ip", c.RealIP())
+	record.Set("expires_at", expiresAt)
+	if err := s.app.Dao().SaveRecord(record); err != nil {
+		return err
+	}
+
+	token, err := security.NewJWT(
+		jwt.MapClaims{
+			"id":     user.Id,
+			"type":   trustTokenType,
+			"device": record.Id,
+		},
+		user.TokenKey()+s.app.Settings().RecordAuthToken.Secret,
+		int64(TrustDuration.Seconds()),
+	)
+	if err != nil {
+		return err
+	}
+
+	c.SetCookie(&http.Cookie{
+		Name:     TrustCookie,
+		Value:    token,
+		Path:     "/api/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// Trusted reports whether the requesting browser is a trusted device of user
+func (s *Service) Trusted(c echo.Context, user *models.Record) bool {
+	device := s.trustedDevice(c, user)
+	if device == nil {
+		return false
+	}
+
+	if time.Since(device.GetDateTime("last_used_at").Time()) >= touchInterval {
+		now, _ := types.ParseDateTime(time.Now())
+		device.Set("last_used_at", now)
+		if err := s.app.Dao().SaveRecord(device); err != nil {
+			s.logger.Debug("failed to update trusted device use", "device_id", device.Id, "error", err)
+		}
+	}
+	return true
+}
+
+// ListTrusted returns the unexpired trusted devices of a user, newest
+// first. The requesting browser is flagged as current.
+func (s *Service) ListTrusted(c echo.Context, user *models.Record) ([]TrustedDevice, error) {
+	now, _ := types.ParseDateTime(time.Now())
+	records, err := s.app.Dao().FindRecordsByFilter(
+		TrustedCollection,
+		"user = {:user} && expires_at > {:now}",
+		"-created", 0, 0,
+		dbx.Params{"user": user.Id, "now": now.String()},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	current := ""
+	if device := s.trustedDevice(c, user); device != nil {
+		current = device.Id
+	}
+
+	list := make([]TrustedDevice, 0, len(records))
+	for _, record := range records {
+		list = append(list, TrustedDevice{
+			ID:         record.Id,
+			Device:     Device(record.GetString("user_agent")),
+			UserAgent:  record.GetString("user_agent"),
+			IP:         record.GetString("ip"),
+			Created:    record.Created,
+			ExpiresAt:  record.GetDateTime("expires_at"),
+			LastUsedAt: record.GetDateTime("last_used_at"),
+			Current:    record.Id == current,
+		})
+	}
+	return list, nil
+}
+
+// Untrust forgets a trusted device of a user, it has to enter the TOTP code
+// on its next login. The cookie is cleared when it is the requesting
+// browser.
+func (s *Service) Untrust(c echo.Context, user *models.Record, id string) error {
+	record, err := s.app.Dao().FindRecordById(TrustedCollection, id)
+	if err != nil || record.GetString("user") != user.Id {
+		return ErrNotFound
+	}
+
+	if device := s.trustedDevice(c, user); device != nil && device.Id == record.Id {
+		c.SetCookie(&http.Cookie{
+			Name:     TrustCookie,
+			Path:     "/api/",
+			MaxAge:   -1,
+			HttpOnly: true,
+		})
+	}
+
+	return s.app.Dao().DeleteRecord(record)
+}
+
+// trustedDevice returns the unexpired trusted device of user the request's
+// cookie was signed for
+func (s *Service) trustedDevice(c echo.Context, user *models.Record) *models.Record {
+	cookie, err := c.Cookie(TrustCookie)
+	if err != nil || cookie.Value == "" {
+		return nil
+	}
+
+	claims, err := security.ParseJWT(cookie.Value, user.TokenKey()+s.app.Settings().RecordAuthToken.Secret)
+	if err != nil || cast.ToString(claims["type"]) != trustTokenType || cast.ToString(claims["id"]) != user.Id {
+		return nil
+	}
+
+	device, err := s.app.Dao().FindRecordById(TrustedCollection, cast.ToString(claims["device"]))
+	if err != nil || device.GetString("user") != user.Id {
+		return nil
+	}
+	if device.GetDateTime("expires_at").Time().Before(time.Now()) {
+		return nil
+	}
+	return device
+}
@@ -0,0 +1,475 @@
+// Package settings stores the configuration changed at runtime, as JSON
+// values under a key in the app_settings collection. Services register the
+// keys they are configured by and are handed every new value, whether it
+// was set through the API, from code or by editing the collection.
+//
+// Global settings configure the server and only admins change them. User
+// settings are each user's own, stored in records of the user, and default
+// to the global value of their key.
+package settings
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sort"
+	"sync"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+
+	"iptv-backend/logging"
+)
+
+// Collection holds one record per setting, with its key, JSON value and
+// the user it belongs to, none for global values
+const Collection = "app_settings"
+
+// Scope tells who a setting belongs to
+type Scope int
+
+const (
+	// ScopeGlobal settings configure the server, for everybody
+	ScopeGlobal Scope = iota
+	// ScopeUser settings are set by each user for themselves
+	ScopeUser
+)
+
+// ErrNotSet is returned for a key no value was stored under
+var ErrNotSet = errors.New("setting not set")
+
+// ErrUnknownKey is returned by the API for keys no service registered
+var ErrUnknownKey = errors.New("unknown setting")
+
+// ErrWrongScope is returned when a user sets a global setting
+var ErrWrongScope = errors.New("not a user setting")
+
+// InvalidError is returned when the service a setting configures refuses
+// a value
+type InvalidError struct {
+	Key string
+	Err error
+}
+
+func (e *InvalidError) Error() string {
+	return "invalid setting " + e.Key + ": " + e.Err.Error()
+}
+
+func (e *InvalidError) Unwrap() error {
+	return e.Err
+}
+
+// Definition describes a setting a service is configured by
+type Definition struct {
+	// Apply validates a new value and applies it to the service, at startup
+	// with the stored value and then on every change. An error refuses the
+	// value, which isn't saved. Values of user settings are only validated,
+	// services read them with GetFor when they need them.
+	Apply func(value json.RawMessage) error
+	// Current returns the value in effect, defaults included, for the API.
+	// Optional: the stored value is returned without it.
+	Current func() interface{}
+	// Private keeps the setting out of the settings API, for values holding
+	// secrets which have their own endpoints
+	Private bool
+	// Scope is ScopeGlobal unless users set the setting for themselves
+	Scope Scope
+}
+
+// Service caches the settings and notifies the services using them
+type Service struct {
+	app    core.App
+	logger *slog.Logger
+
+	mu          sync.RWMutex
+	values      map[string]json.RawMessage            // Cached global values, by key
+	loaded      bool                                  // All stored global values are cached
+	userValues  map[string]map[string]json.RawMessage // Cached values of users, by user and key
+	definitions map[string]Definition
+}
+
+// NewService creates the settings service and watches the collection for
+// changes
+func NewService(app core.App) *Service {
+	s := &Service{
+		app:         app,
+		logger:      logging.For("settings"),
+		values:      make(map[string]json.RawMessage),
+		userValues:  make(map[string]map[string]json.RawMessage),
+		definitions: make(map[string]Definition),
+	}
+
+	// Values are applied before they are saved, so services can refuse them
+	apply := func(e *core.ModelEvent) error {
+		record, ok := e.Model.(*models.Record)
+		if !ok {
+			return nil
+		}
+		key := record.GetString("key")
+		if err := s.apply(key, record.GetString("user"), json.RawMessage(record.GetString("value"))); err != nil {
+			return &InvalidError{Key: key, Err: err}
+		}
+		return nil
+	}
+	cache := func(e *core.ModelEvent) error {
+		record, ok := e.Model.(*models.Record)
+		if !ok {
+			return nil
+		}
+		user, key := record.GetString("user"), record.GetString("key")
+		// The key or the user of the record may have changed
+		if original := record.OriginalCopy(); !original.IsNew() && (original.GetString("user") != user || original.GetString("key") != key) {
+			s.uncache(original.GetString("user"), original.GetString("key"))
+		}
+		s.cache(user, key, json.RawMessage(record.GetString("value")))
+		return nil
+	}
+	app.OnModelBeforeCreate(Collection).Add(apply)
+	app.OnModelBeforeUpdate(Collection).Add(apply)
+	app.OnModelAfterCreate(Collection).Add(cache)
+	app.OnModelAfterUpdate(Collection).Add(cache)
+	// Services keep the values they have until the next restart
+	app.OnModelAfterDelete(Collection).Add(func(e *core.ModelEvent) error {
+		if record, ok := e.Model.(*models.Record); ok {
+			s.uncache(record.GetString("user"), record.GetString("key"))
+		}
+		return nil
+	})
+
+	return s
+}
+
+// cache stores a value saved in the collection, for user or global
+func (s *Service) cache(user, key string, value json.RawMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user == "" {
+		s.values[key] = value
+	} else if values, ok := s.userValues[user]; ok {
+		values[key] = value
+	}
+}
+
+// uncache forgets a value deleted from the collection
+func (s *Service) uncache(user, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user == "" {
+		delete(s.values, key)
+	} else if values, ok := s.userValues[user]; ok {
+		delete(values, key)
+	}
+}
+
+// Register declares a setting. Register before Load, so the stored value
+// is applied at startup.
+func (s *Service) Register(key string, definition Definition) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.definitions[key] = definition
+}
+
+// Load caches the stored global settings and applies those registered.
+// Values the services refuse are logged and skipped.
+func (s *Service) Load() error {
+	collection, err := s.app.Dao().FindCollectionByNameOrId(Collection)
+	if err != nil {
+		return nil // Created by a migration not applied yet
+	}
+
+	// Settings load before the migrations run, every value was global
+	// before users had theirs
+	filter := "key != ''"
+	if collection.Schema.GetFieldByName("user") != nil {
+		filter += " && user = ''"
+	}
+	records, err := s.app.Dao().FindRecordsByFilter(Collection, filter, "", 0, 0)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	for _, record := range records {
+		s.values[record.GetString("key")] = json.RawMessage(record.GetString("value"))
+	}
+	s.loaded = true
+	s.mu.Unlock()
+
+	for _, record := range records {
+		key := record.GetString("key")
+		if err := s.apply(key, "", json.RawMessage(record.GetString("value"))); err != nil {
+			s.logger.Warn("ignoring saved setting", "key", key, "error", err)
+		} else if s.registered(key) {
+			s.logger.Info("setting loaded from database", "key", key)
+		}
+	}
+	return nil
+}
+
+// apply hands a value to the service that registered key, if any. Values
+// of users are only allowed for user settings, so users can't change the
+// configuration of the server through their records.
+func (s *Service) apply(key, user string, value json.RawMessage) error {
+	s.mu.RLock()
+	definition, ok := s.definitions[key]
+	s.mu.RUnlock()
+	if user != "" && (!ok || definition.Scope != ScopeUser) {
+		return ErrWrongScope
+	}
+	if !ok || definition.Apply == nil {
+		return nil
+	}
+	return definition.Apply(value)
+}
+
+func (s *Service) registered(key string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.definitions[key]
+	return ok
+}
+
+// Raw returns the global JSON value stored under key, or ErrNotSet
+func (s *Service) Raw(key string) (json.RawMessage, error) {
+	s.mu.RLock()
+	value, ok := s.values[key]
+	loaded := s.loaded
+	s.mu.RUnlock()
+	if ok {
+		return value, nil
+	}
+	if loaded {
+		return nil, ErrNotSet
+	}
+
+	record, err := s.app.Dao().FindFirstRecordByFilter(Collection, "key = {:key} && user = ''", dbx.Params{"key": key})
+	if err != nil {
+		return nil, ErrNotSet
+	}
+	value = json.RawMessage(record.GetString("value"))
+
+	s.mu.Lock()
+	s.values[key] = value
+	s.mu.Unlock()
+	return value, nil
+}
+
+// userValue returns the value user stored under key, caching all of the
+// user's values on first use
+func (s *Service) userValue(user, key string) (json.RawMessage, bool, error) {
+	s.mu.RLock()
+	values, loaded := s.userValues[user]
+	value, ok := values[key]
+	s.mu.RUnlock()
+	if loaded {
+		return value, ok, nil
+	}
+
+	records, err := s.app.Dao().FindRecordsByFilter(Collection, "user = {:user}", "", 0, 0, dbx.Params{"user": user})
+	if err != nil {
+		return nil, false, err
+	}
+	values = make(map[string]json.RawMessage, len(records))
+	for _, record := range records {
+		values[record.GetString("key")] = json.RawMessage(record.GetString("value"))
+	}
+
+	s.mu.Lock()
+	s.userValues[user] = values
+	s.mu.Unlock()
+
+	value, ok = values[key]
+	return value, ok, nil
+}
+
+// Get decodes the global value stored under key into a T, or returns
+// ErrNotSet
+func Get[T any](s *Service, key string) (T, error) {
+	var value T
+	raw, err := s.Raw(key)
+	if err != nil {
+		return value, err
+	}
+	err = json.Unmarshal(raw, &value)
+	return value, err
+}
+
+// GetFor decodes the value of user for a user setting into a T, over the
+// global value so fields the user left out keep it. Returns ErrNotSet when
+// neither is stored.
+func GetFor[T any](s *Service, user, key string) (T, error) {
+	var value T
+	global, err := s.Raw(key)
+	if err != nil && !errors.Is(err, ErrNotSet) {
+		return value, err
+	}
+	own, set, ownErr := s.userValue(user, key)
+	if ownErr != nil {
+		return value, ownErr
+	}
+	if err != nil && !set {
+		return value, err
+	}
+
+	if global != nil {
+		if err := json.Unmarshal(global, &value); err != nil {
+			return value, err
+		}
+	}
+	if set {
+		err = json.Unmarshal(own, &value)
+	}
+	return value, err
+}
+
+// Set stores the global value of key, once the service that registered key
+// accepted it. A refused value returns an *InvalidError.
+func (s *Service) Set(key string, value interface{}) error {
+	return s.save("", key, value)
+}
+
+// SetFor stores the value of user for a user setting. A refused value, or a
+// key which isn't a user setting, returns an *InvalidError.
+func (s *Service) SetFor(user, key string, value interface{}) error {
+	return s.save(user, key, value)
+}
+
+// save stores a value of user, global without one
+func (s *Service) save(user, key string, value interface{}) error {
+	valueJSON, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	dao := s.app.Dao()
+	collection, err := dao.FindCollectionByNameOrId(Collection)
+	if err != nil {
+		return err
+	}
+
+	record, err := dao.FindFirstRecordByFilter(collection.Id, "key = {:key} && user = {:user}", dbx.Params{"key": key, "user": user})
+	if err != nil || record == nil {
+		record = models.NewRecord(collection)
+		record.Set("key", key)
+		record.Set("user", user)
+	}
+	record.Set("value", string(valueJSON))
+
+	return dao.SaveRecord(record)
+}
+
+// ResetFor deletes the value of user for key, who gets the global value
+// again
+func (s *Service) ResetFor(user, key string) error {
+	dao := s.app.Dao()
+	record, err := dao.FindFirstRecordByFilter(Collection, "key = {:key} && user = {:user}", dbx.Params{"key": key, "user": user})
+	if err != nil {
+		return nil // Not set
+	}
+	return dao.DeleteRecord(record)
+}
+
+// Keys returns the settings available through the API, sorted. Admins
+// set the global values of all of them, the defaults of user settings.
+func (s *Service) Keys() []string {
+	return s.keys(func(Definition) bool { return true })
+}
+
+// UserKeys returns the user settings available through the API, sorted
+func (s *Service) UserKeys() []string {
+	return s.keys(func(definition Definition) bool { return definition.Scope == ScopeUser })
+}
+
+func (s *Service) keys(match func(Definition) bool) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]string, 0, len(s.definitions))
+	for key, definition := range s.definitions {
+		if !definition.Private && match(definition) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Current returns the value in effect for a setting of the API, or
+// ErrUnknownKey
+func (s *Service) Current(key string) (interface{}, error) {
+	s.mu.RLock()
+	definition, ok := s.definitions[key]
+	s.mu.RUnlock()
+	if !ok || definition.Private {
+		return nil, ErrUnknownKey
+	}
+
+	if definition.Current != nil {
+		return definition.Current(), nil
+	}
+	value, err := s.Raw(key)
+	if errors.Is(err, ErrNotSet) {
+		return nil, nil
+	}
+	return value, err
+}
+
+// Update sets a setting of the API, or returns ErrUnknownKey
+func (s *Service) Update(key string, value json.RawMessage) error {
+	s.mu.RLock()
+	definition, ok := s.definitions[key]
+	s.mu.RUnlock()
+	if !ok || definition.Private {
+		return ErrUnknownKey
+	}
+	if !json.Valid(value) {
+		return &InvalidError{Key: key, Err: errors.New("value is not JSON")}
+	}
+	return s.Set(key, value)
+}
+
+// CurrentFor returns the value in effect for user of a user setting of the
+// API: the global value in effect, with the fields the user set replaced.
+// Returns ErrUnknownKey for other settings.
+func (s *Service) CurrentFor(user, key string) (interface{}, error) {
+	s.mu.RLock()
+	definition, ok := s.definitions[key]
+	s.mu.RUnlock()
+	if !ok || definition.Private || definition.Scope != ScopeUser {
+		return nil, ErrUnknownKey
+	}
+
+	current, err := s.Current(key)
+	if err != nil {
+		return nil, err
+	}
+	own, set, err := s.userValue(user, key)
+	if err != nil || !set {
+		return current, err
+	}
+
+	// Objects are merged, other values replaced
+	var merged map[string]interface{}
+	if global, err := json.Marshal(current); err == nil && json.Unmarshal(global, &merged) == nil && merged != nil {
+		if json.Unmarshal(own, &merged) == nil {
+			return merged, nil
+		}
+	}
+	return own, nil
+}
+
+// UpdateFor sets the value of user for a user setting of the API, or
+// returns ErrUnknownKey
+func (s *Service) UpdateFor(user, key string, value json.RawMessage) error {
+	s.mu.RLock()
+	definition, ok := s.definitions[key]
+	s.mu.RUnlock()
+	if !ok || definition.Private || definition.Scope != ScopeUser {
+		return ErrUnknownKey
+	}
+	if !json.Valid(value) {
+		return &InvalidError{Key: key, Err: errors.New("value is not JSON")}
+	}
+	return s.SetFor(user, key, value)
+}
@@ -0,0 +1,214 @@
+// Package sso configures single sign-on through an OpenID Connect provider
+// (Authentik, Keycloak, Google, ...). Logins go through PocketBase's OAuth2
+// flow with its "oidc" provider, which maps the external identity to a user:
+// the user it was linked to before, the logged in user when linking from the
+// account settings, or the user with the same verified email. Users with
+// TOTP enabled still have to enter a code before they get a token.
+package sso
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/auth"
+	"github.com/pocketbase/pocketbase/tools/rest"
+	"github.com/pocketbase/pocketbase/tools/types"
+
+	"iptv-backend/logging"
+)
+
+// ProviderName is the PocketBase OAuth2 provider used for SSO
+const ProviderName = auth.NameOIDC
+
+// discoveryTimeout bounds the fetch of the provider's configuration
+const discoveryTimeout = 15 * time.Second
+
+// Config describes the OpenID Connect provider
+type Config struct {
+	Issuer       string // Issuer URL, its /.well-known/openid-configuration is fetched
+	ClientID     string
+	ClientSecret string
+	DisplayName  string // Label of the login button
+	AllowSignup  bool   // Create users for identities matching no account
+
+	// SkipTOTP reports whether a TOTP user may sign in without a code, from
+	// a trusted device. Nil always requires the code.
+	SkipTOTP func(c echo.Context, user *models.Record) bool
+
+	// MFAToken returns the token a TOTP user sends with the code to get an
+	// auth token
+	MFAToken func(user *models.Record) (string, error)
+}
+
+// Enabled reports whether a provider is configured
+func (c Config) Enabled() bool {
+	return c.Issuer != "" && c.ClientID != ""
+}
+
+// discovery is the part of the provider's metadata PocketBase needs
+type discovery struct {
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+// Register configures the provider when the server starts and installs the
+// hooks enforcing signup and TOTP. Nothing is changed without an issuer, so
+// an OIDC provider set up from the PocketBase dashboard keeps working.
+func Register(app core.App, config Config) {
+	logger := logging.For("sso")
+	if !config.Enabled() {
+		return
+	}
+
+	app.OnBeforeServe().Add(func(e *core.ServeEvent) error {
+		if err := configure(app, config); err != nil {
+			logger.Error("failed to configure OIDC provider", "issuer", config.Issuer, "error", err)
+		} else {
+			logger.Info("OIDC provider configured", "issuer", config.Issuer)
+		}
+		return nil
+	})
+
+	// Refuse identities matching no account unless signups are allowed
+	app.OnRecordBeforeAuthWithOAuth2Request("users").Add(func(e *core.RecordAuthWithOAuth2Event) error {
+		if e.ProviderName != ProviderName || e.Record != nil || config.AllowSignup {
+			return nil
+		}
+
+		logger.Info("refused SSO login without account", "email", e.OAuth2User.Email)
+		return apis.NewForbiddenError("No account is linked to this identity, sign in with your password and link it from the security settings", nil)
+	})
+
+	// Hold back the token of users with TOTP enabled, they exchange a code
+	// and their MFA token for it at /api/auth/totp/validate like after a
+	// password login
+	app.OnRecordAuthRequest("users").Add(func(e *core.RecordAuthEvent) error {
+		if !IsOAuth2Login(e.HttpContext.Path()) || !e.Record.GetBool("totp_enabled") {
+			return nil
+		}
+
+		// Linking an identity to the account the user is signed in to
+		if linked, _ := e.HttpContext.Get(apis.ContextAuthRecordKey).(*models.Record); linked != nil && linked.Id == e.Record.Id {
+			return nil
+		}
+
+		if config.SkipTOTP != nil && config.SkipTOTP(e.HttpContext, e.Record) {
+			return nil
+		}
+
+		mfaToken, err := config.MFAToken(e.Record)
+		if err != nil {
+			return apis.NewBadRequestError("Failed to create auth token.", err)
+		}
+		e.Token = ""
+		e.Meta = map[string]any{"requires_2fa": true, "mfa_token": mfaToken}
+		return nil
+	})
+}
+
+// GuardSignup has check approve the users OAuth2 logins create, with any
+// provider. PocketBase saves them from the request's createData with full
+// manage access, so the create request hooks don't see them.
+func GuardSignup(app core.App, check func(c echo.Context, user *models.Record) error) {
+	app.OnRecordBeforeAuthWithOAuth2Request("users").Add(guardSignup(check))
+}
+
+// guardSignup loads the createData of a signup in the user it creates, and
+// returns the error of check on it
+func guardSignup(check func(c echo.Context, user *models.Record) error) func(e *core.RecordAuthWithOAuth2Event) error {
+	return func(e *core.RecordAuthWithOAuth2Event) error {
+		if e.Record != nil {
+			return nil
+		}
+
+		var body struct {
+			CreateData map[string]any `form:"createData" json:"createData"`
+		}
+		if err := rest.BindBody(e.HttpContext, &body); err != nil {
+			return apis.NewBadRequestError("Failed to read the request data", err)
+		}
+
+		user := models.NewRecord(e.Collection)
+		user.Load(body.CreateData)
+		return check(e.HttpContext, user)
+	}
+}
+
+// IsOAuth2Login reports whether a route is PocketBase's OAuth2 login
+func IsOAuth2Login(path string) bool {
+	return strings.HasSuffix(path, "/auth-with-oauth2")
+}
+
+// configure stores the provider's endpoints in PocketBase's OIDC settings
+func configure(app core.App, config Config) error {
+	endpoints, err := discover(config.Issuer)
+	if err != nil {
+		return err
+	}
+
+	displayName := config.DisplayName
+	if displayName == "" {
+		displayName = "SSO"
+	}
+
+	settings, err := app.Settings().Clone()
+	if err != nil {
+		return err
+	}
+	settings.OIDCAuth.Enabled = true
+	settings.OIDCAuth.ClientId = config.ClientID
+	settings.OIDCAuth.ClientSecret = config.ClientSecret
+	settings.OIDCAuth.AuthUrl = endpoints.AuthorizationEndpoint
+	settings.OIDCAuth.TokenUrl = endpoints.TokenEndpoint
+	settings.OIDCAuth.UserApiUrl = endpoints.UserinfoEndpoint
+	settings.OIDCAuth.DisplayName = displayName
+	settings.OIDCAuth.PKCE = types.Pointer(true)
+
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+	if err := app.Dao().SaveSettings(settings); err != nil {
+		return err
+	}
+	return app.RefreshSettings()
+}
+
+// discover fetches the provider's endpoints from its issuer
+func discover(issuer string) (*discovery, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), discoveryTimeout)
+	defer cancel()
+
+	url := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("discovery returned status %d", resp.StatusCode)
+	}
+
+	var endpoints discovery
+	if err := json.NewDecoder(resp.Body).Decode(&endpoints); err != nil {
+		return nil, fmt.Errorf("invalid discovery document: %w", err)
+	}
+	if endpoints.AuthorizationEndpoint == "" || endpoints.TokenEndpoint == "" || endpoints.UserinfoEndpoint == "" {
+		return nil, fmt.Errorf("discovery document is missing endpoints")
+	}
+	return &endpoints, nil
+}
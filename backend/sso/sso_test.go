@@ -0,0 +1,68 @@
+package sso
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+)
+
+var errForbidden = errors.New("forbidden")
+
+// refuseRaised refuses users given a role or a storage quota
+func refuseRaised(c echo.Context, user *models.Record) error {
+	if user.GetString("role") != "" || user.GetInt("storage_quota_mb") != 0 {
+		return errForbidden
+	}
+	return nil
+}
+
+func signupEvent(body string, record *models.Record) *core.RecordAuthWithOAuth2Event {
+	collection := &models.Collection{Name: "users", Type: models.CollectionTypeAuth}
+	collection.Schema = schema.NewSchema(
+		&schema.SchemaField{Name: "role", Type: schema.FieldTypeText},
+		&schema.SchemaField{Name: "storage_quota_mb", Type: schema.FieldTypeNumber},
+		&schema.SchemaField{Name: "name", Type: schema.FieldTypeText},
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/collections/users/auth-with-oauth2", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+
+	e := &core.RecordAuthWithOAuth2Event{HttpContext: echo.New().NewContext(req, httptest.NewRecorder()), Record: record}
+	e.Collection = collection
+	return e
+}
+
+func TestGuardSignupChecksCreateData(t *testing.T) {
+	cases := []struct {
+		name string
+		body string
+		want error
+	}{
+		{"role", `{"provider":"oidc","createData":{"role":"admin"}}`, errForbidden},
+		{"quota", `{"provider":"oidc","createData":{"storage_quota_mb":-1}}`, errForbidden},
+		{"other fields", `{"provider":"oidc","createData":{"name":"Alice"}}`, nil},
+		{"no createData", `{"provider":"oidc"}`, nil},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := guardSignup(refuseRaised)(signupEvent(tc.body, nil)); !errors.Is(err, tc.want) {
+				t.Errorf("got %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestGuardSignupIgnoresExistingUsers(t *testing.T) {
+	e := signupEvent(`{"provider":"oidc","createData":{"role":"admin"}}`, &models.Record{})
+	if err := guardSignup(refuseRaised)(e); err != nil {
+		t.Errorf("got %v for an existing user, createData is only used for new ones", err)
+	}
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+)
+
+// How often a followed file is checked for new data, and how long it is
+// still read after its writer is done, to pick up the last flushed bytes
+const (
+	followPoll  = 500 * time.Millisecond
+	followGrace = 2 * time.Second
+)
+
+// followReader is a FileReader for a file that is still being written
+type followReader struct {
+	path string
+	done <-chan struct{}
+}
+
+// Follow returns a FileReader streaming the file at path while it grows:
+// reads at the end of the file wait for more data until done is closed.
+// Put can then upload a recording as ffmpeg writes it.
+func Follow(path string, done <-chan struct{}) filesystem.FileReader {
+	return &followReader{path: path, done: done}
+}
+
+func (f *followReader) Open() (io.ReadSeekCloser, error) {
+	// ffmpeg may not have created the file yet
+	var file *os.File
+	for {
+		var err error
+		file, err = os.Open(f.path)
+		if err == nil {
+			break
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		select {
+		case <-f.done:
+			return nil, err
+		case <-time.After(followPoll):
+		}
+	}
+
+	return &followFile{file: file, done: f.done}, nil
+}
+
+// followFile blocks at EOF until its writer is done. The file isn't
+// embedded: io.Copy would find its fast paths and bypass Read.
+type followFile struct {
+	file     *os.File
+	done     <-chan struct{}
+	deadline time.Time // Set once done is closed
+}
+
+func (f *followFile) Read(p []byte) (int, error) {
+	for {
+		n, err := f.file.Read(p)
+		if n > 0 || err != io.EOF {
+			return n, err
+		}
+
+		if f.deadline.IsZero() {
+			select {
+			case <-f.done:
+				f.deadline = time.Now().Add(followGrace)
+			case <-time.After(followPoll):
+				continue
+			}
+		}
+		if time.Now().After(f.deadline) {
+			return 0, io.EOF
+		}
+		time.Sleep(followPoll)
+	}
+}
+
+func (f *followFile) Seek(offset int64, whence int) (int64, error) {
+	return f.file.Seek(offset, whence)
+}
+
+func (f *followFile) Close() error {
+	return f.file.Close()
+}
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+)
+
+// Local stores files in a directory, typically a mounted network share
+type Local struct {
+	dir string
+}
+
+// NewLocal returns a backend storing files under dir
+func NewLocal(dir string) (*Local, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Local{dir: dir}, nil
+}
+
+func (l *Local) Name() string {
+	return BackendLocal
+}
+
+// path maps a key into the directory, refusing keys escaping it
+func (l *Local) path(key string) (string, error) {
+	path := filepath.Join(l.dir, filepath.FromSlash(key))
+	if !strings.HasPrefix(path, filepath.Clean(l.dir)+string(filepath.Separator)) {
+		return "", ErrNotExist
+	}
+	return path, nil
+}
+
+func (l *Local) Put(key string, src filesystem.FileReader) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	in, err := src.Open()
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	// Write then rename so readers never see a partial file
+	out, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(path + ".tmp")
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (l *Local) Open(key string) (io.ReadCloser, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotExist
+	}
+	return f, err
+}
+
+func (l *Local) Stat(key string) (Object, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return Object{}, err
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return Object{}, ErrNotExist
+	}
+	if err != nil {
+		return Object{}, err
+	}
+	return Object{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (l *Local) List(prefix string) ([]Object, error) {
+	objects := []Object{}
+	err := filepath.Walk(l.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || strings.HasSuffix(path, ".tmp") {
+			return nil
+		}
+		rel, err := filepath.Rel(l.dir, path)
+		if err != nil {
+			return nil
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, Object{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+		}
+		return nil
+	})
+	return objects, err
+}
+
+func (l *Local) Delete(key string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (l *Local) Serve(res http.ResponseWriter, req *http.Request, key, name string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return ErrNotExist
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	http.ServeContent(res, req, name, info.ModTime(), f)
+	return nil
+}
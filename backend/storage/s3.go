@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+	"gocloud.dev/gcerrors"
+)
+
+// S3 stores files in an S3 compatible bucket (AWS, MinIO, ...), through
+// the same client PocketBase uses for its own file storage
+type S3 struct {
+	fs *filesystem.System
+}
+
+// NewS3 returns a backend storing files in bucket
+func NewS3(bucket, region, endpoint, accessKey, secret string, forcePathStyle bool) (*S3, error) {
+	fs, err := filesystem.NewS3(bucket, region, endpoint, accessKey, secret, forcePathStyle)
+	if err != nil {
+		return nil, err
+	}
+	return &S3{fs: fs}, nil
+}
+
+func (s *S3) Name() string {
+	return BackendS3
+}
+
+// Put streams src as a multipart upload, so files of any size are never
+// held in memory
+func (s *S3) Put(key string, src filesystem.FileReader) error {
+	return s.fs.UploadFile(&filesystem.File{Reader: src, Name: key, OriginalName: key}, key)
+}
+
+func (s *S3) Open(key string) (io.ReadCloser, error) {
+	r, err := s.fs.GetFile(key)
+	return r, notExist(err)
+}
+
+func (s *S3) Stat(key string) (Object, error) {
+	attrs, err := s.fs.Attributes(key)
+	if err != nil {
+		return Object{}, notExist(err)
+	}
+	return Object{Key: key, Size: attrs.Size, ModTime: attrs.ModTime}, nil
+}
+
+func (s *S3) List(prefix string) ([]Object, error) {
+	list, err := s.fs.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]Object, 0, len(list))
+	for _, obj := range list {
+		if !obj.IsDir {
+			objects = append(objects, Object{Key: obj.Key, Size: obj.Size, ModTime: obj.ModTime})
+		}
+	}
+	return objects, nil
+}
+
+func (s *S3) Delete(key string) error {
+	if err := notExist(s.fs.Delete(key)); err != nil && !errors.Is(err, ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *S3) Serve(res http.ResponseWriter, req *http.Request, key, name string) error {
+	// Recordings play in the browser rather than download
+	if res.Header().Get("Content-Disposition") == "" {
+		res.Header().Set("Content-Disposition", "inline; filename="+name)
+	}
+	return notExist(s.fs.Serve(res, req, key, name))
+}
+
+// notExist maps the bucket's not found errors to ErrNotExist
+func notExist(err error) error {
+	if err != nil && gcerrors.Code(err) == gcerrors.NotFound {
+		return ErrNotExist
+	}
+	return err
+}
@@ -0,0 +1,102 @@
+// Package storage keeps large files (recordings, their subtitles and
+// subtitle exports) on a backend other than the PocketBase data volume:
+// another local directory such as a NAS mount, an S3 compatible bucket, or
+// a WebDAV server.
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"time"
+
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+)
+
+// Backend names
+const (
+	BackendLocal  = "local"
+	BackendS3     = "s3"
+	BackendWebDAV = "webdav"
+)
+
+// ErrNotExist is returned for keys the backend doesn't have. It is
+// fs.ErrNotExist, so os.IsNotExist recognizes it.
+var ErrNotExist = fs.ErrNotExist
+
+// Object describes a stored file
+type Object struct {
+	Key     string    `json:"key"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// Backend stores files by key. Keys are slash separated relative paths.
+type Backend interface {
+	// Name is the backend name, for logs
+	Name() string
+
+	// Put stores the content of src under key. src is read as a stream, so
+	// it may still be growing, see Follow.
+	Put(key string, src filesystem.FileReader) error
+
+	// Open returns the content of key
+	Open(key string) (io.ReadCloser, error)
+
+	// Stat returns the size and modification time of key
+	Stat(key string) (Object, error)
+
+	// List returns the objects whose key starts with prefix
+	List(prefix string) ([]Object, error)
+
+	// Delete removes key. Missing keys are not an error.
+	Delete(key string) error
+
+	// Serve writes key to res, honoring range and conditional requests
+	Serve(res http.ResponseWriter, req *http.Request, key, name string) error
+}
+
+// Config selects and configures a backend
+type Config struct {
+	Backend string // BackendLocal, BackendS3 or BackendWebDAV; empty for none
+
+	LocalDir string
+
+	S3Bucket         string
+	S3Region         string
+	S3Endpoint       string
+	S3AccessKey      string
+	S3Secret         string
+	S3ForcePathStyle bool
+
+	WebDAVURL      string
+	WebDAVUser     string
+	WebDAVPassword string
+}
+
+// New opens the configured backend, nil when none is configured
+func New(config Config) (Backend, error) {
+	switch config.Backend {
+	case "":
+		return nil, nil
+	case BackendLocal:
+		if config.LocalDir == "" {
+			return nil, errors.New("local storage needs a directory")
+		}
+		return NewLocal(config.LocalDir)
+	case BackendS3:
+		if config.S3Bucket == "" || config.S3Endpoint == "" {
+			return nil, errors.New("s3 storage needs a bucket and an endpoint")
+		}
+		return NewS3(config.S3Bucket, config.S3Region, config.S3Endpoint, config.S3AccessKey, config.S3Secret, config.S3ForcePathStyle)
+	case BackendWebDAV:
+		if config.WebDAVURL == "" {
+			return nil, errors.New("webdav storage needs a URL")
+		}
+		return NewWebDAV(config.WebDAVURL, config.WebDAVUser, config.WebDAVPassword)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", config.Backend)
+	}
+}
@@ -0,0 +1,246 @@
+package storage
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+)
+
+// WebDAV stores files on a WebDAV server (Nextcloud, a NAS, ...)
+type WebDAV struct {
+	base     *url.URL
+	user     string
+	password string
+	client   *http.Client
+}
+
+// NewWebDAV returns a backend storing files under the collection at rawURL
+func NewWebDAV(rawURL, user, password string) (*WebDAV, error) {
+	base, err := url.Parse(strings.TrimSuffix(rawURL, "/") + "/")
+	if err != nil {
+		return nil, err
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("invalid webdav URL %q", rawURL)
+	}
+
+	// No overall timeout: uploads of long recordings take as long as they take
+	return &WebDAV{base: base, user: user, password: password, client: &http.Client{}}, nil
+}
+
+func (w *WebDAV) Name() string {
+	return BackendWebDAV
+}
+
+// url returns the URL of a key, escaping each path segment
+func (w *WebDAV) url(key string) string {
+	segments := strings.Split(strings.Trim(key, "/"), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return w.base.String() + strings.Join(segments, "/")
+}
+
+func (w *WebDAV) do(method, target string, body io.Reader, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if w.user != "" {
+		req.SetBasicAuth(w.user, w.password)
+	}
+	return w.client.Do(req)
+}
+
+// mkdirs creates the collections above key, WebDAV doesn't create them on PUT
+func (w *WebDAV) mkdirs(key string) {
+	dir := path.Dir(strings.Trim(key, "/"))
+	if dir == "." {
+		return
+	}
+	parts := strings.Split(dir, "/")
+	for i := range parts {
+		res, err := w.do("MKCOL", w.url(strings.Join(parts[:i+1], "/"))+"/", nil, nil)
+		if err == nil {
+			res.Body.Close()
+		}
+	}
+}
+
+func (w *WebDAV) Put(key string, src filesystem.FileReader) error {
+	w.mkdirs(key)
+
+	in, err := src.Open()
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	// Without a length the body is sent chunked, as it is read
+	res, err := w.do(http.MethodPut, w.url(key), io.NopCloser(in), nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("webdav upload failed with status %d", res.StatusCode)
+	}
+	return nil
+}
+
+func (w *WebDAV) Open(key string) (io.ReadCloser, error) {
+	res, err := w.do(http.MethodGet, w.url(key), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return nil, ErrNotExist
+	}
+	if res.StatusCode >= 300 {
+		res.Body.Close()
+		return nil, fmt.Errorf("webdav download failed with status %d", res.StatusCode)
+	}
+	return res.Body, nil
+}
+
+func (w *WebDAV) Stat(key string) (Object, error) {
+	res, err := w.do(http.MethodHead, w.url(key), nil, nil)
+	if err != nil {
+		return Object{}, err
+	}
+	res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return Object{}, ErrNotExist
+	}
+	if res.StatusCode >= 300 {
+		return Object{}, fmt.Errorf("webdav stat failed with status %d", res.StatusCode)
+	}
+
+	modTime, _ := http.ParseTime(res.Header.Get("Last-Modified"))
+	return Object{Key: key, Size: res.ContentLength, ModTime: modTime}, nil
+}
+
+// propfindResponse is the part of a PROPFIND multistatus response List reads
+type propfindResponse struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Propstat []struct {
+			Prop struct {
+				Length       string    `xml:"getcontentlength"`
+				LastModified string    `xml:"getlastmodified"`
+				Collection   *struct{} `xml:"resourcetype>collection"`
+			} `xml:"prop"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+// List lists the collection of prefix, up to its last slash, one level deep
+func (w *WebDAV) List(prefix string) ([]Object, error) {
+	dir := ""
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir = prefix[:i+1]
+	}
+
+	body := `<?xml version="1.0"?><propfind xmlns="DAV:"><prop><getcontentlength/><getlastmodified/><resourcetype/></prop></propfind>`
+	res, err := w.do("PROPFIND", w.url(dir)+"/", strings.NewReader(body), http.Header{
+		"Depth":        {"1"},
+		"Content-Type": {"application/xml"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return []Object{}, nil
+	}
+	if res.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("webdav listing failed with status %d", res.StatusCode)
+	}
+
+	var parsed propfindResponse
+	if err := xml.NewDecoder(res.Body).Decode(&parsed); err != nil {
+		return nil, err
+	}
+
+	objects := []Object{}
+	for _, response := range parsed.Responses {
+		href, err := url.PathUnescape(response.Href)
+		if err != nil {
+			continue
+		}
+		name := path.Base(strings.TrimSuffix(href, "/"))
+		key := dir + name
+		if strings.HasSuffix(href, "/") || !strings.HasPrefix(key, prefix) || len(response.Propstat) == 0 {
+			continue
+		}
+
+		prop := response.Propstat[0].Prop
+		if prop.Collection != nil {
+			continue
+		}
+		size, _ := strconv.ParseInt(prop.Length, 10, 64)
+		modTime, _ := time.Parse(http.TimeFormat, prop.LastModified)
+		objects = append(objects, Object{Key: key, Size: size, ModTime: modTime})
+	}
+
+	return objects, nil
+}
+
+func (w *WebDAV) Delete(key string) error {
+	res, err := w.do(http.MethodDelete, w.url(key), nil, nil)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode >= 300 && res.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("webdav delete failed with status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// Serve proxies the file, passing range and conditional headers through so
+// players can seek
+func (w *WebDAV) Serve(res http.ResponseWriter, req *http.Request, key, name string) error {
+	header := http.Header{}
+	for _, h := range []string{"Range", "If-Range", "If-None-Match", "If-Modified-Since"} {
+		if value := req.Header.Get(h); value != "" {
+			header.Set(h, value)
+		}
+	}
+
+	upstream, err := w.do(http.MethodGet, w.url(key), nil, header)
+	if err != nil {
+		return err
+	}
+	defer upstream.Body.Close()
+
+	if upstream.StatusCode == http.StatusNotFound {
+		return ErrNotExist
+	}
+
+	for _, h := range []string{"Content-Type", "Content-Length", "Content-Range", "Accept-Ranges", "Last-Modified", "ETag"} {
+		if value := upstream.Header.Get(h); value != "" {
+			res.Header().Set(h, value)
+		}
+	}
+	res.Header().Set("Content-Disposition", "inline; filename="+name)
+	res.WriteHeader(upstream.StatusCode)
+	_, err = io.Copy(res, upstream.Body)
+	return err
+}
@@ -0,0 +1,90 @@
+package stream
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/pocketbase/pocketbase/models"
+)
+
+// DeviceProfile is what a client device can play natively
+type DeviceProfile struct {
+	ID          string   `json:"id,omitempty"`
+	Name        string   `json:"name"`
+	VideoCodecs []string `json:"video_codecs"`
+	AudioCodecs []string `json:"audio_codecs"`
+	Containers  []string `json:"containers"`
+	MaxHeight   int      `json:"max_height"` // 0 for no limit
+	HDR         bool     `json:"hdr"`
+}
+
+// DefaultDeviceProfile is assumed for clients that didn't register a
+// device: a browser playing through hls.js
+func DefaultDeviceProfile() DeviceProfile {
+	return DeviceProfile{
+		Name:        "default",
+		VideoCodecs: []string{"h264"},
+		AudioCodecs: []string{"aac", "mp3"},
+		Containers:  []string{"hls", "mp4"},
+		MaxHeight:   1080,
+	}
+}
+
+// DeviceProfileFromRecord reads a profile from a devices record. Codec and
+// container lists left empty keep the defaults.
+func DeviceProfileFromRecord(record *models.Record) DeviceProfile {
+	profile := DefaultDeviceProfile()
+	profile.ID = record.Id
+	profile.Name = record.GetString("name")
+	profile.MaxHeight = record.GetInt("max_height")
+	profile.HDR = record.GetBool("hdr")
+
+	if codecs := jsonList(record, "video_codecs"); len(codecs) > 0 {
+		profile.VideoCodecs = codecs
+	}
+	if codecs := jsonList(record, "audio_codecs"); len(codecs) > 0 {
+		profile.AudioCodecs = codecs
+	}
+	if containers := jsonList(record, "containers"); len(containers) > 0 {
+		profile.Containers = containers
+	}
+
+	return profile
+}
+
+func jsonList(record *models.Record, field string) []string {
+	var values []string
+	json.Unmarshal([]byte(record.GetString(field)), &values)
+
+	for i, value := range values {
+		values[i] = normalizeCodec(value)
+	}
+	return values
+}
+
+// normalizeCodec maps common aliases to ffprobe codec names
+func normalizeCodec(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	switch name {
+	case "avc", "avc1", "h.264":
+		return "h264"
+	case "h265", "h.265", "hev1", "hvc1":
+		return "hevc"
+	case "ts", "m2ts":
+		return "mpegts"
+	case "ec-3":
+		return "eac3"
+	case "ac-3":
+		return "ac3"
+	}
+	return name
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package stream
+
+import (
+	"sync"
+	"time"
+)
+
+// downAfterFailures is how many consecutive failed upstream requests mark a
+// channel as down; players retry on their own so single errors are noise
+const downAfterFailures = 3
+
+// ChannelStatusHandler is called when a channel goes down or comes back up
+type ChannelStatusHandler func(channelID string, up bool, reason string)
+
+// channelHealth tracks the upstream state of channels seen by the proxy
+type channelHealth struct {
+	mu       sync.Mutex
+	failures map[string]int
+	down     map[string]time.Time
+	handlers []ChannelStatusHandler
+}
+
+// OnChannelStatus registers a handler for channel up/down transitions
+func (s *Service) OnChannelStatus(handler ChannelStatusHandler) {
+	s.health.mu.Lock()
+	defer s.health.mu.Unlock()
+	s.health.handlers = append(s.health.handlers, handler)
+}
+
+// ReportHealth records the outcome of a request to a channel's source.
+// Besides the proxy, anything probing streams (thumbnails) reports here.
+func (s *Service) ReportHealth(channelID string, ok bool, reason string) {
+	if channelID == "" {
+		return
+	}
+
+	h := &s.health
+	h.mu.Lock()
+
+	if h.failures == nil {
+		h.failures = make(map[string]int)
+		h.down = make(map[string]time.Time)
+	}
+
+	_, wasDown := h.down[channelID]
+	var notify bool
+
+	if ok {
+		delete(h.failures, channelID)
+		if wasDown {
+			delete(h.down, channelID)
+			notify = true
+		}
+	} else {
+		h.failures[channelID]++
+		if !wasDown && h.failures[channelID] >= downAfterFailures {
+			h.down[channelID] = time.Now()
+			notify = true
+		}
+	}
+
+	handlers := h.handlers
+	h.mu.Unlock()
+
+	if !notify {
+		return
+	}
+
+	if ok {
+		s.logger.Info("channel back up", "channel_id", channelID)
+	} else {
+		s.logger.Warn("channel down", "channel_id", channelID, "reason", reason)
+	}
+
+	for _, handler := range handlers {
+		go handler(channelID, ok, reason)
+	}
+}
+
+// IsDown reports whether a channel is currently considered down and since when
+func (s *Service) IsDown(channelID string) (time.Time, bool) {
+	s.health.mu.Lock()
+	defer s.health.mu.Unlock()
+	since, down := s.health.down[channelID]
+	return since, down
+}
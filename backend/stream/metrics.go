@@ -0,0 +1,151 @@
+package stream
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxZapSamples bounds the zap times kept per category
+const maxZapSamples = 500
+
+// zapTimeout drops zaps whose first segment never arrives
+const zapTimeout = 30 * time.Second
+
+// playingWindow is how long after its last media response a channel counts
+// as playing; entry requests then are live manifest reloads, not zaps
+const playingWindow = 15 * time.Second
+
+// zapStart is a channel start waiting for its first media bytes
+type zapStart struct {
+	at   time.Time
+	warm bool
+}
+
+// zapMetrics measures channel start times through the proxy: from the
+// request for a channel's entry URL to the first media response. Starts
+// whose manifest came from the prefetch cache are counted as warm.
+type zapMetrics struct {
+	mu        sync.Mutex
+	pending   map[string]zapStart
+	lastMedia map[string]time.Time
+	warm      []float64 // Milliseconds, oldest first
+	cold      []float64
+}
+
+// beginZap records that a channel is being started
+func (s *Service) beginZap(channelID string, warm bool) {
+	m := &s.zaps
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.pending == nil {
+		m.pending = make(map[string]zapStart)
+		m.lastMedia = make(map[string]time.Time)
+	}
+	if last, ok := m.lastMedia[channelID]; ok && time.Since(last) < playingWindow {
+		return
+	}
+	// Concurrent viewers of a channel share the entry; the first start wins
+	if start, ok := m.pending[channelID]; ok && time.Since(start.at) < zapTimeout {
+		return
+	}
+	m.pending[channelID] = zapStart{at: time.Now(), warm: warm}
+}
+
+// endZap records the first media response of a pending channel start
+func (s *Service) endZap(channelID string) {
+	m := &s.zaps
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.lastMedia != nil {
+		m.lastMedia[channelID] = time.Now()
+	}
+
+	start, ok := m.pending[channelID]
+	if !ok {
+		return
+	}
+	delete(m.pending, channelID)
+
+	elapsed := time.Since(start.at)
+	if elapsed > zapTimeout {
+		return
+	}
+
+	ms := float64(elapsed.Microseconds()) / 1000
+	if start.warm {
+		m.warm = appendSample(m.warm, ms)
+	} else {
+		m.cold = appendSample(m.cold, ms)
+	}
+}
+
+func appendSample(samples []float64, value float64) []float64 {
+	samples = append(samples, value)
+	if len(samples) > maxZapSamples {
+		samples = samples[len(samples)-maxZapSamples:]
+	}
+	return samples
+}
+
+// ZapStats summarizes measured zap times in milliseconds
+type ZapStats struct {
+	Count int     `json:"count"`
+	AvgMs float64 `json:"avg_ms"`
+	P50Ms float64 `json:"p50_ms"`
+	P95Ms float64 `json:"p95_ms"`
+}
+
+func summarize(samples []float64) ZapStats {
+	if len(samples) == 0 {
+		return ZapStats{}
+	}
+
+	sorted := append([]float64(nil), samples...)
+	sort.Float64s(sorted)
+
+	sum := 0.0
+	for _, v := range sorted {
+		sum += v
+	}
+
+	percentile := func(p float64) float64 {
+		index := int(math.Ceil(p*float64(len(sorted)))) - 1
+		if index < 0 {
+			index = 0
+		}
+		return round(sorted[index])
+	}
+
+	return ZapStats{
+		Count: len(sorted),
+		AvgMs: round(sum / float64(len(sorted))),
+		P50Ms: percentile(0.50),
+		P95Ms: percentile(0.95),
+	}
+}
+
+func round(v float64) float64 {
+	return math.Round(v*10) / 10
+}
+
+// Metrics reports prefetch state, zap times with and without prefetch and
+// WebRTC output
+func (s *Service) Metrics() map[string]interface{} {
+	s.zaps.mu.Lock()
+	warm := summarize(s.zaps.warm)
+	cold := summarize(s.zaps.cold)
+	s.zaps.mu.Unlock()
+
+	return map[string]interface{}{
+		"prefetch": s.prefetchStatus(),
+		"zap_time": map[string]ZapStats{
+			"warm": warm,
+			"cold": cold,
+		},
+		"whep": s.whepStatus(),
+	}
+}
@@ -0,0 +1,185 @@
+package stream
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/subscriptions"
+
+	"iptv-backend/access"
+)
+
+// Viewer identifies who an API response is built for
+type Viewer struct {
+	UserID  string
+	IsAdmin bool
+}
+
+// getter is implemented by both echo contexts and realtime clients
+type getter interface {
+	Get(key string) any
+}
+
+// viewerFrom reads the authenticated admin or user from a request or realtime client
+func viewerFrom(g getter) Viewer {
+	if admin, _ := g.Get(apis.ContextAdminKey).(*models.Admin); admin != nil {
+		return Viewer{IsAdmin: true}
+	}
+	if record, _ := g.Get(apis.ContextAuthRecordKey).(*models.Record); record != nil {
+		return Viewer{UserID: record.Id, IsAdmin: access.IsAdmin(g)}
+	}
+	return Viewer{}
+}
+
+// ViewerFromContext returns the viewer of an API request
+func ViewerFromContext(c echo.Context) Viewer {
+	return viewerFrom(c)
+}
+
+// PlaylistOwner returns the owner of a playlist, which is the first user of
+// its user relation (the account that added it). Other users are viewers.
+func PlaylistOwner(playlist *models.Record) string {
+	users := playlist.GetStringSlice("user")
+	if len(users) == 0 {
+		return ""
+	}
+	return users[0]
+}
+
+// CanViewSource reports whether viewer may see the raw source URLs of a playlist
+func CanViewSource(viewer Viewer, playlist *models.Record) bool {
+	if viewer.IsAdmin {
+		return true
+	}
+	return viewer.UserID != "" && PlaylistOwner(playlist) == viewer.UserID
+}
+
+// policyCache memoizes per-playlist decisions while building one response
+type policyCache map[string]bool
+
+func (s *Service) canViewPlaylists(viewer Viewer, playlistIDs []string, cache policyCache) bool {
+	if viewer.IsAdmin {
+		return true
+	}
+
+	for _, id := range playlistIDs {
+		allowed, ok := cache[id]
+		if !ok {
+			playlist, err := s.app.Dao().FindRecordById("playlists", id)
+			allowed = err == nil && CanViewSource(viewer, playlist)
+			cache[id] = allowed
+		}
+		if allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CanViewChannelSource reports whether the request's viewer may see the raw URL of a channel
+func (s *Service) CanViewChannelSource(c echo.Context, channel *models.Record) bool {
+	return s.canViewPlaylists(ViewerFromContext(c), channel.GetStringSlice("playlist"), policyCache{})
+}
+
+// ApplyChannelPolicy replaces the url of channel records the viewer doesn't own
+// with a playback URL. Expanded relations (favorites, history...) are included.
+func (s *Service) ApplyChannelPolicy(c echo.Context, records ...*models.Record) {
+	s.applyChannelPolicy(ViewerFromContext(c), s.BaseURL(c), records, policyCache{}, 0)
+}
+
+func (s *Service) applyChannelPolicy(viewer Viewer, baseURL string, records []*models.Record, cache policyCache, depth int) {
+	// PocketBase limits expand depth to 6
+	if depth > 6 {
+		return
+	}
+
+	for _, record := range records {
+		if record == nil {
+			continue
+		}
+
+		if record.Collection().Name == "channels" && record.GetString("url") != "" {
+			if !s.canViewPlaylists(viewer, record.GetStringSlice("playlist"), cache) {
+				record.Set("url", s.PlaybackURL(baseURL, record.Id))
+			}
+		}
+
+		for _, expanded := range record.Expand() {
+			switch v := expanded.(type) {
+			case *models.Record:
+				s.applyChannelPolicy(viewer, baseURL, []*models.Record{v}, cache, depth+1)
+			case []*models.Record:
+				s.applyChannelPolicy(viewer, baseURL, v, cache, depth+1)
+			}
+		}
+	}
+}
+
+// ApplyRealtimePolicy rewrites channel URLs in a realtime record message
+func (s *Service) ApplyRealtimePolicy(client subscriptions.Client, message *subscriptions.Message) {
+	if !strings.HasPrefix(message.Name, "channels") {
+		return
+	}
+
+	var data map[string]any
+	if err := json.Unmarshal(message.Data, &data); err != nil {
+		return
+	}
+
+	record, ok := data["record"].(map[string]any)
+	if !ok || record["collectionName"] != "channels" {
+		return
+	}
+
+	id, _ := record["id"].(string)
+	if sourceURL, _ := record["url"].(string); id == "" || sourceURL == "" {
+		return
+	}
+
+	var playlistIDs []string
+	switch v := record["playlist"].(type) {
+	case string:
+		playlistIDs = []string{v}
+	case []any:
+		for _, item := range v {
+			if playlistID, ok := item.(string); ok {
+				playlistIDs = append(playlistIDs, playlistID)
+			}
+		}
+	}
+
+	if s.canViewPlaylists(viewerFrom(client), playlistIDs, policyCache{}) {
+		return
+	}
+
+	// No request here, so the URL is root-relative unless PUBLIC_URL is set
+	record["url"] = s.PlaybackURL(s.publicURL, id)
+
+	if encoded, err := json.Marshal(data); err == nil {
+		message.Data = encoded
+	}
+}
+
+// ProtectSourceURL prevents viewers from changing the source URL of a channel.
+// A submitted playback URL (what viewers are shown) is mapped back to the
+// stored source so round-tripping a record doesn't overwrite it.
+func (s *Service) ProtectSourceURL(c echo.Context, channel *models.Record) error {
+	original := channel.OriginalCopy()
+	submitted := channel.GetString("url")
+	stored := original.GetString("url")
+
+	if submitted == stored || s.CanViewChannelSource(c, original) {
+		return nil
+	}
+
+	if id, ok := s.ParsePlaybackURL(submitted); ok && id == channel.Id {
+		channel.Set("url", stored)
+		return nil
+	}
+
+	return apis.NewForbiddenError("Only the playlist owner can change stream URLs", nil)
+}
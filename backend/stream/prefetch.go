@@ -0,0 +1,387 @@
+package stream
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/tools/types"
+
+	"iptv-backend/upstream"
+)
+
+// PrefetchConfig configures the hot standby of favorite channels
+type PrefetchConfig struct {
+	TopFavorites int           // Favorites kept warm per active profile (0 disables)
+	Interval     time.Duration // How often warm channels are polled
+	ManifestTTL  time.Duration // How long a prefetched manifest may be served
+	ActiveWindow time.Duration // Profiles that watched something this recently are active
+}
+
+// DefaultPrefetchConfig returns default prefetch settings (disabled)
+func DefaultPrefetchConfig() PrefetchConfig {
+	return PrefetchConfig{
+		TopFavorites: 0,
+		Interval:     5 * time.Second,
+		ManifestTTL:  6 * time.Second,
+		ActiveWindow: 6 * time.Hour,
+	}
+}
+
+// cachedManifest is an HLS manifest fetched ahead of a request
+type cachedManifest struct {
+	body      []byte
+	finalURL  *url.URL // After redirects, base for relative URIs
+	fetchedAt time.Time
+}
+
+// prefetcher keeps upstream connections and manifests of favorite channels warm
+type prefetcher struct {
+	mu         sync.Mutex
+	config     PrefetchConfig
+	stop       context.CancelFunc
+	channels   []string
+	manifests  map[string]*cachedManifest // By upstream URL
+	isPlaylist map[string]bool            // By channel URL, learned from HEAD responses
+}
+
+// StartPrefetch starts keeping the top favorites of recently active profiles
+// warm, so zapping to them skips the upstream round trips
+func (s *Service) StartPrefetch(config PrefetchConfig) {
+	if config.TopFavorites <= 0 {
+		return
+	}
+	if config.Interval <= 0 {
+		config.Interval = 5 * time.Second
+	}
+	if config.ManifestTTL <= 0 {
+		config.ManifestTTL = config.Interval + time.Second
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	p := &s.prefetch
+	p.mu.Lock()
+	if p.stop != nil {
+		p.stop()
+	}
+	p.config = config
+	p.stop = cancel
+	p.manifests = make(map[string]*cachedManifest)
+	p.isPlaylist = make(map[string]bool)
+	p.mu.Unlock()
+
+	s.logger.Info("channel prefetch enabled", "top_favorites", config.TopFavorites, "interval", config.Interval.String())
+
+	go s.prefetchLoop(ctx, config)
+}
+
+// StopPrefetch stops the hot standby and drops cached manifests
+func (s *Service) StopPrefetch() {
+	p := &s.prefetch
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stop != nil {
+		p.stop()
+		p.stop = nil
+	}
+	p.channels = nil
+	p.manifests = nil
+}
+
+func (s *Service) prefetchLoop(ctx context.Context, config PrefetchConfig) {
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+
+	var targets map[string]string // channel id -> source url
+	var refreshedAt time.Time
+
+	for {
+		// The favorite set changes slowly, poll it less often than manifests
+		if time.Since(refreshedAt) > time.Minute {
+			targets = s.prefetchTargets(config)
+			refreshedAt = time.Now()
+
+			ids := make([]string, 0, len(targets))
+			for id := range targets {
+				ids = append(ids, id)
+			}
+			sort.Strings(ids)
+
+			s.prefetch.mu.Lock()
+			s.prefetch.channels = ids
+			s.prefetch.mu.Unlock()
+		}
+
+		var wg sync.WaitGroup
+		for _, sourceURL := range targets {
+			wg.Add(1)
+			go func(sourceURL string) {
+				defer wg.Done()
+				s.warm(ctx, sourceURL)
+			}(sourceURL)
+		}
+		wg.Wait()
+
+		s.expireManifests(config.ManifestTTL * 2)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// prefetchTargets returns the top favorites of every recently active profile
+func (s *Service) prefetchTargets(config PrefetchConfig) map[string]string {
+	return s.FavoriteTargets(config.TopFavorites, config.ActiveWindow)
+}
+
+// FavoriteTargets returns the source URLs of the top favorites of every
+// profile that watched something within window, by channel id
+func (s *Service) FavoriteTargets(top int, window time.Duration) map[string]string {
+	targets := make(map[string]string)
+
+	cutoff, _ := types.ParseDateTime(time.Now().Add(-window))
+	history, err := s.app.Dao().FindRecordsByFilter(
+		"watch_history",
+		"watched_at >= {:cutoff}",
+		"-watched_at",
+		500,
+		0,
+		dbx.Params{"cutoff": cutoff.String()},
+	)
+	if err != nil {
+		return targets
+	}
+
+	profiles := make(map[string]bool)
+	for _, record := range history {
+		for _, profileID := range record.GetStringSlice("profile") {
+			profiles[profileID] = true
+		}
+	}
+
+	for profileID := range profiles {
+		favorites, err := s.app.Dao().FindRecordsByFilter(
+			"favorites",
+			"profile ~ {:profile}",
+			"sort_order",
+			top,
+			0,
+			dbx.Params{"profile": profileID},
+		)
+		if err != nil {
+			continue
+		}
+
+		for _, favorite := range favorites {
+			for _, channelID := range favorite.GetStringSlice("channel") {
+				if _, ok := targets[channelID]; ok {
+					continue
+				}
+				channel, err := s.app.Dao().FindRecordById("channels", channelID)
+				if err != nil {
+					continue
+				}
+				if sourceURL := s.Source(channel).GetString("url"); sourceURL != "" {
+					targets[channelID] = sourceURL
+				}
+			}
+		}
+	}
+
+	return targets
+}
+
+// warm refreshes one channel. HLS manifests (and the first variant of a
+// master playlist) are fetched and cached; other streams only get a HEAD
+// request so DNS, TCP and TLS stay warm without downloading media.
+func (s *Service) warm(ctx context.Context, sourceURL string) {
+	headers := s.urlHeaders(sourceURL)
+
+	s.prefetch.mu.Lock()
+	playlist, known := s.prefetch.isPlaylist[sourceURL]
+	s.prefetch.mu.Unlock()
+
+	switch {
+	case !known:
+		playlist = looksLikePlaylistURL(sourceURL) || s.headIsPlaylist(ctx, sourceURL, headers)
+		s.prefetch.mu.Lock()
+		if s.prefetch.isPlaylist != nil {
+			s.prefetch.isPlaylist[sourceURL] = playlist
+		}
+		s.prefetch.mu.Unlock()
+		if !playlist {
+			return
+		}
+	case !playlist:
+		s.headIsPlaylist(ctx, sourceURL, headers)
+		return
+	}
+
+	manifest := s.fetchManifest(ctx, sourceURL, headers)
+	if manifest == nil {
+		return
+	}
+
+	// Players fetch a variant right after the master playlist
+	if variant := firstVariant(manifest); variant != "" {
+		s.fetchManifest(ctx, variant, headers)
+	}
+}
+
+// headIsPlaylist sends a HEAD request and reports whether it's an HLS manifest
+func (s *Service) headIsPlaylist(ctx context.Context, target string, headers upstream.Headers) bool {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, target, nil)
+	if err != nil {
+		return false
+	}
+	headers.Apply(req)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode < 400 && isPlaylistResponse(resp)
+}
+
+// fetchManifest GETs and caches an HLS manifest
+func (s *Service) fetchManifest(ctx context.Context, target string, headers upstream.Headers) *cachedManifest {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil
+	}
+	headers.Apply(req)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 || !isPlaylistResponse(resp) {
+		return nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxPlaylistSize))
+	if err != nil {
+		return nil
+	}
+
+	manifest := &cachedManifest{
+		body:      body,
+		finalURL:  resp.Request.URL,
+		fetchedAt: time.Now(),
+	}
+
+	s.prefetch.mu.Lock()
+	if s.prefetch.manifests != nil {
+		s.prefetch.manifests[target] = manifest
+	}
+	s.prefetch.mu.Unlock()
+
+	return manifest
+}
+
+// freshManifest returns a prefetched manifest still fresh enough to serve
+func (s *Service) freshManifest(target string) *cachedManifest {
+	p := &s.prefetch
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	manifest, ok := p.manifests[target]
+	if !ok || time.Since(manifest.fetchedAt) > p.config.ManifestTTL {
+		return nil
+	}
+	return manifest
+}
+
+func (s *Service) expireManifests(maxAge time.Duration) {
+	p := &s.prefetch
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for target, manifest := range p.manifests {
+		if time.Since(manifest.fetchedAt) > maxAge {
+			delete(p.manifests, target)
+		}
+	}
+}
+
+// PrefetchStatus describes the hot standby state
+type PrefetchStatus struct {
+	Enabled   bool     `json:"enabled"`
+	Channels  []string `json:"channels"`
+	Manifests int      `json:"cached_manifests"`
+}
+
+func (s *Service) prefetchStatus() PrefetchStatus {
+	p := &s.prefetch
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	channels := p.channels
+	if channels == nil {
+		channels = []string{}
+	}
+
+	return PrefetchStatus{
+		Enabled:   p.stop != nil,
+		Channels:  channels,
+		Manifests: len(p.manifests),
+	}
+}
+
+// firstVariant returns the absolute URL of the first variant of a master
+// playlist, or "" for media playlists
+func firstVariant(manifest *cachedManifest) string {
+	if !bytes.Contains(manifest.body, []byte("#EXT-X-STREAM-INF")) {
+		return ""
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(manifest.body))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	afterStreamInf := false
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF"):
+			afterStreamInf = true
+		case line == "" || strings.HasPrefix(line, "#"):
+		case afterStreamInf:
+			resolved, err := manifest.finalURL.Parse(line)
+			if err != nil {
+				return ""
+			}
+			return resolved.String()
+		}
+	}
+
+	return ""
+}
+
+func looksLikePlaylistURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	path := strings.ToLower(u.Path)
+	return strings.HasSuffix(path, ".m3u8") || strings.HasSuffix(path, ".m3u")
+}
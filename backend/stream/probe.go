@@ -0,0 +1,172 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Probe results are cached per URL; failures are retried sooner
+const (
+	probeTTL        = 30 * time.Minute
+	probeFailureTTL = time.Minute
+	probeTimeout    = 15 * time.Second
+)
+
+// MediaInfo describes the container and main streams of a source
+type MediaInfo struct {
+	Container  string `json:"container"` // hls, mpegts, mp4, mkv, or the ffprobe format name
+	VideoCodec string `json:"video_codec,omitempty"`
+	AudioCodec string `json:"audio_codec,omitempty"`
+	Width      int    `json:"width,omitempty"`
+	Height     int    `json:"height,omitempty"`
+	HDR        bool   `json:"hdr"`
+}
+
+type probeEntry struct {
+	info     *MediaInfo
+	err      error
+	probedAt time.Time
+}
+
+// probeCache memoizes ffprobe results by source URL or file path
+type probeCache struct {
+	mu      sync.Mutex
+	entries map[string]probeEntry
+}
+
+// Probe returns the media info of a stream URL or file, running ffprobe
+// when no recent result is cached
+func (s *Service) Probe(ctx context.Context, input string) (*MediaInfo, error) {
+	return s.cachedProbe(ctx, input, func(ctx context.Context) (*MediaInfo, error) {
+		return probe(ctx, input, nil, s.inputArgs(input))
+	})
+}
+
+// probeReader returns the media info of the content open returns, such as
+// a file of a storage backend, cached under key. ffprobe reads it from
+// stdin, so the container must be recognizable without seeking.
+func (s *Service) probeReader(ctx context.Context, key string, open func() (io.ReadCloser, error)) (*MediaInfo, error) {
+	return s.cachedProbe(ctx, key, func(ctx context.Context) (*MediaInfo, error) {
+		r, err := open()
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return probe(ctx, "pipe:0", r, nil)
+	})
+}
+
+// cachedProbe returns the cached media info of key, or runs run when none
+// is recent
+func (s *Service) cachedProbe(ctx context.Context, key string, run func(ctx context.Context) (*MediaInfo, error)) (*MediaInfo, error) {
+	p := &s.probes
+	p.mu.Lock()
+	if entry, ok := p.entries[key]; ok {
+		ttl := probeTTL
+		if entry.err != nil {
+			ttl = probeFailureTTL
+		}
+		if time.Since(entry.probedAt) < ttl {
+			p.mu.Unlock()
+			return entry.info, entry.err
+		}
+	}
+	p.mu.Unlock()
+
+	info, err := run(ctx)
+	if ctx.Err() != nil {
+		// Cancelled by the caller, says nothing about the source
+		return nil, err
+	}
+
+	p.mu.Lock()
+	if p.entries == nil {
+		p.entries = make(map[string]probeEntry)
+	}
+	p.entries[key] = probeEntry{info: info, err: err, probedAt: time.Now()}
+	p.mu.Unlock()
+
+	return info, err
+}
+
+// probe runs ffprobe on input, fed with stdin when input is pipe:0
+func probe(ctx context.Context, input string, stdin io.Reader, inputArgs []string) (*MediaInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	args := append([]string{
+		"-v", "error",
+		"-print_format", "json",
+		"-show_format",
+		"-show_streams",
+	}, inputArgs...)
+	cmd := exec.CommandContext(ctx, "ffprobe", append(args, input)...)
+	cmd.Stdin = stdin
+	cmd.WaitDelay = time.Second // Don't wait on a stalled stdin once ffprobe exits
+	output, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("probe timed out")
+		}
+		return nil, fmt.Errorf("ffprobe failed: %w", err)
+	}
+
+	var result struct {
+		Format struct {
+			FormatName string `json:"format_name"`
+		} `json:"format"`
+		Streams []struct {
+			CodecType     string `json:"codec_type"`
+			CodecName     string `json:"codec_name"`
+			Width         int    `json:"width"`
+			Height        int    `json:"height"`
+			ColorTransfer string `json:"color_transfer"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+
+	info := &MediaInfo{Container: containerName(result.Format.FormatName)}
+	for _, stream := range result.Streams {
+		switch stream.CodecType {
+		case "video":
+			// HLS masters list every variant, judge by the largest one
+			if stream.Height > info.Height || info.VideoCodec == "" {
+				info.VideoCodec = stream.CodecName
+				info.Width = stream.Width
+				info.Height = stream.Height
+			}
+			if stream.ColorTransfer == "smpte2084" || stream.ColorTransfer == "arib-std-b67" {
+				info.HDR = true
+			}
+		case "audio":
+			if info.AudioCodec == "" {
+				info.AudioCodec = stream.CodecName
+			}
+		}
+	}
+
+	return info, nil
+}
+
+// containerName maps ffprobe format names to the names used in device profiles
+func containerName(formatName string) string {
+	switch {
+	case formatName == "hls" || formatName == "applehttp":
+		return "hls"
+	case formatName == "mpegts":
+		return "mpegts"
+	case strings.Contains(formatName, "mp4"):
+		return "mp4"
+	case strings.Contains(formatName, "matroska"):
+		return "mkv"
+	}
+	return formatName
+}
@@ -0,0 +1,211 @@
+package stream
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase/apis"
+)
+
+// maxPlaylistSize bounds HLS manifests read into memory for rewriting
+const maxPlaylistSize = 5 * 1024 * 1024
+
+var uriAttributePattern = regexp.MustCompile(`URI="([^"]*)"`)
+
+// forwardedResponseHeaders are copied from the upstream response
+var forwardedResponseHeaders = []string{
+	"Content-Type",
+	"Content-Length",
+	"Content-Range",
+	"Accept-Ranges",
+	"Cache-Control",
+	"Last-Modified",
+}
+
+// HandleStream proxies a channel stream for a signed playback URL, or a
+// long-lived token allowed to play the channel.
+// HLS manifests are rewritten so segments and variant playlists are also
+// fetched through the proxy; the source URL never reaches the client.
+func (s *Service) HandleStream(c echo.Context) error {
+	channelID := c.PathParam("channelId")
+	query := c.QueryParams()
+
+	exp := query.Get("exp")
+	sig := query.Get("sig")
+	if token := query.Get(TokenQueryParam); token != "" && sig == "" {
+		// The resources of the stream are signed like those of a playback
+		// URL, the long-lived token stays on the entry URL
+		if s.tokenCheck == nil || !s.tokenCheck(token, channelID) {
+			return apis.NewForbiddenError("Invalid or expired stream token", nil)
+		}
+		exp, sig = s.playbackToken(channelID)
+	} else if err := s.verifyToken(channelID, exp, sig); err != nil {
+		return apis.NewForbiddenError("Invalid or expired stream token", nil)
+	}
+
+	// Health is tracked per source, so a merged channel whose preferred
+	// source is down plays from the next one
+	sourceID := channelID
+
+	target := query.Get("url")
+	if target != "" {
+		// Nested resources carry their own signature so the proxy can't be
+		// used to fetch arbitrary URLs, nor with another source's headers
+		if src := query.Get("src"); src != "" {
+			sourceID = src
+		}
+		if !s.checkSignature(query.Get("usig"), "resource", channelID, sourceID, target) {
+			return apis.NewForbiddenError("Invalid stream resource signature", nil)
+		}
+	} else {
+		channel, err := s.app.Dao().FindRecordById("channels", channelID)
+		if err != nil {
+			return apis.NewNotFoundError("Channel not found", err)
+		}
+		source := s.Source(channel)
+		sourceID = source.Id
+		target = source.GetString("url")
+	}
+
+	// Viewers count against the connections their provider allows
+	defer s.beginView(sourceID, c.RealIP())()
+
+	// Only the channel's entry URL says whether the channel is up; a single
+	// missing segment doesn't
+	entry := query.Get("url") == ""
+	rangeHeader := c.Request().Header.Get("Range")
+
+	// Favorites kept warm by the prefetcher skip the upstream round trip
+	if rangeHeader == "" {
+		if manifest := s.freshManifest(target); manifest != nil {
+			if entry {
+				s.beginZap(channelID, true)
+			}
+			rewritten := s.rewritePlaylist(manifest.body, manifest.finalURL, channelID, sourceID, exp, sig)
+
+			c.Response().Header().Set("Cache-Control", "no-cache")
+			return c.Blob(http.StatusOK, "application/vnd.apple.mpegurl", rewritten)
+		}
+	}
+	if entry {
+		s.beginZap(channelID, false)
+	}
+
+	req, err := http.NewRequestWithContext(c.Request().Context(), http.MethodGet, target, nil)
+	if err != nil {
+		return apis.NewBadRequestError("Invalid stream URL", nil)
+	}
+	s.sourceHeaders(sourceID).Apply(req)
+	if rangeHeader != "" {
+		req.Header.Set("Range", rangeHeader)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		s.logger.Warn("upstream request failed", "channel_id", channelID, "error", err)
+		if entry && c.Request().Context().Err() == nil {
+			s.ReportHealth(sourceID, false, err.Error())
+		}
+		return apis.NewApiError(http.StatusBadGateway, "Failed to reach stream source", nil)
+	}
+	defer resp.Body.Close()
+
+	if entry {
+		s.ReportHealth(sourceID, resp.StatusCode < 400, resp.Status)
+	}
+
+	if resp.StatusCode >= 400 {
+		return apis.NewApiError(http.StatusBadGateway, "Stream source returned an error", map[string]any{
+			"status": resp.StatusCode,
+		})
+	}
+
+	if isPlaylistResponse(resp) {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxPlaylistSize))
+		if err != nil {
+			return apis.NewApiError(http.StatusBadGateway, "Failed to read stream playlist", nil)
+		}
+
+		rewritten := s.rewritePlaylist(body, resp.Request.URL, channelID, sourceID, exp, sig)
+
+		c.Response().Header().Set("Cache-Control", "no-cache")
+		return c.Blob(http.StatusOK, "application/vnd.apple.mpegurl", rewritten)
+	}
+
+	s.endZap(channelID)
+
+	for _, header := range forwardedResponseHeaders {
+		if value := resp.Header.Get(header); value != "" {
+			c.Response().Header().Set(header, value)
+		}
+	}
+	c.Response().WriteHeader(resp.StatusCode)
+
+	_, err = io.Copy(c.Response(), resp.Body)
+	if err != nil && c.Request().Context().Err() == nil {
+		s.logger.Debug("stream copy interrupted", "channel_id", channelID, "error", err)
+	}
+
+	return nil
+}
+
+// isPlaylistResponse reports whether an upstream response is an HLS manifest
+func isPlaylistResponse(resp *http.Response) bool {
+	contentType := strings.ToLower(resp.Header.Get("Content-Type"))
+	if strings.Contains(contentType, "mpegurl") {
+		return true
+	}
+	return strings.HasSuffix(strings.ToLower(resp.Request.URL.Path), ".m3u8")
+}
+
+// rewritePlaylist points every URI of an HLS manifest at the proxy. The
+// URIs name the source they belong to, whose headers fetch them.
+func (s *Service) rewritePlaylist(body []byte, base *url.URL, channelID, sourceID, exp, sig string) []byte {
+	proxied := func(ref string) string {
+		resolved, err := base.Parse(strings.TrimSpace(ref))
+		if err != nil {
+			return ref
+		}
+		abs := resolved.String()
+
+		query := url.Values{}
+		query.Set("exp", exp)
+		query.Set("sig", sig)
+		query.Set("url", abs)
+		query.Set("src", sourceID)
+		query.Set("usig", s.sign("resource", channelID, sourceID, abs))
+
+		return PlaybackPathPrefix + url.PathEscape(channelID) + "?" + query.Encode()
+	}
+
+	var out bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "":
+			out.WriteString(line)
+		case strings.HasPrefix(trimmed, "#"):
+			// Tags such as EXT-X-KEY and EXT-X-MEDIA reference URIs in attributes
+			out.WriteString(uriAttributePattern.ReplaceAllStringFunc(line, func(match string) string {
+				ref := uriAttributePattern.FindStringSubmatch(match)[1]
+				return `URI="` + proxied(ref) + `"`
+			}))
+		default:
+			out.WriteString(proxied(trimmed))
+		}
+		out.WriteByte('\n')
+	}
+
+	return out.Bytes()
+}
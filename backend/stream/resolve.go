@@ -0,0 +1,213 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase/models"
+)
+
+// Playback methods, from cheapest to most expensive for the server
+const (
+	MethodDirect    = "direct"    // The client plays the source URL itself
+	MethodProxy     = "proxy"     // The stream is relayed unchanged through the proxy
+	MethodTranscode = "transcode" // ffmpeg converts the stream for the device
+)
+
+// PlaybackDecision is how a device should play a channel or recording
+type PlaybackDecision struct {
+	Method    string            `json:"method"`
+	URL       string            `json:"url"`
+	Reasons   []string          `json:"reasons,omitempty"` // Why the stream isn't played as is
+	Media     *MediaInfo        `json:"media,omitempty"`   // Nil when probing failed
+	Transcode *TranscodeOptions `json:"transcode,omitempty"`
+	Device    DeviceProfile     `json:"device"`
+}
+
+// TranscodeOptions is what ffmpeg converts a stream to. Codecs are "copy"
+// when the device already supports the source codec.
+type TranscodeOptions struct {
+	VideoCodec string `json:"video_codec"` // h264 or copy
+	AudioCodec string `json:"audio_codec"` // aac or copy
+	Height     int    `json:"height,omitempty"`
+	Format     string `json:"format"` // mpegts or mp4
+}
+
+// values encodes the options as query parameters (sorted, so stable for signing)
+func (o TranscodeOptions) values() url.Values {
+	values := url.Values{}
+	values.Set("vcodec", o.VideoCodec)
+	values.Set("acodec", o.AudioCodec)
+	values.Set("format", o.Format)
+	if o.Height > 0 {
+		values.Set("height", strconv.Itoa(o.Height))
+	}
+	return values
+}
+
+// parseTranscodeOptions reads and validates options from a transcode URL
+func parseTranscodeOptions(query url.Values) (TranscodeOptions, error) {
+	options := TranscodeOptions{
+		VideoCodec: query.Get("vcodec"),
+		AudioCodec: query.Get("acodec"),
+		Format:     query.Get("format"),
+	}
+	if raw := query.Get("height"); raw != "" {
+		height, err := strconv.Atoi(raw)
+		if err != nil || height < 0 {
+			return options, fmt.Errorf("invalid height")
+		}
+		options.Height = height
+	}
+
+	if options.VideoCodec != "h264" && options.VideoCodec != "copy" {
+		return options, fmt.Errorf("invalid video codec")
+	}
+	if options.AudioCodec != "aac" && options.AudioCodec != "copy" {
+		return options, fmt.Errorf("invalid audio codec")
+	}
+	if options.Format != "mpegts" && options.Format != "mp4" {
+		return options, fmt.Errorf("invalid format")
+	}
+	return options, nil
+}
+
+// decide compares the media with the device and returns the reasons it
+// can't be played as is, along with the transcode that fixes them
+func decide(media *MediaInfo, device DeviceProfile) ([]string, *TranscodeOptions) {
+	var reasons []string
+	options := &TranscodeOptions{VideoCodec: "copy", AudioCodec: "copy"}
+
+	if media.VideoCodec != "" && !contains(device.VideoCodecs, media.VideoCodec) {
+		reasons = append(reasons, fmt.Sprintf("video codec %s not supported", media.VideoCodec))
+		options.VideoCodec = "h264"
+	}
+	if media.AudioCodec != "" && !contains(device.AudioCodecs, media.AudioCodec) {
+		reasons = append(reasons, fmt.Sprintf("audio codec %s not supported", media.AudioCodec))
+		options.AudioCodec = "aac"
+	}
+	if device.MaxHeight > 0 && media.Height > device.MaxHeight {
+		reasons = append(reasons, fmt.Sprintf("resolution %dp above device limit %dp", media.Height, device.MaxHeight))
+		options.VideoCodec = "h264"
+		options.Height = device.MaxHeight
+	}
+	if media.HDR && !device.HDR {
+		reasons = append(reasons, "HDR not supported")
+		options.VideoCodec = "h264"
+	}
+	if !contains(device.Containers, media.Container) {
+		reasons = append(reasons, fmt.Sprintf("container %s not supported", media.Container))
+	}
+
+	if len(reasons) == 0 {
+		return nil, nil
+	}
+
+	// Remuxing into MPEG-TS is cheapest, MP4 plays everywhere else
+	options.Format = "mp4"
+	if contains(device.Containers, "mpegts") {
+		options.Format = "mpegts"
+	}
+
+	return reasons, options
+}
+
+// ResolveChannel decides how device should play a channel: the source URL
+// when the viewer may see it and the device plays it, the proxy when only
+// the URL must be hidden or the provider requires headers players don't
+// send, and a transcode when the device can't decode it
+func (s *Service) ResolveChannel(c echo.Context, channel *models.Record, device DeviceProfile) PlaybackDecision {
+	decision := PlaybackDecision{Device: device}
+
+	source := s.Source(channel)
+	sourceURL := source.GetString("url")
+	media, err := s.Probe(c.Request().Context(), sourceURL)
+	if err != nil {
+		s.logger.Debug("channel probe failed", "channel_id", channel.Id, "error", err)
+	} else {
+		decision.Media = media
+		decision.Reasons, decision.Transcode = decide(media, device)
+	}
+
+	switch {
+	case decision.Transcode != nil:
+		decision.Method = MethodTranscode
+		decision.URL = s.TranscodeURL(s.BaseURL(c), channel.Id, *decision.Transcode)
+	case s.CanViewChannelSource(c, source) && s.sourceHeaders(source.Id).Empty():
+		decision.Method = MethodDirect
+		decision.URL = sourceURL
+	default:
+		decision.Method = MethodProxy
+		decision.URL = s.PlaybackURL(s.BaseURL(c), channel.Id)
+	}
+
+	return decision
+}
+
+// ResolveRecording decides how device should play a recorded file, local
+// or stored: served as is when it can, transcoded otherwise
+func (s *Service) ResolveRecording(c echo.Context, files RecordingFiles, filename string, device DeviceProfile) PlaybackDecision {
+	decision := PlaybackDecision{Device: device}
+
+	media, err := s.probeRecording(c.Request().Context(), files, filename)
+	if err != nil {
+		s.logger.Debug("recording probe failed", "file", filename, "error", err)
+	} else {
+		decision.Media = media
+		decision.Reasons, decision.Transcode = decide(media, device)
+	}
+
+	if decision.Transcode != nil {
+		decision.Method = MethodTranscode
+		decision.URL = s.RecordingURL(s.BaseURL(c), filename, decision.Transcode)
+	} else {
+		decision.Method = MethodDirect
+		decision.URL = s.RecordingURL(s.BaseURL(c), filename, nil)
+	}
+
+	return decision
+}
+
+// probeRecording probes a recorded file in place, or read from the storage
+// backend it was moved to
+func (s *Service) probeRecording(ctx context.Context, files RecordingFiles, filename string) (*MediaInfo, error) {
+	if path, ok := files.LocalPath(filename); ok {
+		return s.Probe(ctx, path)
+	}
+	return s.probeReader(ctx, "recording:"+filename, func() (io.ReadCloser, error) {
+		return files.OpenFile(filename)
+	})
+}
+
+// TranscodeURL returns a signed URL streaming a channel converted with options
+func (s *Service) TranscodeURL(baseURL, channelID string, options TranscodeOptions) string {
+	exp := strconv.FormatInt(time.Now().Add(s.tokenTTL).Unix(), 10)
+
+	query := options.values()
+	query.Set("exp", exp)
+	query.Set("sig", s.sign("transcode", channelID, exp, options.values().Encode()))
+
+	return baseURL + PlaybackPathPrefix + url.PathEscape(channelID) + "/transcode?" + query.Encode()
+}
+
+// RecordingURL returns a signed URL serving a recorded file, converted with
+// options when they are set
+func (s *Service) RecordingURL(baseURL, filename string, options *TranscodeOptions) string {
+	exp := strconv.FormatInt(time.Now().Add(s.tokenTTL).Unix(), 10)
+
+	query := url.Values{}
+	params := ""
+	if options != nil {
+		query = options.values()
+		params = query.Encode()
+	}
+	query.Set("exp", exp)
+	query.Set("sig", s.sign("recording", filename, exp, params))
+
+	return baseURL + PlaybackPathPrefix + "recording/" + url.PathEscape(filename) + "?" + query.Encode()
+}
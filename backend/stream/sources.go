@@ -0,0 +1,61 @@
+package stream
+
+import (
+	"sort"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/models"
+)
+
+// Sources returns the records carrying a channel, by priority: the channel
+// itself and the duplicates merged into it. A merged duplicate played
+// directly is its only source.
+func (s *Service) Sources(channel *models.Record) []*models.Record {
+	sources := []*models.Record{channel}
+	if channel.GetString("merged_into") != "" || channel.Collection().Schema.GetFieldByName("merged_into") == nil {
+		return sources
+	}
+
+	merged, err := s.app.Dao().FindRecordsByFilter("channels", "merged_into = {:channel}", "", 0, 0,
+		dbx.Params{"channel": channel.Id})
+	if err != nil {
+		s.logger.Warn("failed to load channel sources", "channel_id", channel.Id, "error", err)
+		return sources
+	}
+
+	sources = append(sources, merged...)
+	sort.SliceStable(sources, func(i, j int) bool {
+		return sources[i].GetInt("priority") < sources[j].GetInt("priority")
+	})
+	return sources
+}
+
+// Source picks the source to play a channel from: the first by priority
+// that isn't down, the first one when all are
+func (s *Service) Source(channel *models.Record) *models.Record {
+	return s.pick(s.Sources(channel))
+}
+
+// SourceURLs returns the URLs of the sources of a channel, the one to play
+// first and then the fallbacks by priority
+func (s *Service) SourceURLs(channel *models.Record) []string {
+	sources := s.Sources(channel)
+	first := s.pick(sources)
+
+	urls := []string{first.GetString("url")}
+	for _, source := range sources {
+		if source != first {
+			urls = append(urls, source.GetString("url"))
+		}
+	}
+	return urls
+}
+
+func (s *Service) pick(sources []*models.Record) *models.Record {
+	for _, source := range sources {
+		if _, down := s.IsDown(source.Id); !down {
+			return source
+		}
+	}
+	return sources[0]
+}
@@ -0,0 +1,227 @@
+package stream
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase/core"
+
+	"iptv-backend/logging"
+	"iptv-backend/upstream"
+)
+
+// PlaybackPathPrefix is the route prefix of proxied playback URLs
+const PlaybackPathPrefix = "/api/stream/"
+
+// DefaultTokenTTL is how long a playback URL stays valid
+const DefaultTokenTTL = 24 * time.Hour
+
+// TokenQueryParam carries, instead of a signature, a long-lived token of a
+// player that can't sign in, checked by the function of SetTokenCheck
+const TokenQueryParam = "token"
+
+// Service builds signed playback URLs, proxies streams and applies the
+// stream URL visibility policy
+type Service struct {
+	app        core.App
+	publicURL  string
+	tokenTTL   time.Duration
+	client     *http.Client
+	health     channelHealth
+	prefetch   prefetcher
+	zaps       zapMetrics
+	probes     probeCache
+	viewers    viewerSessions
+	whep       *whepOutput                        // WebRTC output, nil while disabled
+	upstream   *upstream.Resolver                 // Provider headers, nil for none
+	tokenCheck func(token, channelID string) bool // Long-lived tokens, nil to refuse them
+	logger     *slog.Logger
+}
+
+// NewService creates a stream service.
+// publicURL is the externally reachable backend URL; when empty it is derived
+// from each request.
+func NewService(app core.App, publicURL string) *Service {
+	return &Service{
+		app:       app,
+		publicURL: strings.TrimRight(publicURL, "/"),
+		tokenTTL:  DefaultTokenTTL,
+		client: &http.Client{
+			Transport: &http.Transport{
+				Proxy:                 http.ProxyFromEnvironment,
+				ResponseHeaderTimeout: 15 * time.Second,
+				IdleConnTimeout:       90 * time.Second,
+			},
+		},
+		logger: logging.For("stream"),
+	}
+}
+
+// SetUpstream sets where the headers providers require come from. Call it
+// before serving requests.
+func (s *Service) SetUpstream(resolver *upstream.Resolver) {
+	s.upstream = resolver
+}
+
+// SetTokenCheck sets what tells whether a long-lived token may play a
+// channel. Without it, playback URLs need a signature. Call it before
+// serving requests.
+func (s *Service) SetTokenCheck(check func(token, channelID string) bool) {
+	s.tokenCheck = check
+}
+
+// sourceHeaders returns the headers to request a source channel with
+func (s *Service) sourceHeaders(sourceID string) upstream.Headers {
+	if s.upstream == nil {
+		return upstream.Headers{}
+	}
+	return s.upstream.ForChannelID(sourceID)
+}
+
+// urlHeaders returns the headers to request a channel's stream URL with
+func (s *Service) urlHeaders(streamURL string) upstream.Headers {
+	if s.upstream == nil {
+		return upstream.Headers{}
+	}
+	return s.upstream.ForURL(streamURL)
+}
+
+// inputArgs returns the ffmpeg options to read an input with
+func (s *Service) inputArgs(input string) []string {
+	if s.upstream == nil {
+		return nil
+	}
+	return s.upstream.FFmpegArgs(input)
+}
+
+// sign computes the hex HMAC of the given parts.
+// The key is the app's auth token secret, so regenerating it in the admin
+// settings also revokes all playback URLs.
+func (s *Service) sign(parts ...string) string {
+	mac := hmac.New(sha256.New, []byte(s.app.Settings().RecordAuthToken.Secret))
+	mac.Write([]byte("stream|" + strings.Join(parts, "|")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *Service) checkSignature(signature string, parts ...string) bool {
+	if signature == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(signature), []byte(s.sign(parts...))) == 1
+}
+
+// BaseURL returns the externally reachable backend URL for a request
+func (s *Service) BaseURL(c echo.Context) string {
+	if s.publicURL != "" || c == nil {
+		return s.publicURL
+	}
+	return c.Scheme() + "://" + c.Request().Host
+}
+
+// PlaybackURL returns a signed proxy URL for a channel.
+// baseURL may be empty to get a root-relative URL.
+func (s *Service) PlaybackURL(baseURL, channelID string) string {
+	exp, sig := s.playbackToken(channelID)
+
+	query := url.Values{}
+	query.Set("exp", exp)
+	query.Set("sig", sig)
+
+	return baseURL + PlaybackPathPrefix + url.PathEscape(channelID) + "?" + query.Encode()
+}
+
+// playbackToken returns the expiry and signature of a playback URL
+func (s *Service) playbackToken(channelID string) (exp, sig string) {
+	exp = strconv.FormatInt(time.Now().Add(s.tokenTTL).Unix(), 10)
+	return exp, s.sign("token", channelID, exp)
+}
+
+// verifyToken checks a playback token for a channel
+func (s *Service) verifyToken(channelID, exp, signature string) error {
+	expUnix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid token")
+	}
+	if time.Now().Unix() > expUnix {
+		return fmt.Errorf("token expired")
+	}
+	if !s.checkSignature(signature, "token", channelID, exp) {
+		return fmt.Errorf("invalid token")
+	}
+	return nil
+}
+
+// ParsePlaybackURL returns the channel ID of a valid playback URL
+func (s *Service) ParsePlaybackURL(raw string) (string, bool) {
+	u, err := url.Parse(raw)
+	if err != nil || !strings.HasPrefix(u.Path, PlaybackPathPrefix) {
+		return "", false
+	}
+
+	channelID := strings.TrimPrefix(u.Path, PlaybackPathPrefix)
+	if channelID == "" || strings.Contains(channelID, "/") {
+		return "", false
+	}
+
+	query := u.Query()
+	if s.verifyToken(channelID, query.Get("exp"), query.Get("sig")) != nil {
+		return "", false
+	}
+
+	return channelID, true
+}
+
+// ResolveSourceURL maps a playback URL back to the URL of the channel's
+// preferred source. Any other URL is returned unchanged, so callers can
+// accept both forms.
+func (s *Service) ResolveSourceURL(raw string) (string, error) {
+	if !strings.Contains(raw, PlaybackPathPrefix) {
+		return raw, nil
+	}
+
+	channelID, ok := s.ParsePlaybackURL(raw)
+	if !ok {
+		return raw, nil
+	}
+
+	channel, err := s.app.Dao().FindRecordById("channels", channelID)
+	if err != nil {
+		return "", fmt.Errorf("channel not found: %w", err)
+	}
+
+	return s.Source(channel).GetString("url"), nil
+}
+
+// VisibleURL returns the URL of a stream as the request's viewer may see it:
+// the source URL for admins and playlist owners, a playback URL otherwise.
+// Without a channel, only ownerID (the user who supplied the URL) sees it.
+func (s *Service) VisibleURL(c echo.Context, channelID, sourceURL, ownerID string) string {
+	viewer := ViewerFromContext(c)
+	if viewer.IsAdmin {
+		return sourceURL
+	}
+
+	if channelID == "" {
+		if ownerID != "" && ownerID == viewer.UserID {
+			return sourceURL
+		}
+		return ""
+	}
+
+	channel, err := s.app.Dao().FindRecordById("channels", channelID)
+	if err == nil && s.CanViewChannelSource(c, channel) {
+		return sourceURL
+	}
+
+	return s.PlaybackURL(s.BaseURL(c), channelID)
+}
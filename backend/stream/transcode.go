@@ -0,0 +1,195 @@
+package stream
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase/apis"
+
+	"iptv-backend/recorder"
+)
+
+// HandleTranscode streams a channel converted for a device, for a signed
+// transcode URL. ffmpeg runs until the client disconnects.
+func (s *Service) HandleTranscode(c echo.Context) error {
+	channelID := c.PathParam("channelId")
+	query := c.QueryParams()
+
+	options, err := parseTranscodeOptions(query)
+	if err != nil {
+		return apis.NewBadRequestError("Invalid transcode options", nil)
+	}
+	if err := s.verifySignedURL(query, "transcode", channelID, options.values().Encode()); err != nil {
+		return apis.NewForbiddenError("Invalid or expired stream token", nil)
+	}
+
+	channel, err := s.app.Dao().FindRecordById("channels", channelID)
+	if err != nil {
+		return apis.NewNotFoundError("Channel not found", err)
+	}
+
+	source := s.Source(channel)
+	defer s.beginView(source.Id, c.RealIP())()
+
+	return s.transcode(c, source.GetString("url"), nil, options)
+}
+
+// RecordingFiles reads recorded files, from the recordings directory or
+// from the storage backend they were moved to
+type RecordingFiles interface {
+	// LocalPath returns the path of a file still in the recordings directory
+	LocalPath(name string) (string, bool)
+	OpenFile(name string) (io.ReadCloser, error)
+	ServeFile(res http.ResponseWriter, req *http.Request, name string) error
+}
+
+// HandleRecording serves a recorded file, local or stored, for a signed
+// recording URL, transcoded when the URL carries transcode options
+func (s *Service) HandleRecording(c echo.Context, files RecordingFiles) error {
+	filename := c.PathParam("filename")
+	if !recorder.ValidFilename(filename) {
+		return apis.NewBadRequestError("Invalid filename", nil)
+	}
+
+	query := c.QueryParams()
+
+	var options *TranscodeOptions
+	params := ""
+	if query.Get("vcodec") != "" {
+		parsed, err := parseTranscodeOptions(query)
+		if err != nil {
+			return apis.NewBadRequestError("Invalid transcode options", nil)
+		}
+		options = &parsed
+		params = parsed.values().Encode()
+	}
+	if err := s.verifySignedURL(query, "recording", filename, params); err != nil {
+		return apis.NewForbiddenError("Invalid or expired stream token", nil)
+	}
+
+	path, ok := files.LocalPath(filename)
+	if !ok {
+		return s.serveStoredRecording(c, files, filename, options)
+	}
+
+	if options != nil {
+		return s.transcode(c, path, nil, *options)
+	}
+
+	// ServeFile handles range requests, so players can seek
+	return c.File(path)
+}
+
+// serveStoredRecording serves a recording from its storage backend. ffmpeg
+// reads it from stdin when it is transcoded.
+func (s *Service) serveStoredRecording(c echo.Context, files RecordingFiles, filename string, options *TranscodeOptions) error {
+	if options == nil {
+		err := files.ServeFile(c.Response(), c.Request(), filename)
+		if os.IsNotExist(err) {
+			return apis.NewNotFoundError("Recording not found", nil)
+		}
+		return err
+	}
+
+	r, err := files.OpenFile(filename)
+	if err != nil {
+		return apis.NewNotFoundError("Recording not found", nil)
+	}
+	defer r.Close()
+
+	return s.transcode(c, "pipe:0", r, *options)
+}
+
+// verifySignedURL checks the exp and sig query parameters of a URL built by
+// TranscodeURL or RecordingURL
+func (s *Service) verifySignedURL(query url.Values, kind, id, params string) error {
+	exp := query.Get("exp")
+	expUnix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid token")
+	}
+	if time.Now().Unix() > expUnix {
+		return fmt.Errorf("token expired")
+	}
+	if !s.checkSignature(query.Get("sig"), kind, id, exp, params) {
+		return fmt.Errorf("invalid token")
+	}
+	return nil
+}
+
+// transcode pipes input through ffmpeg into the response. stdin feeds
+// ffmpeg when input is pipe:0.
+func (s *Service) transcode(c echo.Context, input string, stdin io.Reader, options TranscodeOptions) error {
+	args := append([]string{
+		"-hide_banner",
+		"-loglevel", "error",
+	}, s.inputArgs(input)...)
+	args = append(args,
+		"-i", input,
+		"-map", "0:v:0?",
+		"-map", "0:a:0?",
+	)
+
+	if options.VideoCodec == "copy" {
+		args = append(args, "-c:v", "copy")
+	} else {
+		args = append(args, "-c:v", "libx264", "-preset", "veryfast", "-crf", "23", "-pix_fmt", "yuv420p")
+		if options.Height > 0 {
+			args = append(args, "-vf", "scale=-2:'min("+strconv.Itoa(options.Height)+",ih)'")
+		}
+	}
+	if options.AudioCodec == "copy" {
+		args = append(args, "-c:a", "copy")
+	} else {
+		args = append(args, "-c:a", "aac", "-b:a", "128k", "-ac", "2")
+	}
+
+	contentType := "video/mp2t"
+	if options.Format == "mp4" {
+		// Fragmented so playback starts before the stream ends
+		args = append(args, "-f", "mp4", "-movflags", "frag_keyframe+empty_moov+default_base_moof")
+		contentType = "video/mp4"
+	} else {
+		args = append(args, "-f", "mpegts")
+	}
+	args = append(args, "pipe:1")
+
+	ctx := c.Request().Context()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stderr = &stderr
+	cmd.Stdin = stdin
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		s.logger.Error("failed to start transcode", "error", err)
+		return apis.NewApiError(http.StatusInternalServerError, "Failed to start transcoding", nil)
+	}
+
+	s.logger.Debug("transcode started", "video_codec", options.VideoCodec, "audio_codec", options.AudioCodec, "height", options.Height, "format", options.Format)
+
+	c.Response().Header().Set("Content-Type", contentType)
+	c.Response().Header().Set("Cache-Control", "no-cache")
+	c.Response().WriteHeader(http.StatusOK)
+
+	_, copyErr := io.Copy(c.Response(), stdout)
+	waitErr := cmd.Wait()
+
+	if ctx.Err() == nil && (copyErr != nil || waitErr != nil) {
+		s.logger.Warn("transcode ended with an error", "error", waitErr, "stderr", strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package stream
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// viewerWindow is how long after its last request a client still counts as
+// watching a source; HLS players reload the manifest every few seconds
+const viewerWindow = 30 * time.Second
+
+// ViewingSession is a client playing a source channel through the proxy,
+// holding a connection to its provider
+type ViewingSession struct {
+	SourceID string    `json:"source_id"`
+	Client   string    `json:"-"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// viewerSessions tracks clients by source and address. A stream copied in a
+// single long request counts while it's open.
+type viewerSessions struct {
+	mu   sync.Mutex
+	open map[string]int
+	seen map[string]ViewingSession
+}
+
+// beginView records a request of a client for a source, call the returned
+// function when it ends
+func (s *Service) beginView(sourceID, client string) func() {
+	v := &s.viewers
+	key := sourceID + "|" + client
+
+	v.mu.Lock()
+	if v.open == nil {
+		v.open = make(map[string]int)
+		v.seen = make(map[string]ViewingSession)
+	}
+	v.open[key]++
+	v.seen[key] = ViewingSession{SourceID: sourceID, Client: client, LastSeen: time.Now()}
+	v.mu.Unlock()
+
+	return func() {
+		v.mu.Lock()
+		defer v.mu.Unlock()
+		if v.open[key]--; v.open[key] <= 0 {
+			delete(v.open, key)
+		}
+		v.seen[key] = ViewingSession{SourceID: sourceID, Client: client, LastSeen: time.Now()}
+	}
+}
+
+// ViewingSessions lists the clients watching through the proxy or the
+// transcoder now, by source
+func (s *Service) ViewingSessions() []ViewingSession {
+	v := &s.viewers
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	sessions := make([]ViewingSession, 0, len(v.seen))
+	for key, session := range v.seen {
+		if v.open[key] == 0 && time.Since(session.LastSeen) > viewerWindow {
+			delete(v.seen, key)
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeen.After(sessions[j].LastSeen)
+	})
+	return sessions
+}
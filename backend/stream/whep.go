@@ -0,0 +1,593 @@
+package stream
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/webrtc/v4"
+	"github.com/pocketbase/pocketbase/models"
+)
+
+// WebRTC output plays channels over WHEP (RFC 9725) with sub-second
+// latency, where HLS lags several seconds behind: the player posts an SDP
+// offer and gets the answer, then media flows over ICE. ffmpeg encodes a
+// channel once, to H.264 and Opus sent over RTP to the backend, and every
+// viewer of the channel shares its tracks.
+
+// WHEPConfig configures WebRTC output
+type WHEPConfig struct {
+	UDPPort     int      // Port all sessions share, 0 for an ephemeral port per session
+	PublicIPs   []string // Announced instead of the host's addresses, behind NAT or in Docker
+	MaxChannels int      // Channels encoded at once, 0 for no limit
+}
+
+// DefaultWHEPConfig returns the configuration used when none is given
+func DefaultWHEPConfig() WHEPConfig {
+	return WHEPConfig{UDPPort: 8189, MaxChannels: 4}
+}
+
+var (
+	ErrWHEPDisabled = errors.New("WebRTC output is disabled")
+	ErrWHEPBusy     = errors.New("too many channels are played over WebRTC at once")
+	ErrWHEPOffer    = errors.New("invalid SDP offer")
+	ErrWHEPSession  = errors.New("WebRTC session not found")
+)
+
+const (
+	whepConnectTimeout = 30 * time.Second // Sessions not connected by then are closed
+	whepFeedLinger     = 10 * time.Second // Encoding kept after the last viewer leaves, for quick returns
+	whepGatherTimeout  = 5 * time.Second
+	whepProbeTimeout   = 10 * time.Second
+)
+
+// whepOutput is the WebRTC state of the service, nil while disabled
+type whepOutput struct {
+	api      *webrtc.API
+	config   WHEPConfig
+	mux      net.PacketConn // Shared UDP port, nil for ephemeral ports
+	mu       sync.Mutex
+	feeds    map[string]*whepFeed    // By source
+	sessions map[string]*WHEPSession // By id
+}
+
+// whepFeed is a channel source encoded for WebRTC
+type whepFeed struct {
+	sourceID string
+	video    *webrtc.TrackLocalStaticRTP // Nil for radio channels
+	audio    *webrtc.TrackLocalStaticRTP // Nil for silent channels
+	viewers  int                         // Guarded by whepOutput.mu
+	linger   *time.Timer                 // Guarded by whepOutput.mu
+	exited   bool                        // Guarded by whepOutput.mu
+	cancel   context.CancelFunc
+}
+
+// WHEPSession is a viewer's WebRTC connection to a channel
+type WHEPSession struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user"`
+	ChannelID string    `json:"channel"`
+	Created   time.Time `json:"created"`
+
+	pc        *webrtc.PeerConnection
+	feed      *whepFeed
+	endView   func()
+	closeOnce sync.Once
+}
+
+// EnableWHEP turns WebRTC output on. Call it before serving requests.
+func (s *Service) EnableWHEP(config WHEPConfig) error {
+	media := &webrtc.MediaEngine{}
+	if err := media.RegisterDefaultCodecs(); err != nil {
+		return err
+	}
+	registry := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(media, registry); err != nil {
+		return err
+	}
+
+	output := &whepOutput{
+		config:   config,
+		feeds:    make(map[string]*whepFeed),
+		sessions: make(map[string]*WHEPSession),
+	}
+
+	settings := webrtc.SettingEngine{}
+	if len(config.PublicIPs) > 0 {
+		settings.SetNAT1To1IPs(config.PublicIPs, webrtc.ICECandidateTypeHost)
+	}
+	if config.UDPPort > 0 {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: config.UDPPort})
+		if err != nil {
+			return fmt.Errorf("failed to listen on WebRTC port %d: %w", config.UDPPort, err)
+		}
+		output.mux = conn
+		settings.SetICEUDPMux(webrtc.NewICEUDPMux(nil, conn))
+	}
+
+	output.api = webrtc.NewAPI(
+		webrtc.WithMediaEngine(media),
+		webrtc.WithInterceptorRegistry(registry),
+		webrtc.WithSettingEngine(settings),
+	)
+	s.whep = output
+	return nil
+}
+
+// StopWHEP closes the WebRTC sessions and stops encoding
+func (s *Service) StopWHEP() {
+	w := s.whep
+	if w == nil {
+		return
+	}
+
+	w.mu.Lock()
+	sessions := make([]*WHEPSession, 0, len(w.sessions))
+	for _, session := range w.sessions {
+		sessions = append(sessions, session)
+	}
+	w.mu.Unlock()
+	for _, session := range sessions {
+		s.closeWHEPSession(session)
+	}
+
+	w.mu.Lock()
+	for _, feed := range w.feeds {
+		feed.cancel()
+	}
+	w.mu.Unlock()
+
+	if w.mux != nil {
+		w.mux.Close()
+	}
+}
+
+// WHEPOffer answers the SDP offer of a player for a channel and returns the
+// session with the SDP answer, which lists all candidates of the backend.
+// client identifies the viewer in viewer counts.
+func (s *Service) WHEPOffer(channel *models.Record, userID, client, offer string) (*WHEPSession, string, error) {
+	w := s.whep
+	if w == nil {
+		return nil, "", ErrWHEPDisabled
+	}
+
+	source := s.Source(channel)
+	feed, err := s.joinWHEPFeed(source)
+	if err != nil {
+		return nil, "", err
+	}
+
+	pc, err := w.api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		s.leaveWHEPFeed(feed)
+		return nil, "", err
+	}
+	session := &WHEPSession{
+		ID:        newWHEPSessionID(),
+		UserID:    userID,
+		ChannelID: channel.Id,
+		Created:   time.Now(),
+		pc:        pc,
+		feed:      feed,
+		endView:   func() {},
+	}
+
+	answer, err := s.negotiateWHEP(pc, feed, offer)
+	if err != nil {
+		pc.Close()
+		s.leaveWHEPFeed(feed)
+		return nil, "", err
+	}
+
+	session.endView = s.beginView(source.Id, client)
+	w.mu.Lock()
+	w.sessions[session.ID] = session
+	w.mu.Unlock()
+
+	connected := make(chan struct{})
+	var connectedOnce sync.Once
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		switch state {
+		case webrtc.PeerConnectionStateConnected:
+			connectedOnce.Do(func() { close(connected) })
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
+			go s.closeWHEPSession(session)
+		}
+	})
+	time.AfterFunc(whepConnectTimeout, func() {
+		select {
+		case <-connected:
+		default:
+			s.logger.Debug("WebRTC session didn't connect", "session_id", session.ID, "channel_id", channel.Id)
+			s.closeWHEPSession(session)
+		}
+	})
+
+	s.logger.Debug("WebRTC session started", "session_id", session.ID, "channel_id", channel.Id, "user_id", userID)
+	return session, answer, nil
+}
+
+// negotiateWHEP adds the tracks of a feed to a connection and answers an
+// offer, once the backend's candidates are gathered
+func (s *Service) negotiateWHEP(pc *webrtc.PeerConnection, feed *whepFeed, offer string) (string, error) {
+	for _, track := range []*webrtc.TrackLocalStaticRTP{feed.video, feed.audio} {
+		if track == nil {
+			continue
+		}
+		sender, err := pc.AddTrack(track)
+		if err != nil {
+			return "", err
+		}
+		// RTCP has to be read for the interceptors to handle NACKs
+		go func() {
+			buf := make([]byte, 1500)
+			for {
+				if _, _, err := sender.Read(buf); err != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer}); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrWHEPOffer, err)
+	}
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrWHEPOffer, err)
+	}
+
+	gathered := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(answer); err != nil {
+		return "", err
+	}
+	select {
+	case <-gathered:
+	case <-time.After(whepGatherTimeout):
+		// The answer carries the candidates gathered so far
+	}
+	return pc.LocalDescription().SDP, nil
+}
+
+// WHEPCandidates adds the ICE candidates a player trickles, from an SDP
+// fragment, to its session
+func (s *Service) WHEPCandidates(id, userID, fragment string) error {
+	session, err := s.whepSession(id, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, line := range strings.Split(fragment, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "a=candidate:") {
+			continue
+		}
+		candidate := webrtc.ICECandidateInit{Candidate: strings.TrimPrefix(line, "a=")}
+		if err := session.pc.AddICECandidate(candidate); err != nil {
+			return fmt.Errorf("%w: %v", ErrWHEPOffer, err)
+		}
+	}
+	return nil
+}
+
+// WHEPClose ends a session of a user
+func (s *Service) WHEPClose(id, userID string) error {
+	session, err := s.whepSession(id, userID)
+	if err != nil {
+		return err
+	}
+	s.closeWHEPSession(session)
+	return nil
+}
+
+// whepSession returns a session of a user
+func (s *Service) whepSession(id, userID string) (*WHEPSession, error) {
+	w := s.whep
+	if w == nil {
+		return nil, ErrWHEPSession
+	}
+	w.mu.Lock()
+	session := w.sessions[id]
+	w.mu.Unlock()
+	if session == nil || session.UserID != userID {
+		return nil, ErrWHEPSession
+	}
+	return session, nil
+}
+
+// closeWHEPSession closes the connection of a session and leaves its feed
+func (s *Service) closeWHEPSession(session *WHEPSession) {
+	session.closeOnce.Do(func() {
+		w := s.whep
+		w.mu.Lock()
+		delete(w.sessions, session.ID)
+		w.mu.Unlock()
+
+		session.pc.Close()
+		session.endView()
+		s.leaveWHEPFeed(session.feed)
+		s.logger.Debug("WebRTC session closed", "session_id", session.ID, "channel_id", session.ChannelID)
+	})
+}
+
+// joinWHEPFeed returns the feed of a source with one more viewer, starting
+// it when the source isn't encoded yet
+func (s *Service) joinWHEPFeed(source *models.Record) (*whepFeed, error) {
+	w := s.whep
+
+	w.mu.Lock()
+	if feed := w.joinRunning(source.Id); feed != nil {
+		w.mu.Unlock()
+		return feed, nil
+	}
+	if w.full() {
+		w.mu.Unlock()
+		return nil, ErrWHEPBusy
+	}
+	w.mu.Unlock()
+
+	// Started unlocked, probing takes seconds
+	feed, err := s.startWHEPFeed(source)
+	if err != nil {
+		return nil, err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if running := w.joinRunning(source.Id); running != nil {
+		// Another viewer started it meanwhile
+		feed.cancel()
+		return running, nil
+	}
+	if feed.exited {
+		return nil, errors.New("encoding the channel failed")
+	}
+	if w.full() {
+		feed.cancel()
+		return nil, ErrWHEPBusy
+	}
+	feed.viewers = 1
+	w.feeds[source.Id] = feed
+	return feed, nil
+}
+
+// joinRunning adds a viewer to the running feed of a source, if any. w.mu
+// must be held.
+func (w *whepOutput) joinRunning(sourceID string) *whepFeed {
+	feed := w.feeds[sourceID]
+	if feed == nil || feed.exited {
+		return nil
+	}
+	feed.viewers++
+	if feed.linger != nil {
+		feed.linger.Stop()
+		feed.linger = nil
+	}
+	return feed
+}
+
+// full reports whether MaxChannels are encoded. w.mu must be held.
+func (w *whepOutput) full() bool {
+	return w.config.MaxChannels > 0 && len(w.feeds) >= w.config.MaxChannels
+}
+
+// leaveWHEPFeed removes a viewer of a feed, which stops whepFeedLinger
+// after its last viewer left
+func (s *Service) leaveWHEPFeed(feed *whepFeed) {
+	w := s.whep
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	feed.viewers--
+	if feed.viewers > 0 || feed.exited {
+		return
+	}
+	feed.linger = time.AfterFunc(whepFeedLinger, func() {
+		w.mu.Lock()
+		idle := feed.viewers == 0
+		w.mu.Unlock()
+		if idle {
+			feed.cancel()
+		}
+	})
+}
+
+// startWHEPFeed starts ffmpeg encoding a source to RTP on local ports,
+// relayed into the tracks of the feed. Sessions of the feed are closed when
+// ffmpeg exits.
+func (s *Service) startWHEPFeed(source *models.Record) (*whepFeed, error) {
+	input := source.GetString("url")
+
+	probeCtx, cancelProbe := context.WithTimeout(context.Background(), whepProbeTimeout)
+	media, err := s.Probe(probeCtx, input)
+	cancelProbe()
+	if err != nil {
+		// Assume both, ffmpeg fails on what the source lacks
+		s.logger.Debug("WebRTC source probe failed", "source_id", source.Id, "error", err)
+		media = &MediaInfo{VideoCodec: "unknown", AudioCodec: "unknown"}
+	}
+	if media.VideoCodec == "" && media.AudioCodec == "" {
+		return nil, errors.New("the channel has neither video nor audio")
+	}
+
+	feed := &whepFeed{sourceID: source.Id}
+	args := append([]string{
+		"-hide_banner",
+		"-loglevel", "error",
+		"-fflags", "nobuffer",
+		"-flags", "low_delay",
+	}, s.inputArgs(input)...)
+	args = append(args, "-i", input)
+
+	var conns []*net.UDPConn
+	closeConns := func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}
+	listen := func() (*net.UDPConn, error) {
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err == nil {
+			conns = append(conns, conn)
+		}
+		return conn, err
+	}
+	var relays []func()
+
+	if media.VideoCodec != "" {
+		conn, err := listen()
+		if err != nil {
+			closeConns()
+			return nil, err
+		}
+		feed.video, err = webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{
+			MimeType:    webrtc.MimeTypeH264,
+			ClockRate:   90000,
+			SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f",
+		}, "video", "streamvault-"+source.Id)
+		if err != nil {
+			closeConns()
+			return nil, err
+		}
+		// Baseline without B-frames and a keyframe every second, so
+		// browsers decode it and new viewers start within a second
+		args = append(args,
+			"-map", "0:v:0",
+			"-c:v", "libx264", "-preset", "ultrafast", "-tune", "zerolatency",
+			"-profile:v", "baseline", "-pix_fmt", "yuv420p", "-bf", "0",
+			"-vf", "scale=-2:'min(1080,ih)'",
+			"-force_key_frames", "expr:gte(t,n_forced*1)",
+			"-f", "rtp", "-payload_type", "96", rtpURL(conn),
+		)
+		track := feed.video
+		relays = append(relays, func() { relayRTP(conn, track) })
+	}
+	if media.AudioCodec != "" {
+		conn, err := listen()
+		if err != nil {
+			closeConns()
+			return nil, err
+		}
+		feed.audio, err = webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{
+			MimeType:  webrtc.MimeTypeOpus,
+			ClockRate: 48000,
+			Channels:  2,
+		}, "audio", "streamvault-"+source.Id)
+		if err != nil {
+			closeConns()
+			return nil, err
+		}
+		args = append(args,
+			"-map", "0:a:0",
+			"-c:a", "libopus", "-b:a", "128k", "-ar", "48000", "-ac", "2",
+			"-f", "rtp", "-payload_type", "111", rtpURL(conn),
+		)
+		track := feed.audio
+		relays = append(relays, func() { relayRTP(conn, track) })
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	feed.cancel = cancel
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		cancel()
+		closeConns()
+		s.logger.Error("failed to start WebRTC encoding", "error", err)
+		return nil, fmt.Errorf("failed to start encoding: %w", err)
+	}
+	for _, relay := range relays {
+		go relay()
+	}
+	s.logger.Debug("WebRTC encoding started", "source_id", source.Id)
+
+	go func() {
+		err := cmd.Wait()
+		closeConns()
+		if ctx.Err() == nil {
+			s.logger.Warn("WebRTC encoding ended", "source_id", source.Id, "error", err, "stderr", strings.TrimSpace(stderr.String()))
+		}
+		s.endWHEPFeed(feed)
+	}()
+
+	return feed, nil
+}
+
+// endWHEPFeed removes a feed whose ffmpeg exited and closes its sessions
+func (s *Service) endWHEPFeed(feed *whepFeed) {
+	w := s.whep
+	w.mu.Lock()
+	feed.exited = true
+	if w.feeds[feed.sourceID] == feed {
+		delete(w.feeds, feed.sourceID)
+	}
+	var sessions []*WHEPSession
+	for _, session := range w.sessions {
+		if session.feed == feed {
+			sessions = append(sessions, session)
+		}
+	}
+	w.mu.Unlock()
+
+	for _, session := range sessions {
+		s.closeWHEPSession(session)
+	}
+}
+
+// whepStatus reports the channels encoded and the sessions open
+func (s *Service) whepStatus() map[string]interface{} {
+	w := s.whep
+	if w == nil {
+		return map[string]interface{}{"enabled": false}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return map[string]interface{}{
+		"enabled":      true,
+		"channels":     len(w.feeds),
+		"max_channels": w.config.MaxChannels,
+		"sessions":     len(w.sessions),
+	}
+}
+
+// relayRTP writes the RTP packets ffmpeg sends to conn into a track, until
+// conn is closed
+func relayRTP(conn *net.UDPConn, track *webrtc.TrackLocalStaticRTP) {
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		// ffmpeg's RTCP sender reports come to the same port
+		if n < 12 || buf[1] >= 200 && buf[1] <= 204 {
+			continue
+		}
+		// Errors are of viewers whose connection closed
+		track.Write(buf[:n])
+	}
+}
+
+// rtpURL is the ffmpeg output sending RTP, and RTCP, to conn
+func rtpURL(conn *net.UDPConn) string {
+	port := strconv.Itoa(conn.LocalAddr().(*net.UDPAddr).Port)
+	return "rtp://127.0.0.1:" + port + "?pkt_size=1200&rtcpport=" + port
+}
+
+func newWHEPSessionID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,124 @@
+package streamtokens
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pocketbase/pocketbase/models"
+
+	"iptv-backend/stream"
+)
+
+// ExportPathPrefix is the route prefix of exports, followed by the token.
+// It is the base URL of the HDHomeRun device players are given.
+const ExportPathPrefix = "/api/export/"
+
+// DefaultTuners is the tuner count of the HDHomeRun device when a playlist
+// has no connection limit
+const DefaultTuners = 4
+
+// Export renders the channels of a token in the formats players read,
+// numbered from 1 in their order. Their URLs go through the stream proxy
+// and carry the token.
+type Export struct {
+	BaseURL  string // Externally reachable backend URL
+	Token    string
+	Channels []*models.Record
+}
+
+// StreamURL returns the URL a player plays a channel from
+func (e Export) StreamURL(channelID string) string {
+	return e.BaseURL + stream.PlaybackPathPrefix + url.PathEscape(channelID) + "?" + QueryParam + "=" + url.QueryEscape(e.Token)
+}
+
+// DeviceURL returns the base URL of the HDHomeRun device
+func (e Export) DeviceURL() string {
+	return e.BaseURL + ExportPathPrefix + url.PathEscape(e.Token)
+}
+
+// WriteM3U writes an M3U playlist of the channels, with their guide id,
+// logo, group and number
+func (e Export) WriteM3U(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	for i, channel := range e.Channels {
+		fmt.Fprintf(&b, "#EXTINF:-1 tvg-id=\"%s\" tvg-name=\"%s\" tvg-logo=\"%s\" tvg-chno=\"%d\" group-title=\"%s\",%s\n",
+			attribute(channel.GetString("tvg_id")),
+			attribute(channel.GetString("tvg_name")),
+			attribute(channel.GetString("tvg_logo")),
+			i+1,
+			attribute(channel.GetString("group_title")),
+			line(channel.GetString("name")))
+		b.WriteString(e.StreamURL(channel.Id))
+		b.WriteByte('\n')
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// LineupEntry is a channel of an HDHomeRun lineup
+type LineupEntry struct {
+	GuideNumber string `json:"GuideNumber"`
+	GuideName   string `json:"GuideName"`
+	URL         string `json:"URL"`
+}
+
+// Lineup returns the channels as an HDHomeRun lineup.json
+func (e Export) Lineup() []LineupEntry {
+	lineup := make([]LineupEntry, len(e.Channels))
+	for i, channel := range e.Channels {
+		lineup[i] = LineupEntry{
+			GuideNumber: strconv.Itoa(i + 1),
+			GuideName:   channel.GetString("name"),
+			URL:         e.StreamURL(channel.Id),
+		}
+	}
+	return lineup
+}
+
+// Discover returns the HDHomeRun discover.json of the device of a token.
+// Its DeviceID is derived from the token record so players recognize the
+// device across restarts; tuners bounds the streams they open at once.
+func (e Export) Discover(record *models.Record, tuners int) map[string]interface{} {
+	sum := sha256.Sum256([]byte(record.Id))
+	deviceURL := e.DeviceURL()
+
+	return map[string]interface{}{
+		"FriendlyName":    "StreamVault " + record.GetString("name"),
+		"Manufacturer":    "Silicondust",
+		"ModelNumber":     "HDTC-2US",
+		"FirmwareName":    "hdhomeruntc_atsc",
+		"FirmwareVersion": "20200101",
+		"DeviceID":        strings.ToUpper(hex.EncodeToString(sum[:4])),
+		"DeviceAuth":      hex.EncodeToString(sum[4:16]),
+		"TunerCount":      tuners,
+		"BaseURL":         deviceURL,
+		"LineupURL":       deviceURL + "/lineup.json",
+	}
+}
+
+// LineupStatus is the HDHomeRun lineup_status.json of a device that never
+// scans: its lineup is the user's channels
+func LineupStatus() map[string]interface{} {
+	return map[string]interface{}{
+		"ScanInProgress": 0,
+		"ScanPossible":   1,
+		"Source":         "Cable",
+		"SourceList":     []string{"Cable"},
+	}
+}
+
+// attribute makes a value safe inside a quoted M3U attribute
+func attribute(value string) string {
+	return strings.ReplaceAll(line(value), `"`, "'")
+}
+
+// line makes a value safe on a single M3U line
+func line(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
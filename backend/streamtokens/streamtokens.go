@@ -0,0 +1,212 @@
+// Package streamtokens lets players that can't sign in, such as Plex,
+// Jellyfin or TiviMate, play a user's channels. Each token belongs to a
+// user, optionally to one of their profiles, and is embedded in the URLs of
+// the M3U playlist and HDHomeRun lineup exported for it; the stream proxy
+// accepts it for the channels of the export. Tokens don't expire, they are
+// revoked through the API.
+package streamtokens
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/types"
+
+	"iptv-backend/connections"
+	"iptv-backend/hashedtokens"
+	"iptv-backend/logging"
+	"iptv-backend/parental"
+	"iptv-backend/stream"
+)
+
+// Collection is where tokens are stored
+const Collection = "stream_tokens"
+
+// QueryParam carries a token on stream proxy URLs
+const QueryParam = stream.TokenQueryParam
+
+// tokenPrefix starts every token, unlike the sv_ of API keys
+const tokenPrefix = "svst_"
+
+var (
+	ErrNotFound = hashedtokens.ErrNotFound
+	ErrProfile  = errors.New("profile not found")
+)
+
+// Token describes a token without its secret
+type Token struct {
+	ID         string         `json:"id"`
+	Name       string         `json:"name"`
+	Prefix     string         `json:"prefix"`
+	Profile    string         `json:"profile"`
+	Created    types.DateTime `json:"created"`
+	LastUsedAt types.DateTime `json:"last_used_at"`
+}
+
+// Service creates, checks and revokes tokens
+type Service struct {
+	app    core.App
+	tokens *hashedtokens.Store
+}
+
+// NewService returns a token service over the app's database
+func NewService(app core.App) *Service {
+	return &Service{
+		app:    app,
+		tokens: hashedtokens.NewStore(app, logging.For("streamtokens"), Collection, "token_hash", tokenPrefix),
+	}
+}
+
+// Create generates a token for a user, limited to what a profile of theirs
+// may watch when profileID is set, and returns it with its description.
+// The token is only available now, only its hash is stored.
+func (s *Service) Create(userID, name, profileID string) (string, Token, error) {
+	dao := s.app.Dao()
+
+	if profileID != "" {
+		profile, err := dao.FindRecordById("profiles", profileID)
+		if err != nil || !parental.OwnedBy(profile, userID) {
+			return "", Token{}, ErrProfile
+		}
+	}
+
+	token, record, err := s.tokens.Create(userID, map[string]any{"name": name, "profile": profileID})
+	if err != nil {
+		return "", Token{}, err
+	}
+	return token, describe(record), nil
+}
+
+// List returns the tokens of a user, newest first
+func (s *Service) List(userID string) ([]Token, error) {
+	records, err := s.tokens.List(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make([]Token, 0, len(records))
+	for _, record := range records {
+		tokens = append(tokens, describe(record))
+	}
+	return tokens, nil
+}
+
+// Revoke deletes a token of a user; players using it stop at their next
+// request
+func (s *Service) Revoke(userID, id string) error {
+	return s.tokens.Revoke(userID, id)
+}
+
+// Find returns the record of a valid token and records its use
+func (s *Service) Find(token string) (*models.Record, error) {
+	record, err := s.tokens.Find(token)
+	if err != nil {
+		return nil, err
+	}
+	s.tokens.Touch(record)
+	return record, nil
+}
+
+// Channels returns the channels exported for a token: the active channels
+// of its user's playlists, merged duplicates played through their channel,
+// by sort order and name. Channels hidden from the token's profile by
+// parental controls are left out.
+func (s *Service) Channels(record *models.Record) ([]*models.Record, error) {
+	channels, err := s.app.Dao().FindRecordsByFilter("channels",
+		"playlist.user ~ {:user} && is_active = true && merged_into = ''", "sort_order,name", 0, 0,
+		dbx.Params{"user": record.GetString("user")})
+	if err != nil {
+		return nil, err
+	}
+
+	restrictions, err := s.restrictions(record)
+	if err != nil || restrictions.Empty() {
+		return channels, err
+	}
+	kept := channels[:0]
+	for _, channel := range channels {
+		if !restrictions.BlocksChannel(channel) {
+			kept = append(kept, channel)
+		}
+	}
+	return kept, nil
+}
+
+// CanPlay reports whether a token may play a channel: one of its user's
+// and not hidden from its profile
+func (s *Service) CanPlay(token, channelID string) bool {
+	record, err := s.Find(token)
+	if err != nil {
+		return false
+	}
+
+	dao := s.app.Dao()
+	channel, err := dao.FindRecordById("channels", channelID)
+	if err != nil {
+		return false
+	}
+
+	owned := false
+	for _, playlistID := range channel.GetStringSlice("playlist") {
+		playlist, err := dao.FindRecordById("playlists", playlistID)
+		if err == nil && slices.Contains(playlist.GetStringSlice("user"), record.GetString("user")) {
+			owned = true
+			break
+		}
+	}
+	if !owned {
+		return false
+	}
+
+	restrictions, err := s.restrictions(record)
+	return err == nil && !restrictions.BlocksChannel(channel)
+}
+
+// Tuners returns how many streams a token's players may open at once: the
+// connections the user's playlists allow in all, DefaultTuners when one of
+// them has no limit
+func (s *Service) Tuners(record *models.Record) int {
+	playlists, err := s.app.Dao().FindRecordsByFilter("playlists", "user ~ {:user}", "", 0, 0,
+		dbx.Params{"user": record.GetString("user")})
+	if err != nil || len(playlists) == 0 {
+		return DefaultTuners
+	}
+
+	tuners := 0
+	for _, playlist := range playlists {
+		limit := playlist.GetInt(connections.LimitField)
+		if limit <= 0 {
+			return DefaultTuners
+		}
+		tuners += limit
+	}
+	return tuners
+}
+
+// restrictions returns the parental controls of a token's profile, none
+// when it has no profile
+func (s *Service) restrictions(record *models.Record) (parental.Restrictions, error) {
+	profileID := record.GetString("profile")
+	if profileID == "" {
+		return parental.Restrictions{}, nil
+	}
+	profile, err := s.app.Dao().FindRecordById("profiles", profileID)
+	if err != nil {
+		return parental.Restrictions{}, err
+	}
+	return parental.RestrictionsOf(profile), nil
+}
+
+func describe(record *models.Record) Token {
+	return Token{
+		ID:         record.Id,
+		Name:       record.GetString("name"),
+		Prefix:     record.GetString("prefix"),
+		Profile:    record.GetString("profile"),
+		Created:    record.Created,
+		LastUsedAt: record.GetDateTime("last_used_at"),
+	}
+}
@@ -0,0 +1,454 @@
+package subtitle
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultBurnInDelay is how far burned in streams run behind live, for the
+// subtitles of what they show to be recognized by then
+const DefaultBurnInDelay = 12 * time.Second
+
+// Burned in streams nobody fetched for this long are stopped
+const burnInIdleTimeout = 2 * time.Minute
+
+// Caption lines are wrapped at this many characters, which fits a 16:9
+// picture at the largest font size
+const burnInLineLength = 42
+
+// Captions stay on screen at least this long, as in the web player
+const minCaptionDuration = 2500 * time.Millisecond
+
+// ErrBurnInNotFound is returned for a burned in stream that was stopped or
+// never started
+var ErrBurnInNotFound = errors.New("burned in stream not found")
+
+// burnInFiles are the files of a burned in stream that are served
+var burnInFiles = regexp.MustCompile(`^(?:index\.m3u8|seg_\d+\.ts)$`)
+
+// burnIn re-encodes the stream of a session with its subtitles drawn over
+// the picture, as HLS. The stream is read through a delay line so that
+// each frame is encoded once its subtitles are ready.
+type burnIn struct {
+	Token     string
+	Lang      string
+	Style     PreviewStyle
+	Delay     time.Duration
+	StartedAt time.Time
+	Status    string // starting, running
+
+	dir       string
+	cancel    context.CancelFunc
+	lastFetch time.Time
+}
+
+// BurnInInfo describes the burned in stream of a session
+type BurnInInfo struct {
+	Path      string       `json:"path"` // Playlist path, relative to the backend URL
+	Lang      string       `json:"lang,omitempty"`
+	Style     PreviewStyle `json:"style"`
+	Delay     float64      `json:"delay"` // Seconds behind live
+	Status    string       `json:"status"`
+	StartedAt time.Time    `json:"started_at"`
+}
+
+func (b *burnIn) info() BurnInInfo {
+	return BurnInInfo{
+		Path:      "/api/subtitle/burnin/" + b.Token + "/index.m3u8",
+		Lang:      b.Lang,
+		Style:     b.Style,
+		Delay:     b.Delay.Seconds(),
+		Status:    b.Status,
+		StartedAt: b.StartedAt,
+	}
+}
+
+// StartBurnIn starts re-encoding the stream of a session with its
+// subtitles in lang burned in, styled like previews. A session has one
+// burned in stream, which is returned as is when already started.
+func (ss *SubtitleService) StartBurnIn(sessionID, lang string, style PreviewStyle) (*BurnInInfo, error) {
+	if err := style.Normalize(); err != nil {
+		return nil, err
+	}
+
+	ss.mu.RLock()
+	session, exists := ss.sessions[sessionID]
+	ss.mu.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrSessionNotFound, sessionID)
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if session.burnIn != nil {
+		info := session.burnIn.info()
+		return &info, nil
+	}
+	if session.ctx.Err() != nil {
+		return nil, fmt.Errorf("%w: %s", ErrSessionNotFound, sessionID)
+	}
+	lang = session.langForLocked(sessionID, lang)
+	if !session.hasLanguage(lang) {
+		return nil, fmt.Errorf("%w: %s", ErrLanguageNotAvailable, lang)
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	delay := ss.config.BurnInDelay
+	if delay <= 0 {
+		delay = DefaultBurnInDelay
+	}
+
+	ctx, cancel := context.WithCancel(session.ctx)
+	b := &burnIn{
+		Token:     hex.EncodeToString(token),
+		Lang:      lang,
+		Style:     style,
+		Delay:     delay,
+		StartedAt: time.Now(),
+		Status:    "starting",
+		dir:       filepath.Join(ss.config.CacheDir, "burnin", hex.EncodeToString(token)),
+		cancel:    cancel,
+		lastFetch: time.Now(),
+	}
+	if err := os.MkdirAll(b.dir, 0755); err != nil {
+		cancel()
+		return nil, err
+	}
+	session.burnIn = b
+
+	ss.workers.Add(1)
+	go func() {
+		defer ss.workers.Done()
+		err := ss.runBurnIn(ctx, session, b)
+
+		session.mu.Lock()
+		if session.burnIn == b {
+			session.burnIn = nil
+		}
+		session.mu.Unlock()
+		os.RemoveAll(b.dir)
+
+		if err != nil && ctx.Err() == nil {
+			ss.sessionLogger(session).Warn("burned in stream failed", "error", err)
+		}
+		cancel()
+	}()
+
+	ss.sessionLogger(session).Info("burned in stream started", "lang", lang, "delay", delay)
+
+	info := b.info()
+	return &info, nil
+}
+
+// GetBurnIn returns the burned in stream of a session, nil when it has none
+func (ss *SubtitleService) GetBurnIn(sessionID string) (*BurnInInfo, error) {
+	ss.mu.RLock()
+	session, exists := ss.sessions[sessionID]
+	ss.mu.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrSessionNotFound, sessionID)
+	}
+
+	session.mu.RLock()
+	defer session.mu.RUnlock()
+	if session.burnIn == nil {
+		return nil, nil
+	}
+	info := session.burnIn.info()
+	return &info, nil
+}
+
+// StopBurnIn stops the burned in stream of a session
+func (ss *SubtitleService) StopBurnIn(sessionID string) error {
+	ss.mu.RLock()
+	session, exists := ss.sessions[sessionID]
+	ss.mu.RUnlock()
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrSessionNotFound, sessionID)
+	}
+
+	session.mu.Lock()
+	b := session.burnIn
+	session.burnIn = nil
+	session.mu.Unlock()
+
+	if b == nil {
+		return ErrBurnInNotFound
+	}
+	b.cancel()
+	return nil
+}
+
+// BurnInFile returns the path of a file of the burned in stream with the
+// given token, its playlist or a segment. Fetching it counts as watching
+// the session.
+func (ss *SubtitleService) BurnInFile(token, name string) (string, error) {
+	if !burnInFiles.MatchString(name) {
+		return "", ErrBurnInNotFound
+	}
+
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	for _, session := range ss.sessions {
+		session.mu.Lock()
+		b := session.burnIn
+		if b != nil && token != "" && b.Token == token {
+			b.lastFetch = time.Now()
+			session.mu.Unlock()
+			session.touch()
+			return filepath.Join(b.dir, name), nil
+		}
+		session.mu.Unlock()
+	}
+	return "", ErrBurnInNotFound
+}
+
+// runBurnIn copies the session's stream out of one ffmpeg, holds it back by
+// the burn in delay and feeds it to another which draws the caption of
+// the moment it shows and writes the HLS playlist and segments
+func (ss *SubtitleService) runBurnIn(ctx context.Context, session *SubtitleSession, b *burnIn) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	captionPath := filepath.Join(b.dir, "caption.txt")
+	if err := os.WriteFile(captionPath, nil, 0644); err != nil {
+		return err
+	}
+
+	readArgs := append([]string{"-hide_banner", "-loglevel", "error"}, ss.inputOptions(session.StreamURL)...)
+	readArgs = append(readArgs,
+		"-i", session.StreamURL,
+		"-map", "0:v:0",
+		"-map", "0:a:0?",
+		"-c", "copy",
+		"-f", "mpegts",
+		"pipe:1",
+	)
+	reader := exec.CommandContext(ctx, "ffmpeg", readArgs...)
+	var readErr bytes.Buffer
+	reader.Stderr = &readErr
+	source, err := reader.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	// Keyframes every segment, so each starts a segment of its own
+	encoder := exec.CommandContext(ctx, "ffmpeg",
+		"-hide_banner", "-loglevel", "error",
+		"-f", "mpegts",
+		"-i", "pipe:0",
+		"-map", "0:v:0",
+		"-map", "0:a:0?",
+		"-vf", "scale=-2:'min(720,ih)',"+b.Style.drawText(captionPath),
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "23", "-pix_fmt", "yuv420p",
+		"-force_key_frames", "expr:gte(t,n_forced*4)",
+		"-c:a", "aac", "-b:a", "128k", "-ac", "2",
+		"-f", "hls",
+		"-hls_time", "4",
+		"-hls_list_size", "6",
+		"-hls_flags", "delete_segments+independent_segments+omit_endlist",
+		"-hls_segment_filename", filepath.Join(b.dir, "seg_%05d.ts"),
+		filepath.Join(b.dir, "index.m3u8"),
+	)
+	var encodeErr bytes.Buffer
+	encoder.Stderr = &encodeErr
+	sink, err := encoder.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := reader.Start(); err != nil {
+		return fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	if err := encoder.Start(); err != nil {
+		cancel()
+		reader.Wait()
+		return fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+
+	go ss.drawCaptions(ctx, session, b, captionPath, cancel)
+
+	// Returns once the stream ended and what was held back is encoded, or
+	// the encoder failed
+	ss.delayStream(ctx, source, sink, b.Delay)
+	stopped := ctx.Err() != nil
+	encodeWait := encoder.Wait()
+	cancel()
+	readWait := reader.Wait()
+
+	switch {
+	case stopped:
+		return nil
+	case encodeWait != nil:
+		return fmt.Errorf("ffmpeg error: %w: %s", encodeWait, strings.TrimSpace(lastLine(encodeErr.String())))
+	case readErr.Len() > 0:
+		return fmt.Errorf("ffmpeg error: %v: %s", readWait, strings.TrimSpace(lastLine(readErr.String())))
+	}
+	return nil
+}
+
+// delayStream copies src to dst, each chunk delay after it was read. dst is
+// closed once src ends and everything held back was written.
+func (ss *SubtitleService) delayStream(ctx context.Context, src io.Reader, dst io.WriteCloser, delay time.Duration) {
+	type chunk struct {
+		at   time.Time
+		data []byte
+	}
+	// Room for the delay at a few tens of Mbit/s, reading blocks beyond
+	held := make(chan chunk, 4096)
+
+	go func() {
+		defer close(held)
+		for {
+			buf := make([]byte, 64*1024)
+			n, err := src.Read(buf)
+			if n > 0 {
+				select {
+				case held <- chunk{at: time.Now(), data: buf[:n]}:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	defer dst.Close()
+	for c := range held {
+		if wait := time.Until(c.at.Add(delay)); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return
+			}
+		}
+		if _, err := dst.Write(c.data); err != nil {
+			return
+		}
+	}
+}
+
+// drawCaptions keeps the caption file drawn by the encoder on the subtitle
+// of the moment it encodes, delay behind live. It stops the stream when
+// nobody fetched it for a while.
+func (ss *SubtitleService) drawCaptions(ctx context.Context, session *SubtitleSession, b *burnIn, path string, stop context.CancelFunc) {
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+
+	playlist := filepath.Join(b.dir, "index.m3u8")
+	shown := ""
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		session.mu.Lock()
+		if b.Status == "starting" {
+			if _, err := os.Stat(playlist); err == nil {
+				b.Status = "running"
+			}
+		}
+		idle := time.Since(b.lastFetch) > burnInIdleTimeout
+		// The burn in delay already makes up for the pipeline's, only an
+		// offset set by hand moves captions further
+		shift := b.Delay
+		if !session.AutoOffset {
+			shift += time.Duration(session.Offset * float64(time.Second))
+		}
+		caption := session.captionAtLocked(b.Lang, time.Now().Add(-shift))
+		session.mu.Unlock()
+
+		if idle {
+			ss.sessionLogger(session).Info("burned in stream stopped, nobody watched it")
+			stop()
+			return
+		}
+		if caption == shown {
+			continue
+		}
+
+		// drawtext rereads the file every frame, so it is replaced at once
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, []byte(caption), 0644); err != nil {
+			continue
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			continue
+		}
+		shown = caption
+	}
+}
+
+// captionAtLocked returns the text of the entry spoken at a moment, in
+// lang, wrapped into lines. The caller holds session.mu.
+func (session *SubtitleSession) captionAtLocked(lang string, at time.Time) string {
+	for i := len(session.Subtitles) - 1; i >= 0; i-- {
+		entry := session.Subtitles[i]
+		if entry.wallStart.IsZero() || entry.wallStart.After(at) {
+			continue
+		}
+		duration := time.Duration((entry.EndTime - entry.StartTime) * float64(time.Second))
+		if at.Sub(entry.wallStart) > max(duration, minCaptionDuration) {
+			return ""
+		}
+		return wrapLines(inLanguage(entry, session, lang).Text, burnInLineLength)
+	}
+	return ""
+}
+
+// drawText converts the style to a drawtext filter drawing the text of
+// path, reread every frame. Sizes follow the ASS ones of forceStyle,
+// scaled from libass' 288 line script height to the picture's.
+func (s PreviewStyle) drawText(path string) string {
+	sizes := map[string]int{"small": 14, "medium": 18, "large": 24}
+
+	parts := []string{
+		"drawtext=textfile=" + path,
+		"reload=1",
+		"expansion=none",
+		"fontcolor=white",
+		"fontsize=h*" + strconv.Itoa(sizes[s.FontSize]) + "/288",
+		"line_spacing=6",
+		"x=(w-text_w)/2",
+	}
+	if s.Font != "" {
+		parts = append(parts, "font="+s.Font)
+	}
+
+	if s.Position == "top" {
+		parts = append(parts, "y=h*20/288")
+	} else {
+		parts = append(parts, "y=h-text_h-h*20/288")
+	}
+
+	switch s.Background {
+	case "semi":
+		parts = append(parts, "box=1", "boxcolor=black@0.6", "boxborderw=10")
+	case "solid":
+		parts = append(parts, "box=1", "boxcolor=black", "boxborderw=10")
+	default:
+		parts = append(parts, "borderw=2", "bordercolor=black", "shadowx=1", "shadowy=1")
+	}
+
+	return strings.Join(parts, ":")
+}
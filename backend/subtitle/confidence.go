@@ -0,0 +1,62 @@
+package subtitle
+
+import (
+	"fmt"
+	"math"
+)
+
+// Actions on entries recognized with a confidence below the threshold
+const (
+	LowConfidenceDrop = "drop" // Entries are discarded before translation
+	LowConfidenceFlag = "flag" // Entries are kept with low_confidence set
+)
+
+// DefaultMinConfidence is below what Whisper scores clear speech, and above
+// what it scores the text it makes up over music and silence
+const DefaultMinConfidence = 0.35
+
+// ConfidenceConfig sets what happens to entries the speech engine is
+// unsure of
+type ConfidenceConfig struct {
+	MinConfidence float64 `json:"min_confidence"` // From 0 to 1, 0 keeps every entry
+	Action        string  `json:"action"`         // drop or flag
+}
+
+// GetConfidenceConfig returns the confidence threshold of speech entries
+func (ss *SubtitleService) GetConfidenceConfig() ConfidenceConfig {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	return ss.config.Confidence
+}
+
+// UpdateConfidenceConfig sets the confidence threshold of speech entries,
+// from the next entry on
+func (ss *SubtitleService) UpdateConfidenceConfig(config ConfidenceConfig) error {
+	if math.IsNaN(config.MinConfidence) || config.MinConfidence < 0 || config.MinConfidence > 1 {
+		return fmt.Errorf("confidence threshold must be between 0 and 1")
+	}
+	switch config.Action {
+	case "":
+		config.Action = LowConfidenceDrop
+	case LowConfidenceDrop, LowConfidenceFlag:
+	default:
+		return fmt.Errorf("invalid low confidence action %q", config.Action)
+	}
+
+	ss.mu.Lock()
+	ss.config.Confidence = config
+	ss.mu.Unlock()
+
+	return nil
+}
+
+// lowConfidence reports whether an entry recognized with confidence falls
+// below the threshold, and whether it is then dropped. A confidence of 0
+// means the engine didn't report one and always passes.
+func (ss *SubtitleService) lowConfidence(confidence float64) (low, drop bool) {
+	config := ss.GetConfidenceConfig()
+	if confidence <= 0 || confidence >= config.MinConfidence {
+		return false, false
+	}
+	return true, config.Action != LowConfidenceFlag
+}
@@ -0,0 +1,243 @@
+package subtitle
+
+import (
+	"encoding/binary"
+	"math"
+	"math/cmplx"
+	"strconv"
+)
+
+// Speaker diarization labels entries with who is speaking. Each utterance
+// is summarized by its mean MFCCs, a rough voice print, and matched against
+// the voices heard so far in the session: the closest one when near enough,
+// else a new speaker. Good enough to tell a host from a guest, not to count
+// a crowd or follow overlapping speech.
+const (
+	diarizeWindow      = 512  // Samples per analysis frame, 32 ms at 16 kHz
+	diarizeHop         = 160  // Samples between frames, 10 ms at 16 kHz
+	diarizeMelBands    = 26   // Mel filters between 0 Hz and Nyquist
+	diarizeCoeffs      = 13   // MFCCs per frame, the first (loudness) is left out of voice prints
+	diarizeMinDuration = 1.0  // Seconds, shorter utterances keep the previous speaker
+	diarizeMaxDistance = 4.0  // Distance between voice prints of the same speaker
+	diarizeQuietDB     = 30.0 // Frames this far below the loudest are pauses, not voice
+)
+
+// voicePrinter computes voice prints of 16-bit mono PCM at one sample rate
+type voicePrinter struct {
+	window []float64   // Hamming window
+	mel    [][]float64 // Weights of each power spectrum bin per mel band
+	dct    [][]float64 // DCT-II basis, diarizeCoeffs x diarizeMelBands
+}
+
+func newVoicePrinter(sampleRate int) *voicePrinter {
+	vp := &voicePrinter{
+		window: make([]float64, diarizeWindow),
+		mel:    make([][]float64, diarizeMelBands),
+		dct:    make([][]float64, diarizeCoeffs),
+	}
+
+	for i := range vp.window {
+		vp.window[i] = 0.54 - 0.46*math.Cos(2*math.Pi*float64(i)/float64(diarizeWindow-1))
+	}
+
+	// Triangular filters evenly spaced on the mel scale
+	toMel := func(hz float64) float64 { return 2595 * math.Log10(1+hz/700) }
+	toHz := func(mel float64) float64 { return 700 * (math.Pow(10, mel/2595) - 1) }
+	bins := diarizeWindow/2 + 1
+	maxMel := toMel(float64(sampleRate) / 2)
+	edges := make([]float64, diarizeMelBands+2) // In spectrum bins
+	for i := range edges {
+		edges[i] = toHz(maxMel*float64(i)/float64(diarizeMelBands+1)) * diarizeWindow / float64(sampleRate)
+	}
+	for band := range vp.mel {
+		vp.mel[band] = make([]float64, bins)
+		lo, mid, hi := edges[band], edges[band+1], edges[band+2]
+		for bin := range vp.mel[band] {
+			f := float64(bin)
+			switch {
+			case f > lo && f <= mid:
+				vp.mel[band][bin] = (f - lo) / (mid - lo)
+			case f > mid && f < hi:
+				vp.mel[band][bin] = (hi - f) / (hi - mid)
+			}
+		}
+	}
+
+	for k := range vp.dct {
+		vp.dct[k] = make([]float64, diarizeMelBands)
+		for n := range vp.dct[k] {
+			vp.dct[k][n] = math.Cos(math.Pi * float64(k) * (float64(n) + 0.5) / diarizeMelBands)
+		}
+	}
+
+	return vp
+}
+
+// Print returns the voice print of an utterance, the mean of MFCCs 1 and up
+// over its voiced frames, or nil when it holds too little voice
+func (vp *voicePrinter) Print(pcm []byte) []float64 {
+	samples := make([]float64, len(pcm)/2)
+	for i := range samples {
+		samples[i] = float64(int16(binary.LittleEndian.Uint16(pcm[i*2:]))) / 32768
+	}
+	if len(samples) < diarizeWindow {
+		return nil
+	}
+
+	type frame struct {
+		coeffs []float64
+		db     float64
+	}
+	frames := make([]frame, 0, (len(samples)-diarizeWindow)/diarizeHop+1)
+	loudest := math.Inf(-1)
+
+	buf := make([]complex128, diarizeWindow)
+	bands := make([]float64, diarizeMelBands)
+	for start := 0; start+diarizeWindow <= len(samples); start += diarizeHop {
+		var energy float64
+		for i := range buf {
+			s := samples[start+i]
+			energy += s * s
+			buf[i] = complex(s*vp.window[i], 0)
+		}
+		fft(buf)
+
+		for band, weights := range vp.mel {
+			var sum float64
+			for bin, w := range weights {
+				if w != 0 {
+					p := cmplx.Abs(buf[bin])
+					sum += w * p * p
+				}
+			}
+			bands[band] = math.Log(sum + 1e-10)
+		}
+
+		coeffs := make([]float64, diarizeCoeffs-1)
+		for k := 1; k < diarizeCoeffs; k++ {
+			var sum float64
+			for n, b := range bands {
+				sum += b * vp.dct[k][n]
+			}
+			coeffs[k-1] = sum
+		}
+
+		db := 10 * math.Log10(energy/diarizeWindow+1e-10)
+		loudest = math.Max(loudest, db)
+		frames = append(frames, frame{coeffs: coeffs, db: db})
+	}
+
+	voicePrint := make([]float64, diarizeCoeffs-1)
+	voiced := 0
+	for _, f := range frames {
+		if f.db < loudest-diarizeQuietDB {
+			continue
+		}
+		for k, c := range f.coeffs {
+			voicePrint[k] += c
+		}
+		voiced++
+	}
+	if voiced < 10 {
+		return nil
+	}
+	for k := range voicePrint {
+		voicePrint[k] /= float64(voiced)
+	}
+	return voicePrint
+}
+
+// fft computes the discrete Fourier transform of x in place. len(x) must
+// be a power of two.
+func fft(x []complex128) {
+	n := len(x)
+
+	// Bit-reversal permutation
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j |= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				a, b := x[start+k], w*x[start+k+size/2]
+				x[start+k], x[start+k+size/2] = a+b, a-b
+				w *= step
+			}
+		}
+	}
+}
+
+// speakerTracker remembers the voices of a session. Utterances of a
+// session are transcribed one at a time, so it needs no lock.
+type speakerTracker struct {
+	voices []voice
+	last   int // Speaker of the previous utterance
+	max    int // New voices past this many go to the closest known one
+}
+
+type voice struct {
+	centroid []float64
+	count    int
+}
+
+// Assign returns the 1-based speaker of an utterance lasting duration
+// seconds, from its voice print
+func (st *speakerTracker) Assign(voicePrint []float64, duration float64) int {
+	if voicePrint == nil || duration < diarizeMinDuration {
+		if st.last == 0 {
+			st.last = 1
+		}
+		return st.last
+	}
+
+	best, bestDistance := -1, math.Inf(1)
+	for i, v := range st.voices {
+		var sum float64
+		for k, c := range voicePrint {
+			d := c - v.centroid[k]
+			sum += d * d
+		}
+		if d := math.Sqrt(sum); d < bestDistance {
+			best, bestDistance = i, d
+		}
+	}
+
+	if best == -1 || (bestDistance > diarizeMaxDistance && len(st.voices) < st.max) {
+		st.voices = append(st.voices, voice{centroid: append([]float64(nil), voicePrint...), count: 1})
+		st.last = len(st.voices)
+		return st.last
+	}
+
+	// Average the voice prints of a speaker, single utterances are noisy
+	v := &st.voices[best]
+	v.count++
+	for k, c := range voicePrint {
+		v.centroid[k] += (c - v.centroid[k]) / float64(v.count)
+	}
+	st.last = best + 1
+	return st.last
+}
+
+// SpeakerLabel names a speaker in exports
+func SpeakerLabel(speaker int) string {
+	return "Speaker " + strconv.Itoa(speaker)
+}
+
+// entryText returns the text of an entry as exported, prefixed with its
+// speaker when diarized
+func entryText(entry SubtitleEntry) string {
+	if entry.Speaker == 0 {
+		return entry.Text
+	}
+	return "- " + SpeakerLabel(entry.Speaker) + ": " + entry.Text
+}
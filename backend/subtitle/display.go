@@ -0,0 +1,53 @@
+package subtitle
+
+import "fmt"
+
+// DisplaySettings are a user's defaults for subtitles in the player, set
+// by each user for themselves
+type DisplaySettings struct {
+	Language       string `json:"language"`        // Spoken language of new sessions, ISO 639-1
+	TargetLanguage string `json:"target_language"` // Translated to, none when empty
+	AutoStart      bool   `json:"auto_start"`      // Start subtitles with playback
+	Position       string `json:"position"`        // bottom or top
+	FontSize       string `json:"font_size"`       // small, medium or large
+	Background     string `json:"background"`      // transparent, semi or solid
+}
+
+// DefaultDisplaySettings are the display settings of users who didn't
+// change them
+func DefaultDisplaySettings() DisplaySettings {
+	return DisplaySettings{
+		Language:   "en",
+		Position:   "bottom",
+		FontSize:   "medium",
+		Background: "semi",
+	}
+}
+
+// Validate checks the languages are known and the choices valid
+func (d DisplaySettings) Validate() error {
+	for _, lang := range []string{d.Language, d.TargetLanguage} {
+		if _, ok := languageNames[lang]; lang != "" && !ok {
+			return fmt.Errorf("unknown language %q", lang)
+		}
+	}
+
+	choices := []struct {
+		name, value string
+		allowed     []string
+	}{
+		{"position", d.Position, []string{"bottom", "top"}},
+		{"font_size", d.FontSize, []string{"small", "medium", "large"}},
+		{"background", d.Background, []string{"transparent", "semi", "solid"}},
+	}
+	for _, choice := range choices {
+		valid := false
+		for _, allowed := range choice.allowed {
+			valid = valid || choice.value == allowed
+		}
+		if !valid {
+			return fmt.Errorf("invalid %s %q, one of %v", choice.name, choice.value, choice.allowed)
+		}
+	}
+	return nil
+}
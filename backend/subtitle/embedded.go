@@ -0,0 +1,253 @@
+package subtitle
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Where the subtitles of a session come from
+const (
+	SourceSpeech         = "speech"          // Speech recognition of the audio
+	SourceTeletext       = "teletext"        // DVB teletext subtitle pages
+	SourceClosedCaptions = "closed_captions" // CEA-608 captions carried in the video
+)
+
+// embeddedTrack is a text subtitle track found in a stream
+type embeddedTrack struct {
+	Source   string
+	Index    int    // Index among the subtitle streams, for teletext
+	Language string // ISO 639-2 tag, empty when unknown
+}
+
+// iso639 maps ISO 639-1 session languages to the ISO 639-2 tags of
+// broadcast tracks (both bibliographic and terminologic forms)
+var iso639 = map[string][]string{
+	"en": {"eng"},
+	"fr": {"fra", "fre"},
+	"de": {"deu", "ger"},
+	"es": {"spa"},
+	"it": {"ita"},
+	"pt": {"por"},
+	"nl": {"nld", "dut"},
+	"sv": {"swe"},
+	"da": {"dan"},
+	"no": {"nor", "nob", "nno"},
+	"fi": {"fin"},
+	"pl": {"pol"},
+	"cs": {"ces", "cze"},
+	"el": {"ell", "gre"},
+	"tr": {"tur"},
+	"ru": {"rus"},
+	"ar": {"ara"},
+	"zh": {"zho", "chi"},
+	"ja": {"jpn"},
+	"ko": {"kor"},
+}
+
+// trackLanguageCode returns the ISO 639-1 code of a track tag, empty when
+// the tag is unknown
+func trackLanguageCode(tag string) string {
+	tag = strings.ToLower(tag)
+	for code, tags := range iso639 {
+		if tag == code || slices.Contains(tags, tag) {
+			return code
+		}
+	}
+	return ""
+}
+
+// languageMatches reports whether a track tag fits a session language.
+// Untagged tracks are accepted.
+func languageMatches(tag, language string) bool {
+	tag = strings.ToLower(tag)
+	if tag == "" || tag == "und" || language == "" {
+		return true
+	}
+	if tag == language {
+		return true
+	}
+	for _, code := range iso639[language] {
+		if tag == code {
+			return true
+		}
+	}
+	return false
+}
+
+// findEmbeddedTrack probes a stream for a text subtitle track in language.
+// Bitmap tracks (DVB subtitles) would need OCR and are ignored. inputArgs
+// are the options to read the stream with.
+func findEmbeddedTrack(ctx context.Context, streamURL, language string, inputArgs []string) (*embeddedTrack, error) {
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Second)
+	defer cancel()
+
+	args := append([]string{
+		"-v", "error",
+		"-print_format", "json",
+		"-show_streams",
+	}, inputArgs...)
+	cmd := exec.CommandContext(ctx, "ffprobe", append(args, streamURL)...)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe failed: %w", err)
+	}
+
+	var result struct {
+		Streams []struct {
+			CodecType      string            `json:"codec_type"`
+			CodecName      string            `json:"codec_name"`
+			ClosedCaptions int               `json:"closed_captions"`
+			Tags           map[string]string `json:"tags"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+
+	subtitleIndex := 0
+	var captions *embeddedTrack
+	for _, stream := range result.Streams {
+		switch stream.CodecType {
+		case "subtitle":
+			if stream.CodecName == "dvb_teletext" && languageMatches(stream.Tags["language"], language) {
+				return &embeddedTrack{Source: SourceTeletext, Index: subtitleIndex, Language: stream.Tags["language"]}, nil
+			}
+			subtitleIndex++
+		case "video":
+			// Captions carry no language tag, prefer a matching teletext track
+			if stream.ClosedCaptions > 0 && captions == nil {
+				captions = &embeddedTrack{Source: SourceClosedCaptions}
+			}
+		}
+	}
+
+	return captions, nil
+}
+
+// processEmbedded converts an embedded subtitle track to subtitle entries
+// with ffmpeg, which writes each cue to stdout as SRT as soon as it's decoded
+func (ss *SubtitleService) processEmbedded(session *SubtitleSession, track *embeddedTrack) error {
+	var args []string
+	switch track.Source {
+	case SourceTeletext:
+		args = append([]string{
+			"-txt_format", "text",
+			"-txt_page", "subtitle",
+		}, ss.inputOptions(session.StreamURL)...)
+		args = append(args,
+			"-i", session.StreamURL,
+			"-map", "0:s:"+strconv.Itoa(track.Index),
+		)
+	case SourceClosedCaptions:
+		// The subcc output of the movie source exposes captions as a stream.
+		// The movie source opens the URL itself, without the provider's
+		// headers.
+		args = []string{
+			"-f", "lavfi",
+			"-i", "movie=" + lavfiEscape(session.StreamURL) + "[out0+subcc]",
+			"-map", "0:s",
+		}
+	default:
+		return fmt.Errorf("unsupported subtitle source %q", track.Source)
+	}
+	args = append(args, "-loglevel", "error", "-c:s", "srt", "-f", "srt", "-")
+
+	cmd := exec.CommandContext(session.ctx, "ffmpeg", args...)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to create stdout pipe: %w", err)
+	}
+
+	session.ffmpegCmd = cmd
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	session.markStreamStart()
+
+	cues := 0
+	readSRT(stdout, func(start, end float64, text string) {
+		cues++
+		ss.addEntry(session, text, start, end, 0, nil, 0, time.Now())
+	})
+
+	err = cmd.Wait()
+	if session.ctx.Err() != nil {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("ffmpeg error: %w", err)
+	}
+	if cues == 0 {
+		return fmt.Errorf("no subtitles in %s track", track.Source)
+	}
+
+	return nil
+}
+
+// readSRT parses SRT cues from r as they arrive and calls fn for each
+func readSRT(r io.Reader, fn func(start, end float64, text string)) {
+	scanner := bufio.NewScanner(r)
+
+	var start, end float64
+	var timed bool
+	var lines []string
+
+	flush := func() {
+		if timed && len(lines) > 0 {
+			fn(start, end, strings.Join(lines, "\n"))
+		}
+		timed = false
+		lines = nil
+	}
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+
+		switch {
+		case line == "":
+			flush()
+		case !timed && strings.Contains(line, "-->"):
+			parts := strings.SplitN(line, "-->", 2)
+			s, errStart := parseSRTTime(parts[0])
+			e, errEnd := parseSRTTime(parts[1])
+			if errStart == nil && errEnd == nil {
+				start, end, timed = s, e, true
+			}
+		case timed:
+			lines = append(lines, line)
+		}
+		// Cue numbers before the timing line are skipped
+	}
+	flush()
+}
+
+// parseSRTTime parses "HH:MM:SS,mmm" into seconds
+func parseSRTTime(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+	if i := strings.IndexByte(value, ' '); i >= 0 {
+		value = value[:i] // Position hints after the time
+	}
+
+	var h, m, s, ms int
+	if _, err := fmt.Sscanf(strings.Replace(value, ".", ",", 1), "%d:%d:%d,%d", &h, &m, &s, &ms); err != nil {
+		return 0, err
+	}
+	return float64(h*3600+m*60+s) + float64(ms)/1000, nil
+}
+
+// lavfiEscape quotes a URL for use as the movie filter's file name, which
+// goes through both the option parser and the filtergraph parser
+func lavfiEscape(value string) string {
+	option := strings.NewReplacer(`\`, `\\`, `'`, `\'`, `:`, `\:`).Replace(value)
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`, `[`, `\[`, `]`, `\]`, `,`, `\,`, `;`, `\;`).Replace(option)
+}
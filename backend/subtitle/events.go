@@ -0,0 +1,108 @@
+package subtitle
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// notifyLocked wakes whoever waits for the next change of a session: an
+// entry added or completed, its status, language or partial text. The
+// caller holds session.mu for writing.
+func (session *SubtitleSession) notifyLocked() {
+	if session.changed != nil {
+		close(session.changed)
+		session.changed = nil
+	}
+}
+
+// Watch returns a channel closed at the next change of a session, for
+// viewers streaming it instead of polling. Like polling, watching keeps
+// the session alive.
+func (ss *SubtitleService) Watch(sessionID string) (<-chan struct{}, error) {
+	ss.mu.RLock()
+	session, exists := ss.sessions[sessionID]
+	ss.mu.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrSessionNotFound, sessionID)
+	}
+
+	session.touch()
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if session.changed == nil {
+		session.changed = make(chan struct{})
+	}
+	return session.changed, nil
+}
+
+// FollowFunc receives the events of a followed session: entry, with the
+// revision it brings the session to, status, partial, end and keepalive
+type FollowFunc func(event string, revision int, data interface{}) error
+
+// Follow sends the changes of a session after revision, in lang, as they
+// happen: entries added or completed, the session when its status,
+// language, source or offset changes, and the words of the utterance in
+// progress. It returns once an end event was sent, when ctx is done, or
+// with the error of send. A keepalive event is sent after keepalive
+// without changes, so idle connections stay open.
+func (ss *SubtitleService) Follow(ctx context.Context, sessionID string, revision int, lang string, keepalive time.Duration, send FollowFunc) error {
+	ticker := time.NewTicker(keepalive)
+	defer ticker.Stop()
+
+	end := func(reason string) error {
+		return send("end", revision, map[string]string{"reason": reason})
+	}
+
+	var status, partial string
+	for {
+		// Watch before reading, not to miss a change in between
+		changed, err := ss.Watch(sessionID)
+		if err != nil {
+			return end("session_not_found")
+		}
+
+		entries, current, err := ss.GetSubtitleUpdates(sessionID, revision, lang)
+		if err != nil {
+			return end("session_not_found")
+		}
+		for _, entry := range entries {
+			if err := send("entry", current, entry); err != nil {
+				return err
+			}
+		}
+		revision = current
+
+		info, exists := ss.GetSession(sessionID)
+		if !exists {
+			return end("session_not_found")
+		}
+		if key := fmt.Sprintf("%s|%s|%s|%g", info.Status, info.Language, info.Source, info.Offset); key != status {
+			status = key
+			if err := send("status", revision, info); err != nil {
+				return err
+			}
+		}
+		if info.Partial != partial {
+			partial = info.Partial
+			if err := send("partial", revision, map[string]string{"text": partial}); err != nil {
+				return err
+			}
+		}
+		if info.Status == "stopped" || info.Status == "error" {
+			return end(info.Status)
+		}
+
+		select {
+		case <-changed:
+		case <-ticker.C:
+			if err := send("keepalive", revision, nil); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package subtitle
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Subtitle export formats
+const (
+	FormatSRT = "srt"
+	FormatVTT = "vtt" // WebVTT, for <track> elements in browsers
+	FormatASS = "ass" // Advanced SubStation Alpha, for players and ffmpeg burn-in
+)
+
+// exportTimeFormat stamps export file names, <sessionID>_<time>.<format>
+const exportTimeFormat = "20060102_150405"
+
+// exportName returns the file name of an export of a session made at t
+func exportName(sessionID, format string, t time.Time) string {
+	return sessionID + "_" + t.Format(exportTimeFormat) + "." + format
+}
+
+// ExportSession returns the session an export file name belongs to, false
+// for names that aren't exports, such as the translation cache. Session IDs
+// may contain underscores, so the name is read from its end.
+func ExportSession(name string) (string, bool) {
+	if strings.ContainsAny(name, `/\`) {
+		return "", false
+	}
+
+	ext := path.Ext(name)
+	switch strings.TrimPrefix(ext, ".") {
+	case FormatSRT, FormatVTT, FormatASS:
+	default:
+		return "", false
+	}
+
+	base := strings.TrimSuffix(name, ext)
+	cut := len(base) - len(exportTimeFormat) - 1
+	if cut <= 0 || base[cut] != '_' {
+		return "", false
+	}
+	if _, err := time.Parse(exportTimeFormat, base[cut+1:]); err != nil {
+		return "", false
+	}
+	return base[:cut], true
+}
+
+// FormatContentType returns the MIME type of an export format
+func FormatContentType(format string) string {
+	switch format {
+	case FormatVTT:
+		return "text/vtt; charset=utf-8"
+	case FormatASS:
+		return "text/x-ssa; charset=utf-8"
+	default:
+		return "application/x-subrip; charset=utf-8"
+	}
+}
+
+// FormatSubtitles renders entries in format. Entries with word timings
+// are split when too long for one cue.
+func FormatSubtitles(subtitles []SubtitleEntry, format string) (string, error) {
+	subtitles = splitEntries(subtitles)
+
+	switch format {
+	case FormatSRT:
+		return formatSRT(subtitles), nil
+	case FormatVTT:
+		return formatVTT(subtitles), nil
+	case FormatASS:
+		return formatASS(subtitles), nil
+	default:
+		return "", fmt.Errorf("unsupported subtitle format %q", format)
+	}
+}
+
+// formatVTT renders entries as WebVTT. Cues with word timings get a
+// timestamp tag before each word, for karaoke styling with ::cue(:past).
+func formatVTT(subtitles []SubtitleEntry) string {
+	var buf strings.Builder
+	buf.WriteString("WEBVTT\n\n")
+
+	// Cue text is HTML-like: escape markup, which also rules out "-->"
+	escape := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+	for i, sub := range subtitles {
+		buf.WriteString(strconv.Itoa(i + 1))
+		buf.WriteString("\n")
+		buf.WriteString(formatTimestamp(sub.StartTime, '.'))
+		buf.WriteString(" --> ")
+		buf.WriteString(formatTimestamp(sub.EndTime, '.'))
+		buf.WriteString("\n")
+		if words := alignedWords(sub); len(words) > 1 {
+			// Timestamps must increase within the cue and stay inside it
+			text := make([]string, len(words))
+			last := sub.StartTime
+			for j, w := range words {
+				text[j] = escape.Replace(w.Word)
+				if j > 0 && w.Start > last && w.Start < sub.EndTime {
+					text[j] = "<" + formatTimestamp(w.Start, '.') + ">" + text[j]
+					last = w.Start
+				}
+			}
+			sub.Text = strings.Join(text, " ")
+			buf.WriteString(strings.TrimSpace(entryText(sub)))
+		} else {
+			buf.WriteString(escape.Replace(strings.TrimSpace(entryText(sub))))
+		}
+		buf.WriteString("\n\n")
+	}
+
+	return buf.String()
+}
+
+// assHeader declares a script with one style matching the medium preview
+// style, on libass' default 384x288 canvas
+const assHeader = `[Script Info]
+ScriptType: v4.00+
+PlayResX: 384
+PlayResY: 288
+WrapStyle: 0
+ScaledBorderAndShadow: yes
+
+[V4+ Styles]
+Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding
+Style: Default,Arial,18,&H00FFFFFF,&H000000FF,&H00000000,&H80000000,0,0,0,0,100,100,0,0,1,1,1,2,10,10,20,1
+
+[Events]
+Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text
+`
+
+// formatASS renders entries as an ASS script
+func formatASS(subtitles []SubtitleEntry) string {
+	var buf strings.Builder
+	buf.WriteString(assHeader)
+
+	// Braces start override tags, and line breaks are written as \N
+	escape := strings.NewReplacer("{", "(", "}", ")", "\r\n", `\N`, "\n", `\N`)
+
+	for _, sub := range subtitles {
+		fmt.Fprintf(&buf, "Dialogue: 0,%s,%s,Default,,0,0,0,,%s\n",
+			formatASSTime(sub.StartTime),
+			formatASSTime(sub.EndTime),
+			escape.Replace(strings.TrimSpace(entryText(sub))),
+		)
+	}
+
+	return buf.String()
+}
+
+// formatTimestamp formats seconds as HH:MM:SS followed by milliseconds
+// after sep, "," for SRT and "." for WebVTT
+func formatTimestamp(seconds float64, sep byte) string {
+	millis := int64(math.Round(seconds * 1000))
+	if millis < 0 {
+		millis = 0
+	}
+	return fmt.Sprintf("%02d:%02d:%02d%c%03d", millis/3600000, millis/60000%60, millis/1000%60, sep, millis%1000)
+}
+
+// formatASSTime formats seconds as H:MM:SS.cc, ASS times are in centiseconds
+func formatASSTime(seconds float64) string {
+	centis := int64(math.Round(seconds * 100))
+	if centis < 0 {
+		centis = 0
+	}
+	return fmt.Sprintf("%d:%02d:%02d.%02d", centis/360000, centis/6000%60, centis/100%60, centis%100)
+}
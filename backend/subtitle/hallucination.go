@@ -0,0 +1,184 @@
+package subtitle
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Speech engines, Whisper above all, make up text during jingles, music and
+// silence: credits of the subtitles they were trained on, video outros and
+// words repeated over and over. Such entries are dropped before they are
+// translated and stored.
+
+// hallucinationPhrases are the lines Whisper is known to make up. An entry
+// made only of them is dropped. Normalized at init, longest first.
+var hallucinationPhrases = []string{
+	// English
+	"Thanks for watching!", "Thank you for watching.", "Thank you so much for watching.",
+	"Please subscribe.", "Please subscribe to my channel.", "Subscribe to my channel.",
+	"Like and subscribe.", "Don't forget to like and subscribe.", "See you in the next video.",
+	"See you next time.", "Subtitles by the Amara.org community", "Transcribed by ESO, translated by —",
+	"Thank you.", "Thanks.", "You",
+	// French
+	"Merci d'avoir regardé cette vidéo !", "Merci d'avoir regardé !", "Sous-titrage ST' 501",
+	"Sous-titres réalisés par la communauté d'Amara.org", "Abonnez-vous !",
+	"N'hésitez pas à vous abonner.", "Merci.",
+	// Spanish
+	"¡Gracias por ver el video!", "Gracias por ver.", "¡Suscríbete!",
+	"Subtítulos realizados por la comunidad de Amara.org",
+	// German
+	"Untertitel im Auftrag des ZDF für funk, 2017", "Untertitel im Auftrag des ZDF, 2018",
+	"Untertitel der Amara.org-Community", "Vielen Dank fürs Zuschauen!", "Danke fürs Zuschauen!",
+	// Italian, Portuguese, Dutch
+	"Grazie per la visione!", "Sottotitoli creati dalla comunità Amara.org", "Obrigado por assistir!",
+	"Ondertiteld door de Amara.org gemeenschap",
+	// Russian, Japanese, Chinese, Korean
+	"Продолжение следует...", "Спасибо за просмотр!", "ご視聴ありがとうございました",
+	"字幕由Amara.org社区提供", "시청해주셔서 감사합니다.",
+}
+
+func init() {
+	for i, phrase := range hallucinationPhrases {
+		hallucinationPhrases[i] = normalizePhrase(phrase)
+	}
+	sort.SliceStable(hallucinationPhrases, func(i, j int) bool {
+		return len(hallucinationPhrases[i]) > len(hallucinationPhrases[j])
+	})
+}
+
+// nonSpeech matches the annotations engines write for sounds, like [Music],
+// (applause) and ♪, which carry no speech
+var nonSpeech = regexp.MustCompile(`\[[^\]]*\]|\([^)]*\)|\*[^*]*\*|[♪♫♬🎵🎶]`)
+
+// Repeated words: a run of the same one to four words this many times over
+// most of an entry is a decoding loop, not speech
+const (
+	minRepeats      = 4
+	maxRepeatNgram  = 4
+	repeatedPortion = 0.6
+)
+
+// normalizePhrase lowercases text and removes its punctuation
+func normalizePhrase(text string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsNumber(r) || r == '-':
+			b.WriteRune(r)
+			space = false
+		case unicode.IsSpace(r):
+			if !space && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			space = true
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// isHallucination reports whether recognized text is likely made up: only
+// sound annotations, only known made up phrases, or a word loop
+func isHallucination(text string) (bool, string) {
+	text = normalizePhrase(nonSpeech.ReplaceAllString(text, " "))
+	if text == "" {
+		return true, "non_speech"
+	}
+
+	// Longer phrases go first, so shorter ones don't cut them
+	rest := " " + text + " "
+	for _, phrase := range hallucinationPhrases {
+		for strings.Contains(rest, " "+phrase+" ") {
+			rest = strings.Replace(rest, " "+phrase+" ", " ", 1)
+		}
+	}
+	if strings.TrimSpace(rest) == "" {
+		return true, "blocklist"
+	}
+
+	if repeatedWords(strings.Fields(text)) {
+		return true, "repetition"
+	}
+	return false, ""
+}
+
+// repeatedWords reports whether a run of the same n-gram repeated at least
+// minRepeats times covers most of words
+func repeatedWords(words []string) bool {
+	for n := 1; n <= maxRepeatNgram; n++ {
+		for start := 0; start+n*minRepeats <= len(words); start++ {
+			repeats := 1
+			for next := start + n; next+n <= len(words) && sameWords(words[start:start+n], words[next:next+n]); next += n {
+				repeats++
+			}
+			if repeats >= minRepeats && float64(repeats*n) >= repeatedPortion*float64(len(words)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func sameWords(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// suppressHallucination reports whether recognized text is dropped as made
+// up, counting it on the session
+func (ss *SubtitleService) suppressHallucination(session *SubtitleSession, text string) bool {
+	if !ss.config.HallucinationFilter {
+		return false
+	}
+	hallucination, reason := isHallucination(text)
+	if hallucination {
+		ss.dropHallucination(session, reason, text)
+	}
+	return hallucination
+}
+
+// dropHallucination counts an entry dropped as made up on the session
+func (ss *SubtitleService) dropHallucination(session *SubtitleSession, reason, text string) {
+	session.mu.Lock()
+	session.DroppedEntries++
+	session.mu.Unlock()
+
+	ss.sessionLogger(session).Debug("subtitle entry dropped as hallucination", "reason", reason, "text", text)
+}
+
+// speechGate tells whether audio streamed to Vosk between two results held
+// speech, with the energy classifier of the segmenter. Vosk needs the
+// silence to end utterances, so all audio is sent, and results of audio
+// without speech are dropped.
+type speechGate struct {
+	vad    *segmenter
+	speech int // Speech frames since the last result
+}
+
+func newSpeechGate(sampleRate int) *speechGate {
+	return &speechGate{vad: newSegmenter(sampleRate, 0, 0)}
+}
+
+// Push classifies the frames of a chunk of audio
+func (g *speechGate) Push(chunk []byte) {
+	size := g.vad.frameSize
+	for i := 0; i+size <= len(chunk); i += size {
+		if g.vad.classify(frameEnergy(chunk[i : i+size])) {
+			g.speech++
+		}
+	}
+}
+
+// Result reports whether the audio since the previous result held enough
+// speech for a result, and starts over
+func (g *speechGate) Result() bool {
+	heard := g.speech >= g.vad.minSpeech
+	g.speech = 0
+	return heard
+}
@@ -0,0 +1,110 @@
+package subtitle
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"iptv-backend/health"
+)
+
+// HealthChecks returns the checks of the speech engine and the translation
+// server the configuration uses
+func (ss *SubtitleService) HealthChecks() []health.Check {
+	config := ss.GetConfig()
+	script := config.Engine == EngineWhisper && config.WhisperServerURL == ""
+
+	checks := []health.Check{
+		{
+			Name:     "python",
+			Required: script,
+			Probe:    health.Command("python3", "--version"),
+			Fix:      "Install Python 3, or set WHISPER_SERVER_URL to transcribe with a whisper.cpp server",
+		},
+		{
+			Name:     "faster_whisper",
+			Required: script,
+			Probe:    fasterWhisperProbe,
+			Fix:      "Install the Whisper script's dependencies with pip install faster-whisper, and keep scripts/transcribe.py next to the binary",
+		},
+		{
+			Name:     "whisper_server",
+			Required: config.Engine == EngineWhisper && config.WhisperServerURL != "",
+			Probe:    health.TCP(config.WhisperServerURL),
+			Fix:      "Start the whisper.cpp server at WHISPER_SERVER_URL, or leave it empty to run faster-whisper locally",
+		},
+		{
+			Name:     "vosk",
+			Required: config.Engine == EngineVosk,
+			Probe:    health.TCP(config.VoskServerURL),
+			Fix:      "Start a Vosk server, such as docker run -p 2700:2700 alphacep/kaldi-en, at VOSK_SERVER_URL",
+		},
+		{
+			Name:  "ollama",
+			Probe: ollamaProbe(config.OllamaURL, config.OllamaModel),
+			Fix:   "Start Ollama and pull the translation model, or change its URL and model with POST /api/subtitle/ollama/config. Subtitles aren't translated meanwhile.",
+		},
+	}
+
+	if !script {
+		checks[0].Disabled = "not used: the " + config.Engine + " engine or a Whisper server transcribes"
+		checks[1].Disabled = checks[0].Disabled
+	}
+	if !checks[2].Required {
+		checks[2].Disabled = "not used: WHISPER_SERVER_URL is empty or the engine isn't whisper"
+	}
+	if !checks[3].Required {
+		checks[3].Disabled = "not used: SUBTITLE_ENGINE isn't vosk"
+	}
+	return checks
+}
+
+// fasterWhisperProbe checks the transcription script is installed and can
+// import faster-whisper
+func fasterWhisperProbe(ctx context.Context) (string, string, error) {
+	scriptPath := filepath.Join(filepath.Dir(os.Args[0]), "scripts", "transcribe.py")
+	if _, err := os.Stat(scriptPath); err != nil {
+		return "", scriptPath, fmt.Errorf("transcription script missing: %w", err)
+	}
+
+	version, _, err := health.Command("python3", "-c", "import faster_whisper; print(faster_whisper.__version__)")(ctx)
+	return version, scriptPath, err
+}
+
+// ollamaProbe checks Ollama answers and has the translation model
+func ollamaProbe(ollamaURL, model string) health.ProbeFunc {
+	base := strings.TrimRight(ollamaURL, "/")
+	return func(ctx context.Context) (string, string, error) {
+		version, target, err := health.HTTP(base+"/api/version", func(body []byte) (string, error) {
+			var response struct {
+				Version string `json:"version"`
+			}
+			json.Unmarshal(body, &response)
+			return response.Version, nil
+		})(ctx)
+		if err != nil {
+			return "", target, err
+		}
+
+		_, _, err = health.HTTP(base+"/api/tags", func(body []byte) (string, error) {
+			var response struct {
+				Models []struct {
+					Name string `json:"name"`
+				} `json:"models"`
+			}
+			if err := json.Unmarshal(body, &response); err != nil {
+				return "", fmt.Errorf("unexpected answer: %w", err)
+			}
+			for _, m := range response.Models {
+				if m.Name == model || strings.TrimSuffix(m.Name, ":latest") == model {
+					return "", nil
+				}
+			}
+			return "", fmt.Errorf("model %s isn't pulled, run ollama pull %s", model, model)
+		})(ctx)
+		return version, target, err
+	}
+}
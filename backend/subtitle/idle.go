@@ -0,0 +1,92 @@
+package subtitle
+
+import (
+	"fmt"
+	"time"
+)
+
+// Why a session was stopped
+const (
+	StopReasonUser        = "user"         // Stopped through the API
+	StopReasonIdle        = "idle"         // No viewer polled or sent a heartbeat for IdleTimeout
+	StopReasonMaxDuration = "max_duration" // Ran longer than MaxSessionDuration
+)
+
+// idleCheckInterval is how often sessions are checked for idleness
+const idleCheckInterval = 30 * time.Second
+
+// touch records that a viewer is still consuming the session
+func (session *SubtitleSession) touch() {
+	session.lastSeen.Store(time.Now().UnixNano())
+}
+
+// lastSeenAt returns when a viewer last polled the session
+func (session *SubtitleSession) lastSeenAt() time.Time {
+	return time.Unix(0, session.lastSeen.Load())
+}
+
+// Heartbeat keeps a session alive for a viewer that doesn't poll for
+// subtitles, e.g. one receiving them through webhooks
+func (ss *SubtitleService) Heartbeat(sessionID string) (*SessionInfo, error) {
+	ss.mu.RLock()
+	session, exists := ss.sessions[sessionID]
+	ss.mu.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("session %s not found", sessionID)
+	}
+
+	session.touch()
+
+	session.mu.RLock()
+	defer session.mu.RUnlock()
+	info := session.infoForLocked(sessionID)
+	return &info, nil
+}
+
+// idleLoop stops sessions nobody consumes anymore and sessions past the
+// maximum lifetime, so ffmpeg and Whisper don't run for nobody
+func (ss *SubtitleService) idleLoop() {
+	ticker := time.NewTicker(idleCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ss.stopExpiredSessions()
+	}
+}
+
+// stopExpiredSessions stops the running sessions that are idle or too old
+func (ss *SubtitleService) stopExpiredSessions() {
+	now := time.Now()
+
+	type expired struct {
+		session *SubtitleSession
+		reason  string
+	}
+	var sessions []expired
+
+	ss.mu.RLock()
+	for _, session := range ss.distinctSessionsLocked() {
+		session.mu.RLock()
+		running := session.Status == "starting" || session.Status == "running"
+		createdAt := session.CreatedAt
+		session.mu.RUnlock()
+
+		if !running {
+			continue
+		}
+
+		switch {
+		case ss.config.MaxSessionDuration > 0 && now.Sub(createdAt) > ss.config.MaxSessionDuration:
+			sessions = append(sessions, expired{session, StopReasonMaxDuration})
+		case ss.config.IdleTimeout > 0 && now.Sub(session.lastSeenAt()) > ss.config.IdleTimeout:
+			sessions = append(sessions, expired{session, StopReasonIdle})
+		}
+	}
+	ss.mu.RUnlock()
+
+	for _, e := range sessions {
+		ss.sessionLogger(e.session).Info("auto-stopping subtitle session", "reason", e.reason, "last_seen_at", e.session.lastSeenAt())
+		ss.stopSession(e.session, e.reason)
+	}
+}
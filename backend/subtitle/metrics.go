@@ -0,0 +1,150 @@
+package subtitle
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"time"
+)
+
+// metricsWindow is how many recent samples each stage keeps
+const metricsWindow = 100
+
+// StageMetrics summarizes the recent durations of a processing stage
+type StageMetrics struct {
+	Count  int     `json:"count"` // Samples since the session started
+	LastMs float64 `json:"last_ms"`
+	AvgMs  float64 `json:"avg_ms"`
+	P95Ms  float64 `json:"p95_ms"`
+	MaxMs  float64 `json:"max_ms"`
+}
+
+// SessionMetrics breaks the delay of a session's subtitles down by stage.
+// Averages, percentiles and maximums cover the last 100 entries.
+type SessionMetrics struct {
+	SessionID     string       `json:"session_id"`
+	Status        string       `json:"status"`
+	Source        string       `json:"source,omitempty"`
+	Backend       string       `json:"backend,omitempty"` // Speech backend, for the speech source
+	Entries       int          `json:"entries"`
+	QueuedChunks  int          `json:"queued_chunks"`
+	DroppedChunks int          `json:"dropped_chunks"`
+	AudioWait     StageMetrics `json:"audio_wait"`           // End of the utterance in the stream to transcription start: pause detection and queueing
+	Transcription StageMetrics `json:"transcription"`        // Whisper
+	Translation   StageMetrics `json:"translation"`          // Ollama, for all target languages in parallel
+	Lag           StageMetrics `json:"lag"`                  // End of the utterance in the stream to the entry being shown
+	Bottleneck    string       `json:"bottleneck,omitempty"` // audio_wait, transcription or translation, the slowest on average
+	Delay         StageMetrics `json:"delay"`                // Start of the utterance in the stream to the entry being shown
+}
+
+// stageSamples keeps the recent durations of a stage, in milliseconds
+type stageSamples struct {
+	count   int
+	samples []float64 // Ring buffer of the last metricsWindow samples
+}
+
+func (s *stageSamples) add(d time.Duration) {
+	ms := math.Max(0, float64(d.Microseconds())/1000)
+	if len(s.samples) < metricsWindow {
+		s.samples = append(s.samples, ms)
+	} else {
+		s.samples[s.count%metricsWindow] = ms
+	}
+	s.count++
+}
+
+func (s *stageSamples) summary() StageMetrics {
+	metrics := StageMetrics{Count: s.count}
+	if s.count == 0 {
+		return metrics
+	}
+
+	metrics.LastMs = s.samples[(s.count-1)%metricsWindow]
+
+	sorted := slices.Clone(s.samples)
+	slices.Sort(sorted)
+	var sum float64
+	for _, ms := range sorted {
+		sum += ms
+	}
+	metrics.AvgMs = sum / float64(len(sorted))
+	metrics.P95Ms = sorted[int(math.Ceil(0.95*float64(len(sorted))))-1]
+	metrics.MaxMs = sorted[len(sorted)-1]
+	return metrics
+}
+
+// sessionMetrics holds the stage timings of a session, guarded by
+// session.mu
+type sessionMetrics struct {
+	audioWait     stageSamples
+	transcription stageSamples
+	translation   stageSamples
+	lag           stageSamples
+	delay         stageSamples // Start of the utterance in the stream to the entry being shown
+}
+
+// streamTime returns the wall clock time at which the audio at offset
+// seconds of the stream was received, zero before ffmpeg started. The
+// caller holds session.mu.
+func (session *SubtitleSession) streamTime(offset float64) time.Time {
+	if session.streamStart.IsZero() {
+		return time.Time{}
+	}
+	return session.streamStart.Add(time.Duration(offset * float64(time.Second)))
+}
+
+// record adds a sample to stage, one of the session.metrics fields
+func (session *SubtitleSession) record(stage *stageSamples, d time.Duration) {
+	session.mu.Lock()
+	stage.add(d)
+	session.mu.Unlock()
+}
+
+// GetSessionMetrics returns the timing breakdown of a session
+func (ss *SubtitleService) GetSessionMetrics(sessionID string) (*SessionMetrics, error) {
+	ss.mu.RLock()
+	session, exists := ss.sessions[sessionID]
+	ss.mu.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("session %s not found", sessionID)
+	}
+
+	queued := ss.transcriber.Queued(session)
+
+	session.mu.RLock()
+	defer session.mu.RUnlock()
+
+	metrics := &SessionMetrics{
+		SessionID:     session.ID,
+		Status:        session.Status,
+		Source:        session.Source,
+		Entries:       session.entryCounter,
+		QueuedChunks:  queued,
+		DroppedChunks: session.DroppedChunks,
+		AudioWait:     session.metrics.audioWait.summary(),
+		Transcription: session.metrics.transcription.summary(),
+		Translation:   session.metrics.translation.summary(),
+		Lag:           session.metrics.lag.summary(),
+		Delay:         session.metrics.delay.summary(),
+	}
+	if session.Source == SourceSpeech {
+		metrics.Backend = ss.speech.Name()
+		if ss.config.Engine == EngineVosk {
+			metrics.Backend = EngineVosk
+		}
+	}
+
+	slowest := 0.0
+	for stage, m := range map[string]StageMetrics{
+		"audio_wait":    metrics.AudioWait,
+		"transcription": metrics.Transcription,
+		"translation":   metrics.Translation,
+	} {
+		if m.Count > 0 && m.AvgMs > slowest {
+			metrics.Bottleneck, slowest = stage, m.AvgMs
+		}
+	}
+
+	return metrics, nil
+}
@@ -0,0 +1,95 @@
+package subtitle
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// MaxOffset bounds the display offset of a session, in seconds either way
+const MaxOffset = 60.0
+
+// The pipeline delay is estimated once this many entries were measured
+const minDelaySamples = 3
+
+// estimatedDelayLocked returns how long after it was spoken an entry of
+// the session is typically available, in seconds: the 95th percentile of
+// recent entries, 0 until enough were measured. The caller holds
+// session.mu.
+func (session *SubtitleSession) estimatedDelayLocked() float64 {
+	if session.metrics.delay.count < minDelaySamples {
+		return 0
+	}
+	return math.Round(session.metrics.delay.summary().P95Ms/100) / 10
+}
+
+// offsetLocked returns the offset entries are shifted by when emitted: the
+// estimated delay in auto mode, else the one set. The caller holds
+// session.mu.
+func (session *SubtitleSession) offsetLocked() float64 {
+	if session.AutoOffset {
+		return session.estimatedDelayLocked()
+	}
+	return session.Offset
+}
+
+// emitLocked returns entry as served to viewers: in lang, its times and
+// those of its words shifted by the display offset. The caller holds
+// session.mu.
+func (session *SubtitleSession) emitLocked(entry SubtitleEntry, lang string) SubtitleEntry {
+	entry = inLanguage(entry, session, lang)
+
+	offset := session.offsetLocked()
+	if offset == 0 {
+		return entry
+	}
+	entry.StartTime += offset
+	entry.EndTime += offset
+	if len(entry.Words) > 0 {
+		words := make([]Word, len(entry.Words))
+		for i, w := range entry.Words {
+			w.Start += offset
+			w.End += offset
+			words[i] = w
+		}
+		entry.Words = words
+	}
+	return entry
+}
+
+// SetOffset sets the display offset of a session in seconds, added to the
+// times of the entries it serves from then on. Positive offsets show
+// subtitles later, for players holding the video back. With auto, the
+// offset follows the estimated pipeline delay instead.
+func (ss *SubtitleService) SetOffset(sessionID string, offset float64, auto bool) (*SessionInfo, error) {
+	if math.IsNaN(offset) || math.Abs(offset) > MaxOffset {
+		return nil, fmt.Errorf("offset must be between -%g and %g seconds", MaxOffset, MaxOffset)
+	}
+
+	ss.mu.RLock()
+	session, exists := ss.sessions[sessionID]
+	ss.mu.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrSessionNotFound, sessionID)
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	session.Offset = math.Round(offset*1000) / 1000
+	session.AutoOffset = auto
+	session.notifyLocked()
+
+	ss.sessionLogger(session).Info("subtitle offset changed", "offset", session.Offset, "auto", auto)
+
+	info := session.infoForLocked(sessionID)
+	return &info, nil
+}
+
+// recordDelay measures how long after it was spoken an entry became
+// available. The caller holds session.mu.
+func (session *SubtitleSession) recordDelay(entry SubtitleEntry) {
+	if !entry.wallStart.IsZero() {
+		session.metrics.delay.add(time.Since(entry.wallStart))
+	}
+}
@@ -0,0 +1,119 @@
+package subtitle
+
+import (
+	"time"
+)
+
+// Store persists sessions and their entries, so transcripts outlive the
+// in-memory session and the MaxSubtitles window
+type Store interface {
+	// CreateSession stores a new session and returns its stored ID
+	CreateSession(info SessionInfo) (string, error)
+	AddEntry(storedID string, entry SubtitleEntry) error
+	EndSession(storedID string, info SessionInfo, endedAt time.Time) error
+	// LoadTranscript returns the entries of the latest stored session with sessionID
+	LoadTranscript(sessionID string) ([]SubtitleEntry, error)
+	// Search returns up to limit entries of a user's sessions containing query
+	Search(userID, query string, limit int) ([]SearchMatch, error)
+	// CloseInterrupted marks sessions left running by a previous process as failed
+	CloseInterrupted() error
+}
+
+// storeOp is a queued write, run in order by storeLoop
+type storeOp func(store Store) error
+
+// SetStore enables persistence. Must be called before sessions are started.
+func (ss *SubtitleService) SetStore(store Store) {
+	if err := store.CloseInterrupted(); err != nil {
+		ss.logger.Warn("failed to close interrupted subtitle sessions", "error", err)
+	}
+
+	ss.store = store
+	ss.storeQueue = make(chan storeOp, 1000)
+	ss.storeDone = make(chan struct{})
+	go ss.storeLoop()
+}
+
+// persist queues a write without blocking recognition. Writes run one at a
+// time, so entries are stored after the session they belong to.
+func (ss *SubtitleService) persist(op storeOp) {
+	if ss.store == nil {
+		return
+	}
+
+	ss.storeMu.Lock()
+	defer ss.storeMu.Unlock()
+
+	if ss.storeClosed {
+		ss.logger.Warn("subtitle store closed, dropping write")
+		return
+	}
+
+	select {
+	case ss.storeQueue <- op:
+	default:
+		ss.logger.Warn("subtitle store queue full, dropping write")
+	}
+}
+
+// storeLoop runs queued writes until the queue is closed at shutdown
+func (ss *SubtitleService) storeLoop() {
+	defer close(ss.storeDone)
+
+	for op := range ss.storeQueue {
+		if err := op(ss.store); err != nil {
+			ss.logger.Warn("failed to persist subtitles", "error", err)
+		}
+	}
+}
+
+// persistSessionStart stores a new session
+func (ss *SubtitleService) persistSessionStart(session *SubtitleSession, info SessionInfo) {
+	ss.persist(func(store Store) error {
+		id, err := store.CreateSession(info)
+		if err != nil {
+			return err
+		}
+		session.storedID = id
+		return nil
+	})
+}
+
+// persistEntry stores an entry of a session
+func (ss *SubtitleService) persistEntry(session *SubtitleSession, entry SubtitleEntry) {
+	ss.persist(func(store Store) error {
+		if session.storedID == "" {
+			return nil // Creating the session failed
+		}
+		return store.AddEntry(session.storedID, entry)
+	})
+}
+
+// persistSessionEnd records the final status of a session
+func (ss *SubtitleService) persistSessionEnd(session *SubtitleSession, info SessionInfo) {
+	endedAt := time.Now()
+	ss.persist(func(store Store) error {
+		if session.storedID == "" {
+			return nil
+		}
+		return store.EndSession(session.storedID, info, endedAt)
+	})
+}
+
+// flushStore waits for queued writes, up to timeout
+func (ss *SubtitleService) flushStore(timeout time.Duration) {
+	if ss.store == nil {
+		return
+	}
+
+	ss.storeMu.Lock()
+	ss.storeClosed = true
+	close(ss.storeQueue)
+	ss.storeMu.Unlock()
+
+	select {
+	case <-ss.storeDone:
+	case <-time.After(timeout):
+		ss.logger.Warn("subtitle store writes did not finish in time")
+	}
+}
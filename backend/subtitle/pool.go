@@ -0,0 +1,161 @@
+package subtitle
+
+import (
+	"sync"
+)
+
+// maxQueuedChunks is how many utterances a session may have waiting for a
+// worker. Live subtitles arriving late are useless, so older ones are dropped.
+const maxQueuedChunks = 3
+
+// transcriptionJob is an utterance waiting for a worker
+type transcriptionJob struct {
+	session *SubtitleSession
+	segment *speechSegment
+}
+
+// transcriptionPool runs utterances of all sessions on a fixed number of
+// workers, so concurrent sessions share the CPU/GPU instead of each running
+// its own Whisper. Sessions take turns: a worker picks the next session in
+// round-robin order, and a session's utterances run one at a time, in order.
+type transcriptionPool struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending map[*SubtitleSession][]*speechSegment
+	busy    map[*SubtitleSession]bool
+	ready   []*SubtitleSession // Sessions with pending utterances and no running one
+	run     func(session *SubtitleSession, segment *speechSegment)
+	dropped func(session *SubtitleSession)
+}
+
+// newTranscriptionPool starts workers running run on queued utterances.
+// dropped is called when an utterance is discarded because the session's
+// queue is full.
+func newTranscriptionPool(workers int, run func(*SubtitleSession, *speechSegment), dropped func(*SubtitleSession)) *transcriptionPool {
+	if workers < 1 {
+		workers = 1
+	}
+
+	p := &transcriptionPool{
+		pending: make(map[*SubtitleSession][]*speechSegment),
+		busy:    make(map[*SubtitleSession]bool),
+		run:     run,
+		dropped: dropped,
+	}
+	p.cond = sync.NewCond(&p.mu)
+
+	for i := 0; i < workers; i++ {
+		go p.worker()
+	}
+	return p
+}
+
+// Submit queues an utterance of session
+func (p *transcriptionPool) Submit(session *SubtitleSession, segment *speechSegment) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	queue := p.pending[session]
+	if len(queue) >= maxQueuedChunks {
+		queue = queue[1:]
+		if p.dropped != nil {
+			p.dropped(session)
+		}
+	}
+	p.pending[session] = append(queue, segment)
+
+	if len(queue) == 0 && !p.busy[session] {
+		p.ready = append(p.ready, session)
+		p.cond.Broadcast()
+	}
+}
+
+// Drain waits until every queued utterance of session has run
+func (p *transcriptionPool) Drain(session *SubtitleSession) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for len(p.pending[session]) > 0 || p.busy[session] {
+		p.cond.Wait()
+	}
+}
+
+// Discard drops the queued utterances of session and waits for the running
+// one, if any
+func (p *transcriptionPool) Discard(session *SubtitleSession) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.pending, session)
+	for i, s := range p.ready {
+		if s == session {
+			p.ready = append(p.ready[:i], p.ready[i+1:]...)
+			break
+		}
+	}
+
+	for p.busy[session] {
+		p.cond.Wait()
+	}
+}
+
+// Queued returns how many utterances of session are waiting for a worker
+func (p *transcriptionPool) Queued(session *SubtitleSession) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.pending[session])
+}
+
+func (p *transcriptionPool) worker() {
+	for {
+		job := p.next()
+		p.run(job.session, job.segment)
+		p.done(job.session)
+	}
+}
+
+// next waits for the next session's turn and takes its oldest utterance
+func (p *transcriptionPool) next() transcriptionJob {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for len(p.ready) == 0 {
+		p.cond.Wait()
+	}
+
+	session := p.ready[0]
+	p.ready = p.ready[1:]
+
+	queue := p.pending[session]
+	segment := queue[0]
+	if len(queue) == 1 {
+		delete(p.pending, session)
+	} else {
+		p.pending[session] = queue[1:]
+	}
+	p.busy[session] = true
+
+	return transcriptionJob{session: session, segment: segment}
+}
+
+// done puts session back at the end of the line if it has more utterances
+func (p *transcriptionPool) done(session *SubtitleSession) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.busy, session)
+	if len(p.pending[session]) > 0 {
+		p.ready = append(p.ready, session)
+	}
+	p.cond.Broadcast()
+}
+
+// chunkDropped counts an utterance the pool discarded for session
+func (ss *SubtitleService) chunkDropped(session *SubtitleSession) {
+	session.mu.Lock()
+	session.DroppedChunks++
+	dropped := session.DroppedChunks
+	session.mu.Unlock()
+
+	ss.sessionLogger(session).Warn("transcription workers busy, dropping oldest utterance", "dropped_chunks", dropped)
+}
@@ -0,0 +1,143 @@
+package subtitle
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// TextFilters configure the post-processing applied to the text of a
+// session's entries before they are stored
+type TextFilters struct {
+	MaskProfanity bool `json:"mask_profanity"`  // Replace swear words with their first letter and asterisks
+	RestoreCasing bool `json:"restore_casing"`  // Capitalize sentences and end them with punctuation, for engines that output neither
+	MaxLineLength int  `json:"max_line_length"` // Wrap lines at this many characters, 0 disables
+}
+
+// Line lengths accepted for wrapping
+const (
+	minLineLength = 16
+	maxLineLength = 100
+)
+
+// Normalize validates the filters
+func (f *TextFilters) Normalize() error {
+	if f.MaxLineLength != 0 && (f.MaxLineLength < minLineLength || f.MaxLineLength > maxLineLength) {
+		return fmt.Errorf("max_line_length must be 0 or between %d and %d", minLineLength, maxLineLength)
+	}
+	return nil
+}
+
+// clean restores casing and masks profanity. Recognized text goes through
+// it before translation, so translations start from filtered text.
+func (f TextFilters) clean(text string) string {
+	text = CleanSubtitleText(text)
+	if f.RestoreCasing {
+		text = restoreCasing(text)
+	}
+	if f.MaskProfanity {
+		text = maskProfanity(text)
+	}
+	return text
+}
+
+// Apply runs the whole chain on text as it will be stored. Translations go
+// through it too, the model may use words the source didn't have.
+func (f TextFilters) Apply(text string) string {
+	text = f.clean(text)
+	if f.MaxLineLength > 0 {
+		text = wrapLines(text, f.MaxLineLength)
+	}
+	return text
+}
+
+// profanity lists swear words in the languages subtitles are most often
+// generated in. Matched on whole words, case-insensitively.
+var profanity = map[string]bool{}
+
+func init() {
+	for _, word := range []string{
+		// English
+		"fuck", "fucks", "fucking", "fucked", "fucker", "fuckers", "motherfucker", "motherfuckers",
+		"shit", "shits", "shitty", "bullshit", "bitch", "bitches", "asshole", "assholes",
+		"bastard", "bastards", "dick", "dicks", "cunt", "cunts", "piss", "pissed", "damn",
+		"crap", "slut", "sluts", "whore", "whores", "wanker", "wankers", "twat", "bollocks",
+		// French
+		"merde", "putain", "connard", "connards", "connasse", "salope", "salopes", "enculé",
+		"enculés", "encule", "bordel", "chier", "conne", "pute", "putes", "bâtard", "batard",
+		"nique", "niquer", "foutre",
+		// Spanish
+		"mierda", "puta", "putas", "puto", "cabrón", "cabron", "joder", "coño", "pendejo",
+		"gilipollas",
+		// German
+		"scheiße", "scheisse", "arschloch", "fotze", "wichser", "hure", "verdammt",
+	} {
+		profanity[word] = true
+	}
+}
+
+var wordPattern = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// maskProfanity keeps the first letter of swear words and replaces the
+// rest with asterisks
+func maskProfanity(text string) string {
+	return wordPattern.ReplaceAllStringFunc(text, func(word string) string {
+		if !profanity[strings.ToLower(word)] {
+			return word
+		}
+		first, size := utf8.DecodeRuneInString(word)
+		return string(first) + strings.Repeat("*", utf8.RuneCountInString(word[size:]))
+	})
+}
+
+// restoreCasing capitalizes the start of each sentence and ends the text
+// with a period when it has no final punctuation
+func restoreCasing(text string) string {
+	if text == "" {
+		return text
+	}
+
+	runes := []rune(text)
+	capitalize := true
+	for i, r := range runes {
+		switch {
+		case r == '.' || r == '!' || r == '?' || r == '…':
+			capitalize = true
+		case capitalize && unicode.IsLetter(r):
+			runes[i] = unicode.ToUpper(r)
+			capitalize = false
+		case capitalize && unicode.IsDigit(r):
+			capitalize = false
+		}
+	}
+
+	last := runes[len(runes)-1]
+	if unicode.IsLetter(last) || unicode.IsDigit(last) {
+		runes = append(runes, '.')
+	}
+	return string(runes)
+}
+
+// wrapLines breaks text at spaces into lines of at most width characters.
+// Words longer than width get a line of their own.
+func wrapLines(text string, width int) string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		switch {
+		case line == "":
+			line = word
+		case utf8.RuneCountInString(line)+1+utf8.RuneCountInString(word) <= width:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = word
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
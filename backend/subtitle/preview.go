@@ -0,0 +1,207 @@
+package subtitle
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Preview clip duration bounds
+const (
+	DefaultPreviewDuration = 6 * time.Second
+	MaxPreviewDuration     = 15 * time.Second
+)
+
+// maxPreviewSize bounds the rendered clip read into memory
+const maxPreviewSize = 20 * 1024 * 1024
+
+// PreviewStyle mirrors the caption settings of the player.
+// Sizes and positions use the same names as the frontend settings.
+type PreviewStyle struct {
+	Font       string `json:"font"`       // Font family, empty for the default
+	FontSize   string `json:"font_size"`  // small, medium, large
+	Position   string `json:"position"`   // bottom, top
+	Background string `json:"background"` // transparent, semi, solid
+}
+
+// Normalize fills defaults and validates the style
+func (s *PreviewStyle) Normalize() error {
+	if s.FontSize == "" {
+		s.FontSize = "medium"
+	}
+	if s.Position == "" {
+		s.Position = "bottom"
+	}
+	if s.Background == "" {
+		s.Background = "semi"
+	}
+
+	switch s.FontSize {
+	case "small", "medium", "large":
+	default:
+		return fmt.Errorf("invalid font size %q", s.FontSize)
+	}
+	switch s.Position {
+	case "bottom", "top":
+	default:
+		return fmt.Errorf("invalid position %q", s.Position)
+	}
+	switch s.Background {
+	case "transparent", "semi", "solid":
+	default:
+		return fmt.Errorf("invalid background %q", s.Background)
+	}
+
+	// The font name ends up in an ffmpeg filter argument
+	if strings.ContainsAny(s.Font, `,:;'"\=[]`) || len(s.Font) > 64 {
+		return fmt.Errorf("invalid font name %q", s.Font)
+	}
+
+	return nil
+}
+
+// forceStyle converts the style to an ASS force_style for the subtitles filter.
+// Sizes are relative to libass' default 288 line script height.
+func (s PreviewStyle) forceStyle() string {
+	sizes := map[string]int{"small": 14, "medium": 18, "large": 24}
+
+	parts := []string{
+		fmt.Sprintf("FontSize=%d", sizes[s.FontSize]),
+		"PrimaryColour=&H00FFFFFF",
+		"MarginV=20",
+	}
+	if s.Font != "" {
+		parts = append(parts, "FontName="+s.Font)
+	}
+
+	// ASS numpad alignment: 2 is bottom center, 8 top center
+	if s.Position == "top" {
+		parts = append(parts, "Alignment=8")
+	} else {
+		parts = append(parts, "Alignment=2")
+	}
+
+	// BorderStyle 3 draws an opaque box in BackColour (alpha first, 00 is opaque)
+	switch s.Background {
+	case "semi":
+		parts = append(parts, "BorderStyle=3", "BackColour=&H66000000", "OutlineColour=&H66000000", "Outline=4", "Shadow=0")
+	case "solid":
+		parts = append(parts, "BorderStyle=3", "BackColour=&H00000000", "OutlineColour=&H00000000", "Outline=4", "Shadow=0")
+	default:
+		parts = append(parts, "BorderStyle=1", "OutlineColour=&H00000000", "Outline=1", "Shadow=1")
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// previewText picks the caption shown in a preview: the given text, else the
+// latest subtitles of the session, else a sample line
+func (ss *SubtitleService) previewText(sessionID, text string) string {
+	if text != "" {
+		return text
+	}
+
+	if sessionID != "" {
+		if entries, err := ss.GetSubtitles(sessionID, 0, ""); err == nil && len(entries) > 0 {
+			start := len(entries) - 2
+			if start < 0 {
+				start = 0
+			}
+			lines := make([]string, 0, 2)
+			for _, entry := range entries[start:] {
+				lines = append(lines, entry.Text)
+			}
+			return strings.Join(lines, "\n")
+		}
+	}
+
+	return "This is how subtitles will look on your screen.\nThe quick brown fox jumps over the lazy dog."
+}
+
+// RenderPreview records a short clip of a stream with a caption burned in
+// using style, and returns it as MP4. The caption is text, or the latest
+// subtitles of sessionID when text is empty.
+func (ss *SubtitleService) RenderPreview(ctx context.Context, streamURL, sessionID, text string, style PreviewStyle, duration time.Duration) ([]byte, error) {
+	if err := style.Normalize(); err != nil {
+		return nil, err
+	}
+	if duration <= 0 {
+		duration = DefaultPreviewDuration
+	}
+	if duration > MaxPreviewDuration {
+		duration = MaxPreviewDuration
+	}
+
+	workDir, err := os.MkdirTemp("", "subtitle-preview-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+
+	// One cue covering the whole clip
+	srtPath := filepath.Join(workDir, "preview.srt")
+	srt := formatSRT([]SubtitleEntry{{
+		StartTime: 0,
+		EndTime:   duration.Seconds(),
+		Text:      ss.previewText(sessionID, text),
+	}})
+	if err := os.WriteFile(srtPath, []byte(srt), 0644); err != nil {
+		return nil, err
+	}
+
+	outputPath := filepath.Join(workDir, "preview.mp4")
+	seconds := fmt.Sprintf("%.1f", duration.Seconds())
+
+	args := append([]string{
+		"-y",
+		"-hide_banner",
+		"-loglevel", "error",
+	}, ss.inputOptions(streamURL)...)
+	args = append(args,
+		"-i", streamURL,
+		"-t", seconds,
+		"-vf", fmt.Sprintf("scale=-2:720,subtitles=filename=%s:force_style='%s'", srtPath, style.forceStyle()),
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-crf", "28",
+		"-c:a", "aac",
+		"-b:a", "96k",
+		"-movflags", "+faststart",
+		outputPath,
+	)
+
+	ctx, cancel := context.WithTimeout(ctx, duration+30*time.Second)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("preview rendering timed out")
+		}
+		return nil, fmt.Errorf("failed to render preview: %w: %s", err, strings.TrimSpace(lastLine(stderr.String())))
+	}
+
+	info, err := os.Stat(outputPath)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > maxPreviewSize {
+		return nil, fmt.Errorf("preview too large")
+	}
+
+	return os.ReadFile(outputPath)
+}
+
+// lastLine returns the last non-empty line of s
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return lines[len(lines)-1]
+}
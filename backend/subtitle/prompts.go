@@ -0,0 +1,225 @@
+package subtitle
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// DefaultPromptTemplate is the translation prompt sent to Ollama unless one
+// is configured. Templates use text/template with the fields of
+// PromptVariables.
+const DefaultPromptTemplate = `You are a subtitle translator. Translate the following from {{.From}} to {{.To}}.
+{{- if .Domain}}
+The text is from {{.Domain}} programs: use the terms their viewers expect.
+{{- end}}
+
+RULES:
+- Output ONLY the translation, nothing else
+- No explanations, notes, or commentary
+- No quotation marks around the translation
+{{- if eq .Tone "formal"}}
+- Use a formal register (vous, usted, Sie...)
+{{- else if eq .Tone "informal"}}
+- Use an informal register (tu, tú, du...)
+{{- else}}
+- Keep the same tone and style
+{{- end}}
+- If text is unclear, translate it as best as you can
+
+Text: {{.Text}}
+
+Translation:`
+
+// Registers a prompt can ask translations in
+const (
+	ToneFormal   = "formal"
+	ToneInformal = "informal"
+)
+
+// maxPromptTemplate bounds the length of a prompt template in bytes
+const maxPromptTemplate = 4000
+
+// promptSample stands for the text to translate when templates are checked
+const promptSample = "\x00text\x00"
+
+// PromptVariables are the fields translation prompt templates can use
+type PromptVariables struct {
+	Text     string // Text to translate
+	From     string // Source language name, such as English
+	To       string // Target language name
+	FromCode string // Source language code, such as en
+	ToCode   string // Target language code
+	Tone     string // formal, informal, or empty to keep the source's
+	Domain   string // Kind of programs, such as sports or news, empty when unknown
+}
+
+// PromptTemplate shapes the translation prompt. Empty fields of a language
+// pair's template fall back to the default one.
+type PromptTemplate struct {
+	Template string `json:"template,omitempty"` // text/template source, empty for DefaultPromptTemplate
+	Tone     string `json:"tone,omitempty"`     // formal or informal, empty to keep the source's
+	Domain   string `json:"domain,omitempty"`   // Vocabulary hint, such as sports or news
+}
+
+// PromptConfig holds the default translation prompt and those of language
+// pairs
+type PromptConfig struct {
+	PromptTemplate
+	Pairs map[string]PromptTemplate `json:"pairs,omitempty"` // Keyed "en-fr", or "*-fr" and "en-*" for every source or target
+}
+
+// promptSet is a PromptConfig with its templates parsed
+type promptSet struct {
+	config    PromptConfig
+	templates map[string]*template.Template // By template source
+}
+
+// defaultPrompts is the prompt set of a service without configuration
+var defaultPrompts = mustPromptSet(PromptConfig{})
+
+func mustPromptSet(config PromptConfig) *promptSet {
+	set, err := newPromptSet(config)
+	if err != nil {
+		panic(err)
+	}
+	return set
+}
+
+// newPromptSet validates and parses the templates of config
+func newPromptSet(config PromptConfig) (*promptSet, error) {
+	set := &promptSet{config: config, templates: make(map[string]*template.Template)}
+
+	check := func(name string, prompt PromptTemplate) error {
+		switch prompt.Tone {
+		case "", ToneFormal, ToneInformal:
+		default:
+			return fmt.Errorf("%s: invalid tone %q", name, prompt.Tone)
+		}
+		if len(prompt.Domain) > 100 {
+			return fmt.Errorf("%s: domain is too long", name)
+		}
+
+		source := prompt.Template
+		if source == "" {
+			source = DefaultPromptTemplate
+		}
+		if _, ok := set.templates[source]; ok {
+			return nil
+		}
+		if len(source) > maxPromptTemplate {
+			return fmt.Errorf("%s: template is longer than %d bytes", name, maxPromptTemplate)
+		}
+		tmpl, err := template.New(name).Parse(source)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		// Catch references to unknown fields now rather than per entry
+		var rendered strings.Builder
+		if err := tmpl.Execute(&rendered, PromptVariables{Text: promptSample}); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if !strings.Contains(rendered.String(), promptSample) {
+			return fmt.Errorf("%s: template must include {{.Text}}", name)
+		}
+		set.templates[source] = tmpl
+		return nil
+	}
+
+	if err := check("default", config.PromptTemplate); err != nil {
+		return nil, err
+	}
+	for pair, prompt := range config.Pairs {
+		from, to, ok := strings.Cut(pair, "-")
+		if !ok || from == "" || to == "" || (from == "*" && to == "*") {
+			return nil, fmt.Errorf("invalid language pair %q, expected a key like en-fr, *-fr or en-*", pair)
+		}
+		if err := check(pair, prompt); err != nil {
+			return nil, err
+		}
+	}
+
+	return set, nil
+}
+
+// resolve returns the template of a language pair: the most specific pair
+// entry, with its empty fields taken from the default
+func (set *promptSet) resolve(fromLang, toLang string) PromptTemplate {
+	prompt := set.config.PromptTemplate
+	for _, key := range []string{fromLang + "-" + toLang, fromLang + "-*", "*-" + toLang} {
+		pair, ok := set.config.Pairs[key]
+		if !ok {
+			continue
+		}
+		if pair.Template != "" {
+			prompt.Template = pair.Template
+		}
+		if pair.Tone != "" {
+			prompt.Tone = pair.Tone
+		}
+		if pair.Domain != "" {
+			prompt.Domain = pair.Domain
+		}
+		break
+	}
+	if prompt.Template == "" {
+		prompt.Template = DefaultPromptTemplate
+	}
+	return prompt
+}
+
+// render returns the prompt translating text between two languages, and an
+// ID of the template and variables it was made with, which translations
+// are cached under
+func (set *promptSet) render(text, fromLang, toLang string) (prompt, id string, err error) {
+	resolved := set.resolve(fromLang, toLang)
+
+	var b strings.Builder
+	err = set.templates[resolved.Template].Execute(&b, PromptVariables{
+		Text:     text,
+		From:     getLanguageName(fromLang),
+		To:       getLanguageName(toLang),
+		FromCode: fromLang,
+		ToCode:   toLang,
+		Tone:     resolved.Tone,
+		Domain:   resolved.Domain,
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(resolved.Template + "\x00" + resolved.Tone + "\x00" + resolved.Domain))
+	return b.String(), strconv.FormatUint(h.Sum64(), 36), nil
+}
+
+// GetPromptConfig returns the translation prompt configuration
+func (ss *SubtitleService) GetPromptConfig() PromptConfig {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	return ss.prompts.config
+}
+
+// UpdatePromptConfig sets the translation prompts, used from the next
+// translation on. Translations cached under other prompts aren't reused.
+func (ss *SubtitleService) UpdatePromptConfig(config PromptConfig) error {
+	set, err := newPromptSet(config)
+	if err != nil {
+		return err
+	}
+
+	ss.mu.Lock()
+	ss.prompts = set
+	ss.mu.Unlock()
+	return nil
+}
+
+// translationPrompt renders the prompt translating text, see promptSet.render
+func (ss *SubtitleService) translationPrompt(text, fromLang, toLang string) (string, string, error) {
+	ss.mu.RLock()
+	set := ss.prompts
+	ss.mu.RUnlock()
+	return set.render(text, fromLang, toLang)
+}
@@ -0,0 +1,56 @@
+package subtitle
+
+import (
+	"sort"
+	"time"
+)
+
+// markStreamStart records that a new ffmpeg process started reading the
+// stream, whose output times start again from zero
+func (session *SubtitleSession) markStreamStart() {
+	session.mu.Lock()
+	session.streamStart = time.Now()
+	session.mu.Unlock()
+}
+
+// ChannelEntries returns the subtitles spoken on a channel between from and
+// to, with times relative to from, for a recording of that window. When
+// several sessions ran on the channel (other users, other languages), the
+// one covering the window best is used. Entries trimmed from memory are
+// not included.
+func (ss *SubtitleService) ChannelEntries(channelID string, from, to time.Time) []SubtitleEntry {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+
+	var best []SubtitleEntry
+	for _, session := range ss.sessions {
+		if session.ChannelID != channelID {
+			continue
+		}
+
+		session.mu.RLock()
+		var entries []SubtitleEntry
+		for _, entry := range session.Subtitles {
+			if entry.wallStart.IsZero() || entry.wallStart.Before(from) || !entry.wallStart.Before(to) {
+				continue
+			}
+			shift := entry.wallStart.Sub(from).Seconds() - entry.StartTime
+			entry.StartTime += shift
+			entry.EndTime += shift
+			entry.Words = offsetWords(entry.Words, shift)
+			entries = append(entries, entry)
+		}
+		session.mu.RUnlock()
+
+		if len(entries) > len(best) {
+			best = entries
+		}
+	}
+
+	sort.Slice(best, func(i, j int) bool { return best[i].StartTime < best[j].StartTime })
+	for i := range best {
+		best[i].ID = i + 1
+	}
+
+	return best
+}
@@ -0,0 +1,94 @@
+package subtitle
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// Bounds of the number of search results
+const (
+	DefaultSearchLimit = 50
+	MaxSearchLimit     = 200
+	minSearchQuery     = 2 // Characters
+)
+
+// ErrQueryTooShort is returned for search queries matching nearly everything
+var ErrQueryTooShort = errors.New("search query too short")
+
+// SearchMatch is a subtitle line containing the searched text, with the
+// time to jump to in its session or recording
+type SearchMatch struct {
+	SessionID   string    `json:"session_id,omitempty"`
+	RecordingID string    `json:"recording_id,omitempty"`
+	ChannelID   string    `json:"channel_id,omitempty"`
+	EntryID     int       `json:"entry_id"`
+	StartTime   float64   `json:"start_time"` // Seconds from the start of the session or recording
+	EndTime     float64   `json:"end_time"`
+	Text        string    `json:"text"`               // The line in the language that matched
+	Language    string    `json:"language,omitempty"` // Empty for recordings, whose sidecars don't say
+	Speaker     int       `json:"speaker,omitempty"`
+	Date        time.Time `json:"date"` // When the session started or the recording was made
+}
+
+// NormalizeQuery trims a search query and checks it is long enough
+func NormalizeQuery(query string) (string, error) {
+	query = strings.Join(strings.Fields(query), " ")
+	if len([]rune(query)) < minSearchQuery {
+		return "", ErrQueryTooShort
+	}
+	return query, nil
+}
+
+// matchEntry returns the text and language of entry containing query, the
+// recognized text first, then its translations
+func matchEntry(entry SubtitleEntry, query string) (string, string, bool) {
+	query = strings.ToLower(query)
+	contains := func(text string) bool {
+		return strings.Contains(strings.ToLower(strings.Join(strings.Fields(text), " ")), query)
+	}
+
+	if contains(entry.Text) {
+		return entry.Text, entry.Language, true
+	}
+	if contains(entry.Original) {
+		return entry.Original, "", true
+	}
+	for lang, text := range entry.Translations {
+		if contains(text) {
+			return text, lang, true
+		}
+	}
+	return "", "", false
+}
+
+// SearchTranscripts returns the stored lines of a user's sessions that
+// contain query, most recent sessions first
+func (ss *SubtitleService) SearchTranscripts(userID, query string, limit int) ([]SearchMatch, error) {
+	if ss.store == nil {
+		return []SearchMatch{}, nil
+	}
+	return ss.store.Search(userID, query, limit)
+}
+
+// SearchSRT returns the lines of SRT subtitles that contain query
+func SearchSRT(r io.Reader, query string, limit int) []SearchMatch {
+	matches := []SearchMatch{}
+	id := 0
+	readSRT(r, func(start, end float64, text string) {
+		id++
+		if len(matches) >= limit {
+			return
+		}
+		if _, _, ok := matchEntry(SubtitleEntry{Text: text}, query); ok {
+			matches = append(matches, SearchMatch{
+				EntryID:   id,
+				StartTime: start,
+				EndTime:   end,
+				Text:      text,
+			})
+		}
+	})
+	return matches
+}
@@ -0,0 +1,136 @@
+package subtitle
+
+import (
+	"slices"
+)
+
+// viewer is a user watching a session under a session ID of their own.
+// Viewers of the same channel share one session, and so one ffmpeg and
+// speech recognition pipeline, which translates to the languages of all.
+type viewer struct {
+	UserID      string
+	TargetLangs []string
+}
+
+// distinctSessionsLocked returns each session once, however many viewers
+// share it. The caller holds ss.mu.
+func (ss *SubtitleService) distinctSessionsLocked() []*SubtitleSession {
+	sessions := make([]*SubtitleSession, 0, len(ss.sessions))
+	seen := make(map[*SubtitleSession]bool, len(ss.sessions))
+	for _, session := range ss.sessions {
+		if !seen[session] {
+			seen[session] = true
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions
+}
+
+// joinLocked adds a viewer to a running session of the channel that can
+// serve them: same spoken language or auto detection, same text filters,
+// and room for their target languages. It returns nil when there is none.
+// The caller holds ss.mu for writing.
+func (ss *SubtitleService) joinLocked(sessionID, userID, channelID, language string, targetLangs []string, filters TextFilters) *SubtitleSession {
+	if !ss.config.ShareSessions || channelID == "" {
+		return nil
+	}
+
+	for _, session := range ss.distinctSessionsLocked() {
+		if session.ChannelID != channelID || session.ctx.Err() != nil {
+			continue
+		}
+
+		session.mu.Lock()
+		running := session.Status == "starting" || session.Status == "running"
+		langs := session.TargetLangs
+		for _, lang := range targetLangs {
+			if lang != session.Language && !slices.Contains(langs, lang) {
+				langs = append(slices.Clip(langs), lang)
+			}
+		}
+		if !running || session.Filters != filters || len(langs) > MaxTargetLangs ||
+			(language != "" && language != session.Language) {
+			session.mu.Unlock()
+			continue
+		}
+
+		session.viewers[sessionID] = &viewer{UserID: userID, TargetLangs: targetLangs}
+		session.TargetLangs = langs
+		session.notifyLocked()
+		viewers := len(session.viewers)
+		session.mu.Unlock()
+
+		ss.sessions[sessionID] = session
+		ss.sessionLogger(session).Info("viewer joined shared subtitle session", "viewer_session_id", sessionID, "viewers", viewers, "target_langs", langs)
+		return session
+	}
+	return nil
+}
+
+// leaveLocked detaches a viewer from a session others still watch, and
+// stops translating to the languages only they wanted. It reports false
+// when they are the last viewer, whose leaving ends the session. The
+// caller holds ss.mu for writing.
+func (ss *SubtitleService) leaveLocked(session *SubtitleSession, sessionID string) bool {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if _, ok := session.viewers[sessionID]; !ok || len(session.viewers) <= 1 {
+		return false
+	}
+	delete(session.viewers, sessionID)
+	delete(ss.sessions, sessionID)
+
+	// Entry texts are in the primary target language, which stays
+	var langs []string
+	if session.TargetLang != "" {
+		langs = append(langs, session.TargetLang)
+	}
+	for _, v := range session.viewers {
+		for _, lang := range v.TargetLangs {
+			if lang != session.Language && !slices.Contains(langs, lang) {
+				langs = append(langs, lang)
+			}
+		}
+	}
+	session.TargetLangs = langs
+	session.notifyLocked()
+
+	ss.sessionLogger(session).Info("viewer left shared subtitle session", "viewer_session_id", sessionID, "viewers", len(session.viewers))
+	return true
+}
+
+// langForLocked returns the language a viewer is served entries in when
+// they ask for none: their first target language, else the spoken one.
+// The viewer who started the session gets the primary text as before.
+// The caller holds session.mu.
+func (session *SubtitleSession) langForLocked(sessionID, lang string) string {
+	if lang != "" || sessionID == session.ID {
+		return lang
+	}
+	v, ok := session.viewers[sessionID]
+	if !ok {
+		return lang
+	}
+	if len(v.TargetLangs) > 0 {
+		return v.TargetLangs[0]
+	}
+	return session.Language
+}
+
+// infoForLocked returns the information of a session as a viewer sees it,
+// under their session ID with their target languages. The caller holds
+// session.mu.
+func (session *SubtitleSession) infoForLocked(sessionID string) SessionInfo {
+	info := session.info()
+	if v, ok := session.viewers[sessionID]; ok && sessionID != session.ID {
+		info.ID = sessionID
+		info.UserID = v.UserID
+		info.TargetLangs = v.TargetLangs
+		info.TargetLang = ""
+		if len(v.TargetLangs) > 0 {
+			info.TargetLang = v.TargetLangs[0]
+		}
+	}
+	return info
+}
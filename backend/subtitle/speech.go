@@ -0,0 +1,356 @@
+package subtitle
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SpeechBackend transcribes chunks of 16-bit little-endian mono PCM audio
+type SpeechBackend interface {
+	// Name identifies the backend in logs and status responses
+	Name() string
+	// Transcribe returns the text spoken in pcm. language is an ISO 639-1
+	// code, empty to let the backend detect it.
+	Transcribe(ctx context.Context, pcm []byte, sampleRate int, language string) (Transcription, error)
+}
+
+// Transcription is the result of transcribing a chunk
+type Transcription struct {
+	Text                string
+	Language            string  // ISO 639-1 code of the spoken language, empty when unknown
+	LanguageProbability float64 // Confidence of a detected language, 0 when not reported
+	Words               []Word  // Words timed from the start of the chunk, nil when not reported
+	Confidence          float64 // How sure the backend is of the text, from 0 to 1, 0 when not reported
+}
+
+// whisperSegment is a segment of a Whisper transcription with its scores
+type whisperSegment struct {
+	Text         string  `json:"text"`
+	AvgLogprob   float64 `json:"avg_logprob"`
+	NoSpeechProb float64 `json:"no_speech_prob"`
+	Words        []Word  `json:"words"`
+}
+
+// whisperConfidence scores a transcription from its segments: the
+// probability of their tokens, discounted by the probability that there
+// was no speech at all, weighted by their length. That probability is high
+// for the text Whisper makes up over music and silence. Without segment
+// scores, the mean probability of the words is used.
+func whisperConfidence(segments []whisperSegment, words []Word) float64 {
+	var sum, weight float64
+	for _, segment := range segments {
+		if segment.AvgLogprob == 0 && segment.NoSpeechProb == 0 {
+			continue // Not reported
+		}
+		length := float64(len(strings.TrimSpace(segment.Text)) + 1)
+		sum += math.Exp(segment.AvgLogprob) * (1 - segment.NoSpeechProb) * length
+		weight += length
+	}
+	if weight == 0 {
+		return meanWordProbability(words)
+	}
+	return math.Round(sum/weight*1000) / 1000
+}
+
+// meanWordProbability returns the mean probability of words, 0 when none
+// was reported
+func meanWordProbability(words []Word) float64 {
+	var sum float64
+	n := 0
+	for _, w := range words {
+		if w.Probability > 0 {
+			sum += w.Probability
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return math.Round(sum/float64(n)*1000) / 1000
+}
+
+// newSpeechBackend picks the backend for config: a whisper.cpp server when
+// one is configured, else the faster-whisper script running the model
+// returned by model
+func newSpeechBackend(config SubtitleServiceConfig, logger *slog.Logger, model func() (name, path string)) SpeechBackend {
+	if config.WhisperServerURL != "" {
+		return &whisperServerBackend{
+			url:    strings.TrimRight(config.WhisperServerURL, "/"),
+			client: &http.Client{Timeout: 60 * time.Second},
+		}
+	}
+	return &scriptBackend{logger: logger, model: model}
+}
+
+// whisperServerBackend posts audio to a whisper.cpp server
+// (examples/server), which keeps the model loaded between requests
+type whisperServerBackend struct {
+	url    string
+	client *http.Client
+}
+
+func (b *whisperServerBackend) Name() string {
+	return "whisper.cpp"
+}
+
+func (b *whisperServerBackend) Transcribe(ctx context.Context, pcm []byte, sampleRate int, language string) (Transcription, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+
+	part, err := form.CreateFormFile("file", "audio.wav")
+	if err != nil {
+		return Transcription{}, err
+	}
+	if err := writeWAV(part, pcm, sampleRate); err != nil {
+		return Transcription{}, err
+	}
+
+	// verbose_json reports the detected language and word timings
+	if language == "" {
+		language = "auto"
+	}
+	form.WriteField("language", language)
+	form.WriteField("response_format", "verbose_json")
+	form.WriteField("temperature", "0.0")
+	if err := form.Close(); err != nil {
+		return Transcription{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.url+"/inference", &body)
+	if err != nil {
+		return Transcription{}, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return Transcription{}, fmt.Errorf("whisper server unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		return Transcription{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Transcription{}, fmt.Errorf("whisper server returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+
+	var result struct {
+		Text                        string           `json:"text"`
+		Language                    string           `json:"language,omitempty"`
+		DetectedLanguage            string           `json:"detected_language,omitempty"`
+		DetectedLanguageProbability float64          `json:"detected_language_probability,omitempty"`
+		Segments                    []whisperSegment `json:"segments,omitempty"`
+		Error                       string           `json:"error,omitempty"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return Transcription{}, fmt.Errorf("failed to parse whisper server response: %w", err)
+	}
+	if result.Error != "" {
+		return Transcription{}, fmt.Errorf("whisper server error: %s", result.Error)
+	}
+
+	transcription := Transcription{
+		Text:                strings.TrimSpace(result.Text),
+		Language:            whisperLanguageCode(result.DetectedLanguage),
+		LanguageProbability: result.DetectedLanguageProbability,
+	}
+	for _, segment := range result.Segments {
+		transcription.Words = append(transcription.Words, segment.Words...)
+	}
+	transcription.Confidence = whisperConfidence(result.Segments, transcription.Words)
+	if transcription.Language == "" {
+		transcription.Language = whisperLanguageCode(result.Language)
+	}
+	return transcription, nil
+}
+
+// scriptBackend runs scripts/transcribe.py (faster-whisper) per chunk,
+// falling back to the openai-whisper CLI. The model is loaded on every call.
+type scriptBackend struct {
+	logger *slog.Logger
+	model  func() (name, path string) // Selected model, path empty until downloaded
+}
+
+func (b *scriptBackend) Name() string {
+	return "faster-whisper"
+}
+
+// Transcribe kills child processes when ctx (the session context) is cancelled
+func (b *scriptBackend) Transcribe(ctx context.Context, pcm []byte, sampleRate int, language string) (Transcription, error) {
+	tmpWav, err := os.CreateTemp("", "audio-*.wav")
+	if err != nil {
+		return Transcription{}, err
+	}
+	tmpWavName := tmpWav.Name()
+	defer os.Remove(tmpWavName)
+
+	if err := writeWAV(tmpWav, pcm, sampleRate); err != nil {
+		tmpWav.Close()
+		return Transcription{}, err
+	}
+	tmpWav.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, 120*time.Second)
+	defer cancel()
+
+	// Use our Python script for transcription (uses faster-whisper)
+	scriptPath := filepath.Join(filepath.Dir(os.Args[0]), "scripts", "transcribe.py")
+
+	model, modelPath := b.model()
+
+	// Check if script exists, fallback to whisper CLI if not
+	if _, err := os.Stat(scriptPath); os.IsNotExist(err) {
+		return b.transcribeWithCLI(ctx, tmpWavName, language, model)
+	}
+
+	whisperCmd := exec.CommandContext(ctx, "python3", scriptPath, tmpWavName, language, model, modelPath)
+
+	output, err := whisperCmd.CombinedOutput()
+	if err != nil {
+		b.logger.Warn("transcription script error", "error", err, "output", string(output))
+		return b.transcribeWithCLI(ctx, tmpWavName, language, model)
+	}
+
+	var result struct {
+		Success             bool             `json:"success"`
+		Text                string           `json:"text"`
+		Language            string           `json:"language,omitempty"`
+		LanguageProbability float64          `json:"language_probability,omitempty"`
+		Words               []Word           `json:"words,omitempty"`
+		Segments            []whisperSegment `json:"segments,omitempty"`
+		Error               string           `json:"error,omitempty"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		b.logger.Error("failed to parse transcription output", "error", err, "raw", string(output))
+		return Transcription{}, fmt.Errorf("failed to parse transcription output: %w", err)
+	}
+
+	if !result.Success {
+		return Transcription{}, fmt.Errorf("transcription failed: %s", result.Error)
+	}
+
+	return Transcription{
+		Text:                strings.TrimSpace(result.Text),
+		Language:            whisperLanguageCode(result.Language),
+		LanguageProbability: result.LanguageProbability,
+		Words:               result.Words,
+		Confidence:          whisperConfidence(result.Segments, result.Words),
+	}, nil
+}
+
+// transcribeWithCLI uses the whisper CLI as fallback
+func (b *scriptBackend) transcribeWithCLI(ctx context.Context, wavFile, language, model string) (Transcription, error) {
+	// Run whisper with JSON output
+	tmpDir := filepath.Dir(wavFile)
+
+	args := []string{
+		wavFile,
+		"--output_format", "json",
+		"--output_dir", tmpDir,
+		"--model", model,
+		"--word_timestamps", "True",
+	}
+	if language != "" {
+		args = append(args, "--language", language)
+	}
+	whisperCmd := exec.CommandContext(ctx, "whisper", args...)
+
+	output, err := whisperCmd.CombinedOutput()
+	if err != nil {
+		b.logger.Warn("whisper CLI error", "error", err, "output", string(output))
+		return Transcription{}, fmt.Errorf("whisper failed: %w", err)
+	}
+
+	// Read the JSON output - whisper names output based on input filename
+	baseName := filepath.Base(wavFile)
+	jsonFile := filepath.Join(tmpDir, strings.TrimSuffix(baseName, filepath.Ext(baseName))+".json")
+	defer os.Remove(jsonFile)
+
+	jsonData, err := os.ReadFile(jsonFile)
+	if err != nil {
+		return Transcription{}, fmt.Errorf("failed to read whisper output: %w", err)
+	}
+
+	var result struct {
+		Text     string           `json:"text"`
+		Language string           `json:"language"`
+		Segments []whisperSegment `json:"segments"`
+	}
+	if err := json.Unmarshal(jsonData, &result); err != nil {
+		return Transcription{}, fmt.Errorf("failed to parse whisper output: %w", err)
+	}
+
+	transcription := Transcription{
+		Text:     strings.TrimSpace(result.Text),
+		Language: whisperLanguageCode(result.Language),
+	}
+	for _, segment := range result.Segments {
+		transcription.Words = append(transcription.Words, segment.Words...)
+	}
+	transcription.Confidence = whisperConfidence(result.Segments, transcription.Words)
+	return transcription, nil
+}
+
+// whisperLanguageCode normalizes a language reported by Whisper, either an
+// ISO 639-1 code or an English name such as "german", to the code
+func whisperLanguageCode(language string) string {
+	language = strings.ToLower(strings.TrimSpace(language))
+	if len(language) == 2 {
+		return language
+	}
+	for code, name := range languageNames {
+		if strings.ToLower(name) == language {
+			return code
+		}
+	}
+	return ""
+}
+
+// writeWAV writes 16-bit mono PCM with a canonical 44-byte WAV header,
+// which replaces the ffmpeg raw to WAV conversion
+func writeWAV(w io.Writer, pcm []byte, sampleRate int) error {
+	const (
+		channels      = 1
+		bitsPerSample = 16
+	)
+	byteRate := sampleRate * channels * bitsPerSample / 8
+
+	header := []interface{}{
+		[4]byte{'R', 'I', 'F', 'F'},
+		uint32(36 + len(pcm)),
+		[4]byte{'W', 'A', 'V', 'E'},
+		[4]byte{'f', 'm', 't', ' '},
+		uint32(16), // fmt chunk size
+		uint16(1),  // PCM
+		uint16(channels),
+		uint32(sampleRate),
+		uint32(byteRate),
+		uint16(channels * bitsPerSample / 8), // Block align
+		uint16(bitsPerSample),
+		[4]byte{'d', 'a', 't', 'a'},
+		uint32(len(pcm)),
+	}
+	for _, field := range header {
+		if err := binary.Write(w, binary.LittleEndian, field); err != nil {
+			return err
+		}
+	}
+
+	_, err := w.Write(pcm)
+	return err
+}
@@ -0,0 +1,125 @@
+package subtitle
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+
+	"iptv-backend/storage"
+)
+
+// ExportPrefix starts the keys of subtitle exports in the storage backend,
+// apart from the recordings
+const ExportPrefix = "subtitle-exports/"
+
+// ErrInvalidExport is returned for names that aren't subtitle exports
+var ErrInvalidExport = errors.New("invalid subtitle export name")
+
+// SetStorage writes subtitle exports to backend instead of CacheDir.
+// Exports already in CacheDir stay readable there.
+func (ss *SubtitleService) SetStorage(backend storage.Backend) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.exportStorage = backend
+}
+
+func (ss *SubtitleService) getStorage() storage.Backend {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	return ss.exportStorage
+}
+
+// saveExport writes an export to the storage backend, or to CacheDir when
+// there is none or the upload fails, so the export isn't lost
+func (ss *SubtitleService) saveExport(name string, content []byte) error {
+	if backend := ss.getStorage(); backend != nil {
+		err := backend.Put(ExportPrefix+name, &filesystem.BytesReader{Bytes: content})
+		if err == nil {
+			return nil
+		}
+		ss.logger.Warn("failed to upload subtitle export, keeping it locally", "file", name, "storage", backend.Name(), "error", err)
+	}
+
+	return os.WriteFile(filepath.Join(ss.config.CacheDir, name), content, 0644)
+}
+
+// OpenExport returns the content of a subtitle export, local or stored
+func (ss *SubtitleService) OpenExport(name string) (io.ReadCloser, error) {
+	if _, ok := ExportSession(name); !ok {
+		return nil, ErrInvalidExport
+	}
+
+	f, err := os.Open(filepath.Join(ss.config.CacheDir, name))
+	if err == nil {
+		return f, nil
+	}
+	backend := ss.getStorage()
+	if backend == nil || !os.IsNotExist(err) {
+		return nil, err
+	}
+	return backend.Open(ExportPrefix + name)
+}
+
+// ListExports returns the subtitle exports of CacheDir and of the storage
+// backend, sorted by name. Keys are the export names, without ExportPrefix.
+func (ss *SubtitleService) ListExports() ([]storage.Object, error) {
+	exports := make(map[string]storage.Object)
+
+	entries, err := os.ReadDir(ss.config.CacheDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if _, ok := ExportSession(entry.Name()); !ok || entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		exports[entry.Name()] = storage.Object{Key: entry.Name(), Size: info.Size(), ModTime: info.ModTime()}
+	}
+
+	if backend := ss.getStorage(); backend != nil {
+		stored, err := backend.List(ExportPrefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range stored {
+			name := strings.TrimPrefix(obj.Key, ExportPrefix)
+			if _, ok := ExportSession(name); ok {
+				obj.Key = name
+				exports[name] = obj
+			}
+		}
+	}
+
+	list := make([]storage.Object, 0, len(exports))
+	for _, obj := range exports {
+		list = append(list, obj)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+
+	return list, nil
+}
+
+// DeleteExport removes a subtitle export, local and stored. Missing exports
+// are not an error.
+func (ss *SubtitleService) DeleteExport(name string) error {
+	if _, ok := ExportSession(name); !ok {
+		return ErrInvalidExport
+	}
+
+	if err := os.Remove(filepath.Join(ss.config.CacheDir, name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if backend := ss.getStorage(); backend != nil {
+		return backend.Delete(ExportPrefix + name)
+	}
+	return nil
+}
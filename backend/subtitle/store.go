@@ -0,0 +1,255 @@
+package subtitle
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Collections holding persisted subtitles
+const (
+	SessionsCollectionName = "subtitle_sessions"
+	EntriesCollectionName  = "subtitle_entries"
+)
+
+// RecordStore persists subtitles in the PocketBase "subtitle_sessions" and
+// "subtitle_entries" collections
+type RecordStore struct {
+	app core.App
+}
+
+// NewRecordStore creates a store backed by the given PocketBase app
+func NewRecordStore(app core.App) *RecordStore {
+	return &RecordStore{app: app}
+}
+
+// CreateSession inserts a session record
+func (s *RecordStore) CreateSession(info SessionInfo) (string, error) {
+	collection, err := s.app.Dao().FindCollectionByNameOrId(SessionsCollectionName)
+	if err != nil {
+		return "", err
+	}
+
+	record := models.NewRecord(collection)
+	record.Set("user", info.UserID)
+	record.Set("session_id", info.ID)
+	record.Set("channel_id", info.ChannelID)
+	record.Set("language", info.Language)
+	record.Set("target_langs", info.TargetLangs)
+	record.Set("source", info.Source)
+	record.Set("status", info.Status)
+
+	if err := s.app.Dao().SaveRecord(record); err != nil {
+		return "", err
+	}
+	return record.Id, nil
+}
+
+// AddEntry inserts an entry of a stored session
+func (s *RecordStore) AddEntry(storedID string, entry SubtitleEntry) error {
+	collection, err := s.app.Dao().FindCollectionByNameOrId(EntriesCollectionName)
+	if err != nil {
+		return err
+	}
+
+	record := models.NewRecord(collection)
+	record.Set("session", storedID)
+	record.Set("entry_id", entry.ID)
+	record.Set("start_time", entry.StartTime)
+	record.Set("end_time", entry.EndTime)
+	record.Set("text", entry.Text)
+	record.Set("language", entry.Language)
+	record.Set("original", entry.Original)
+	record.Set("speaker", entry.Speaker)
+	if len(entry.Translations) > 0 {
+		record.Set("translations", entry.Translations)
+	}
+	if len(entry.Words) > 0 {
+		record.Set("words", entry.Words)
+	}
+
+	return s.app.Dao().SaveRecord(record)
+}
+
+// EndSession records the final status of a stored session
+func (s *RecordStore) EndSession(storedID string, info SessionInfo, endedAt time.Time) error {
+	record, err := s.app.Dao().FindRecordById(SessionsCollectionName, storedID)
+	if err != nil {
+		return err
+	}
+
+	// Memory only holds the last MaxSubtitles entries, count the stored ones
+	var count int
+	err = s.app.Dao().DB().
+		Select("count(*)").
+		From(EntriesCollectionName).
+		Where(dbx.HashExp{"session": storedID}).
+		Row(&count)
+	if err != nil {
+		return err
+	}
+
+	record.Set("status", info.Status)
+	record.Set("language", info.Language) // Detected during the session for auto sessions
+	record.Set("error", info.Error)
+	record.Set("source", info.Source)
+	record.Set("subtitle_count", count)
+	record.Set("ended_at", endedAt)
+
+	return s.app.Dao().SaveRecord(record)
+}
+
+// LoadTranscript returns all entries of the latest stored session with
+// sessionID, in order
+func (s *RecordStore) LoadTranscript(sessionID string) ([]SubtitleEntry, error) {
+	sessions, err := s.app.Dao().FindRecordsByFilter(
+		SessionsCollectionName,
+		"session_id = {:session_id}",
+		"-created",
+		1,
+		0,
+		dbx.Params{"session_id": sessionID},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(sessions) == 0 {
+		return nil, nil
+	}
+
+	return s.Entries(sessions[0].Id)
+}
+
+// Entries returns the entries of the stored session with record ID
+// storedID, in order
+func (s *RecordStore) Entries(storedID string) ([]SubtitleEntry, error) {
+	records, err := s.app.Dao().FindRecordsByFilter(
+		EntriesCollectionName,
+		"session = {:session}",
+		"entry_id",
+		0,
+		0,
+		dbx.Params{"session": storedID},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]SubtitleEntry, 0, len(records))
+	for _, record := range records {
+		entry := SubtitleEntry{
+			ID:        record.GetInt("entry_id"),
+			StartTime: record.GetFloat("start_time"),
+			EndTime:   record.GetFloat("end_time"),
+			Text:      record.GetString("text"),
+			Language:  record.GetString("language"),
+			Original:  record.GetString("original"),
+			Speaker:   record.GetInt("speaker"),
+		}
+		if err := record.UnmarshalJSONField("translations", &entry.Translations); err != nil {
+			entry.Translations = nil
+		}
+		if err := record.UnmarshalJSONField("words", &entry.Words); err != nil {
+			entry.Words = nil
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Search returns up to limit entries of a user's sessions whose text,
+// recognized text or translations contain query, case-insensitively
+func (s *RecordStore) Search(userID, query string, limit int) ([]SearchMatch, error) {
+	pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(query) + "%"
+
+	rows := []struct {
+		EntryID      int     `db:"entry_id"`
+		StartTime    float64 `db:"start_time"`
+		EndTime      float64 `db:"end_time"`
+		Text         string  `db:"text"`
+		Language     string  `db:"language"`
+		Original     string  `db:"original"`
+		Translations string  `db:"translations"`
+		Speaker      int     `db:"speaker"`
+		SessionID    string  `db:"session_id"`
+		ChannelID    string  `db:"channel_id"`
+		Created      string  `db:"created"`
+	}{}
+
+	// Translations are matched as JSON here, then per language below
+	err := s.app.Dao().DB().
+		Select(
+			"e.entry_id", "e.start_time", "e.end_time", "e.text", "e.language", "e.original",
+			"COALESCE(e.translations, '') AS translations", "e.speaker",
+			"s.session_id", "s.channel_id", "s.created",
+		).
+		From(EntriesCollectionName+" e").
+		InnerJoin(SessionsCollectionName+" s", dbx.NewExp("s.id = e.session")).
+		Where(dbx.HashExp{"s.user": userID}).
+		AndWhere(dbx.NewExp(
+			`e.text LIKE {:pattern} ESCAPE '\' OR e.original LIKE {:pattern} ESCAPE '\' OR e.translations LIKE {:pattern} ESCAPE '\'`,
+			dbx.Params{"pattern": pattern},
+		)).
+		OrderBy("s.created DESC", "e.entry_id ASC").
+		Limit(int64(limit)).
+		All(&rows)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]SearchMatch, 0, len(rows))
+	for _, row := range rows {
+		entry := SubtitleEntry{Text: row.Text, Language: row.Language, Original: row.Original}
+		if row.Translations != "" {
+			json.Unmarshal([]byte(row.Translations), &entry.Translations)
+		}
+		text, language, ok := matchEntry(entry, query)
+		if !ok {
+			continue // Matched a language code in the translations JSON
+		}
+
+		date, _ := types.ParseDateTime(row.Created)
+		matches = append(matches, SearchMatch{
+			SessionID: row.SessionID,
+			ChannelID: row.ChannelID,
+			EntryID:   row.EntryID,
+			StartTime: row.StartTime,
+			EndTime:   row.EndTime,
+			Text:      text,
+			Language:  language,
+			Speaker:   row.Speaker,
+			Date:      date.Time(),
+		})
+	}
+	return matches, nil
+}
+
+// CloseInterrupted marks sessions still starting or running as failed. Run
+// at startup, when no session of this process exists yet.
+func (s *RecordStore) CloseInterrupted() error {
+	records, err := s.app.Dao().FindRecordsByFilter(
+		SessionsCollectionName,
+		"status = 'starting' || status = 'running'",
+		"",
+		0,
+		0,
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		record.Set("status", "error")
+		record.Set("error", "Interrupted by a server restart")
+		record.Set("ended_at", record.Updated.Time())
+		if err := s.app.Dao().SaveRecord(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
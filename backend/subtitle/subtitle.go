@@ -4,45 +4,91 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"iptv-backend/logging"
+	"iptv-backend/storage"
 )
 
 // SubtitleEntry represents a single subtitle line
 type SubtitleEntry struct {
-	ID             int     `json:"id"`
-	StartTime      float64 `json:"start_time"`
-	EndTime        float64 `json:"end_time"`
-	Text           string  `json:"text"`
-	Language       string  `json:"language,omitempty"`
-	ProcessingTime float64 `json:"processing_time,omitempty"` // Time taken to process this subtitle (ms)
+	ID             int               `json:"id"`
+	StartTime      float64           `json:"start_time"`
+	EndTime        float64           `json:"end_time"`
+	Text           string            `json:"text"`
+	Language       string            `json:"language,omitempty"`
+	ProcessingTime float64           `json:"processing_time,omitempty"` // Time taken to process this subtitle (ms)
+	Original       string            `json:"original,omitempty"`        // Recognized text, when Text is a translation
+	Translations   map[string]string `json:"translations,omitempty"`    // Text in each target language
+	Speaker        int               `json:"speaker,omitempty"`         // 1-based speaker from diarization, 0 when not diarized
+	Pending        bool              `json:"pending,omitempty"`         // Text is the first sentence of a translation still streaming
+	Revision       int               `json:"revision,omitempty"`        // Session revision of the last change to the entry
+	Words          []Word            `json:"words,omitempty"`           // Timing of each word of the recognized text (Original when set), when the engine reports it
+	Confidence     float64           `json:"confidence,omitempty"`      // How sure the speech engine is of the text, from 0 to 1, when it reports it
+	LowConfidence  bool              `json:"low_confidence,omitempty"`  // Confidence is below the threshold, the text may be made up
+
+	wallStart time.Time // When the line was spoken, zero for entries loaded from the store
 }
 
+// LanguageAuto as a session language lets Whisper detect the spoken language
+const LanguageAuto = "auto"
+
+// minLanguageProbability is the confidence needed to adopt a detected
+// language. Music and noise in the first chunks give unreliable guesses.
+const minLanguageProbability = 0.5
+
+// MaxTargetLangs bounds the translations made for every entry of a session
+const MaxTargetLangs = 5
+
+// ErrLanguageNotAvailable is returned when subtitles are requested in a
+// language the session neither recognizes nor translates to
+var ErrLanguageNotAvailable = errors.New("language not available in session")
+
+// ErrSessionNotFound is returned for a session that is neither running nor
+// stored
+var ErrSessionNotFound = errors.New("session not found")
+
 // SubtitleSession represents an active subtitle generation session
 type SubtitleSession struct {
-	ID           string           `json:"id"`
-	ChannelID    string           `json:"channel_id"`
-	StreamURL    string           `json:"stream_url"`
-	Status       string           `json:"status"` // starting, running, paused, stopped, error
-	Language     string           `json:"language"`
-	TargetLang   string           `json:"target_lang,omitempty"`
-	Subtitles    []SubtitleEntry  `json:"subtitles"`
-	CreatedAt    time.Time        `json:"created_at"`
-	Error        string           `json:"error,omitempty"`
+	ID               string          `json:"id"`
+	ChannelID        string          `json:"channel_id"`
+	UserID           string          `json:"user_id,omitempty"`
+	StreamURL        string          `json:"stream_url"`
+	Status           string          `json:"status"`   // starting, running, paused, stopped, error
+	Language         string          `json:"language"` // Empty until detected for auto sessions
+	LanguageDetected bool            `json:"language_detected,omitempty"`
+	TargetLang       string          `json:"target_lang,omitempty"` // First of TargetLangs, the language of entry texts
+	TargetLangs      []string        `json:"target_langs,omitempty"`
+	Subtitles        []SubtitleEntry `json:"subtitles"`
+	CreatedAt        time.Time       `json:"created_at"`
+	Error            string          `json:"error,omitempty"`
+	Partial          string          `json:"partial,omitempty"`         // Words recognized so far in the current utterance (vosk)
+	Source           string          `json:"source,omitempty"`          // speech, teletext or closed_captions
+	StopReason       string          `json:"stop_reason,omitempty"`     // user, idle or max_duration
+	DroppedChunks    int             `json:"dropped_chunks,omitempty"`  // Utterances skipped while transcription workers were busy
+	DroppedEntries   int             `json:"dropped_entries,omitempty"` // Entries discarded as likely made up: low confidence or hallucinations
+	Filters          TextFilters     `json:"filters"`                   // Post-processing of entry texts
+	Offset           float64         `json:"offset"`                    // Seconds added to the times of served entries
+	AutoOffset       bool            `json:"auto_offset,omitempty"`     // Offset follows the estimated pipeline delay
 
 	// Processing time tracking
-	ProcessingTimes    []float64 `json:"processing_times,omitempty"`     // Recent processing times in ms
-	AvgProcessingTime  float64   `json:"avg_processing_time,omitempty"`  // Average processing time in ms
+	ProcessingTimes   []float64 `json:"processing_times,omitempty"`    // Recent processing times in ms
+	AvgProcessingTime float64   `json:"avg_processing_time,omitempty"` // Average processing time in ms
 
 	// Internal
 	ctx          context.Context
@@ -51,19 +97,44 @@ type SubtitleSession struct {
 	audioBuffer  chan []byte
 	mu           sync.RWMutex
 	entryCounter int
+	finalized    bool
+	storedID     string             // Record ID in the store, only touched by the store goroutine
+	streamStart  time.Time          // When the running ffmpeg started, entry times count from it
+	lastSeen     atomic.Int64       // Unix nanoseconds of the last poll or heartbeat
+	speakers     *speakerTracker    // Nil when diarization is disabled
+	revision     int                // Bumped when an entry is added or completed
+	changed      chan struct{}      // Closed at the next change, nil until watched
+	burnIn       *burnIn            // Stream with the subtitles burned in, nil when not started
+	viewers      map[string]*viewer // Session IDs sharing the session, its own included
+	metrics      sessionMetrics
 }
 
 // SessionInfo returns public session information
 type SessionInfo struct {
-	ID                string    `json:"id"`
-	ChannelID         string    `json:"channel_id"`
-	Status            string    `json:"status"`
-	Language          string    `json:"language"`
-	TargetLang        string    `json:"target_lang,omitempty"`
-	SubCount          int       `json:"subtitle_count"`
-	CreatedAt         time.Time `json:"created_at"`
-	Error             string    `json:"error,omitempty"`
-	AvgProcessingTime float64   `json:"avg_processing_time,omitempty"` // Average processing time in ms
+	ID                string      `json:"id"`
+	ChannelID         string      `json:"channel_id"`
+	UserID            string      `json:"user_id,omitempty"`
+	Status            string      `json:"status"`
+	Language          string      `json:"language"`
+	LanguageDetected  bool        `json:"language_detected,omitempty"`
+	TargetLang        string      `json:"target_lang,omitempty"`
+	TargetLangs       []string    `json:"target_langs,omitempty"`
+	SubCount          int         `json:"subtitle_count"`
+	CreatedAt         time.Time   `json:"created_at"`
+	Error             string      `json:"error,omitempty"`
+	Partial           string      `json:"partial,omitempty"`
+	Source            string      `json:"source,omitempty"`
+	StopReason        string      `json:"stop_reason,omitempty"`
+	LastSeenAt        time.Time   `json:"last_seen_at"`
+	DroppedChunks     int         `json:"dropped_chunks,omitempty"`
+	DroppedEntries    int         `json:"dropped_entries,omitempty"`
+	Filters           TextFilters `json:"filters"`
+	Revision          int         `json:"revision"`                      // Poll with since_revision to get entries completed in place
+	Offset            float64     `json:"offset"`                        // Seconds added to the times of served entries
+	AutoOffset        bool        `json:"auto_offset,omitempty"`         // Offset follows EstimatedDelay
+	EstimatedDelay    float64     `json:"estimated_delay,omitempty"`     // Seconds from speech to its entry, 95th percentile
+	Viewers           int         `json:"viewers,omitempty"`             // Viewers sharing the session
+	AvgProcessingTime float64     `json:"avg_processing_time,omitempty"` // Average processing time in ms
 }
 
 // VoskResult represents Vosk speech recognition result
@@ -83,38 +154,77 @@ type OllamaRequest struct {
 	Model  string `json:"model"`
 	Prompt string `json:"prompt"`
 	Stream bool   `json:"stream"`
+	Format string `json:"format,omitempty"` // "json" constrains the response to JSON
 }
 
 // OllamaResponse represents Ollama API response
 type OllamaResponse struct {
-	Model     string `json:"model"`
-	Response  string `json:"response"`
-	Done      bool   `json:"done"`
+	Model    string `json:"model"`
+	Response string `json:"response"`
+	Done     bool   `json:"done"`
+	Error    string `json:"error,omitempty"`
 }
 
 // SubtitleServiceConfig holds configuration
 type SubtitleServiceConfig struct {
-	VoskModelPath   string        // Path to Vosk model directory
-	VoskServerURL   string        // URL to Vosk server (alternative to local)
-	OllamaURL       string        // Ollama API URL
-	OllamaModel     string        // Ollama model for translation
-	AudioSampleRate int           // Audio sample rate (16000 recommended for Vosk)
-	BufferDuration  time.Duration // Audio buffer duration
-	MaxSubtitles    int           // Max subtitles to keep in memory
-	CacheDir        string        // Directory for SRT exports
+	Engine               string           // Recognition engine: whisper (batches) or vosk (streaming)
+	VoskModelPath        string           // Path to Vosk model directory
+	VoskServerURL        string           // Vosk server WebSocket URL, used by the vosk engine
+	WhisperServerURL     string           // whisper.cpp server URL, empty to run the faster-whisper script per chunk
+	WhisperModel         string           // Model size used by the faster-whisper script: tiny, base, small or medium
+	WhisperModelDir      string           // Directory of downloaded Whisper models
+	EmbeddedSubtitles    bool             // Use teletext/closed caption tracks of the stream when present
+	OllamaURL            string           // Ollama API URL
+	OllamaModel          string           // Ollama model for translation
+	AudioSampleRate      int              // Audio sample rate (16000 recommended for Vosk)
+	VADSilence           time.Duration    // Pause that ends an utterance sent to Whisper
+	MaxChunkDuration     time.Duration    // Longest utterance sent to Whisper before a forced cut
+	MaxSubtitles         int              // Max subtitles to keep in memory
+	TranslationCacheSize int              // Translations kept to skip Ollama for repeated lines, 0 disables
+	IdleTimeout          time.Duration    // Stop sessions nobody polled for this long, 0 disables
+	MaxSessionDuration   time.Duration    // Stop sessions running longer than this, 0 disables
+	MaxTranscriptions    int              // Utterances transcribed in parallel across all sessions
+	Diarization          bool             // Label speech recognition entries with their speaker
+	MaxSpeakers          int              // Distinct speakers told apart per session
+	CacheDir             string           // Directory for exports, when not in a storage backend, and caches
+	BurnInDelay          time.Duration    // How far streams with subtitles burned in run behind live
+	ShareSessions        bool             // Viewers of the same channel share one session
+	Confidence           ConfidenceConfig // What to do with entries the speech engine is unsure of
+	HallucinationFilter  bool             // Drop text made up during music and silence
+
+	// InputArgs returns the ffmpeg options to read a stream with, such as
+	// the headers its provider requires. Optional.
+	InputArgs func(input string) []string
 }
 
 // DefaultSubtitleConfig returns default configuration
 func DefaultSubtitleConfig() SubtitleServiceConfig {
 	return SubtitleServiceConfig{
-		VoskModelPath:   "./models/vosk",
-		VoskServerURL:   "ws://localhost:2700",
-		OllamaURL:       "http://localhost:11434",
-		OllamaModel:     "llama3.2",
-		AudioSampleRate: 16000,
-		BufferDuration:  3 * time.Second, // Shorter for faster updates
-		MaxSubtitles:    1000,
-		CacheDir:        "./pb_data/subtitles",
+		Engine:               EngineWhisper,
+		EmbeddedSubtitles:    true,
+		VoskModelPath:        "./models/vosk",
+		VoskServerURL:        "ws://localhost:2700",
+		WhisperModel:         DefaultWhisperModel,
+		WhisperModelDir:      "./models/whisper",
+		OllamaURL:            "http://localhost:11434",
+		OllamaModel:          "llama3.2",
+		AudioSampleRate:      16000,
+		VADSilence:           500 * time.Millisecond,
+		MaxChunkDuration:     8 * time.Second, // Long enough for most sentences
+		MaxSubtitles:         1000,
+		TranslationCacheSize: 10000,
+		IdleTimeout:          5 * time.Minute,
+		MaxSessionDuration:   4 * time.Hour,
+		MaxTranscriptions:    2,
+		MaxSpeakers:          4,
+		CacheDir:             "./pb_data/subtitles",
+		BurnInDelay:          DefaultBurnInDelay,
+		ShareSessions:        true,
+		HallucinationFilter:  true,
+		Confidence: ConfidenceConfig{
+			MinConfidence: DefaultMinConfidence,
+			Action:        LowConfidenceDrop,
+		},
 	}
 }
 
@@ -123,6 +233,30 @@ type SubtitleService struct {
 	config   SubtitleServiceConfig
 	sessions map[string]*SubtitleSession
 	mu       sync.RWMutex
+	logger   *slog.Logger
+	workers  sync.WaitGroup // Session goroutines, waited on at shutdown
+	speech   SpeechBackend  // Transcribes the whisper engine's audio chunks
+
+	transcriber *transcriptionPool // Shares speech workers between sessions
+	models      *ModelManager      // Downloads the Whisper models of the script backend
+	voices      *voicePrinter      // Nil when diarization is disabled
+
+	translations *translationCache // Nil when disabled
+	prompts      *promptSet        // Translation prompts, guarded by mu
+
+	webhook      TranscriptWebhookConfig
+	webhookMu    sync.RWMutex
+	webhookQueue chan webhookDelivery
+
+	endedHandlers []func(info SessionInfo)
+
+	exportStorage storage.Backend // Where exports are written, nil for CacheDir, guarded by mu
+
+	store       Store // Nil when persistence is disabled
+	storeQueue  chan storeOp
+	storeDone   chan struct{}
+	storeMu     sync.Mutex
+	storeClosed bool
 }
 
 // GetConfig returns current configuration
@@ -143,9 +277,48 @@ func (ss *SubtitleService) UpdateOllamaConfig(url, model string) {
 	}
 }
 
-// GetOllamaModels fetches available models from Ollama
-func (ss *SubtitleService) GetOllamaModels() ([]string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// Models returns the Whisper model manager
+func (ss *SubtitleService) Models() *ModelManager {
+	return ss.models
+}
+
+// SpeechBackendName returns the backend transcribing chunks of the whisper
+// engine. Model selection only applies to the faster-whisper script.
+func (ss *SubtitleService) SpeechBackendName() string {
+	return ss.speech.Name()
+}
+
+// SetWhisperModel selects the Whisper model of new chunks and downloads it
+// if needed
+func (ss *SubtitleService) SetWhisperModel(name string) error {
+	if !IsWhisperModel(name) {
+		return ErrUnknownModel
+	}
+
+	ss.mu.Lock()
+	ss.config.WhisperModel = name
+	ss.mu.Unlock()
+
+	return ss.models.Download(name)
+}
+
+// whisperModel returns the selected model and its directory, empty when
+// it isn't downloaded
+func (ss *SubtitleService) whisperModel() (name, path string) {
+	ss.mu.RLock()
+	name = ss.config.WhisperModel
+	ss.mu.RUnlock()
+
+	if ss.models.Installed(name) {
+		path = ss.models.Path(name)
+	}
+	return name, path
+}
+
+// GetOllamaModels fetches available models from Ollama, giving up when ctx
+// is done
+func (ss *SubtitleService) GetOllamaModels(ctx context.Context) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	req, err := http.NewRequestWithContext(ctx, "GET", ss.config.OllamaURL+"/api/tags", nil)
@@ -186,14 +359,62 @@ func (ss *SubtitleService) GetOllamaModels() ([]string, error) {
 func NewSubtitleService(config SubtitleServiceConfig) *SubtitleService {
 	os.MkdirAll(config.CacheDir, 0755)
 
-	return &SubtitleService{
-		config:   config,
-		sessions: make(map[string]*SubtitleSession),
+	ss := &SubtitleService{
+		config:       config,
+		sessions:     make(map[string]*SubtitleSession),
+		logger:       logging.For("subtitle"),
+		webhookQueue: make(chan webhookDelivery, 500),
+		prompts:      defaultPrompts,
+	}
+	ss.models = NewModelManager(config.WhisperModelDir, ss.logger)
+	ss.speech = newSpeechBackend(config, ss.logger, ss.whisperModel)
+	ss.logger.Info("speech backend selected", "backend", ss.speech.Name(), "max_transcriptions", config.MaxTranscriptions)
+	if config.Diarization {
+		ss.voices = newVoicePrinter(config.AudioSampleRate)
+	}
+	ss.transcriber = newTranscriptionPool(config.MaxTranscriptions, ss.transcribeSegment, ss.chunkDropped)
+
+	if config.TranslationCacheSize > 0 {
+		ss.translations = newTranslationCache(config.CacheDir, config.TranslationCacheSize)
+		go ss.translationCacheLoop()
 	}
+
+	// Start transcript webhook delivery worker
+	go ss.webhookLoop()
+
+	if config.IdleTimeout > 0 || config.MaxSessionDuration > 0 {
+		go ss.idleLoop()
+	}
+
+	return ss
+}
+
+// OnSessionEnded registers a handler called once when a session stops or fails
+func (ss *SubtitleService) OnSessionEnded(handler func(info SessionInfo)) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.endedHandlers = append(ss.endedHandlers, handler)
 }
 
-// StartSession starts a new subtitle generation session
-func (ss *SubtitleService) StartSession(sessionID, channelID, streamURL, language, targetLang string) (*SubtitleSession, error) {
+// StartSession starts a new subtitle generation session for a user. Nothing
+// is started when ctx, the context of the request, is already done; the
+// session itself runs until stopped.
+func (ss *SubtitleService) StartSession(ctx context.Context, sessionID, userID, channelID, streamURL, language string, targetLangs []string, filters TextFilters) (*SubtitleSession, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if language == LanguageAuto {
+		language = ""
+	}
+	targetLangs = normalizeTargetLangs(language, targetLangs)
+	if len(targetLangs) > MaxTargetLangs {
+		return nil, fmt.Errorf("at most %d target languages are supported", MaxTargetLangs)
+	}
+	targetLang := ""
+	if len(targetLangs) > 0 {
+		targetLang = targetLangs[0]
+	}
+
 	ss.mu.Lock()
 	defer ss.mu.Unlock()
 
@@ -202,25 +423,49 @@ func (ss *SubtitleService) StartSession(sessionID, channelID, streamURL, languag
 		return nil, fmt.Errorf("session %s already exists", sessionID)
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	// Another viewer of the channel may already run a session for it
+	if shared := ss.joinLocked(sessionID, userID, channelID, language, targetLangs, filters); shared != nil {
+		return shared, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
 
 	session := &SubtitleSession{
 		ID:          sessionID,
 		ChannelID:   channelID,
+		UserID:      userID,
 		StreamURL:   streamURL,
 		Status:      "starting",
 		Language:    language,
 		TargetLang:  targetLang,
+		TargetLangs: targetLangs,
+		Filters:     filters,
 		Subtitles:   make([]SubtitleEntry, 0),
 		CreatedAt:   time.Now(),
 		ctx:         ctx,
 		cancel:      cancel,
 		audioBuffer: make(chan []byte, 100),
+		viewers:     map[string]*viewer{sessionID: {UserID: userID, TargetLangs: targetLangs}},
+	}
+
+	// Fetch the model ahead of the first chunk, the script would otherwise
+	// download it on every call until its own cache is filled
+	if ss.config.Engine == EngineWhisper && ss.config.WhisperServerURL == "" {
+		if err := ss.models.Download(ss.config.WhisperModel); err != nil {
+			ss.logger.Warn("failed to start whisper model download", "model", ss.config.WhisperModel, "error", err)
+		}
+	}
+
+	if ss.voices != nil {
+		session.speakers = &speakerTracker{max: ss.config.MaxSpeakers}
 	}
 
+	session.touch()
 	ss.sessions[sessionID] = session
+	ss.persistSessionStart(session, session.info())
 
 	// Start processing in background
+	ss.workers.Add(1)
 	go ss.processStream(session)
 
 	return session, nil
@@ -228,40 +473,99 @@ func (ss *SubtitleService) StartSession(sessionID, channelID, streamURL, languag
 
 // processStream handles audio extraction and speech recognition
 func (ss *SubtitleService) processStream(session *SubtitleSession) {
-	log.Printf("Starting subtitle session %s for channel %s (language: %s, target: %s)",
-		session.ID, session.ChannelID, session.Language, session.TargetLang)
+	defer ss.workers.Done()
+
+	logger := ss.sessionLogger(session)
+	logger.Info("starting subtitle session", "language", session.Language, "target_lang", session.TargetLang)
 
 	// Update status
 	session.mu.Lock()
 	session.Status = "running"
+	session.notifyLocked()
 	session.mu.Unlock()
 
-	// Extract audio using FFmpeg
-	err := ss.extractAndProcessAudio(session)
+	// Prefer subtitles the channel already carries over speech recognition
+	var err error
+	if track := ss.embeddedTrack(session); track != nil {
+		err = ss.processEmbedded(session, track)
+		if err != nil && session.ctx.Err() == nil {
+			logger.Warn("embedded subtitles failed, falling back to speech recognition", "source", track.Source, "error", err)
+			ss.setSource(session, SourceSpeech)
+			err = ss.extractAndProcessAudio(session)
+		}
+	} else {
+		// Extract audio using FFmpeg
+		err = ss.extractAndProcessAudio(session)
+	}
 	if err != nil {
 		session.mu.Lock()
 		session.Status = "error"
 		session.Error = err.Error()
+		session.notifyLocked()
 		session.mu.Unlock()
-		log.Printf("Subtitle session %s error: %v", session.ID, err)
+		logger.Error("subtitle session failed", "error", err)
+		ss.finalizeSession(session)
 		return
 	}
 
 	session.mu.Lock()
 	session.Status = "stopped"
+	session.notifyLocked()
 	session.mu.Unlock()
+
+	ss.finalizeSession(session)
+}
+
+// embeddedTrack returns the subtitle track to use instead of speech
+// recognition, if enabled and the stream has one, and records the source
+func (ss *SubtitleService) embeddedTrack(session *SubtitleSession) *embeddedTrack {
+	if !ss.config.EmbeddedSubtitles {
+		ss.setSource(session, SourceSpeech)
+		return nil
+	}
+
+	track, err := findEmbeddedTrack(session.ctx, session.StreamURL, session.language(), ss.inputOptions(session.StreamURL))
+	if err != nil {
+		ss.sessionLogger(session).Debug("subtitle track probe failed", "error", err)
+	}
+	if track == nil {
+		ss.setSource(session, SourceSpeech)
+		return nil
+	}
+
+	ss.sessionLogger(session).Info("using embedded subtitles", "source", track.Source, "track_language", track.Language)
+	if session.language() == "" {
+		ss.setDetectedLanguage(session, trackLanguageCode(track.Language))
+	}
+	ss.setSource(session, track.Source)
+	return track
+}
+
+func (ss *SubtitleService) setSource(session *SubtitleSession, source string) {
+	session.mu.Lock()
+	session.Source = source
+	session.notifyLocked()
+	session.mu.Unlock()
+}
+
+// inputOptions returns the ffmpeg options to read a stream with
+func (ss *SubtitleService) inputOptions(streamURL string) []string {
+	if ss.config.InputArgs == nil {
+		return nil
+	}
+	return ss.config.InputArgs(streamURL)
 }
 
 // extractAndProcessAudio extracts audio from stream and processes it
 func (ss *SubtitleService) extractAndProcessAudio(session *SubtitleSession) error {
 	// FFmpeg command to extract audio as raw PCM
-	// -i: input stream
+	// -i: input stream, after the options its provider requires
 	// -vn: no video
 	// -acodec pcm_s16le: 16-bit PCM audio
 	// -ar: sample rate
 	// -ac 1: mono
 	// -f s16le: raw PCM format
-	args := []string{
+	args := append(ss.inputOptions(session.StreamURL),
 		"-i", session.StreamURL,
 		"-vn",
 		"-acodec", "pcm_s16le",
@@ -269,7 +573,7 @@ func (ss *SubtitleService) extractAndProcessAudio(session *SubtitleSession) erro
 		"-ac", "1",
 		"-f", "s16le",
 		"-",
-	}
+	)
 
 	cmd := exec.CommandContext(session.ctx, "ffmpeg", args...)
 
@@ -283,9 +587,22 @@ func (ss *SubtitleService) extractAndProcessAudio(session *SubtitleSession) erro
 	if err := cmd.Start(); err != nil {
 		return fmt.Errorf("failed to start ffmpeg: %w", err)
 	}
+	session.markStreamStart()
 
-	// Start Vosk processing goroutine
-	go ss.processWithVosk(session, stdout)
+	// Start recognition goroutine
+	ss.workers.Add(1)
+	go func() {
+		defer ss.workers.Done()
+		if ss.config.Engine == EngineVosk {
+			err := ss.processWithVosk(session, stdout)
+			if err == nil || session.ctx.Err() != nil {
+				return
+			}
+			// Keep subtitles coming from the rest of the audio
+			ss.sessionLogger(session).Warn("vosk streaming failed, falling back to whisper", "error", err)
+		}
+		ss.processWithWhisper(session, stdout)
+	}()
 
 	// Wait for ffmpeg to finish or context cancellation
 	err = cmd.Wait()
@@ -299,286 +616,276 @@ func (ss *SubtitleService) extractAndProcessAudio(session *SubtitleSession) erro
 	return nil
 }
 
-// processWithVosk sends audio to Vosk for speech recognition
-func (ss *SubtitleService) processWithVosk(session *SubtitleSession, audioReader io.Reader) {
-	// Buffer to accumulate audio chunks
-	bufferSize := ss.config.AudioSampleRate * 2 * int(ss.config.BufferDuration.Seconds()) // 16-bit samples
-	buffer := make([]byte, bufferSize)
+// processWithWhisper transcribes the audio utterance by utterance: voice
+// activity detection cuts it at pauses and each chunk goes to Whisper
+func (ss *SubtitleService) processWithWhisper(session *SubtitleSession, audioReader io.Reader) {
+	logger := ss.sessionLogger(session)
 
-	startTime := time.Now()
+	segments := newSegmenter(ss.config.AudioSampleRate, ss.config.VADSilence, ss.config.MaxChunkDuration)
+	frame := make([]byte, segments.frameSize)
 
 	for {
 		select {
 		case <-session.ctx.Done():
+			ss.transcriber.Discard(session)
 			return
 		default:
 		}
 
-		// Read exactly bufferSize bytes to ensure complete audio chunks
-		// This prevents sending incomplete audio to Whisper
-		n, err := io.ReadFull(audioReader, buffer)
-		if err != nil {
-			if err == io.EOF || err == io.ErrUnexpectedEOF {
-				// If we got some data, process it
-				if n > 0 {
-					// Process the partial buffer
-					goto processAudio
-				}
+		if _, err := io.ReadFull(audioReader, frame); err != nil {
+			if err != io.EOF && err != io.ErrUnexpectedEOF {
+				logger.Error("audio read error", "error", err)
+				ss.transcriber.Discard(session)
 				return
 			}
-			log.Printf("Audio read error: %v", err)
-			return
-		}
-
-	processAudio:
-		if n == 0 {
-			continue
-		}
-
-		// Calculate timing
-		elapsedSeconds := time.Since(startTime).Seconds()
-
-		// Measure processing time
-		processingStart := time.Now()
-
-		// Process audio chunk with Whisper
-		text, err := ss.recognizeWithWhisper(buffer[:n], session.Language)
-		if err != nil {
-			log.Printf("Whisper recognition error: %v", err)
-			continue
-		}
-
-		if text == "" {
-			continue
-		}
-
-		// Translate if target language is different
-		finalText := text
-		if session.TargetLang != "" && session.TargetLang != session.Language {
-			log.Printf("Translating from %s to %s: %s", session.Language, session.TargetLang, text)
-			translated, err := ss.translateWithOllama(text, session.Language, session.TargetLang)
-			if err != nil {
-				log.Printf("Translation error: %v", err)
-				// Keep original text if translation fails
-			} else {
-				log.Printf("Translation result: %s", translated)
-				finalText = translated
+			// End of stream: transcribe the utterance in progress
+			if segment := segments.Flush(); segment != nil {
+				ss.transcriber.Submit(session, segment)
 			}
+			ss.transcriber.Drain(session)
+			return
 		}
 
-		// Calculate processing time in milliseconds
-		processingTimeMs := float64(time.Since(processingStart).Milliseconds())
-
-		// Add subtitle entry
-		session.mu.Lock()
-		session.entryCounter++
-		entry := SubtitleEntry{
-			ID:             session.entryCounter,
-			StartTime:      elapsedSeconds - ss.config.BufferDuration.Seconds(),
-			EndTime:        elapsedSeconds,
-			Text:           finalText,
-			Language:       session.TargetLang,
-			ProcessingTime: processingTimeMs,
-		}
-		if entry.Language == "" {
-			entry.Language = session.Language
+		if segment := segments.Push(frame); segment != nil {
+			ss.transcriber.Submit(session, segment)
 		}
+	}
+}
 
-		session.Subtitles = append(session.Subtitles, entry)
+// transcribeSegment runs one utterance through the speech backend, on a
+// worker of the transcription pool
+func (ss *SubtitleService) transcribeSegment(session *SubtitleSession, segment *speechSegment) {
+	if session.ctx.Err() != nil {
+		return
+	}
 
-		// Track processing times (keep last 20 samples for averaging)
-		session.ProcessingTimes = append(session.ProcessingTimes, processingTimeMs)
-		if len(session.ProcessingTimes) > 20 {
-			session.ProcessingTimes = session.ProcessingTimes[len(session.ProcessingTimes)-20:]
-		}
+	processingStart := time.Now()
 
-		// Calculate average processing time
-		var sum float64
-		for _, pt := range session.ProcessingTimes {
-			sum += pt
-		}
-		session.AvgProcessingTime = sum / float64(len(session.ProcessingTimes))
+	session.mu.RLock()
+	spokenEnd := session.streamTime(segment.End)
+	session.mu.RUnlock()
+	if !spokenEnd.IsZero() {
+		session.record(&session.metrics.audioWait, processingStart.Sub(spokenEnd))
+	}
 
-		// Trim old subtitles if needed
-		if len(session.Subtitles) > ss.config.MaxSubtitles {
-			session.Subtitles = session.Subtitles[len(session.Subtitles)-ss.config.MaxSubtitles:]
+	language := session.language()
+	result, err := ss.speech.Transcribe(session.ctx, segment.PCM, ss.config.AudioSampleRate, language)
+	if err != nil {
+		if session.ctx.Err() == nil {
+			ss.sessionLogger(session).Warn("whisper recognition error", "backend", ss.speech.Name(), "error", err)
 		}
-		session.mu.Unlock()
+		return
+	}
+	session.record(&session.metrics.transcription, time.Since(processingStart))
 
-		log.Printf("Subtitle [%s]: %s", session.ID, finalText)
+	if result.Text == "" || ss.suppressHallucination(session, result.Text) {
+		return
 	}
-}
 
-// recognizeWithWhisper uses faster-whisper for speech recognition
-func (ss *SubtitleService) recognizeWithWhisper(audioData []byte, language string) (string, error) {
-	// Create temp WAV file for audio (Whisper needs WAV format)
-	tmpRaw, err := os.CreateTemp("", "audio-*.raw")
-	if err != nil {
-		return "", err
+	// Until a language is adopted every chunk is detected again
+	if language == "" && (result.LanguageProbability == 0 || result.LanguageProbability >= minLanguageProbability) {
+		ss.setDetectedLanguage(session, result.Language)
 	}
-	tmpRawName := tmpRaw.Name()
-	defer os.Remove(tmpRawName)
 
-	if _, err := tmpRaw.Write(audioData); err != nil {
-		tmpRaw.Close()
-		return "", err
+	speaker := 0
+	if session.speakers != nil {
+		speaker = session.speakers.Assign(ss.voices.Print(segment.PCM), segment.End-segment.Start)
 	}
-	tmpRaw.Close()
 
-	// Convert raw PCM to WAV using ffmpeg
-	tmpWav := tmpRawName + ".wav"
-	defer os.Remove(tmpWav)
+	words := offsetWords(result.Words, segment.Start)
+	ss.addEntry(session, result.Text, segment.Start, segment.End, speaker, words, result.Confidence, processingStart)
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
-	defer cancel()
+// addEntry translates a recognized utterance if needed and appends it to the
+// session. speaker is 0 when not diarized, words are nil when the engine
+// doesn't time them, confidence is 0 when it doesn't score them,
+// processingStart is when recognition of the utterance began.
+func (ss *SubtitleService) addEntry(session *SubtitleSession, text string, start, end float64, speaker int, words []Word, confidence float64, processingStart time.Time) {
+	logger := ss.sessionLogger(session)
 
-	// Convert raw PCM (s16le, 16000Hz, mono) to WAV
-	convertCmd := exec.CommandContext(ctx, "ffmpeg",
-		"-f", "s16le",
-		"-ar", strconv.Itoa(ss.config.AudioSampleRate),
-		"-ac", "1",
-		"-i", tmpRawName,
-		"-y",
-		"-loglevel", "error",
-		tmpWav,
-	)
-	if err := convertCmd.Run(); err != nil {
-		return "", fmt.Errorf("failed to convert audio to WAV: %w", err)
+	text = session.Filters.clean(text)
+	if text == "" {
+		return
 	}
+	words = session.Filters.cleanWords(words)
 
-	// Use our Python script for transcription (uses faster-whisper)
-	scriptPath := filepath.Join(filepath.Dir(os.Args[0]), "scripts", "transcribe.py")
+	// Dropped before translation, so made up text costs no Ollama call
+	lowConfidence, drop := ss.lowConfidence(confidence)
+	if drop {
+		session.mu.Lock()
+		session.DroppedEntries++
+		session.mu.Unlock()
+		logger.Debug("subtitle entry dropped below confidence threshold", "confidence", confidence, "text", text)
+		return
+	}
 
-	// Check if script exists, fallback to whisper CLI if not
-	if _, err := os.Stat(scriptPath); os.IsNotExist(err) {
-		// Fallback to whisper CLI
-		return ss.recognizeWithWhisperCLI(ctx, tmpWav, language)
+	// The first sentence of the primary translation is shown as soon as
+	// Ollama has streamed it, the entry is completed in place afterwards
+	pendingID := 0
+	showFirstSentence := func(partial string) {
+		pendingID = ss.appendEntry(session, SubtitleEntry{
+			StartTime:      start,
+			EndTime:        end,
+			Text:           session.Filters.Apply(partial),
+			Language:       session.TargetLang,
+			ProcessingTime: float64(time.Since(processingStart).Milliseconds()),
+			Speaker:        speaker,
+			Words:          words,
+			Confidence:     confidence,
+			LowConfidence:  lowConfidence,
+			Pending:        true,
+		})
+		logger.Debug("subtitle entry shown before its translation completed", "entry_id", pendingID)
 	}
 
-	whisperCmd := exec.CommandContext(ctx, "python3", scriptPath, tmpWav, language)
+	// One recognition pass fans out to every target language
+	translationStart := time.Now()
+	translations := ss.translate(session, text, showFirstSentence)
+	if translations != nil {
+		session.record(&session.metrics.translation, time.Since(translationStart))
+	}
+	for lang, translated := range translations {
+		translations[lang] = session.Filters.Apply(translated)
+	}
 
-	output, err := whisperCmd.CombinedOutput()
-	if err != nil {
-		log.Printf("Transcription script error: %v, output: %s", err, string(output))
-		// Fallback to whisper CLI
-		return ss.recognizeWithWhisperCLI(ctx, tmpWav, language)
+	// Keep original text if translation to the primary language fails
+	finalText := session.Filters.Apply(text)
+	language := session.language()
+	if translated, ok := translations[session.TargetLang]; ok {
+		finalText = translated
+		language = session.TargetLang
 	}
 
-	var result struct {
-		Success bool   `json:"success"`
-		Text    string `json:"text"`
-		Error   string `json:"error,omitempty"`
+	entry := SubtitleEntry{
+		StartTime:      start,
+		EndTime:        end,
+		Text:           finalText,
+		Language:       language,
+		ProcessingTime: float64(time.Since(processingStart).Milliseconds()),
+		Speaker:        speaker,
+		Words:          words,
+		Confidence:     confidence,
+		LowConfidence:  lowConfidence,
 	}
-	if err := json.Unmarshal(output, &result); err != nil {
-		log.Printf("Failed to parse transcription output: %v, raw: %s", err, string(output))
-		return "", fmt.Errorf("failed to parse transcription output: %w", err)
+	if len(translations) > 0 {
+		entry.Original = session.Filters.Apply(text)
+		entry.Translations = translations
 	}
 
-	if !result.Success {
-		return "", fmt.Errorf("transcription failed: %s", result.Error)
+	if pendingID == 0 {
+		entry.ID = ss.appendEntry(session, entry)
+	} else {
+		entry = ss.completeEntry(session, pendingID, entry)
 	}
 
-	return strings.TrimSpace(result.Text), nil
-}
+	ss.persistEntry(session, entry)
 
-// recognizeWithWhisperCLI uses whisper CLI as fallback
-func (ss *SubtitleService) recognizeWithWhisperCLI(ctx context.Context, wavFile, language string) (string, error) {
-	// Run whisper with JSON output
-	tmpDir := filepath.Dir(wavFile)
+	logger.Debug("subtitle entry added", "entry_id", entry.ID, "text", finalText)
 
-	whisperCmd := exec.CommandContext(ctx, "whisper",
-		wavFile,
-		"--language", language,
-		"--output_format", "json",
-		"--output_dir", tmpDir,
-		"--model", "base",
-	)
+	ss.publishEntry(session, entry)
+}
 
-	output, err := whisperCmd.CombinedOutput()
-	if err != nil {
-		log.Printf("Whisper CLI error: %v, output: %s", err, string(output))
-		return "", fmt.Errorf("whisper failed: %w", err)
+// appendEntry gives entry the next ID and revision, adds it to the session
+// and returns its ID
+func (ss *SubtitleService) appendEntry(session *SubtitleSession, entry SubtitleEntry) int {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	session.entryCounter++
+	session.revision++
+	entry.ID = session.entryCounter
+	entry.Revision = session.revision
+	entry.wallStart = session.streamTime(entry.StartTime)
+	if spokenEnd := session.streamTime(entry.EndTime); !spokenEnd.IsZero() {
+		session.metrics.lag.add(time.Since(spokenEnd))
 	}
+	session.recordDelay(entry)
 
-	// Read the JSON output - whisper names output based on input filename
-	baseName := filepath.Base(wavFile)
-	jsonFile := filepath.Join(tmpDir, strings.TrimSuffix(baseName, filepath.Ext(baseName))+".json")
-	defer os.Remove(jsonFile)
+	session.Subtitles = append(session.Subtitles, entry)
+	session.notifyLocked()
 
-	jsonData, err := os.ReadFile(jsonFile)
-	if err != nil {
-		return "", fmt.Errorf("failed to read whisper output: %w", err)
+	// Track processing times (keep last 20 samples for averaging)
+	session.ProcessingTimes = append(session.ProcessingTimes, entry.ProcessingTime)
+	if len(session.ProcessingTimes) > 20 {
+		session.ProcessingTimes = session.ProcessingTimes[len(session.ProcessingTimes)-20:]
 	}
 
-	var result struct {
-		Text string `json:"text"`
+	// Calculate average processing time
+	var sum float64
+	for _, pt := range session.ProcessingTimes {
+		sum += pt
 	}
-	if err := json.Unmarshal(jsonData, &result); err != nil {
-		return "", fmt.Errorf("failed to parse whisper output: %w", err)
+	session.AvgProcessingTime = sum / float64(len(session.ProcessingTimes))
+
+	// Trim old subtitles if needed
+	if len(session.Subtitles) > ss.config.MaxSubtitles {
+		session.Subtitles = session.Subtitles[len(session.Subtitles)-ss.config.MaxSubtitles:]
 	}
 
-	return strings.TrimSpace(result.Text), nil
+	return entry.ID
 }
 
-// callVoskServer calls a Vosk WebSocket server
-func (ss *SubtitleService) callVoskServer(ctx context.Context, audioData []byte, language string) (string, error) {
-	// Simple HTTP fallback if WebSocket not available
-	// This assumes a Vosk HTTP API endpoint
-	url := strings.Replace(ss.config.VoskServerURL, "ws://", "http://", 1)
-	url = strings.Replace(url, "wss://", "https://", 1)
-	url = url + "/recognize"
-
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(audioData))
-	if err != nil {
-		return "", err
+// completeEntry replaces the pending entry id with the final entry, under a
+// new revision so pollers see the change. The processing time stays the one
+// of the pending entry, when the line was first shown.
+func (ss *SubtitleService) completeEntry(session *SubtitleSession, id int, entry SubtitleEntry) SubtitleEntry {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	session.revision++
+	entry.ID = id
+	entry.Revision = session.revision
+
+	for i := len(session.Subtitles) - 1; i >= 0; i-- {
+		if session.Subtitles[i].ID == id {
+			entry.ProcessingTime = session.Subtitles[i].ProcessingTime
+			entry.wallStart = session.Subtitles[i].wallStart
+			session.Subtitles[i] = entry
+			break
+		}
 	}
-	req.Header.Set("Content-Type", "audio/raw")
-	req.Header.Set("X-Language", language)
+	session.notifyLocked()
+	return entry
+}
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+// sessionLogger returns a logger tagged with the session and channel IDs
+func (ss *SubtitleService) sessionLogger(session *SubtitleSession) *slog.Logger {
+	return ss.logger.With("session_id", session.ID, "channel_id", session.ChannelID)
+}
+
+// translateWithOllama translates text using Ollama. onFirstSentence, when
+// not nil, receives the first translated sentence as soon as it has been
+// streamed, if the translation has more than one. The request is aborted
+// when ctx is done.
+func (ss *SubtitleService) translateWithOllama(ctx context.Context, text, fromLang, toLang string, onFirstSentence func(string)) (string, error) {
+	prompt, promptID, err := ss.translationPrompt(text, fromLang, toLang)
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("translation prompt: %w", err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("vosk server returned %d", resp.StatusCode)
+	if ss.translations == nil {
+		return ss.requestTranslation(ctx, prompt, onFirstSentence)
 	}
 
-	var result VoskResult
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", err
+	key := translationKey(ss.config.OllamaModel, promptID, fromLang, toLang, text)
+	if translation, ok := ss.translations.Get(key); ok {
+		return translation, nil
 	}
 
-	return result.Text, nil
+	translation, err := ss.requestTranslation(ctx, prompt, onFirstSentence)
+	if err == nil && translation != "" {
+		ss.translations.Put(key, translation)
+	}
+	return translation, err
 }
 
-// translateWithOllama translates text using Ollama
-func (ss *SubtitleService) translateWithOllama(text, fromLang, toLang string) (string, error) {
-	// Use a strict system prompt to avoid commentary
-	prompt := fmt.Sprintf(
-		`You are a subtitle translator. Translate the following from %s to %s.
-
-RULES:
-- Output ONLY the translation, nothing else
-- No explanations, notes, or commentary
-- No quotation marks around the translation
-- Keep the same tone and style
-- If text is unclear, translate it as best as you can
-
-Text: %s
-
-Translation:`,
-		getLanguageName(fromLang),
-		getLanguageName(toLang),
-		text,
-	)
-
+// requestTranslation asks Ollama for a translation with prompt, streamed
+// token by token
+func (ss *SubtitleService) requestTranslation(ctx context.Context, prompt string, onFirstSentence func(string)) (string, error) {
 	reqBody := OllamaRequest{
 		Model:  ss.config.OllamaModel,
 		Prompt: prompt,
-		Stream: false,
+		Stream: true,
 	}
 
 	jsonBody, err := json.Marshal(reqBody)
@@ -586,7 +893,7 @@ Translation:`,
 		return "", err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	req, err := http.NewRequestWithContext(ctx, "POST", ss.config.OllamaURL+"/api/generate", bytes.NewReader(jsonBody))
@@ -607,12 +914,59 @@ Translation:`,
 		return "", fmt.Errorf("ollama returned %d: %s", resp.StatusCode, string(body))
 	}
 
-	var result OllamaResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", err
+	// Each line of the stream holds the next tokens
+	var response strings.Builder
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var chunk OllamaResponse
+		if err := decoder.Decode(&chunk); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return "", err
+		}
+		if chunk.Error != "" {
+			return "", fmt.Errorf("ollama error: %s", chunk.Error)
+		}
+
+		response.WriteString(chunk.Response)
+		current := strings.TrimSpace(response.String())
+
+		// Anything after a line break is commentary, stop generating it
+		if strings.Contains(current, "\n") || chunk.Done {
+			break
+		}
+
+		if onFirstSentence != nil {
+			if end := firstSentenceEnd(current); end > 0 {
+				onFirstSentence(cleanTranslation(current[:end]))
+				onFirstSentence = nil
+			}
+		}
+	}
+
+	return cleanTranslation(response.String()), nil
+}
+
+// firstSentenceEnd returns the length of the first sentence of text once
+// the next one has started, 0 before
+func firstSentenceEnd(text string) int {
+	for i, r := range text {
+		switch r {
+		case '.', '!', '?', '…', '。':
+			next := i + utf8.RuneLen(r)
+			if next < len(text) && unicode.IsSpace(rune(text[next])) && strings.TrimSpace(text[next:]) != "" {
+				return next
+			}
+		}
 	}
+	return 0
+}
 
-	translation := strings.TrimSpace(result.Response)
+// cleanTranslation strips the notes, quotes and explanations LLMs add
+// around a translation
+func cleanTranslation(translation string) string {
+	translation = strings.TrimSpace(translation)
 
 	// Clean up common LLM artifacts
 	// Remove parenthetical notes like "(Note: ...)" or "(correction: ...)"
@@ -629,7 +983,7 @@ Translation:`,
 		translation = translation[:idx]
 	}
 
-	return strings.TrimSpace(translation), nil
+	return strings.TrimSpace(translation)
 }
 
 // StopSession stops a subtitle session
@@ -642,6 +996,18 @@ func (ss *SubtitleService) StopSession(sessionID string) error {
 		return fmt.Errorf("session %s not found", sessionID)
 	}
 
+	// Others still watching keep the session running
+	if ss.leaveLocked(session, sessionID) {
+		return nil
+	}
+
+	ss.stopSession(session, StopReasonUser)
+
+	return nil
+}
+
+// stopSession cancels a session's processing and finalizes it
+func (ss *SubtitleService) stopSession(session *SubtitleSession, reason string) {
 	session.cancel()
 
 	if session.ffmpegCmd != nil && session.ffmpegCmd.Process != nil {
@@ -650,9 +1016,47 @@ func (ss *SubtitleService) StopSession(sessionID string) error {
 
 	session.mu.Lock()
 	session.Status = "stopped"
+	session.StopReason = reason
+	session.notifyLocked()
 	session.mu.Unlock()
 
-	return nil
+	go ss.finalizeSession(session)
+}
+
+// Shutdown cancels all sessions and waits for their ffmpeg and whisper
+// processes to exit, up to timeout
+func (ss *SubtitleService) Shutdown(timeout time.Duration) {
+	ss.mu.RLock()
+	sessions := ss.distinctSessionsLocked()
+	ss.mu.RUnlock()
+
+	if len(sessions) > 0 {
+		ss.logger.Info("stopping subtitle sessions", "count", len(sessions))
+	}
+
+	for _, session := range sessions {
+		session.cancel()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ss.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		ss.logger.Warn("subtitle sessions did not stop in time")
+	}
+
+	if ss.translations != nil {
+		if err := ss.translations.Save(); err != nil {
+			ss.logger.Warn("failed to save translation cache", "error", err)
+		}
+	}
+
+	ss.flushStore(timeout)
 }
 
 // GetSession returns session information
@@ -668,21 +1072,168 @@ func (ss *SubtitleService) GetSession(sessionID string) (*SessionInfo, bool) {
 	session.mu.RLock()
 	defer session.mu.RUnlock()
 
-	return &SessionInfo{
+	info := session.infoForLocked(sessionID)
+	return &info, true
+}
+
+// info returns the public information of a session. The caller holds
+// session.mu.
+func (session *SubtitleSession) info() SessionInfo {
+	return SessionInfo{
 		ID:                session.ID,
 		ChannelID:         session.ChannelID,
+		UserID:            session.UserID,
 		Status:            session.Status,
 		Language:          session.Language,
+		LanguageDetected:  session.LanguageDetected,
 		TargetLang:        session.TargetLang,
+		TargetLangs:       session.TargetLangs,
 		SubCount:          len(session.Subtitles),
 		CreatedAt:         session.CreatedAt,
 		Error:             session.Error,
+		Partial:           session.Partial,
+		Source:            session.Source,
+		StopReason:        session.StopReason,
+		LastSeenAt:        session.lastSeenAt(),
+		DroppedChunks:     session.DroppedChunks,
+		DroppedEntries:    session.DroppedEntries,
+		Filters:           session.Filters,
+		Revision:          session.revision,
+		Offset:            session.offsetLocked(),
+		AutoOffset:        session.AutoOffset,
+		EstimatedDelay:    session.estimatedDelayLocked(),
 		AvgProcessingTime: session.AvgProcessingTime,
-	}, true
+		Viewers:           len(session.viewers),
+	}
+}
+
+// translate translates text into each target language of the session in
+// parallel. Languages whose translation failed are missing from the result.
+// showFirstSentence receives the first sentence of the primary language
+// translation early, see translateWithOllama.
+func (ss *SubtitleService) translate(session *SubtitleSession, text string, showFirstSentence func(string)) map[string]string {
+	// Nothing to translate from until the language of an auto session is known
+	from := session.language()
+
+	// Viewers joining or leaving a shared session change its languages
+	session.mu.RLock()
+	targetLangs := session.TargetLangs
+	session.mu.RUnlock()
+	if len(targetLangs) == 0 || from == "" {
+		return nil
+	}
+
+	logger := ss.sessionLogger(session)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	translations := make(map[string]string, len(targetLangs))
+	for _, lang := range targetLangs {
+		// A detected language may be one of the targets
+		if lang == from {
+			continue
+		}
+
+		wg.Add(1)
+		go func(lang string) {
+			defer wg.Done()
+
+			logger.Debug("translating", "from", from, "to", lang, "text", text)
+			// Only the language of entry texts is worth showing early
+			var onFirstSentence func(string)
+			if lang == session.TargetLang {
+				onFirstSentence = showFirstSentence
+			}
+
+			// Stopping the session aborts the translations in flight
+			translated, err := ss.translateWithOllama(session.ctx, text, from, lang, onFirstSentence)
+			if err != nil {
+				if session.ctx.Err() != nil {
+					return
+				}
+				logger.Warn("translation error", "to", lang, "error", err)
+				return
+			}
+			logger.Debug("translation result", "to", lang, "text", translated)
+
+			mu.Lock()
+			translations[lang] = translated
+			mu.Unlock()
+		}(lang)
+	}
+	wg.Wait()
+
+	return translations
+}
+
+// language returns the spoken language, empty while an auto session hasn't
+// detected it yet
+func (session *SubtitleSession) language() string {
+	session.mu.RLock()
+	defer session.mu.RUnlock()
+	return session.Language
+}
+
+// setDetectedLanguage adopts the language detected for an auto session
+func (ss *SubtitleService) setDetectedLanguage(session *SubtitleSession, language string) {
+	if language == "" {
+		return
+	}
+
+	session.mu.Lock()
+	if session.Language != "" {
+		session.mu.Unlock()
+		return
+	}
+	session.Language = language
+	session.LanguageDetected = true
+	session.notifyLocked()
+	session.mu.Unlock()
+
+	ss.sessionLogger(session).Info("detected session language", "language", language)
+}
+
+// normalizeTargetLangs lowercases and deduplicates target languages, and
+// drops the source language which needs no translation
+func normalizeTargetLangs(language string, langs []string) []string {
+	var result []string
+	for _, lang := range langs {
+		lang = strings.ToLower(strings.TrimSpace(lang))
+		if lang == "" || lang == language || slices.Contains(result, lang) {
+			continue
+		}
+		result = append(result, lang)
+	}
+	return result
+}
+
+// inLanguage returns entry with its text in lang: the translation for a
+// target language, the recognized text for the source language. An empty
+// lang keeps the primary text. Entries whose translation failed keep the
+// recognized text.
+func inLanguage(entry SubtitleEntry, session *SubtitleSession, lang string) SubtitleEntry {
+	if lang == "" || lang == entry.Language {
+		return entry
+	}
+
+	if translated, ok := entry.Translations[lang]; ok {
+		entry.Text = translated
+	} else if entry.Original != "" {
+		entry.Text = entry.Original
+		lang = session.Language
+	}
+	entry.Language = lang
+	return entry
+}
+
+// hasLanguage reports whether subtitles of a session are available in lang.
+// The caller holds session.mu.
+func (session *SubtitleSession) hasLanguage(lang string) bool {
+	return lang == "" || lang == session.Language || slices.Contains(session.TargetLangs, lang)
 }
 
 // GetSubtitles returns subtitles from a session
-func (ss *SubtitleService) GetSubtitles(sessionID string, since int) ([]SubtitleEntry, error) {
+func (ss *SubtitleService) GetSubtitles(sessionID string, since int, lang string) ([]SubtitleEntry, error) {
 	ss.mu.RLock()
 	defer ss.mu.RUnlock()
 
@@ -691,22 +1242,63 @@ func (ss *SubtitleService) GetSubtitles(sessionID string, since int) ([]Subtitle
 		return nil, fmt.Errorf("session %s not found", sessionID)
 	}
 
+	// Polling for subtitles keeps the session alive
+	session.touch()
+
 	session.mu.RLock()
 	defer session.mu.RUnlock()
 
+	lang = session.langForLocked(sessionID, lang)
+	if !session.hasLanguage(lang) {
+		return nil, fmt.Errorf("%w: %s", ErrLanguageNotAvailable, lang)
+	}
+
 	// Return subtitles after the given ID
 	result := make([]SubtitleEntry, 0)
 	for _, sub := range session.Subtitles {
 		if sub.ID > since {
-			result = append(result, sub)
+			result = append(result, session.emitLocked(sub, lang))
 		}
 	}
 
 	return result, nil
 }
 
+// GetSubtitleUpdates returns the entries added or completed after revision
+// sinceRevision, which includes pending entries whose translation finished,
+// and the current revision to poll from next
+func (ss *SubtitleService) GetSubtitleUpdates(sessionID string, sinceRevision int, lang string) ([]SubtitleEntry, int, error) {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+
+	session, exists := ss.sessions[sessionID]
+	if !exists {
+		return nil, 0, fmt.Errorf("session %s not found", sessionID)
+	}
+
+	// Polling for subtitles keeps the session alive
+	session.touch()
+
+	session.mu.RLock()
+	defer session.mu.RUnlock()
+
+	lang = session.langForLocked(sessionID, lang)
+	if !session.hasLanguage(lang) {
+		return nil, 0, fmt.Errorf("%w: %s", ErrLanguageNotAvailable, lang)
+	}
+
+	result := make([]SubtitleEntry, 0)
+	for _, sub := range session.Subtitles {
+		if sub.Revision > sinceRevision {
+			result = append(result, session.emitLocked(sub, lang))
+		}
+	}
+
+	return result, session.revision, nil
+}
+
 // GetLatestSubtitle returns the most recent subtitle
-func (ss *SubtitleService) GetLatestSubtitle(sessionID string) (*SubtitleEntry, error) {
+func (ss *SubtitleService) GetLatestSubtitle(sessionID, lang string) (*SubtitleEntry, error) {
 	ss.mu.RLock()
 	defer ss.mu.RUnlock()
 
@@ -715,59 +1307,68 @@ func (ss *SubtitleService) GetLatestSubtitle(sessionID string) (*SubtitleEntry,
 		return nil, fmt.Errorf("session %s not found", sessionID)
 	}
 
+	// Polling for subtitles keeps the session alive
+	session.touch()
+
 	session.mu.RLock()
 	defer session.mu.RUnlock()
 
+	lang = session.langForLocked(sessionID, lang)
+	if !session.hasLanguage(lang) {
+		return nil, fmt.Errorf("%w: %s", ErrLanguageNotAvailable, lang)
+	}
+
 	if len(session.Subtitles) == 0 {
 		return nil, nil
 	}
 
-	latest := session.Subtitles[len(session.Subtitles)-1]
+	latest := session.emitLocked(session.Subtitles[len(session.Subtitles)-1], lang)
 	return &latest, nil
 }
 
-// ExportSRT exports subtitles to SRT format
-func (ss *SubtitleService) ExportSRT(sessionID string) (string, error) {
+// ExportSubtitles writes the subtitles of a session to a file in format
+// (srt, vtt or ass), in the storage backend when one is set, and returns
+// its name, see OpenExport
+func (ss *SubtitleService) ExportSubtitles(sessionID, format string) (string, error) {
+	if format == "" {
+		format = FormatSRT
+	}
+
 	ss.mu.RLock()
 	session, exists := ss.sessions[sessionID]
 	ss.mu.RUnlock()
 
-	if !exists {
-		return "", fmt.Errorf("session %s not found", sessionID)
+	var subtitles []SubtitleEntry
+	if exists {
+		session.mu.RLock()
+		subtitles = make([]SubtitleEntry, len(session.Subtitles))
+		copy(subtitles, session.Subtitles)
+		session.mu.RUnlock()
+	} else {
+		// Ended sessions can still be exported from the store
+		if ss.store != nil {
+			stored, err := ss.store.LoadTranscript(sessionID)
+			if err != nil {
+				return "", fmt.Errorf("failed to load transcript: %w", err)
+			}
+			subtitles = stored
+		}
+		if subtitles == nil {
+			return "", fmt.Errorf("session %s not found", sessionID)
+		}
 	}
 
-	session.mu.RLock()
-	subtitles := make([]SubtitleEntry, len(session.Subtitles))
-	copy(subtitles, session.Subtitles)
-	session.mu.RUnlock()
-
-	var buf strings.Builder
-
-	for i, sub := range subtitles {
-		// SRT format:
-		// 1
-		// 00:00:01,000 --> 00:00:04,000
-		// Subtitle text
-		//
-		buf.WriteString(strconv.Itoa(i + 1))
-		buf.WriteString("\n")
-		buf.WriteString(formatSRTTime(sub.StartTime))
-		buf.WriteString(" --> ")
-		buf.WriteString(formatSRTTime(sub.EndTime))
-		buf.WriteString("\n")
-		buf.WriteString(sub.Text)
-		buf.WriteString("\n\n")
+	content, err := FormatSubtitles(subtitles, format)
+	if err != nil {
+		return "", err
 	}
 
-	// Save to file
-	filename := fmt.Sprintf("%s_%s.srt", sessionID, time.Now().Format("20060102_150405"))
-	filepath := filepath.Join(ss.config.CacheDir, filename)
-
-	if err := os.WriteFile(filepath, []byte(buf.String()), 0644); err != nil {
-		return "", fmt.Errorf("failed to save SRT: %w", err)
+	name := exportName(sessionID, format, time.Now())
+	if err := ss.saveExport(name, []byte(content)); err != nil {
+		return "", fmt.Errorf("failed to save subtitles: %w", err)
 	}
 
-	return filepath, nil
+	return name, nil
 }
 
 // DeleteSession removes a session
@@ -780,9 +1381,19 @@ func (ss *SubtitleService) DeleteSession(sessionID string) error {
 		return fmt.Errorf("session %s not found", sessionID)
 	}
 
+	if ss.leaveLocked(session, sessionID) {
+		return nil
+	}
+
 	session.cancel()
 	delete(ss.sessions, sessionID)
 
+	session.mu.Lock()
+	session.notifyLocked()
+	session.mu.Unlock()
+
+	go ss.finalizeSession(session)
+
 	return nil
 }
 
@@ -792,19 +1403,9 @@ func (ss *SubtitleService) GetAllSessions() []SessionInfo {
 	defer ss.mu.RUnlock()
 
 	sessions := make([]SessionInfo, 0, len(ss.sessions))
-	for _, session := range ss.sessions {
+	for id, session := range ss.sessions {
 		session.mu.RLock()
-		sessions = append(sessions, SessionInfo{
-			ID:                session.ID,
-			ChannelID:         session.ChannelID,
-			Status:            session.Status,
-			Language:          session.Language,
-			TargetLang:        session.TargetLang,
-			SubCount:          len(session.Subtitles),
-			CreatedAt:         session.CreatedAt,
-			Error:             session.Error,
-			AvgProcessingTime: session.AvgProcessingTime,
-		})
+		sessions = append(sessions, session.infoForLocked(id))
 		session.mu.RUnlock()
 	}
 
@@ -833,9 +1434,9 @@ func (ss *SubtitleService) GetAvailableLanguages() []map[string]string {
 	}
 }
 
-// CheckOllamaStatus checks if Ollama is available
-func (ss *SubtitleService) CheckOllamaStatus() (bool, string) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// CheckOllamaStatus checks if Ollama is available, giving up when ctx is done
+func (ss *SubtitleService) CheckOllamaStatus(ctx context.Context) (bool, string) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	req, err := http.NewRequestWithContext(ctx, "GET", ss.config.OllamaURL+"/api/tags", nil)
@@ -859,35 +1460,62 @@ func (ss *SubtitleService) CheckOllamaStatus() (bool, string) {
 
 // Helper functions
 
+// formatSRT renders subtitle entries in SRT format
+func formatSRT(subtitles []SubtitleEntry) string {
+	var buf strings.Builder
+
+	for i, sub := range subtitles {
+		// SRT format:
+		// 1
+		// 00:00:01,000 --> 00:00:04,000
+		// Subtitle text
+		//
+		buf.WriteString(strconv.Itoa(i + 1))
+		buf.WriteString("\n")
+		buf.WriteString(formatSRTTime(sub.StartTime))
+		buf.WriteString(" --> ")
+		buf.WriteString(formatSRTTime(sub.EndTime))
+		buf.WriteString("\n")
+		buf.WriteString(entryText(sub))
+		buf.WriteString("\n\n")
+	}
+
+	return buf.String()
+}
+
 func formatSRTTime(seconds float64) string {
-	hours := int(seconds) / 3600
-	minutes := (int(seconds) % 3600) / 60
-	secs := int(seconds) % 60
-	millis := int((seconds - float64(int(seconds))) * 1000)
+	return formatTimestamp(seconds, ',')
+}
 
-	return fmt.Sprintf("%02d:%02d:%02d,%03d", hours, minutes, secs, millis)
+// languageNames maps ISO 639-1 codes to English names, as used in prompts
+// and reported by Whisper
+var languageNames = map[string]string{
+	"en": "English",
+	"fr": "French",
+	"de": "German",
+	"es": "Spanish",
+	"it": "Italian",
+	"pt": "Portuguese",
+	"ru": "Russian",
+	"zh": "Chinese",
+	"ja": "Japanese",
+	"ko": "Korean",
+	"ar": "Arabic",
+	"hi": "Hindi",
+	"nl": "Dutch",
+	"pl": "Polish",
+	"tr": "Turkish",
+	"sv": "Swedish",
+	"da": "Danish",
+	"no": "Norwegian",
+	"fi": "Finnish",
+	"cs": "Czech",
+	"el": "Greek",
+	"uk": "Ukrainian",
 }
 
 func getLanguageName(code string) string {
-	names := map[string]string{
-		"en": "English",
-		"fr": "French",
-		"de": "German",
-		"es": "Spanish",
-		"it": "Italian",
-		"pt": "Portuguese",
-		"ru": "Russian",
-		"zh": "Chinese",
-		"ja": "Japanese",
-		"ko": "Korean",
-		"ar": "Arabic",
-		"hi": "Hindi",
-		"nl": "Dutch",
-		"pl": "Polish",
-		"tr": "Turkish",
-	}
-
-	if name, ok := names[code]; ok {
+	if name, ok := languageNames[code]; ok {
 		return name
 	}
 	return code
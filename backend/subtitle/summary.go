@@ -0,0 +1,213 @@
+package subtitle
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxSummaryTranscript caps the transcript sent for a summary, in bytes,
+// keeping the most recent lines so small models' context isn't exceeded
+const maxSummaryTranscript = 16000
+
+// ErrEmptyTranscript is returned when there is nothing to summarize yet
+var ErrEmptyTranscript = errors.New("transcript is empty")
+
+// Summary is what the model made of a transcript
+type Summary struct {
+	SessionID string    `json:"session_id"`
+	Language  string    `json:"language"` // Language the summary is written in
+	Summary   string    `json:"summary"`
+	KeyPoints []string  `json:"key_points"`
+	Entries   int       `json:"entries"` // Transcript lines summarized
+	From      float64   `json:"from"`    // Stream time of the first line, in seconds
+	To        float64   `json:"to"`      // Stream time of the last line, in seconds
+	Model     string    `json:"model"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Summarize asks Ollama for a summary and key points of a session's
+// transcript. minutes limits it to the last minutes of the stream, 0 for
+// all of it. lang is the language of the summary, the session's primary
+// language when empty.
+func (ss *SubtitleService) Summarize(ctx context.Context, sessionID, lang string, minutes int) (*Summary, error) {
+	entries, language, err := ss.transcript(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if lang == "" {
+		lang = language
+	}
+
+	if minutes > 0 && len(entries) > 0 {
+		from := entries[len(entries)-1].EndTime - float64(minutes*60)
+		for len(entries) > 0 && entries[0].EndTime < from {
+			entries = entries[1:]
+		}
+	}
+
+	// Lines in the spoken language, translations lose detail
+	lines := make([]string, 0, len(entries))
+	size := 0
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if entry.Original != "" {
+			entry.Text = entry.Original
+		}
+		line := strings.ReplaceAll(entryText(entry), "\n", " ")
+		if size+len(line) > maxSummaryTranscript {
+			entries = entries[i+1:]
+			break
+		}
+		size += len(line) + 1
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return nil, ErrEmptyTranscript
+	}
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+
+	prompt := fmt.Sprintf(
+		`You summarize live TV transcripts for viewers who tuned in late. The transcript below was produced by speech recognition and may contain errors.
+
+Reply in %s with a JSON object:
+{"summary": "a short paragraph of what was said", "key_points": ["one point per topic or news item"]}
+
+RULES:
+- Only use information from the transcript
+- At most 8 key points, one sentence each
+- No commentary about the transcript itself
+
+Transcript:
+%s`,
+		getLanguageName(lang),
+		strings.Join(lines, "\n"),
+	)
+
+	ss.mu.RLock()
+	model := ss.config.OllamaModel
+	ss.mu.RUnlock()
+
+	response, err := ss.generate(ctx, prompt, "json")
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Summary   string   `json:"summary"`
+		KeyPoints []string `json:"key_points"`
+	}
+	if err := json.Unmarshal([]byte(response), &result); err != nil {
+		return nil, fmt.Errorf("failed to parse summary: %w", err)
+	}
+	if result.KeyPoints == nil {
+		result.KeyPoints = []string{}
+	}
+
+	return &Summary{
+		SessionID: sessionID,
+		Language:  lang,
+		Summary:   strings.TrimSpace(result.Summary),
+		KeyPoints: result.KeyPoints,
+		Entries:   len(lines),
+		From:      entries[0].StartTime,
+		To:        entries[len(entries)-1].EndTime,
+		Model:     model,
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+// transcript returns the entries of a session, from the store once it has
+// ended, and its primary language
+func (ss *SubtitleService) transcript(sessionID string) ([]SubtitleEntry, string, error) {
+	ss.mu.RLock()
+	session, exists := ss.sessions[sessionID]
+	ss.mu.RUnlock()
+
+	if exists {
+		session.mu.RLock()
+		defer session.mu.RUnlock()
+
+		entries := make([]SubtitleEntry, len(session.Subtitles))
+		copy(entries, session.Subtitles)
+		language := session.TargetLang
+		if language == "" {
+			language = session.Language
+		}
+		return entries, language, nil
+	}
+
+	if ss.store != nil {
+		entries, err := ss.store.LoadTranscript(sessionID)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to load transcript: %w", err)
+		}
+		if entries != nil {
+			language := ""
+			if len(entries) > 0 {
+				language = entries[0].Language
+			}
+			return entries, language, nil
+		}
+	}
+
+	return nil, "", fmt.Errorf("%w: %s", ErrSessionNotFound, sessionID)
+}
+
+// Generate sends a prompt to the configured Ollama model, for features
+// outside subtitles using the same model. format is "json" or empty.
+func (ss *SubtitleService) Generate(ctx context.Context, prompt, format string) (string, error) {
+	return ss.generate(ctx, prompt, format)
+}
+
+// generate sends a prompt to the configured Ollama model and returns the
+// whole response. format is "json" to constrain the output, or empty.
+func (ss *SubtitleService) generate(ctx context.Context, prompt, format string) (string, error) {
+	ss.mu.RLock()
+	url, model := ss.config.OllamaURL, ss.config.OllamaModel
+	ss.mu.RUnlock()
+
+	jsonBody, err := json.Marshal(OllamaRequest{
+		Model:  model,
+		Prompt: prompt,
+		Stream: false,
+		Format: format,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url+"/api/generate", bytes.NewReader(jsonBody))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("ollama request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("ollama returned %d: %s", resp.StatusCode, string(body))
+	}
+
+	var result OllamaResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(result.Response), nil
+}
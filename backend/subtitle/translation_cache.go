@@ -0,0 +1,192 @@
+package subtitle
+
+import (
+	"container/list"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// translationCacheFile holds the cache between restarts, in CacheDir
+const translationCacheFile = "translations.json"
+
+// translationCacheSaveInterval is how often a changed cache is written out
+const translationCacheSaveInterval = time.Minute
+
+// cachedTranslation is one persisted cache entry
+type cachedTranslation struct {
+	Key         string `json:"key"`
+	Translation string `json:"translation"`
+}
+
+// translationCache is an LRU of Ollama translations. Live TV repeats the
+// same lines (tickers, ads, jingles) so many segments never reach Ollama.
+type translationCache struct {
+	mu       sync.Mutex
+	path     string
+	capacity int
+	entries  map[string]*list.Element // Values are *cachedTranslation
+	order    *list.List               // Most recently used first
+	hits     int64
+	misses   int64
+	dirty    bool
+}
+
+// TranslationCacheStats reports the effectiveness of the translation cache
+type TranslationCacheStats struct {
+	Entries  int     `json:"entries"`
+	Capacity int     `json:"capacity"`
+	Hits     int64   `json:"hits"`
+	Misses   int64   `json:"misses"`
+	HitRate  float64 `json:"hit_rate"`
+}
+
+func newTranslationCache(dir string, capacity int) *translationCache {
+	c := &translationCache{
+		path:     filepath.Join(dir, translationCacheFile),
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+	c.load()
+	return c
+}
+
+// translationKey identifies a translation. The model and prompt are part of
+// it since switching either changes the output.
+func translationKey(model, prompt, fromLang, toLang, text string) string {
+	return model + "|" + prompt + "|" + fromLang + "|" + toLang + "|" + strings.Join(strings.Fields(text), " ")
+}
+
+// Get returns a cached translation and marks it recently used
+func (c *translationCache) Get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return "", false
+	}
+
+	c.hits++
+	c.order.MoveToFront(element)
+	return element.Value.(*cachedTranslation).Translation, true
+}
+
+// Put stores a translation, evicting the least recently used beyond capacity
+func (c *translationCache) Put(key, translation string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.dirty = true
+
+	if element, ok := c.entries[key]; ok {
+		element.Value.(*cachedTranslation).Translation = translation
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&cachedTranslation{Key: key, Translation: translation})
+
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedTranslation).Key)
+	}
+}
+
+// Stats returns the entry count and hit rate
+func (c *translationCache) Stats() TranslationCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := TranslationCacheStats{
+		Entries:  c.order.Len(),
+		Capacity: c.capacity,
+		Hits:     c.hits,
+		Misses:   c.misses,
+	}
+	if total := c.hits + c.misses; total > 0 {
+		stats.HitRate = float64(c.hits) / float64(total)
+	}
+	return stats
+}
+
+// load restores the entries saved by Save
+func (c *translationCache) load() {
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return
+	}
+
+	var entries []cachedTranslation
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return
+	}
+
+	// Saved least recently used first, so pushing to the front restores the order
+	for i := range entries {
+		entry := entries[i]
+		if _, ok := c.entries[entry.Key]; ok {
+			continue
+		}
+		c.entries[entry.Key] = c.order.PushFront(&entry)
+	}
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedTranslation).Key)
+	}
+}
+
+// Save writes the cache to disk if it changed since the last save
+func (c *translationCache) Save() error {
+	c.mu.Lock()
+	if !c.dirty {
+		c.mu.Unlock()
+		return nil
+	}
+	entries := make([]cachedTranslation, 0, c.order.Len())
+	for element := c.order.Back(); element != nil; element = element.Prev() {
+		entries = append(entries, *element.Value.(*cachedTranslation))
+	}
+	c.dirty = false
+	c.mu.Unlock()
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	// Write then rename so a crash never leaves a truncated cache
+	if err := os.WriteFile(c.path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(c.path+".tmp", c.path)
+}
+
+// translationCacheLoop periodically persists the translation cache
+func (ss *SubtitleService) translationCacheLoop() {
+	ticker := time.NewTicker(translationCacheSaveInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := ss.translations.Save(); err != nil {
+			ss.logger.Warn("failed to save translation cache", "error", err)
+		}
+	}
+}
+
+// TranslationCacheStats reports the translation cache hit rate, nil when
+// the cache is disabled
+func (ss *SubtitleService) TranslationCacheStats() *TranslationCacheStats {
+	if ss.translations == nil {
+		return nil
+	}
+	stats := ss.translations.Stats()
+	return &stats
+}
@@ -0,0 +1,229 @@
+package subtitle
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// Voice activity detection splits the audio into utterances at pauses, so
+// chunks sent to Whisper hold whole sentences instead of cutting words.
+// Each 20 ms frame is classified by its energy relative to a running
+// estimate of the background noise, as webrtcvad does in its simplest mode.
+const (
+	vadFrameDuration = 20 * time.Millisecond
+	vadPreRoll       = 200 * time.Millisecond // Audio kept before speech onset, so first syllables aren't lost
+	vadTrailing      = 100 * time.Millisecond // Silence kept after the last speech frame
+	vadMinSpeech     = 300 * time.Millisecond // Shorter bursts are treated as noise
+	vadSpeechMargin  = 10.0                   // dB above the noise floor that counts as speech
+	vadMinSpeechDB   = -50.0                  // dBFS below which a frame is never speech
+)
+
+// speechSegment is an utterance found by the segmenter. Times are seconds
+// of audio since the start of the stream.
+type speechSegment struct {
+	PCM   []byte
+	Start float64
+	End   float64
+}
+
+// segmenter groups 16-bit mono PCM frames into speech segments
+type segmenter struct {
+	frameSize      int
+	silenceFrames  int // Pause ending a segment
+	maxFrames      int // Longest segment before a forced cut
+	preRollFrames  int
+	trailingFrames int
+	minSpeech      int
+
+	noiseFloor float64 // dBFS
+
+	inSpeech bool
+	buf      []byte    // Audio of the current segment, or pre-roll between segments
+	energies []float64 // Energy of each frame in buf
+	bufStart int64     // Frame index of buf[0]
+	speech   int       // Speech frames in the current segment
+	silent   int       // Consecutive silent frames at the end of buf
+}
+
+func newSegmenter(sampleRate int, silence, maxSegment time.Duration) *segmenter {
+	frames := func(d time.Duration) int {
+		n := int(d / vadFrameDuration)
+		if n < 1 {
+			n = 1
+		}
+		return n
+	}
+
+	return &segmenter{
+		frameSize:      sampleRate * 2 * int(vadFrameDuration/time.Millisecond) / 1000,
+		silenceFrames:  frames(silence),
+		maxFrames:      frames(maxSegment),
+		preRollFrames:  frames(vadPreRoll),
+		trailingFrames: frames(vadTrailing),
+		minSpeech:      frames(vadMinSpeech),
+		noiseFloor:     vadMinSpeechDB,
+	}
+}
+
+// Push adds one frame of frameSize bytes and returns a segment when one ends
+func (s *segmenter) Push(frame []byte) *speechSegment {
+	energy := frameEnergy(frame)
+	isSpeech := s.classify(energy)
+
+	s.buf = append(s.buf, frame...)
+	s.energies = append(s.energies, energy)
+
+	if !s.inSpeech {
+		if !isSpeech {
+			// Keep only the pre-roll while waiting for speech
+			if len(s.energies) > s.preRollFrames {
+				s.drop(len(s.energies) - s.preRollFrames)
+			}
+			return nil
+		}
+		s.inSpeech = true
+		s.speech = 0
+		s.silent = 0
+	}
+
+	if isSpeech {
+		s.speech++
+		s.silent = 0
+	} else {
+		s.silent++
+	}
+
+	if s.silent >= s.silenceFrames {
+		return s.endSegment()
+	}
+
+	if len(s.energies) >= s.maxFrames {
+		return s.forceCut()
+	}
+
+	return nil
+}
+
+// Flush returns the segment in progress at the end of the stream
+func (s *segmenter) Flush() *speechSegment {
+	if !s.inSpeech {
+		return nil
+	}
+	return s.endSegment()
+}
+
+// classify reports whether a frame is speech and adapts the noise floor
+// to frames that aren't
+func (s *segmenter) classify(energy float64) bool {
+	isSpeech := energy > vadMinSpeechDB && energy > s.noiseFloor+vadSpeechMargin
+
+	switch {
+	case energy < s.noiseFloor:
+		// Quieter than the floor: follow it down quickly
+		s.noiseFloor = energy
+	case !isSpeech:
+		// Background noise rising: follow it up slowly
+		s.noiseFloor += (energy - s.noiseFloor) * 0.05
+	default:
+		// Creep up during speech too (1 dB/s), so loud constant noise
+		// mistaken for speech is eventually learned as the floor
+		s.noiseFloor += 0.02
+	}
+
+	if s.noiseFloor < -90 {
+		s.noiseFloor = -90
+	}
+
+	return isSpeech
+}
+
+// endSegment emits the current segment up to shortly after its last speech
+// frame, or drops it when it was too short to be speech
+func (s *segmenter) endSegment() *speechSegment {
+	keep := len(s.energies) - s.silent + s.trailingFrames
+	if keep > len(s.energies) {
+		keep = len(s.energies)
+	}
+
+	var segment *speechSegment
+	if s.speech >= s.minSpeech {
+		segment = s.emit(keep)
+	} else {
+		s.drop(keep)
+	}
+
+	s.inSpeech = false
+	if len(s.energies) > s.preRollFrames {
+		s.drop(len(s.energies) - s.preRollFrames)
+	}
+
+	return segment
+}
+
+// forceCut splits a segment that reached the maximum length at its quietest
+// frame in the last quarter, so the cut most likely falls between words.
+// The rest stays as the start of the next segment.
+func (s *segmenter) forceCut() *speechSegment {
+	cut := len(s.energies)
+	quietest := math.Inf(1)
+	for i := len(s.energies) * 3 / 4; i < len(s.energies); i++ {
+		if s.energies[i] < quietest {
+			quietest = s.energies[i]
+			cut = i + 1
+		}
+	}
+
+	segment := s.emit(cut)
+
+	// Frames carried over count as speech for the next segment
+	s.speech = len(s.energies)
+	if s.silent > len(s.energies) {
+		s.silent = len(s.energies)
+	}
+
+	return segment
+}
+
+// emit removes the first n frames from buf and returns them as a segment
+func (s *segmenter) emit(n int) *speechSegment {
+	pcm := make([]byte, n*s.frameSize)
+	copy(pcm, s.buf)
+
+	frameSeconds := vadFrameDuration.Seconds()
+	segment := &speechSegment{
+		PCM:   pcm,
+		Start: float64(s.bufStart) * frameSeconds,
+		End:   float64(s.bufStart+int64(n)) * frameSeconds,
+	}
+
+	s.drop(n)
+	return segment
+}
+
+// drop discards the first n frames of buf
+func (s *segmenter) drop(n int) {
+	s.buf = append(s.buf[:0], s.buf[n*s.frameSize:]...)
+	s.energies = append(s.energies[:0], s.energies[n:]...)
+	s.bufStart += int64(n)
+}
+
+// frameEnergy returns the RMS level of 16-bit little-endian PCM in dBFS
+func frameEnergy(frame []byte) float64 {
+	samples := len(frame) / 2
+	if samples == 0 {
+		return -100
+	}
+
+	var sum float64
+	for i := 0; i < samples; i++ {
+		sample := float64(int16(binary.LittleEndian.Uint16(frame[i*2:]))) / 32768
+		sum += sample * sample
+	}
+
+	rms := math.Sqrt(sum / float64(samples))
+	if rms == 0 {
+		return -100
+	}
+	return 20 * math.Log10(rms)
+}
@@ -0,0 +1,175 @@
+package subtitle
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// Recognition engines
+const (
+	EngineWhisper = "whisper" // Fixed-length batches through Whisper
+	EngineVosk    = "vosk"    // Continuous streaming to a Vosk server
+)
+
+// voskChunkDuration is how much audio is sent per WebSocket frame. Vosk
+// answers every frame, so this bounds how often partial results update.
+const voskChunkDuration = 200 * time.Millisecond
+
+// voskDialTimeout bounds the WebSocket handshake with the Vosk server
+const voskDialTimeout = 10 * time.Second
+
+// processWithVosk streams audio to the Vosk server over its WebSocket
+// protocol. Partial results are exposed on the session as they arrive and
+// every final result becomes a subtitle entry. An error is returned when the
+// server is unreachable or the connection breaks, so the caller can fall
+// back to Whisper for the remaining audio.
+func (ss *SubtitleService) processWithVosk(session *SubtitleSession, audioReader io.Reader) error {
+	logger := ss.sessionLogger(session)
+
+	config, err := websocket.NewConfig(ss.config.VoskServerURL, "http://localhost/")
+	if err != nil {
+		return fmt.Errorf("invalid vosk server URL: %w", err)
+	}
+	config.Dialer.Timeout = voskDialTimeout
+
+	conn, err := config.DialContext(session.ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to vosk server: %w", err)
+	}
+	defer conn.Close()
+
+	// Unblock Receive when the session is stopped
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-session.ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	defer ss.setPartial(session, "")
+
+	configMsg := fmt.Sprintf(`{"config":{"sample_rate":%d}}`, ss.config.AudioSampleRate)
+	if err := websocket.Message.Send(conn, configMsg); err != nil {
+		return fmt.Errorf("failed to configure vosk: %w", err)
+	}
+
+	logger.Info("streaming audio to vosk", "url", ss.config.VoskServerURL)
+
+	startTime := time.Now()
+	gate := newSpeechGate(ss.config.AudioSampleRate)
+	chunkSize := int(float64(ss.config.AudioSampleRate*2) * voskChunkDuration.Seconds()) // 16-bit samples
+	buffer := make([]byte, chunkSize)
+
+	for {
+		n, readErr := io.ReadFull(audioReader, buffer)
+		if n == 0 && readErr != nil {
+			if readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+				logger.Error("audio read error", "error", readErr)
+			}
+			break
+		}
+
+		gate.Push(buffer[:n])
+		sentAt := time.Now()
+		if err := websocket.Message.Send(conn, buffer[:n]); err != nil {
+			return fmt.Errorf("failed to send audio to vosk: %w", err)
+		}
+
+		result, err := receiveVoskResult(conn)
+		if err != nil {
+			return fmt.Errorf("failed to read vosk result: %w", err)
+		}
+		ss.handleVoskResult(session, result, gate, startTime, sentAt)
+
+		if readErr != nil {
+			break
+		}
+	}
+
+	if session.ctx.Err() != nil {
+		return nil
+	}
+
+	// Flush the last utterance
+	if err := websocket.Message.Send(conn, `{"eof":1}`); err != nil {
+		return nil
+	}
+	sentAt := time.Now()
+	if result, err := receiveVoskResult(conn); err == nil {
+		ss.handleVoskResult(session, result, gate, startTime, sentAt)
+	}
+
+	return nil
+}
+
+// receiveVoskResult reads one JSON result frame
+func receiveVoskResult(conn *websocket.Conn) (*VoskResult, error) {
+	var message string
+	if err := websocket.Message.Receive(conn, &message); err != nil {
+		return nil, err
+	}
+
+	var result VoskResult
+	if err := json.Unmarshal([]byte(message), &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// handleVoskResult publishes a partial result or turns a final one into a
+// subtitle entry timed by its words, unless the audio it was recognized
+// from held no speech or its text is made up. Word times count from
+// startTime, when the first audio was sent.
+func (ss *SubtitleService) handleVoskResult(session *SubtitleSession, result *VoskResult, gate *speechGate, startTime, sentAt time.Time) {
+	if result.Partial != "" {
+		ss.setPartial(session, result.Partial)
+		return
+	}
+
+	text := strings.TrimSpace(result.Text)
+	if text == "" {
+		return
+	}
+	ss.setPartial(session, "")
+
+	if !gate.Result() && ss.config.HallucinationFilter {
+		ss.dropHallucination(session, "no_speech", text)
+		return
+	}
+	if ss.suppressHallucination(session, text) {
+		return
+	}
+
+	var start, end float64
+	var words []Word
+	if len(result.Result) > 0 {
+		start = result.Result[0].Start
+		end = result.Result[len(result.Result)-1].End
+		for _, w := range result.Result {
+			words = append(words, Word{Word: w.Word, Start: w.Start, End: w.End, Probability: w.Conf})
+		}
+	} else {
+		end = time.Since(startTime).Seconds()
+		start = end - voskChunkDuration.Seconds()
+	}
+
+	ss.addEntry(session, text, start, end, 0, words, meanWordProbability(words), sentAt)
+}
+
+// setPartial stores the words recognized so far in the current utterance
+func (ss *SubtitleService) setPartial(session *SubtitleSession, partial string) {
+	session.mu.Lock()
+	if session.Partial != partial {
+		session.Partial = partial
+		session.notifyLocked()
+	}
+	session.mu.Unlock()
+}
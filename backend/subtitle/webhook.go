@@ -0,0 +1,222 @@
+package subtitle
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"iptv-backend/webhooks"
+)
+
+// Transcript webhook modes
+const (
+	WebhookModeEntry      = "entry"      // POST every finalized subtitle entry
+	WebhookModeTranscript = "transcript" // POST the full transcript when a session ends
+	WebhookModeBoth       = "both"
+)
+
+// TranscriptWebhookConfig configures archiving of subtitles to an external endpoint
+type TranscriptWebhookConfig struct {
+	Enabled bool   `json:"enabled"`
+	URL     string `json:"url"`
+	Secret  string `json:"secret,omitempty"`
+	Mode    string `json:"mode"`
+}
+
+// EntryWebhookPayload is sent for each finalized subtitle entry
+type EntryWebhookPayload struct {
+	Event     string        `json:"event"`
+	SessionID string        `json:"session_id"`
+	ChannelID string        `json:"channel_id"`
+	Language  string        `json:"language"`
+	Entry     SubtitleEntry `json:"entry"`
+	Timestamp time.Time     `json:"timestamp"`
+}
+
+// TranscriptWebhookPayload is sent once when a session ends
+type TranscriptWebhookPayload struct {
+	Event       string          `json:"event"`
+	SessionID   string          `json:"session_id"`
+	ChannelID   string          `json:"channel_id"`
+	Language    string          `json:"language"`
+	TargetLang  string          `json:"target_lang,omitempty"`
+	TargetLangs []string        `json:"target_langs,omitempty"`
+	Status      string          `json:"status"`
+	CreatedAt   time.Time       `json:"created_at"`
+	EndedAt     time.Time       `json:"ended_at"`
+	Entries     []SubtitleEntry `json:"entries"`
+	SRT         string          `json:"srt"`
+}
+
+type webhookDelivery struct {
+	event   string
+	payload interface{}
+}
+
+// GetWebhookConfig returns the transcript webhook configuration
+func (ss *SubtitleService) GetWebhookConfig() TranscriptWebhookConfig {
+	ss.webhookMu.RLock()
+	defer ss.webhookMu.RUnlock()
+	return ss.webhook
+}
+
+// UpdateWebhookConfig replaces the transcript webhook configuration
+func (ss *SubtitleService) UpdateWebhookConfig(config TranscriptWebhookConfig) error {
+	switch config.Mode {
+	case "":
+		config.Mode = WebhookModeTranscript
+	case WebhookModeEntry, WebhookModeTranscript, WebhookModeBoth:
+	default:
+		return fmt.Errorf("invalid webhook mode %q", config.Mode)
+	}
+
+	if config.Enabled && config.URL == "" {
+		return fmt.Errorf("webhook URL is required")
+	}
+
+	ss.webhookMu.Lock()
+	ss.webhook = config
+	ss.webhookMu.Unlock()
+
+	return nil
+}
+
+// publishEntry queues a finalized subtitle entry for delivery
+func (ss *SubtitleService) publishEntry(session *SubtitleSession, entry SubtitleEntry) {
+	config := ss.GetWebhookConfig()
+	if !config.Enabled || (config.Mode != WebhookModeEntry && config.Mode != WebhookModeBoth) {
+		return
+	}
+
+	session.mu.RLock()
+	payload := EntryWebhookPayload{
+		Event:     "subtitle.entry",
+		SessionID: session.ID,
+		ChannelID: session.ChannelID,
+		Language:  session.Language,
+		Entry:     entry,
+		Timestamp: time.Now(),
+	}
+	session.mu.RUnlock()
+
+	ss.enqueueWebhook(payload.Event, payload)
+}
+
+// finalizeSession queues the full transcript of a session for delivery.
+// It only fires once per session, whichever way the session ends.
+func (ss *SubtitleService) finalizeSession(session *SubtitleSession) {
+	session.mu.Lock()
+	if session.finalized {
+		session.mu.Unlock()
+		return
+	}
+	session.finalized = true
+
+	entries := make([]SubtitleEntry, len(session.Subtitles))
+	copy(entries, session.Subtitles)
+
+	payload := TranscriptWebhookPayload{
+		Event:       "subtitle.transcript",
+		SessionID:   session.ID,
+		ChannelID:   session.ChannelID,
+		Language:    session.Language,
+		TargetLang:  session.TargetLang,
+		TargetLangs: session.TargetLangs,
+		Status:      session.Status,
+		CreatedAt:   session.CreatedAt,
+		EndedAt:     time.Now(),
+		Entries:     entries,
+	}
+	info := session.info()
+	info.Partial = ""
+	session.mu.Unlock()
+
+	ss.persistSessionEnd(session, info)
+
+	ss.mu.RLock()
+	for _, handler := range ss.endedHandlers {
+		go handler(info)
+	}
+	ss.mu.RUnlock()
+
+	config := ss.GetWebhookConfig()
+	if !config.Enabled || (config.Mode != WebhookModeTranscript && config.Mode != WebhookModeBoth) {
+		return
+	}
+
+	payload.SRT = formatSRT(entries)
+	ss.enqueueWebhook(payload.Event, payload)
+}
+
+// enqueueWebhook adds a delivery to the queue without blocking the caller
+func (ss *SubtitleService) enqueueWebhook(event string, payload interface{}) {
+	select {
+	case ss.webhookQueue <- webhookDelivery{event: event, payload: payload}:
+	default:
+		ss.logger.Warn("transcript webhook queue full, dropping delivery", "event", event)
+	}
+}
+
+// webhookLoop delivers queued payloads in order
+func (ss *SubtitleService) webhookLoop() {
+	for delivery := range ss.webhookQueue {
+		config := ss.GetWebhookConfig()
+		if !config.Enabled || config.URL == "" {
+			continue
+		}
+
+		body, err := json.Marshal(delivery.payload)
+		if err != nil {
+			ss.logger.Error("failed to encode webhook payload", "event", delivery.event, "error", err)
+			continue
+		}
+
+		// Retry with exponential backoff
+		backoff := time.Second
+		for attempt := 1; attempt <= 3; attempt++ {
+			err = postSignedWebhook(config.URL, config.Secret, delivery.event, body)
+			if err == nil {
+				break
+			}
+			ss.logger.Warn("transcript webhook delivery failed", "event", delivery.event, "attempt", attempt, "error", err)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+}
+
+// postSignedWebhook POSTs a JSON body with signature headers
+func postSignedWebhook(url, secret, event string, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhooks.EventHeader, event)
+	req.Header.Set(webhooks.TimestampHeader, timestamp)
+	if secret != "" {
+		req.Header.Set(webhooks.SignatureHeader, webhooks.Sign(secret, timestamp, body))
+	}
+
+	client := &http.Client{Timeout: 15 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("endpoint returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,279 @@
+package subtitle
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// WhisperModels are the model sizes that can be selected, fastest first
+var WhisperModels = []string{"tiny", "base", "small", "medium"}
+
+// DefaultWhisperModel is used when no model is configured
+const DefaultWhisperModel = "base"
+
+// ErrUnknownModel is returned for a model that is not in WhisperModels
+var ErrUnknownModel = errors.New("unknown whisper model")
+
+// whisperModelURL is where faster-whisper (CTranslate2) models are published
+const whisperModelURL = "https://huggingface.co/Systran/faster-whisper-%s/resolve/main/%s"
+
+// whisperModelFiles make up a model directory. model.bin comes last, so the
+// download size is known once the small files are done.
+var whisperModelFiles = []string{"config.json", "tokenizer.json", "vocabulary.txt", "model.bin"}
+
+// whisperModelSizes are approximate download sizes in bytes, reported until
+// the real size is known
+var whisperModelSizes = map[string]int64{
+	"tiny":   75 << 20,
+	"base":   145 << 20,
+	"small":  484 << 20,
+	"medium": 1530 << 20,
+}
+
+// ModelStatus describes a model and its download
+type ModelStatus struct {
+	Name        string  `json:"name"`
+	Installed   bool    `json:"installed"`
+	Downloading bool    `json:"downloading"`
+	Downloaded  int64   `json:"downloaded_bytes"`
+	Total       int64   `json:"total_bytes"`
+	Progress    float64 `json:"progress"` // Percent of the download
+	Error       string  `json:"error,omitempty"`
+}
+
+// ModelManager downloads Whisper models into a directory and reports their
+// state. Models that are not downloaded are fetched by faster-whisper on
+// first use, into its own cache.
+type ModelManager struct {
+	dir    string
+	client *http.Client
+	logger *slog.Logger
+
+	mu        sync.Mutex
+	downloads map[string]*modelDownload // Latest download of each model
+}
+
+type modelDownload struct {
+	downloaded atomic.Int64
+	total      atomic.Int64
+	done       bool
+	err        error
+}
+
+// NewModelManager creates a manager storing models in dir
+func NewModelManager(dir string, logger *slog.Logger) *ModelManager {
+	os.MkdirAll(dir, 0755)
+
+	return &ModelManager{
+		dir:       dir,
+		client:    &http.Client{}, // No timeout, medium is over a gigabyte
+		logger:    logger,
+		downloads: make(map[string]*modelDownload),
+	}
+}
+
+// IsWhisperModel reports whether name is a known model size
+func IsWhisperModel(name string) bool {
+	for _, model := range WhisperModels {
+		if model == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Path returns the directory holding model name
+func (mm *ModelManager) Path(name string) string {
+	return filepath.Join(mm.dir, "faster-whisper-"+name)
+}
+
+// Installed reports whether every file of model name is present
+func (mm *ModelManager) Installed(name string) bool {
+	for _, file := range whisperModelFiles {
+		info, err := os.Stat(filepath.Join(mm.Path(name), file))
+		if err != nil || info.Size() == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Status returns the state of model name
+func (mm *ModelManager) Status(name string) (ModelStatus, error) {
+	if !IsWhisperModel(name) {
+		return ModelStatus{}, ErrUnknownModel
+	}
+
+	status := ModelStatus{
+		Name:      name,
+		Installed: mm.Installed(name),
+		Total:     whisperModelSizes[name],
+	}
+	if status.Installed {
+		status.Progress = 100
+	}
+
+	mm.mu.Lock()
+	dl := mm.downloads[name]
+	if dl != nil {
+		status.Downloading = !dl.done
+		if dl.err != nil {
+			status.Error = dl.err.Error()
+		}
+	}
+	mm.mu.Unlock()
+
+	if dl != nil && status.Downloading {
+		status.Downloaded = dl.downloaded.Load()
+		if total := dl.total.Load(); total > 0 {
+			status.Total = total
+		}
+		status.Progress = min(float64(status.Downloaded)/float64(status.Total)*100, 99.9)
+	}
+	return status, nil
+}
+
+// List returns the state of every model
+func (mm *ModelManager) List() []ModelStatus {
+	models := make([]ModelStatus, 0, len(WhisperModels))
+	for _, name := range WhisperModels {
+		status, _ := mm.Status(name)
+		models = append(models, status)
+	}
+	return models
+}
+
+// Download starts downloading model name in the background, unless it is
+// installed or already downloading
+func (mm *ModelManager) Download(name string) error {
+	if !IsWhisperModel(name) {
+		return ErrUnknownModel
+	}
+	if mm.Installed(name) {
+		return nil
+	}
+
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	if dl := mm.downloads[name]; dl != nil && !dl.done {
+		return nil
+	}
+
+	dl := &modelDownload{}
+	dl.total.Store(whisperModelSizes[name])
+	mm.downloads[name] = dl
+
+	go func() {
+		mm.logger.Info("downloading whisper model", "model", name)
+		err := mm.download(name, dl)
+		if err != nil {
+			mm.logger.Error("whisper model download failed", "model", name, "error", err)
+		} else {
+			mm.logger.Info("whisper model downloaded", "model", name)
+		}
+
+		mm.mu.Lock()
+		dl.done = true
+		dl.err = err
+		mm.mu.Unlock()
+	}()
+	return nil
+}
+
+// download fetches the files of a model into a temporary directory, checks
+// them and moves the directory in place
+func (mm *ModelManager) download(name string, dl *modelDownload) error {
+	partial := mm.Path(name) + ".partial"
+	os.RemoveAll(partial)
+	if err := os.MkdirAll(partial, 0755); err != nil {
+		return err
+	}
+	defer os.RemoveAll(partial)
+
+	for i, file := range whisperModelFiles {
+		last := i == len(whisperModelFiles)-1
+		if err := mm.downloadFile(fmt.Sprintf(whisperModelURL, name, file), filepath.Join(partial, file), dl, last); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+
+	os.RemoveAll(mm.Path(name))
+	return os.Rename(partial, mm.Path(name))
+}
+
+// downloadFile saves url to path, checking its size and, for files stored
+// with Git LFS, its SHA-256. The size of the last file completes the total.
+func (mm *ModelManager) downloadFile(url, path string, dl *modelDownload, last bool) error {
+	resp, err := mm.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download returned status %d", resp.StatusCode)
+	}
+	if last && resp.ContentLength > 0 {
+		dl.total.Store(dl.downloaded.Load() + resp.ContentLength)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(f, hash, progressWriter{dl}), resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.ContentLength > 0 && written != resp.ContentLength {
+		return fmt.Errorf("incomplete download, got %d of %d bytes", written, resp.ContentLength)
+	}
+	if expected := lfsSHA256(resp); expected != "" && expected != hex.EncodeToString(hash.Sum(nil)) {
+		return errors.New("checksum mismatch")
+	}
+
+	return f.Close()
+}
+
+// lfsSHA256 returns the SHA-256 Hugging Face announces for a Git LFS file, in
+// the X-Linked-Etag header of the redirect to the file
+func lfsSHA256(resp *http.Response) string {
+	for r := resp; r != nil; {
+		if etag := r.Header.Get("X-Linked-Etag"); etag != "" {
+			etag = strings.Trim(strings.TrimPrefix(etag, "W/"), `"`)
+			if len(etag) == sha256.Size*2 {
+				return etag
+			}
+			return ""
+		}
+		if r.Request == nil {
+			break
+		}
+		r = r.Request.Response
+	}
+	return ""
+}
+
+// progressWriter counts downloaded bytes
+type progressWriter struct {
+	dl *modelDownload
+}
+
+func (w progressWriter) Write(p []byte) (int, error) {
+	w.dl.downloaded.Add(int64(len(p)))
+	return len(p), nil
+}
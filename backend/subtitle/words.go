@@ -0,0 +1,141 @@
+package subtitle
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Word is a recognized word and when it was spoken, in seconds on the same
+// clock as the entry holding it
+type Word struct {
+	Word        string  `json:"word"`
+	Start       float64 `json:"start"`
+	End         float64 `json:"end"`
+	Probability float64 `json:"probability,omitempty"` // From 0 to 1, when the engine reports it
+}
+
+// Exported cues longer than this are split at word boundaries, when the
+// entry has word timings. Two lines of 42 characters and 7 seconds are the
+// usual broadcast limits.
+const (
+	maxCueChars    = 84
+	maxCueDuration = 7.0
+	minCueChars    = 20 // A sentence end only splits a cue this long
+)
+
+// offsetWords moves words timed from the start of a chunk to the stream
+// clock, dropping empty ones
+func offsetWords(words []Word, offset float64) []Word {
+	if len(words) == 0 {
+		return nil
+	}
+
+	result := make([]Word, 0, len(words))
+	for _, w := range words {
+		w.Word = strings.TrimSpace(w.Word)
+		if w.Word == "" {
+			continue
+		}
+		w.Start += offset
+		w.End += offset
+		result = append(result, w)
+	}
+	return result
+}
+
+// cleanWords masks swear words like the text they belong to
+func (f TextFilters) cleanWords(words []Word) []Word {
+	if !f.MaskProfanity {
+		return words
+	}
+	for i := range words {
+		words[i].Word = maskProfanity(words[i].Word)
+	}
+	return words
+}
+
+// alignedWords returns the words of the entry text, with the timings of
+// entry.Words and the spelling of the text, which filters may have changed.
+// It returns nil when the text is a translation or its words don't match
+// the recognized ones one to one.
+func alignedWords(entry SubtitleEntry) []Word {
+	if len(entry.Words) == 0 || (entry.Original != "" && entry.Text != entry.Original) {
+		return nil
+	}
+
+	fields := strings.Fields(entry.Text)
+	if len(fields) != len(entry.Words) {
+		return nil
+	}
+
+	words := make([]Word, len(fields))
+	for i, field := range fields {
+		words[i] = Word{Word: field, Start: entry.Words[i].Start, End: entry.Words[i].End}
+	}
+	return words
+}
+
+// splitEntries splits entries too long or too long-lasting for one cue
+// into several, each timed by its words
+func splitEntries(entries []SubtitleEntry) []SubtitleEntry {
+	result := make([]SubtitleEntry, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, splitEntry(entry)...)
+	}
+	return result
+}
+
+func splitEntry(entry SubtitleEntry) []SubtitleEntry {
+	if utf8.RuneCountInString(entry.Text) <= maxCueChars && entry.EndTime-entry.StartTime <= maxCueDuration {
+		return []SubtitleEntry{entry}
+	}
+	words := alignedWords(entry)
+	if words == nil {
+		return []SubtitleEntry{entry}
+	}
+
+	var cues []SubtitleEntry
+	var cue []Word
+	length := 0
+
+	flush := func() {
+		if len(cue) == 0 {
+			return
+		}
+		text := make([]string, len(cue))
+		for i, w := range cue {
+			text[i] = w.Word
+		}
+		part := entry
+		part.Text = strings.Join(text, " ")
+		part.Original = ""
+		part.Translations = nil
+		part.StartTime = cue[0].Start
+		part.EndTime = cue[len(cue)-1].End
+		part.Words = cue
+		cues = append(cues, part)
+		cue, length = nil, 0
+	}
+
+	for _, w := range words {
+		size := utf8.RuneCountInString(w.Word)
+		if len(cue) > 0 && (length+1+size > maxCueChars || w.End-cue[0].Start > maxCueDuration) {
+			flush()
+		}
+		if len(cue) > 0 {
+			length++
+		}
+		cue = append(cue, w)
+		length += size
+
+		if last, _ := utf8.DecodeLastRuneInString(w.Word); length >= minCueChars && strings.ContainsRune(".!?…", last) {
+			flush()
+		}
+	}
+	flush()
+
+	// Keep the entry's own bounds, words rarely start right at its start
+	cues[0].StartTime = entry.StartTime
+	cues[len(cues)-1].EndTime = entry.EndTime
+	return cues
+}
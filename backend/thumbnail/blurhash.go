@@ -0,0 +1,105 @@
+package thumbnail
+
+import (
+	"math"
+	"strings"
+)
+
+// Components of the blurhash along each axis, 4x3 suits 16:9 thumbnails
+const (
+	blurhashX = 4
+	blurhashY = 3
+)
+
+const base83 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// Blurhash encodes rgb24 pixels of a width x height image as a blurhash
+// (https://blurha.sh), a short string clients decode into a blurred
+// placeholder while the image loads
+func Blurhash(pixels []byte, width, height int) string {
+	if width <= 0 || height <= 0 || len(pixels) < width*height*3 {
+		return ""
+	}
+
+	factors := make([][3]float64, 0, blurhashX*blurhashY)
+	for j := 0; j < blurhashY; j++ {
+		for i := 0; i < blurhashX; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+
+			var r, g, b float64
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					basis := math.Cos(math.Pi*float64(i)*float64(x)/float64(width)) *
+						math.Cos(math.Pi*float64(j)*float64(y)/float64(height))
+					p := pixels[(y*width+x)*3:]
+					r += basis * srgbToLinear(p[0])
+					g += basis * srgbToLinear(p[1])
+					b += basis * srgbToLinear(p[2])
+				}
+			}
+
+			scale := normalisation / float64(width*height)
+			factors = append(factors, [3]float64{r * scale, g * scale, b * scale})
+		}
+	}
+
+	var hash strings.Builder
+	hash.WriteString(encode83((blurhashX-1)+(blurhashY-1)*9, 1))
+
+	maximum := 1.0
+	if ac := factors[1:]; len(ac) > 0 {
+		var actualMax float64
+		for _, f := range ac {
+			actualMax = math.Max(actualMax, math.Max(math.Abs(f[0]), math.Max(math.Abs(f[1]), math.Abs(f[2]))))
+		}
+		quantisedMax := int(math.Max(0, math.Min(82, math.Floor(actualMax*166-0.5))))
+		maximum = float64(quantisedMax+1) / 166
+		hash.WriteString(encode83(quantisedMax, 1))
+	} else {
+		hash.WriteString(encode83(0, 1))
+	}
+
+	dc := factors[0]
+	hash.WriteString(encode83(linearToSRGB(dc[0])<<16+linearToSRGB(dc[1])<<8+linearToSRGB(dc[2]), 4))
+
+	for _, f := range factors[1:] {
+		quantise := func(v float64) int {
+			return int(math.Max(0, math.Min(18, math.Floor(signPow(v/maximum, 0.5)*9+9.5))))
+		}
+		hash.WriteString(encode83(quantise(f[0])*19*19+quantise(f[1])*19+quantise(f[2]), 2))
+	}
+
+	return hash.String()
+}
+
+func encode83(value, length int) string {
+	digits := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		digits[i] = base83[value%83]
+		value /= 83
+	}
+	return string(digits)
+}
+
+func srgbToLinear(c byte) float64 {
+	v := float64(c) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) int {
+	v = math.Max(0, math.Min(1, v))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
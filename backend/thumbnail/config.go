@@ -0,0 +1,84 @@
+package thumbnail
+
+import (
+	"fmt"
+	"time"
+)
+
+// RuntimeConfig holds the options administrators change while the server
+// runs. Thumbnails cached with previous options are served until they
+// expire.
+type RuntimeConfig struct {
+	CacheTTLSeconds int `json:"cache_ttl_seconds"` // Thumbnails older are regenerated
+	MaxWidth        int `json:"max_width"`         // Of the default variant
+	MaxHeight       int `json:"max_height"`
+	Quality         int `json:"quality"`           // 1 to 100
+	TimeoutSeconds  int `json:"timeout_seconds"`   // Of one capture
+	MaxCacheSizeMB  int `json:"max_cache_size_mb"` // 0 for no limit
+}
+
+// options are the settings of RuntimeConfig the service runs with
+type options struct {
+	cacheTTL     time.Duration
+	maxWidth     int
+	maxHeight    int
+	quality      int
+	timeout      time.Duration
+	maxCacheSize int64
+}
+
+// current returns the options in effect
+func (ts *ThumbnailService) current() options {
+	ts.optionsMu.RLock()
+	defer ts.optionsMu.RUnlock()
+	return ts.opts
+}
+
+// Config returns the runtime configuration in effect
+func (ts *ThumbnailService) Config() RuntimeConfig {
+	opts := ts.current()
+	return RuntimeConfig{
+		CacheTTLSeconds: int(opts.cacheTTL / time.Second),
+		MaxWidth:        opts.maxWidth,
+		MaxHeight:       opts.maxHeight,
+		Quality:         opts.quality,
+		TimeoutSeconds:  int(opts.timeout / time.Second),
+		MaxCacheSizeMB:  int(opts.maxCacheSize >> 20),
+	}
+}
+
+// SetConfig validates and applies a runtime configuration. A smaller cache
+// size evicts the least recently used thumbnails right away.
+func (ts *ThumbnailService) SetConfig(config RuntimeConfig) error {
+	switch {
+	case config.CacheTTLSeconds < 10 || config.CacheTTLSeconds > 7*24*3600:
+		return fmt.Errorf("cache_ttl_seconds must be between 10 and %d", 7*24*3600)
+	case config.MaxWidth < 16 || config.MaxWidth > 3840 || config.MaxHeight < 16 || config.MaxHeight > 2160:
+		return fmt.Errorf("max_width must be between 16 and 3840, max_height between 16 and 2160")
+	case config.Quality < 1 || config.Quality > 100:
+		return fmt.Errorf("quality must be between 1 and 100")
+	case config.TimeoutSeconds < 1 || config.TimeoutSeconds > 300:
+		return fmt.Errorf("timeout_seconds must be between 1 and 300")
+	case config.MaxCacheSizeMB < 0:
+		return fmt.Errorf("max_cache_size_mb can't be negative")
+	}
+
+	ts.optionsMu.Lock()
+	shrunk := config.MaxCacheSizeMB > 0 && (ts.opts.maxCacheSize <= 0 || int64(config.MaxCacheSizeMB)<<20 < ts.opts.maxCacheSize)
+	ts.opts = options{
+		cacheTTL:     time.Duration(config.CacheTTLSeconds) * time.Second,
+		maxWidth:     config.MaxWidth,
+		maxHeight:    config.MaxHeight,
+		quality:      config.Quality,
+		timeout:      time.Duration(config.TimeoutSeconds) * time.Second,
+		maxCacheSize: int64(config.MaxCacheSizeMB) << 20,
+	}
+	ts.optionsMu.Unlock()
+
+	if shrunk {
+		ts.mu.Lock()
+		ts.evict("")
+		ts.mu.Unlock()
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package thumbnail
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"strings"
+)
+
+// ContentETag returns a strong HTTP entity tag for an image's content
+func ContentETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// fileETag hashes the file at path, "" if it can't be read
+func fileETag(path string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return ""
+	}
+	return `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+}
+
+// ETag returns the entity tag of a cached image, computed when it was
+// generated, or hashed now for files the cache doesn't know about
+func (ts *ThumbnailService) ETag(path string) string {
+	ts.mu.RLock()
+	for _, info := range ts.cache {
+		if info.FilePath == path && info.ETag != "" {
+			ts.mu.RUnlock()
+			return info.ETag
+		}
+	}
+	ts.mu.RUnlock()
+
+	return fileETag(path)
+}
+
+// ETagMatches reports whether an If-None-Match header lists etag, compared
+// weakly as RFC 9110 requires for it
+func ETagMatches(ifNoneMatch, etag string) bool {
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return etag != ""
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate != "" && candidate == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
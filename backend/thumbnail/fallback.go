@@ -0,0 +1,187 @@
+package thumbnail
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"html"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Fallbacks served when a frame can't be captured, in the order they are
+// tried
+const (
+	FallbackStale       = "stale"       // An expired capture of the channel
+	FallbackLogo        = "logo"        // The channel's tvg-logo
+	FallbackPlaceholder = "placeholder" // A card with the channel's name
+)
+
+// logoBackground pads logos to the thumbnail's aspect ratio
+const logoBackground = "0x1f2937"
+
+// GetStalePath returns the capture of a variant still on disk whatever its
+// age, or of the default variant when the variant was never captured
+func (ts *ThumbnailService) GetStalePath(channelID string, variant Variant) (string, bool) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	for _, v := range []Variant{variant, ts.DefaultVariant()} {
+		info, exists := ts.cache[ts.variantCacheKey(channelID, v)]
+		if !exists {
+			continue
+		}
+		if _, err := os.Stat(info.FilePath); err == nil {
+			return info.FilePath, true
+		}
+	}
+
+	return "", false
+}
+
+// GetBlurhash returns the blurhash of a variant's last capture, or of the
+// default variant's, whatever their age: an old placeholder still looks
+// like the channel
+func (ts *ThumbnailService) GetBlurhash(channelID string, variant Variant) string {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	for _, v := range []Variant{variant, ts.DefaultVariant()} {
+		if info, exists := ts.cache[ts.variantCacheKey(channelID, v)]; exists && info.Blurhash != "" {
+			return info.Blurhash
+		}
+	}
+
+	return ""
+}
+
+// GetLogo retrieves a channel's logo fitted to a variant, fetching it if
+// necessary until ctx is done. Only http and https logos are fetched.
+func (ts *ThumbnailService) GetLogo(ctx context.Context, channelID, logoURL string, variant Variant) (*ThumbnailInfo, error) {
+	parsed, err := url.Parse(logoURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return nil, fmt.Errorf("invalid logo URL")
+	}
+
+	cacheKey := ts.variantCacheKey(channelID, variant) + "-logo"
+
+	return ts.getOrGenerate(ctx, cacheKey, func(ctx context.Context) (*ThumbnailInfo, error) {
+		return ts.generateLogo(ctx, channelID, logoURL, cacheKey, variant)
+	})
+}
+
+// generateLogo downloads a logo and scales it into the variant's frame,
+// centered on a dark background
+func (ts *ThumbnailService) generateLogo(ctx context.Context, channelID, logoURL, cacheKey string, variant Variant) (*ThumbnailInfo, error) {
+	outputPath := filepath.Join(ts.cacheDir, cacheKey+"."+variant.ext())
+	tmpPath := filepath.Join(ts.cacheDir, cacheKey+".tmp."+variant.ext())
+	defer os.Remove(tmpPath)
+
+	release, err := ts.waitForSlot(ctx, logoURL)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(ctx, ts.current().timeout)
+	defer cancel()
+
+	filter := fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2:color=%s",
+		variant.Width, variant.Height, variant.Width, variant.Height, logoBackground)
+	args := []string{
+		"-y",
+		// The URL comes from a playlist, never let it read local files
+		"-protocol_whitelist", "http,https,tcp,tls",
+		"-i", logoURL,
+		"-frames:v", "1",
+		"-vf", filter,
+	}
+	args = append(args, ts.encoderArgs(variant)...)
+	args = append(args, tmpPath)
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stderr = nil
+
+	if err := cmd.Run(); err != nil {
+		switch ctx.Err() {
+		case context.DeadlineExceeded:
+			return nil, fmt.Errorf("logo download timed out")
+		case context.Canceled:
+			return nil, fmt.Errorf("logo download canceled: %w", ctx.Err())
+		}
+		return nil, fmt.Errorf("failed to convert logo: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, outputPath); err != nil {
+		return nil, fmt.Errorf("failed to save logo: %w", err)
+	}
+
+	fileInfo, err := os.Stat(outputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat logo file: %w", err)
+	}
+
+	return &ThumbnailInfo{
+		ChannelID:   channelID,
+		StreamURL:   logoURL,
+		FilePath:    outputPath,
+		GeneratedAt: time.Now(),
+		Size:        fileInfo.Size(),
+		Width:       variant.Width,
+		Height:      variant.Height,
+		Format:      variant.Format,
+		Logo:        true,
+	}, nil
+}
+
+// Placeholder returns an SVG card of the variant's size showing the
+// channel's initials and name, on a color derived from the name so channels
+// stay apart in a grid. It needs neither ffmpeg nor the network.
+func Placeholder(name string, variant Variant) []byte {
+	name = strings.TrimSpace(name)
+
+	hash := fnv.New32a()
+	hash.Write([]byte(name))
+	hue := hash.Sum32() % 360
+
+	label := name
+	if runes := []rune(label); len(runes) > 24 {
+		label = string(runes[:23]) + "…"
+	}
+
+	return []byte(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+
+		`<rect width="100%%" height="100%%" fill="hsl(%d,35%%,25%%)"/>`+
+		`<text x="50%%" y="48%%" text-anchor="middle" dominant-baseline="middle" font-family="sans-serif" font-weight="bold" font-size="%d" fill="#fff">%s</text>`+
+		`<text x="50%%" y="80%%" text-anchor="middle" dominant-baseline="middle" font-family="sans-serif" font-size="%d" fill="#e5e7eb">%s</text>`+
+		`</svg>`,
+		variant.Width, variant.Height, variant.Width, variant.Height,
+		hue,
+		variant.Height/3, html.EscapeString(initials(name)),
+		variant.Height/8, html.EscapeString(label),
+	))
+}
+
+// initials returns the first letter of up to two words of name
+func initials(name string) string {
+	var letters []rune
+	for _, word := range strings.Fields(name) {
+		for _, r := range word {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				letters = append(letters, unicode.ToUpper(r))
+				break
+			}
+		}
+		if len(letters) == 2 {
+			break
+		}
+	}
+	if len(letters) == 0 {
+		return "TV"
+	}
+	return string(letters)
+}
@@ -0,0 +1,157 @@
+package thumbnail
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// IndexFile lists the cached thumbnails, so the cache and its access order
+// survive restarts. It lives in the cache directory.
+const IndexFile = "index.json"
+
+// indexEntry is one persisted cache entry
+type indexEntry struct {
+	Key string `json:"key"`
+	ThumbnailInfo
+}
+
+// loadIndex restores the cache saved by saveIndex, dropping entries whose
+// file is gone, and deletes files the index doesn't know about: left by a
+// crash or by versions that didn't keep an index.
+func (ts *ThumbnailService) loadIndex() {
+	data, err := os.ReadFile(filepath.Join(ts.cacheDir, IndexFile))
+	if err != nil && !os.IsNotExist(err) {
+		ts.logger.Warn("failed to read thumbnail index", "error", err)
+	}
+
+	var entries []indexEntry
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &entries); err != nil {
+			ts.logger.Warn("failed to parse thumbnail index", "error", err)
+		}
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	files := make(map[string]bool)
+	for _, entry := range entries {
+		info := entry.ThumbnailInfo
+		if _, err := os.Stat(info.FilePath); err != nil {
+			continue
+		}
+		ts.cache[entry.Key] = &info
+		files[filepath.Base(info.FilePath)] = true
+	}
+
+	dirEntries, err := os.ReadDir(ts.cacheDir)
+	if err != nil {
+		return
+	}
+	removed := 0
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		if dirEntry.IsDir() || name == IndexFile || files[name] {
+			continue
+		}
+		if os.Remove(filepath.Join(ts.cacheDir, name)) == nil {
+			removed++
+		}
+	}
+	if removed > 0 {
+		ts.logger.Info("removed unindexed thumbnails", "count", removed)
+	}
+}
+
+// saveIndex writes the index if the cache changed since the last save
+func (ts *ThumbnailService) saveIndex() error {
+	ts.mu.Lock()
+	if !ts.dirty {
+		ts.mu.Unlock()
+		return nil
+	}
+	entries := make([]indexEntry, 0, len(ts.cache))
+	for key, info := range ts.cache {
+		entries = append(entries, indexEntry{Key: key, ThumbnailInfo: *info})
+	}
+	ts.dirty = false
+	ts.mu.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	// Write then rename so a crash never leaves a truncated index
+	path := filepath.Join(ts.cacheDir, IndexFile)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Close saves the index, for shutdown
+func (ts *ThumbnailService) Close() error {
+	return ts.saveIndex()
+}
+
+// touch marks a cached entry as used now, ts.mu must be held for writing
+func (ts *ThumbnailService) touch(info *ThumbnailInfo) {
+	info.LastAccessed = time.Now()
+	ts.dirty = true
+}
+
+// evict removes the least recently used entries until the cache fits in
+// maxCacheSize, keeping the entry under keep. ts.mu must be held for
+// writing.
+func (ts *ThumbnailService) evict(keep string) {
+	maxCacheSize := ts.current().maxCacheSize
+	if maxCacheSize <= 0 {
+		return
+	}
+
+	var total int64
+	keys := make([]string, 0, len(ts.cache))
+	for key, info := range ts.cache {
+		total += info.Size
+		keys = append(keys, key)
+	}
+	if total <= maxCacheSize {
+		return
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return ts.cache[keys[i]].lastUsed().Before(ts.cache[keys[j]].lastUsed())
+	})
+
+	evicted := 0
+	for _, key := range keys {
+		if total <= maxCacheSize {
+			break
+		}
+		if key == keep {
+			continue
+		}
+		info := ts.cache[key]
+		os.Remove(info.FilePath)
+		delete(ts.cache, key)
+		total -= info.Size
+		evicted++
+	}
+
+	ts.dirty = true
+	ts.logger.Debug("evicted thumbnails over the cache size", "count", evicted, "total_size", total)
+}
+
+// lastUsed is when the thumbnail was last served, or generated
+func (info *ThumbnailInfo) lastUsed() time.Time {
+	if info.LastAccessed.After(info.GeneratedAt) {
+		return info.LastAccessed
+	}
+	return info.GeneratedAt
+}
@@ -0,0 +1,137 @@
+package thumbnail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Animated preview formats
+const (
+	PreviewWebP = "webp"
+	PreviewGIF  = "gif" // Larger, for ffmpeg builds without libwebp
+)
+
+// ErrPreviewsDisabled is returned when animated previews are not enabled
+var ErrPreviewsDisabled = errors.New("animated previews are disabled")
+
+// PreviewConfig configures animated previews, short looping clips shown
+// when hovering a channel
+type PreviewConfig struct {
+	Enabled  bool
+	Duration time.Duration // Length of the clip
+	FPS      int
+	Format   string // PreviewWebP or PreviewGIF
+}
+
+// PreviewsEnabled reports whether animated previews can be requested
+func (ts *ThumbnailService) PreviewsEnabled() bool {
+	return ts.preview.Enabled
+}
+
+// previewCacheKey keys previews apart from still thumbnails of the channel
+func (ts *ThumbnailService) previewCacheKey(channelID string) string {
+	return ts.generateCacheKey(channelID) + "-preview"
+}
+
+// GetPreview retrieves the animated preview of a channel, generating it if
+// necessary until ctx is done. Previews are cached like thumbnails.
+func (ts *ThumbnailService) GetPreview(ctx context.Context, channelID, streamURL string) (*ThumbnailInfo, error) {
+	if !ts.preview.Enabled {
+		return nil, ErrPreviewsDisabled
+	}
+
+	cacheKey := ts.previewCacheKey(channelID)
+
+	return ts.getOrGenerate(ctx, cacheKey, func(ctx context.Context) (*ThumbnailInfo, error) {
+		return ts.generatePreview(ctx, channelID, streamURL, cacheKey)
+	})
+}
+
+// generatePreview captures a few seconds of the stream at a low frame rate
+// and encodes them as a looping animation
+func (ts *ThumbnailService) generatePreview(ctx context.Context, channelID, streamURL, cacheKey string) (*ThumbnailInfo, error) {
+	ts.logger.Debug("generating preview", "channel_id", channelID, "stream_url", streamURL)
+
+	format := ts.preview.Format
+	if format != PreviewGIF {
+		format = PreviewWebP
+	}
+	outputPath := filepath.Join(ts.cacheDir, cacheKey+"."+format)
+	tmpPath := filepath.Join(ts.cacheDir, cacheKey+".tmp."+format)
+	defer os.Remove(tmpPath)
+
+	release, err := ts.waitForSlot(ctx, streamURL)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// Connecting takes as long as for a thumbnail, then the clip is recorded
+	opts := ts.current()
+	ctx, cancel := context.WithTimeout(ctx, opts.timeout+ts.preview.Duration)
+	defer cancel()
+
+	filter := fmt.Sprintf("fps=%d,scale=%d:%d:force_original_aspect_ratio=decrease", ts.preview.FPS, opts.maxWidth, opts.maxHeight)
+	args := append([]string{"-y"}, ts.inputOptions(streamURL)...)
+	args = append(args,
+		"-i", streamURL,
+		"-t", strconv.FormatFloat(ts.preview.Duration.Seconds(), 'f', -1, 64),
+		"-an",
+	)
+	if format == PreviewGIF {
+		// A palette computed from the clip keeps GIF colors acceptable
+		args = append(args,
+			"-filter_complex", filter+",split[a][b];[a]palettegen=max_colors=128[p];[b][p]paletteuse=dither=bayer",
+		)
+	} else {
+		args = append(args,
+			"-vf", filter,
+			"-c:v", "libwebp_anim",
+			"-quality", strconv.Itoa(opts.quality),
+		)
+	}
+	args = append(args, "-loop", "0", tmpPath)
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stderr = nil
+
+	if err := cmd.Run(); err != nil {
+		switch ctx.Err() {
+		case context.DeadlineExceeded:
+			return nil, fmt.Errorf("preview generation timed out")
+		case context.Canceled:
+			return nil, fmt.Errorf("preview generation canceled: %w", ctx.Err())
+		}
+		return nil, fmt.Errorf("failed to generate preview: %w", err)
+	}
+
+	// Replace the previous preview only once the new one is complete
+	if err := os.Rename(tmpPath, outputPath); err != nil {
+		return nil, fmt.Errorf("failed to save preview: %w", err)
+	}
+
+	fileInfo, err := os.Stat(outputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat preview file: %w", err)
+	}
+
+	ts.logger.Debug("generated preview", "channel_id", channelID, "path", outputPath, "bytes", fileInfo.Size())
+
+	return &ThumbnailInfo{
+		ChannelID:   channelID,
+		StreamURL:   streamURL,
+		FilePath:    outputPath,
+		GeneratedAt: time.Now(),
+		Size:        fileInfo.Size(),
+		Width:       opts.maxWidth,
+		Height:      opts.maxHeight,
+		Format:      format,
+		Animated:    true,
+	}, nil
+}
@@ -0,0 +1,82 @@
+package thumbnail
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os/exec"
+	"time"
+)
+
+// A capture whose average luma (0-255) is below blackLuma is a black frame,
+// one whose luma deviates less than flatDeviation is a flat card such as an
+// "offline" slate without much on it
+const (
+	blackLuma     = 20
+	flatDeviation = 8
+)
+
+// analysisWidth and analysisHeight are the size frames are reduced to
+// before measuring them, enough to tell a picture from a blank card
+const (
+	analysisWidth  = 32
+	analysisHeight = 18
+)
+
+// samplePixels decodes the image at path into analysisWidth x
+// analysisHeight rgb24 pixels. ffmpeg reads every format thumbnails are
+// encoded in.
+func (ts *ThumbnailService) samplePixels(path string) []byte {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-v", "error",
+		"-i", path,
+		"-vf", fmt.Sprintf("scale=%d:%d,format=rgb24", analysisWidth, analysisHeight),
+		"-f", "rawvideo",
+		"-",
+	)
+	pixels, err := cmd.Output()
+	if err != nil || len(pixels) < analysisWidth*analysisHeight*3 {
+		ts.logger.Debug("failed to analyse thumbnail", "path", path, "error", err)
+		return nil
+	}
+
+	return pixels[:analysisWidth*analysisHeight*3]
+}
+
+// isBlankFrame reports whether rgb24 pixels are black or nearly uniform.
+// Images that couldn't be sampled are assumed fine.
+func isBlankFrame(pixels []byte) bool {
+	if len(pixels) == 0 {
+		return false
+	}
+
+	luma := make([]byte, len(pixels)/3)
+	for i := range luma {
+		r, g, b := float64(pixels[i*3]), float64(pixels[i*3+1]), float64(pixels[i*3+2])
+		luma[i] = byte(0.299*r + 0.587*g + 0.114*b)
+	}
+
+	mean, deviation := lumaStats(luma)
+	return mean < blackLuma || deviation < flatDeviation
+}
+
+// lumaStats returns the mean and standard deviation of 8-bit gray pixels
+func lumaStats(pixels []byte) (float64, float64) {
+	var sum float64
+	for _, p := range pixels {
+		sum += float64(p)
+	}
+	mean := sum / float64(len(pixels))
+
+	var variance float64
+	for _, p := range pixels {
+		d := float64(p) - mean
+		variance += d * d
+	}
+	variance /= float64(len(pixels))
+
+	return mean, math.Sqrt(variance)
+}
@@ -0,0 +1,118 @@
+package thumbnail
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// ErrQueueFull is returned when too many thumbnails are waiting for ffmpeg
+var ErrQueueFull = errors.New("thumbnail generation queue is full")
+
+// QueueConfig bounds the ffmpeg processes run for thumbnails, previews and
+// logos, whichever request or job asks for them
+type QueueConfig struct {
+	MaxConcurrent int // ffmpeg processes at once
+	MaxPerHost    int // ffmpeg processes at once reading from one upstream host
+	MaxQueued     int // Generations waiting for a slot before new ones are refused
+}
+
+// generationQueue hands out ffmpeg slots, per upstream host then globally,
+// so one provider never sees more than MaxPerHost connections from us
+type generationQueue struct {
+	config  QueueConfig
+	slots   chan struct{}
+	mu      sync.Mutex
+	hosts   map[string]chan struct{}
+	waiting int
+}
+
+func newGenerationQueue(config QueueConfig) *generationQueue {
+	if config.MaxConcurrent <= 0 {
+		config.MaxConcurrent = 1
+	}
+	if config.MaxPerHost <= 0 || config.MaxPerHost > config.MaxConcurrent {
+		config.MaxPerHost = config.MaxConcurrent
+	}
+	return &generationQueue{
+		config: config,
+		slots:  make(chan struct{}, config.MaxConcurrent),
+		hosts:  make(map[string]chan struct{}),
+	}
+}
+
+// acquire waits for a slot to read sourceURL until ctx is done. The host
+// slot is taken first, so generations for a busy host don't hold global
+// slots other hosts could use.
+func (q *generationQueue) acquire(ctx context.Context, sourceURL string) (func(), error) {
+	host := sourceHost(sourceURL)
+
+	q.mu.Lock()
+	if q.config.MaxQueued > 0 && q.waiting >= q.config.MaxQueued {
+		q.mu.Unlock()
+		return nil, ErrQueueFull
+	}
+	q.waiting++
+	hostSlots, ok := q.hosts[host]
+	if !ok {
+		hostSlots = make(chan struct{}, q.config.MaxPerHost)
+		q.hosts[host] = hostSlots
+	}
+	q.mu.Unlock()
+
+	defer func() {
+		q.mu.Lock()
+		q.waiting--
+		q.mu.Unlock()
+	}()
+
+	select {
+	case hostSlots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	select {
+	case q.slots <- struct{}{}:
+	case <-ctx.Done():
+		<-hostSlots
+		return nil, ctx.Err()
+	}
+
+	return func() {
+		<-q.slots
+		<-hostSlots
+	}, nil
+}
+
+// stats returns the running and waiting generation counts
+func (q *generationQueue) stats() (int, int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.slots), q.waiting
+}
+
+// sourceHost is the host limits apply to, the URL itself when it has none
+func sourceHost(sourceURL string) string {
+	parsed, err := url.Parse(sourceURL)
+	if err != nil || parsed.Hostname() == "" {
+		return sourceURL
+	}
+	return strings.ToLower(parsed.Hostname())
+}
+
+// waitForSlot waits up to the generation timeout, or until ctx is done, for
+// an ffmpeg slot reading sourceURL. The returned func releases it.
+func (ts *ThumbnailService) waitForSlot(ctx context.Context, sourceURL string) (func(), error) {
+	timeoutCtx, cancel := context.WithTimeout(ctx, ts.current().timeout)
+	defer cancel()
+
+	release, err := ts.queue.acquire(timeoutCtx, sourceURL)
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+		ts.logger.Warn("thumbnail generation queue backed up", "host", sourceHost(sourceURL))
+		return nil, ErrQueueFull
+	}
+	return release, err
+}
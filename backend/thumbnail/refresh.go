@@ -0,0 +1,165 @@
+package thumbnail
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RefreshConfig configures the background refresher, which regenerates
+// thumbnails that are about to expire so requests rarely wait for ffmpeg
+type RefreshConfig struct {
+	Interval     time.Duration // How often the cache is scanned
+	Lead         time.Duration // Regenerate this long before the TTL runs out
+	RecentWindow time.Duration // Thumbnails served this recently are kept fresh
+	Concurrency  int           // ffmpeg processes run at once
+	TopFavorites int           // Favorites kept fresh per active profile
+}
+
+// DefaultRefreshConfig returns default refresher settings
+func DefaultRefreshConfig() RefreshConfig {
+	return RefreshConfig{
+		Interval:     time.Minute,
+		Lead:         time.Minute,
+		RecentWindow: 15 * time.Minute,
+		Concurrency:  2,
+		TopFavorites: 10,
+	}
+}
+
+// refreshJob is one thumbnail to regenerate
+type refreshJob struct {
+	cacheKey  string
+	channelID string
+	streamURL string
+	variant   Variant
+}
+
+// StartRefresher starts regenerating recently served thumbnails, and the
+// default thumbnail of the channels returned by favorites (channel id ->
+// stream URL), before they expire
+func (ts *ThumbnailService) StartRefresher(config RefreshConfig, favorites func(top int) map[string]string) {
+	if config.Interval <= 0 {
+		config.Interval = time.Minute
+	}
+	if config.Concurrency <= 0 {
+		config.Concurrency = 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	ts.genMu.Lock()
+	if ts.stopRefresh != nil {
+		ts.stopRefresh()
+	}
+	ts.stopRefresh = cancel
+	ts.genMu.Unlock()
+
+	ts.logger.Info("thumbnail refresher enabled", "interval", config.Interval.String(), "top_favorites", config.TopFavorites)
+
+	go func() {
+		ticker := time.NewTicker(config.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			var targets map[string]string
+			if favorites != nil && config.TopFavorites > 0 {
+				targets = favorites(config.TopFavorites)
+			}
+			ts.refresh(ctx, ts.refreshJobs(config, targets), config.Concurrency)
+		}
+	}()
+}
+
+// StopRefresher stops the background refresher
+func (ts *ThumbnailService) StopRefresher() {
+	ts.genMu.Lock()
+	defer ts.genMu.Unlock()
+	if ts.stopRefresh != nil {
+		ts.stopRefresh()
+		ts.stopRefresh = nil
+	}
+}
+
+// refreshJobs lists the thumbnails due for regeneration: recently served
+// ones, and the favorites' default thumbnail, that expire within the lead
+// time or aren't cached
+func (ts *ThumbnailService) refreshJobs(config RefreshConfig, favorites map[string]string) []refreshJob {
+	ttl := ts.current().cacheTTL
+
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	due := func(info *ThumbnailInfo) bool {
+		return time.Since(info.GeneratedAt) > ttl-config.Lead
+	}
+
+	var jobs []refreshJob
+	queued := make(map[string]bool)
+	for key, info := range ts.cache {
+		// Previews are long to record and only wanted on hover, logos are
+		// fallbacks
+		if info.Animated || info.Logo || time.Since(info.LastAccessed) > config.RecentWindow || !due(info) {
+			continue
+		}
+		jobs = append(jobs, refreshJob{
+			cacheKey:  key,
+			channelID: info.ChannelID,
+			streamURL: info.StreamURL,
+			variant:   Variant{Width: info.Width, Height: info.Height, Format: info.Format},
+		})
+		queued[key] = true
+	}
+
+	variant := ts.DefaultVariant()
+	for channelID, streamURL := range favorites {
+		key := ts.variantCacheKey(channelID, variant)
+		if queued[key] {
+			continue
+		}
+		if info, exists := ts.cache[key]; exists && !due(info) {
+			continue
+		}
+		jobs = append(jobs, refreshJob{cacheKey: key, channelID: channelID, streamURL: streamURL, variant: variant})
+	}
+
+	return jobs
+}
+
+// refresh regenerates jobs, concurrency at a time, until ctx is cancelled
+func (ts *ThumbnailService) refresh(ctx context.Context, jobs []refreshJob, concurrency int) {
+	if len(jobs) == 0 {
+		return
+	}
+	ts.logger.Debug("refreshing thumbnails", "count", len(jobs))
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for _, job := range jobs {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case sem <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func(job refreshJob) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			ts.regenerate(ctx, job.cacheKey, func(ctx context.Context) (*ThumbnailInfo, error) {
+				return ts.generateThumbnail(ctx, job.channelID, job.streamURL, job.cacheKey, job.variant)
+			})
+		}(job)
+	}
+
+	wg.Wait()
+}
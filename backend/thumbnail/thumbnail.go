@@ -5,12 +5,16 @@ import (
 	"crypto/md5"
 	"encoding/hex"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+
+	"iptv-backend/logging"
 )
 
 // ThumbnailInfo contains metadata about a cached thumbnail
@@ -22,20 +26,37 @@ type ThumbnailInfo struct {
 	Size        int64     `json:"size"`
 	Width       int       `json:"width"`
 	Height      int       `json:"height"`
+	Format      string    `json:"format,omitempty"`
+	Animated    bool      `json:"animated,omitempty"`
+	// LowQuality marks a capture that still looked blank after retrying
+	LowQuality bool `json:"low_quality,omitempty"`
+	// Logo marks a channel logo cached in place of a capture
+	Logo bool `json:"logo,omitempty"`
+	// ETag identifies the image's content for conditional requests
+	ETag string `json:"etag,omitempty"`
+	// Blurhash is a placeholder clients can show while the image loads
+	Blurhash string `json:"blurhash,omitempty"`
+	// LastAccessed orders eviction when the cache outgrows its size
+	LastAccessed time.Time `json:"last_accessed"`
 }
 
 // ThumbnailService manages thumbnail generation and caching
 type ThumbnailService struct {
-	cacheDir     string
-	cacheTTL     time.Duration
-	cache        map[string]*ThumbnailInfo
-	generating   map[string]bool
-	mu           sync.RWMutex
-	genMu        sync.Mutex
-	maxWidth     int
-	maxHeight    int
-	quality      int
-	timeout      time.Duration
+	cacheDir        string
+	cache           map[string]*ThumbnailInfo
+	generating      map[string]bool
+	mu              sync.RWMutex
+	genMu           sync.Mutex
+	optionsMu       sync.RWMutex
+	opts            options // Changed at runtime, guarded by optionsMu
+	preview         PreviewConfig
+	blankRetries    int
+	queue           *generationQueue
+	blankRetryDelay time.Duration
+	inputArgs       func(input string) []string
+	dirty           bool               // Cache changed since the index was saved
+	stopRefresh     context.CancelFunc // Guarded by genMu
+	logger          *slog.Logger
 }
 
 // ServiceConfig holds configuration for the thumbnail service
@@ -46,6 +67,18 @@ type ServiceConfig struct {
 	MaxHeight int
 	Quality   int
 	Timeout   time.Duration
+	Preview   PreviewConfig
+	// MaxCacheSize bounds the cache directory in bytes, least recently used
+	// thumbnails are removed beyond it. 0 disables the limit.
+	MaxCacheSize int64
+	// BlankRetries is how many times a black or flat capture is taken again,
+	// BlankRetryDelay further into the stream each time. 0 disables retries.
+	BlankRetries    int
+	BlankRetryDelay time.Duration
+	Queue           QueueConfig
+	// InputArgs returns the ffmpeg options to read a stream with, such as
+	// the headers its provider requires. Optional.
+	InputArgs func(input string) []string
 }
 
 // DefaultConfig returns the default service configuration
@@ -57,7 +90,28 @@ func DefaultConfig() ServiceConfig {
 		MaxHeight: 180,
 		Quality:   85,
 		Timeout:   15 * time.Second,
+		Preview: PreviewConfig{
+			Duration: 3 * time.Second,
+			FPS:      5,
+			Format:   PreviewWebP,
+		},
+		MaxCacheSize:    256 << 20,
+		BlankRetries:    2,
+		BlankRetryDelay: 3 * time.Second,
+		Queue: QueueConfig{
+			MaxConcurrent: 4,
+			MaxPerHost:    2,
+			MaxQueued:     64,
+		},
+	}
+}
+
+// inputOptions returns the ffmpeg options to read a stream with
+func (ts *ThumbnailService) inputOptions(streamURL string) []string {
+	if ts.inputArgs == nil {
+		return nil
 	}
+	return ts.inputArgs(streamURL)
 }
 
 // NewThumbnailService creates a new thumbnail service
@@ -67,15 +121,27 @@ func NewThumbnailService(config ServiceConfig) *ThumbnailService {
 
 	service := &ThumbnailService{
 		cacheDir:   config.CacheDir,
-		cacheTTL:   config.CacheTTL,
 		cache:      make(map[string]*ThumbnailInfo),
 		generating: make(map[string]bool),
-		maxWidth:   config.MaxWidth,
-		maxHeight:  config.MaxHeight,
-		quality:    config.Quality,
-		timeout:    config.Timeout,
+		opts: options{
+			cacheTTL:     config.CacheTTL,
+			maxWidth:     config.MaxWidth,
+			maxHeight:    config.MaxHeight,
+			quality:      config.Quality,
+			timeout:      config.Timeout,
+			maxCacheSize: config.MaxCacheSize,
+		},
+		preview:         config.Preview,
+		blankRetries:    config.BlankRetries,
+		blankRetryDelay: config.BlankRetryDelay,
+		queue:           newGenerationQueue(config.Queue),
+		inputArgs:       config.InputArgs,
+		logger:          logging.For("thumbnail"),
 	}
 
+	// Restore the cache of the previous run
+	service.loadIndex()
+
 	// Start cache cleanup goroutine
 	go service.cleanupLoop()
 
@@ -88,22 +154,46 @@ func (ts *ThumbnailService) generateCacheKey(channelID string) string {
 	return hex.EncodeToString(hash[:])
 }
 
-// GetThumbnail retrieves a thumbnail, generating it if necessary
-func (ts *ThumbnailService) GetThumbnail(channelID, streamURL string) (*ThumbnailInfo, error) {
-	cacheKey := ts.generateCacheKey(channelID)
+// GetThumbnail retrieves a thumbnail, generating it if necessary. The
+// generation stops when ctx is done, such as when the client went away.
+func (ts *ThumbnailService) GetThumbnail(ctx context.Context, channelID, streamURL string) (*ThumbnailInfo, error) {
+	return ts.GetThumbnailVariant(ctx, channelID, streamURL, ts.DefaultVariant())
+}
+
+// GetThumbnailVariant retrieves a thumbnail in a given size and format,
+// generating it if necessary until ctx is done. Each variant is cached on
+// its own.
+func (ts *ThumbnailService) GetThumbnailVariant(ctx context.Context, channelID, streamURL string, variant Variant) (*ThumbnailInfo, error) {
+	cacheKey := ts.variantCacheKey(channelID, variant)
+
+	return ts.getOrGenerate(ctx, cacheKey, func(ctx context.Context) (*ThumbnailInfo, error) {
+		return ts.generateThumbnail(ctx, channelID, streamURL, cacheKey, variant)
+	})
+}
 
+// getOrGenerate returns the cached image under cacheKey, or runs generate
+// once at a time per key and caches its result. An expired image is served
+// as is for up to another TTL while a fresh one is generated in the
+// background (stale-while-revalidate), which outlives ctx.
+func (ts *ThumbnailService) getOrGenerate(ctx context.Context, cacheKey string, generate func(context.Context) (*ThumbnailInfo, error)) (*ThumbnailInfo, error) {
 	// Check if we have a valid cached thumbnail
-	ts.mu.RLock()
+	ttl := ts.current().cacheTTL
+	ts.mu.Lock()
 	if info, exists := ts.cache[cacheKey]; exists {
-		if time.Since(info.GeneratedAt) < ts.cacheTTL {
+		age := time.Since(info.GeneratedAt)
+		if age < ttl*2 {
 			// Check if file still exists
 			if _, err := os.Stat(info.FilePath); err == nil {
-				ts.mu.RUnlock()
+				ts.touch(info)
+				ts.mu.Unlock()
+				if age >= ttl {
+					go ts.regenerate(context.Background(), cacheKey, generate)
+				}
 				return info, nil
 			}
 		}
 	}
-	ts.mu.RUnlock()
+	ts.mu.Unlock()
 
 	// Check if already generating
 	ts.genMu.Lock()
@@ -129,54 +219,91 @@ func (ts *ThumbnailService) GetThumbnail(channelID, streamURL string) (*Thumbnai
 	}()
 
 	// Generate new thumbnail
-	info, err := ts.generateThumbnail(channelID, streamURL, cacheKey)
+	info, err := generate(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	// Update cache
-	ts.mu.Lock()
-	ts.cache[cacheKey] = info
-	ts.mu.Unlock()
+	ts.store(cacheKey, info, true)
 
 	return info, nil
 }
 
-// generateThumbnail creates a new thumbnail using ffmpeg
-func (ts *ThumbnailService) generateThumbnail(channelID, streamURL, cacheKey string) (*ThumbnailInfo, error) {
-	log.Printf("Generating thumbnail for channel %s from %s", channelID, streamURL)
+// regenerate replaces the image under cacheKey, unless it is already being
+// generated
+func (ts *ThumbnailService) regenerate(ctx context.Context, cacheKey string, generate func(context.Context) (*ThumbnailInfo, error)) {
+	ts.genMu.Lock()
+	if ts.generating[cacheKey] {
+		ts.genMu.Unlock()
+		return
+	}
+	ts.generating[cacheKey] = true
+	ts.genMu.Unlock()
 
-	outputPath := filepath.Join(ts.cacheDir, cacheKey+".jpg")
+	defer func() {
+		ts.genMu.Lock()
+		delete(ts.generating, cacheKey)
+		ts.genMu.Unlock()
+	}()
 
-	// Create context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), ts.timeout)
-	defer cancel()
+	info, err := generate(ctx)
+	if err != nil {
+		ts.logger.Debug("failed to refresh thumbnail", "cache_key", cacheKey, "error", err)
+		return
+	}
+	ts.store(cacheKey, info, false)
+}
 
-	// ffmpeg command to capture a single frame
-	// -ss 0: start at beginning
-	// -i: input URL
-	// -vframes 1: capture only 1 frame
-	// -vf scale: resize to max dimensions while maintaining aspect ratio
-	// -q:v 2-5: quality (2=best, 31=worst)
-	// -y: overwrite output
-	args := []string{
-		"-y",
-		"-ss", "0",
-		"-i", streamURL,
-		"-vframes", "1",
-		"-vf", fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease", ts.maxWidth, ts.maxHeight),
-		"-q:v", fmt.Sprintf("%d", 31-((ts.quality*29)/100)), // Convert quality to ffmpeg scale
-		outputPath,
+// store caches a generated image, making room for its file. Images
+// refreshed in the background keep the access time of the one they replace,
+// so refreshing doesn't count as use.
+func (ts *ThumbnailService) store(cacheKey string, info *ThumbnailInfo, accessed bool) {
+	info.ETag = fileETag(info.FilePath)
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if previous, exists := ts.cache[cacheKey]; exists && !accessed {
+		info.LastAccessed = previous.LastAccessed
 	}
+	ts.cache[cacheKey] = info
+	ts.dirty = true
+	if accessed {
+		ts.touch(info)
+	}
+	ts.evict(cacheKey)
+}
 
-	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
-	cmd.Stderr = nil // Suppress ffmpeg stderr output
+// generateThumbnail creates a new thumbnail using ffmpeg
+func (ts *ThumbnailService) generateThumbnail(ctx context.Context, channelID, streamURL, cacheKey string, variant Variant) (*ThumbnailInfo, error) {
+	ts.logger.Debug("generating thumbnail", "channel_id", channelID, "stream_url", streamURL, "width", variant.Width, "format", variant.Format)
+
+	outputPath := filepath.Join(ts.cacheDir, cacheKey+"."+variant.ext())
+	tmpPath := filepath.Join(ts.cacheDir, cacheKey+".tmp."+variant.ext())
+	defer os.Remove(tmpPath)
+
+	// Black frames and "offline" slates are common at the start of a
+	// stream, capture again further in before settling for one
+	var lowQuality bool
+	var pixels []byte
+	for attempt := 0; ; attempt++ {
+		offset := time.Duration(attempt) * ts.blankRetryDelay
+		if err := ts.capture(ctx, streamURL, tmpPath, variant, offset); err != nil {
+			return nil, err
+		}
 
-	if err := cmd.Run(); err != nil {
-		if ctx.Err() == context.DeadlineExceeded {
-			return nil, fmt.Errorf("thumbnail generation timed out")
+		pixels = ts.samplePixels(tmpPath)
+		lowQuality = isBlankFrame(pixels)
+		if !lowQuality || attempt >= ts.blankRetries {
+			break
 		}
-		return nil, fmt.Errorf("failed to generate thumbnail: %w", err)
+		ts.logger.Debug("blank thumbnail capture, retrying", "channel_id", channelID, "attempt", attempt+1)
+	}
+
+	// Replace the previous thumbnail only once the new one is complete, it
+	// may be served meanwhile
+	if err := os.Rename(tmpPath, outputPath); err != nil {
+		return nil, fmt.Errorf("failed to save thumbnail: %w", err)
 	}
 
 	// Get file info
@@ -191,35 +318,97 @@ func (ts *ThumbnailService) generateThumbnail(channelID, streamURL, cacheKey str
 		FilePath:    outputPath,
 		GeneratedAt: time.Now(),
 		Size:        fileInfo.Size(),
-		Width:       ts.maxWidth,
-		Height:      ts.maxHeight,
+		Width:       variant.Width,
+		Height:      variant.Height,
+		Format:      variant.Format,
+		LowQuality:  lowQuality,
+		Blurhash:    Blurhash(pixels, analysisWidth, analysisHeight),
 	}
 
-	log.Printf("Generated thumbnail for channel %s: %s (%d bytes)", channelID, outputPath, fileInfo.Size())
+	ts.logger.Debug("generated thumbnail", "channel_id", channelID, "path", outputPath, "bytes", fileInfo.Size(), "low_quality", lowQuality)
 
 	return info, nil
 }
 
+// capture grabs a single frame offset into the stream into outputPath,
+// killing ffmpeg when ctx is done
+func (ts *ThumbnailService) capture(ctx context.Context, streamURL, outputPath string, variant Variant, offset time.Duration) error {
+	release, err := ts.waitForSlot(ctx, streamURL)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	// Create context with timeout, reading up to the offset takes as long
+	ctx, cancel := context.WithTimeout(ctx, ts.current().timeout+offset)
+	defer cancel()
+
+	// ffmpeg command to capture a single frame
+	// -ss 0: start at beginning
+	// -i: input URL, after the options its provider requires
+	// -ss offset: decode and drop the stream up to the offset, live streams
+	// can't seek
+	// -vframes 1: capture only 1 frame
+	// -vf scale: resize to the variant's dimensions while maintaining aspect ratio
+	// then the encoder options of the variant's format
+	// -y: overwrite output
+	args := append([]string{"-y", "-ss", "0"}, ts.inputOptions(streamURL)...)
+	args = append(args, "-i", streamURL)
+	if offset > 0 {
+		args = append(args, "-ss", strconv.FormatFloat(offset.Seconds(), 'f', -1, 64))
+	}
+	args = append(args,
+		"-vframes", "1",
+		"-vf", fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease", variant.Width, variant.Height),
+	)
+	args = append(args, ts.encoderArgs(variant)...)
+	args = append(args, outputPath)
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stderr = nil // Suppress ffmpeg stderr output
+
+	if err := cmd.Run(); err != nil {
+		switch ctx.Err() {
+		case context.DeadlineExceeded:
+			return fmt.Errorf("thumbnail generation timed out")
+		case context.Canceled:
+			// ffmpeg was killed, its exit status says nothing of the stream
+			return fmt.Errorf("thumbnail generation canceled: %w", ctx.Err())
+		}
+		return fmt.Errorf("failed to generate thumbnail: %w", err)
+	}
+
+	return nil
+}
+
 // GetThumbnailPath returns the path to a thumbnail if it exists and is valid
 func (ts *ThumbnailService) GetThumbnailPath(channelID string) (string, bool) {
-	cacheKey := ts.generateCacheKey(channelID)
+	return ts.GetVariantPath(channelID, ts.DefaultVariant())
+}
 
-	ts.mu.RLock()
-	defer ts.mu.RUnlock()
+// GetVariantPath returns the path to a thumbnail variant if it exists and
+// is valid
+func (ts *ThumbnailService) GetVariantPath(channelID string, variant Variant) (string, bool) {
+	cacheKey := ts.variantCacheKey(channelID, variant)
+	ttl := ts.current().cacheTTL
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
 
 	if info, exists := ts.cache[cacheKey]; exists {
-		if time.Since(info.GeneratedAt) < ts.cacheTTL {
+		if time.Since(info.GeneratedAt) < ttl {
 			if _, err := os.Stat(info.FilePath); err == nil {
+				ts.touch(info)
 				return info.FilePath, true
 			}
 		}
 	}
 
 	// Check if file exists on disk even if not in memory cache
-	filePath := filepath.Join(ts.cacheDir, cacheKey+".jpg")
+	filePath := filepath.Join(ts.cacheDir, cacheKey+"."+variant.ext())
 	if info, err := os.Stat(filePath); err == nil {
 		// File exists, check if it's recent enough
-		if time.Since(info.ModTime()) < ts.cacheTTL {
+		if time.Since(info.ModTime()) < ttl {
 			return filePath, true
 		}
 	}
@@ -227,31 +416,42 @@ func (ts *ThumbnailService) GetThumbnailPath(channelID string) (string, bool) {
 	return "", false
 }
 
-// InvalidateThumbnail removes a thumbnail from cache
+// InvalidateThumbnail removes every variant of a thumbnail and its animated
+// preview from cache
 func (ts *ThumbnailService) InvalidateThumbnail(channelID string) {
-	cacheKey := ts.generateCacheKey(channelID)
+	prefix := ts.generateCacheKey(channelID)
 
 	ts.mu.Lock()
 	defer ts.mu.Unlock()
 
-	if info, exists := ts.cache[cacheKey]; exists {
-		os.Remove(info.FilePath)
-		delete(ts.cache, cacheKey)
+	for cacheKey, info := range ts.cache {
+		if strings.HasPrefix(cacheKey, prefix) {
+			os.Remove(info.FilePath)
+			delete(ts.cache, cacheKey)
+			ts.dirty = true
+		}
 	}
 }
 
-// cleanupLoop periodically removes expired thumbnails
+// cleanupLoop periodically removes expired thumbnails, every TTL as it
+// is when the previous cleanup ran
 func (ts *ThumbnailService) cleanupLoop() {
-	ticker := time.NewTicker(ts.cacheTTL)
+	ticker := time.NewTicker(ts.current().cacheTTL)
 	defer ticker.Stop()
 
 	for range ticker.C {
 		ts.cleanup()
+		if err := ts.saveIndex(); err != nil {
+			ts.logger.Warn("failed to save thumbnail index", "error", err)
+		}
+		ticker.Reset(ts.current().cacheTTL)
 	}
 }
 
 // cleanup removes expired thumbnails from cache and disk
 func (ts *ThumbnailService) cleanup() {
+	ttl := ts.current().cacheTTL
+
 	ts.mu.Lock()
 	defer ts.mu.Unlock()
 
@@ -259,7 +459,7 @@ func (ts *ThumbnailService) cleanup() {
 	expiredKeys := make([]string, 0)
 
 	for key, info := range ts.cache {
-		if now.Sub(info.GeneratedAt) > ts.cacheTTL*2 {
+		if now.Sub(info.GeneratedAt) > ttl*2 {
 			// Remove file
 			os.Remove(info.FilePath)
 			expiredKeys = append(expiredKeys, key)
@@ -268,15 +468,18 @@ func (ts *ThumbnailService) cleanup() {
 
 	for _, key := range expiredKeys {
 		delete(ts.cache, key)
+		ts.dirty = true
 	}
 
 	if len(expiredKeys) > 0 {
-		log.Printf("Cleaned up %d expired thumbnails", len(expiredKeys))
+		ts.logger.Info("cleaned up expired thumbnails", "count", len(expiredKeys))
 	}
 }
 
 // GetCacheStats returns statistics about the thumbnail cache
 func (ts *ThumbnailService) GetCacheStats() map[string]interface{} {
+	opts := ts.current()
+
 	ts.mu.RLock()
 	defer ts.mu.RUnlock()
 
@@ -284,19 +487,38 @@ func (ts *ThumbnailService) GetCacheStats() map[string]interface{} {
 	for _, info := range ts.cache {
 		totalSize += info.Size
 	}
+	running, queued := ts.queue.stats()
 
 	return map[string]interface{}{
+		"generating":   running,
+		"queued":       queued,
 		"cached_count": len(ts.cache),
 		"total_size":   totalSize,
+		"max_size":     opts.maxCacheSize,
 		"cache_dir":    ts.cacheDir,
-		"cache_ttl":    ts.cacheTTL.String(),
+		"cache_ttl":    opts.cacheTTL.String(),
 	}
 }
 
 // BatchGenerate generates thumbnails for multiple channels concurrently
 func (ts *ThumbnailService) BatchGenerate(channels map[string]string, concurrency int) map[string]*ThumbnailInfo {
+	return ts.BatchGenerateContext(context.Background(), channels, concurrency, nil)
+}
+
+// BatchProgress counts the channels of a batch by outcome
+type BatchProgress struct {
+	Total   int `json:"total"`
+	Done    int `json:"done"`
+	Failed  int `json:"failed"`
+	Pending int `json:"pending"`
+}
+
+// BatchGenerateContext generates thumbnails for multiple channels concurrently,
+// reporting progress after each channel and stopping early when ctx is cancelled
+func (ts *ThumbnailService) BatchGenerateContext(ctx context.Context, channels map[string]string, concurrency int, progress func(BatchProgress)) map[string]*ThumbnailInfo {
 	results := make(map[string]*ThumbnailInfo)
 	resultsMu := sync.Mutex{}
+	counts := BatchProgress{Total: len(channels), Pending: len(channels)}
 
 	// Create a semaphore channel to limit concurrency
 	sem := make(chan struct{}, concurrency)
@@ -309,12 +531,24 @@ func (ts *ThumbnailService) BatchGenerate(channels map[string]string, concurrenc
 			sem <- struct{}{}        // Acquire
 			defer func() { <-sem }() // Release
 
-			info, err := ts.GetThumbnail(cID, sURL)
+			// Channels skipped after cancellation stay pending
+			if ctx.Err() != nil {
+				return
+			}
+			info, err := ts.GetThumbnail(ctx, cID, sURL)
+
+			resultsMu.Lock()
+			counts.Pending--
 			if err == nil {
-				resultsMu.Lock()
 				results[cID] = info
-				resultsMu.Unlock()
+				counts.Done++
+			} else {
+				counts.Failed++
+			}
+			if progress != nil {
+				progress(counts)
 			}
+			resultsMu.Unlock()
 		}(channelID, streamURL)
 	}
 
@@ -0,0 +1,111 @@
+package thumbnail
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Thumbnail image formats
+const (
+	FormatJPEG = "jpeg"
+	FormatWebP = "webp"
+	FormatAVIF = "avif" // Smallest, but slow to encode: only served when asked for
+)
+
+// Sizes are the thumbnail sizes that can be requested, by name
+var Sizes = map[string][2]int{
+	"small":  {160, 90},  // Mobile grids
+	"medium": {320, 180}, // Default
+	"large":  {640, 360}, // TV interfaces
+}
+
+// ErrInvalidVariant is returned for an unknown size or format
+var ErrInvalidVariant = errors.New("invalid thumbnail variant")
+
+// Variant is a size and format a thumbnail is encoded in
+type Variant struct {
+	Width  int
+	Height int
+	Format string
+}
+
+// DefaultVariant returns the variant served when nothing else is asked for
+func (ts *ThumbnailService) DefaultVariant() Variant {
+	opts := ts.current()
+	return Variant{Width: opts.maxWidth, Height: opts.maxHeight, Format: FormatJPEG}
+}
+
+// ParseVariant picks the variant for a request. size is a name from Sizes
+// or WIDTHxHEIGHT of one of them, format is jpeg, webp or avif; either may
+// be empty. Without a format, WebP is used when accept lists it.
+func (ts *ThumbnailService) ParseVariant(size, format, accept string) (Variant, error) {
+	variant := ts.DefaultVariant()
+
+	if size != "" {
+		dims, ok := Sizes[size]
+		if !ok {
+			for _, d := range Sizes {
+				if size == strconv.Itoa(d[0])+"x"+strconv.Itoa(d[1]) {
+					dims, ok = d, true
+				}
+			}
+		}
+		if !ok {
+			return Variant{}, fmt.Errorf("%w: size %q", ErrInvalidVariant, size)
+		}
+		variant.Width, variant.Height = dims[0], dims[1]
+	}
+
+	switch strings.ToLower(format) {
+	case "":
+		if strings.Contains(accept, "image/webp") {
+			variant.Format = FormatWebP
+		}
+	case FormatJPEG, "jpg":
+		variant.Format = FormatJPEG
+	case FormatWebP:
+		variant.Format = FormatWebP
+	case FormatAVIF:
+		variant.Format = FormatAVIF
+	default:
+		return Variant{}, fmt.Errorf("%w: format %q", ErrInvalidVariant, format)
+	}
+
+	return variant, nil
+}
+
+// ext is the file extension of the variant's format
+func (v Variant) ext() string {
+	if v.Format == FormatJPEG {
+		return "jpg"
+	}
+	return v.Format
+}
+
+// variantCacheKey keys a variant of a channel's thumbnail. The default
+// variant keeps the plain channel key.
+func (ts *ThumbnailService) variantCacheKey(channelID string, v Variant) string {
+	key := ts.generateCacheKey(channelID)
+	if v == ts.DefaultVariant() {
+		return key
+	}
+	return fmt.Sprintf("%s-%dx%d-%s", key, v.Width, v.Height, v.Format)
+}
+
+// encoderArgs returns the ffmpeg options encoding a still image in the
+// variant's format, at the configured quality
+func (ts *ThumbnailService) encoderArgs(v Variant) []string {
+	quality := ts.current().quality
+	switch v.Format {
+	case FormatWebP:
+		return []string{"-c:v", "libwebp", "-quality", strconv.Itoa(quality)}
+	case FormatAVIF:
+		// CRF 63 is the worst quality, 0 lossless
+		crf := 63 - quality*45/100
+		return []string{"-c:v", "libaom-av1", "-still-picture", "1", "-crf", strconv.Itoa(crf), "-cpu-used", "8"}
+	default:
+		return []string{"-q:v", fmt.Sprintf("%d", 31-((quality*29)/100))} // Convert quality to ffmpeg scale
+	}
+}
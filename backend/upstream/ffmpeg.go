@@ -0,0 +1,61 @@
+package upstream
+
+import (
+	"strconv"
+	"time"
+)
+
+// InputOptions are the ffmpeg options every input is read with, so a brief
+// hiccup of a live stream doesn't end the process
+type InputOptions struct {
+	// Reconnect after a dropped connection, including for live streams,
+	// waiting up to ReconnectDelayMax between attempts
+	Reconnect         bool
+	ReconnectDelayMax time.Duration
+
+	// RWTimeout ends reads and writes stalled for that long, 0 waits forever
+	RWTimeout time.Duration
+
+	// How much of an input is read to detect its streams, ffmpeg's defaults
+	// when 0
+	AnalyzeDuration time.Duration
+	ProbeSize       int64 // Bytes
+}
+
+// DefaultInputOptions reconnects live streams and gives up on stalled ones
+func DefaultInputOptions() InputOptions {
+	return InputOptions{
+		Reconnect:         true,
+		ReconnectDelayMax: 10 * time.Second,
+		RWTimeout:         15 * time.Second,
+	}
+}
+
+// Args returns the options to put before "-i" for an input. Reconnect and
+// timeout options are only given for HTTP inputs: ffmpeg refuses options
+// no protocol of the input reads.
+func (o InputOptions) Args(input string) []string {
+	var args []string
+
+	if isHTTP(input) {
+		if o.Reconnect {
+			args = append(args,
+				"-reconnect", "1",
+				"-reconnect_streamed", "1",
+				"-reconnect_delay_max", strconv.Itoa(int(o.ReconnectDelayMax/time.Second)),
+			)
+		}
+		if o.RWTimeout > 0 {
+			args = append(args, "-rw_timeout", strconv.FormatInt(o.RWTimeout.Microseconds(), 10))
+		}
+	}
+
+	if o.AnalyzeDuration > 0 {
+		args = append(args, "-analyzeduration", strconv.FormatInt(o.AnalyzeDuration.Microseconds(), 10))
+	}
+	if o.ProbeSize > 0 {
+		args = append(args, "-probesize", strconv.FormatInt(o.ProbeSize, 10))
+	}
+
+	return args
+}
@@ -0,0 +1,281 @@
+// Package upstream holds the HTTP headers providers require, set per
+// playlist (user agent, referrer and extra headers), and applies them to
+// proxied requests and ffmpeg inputs, along with the options ffmpeg reads
+// every input with.
+package upstream
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+)
+
+// cacheTTL is how long resolved headers are reused; saving a playlist
+// clears the cache
+const cacheTTL = time.Minute
+
+// maxCached bounds the cache, which starts over when full
+const maxCached = 50000
+
+// Headers the proxy and ffmpeg set themselves
+var reservedHeaders = map[string]bool{
+	"Host":              true,
+	"Content-Length":    true,
+	"Transfer-Encoding": true,
+	"Connection":        true,
+	"Range":             true,
+	"User-Agent":        true, // user_agent field
+	"Referer":           true, // referrer field
+}
+
+var (
+	ErrHeaderName    = errors.New("invalid header name")
+	ErrHeaderValue   = errors.New("header values can't contain line breaks")
+	ErrHeaderURL     = errors.New("referrer must be an absolute URL")
+	ErrHeadersObject = errors.New("headers must be an object of header names to string values")
+)
+
+// Headers are the request headers of a provider
+type Headers struct {
+	UserAgent string            `json:"user_agent,omitempty"`
+	Referrer  string            `json:"referrer,omitempty"`
+	Extra     map[string]string `json:"headers,omitempty"`
+}
+
+// FromPlaylist reads the headers of a playlist record
+func FromPlaylist(playlist *models.Record) (Headers, error) {
+	h := Headers{
+		UserAgent: strings.TrimSpace(playlist.GetString("user_agent")),
+		Referrer:  strings.TrimSpace(playlist.GetString("referrer")),
+	}
+
+	if raw := playlist.GetString("headers"); raw != "" && raw != "null" {
+		extra := map[string]string{}
+		if err := json.Unmarshal([]byte(raw), &extra); err != nil {
+			return h, ErrHeadersObject
+		}
+		for name, value := range extra {
+			if h.Extra == nil {
+				h.Extra = make(map[string]string, len(extra))
+			}
+			h.Extra[http.CanonicalHeaderKey(strings.TrimSpace(name))] = strings.TrimSpace(value)
+		}
+	}
+
+	return h, h.Validate()
+}
+
+// Validate rejects headers that could inject others
+func (h Headers) Validate() error {
+	if strings.ContainsAny(h.UserAgent, "\r\n") || strings.ContainsAny(h.Referrer, "\r\n") {
+		return ErrHeaderValue
+	}
+	if h.Referrer != "" {
+		if u, err := url.Parse(h.Referrer); err != nil || u.Host == "" {
+			return ErrHeaderURL
+		}
+	}
+	for name, value := range h.Extra {
+		if !validName(name) || reservedHeaders[name] {
+			return fmt.Errorf("%w: %q", ErrHeaderName, name)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return ErrHeaderValue
+		}
+	}
+	return nil
+}
+
+// validName reports whether name is an HTTP header token
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r > 127 || !(r == '-' || r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// Empty reports whether the provider needs no headers
+func (h Headers) Empty() bool {
+	return h.UserAgent == "" && h.Referrer == "" && len(h.Extra) == 0
+}
+
+// Apply sets the headers on an upstream request
+func (h Headers) Apply(req *http.Request) {
+	if h.UserAgent != "" {
+		req.Header.Set("User-Agent", h.UserAgent)
+	}
+	if h.Referrer != "" {
+		req.Header.Set("Referer", h.Referrer)
+	}
+	for name, value := range h.Extra {
+		req.Header.Set(name, value)
+	}
+}
+
+// FFmpegArgs returns the options to put before "-i" for an input, none for
+// inputs that aren't HTTP URLs: ffmpeg rejects them for other protocols
+func (h Headers) FFmpegArgs(input string) []string {
+	if h.Empty() || !isHTTP(input) {
+		return nil
+	}
+
+	args := make([]string, 0, 6)
+	if h.UserAgent != "" {
+		args = append(args, "-user_agent", h.UserAgent)
+	}
+	if h.Referrer != "" {
+		args = append(args, "-referer", h.Referrer)
+	}
+	if len(h.Extra) > 0 {
+		names := make([]string, 0, len(h.Extra))
+		for name := range h.Extra {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		var lines strings.Builder
+		for _, name := range names {
+			lines.WriteString(name + ": " + h.Extra[name] + "\r\n")
+		}
+		args = append(args, "-headers", lines.String())
+	}
+	return args
+}
+
+func isHTTP(input string) bool {
+	lower := strings.ToLower(input)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
+
+type cached struct {
+	headers Headers
+	expires time.Time
+}
+
+// Resolver finds the headers of the provider of a channel or stream URL
+type Resolver struct {
+	app core.App
+
+	mu    sync.Mutex
+	cache map[string]cached // By playlist ID, "channel:" + channel ID and "url:" + stream URL
+	input InputOptions
+}
+
+// NewResolver returns a resolver reading the playlists of app, giving
+// ffmpeg the default input options
+func NewResolver(app core.App) *Resolver {
+	return &Resolver{app: app, cache: make(map[string]cached), input: DefaultInputOptions()}
+}
+
+// SetInputOptions sets the options ffmpeg reads every input with
+func (r *Resolver) SetInputOptions(options InputOptions) {
+	r.mu.Lock()
+	r.input = options
+	r.mu.Unlock()
+}
+
+// Invalidate forgets resolved headers, after a playlist changed
+func (r *Resolver) Invalidate() {
+	r.mu.Lock()
+	r.cache = make(map[string]cached)
+	r.mu.Unlock()
+}
+
+func (r *Resolver) lookup(key string, resolve func() Headers) Headers {
+	r.mu.Lock()
+	entry, ok := r.cache[key]
+	r.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.headers
+	}
+
+	headers := resolve()
+
+	r.mu.Lock()
+	if len(r.cache) >= maxCached {
+		r.cache = make(map[string]cached)
+	}
+	r.cache[key] = cached{headers: headers, expires: time.Now().Add(cacheTTL)}
+	r.mu.Unlock()
+	return headers
+}
+
+// ForPlaylist returns the headers of a playlist, none when it has invalid
+// ones
+func (r *Resolver) ForPlaylist(playlistID string) Headers {
+	return r.lookup(playlistID, func() Headers {
+		playlist, err := r.app.Dao().FindRecordById("playlists", playlistID)
+		if err != nil {
+			return Headers{}
+		}
+		headers, err := FromPlaylist(playlist)
+		if err != nil {
+			return Headers{}
+		}
+		return headers
+	})
+}
+
+// ForChannel returns the headers of the first playlist of a channel that
+// has some
+func (r *Resolver) ForChannel(channel *models.Record) Headers {
+	// Relation fields may be stored as arrays
+	for _, playlistID := range channel.GetStringSlice("playlist") {
+		if headers := r.ForPlaylist(playlistID); !headers.Empty() {
+			return headers
+		}
+	}
+	return Headers{}
+}
+
+// ForChannelID returns the headers of a channel by its ID
+func (r *Resolver) ForChannelID(channelID string) Headers {
+	return r.lookup("channel:"+channelID, func() Headers {
+		channel, err := r.app.Dao().FindRecordById("channels", channelID)
+		if err != nil {
+			return Headers{}
+		}
+		return r.ForChannel(channel)
+	})
+}
+
+// ForURL returns the headers of the provider of a channel's stream URL,
+// none for other URLs such as the proxy's playback URLs
+func (r *Resolver) ForURL(streamURL string) Headers {
+	if !isHTTP(streamURL) {
+		return Headers{}
+	}
+	return r.lookup("url:"+streamURL, func() Headers {
+		channels, err := r.app.Dao().FindRecordsByFilter("channels", "url = {:url}", "", 1, 0,
+			dbx.Params{"url": streamURL})
+		if err != nil || len(channels) == 0 {
+			return Headers{}
+		}
+		return r.ForChannel(channels[0])
+	})
+}
+
+// FFmpegArgs returns the ffmpeg options for an input: the input options,
+// then the headers of its provider
+func (r *Resolver) FFmpegArgs(input string) []string {
+	r.mu.Lock()
+	options := r.input
+	r.mu.Unlock()
+
+	return append(options.Args(input), r.ForURL(input).FFmpegArgs(input)...)
+}
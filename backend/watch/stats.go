@@ -0,0 +1,270 @@
+package watch
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+const (
+	// StatsCacheTTL is how long computed statistics are served from memory
+	StatsCacheTTL = 5 * time.Minute
+
+	// TopLimit is the number of channels and groups ranked
+	TopLimit = 10
+)
+
+// Periods statistics can be computed over, ending now
+var Periods = map[string]time.Duration{
+	"day":   24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"month": 30 * 24 * time.Hour,
+	"year":  365 * 24 * time.Hour,
+}
+
+var ErrPeriod = errors.New("period must be day, week, month or year")
+
+// StatsQuery selects the statistics of a user
+type StatsQuery struct {
+	User    string
+	Period  string
+	Profile string // Empty for all the user's profiles
+
+	// Location the heatmap hours are in
+	Location *time.Location
+}
+
+// ProfileStats is the time a profile spent watching
+type ProfileStats struct {
+	ID    string  `json:"id"`
+	Name  string  `json:"name"`
+	Hours float64 `json:"hours"`
+}
+
+// ChannelStats is the time spent on a channel, its recordings included
+type ChannelStats struct {
+	ID    string  `json:"id"`
+	Name  string  `json:"name"`
+	Group string  `json:"group"`
+	Hours float64 `json:"hours"`
+}
+
+// GroupStats is the time spent on the channels of a group
+type GroupStats struct {
+	Group string  `json:"group"`
+	Hours float64 `json:"hours"`
+}
+
+// Stats are the viewing statistics of a user over a period
+type Stats struct {
+	Period      string         `json:"period"`
+	From        types.DateTime `json:"from"`
+	To          types.DateTime `json:"to"`
+	Timezone    string         `json:"timezone"`
+	TotalHours  float64        `json:"total_hours"`
+	Profiles    []ProfileStats `json:"profiles"`
+	TopChannels []ChannelStats `json:"top_channels"`
+	TopGroups   []GroupStats   `json:"top_groups"`
+
+	// Heatmap is the hours watched by hour of day, 0 to 23
+	Heatmap [24]float64 `json:"heatmap"`
+}
+
+type cachedStats struct {
+	stats   *Stats
+	expires time.Time
+}
+
+// timeRow is a watch_time entry with the channel it belongs to, directly
+// or through its recording
+type timeRow struct {
+	Profile string  `db:"profile"`
+	Hour    string  `db:"hour"`
+	Seconds float64 `db:"seconds"`
+	Channel string  `db:"channel_id"`
+	Name    string  `db:"channel_name"`
+	Group   string  `db:"group_title"`
+}
+
+// Stats returns the viewing statistics of a user, computed at most once
+// per StatsCacheTTL for the same query
+func (s *Service) Stats(query StatsQuery) (*Stats, error) {
+	length, ok := Periods[query.Period]
+	if !ok {
+		return nil, ErrPeriod
+	}
+	if query.Location == nil {
+		query.Location = time.UTC
+	}
+
+	cacheKey := strings.Join([]string{query.User, query.Period, query.Profile, query.Location.String()}, "|")
+	now := time.Now()
+
+	s.statsMu.Lock()
+	if cached, ok := s.statsCache[cacheKey]; ok && now.Before(cached.expires) {
+		s.statsMu.Unlock()
+		return cached.stats, nil
+	}
+	s.statsMu.Unlock()
+
+	stats, err := s.computeStats(query, now.Add(-length), now)
+	if err != nil {
+		return nil, err
+	}
+
+	s.statsMu.Lock()
+	for key, cached := range s.statsCache {
+		if now.After(cached.expires) {
+			delete(s.statsCache, key)
+		}
+	}
+	s.statsCache[cacheKey] = cachedStats{stats: stats, expires: now.Add(StatsCacheTTL)}
+	s.statsMu.Unlock()
+
+	return stats, nil
+}
+
+func (s *Service) computeStats(query StatsQuery, from, to time.Time) (*Stats, error) {
+	dao := s.app.Dao()
+
+	profiles, err := dao.FindRecordsByFilter("profiles", "user ~ {:user}", "created", 0, 0,
+		dbx.Params{"user": query.User})
+	if err != nil {
+		return nil, err
+	}
+	if query.Profile != "" {
+		profiles = filterProfiles(profiles, query.Profile)
+	}
+
+	stats := &Stats{
+		Period:      query.Period,
+		Timezone:    query.Location.String(),
+		Profiles:    make([]ProfileStats, 0, len(profiles)),
+		TopChannels: []ChannelStats{},
+		TopGroups:   []GroupStats{},
+	}
+	stats.From, _ = types.ParseDateTime(from)
+	stats.To, _ = types.ParseDateTime(to)
+
+	if len(profiles) == 0 {
+		return stats, nil
+	}
+
+	ids := make([]interface{}, len(profiles))
+	for i, profile := range profiles {
+		ids[i] = profile.Id
+	}
+
+	// Hours are stored truncated, include the one the period starts in
+	start, _ := types.ParseDateTime(from.UTC().Truncate(time.Hour))
+
+	rows := []timeRow{}
+	err = dao.DB().
+		Select(
+			"wt.profile",
+			"wt.hour",
+			"wt.seconds",
+			"COALESCE(c.id, '') AS channel_id",
+			"COALESCE(c.name, '') AS channel_name",
+			"COALESCE(c.group_title, '') AS group_title",
+		).
+		From(TimeCollection+" wt").
+		LeftJoin("recordings r", dbx.NewExp("r.id = wt.recording")).
+		LeftJoin("channels c", dbx.NewExp("c.id = (CASE WHEN wt.channel != '' THEN wt.channel ELSE r.channel END)")).
+		Where(dbx.In("wt.profile", ids...)).
+		AndWhere(dbx.NewExp("wt.hour >= {:from}", dbx.Params{"from": start.String()})).
+		All(&rows)
+	if err != nil {
+		return nil, err
+	}
+
+	byProfile := make(map[string]float64)
+	byChannel := make(map[string]*ChannelStats)
+	byGroup := make(map[string]float64)
+
+	for _, row := range rows {
+		hours := row.Seconds / 3600
+		stats.TotalHours += hours
+		byProfile[row.Profile] += hours
+
+		if hour, err := types.ParseDateTime(row.Hour); err == nil {
+			stats.Heatmap[hour.Time().In(query.Location).Hour()] += hours
+		}
+
+		if row.Channel == "" {
+			continue // Recording of a deleted channel
+		}
+		if entry, ok := byChannel[row.Channel]; ok {
+			entry.Hours += hours
+		} else {
+			byChannel[row.Channel] = &ChannelStats{ID: row.Channel, Name: row.Name, Group: row.Group, Hours: hours}
+		}
+		if row.Group != "" {
+			byGroup[row.Group] += hours
+		}
+	}
+
+	for _, profile := range profiles {
+		stats.Profiles = append(stats.Profiles, ProfileStats{
+			ID:    profile.Id,
+			Name:  profile.GetString("name"),
+			Hours: round(byProfile[profile.Id]),
+		})
+	}
+
+	for _, entry := range byChannel {
+		entry.Hours = round(entry.Hours)
+		stats.TopChannels = append(stats.TopChannels, *entry)
+	}
+	sort.Slice(stats.TopChannels, func(i, j int) bool {
+		a, b := stats.TopChannels[i], stats.TopChannels[j]
+		if a.Hours != b.Hours {
+			return a.Hours > b.Hours
+		}
+		return a.Name < b.Name
+	})
+	if len(stats.TopChannels) > TopLimit {
+		stats.TopChannels = stats.TopChannels[:TopLimit]
+	}
+
+	for group, hours := range byGroup {
+		stats.TopGroups = append(stats.TopGroups, GroupStats{Group: group, Hours: round(hours)})
+	}
+	sort.Slice(stats.TopGroups, func(i, j int) bool {
+		a, b := stats.TopGroups[i], stats.TopGroups[j]
+		if a.Hours != b.Hours {
+			return a.Hours > b.Hours
+		}
+		return a.Group < b.Group
+	})
+	if len(stats.TopGroups) > TopLimit {
+		stats.TopGroups = stats.TopGroups[:TopLimit]
+	}
+
+	stats.TotalHours = round(stats.TotalHours)
+	for i := range stats.Heatmap {
+		stats.Heatmap[i] = round(stats.Heatmap[i])
+	}
+
+	return stats, nil
+}
+
+func filterProfiles(profiles []*models.Record, id string) []*models.Record {
+	for _, profile := range profiles {
+		if profile.Id == id {
+			return []*models.Record{profile}
+		}
+	}
+	return nil
+}
+
+// round keeps two decimals of hours
+func round(hours float64) float64 {
+	return math.Round(hours*100) / 100
+}
@@ -0,0 +1,451 @@
+// Package watch keeps the playback position of channels and recordings per
+// profile in watch_history, so playback can be resumed. Players report
+// their position every few seconds; updates are held in memory and written
+// once per FlushInterval so the database sees one write per item instead
+// of one per report. The time between reports is added up by hour in
+// watch_time for viewing statistics.
+package watch
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/types"
+
+	"iptv-backend/logging"
+)
+
+// Collections holding the watch history and the time spent watching
+const (
+	Collection     = "watch_history"
+	TimeCollection = "watch_time"
+)
+
+const (
+	// FlushInterval is how often pending positions are written
+	FlushInterval = 10 * time.Second
+
+	// MinResumePosition is how far into an item playback must have gone
+	// for it to be resumable
+	MinResumePosition = 30.0
+
+	// FinishedRatio is the share of an item after which it counts as
+	// watched and is no longer offered to resume
+	FinishedRatio = 0.95
+
+	// MaxReportGap is the longest time between two reports of an item
+	// counted as watched; longer gaps mean playback stopped in between
+	MaxReportGap = time.Minute
+
+	// DefaultContinueLimit and MaxContinueLimit bound the continue list
+	DefaultContinueLimit = 20
+	MaxContinueLimit     = 100
+)
+
+var (
+	ErrNoItem   = errors.New("a channel or a recording is required")
+	ErrTwoItems = errors.New("send either a channel or a recording, not both")
+	ErrPosition = errors.New("position and duration must not be negative")
+)
+
+// Progress is a playback position reported by a player. Position and
+// Duration are in seconds; Duration is 0 for live channels without a known
+// length.
+type Progress struct {
+	Profile   string  `json:"profile"`
+	Channel   string  `json:"channel,omitempty"`
+	Recording string  `json:"recording,omitempty"`
+	Position  float64 `json:"position"`
+	Duration  float64 `json:"duration"`
+}
+
+// Validate checks that a report is for exactly one item
+func (p Progress) Validate() error {
+	if p.Channel == "" && p.Recording == "" {
+		return ErrNoItem
+	}
+	if p.Channel != "" && p.Recording != "" {
+		return ErrTwoItems
+	}
+	if p.Position < 0 || p.Duration < 0 {
+		return ErrPosition
+	}
+	return nil
+}
+
+// key identifies the item of a report for a profile
+func (p Progress) key() string {
+	if p.Recording != "" {
+		return p.Profile + "/recording/" + p.Recording
+	}
+	return p.Profile + "/channel/" + p.Channel
+}
+
+// pending is a report not yet written
+type pending struct {
+	progress Progress
+	at       time.Time
+}
+
+// lastReport is the previous report of an item, to measure the time
+// watched until the next one
+type lastReport struct {
+	at       time.Time
+	position float64
+}
+
+// spent is the time watched of an item during an hour, not yet written
+type spent struct {
+	progress Progress
+	hour     time.Time
+	seconds  float64
+}
+
+// Item is an entry of the continue watching list
+type Item struct {
+	ID        string         `json:"id"`
+	Channel   *models.Record `json:"channel,omitempty"`
+	Recording *models.Record `json:"recording,omitempty"`
+	Position  float64        `json:"position"`
+	Duration  float64        `json:"duration"`
+	Progress  float64        `json:"progress"`
+	WatchedAt types.DateTime `json:"watched_at"`
+}
+
+// Service records playback positions
+type Service struct {
+	app    core.App
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	pending map[string]pending
+	last    map[string]lastReport
+	spent   map[string]*spent
+
+	// saveMu serializes flushes so entries of an item are upserted once
+	saveMu sync.Mutex
+
+	statsMu    sync.Mutex
+	statsCache map[string]cachedStats
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewService returns a watch progress service
+func NewService(app core.App) *Service {
+	return &Service{
+		app:        app,
+		logger:     logging.For("watch"),
+		pending:    make(map[string]pending),
+		last:       make(map[string]lastReport),
+		spent:      make(map[string]*spent),
+		statsCache: make(map[string]cachedStats),
+	}
+}
+
+// Start writes pending positions every FlushInterval
+func (s *Service) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stop != nil {
+		return
+	}
+
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go s.loop(s.stop, s.done)
+}
+
+// Stop stops the flush loop and writes what is pending
+func (s *Service) Stop() {
+	s.mu.Lock()
+	stop, done := s.stop, s.done
+	s.stop, s.done = nil, nil
+	s.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+	s.Flush()
+}
+
+func (s *Service) loop(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.Flush()
+		}
+	}
+}
+
+// Update queues a position, replacing the one pending for the same item.
+// The time since the previous report of the item counts as watched unless
+// the position did not move (playback paused).
+func (s *Service) Update(progress Progress) error {
+	if err := progress.Validate(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	key := progress.key()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if last, ok := s.last[key]; ok && progress.Position != last.position {
+		if elapsed := now.Sub(last.at); elapsed > 0 && elapsed <= MaxReportGap {
+			s.addSpent(progress, last.at, now)
+		}
+	}
+	s.last[key] = lastReport{at: now, position: progress.Position}
+	s.pending[key] = pending{progress: progress, at: now}
+	return nil
+}
+
+// addSpent adds the time between from and to to the hours it spans, s.mu
+// must be held
+func (s *Service) addSpent(progress Progress, from, to time.Time) {
+	for from.Before(to) {
+		hour := from.UTC().Truncate(time.Hour)
+		end := hour.Add(time.Hour)
+		if end.After(to) {
+			end = to
+		}
+
+		key := progress.key() + "@" + hour.Format(time.RFC3339)
+		if entry, ok := s.spent[key]; ok {
+			entry.seconds += end.Sub(from).Seconds()
+		} else {
+			s.spent[key] = &spent{progress: progress, hour: hour, seconds: end.Sub(from).Seconds()}
+		}
+		from = end
+	}
+}
+
+// Flush writes all pending positions
+func (s *Service) Flush() {
+	s.flush(func(Progress) bool { return true })
+}
+
+// flushProfile writes the pending positions of a profile, so a list read
+// right after a report includes it
+func (s *Service) flushProfile(profileID string) {
+	s.flush(func(p Progress) bool { return p.Profile == profileID })
+}
+
+func (s *Service) flush(match func(Progress) bool) {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.mu.Lock()
+	batch := make([]pending, 0, len(s.pending))
+	for key, p := range s.pending {
+		if match(p.progress) {
+			batch = append(batch, p)
+			delete(s.pending, key)
+		}
+	}
+
+	var times []*spent
+	for key, entry := range s.spent {
+		if match(entry.progress) {
+			times = append(times, entry)
+			delete(s.spent, key)
+		}
+	}
+
+	// Forget items that stopped playing
+	for key, last := range s.last {
+		if time.Since(last.at) > MaxReportGap {
+			delete(s.last, key)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, p := range batch {
+		if err := s.save(p); err != nil {
+			s.logger.Warn("failed to save watch progress",
+				"profile", p.progress.Profile, "item", p.progress.key(), "error", err)
+		}
+	}
+
+	for _, entry := range times {
+		if err := s.saveSpent(entry); err != nil {
+			s.logger.Warn("failed to save watch time",
+				"profile", entry.progress.Profile, "item", entry.progress.key(), "error", err)
+		}
+	}
+}
+
+// save upserts the history entry of a report
+func (s *Service) save(p pending) error {
+	dao := s.app.Dao()
+	progress := p.progress
+
+	filter := "profile ~ {:profile} && channel ~ {:item}"
+	item := progress.Channel
+	if progress.Recording != "" {
+		filter = "profile ~ {:profile} && recording = {:item}"
+		item = progress.Recording
+	}
+
+	record, err := dao.FindFirstRecordByFilter(Collection, filter,
+		dbx.Params{"profile": progress.Profile, "item": item})
+	if err != nil {
+		collection, err := dao.FindCollectionByNameOrId(Collection)
+		if err != nil {
+			return err
+		}
+		record = models.NewRecord(collection)
+		record.Set("profile", progress.Profile)
+		if progress.Recording != "" {
+			record.Set("recording", progress.Recording)
+		} else {
+			record.Set("channel", progress.Channel)
+		}
+	}
+
+	watchedAt, _ := types.ParseDateTime(p.at)
+	record.Set("watched_at", watchedAt)
+	record.Set("position", progress.Position)
+	if progress.Duration > 0 {
+		record.Set("duration", progress.Duration)
+	}
+
+	return dao.SaveRecord(record)
+}
+
+// saveSpent adds the time watched of an item during an hour to its entry
+func (s *Service) saveSpent(entry *spent) error {
+	dao := s.app.Dao()
+	progress := entry.progress
+
+	hour, err := types.ParseDateTime(entry.hour)
+	if err != nil {
+		return err
+	}
+
+	filter := "profile = {:profile} && channel = {:item} && hour = {:hour}"
+	item := progress.Channel
+	if progress.Recording != "" {
+		filter = "profile = {:profile} && recording = {:item} && hour = {:hour}"
+		item = progress.Recording
+	}
+
+	record, err := dao.FindFirstRecordByFilter(TimeCollection, filter,
+		dbx.Params{"profile": progress.Profile, "item": item, "hour": hour.String()})
+	if err != nil {
+		collection, err := dao.FindCollectionByNameOrId(TimeCollection)
+		if err != nil {
+			return err
+		}
+		record = models.NewRecord(collection)
+		record.Set("profile", progress.Profile)
+		record.Set("channel", progress.Channel)
+		record.Set("recording", progress.Recording)
+		record.Set("hour", hour)
+	}
+
+	record.Set("seconds", record.GetFloat("seconds")+entry.seconds)
+	return dao.SaveRecord(record)
+}
+
+// Resumable reports whether playback stopped partway through an item
+func Resumable(position, duration float64) bool {
+	return duration > 0 && position >= MinResumePosition && position < duration*FinishedRatio
+}
+
+// Finished reports whether playback went far enough for an item to count
+// as watched
+func Finished(position, duration float64) bool {
+	return duration > 0 && position >= duration*FinishedRatio
+}
+
+// WatchedRecordings returns the recordings a profile watched to the end
+func (s *Service) WatchedRecordings(profileID string) ([]string, error) {
+	s.flushProfile(profileID)
+
+	records, err := s.app.Dao().FindRecordsByFilter(Collection,
+		"profile ~ {:profile} && recording != '' && duration > 0", "", 0, 0,
+		dbx.Params{"profile": profileID})
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, record := range records {
+		if Finished(record.GetFloat("position"), record.GetFloat("duration")) {
+			ids = append(ids, record.GetString("recording"))
+		}
+	}
+	return ids, nil
+}
+
+// Continue returns the items a profile can resume, most recently watched
+// first. keep filters out items, such as channels hidden from the profile.
+func (s *Service) Continue(profileID string, limit int, keep func(item Item) bool) ([]Item, error) {
+	if limit < 1 || limit > MaxContinueLimit {
+		limit = DefaultContinueLimit
+	}
+
+	s.flushProfile(profileID)
+
+	records, err := s.app.Dao().FindRecordsByFilter(Collection,
+		"profile ~ {:profile} && duration > 0 && position >= {:min}",
+		"-watched_at", 0, 0,
+		dbx.Params{"profile": profileID, "min": MinResumePosition})
+	if err != nil {
+		return nil, err
+	}
+
+	if errs := s.app.Dao().ExpandRecords(records, []string{"channel", "recording"}, nil); len(errs) > 0 {
+		s.logger.Warn("failed to expand watch history", "profile", profileID, "errors", len(errs))
+	}
+
+	items := make([]Item, 0, limit)
+	for _, record := range records {
+		position, duration := record.GetFloat("position"), record.GetFloat("duration")
+		if !Resumable(position, duration) {
+			continue
+		}
+
+		item := Item{
+			ID:        record.Id,
+			Position:  position,
+			Duration:  duration,
+			Progress:  position / duration,
+			WatchedAt: record.GetDateTime("watched_at"),
+		}
+		if channels := record.ExpandedAll("channel"); len(channels) > 0 {
+			item.Channel = channels[0]
+		}
+		item.Recording = record.ExpandedOne("recording")
+		if item.Channel == nil && item.Recording == nil {
+			continue // The item was deleted
+		}
+		if keep != nil && !keep(item) {
+			continue
+		}
+
+		items = append(items, item)
+		if len(items) == limit {
+			break
+		}
+	}
+
+	return items, nil
+}
@@ -0,0 +1,300 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/types"
+
+	"iptv-backend/jobs"
+	"iptv-backend/logging"
+)
+
+// CollectionName is the PocketBase collection holding webhook subscriptions
+const CollectionName = "webhooks"
+
+// JobType is the background job type each delivery runs as, so failed
+// deliveries are retried with backoff and survive restarts
+const JobType = "webhook.delivery"
+
+// Headers sent with every delivery
+const (
+	EventHeader     = "X-StreamVault-Event"
+	DeliveryHeader  = "X-StreamVault-Delivery"
+	TimestampHeader = "X-StreamVault-Timestamp"
+	SignatureHeader = "X-StreamVault-Signature"
+)
+
+// EventType identifies a lifecycle event
+type EventType string
+
+const (
+	EventRecordingCompleted EventType = "recording.completed"
+	EventRecordingFailed    EventType = "recording.failed"
+	EventSubtitleSessionEnd EventType = "subtitle.session_ended"
+	EventPlaylistSynced     EventType = "playlist.synced"
+	EventPlaylistSyncFailed EventType = "playlist.sync_failed"
+	EventChannelDown        EventType = "channel.down"
+	EventChannelUp          EventType = "channel.up"
+	EventPing               EventType = "ping"
+)
+
+// AllEvents lists the event types webhooks can subscribe to
+var AllEvents = []EventType{
+	EventRecordingCompleted,
+	EventRecordingFailed,
+	EventSubtitleSessionEnd,
+	EventPlaylistSynced,
+	EventPlaylistSyncFailed,
+	EventChannelDown,
+	EventChannelUp,
+}
+
+// Payload is the JSON body POSTed to webhook endpoints
+type Payload struct {
+	ID    string                 `json:"id"` // Delivery ID, identical across retries
+	Event EventType              `json:"event"`
+	Time  time.Time              `json:"time"`
+	Data  map[string]interface{} `json:"data"`
+}
+
+// deliveryJob is the job payload of a single delivery
+type deliveryJob struct {
+	WebhookID string          `json:"webhook_id"`
+	Event     EventType       `json:"event"`
+	Body      json.RawMessage `json:"body"`
+}
+
+// Service dispatches lifecycle events to the users' webhooks
+type Service struct {
+	app    core.App
+	jobs   *jobs.Manager
+	client *http.Client
+	logger *slog.Logger
+}
+
+// NewService creates a webhook service and registers the delivery job type
+// on jobManager
+func NewService(app core.App, jobManager *jobs.Manager) *Service {
+	s := &Service{
+		app:    app,
+		jobs:   jobManager,
+		client: &http.Client{Timeout: 15 * time.Second},
+		logger: logging.For("webhooks"),
+	}
+
+	jobManager.Register(JobType, s.deliver, jobs.TypeOptions{
+		MaxAttempts: 5,
+		Concurrency: 4,
+		Timeout:     30 * time.Second,
+	})
+
+	return s
+}
+
+// Dispatch queues an event for every enabled webhook of the given users
+// that subscribes to it
+func (s *Service) Dispatch(event EventType, data map[string]interface{}, users ...string) {
+	for _, user := range uniqueUsers(users) {
+		records, err := s.app.Dao().FindRecordsByFilter(
+			CollectionName,
+			"enabled = true && user = {:user}",
+			"",
+			0,
+			0,
+			dbx.Params{"user": user},
+		)
+		if err != nil {
+			continue
+		}
+
+		for _, record := range records {
+			if !Wants(record, event) {
+				continue
+			}
+			if _, err := s.enqueue(record, event, data); err != nil {
+				s.logger.Warn("failed to queue webhook delivery", "webhook_id", record.Id, "event", event, "error", err)
+			}
+		}
+	}
+}
+
+// SendTest queues a ping delivery to a single webhook
+func (s *Service) SendTest(record *models.Record) (*jobs.Job, error) {
+	return s.enqueue(record, EventPing, map[string]interface{}{
+		"webhook_id": record.Id,
+		"message":    "Webhook is working.",
+	})
+}
+
+func (s *Service) enqueue(record *models.Record, event EventType, data map[string]interface{}) (*jobs.Job, error) {
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+
+	body, err := json.Marshal(Payload{
+		ID:    newDeliveryID(),
+		Event: event,
+		Time:  time.Now().UTC(),
+		Data:  data,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.jobs.Enqueue(JobType, record.GetString("user"), deliveryJob{
+		WebhookID: record.Id,
+		Event:     event,
+		Body:      body,
+	})
+}
+
+// deliver is the job handler POSTing one payload. Returning an error makes
+// the job manager retry it with backoff.
+func (s *Service) deliver(ctx context.Context, job *jobs.Job, progress jobs.ProgressFunc) (interface{}, error) {
+	var delivery deliveryJob
+	if err := job.DecodePayload(&delivery); err != nil {
+		return nil, err
+	}
+
+	record, err := s.app.Dao().FindRecordById(CollectionName, delivery.WebhookID)
+	if err != nil {
+		// Deleted since the event was queued, nothing to retry
+		return map[string]interface{}{"skipped": "webhook deleted"}, nil
+	}
+	if !record.GetBool("enabled") && delivery.Event != EventPing {
+		return map[string]interface{}{"skipped": "webhook disabled"}, nil
+	}
+
+	var payload struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(delivery.Body, &payload)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, record.GetString("url"), bytes.NewReader(delivery.Body))
+	if err != nil {
+		s.recordOutcome(record, 0, err)
+		return nil, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "StreamVault-Webhooks/1.0")
+	req.Header.Set(EventHeader, string(delivery.Event))
+	req.Header.Set(DeliveryHeader, payload.ID)
+	req.Header.Set(TimestampHeader, timestamp)
+	if secret := record.GetString("secret"); secret != "" {
+		req.Header.Set(SignatureHeader, Sign(secret, timestamp, delivery.Body))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		s.recordOutcome(record, 0, err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		err := fmt.Errorf("endpoint returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+		s.recordOutcome(record, resp.StatusCode, err)
+		return nil, err
+	}
+
+	s.recordOutcome(record, resp.StatusCode, nil)
+
+	return map[string]interface{}{
+		"webhook_id":  record.Id,
+		"delivery_id": payload.ID,
+		"status":      resp.StatusCode,
+	}, nil
+}
+
+// recordOutcome stores the result of the last delivery attempt on the webhook
+func (s *Service) recordOutcome(record *models.Record, status int, err error) {
+	if err != nil {
+		s.logger.Warn("webhook delivery failed", "webhook_id", record.Id, "status", status, "error", err)
+	}
+
+	// Reload so edits made while the request was in flight aren't overwritten
+	record, findErr := s.app.Dao().FindRecordById(CollectionName, record.Id)
+	if findErr != nil {
+		return
+	}
+
+	now, _ := types.ParseDateTime(time.Now())
+	record.Set("last_status", status)
+	record.Set("last_delivery_at", now)
+	if err != nil {
+		record.Set("last_error", err.Error())
+	} else {
+		record.Set("last_error", "")
+	}
+
+	if saveErr := s.app.Dao().SaveRecord(record); saveErr != nil {
+		s.logger.Debug("failed to save webhook delivery outcome", "webhook_id", record.Id, "error", saveErr)
+	}
+}
+
+// Wants reports whether a webhook subscribes to an event type.
+// An empty event list subscribes to everything.
+func Wants(record *models.Record, event EventType) bool {
+	if event == EventPing {
+		return true
+	}
+
+	var events []string
+	json.Unmarshal([]byte(record.GetString("events")), &events)
+	if len(events) == 0 {
+		return true
+	}
+	for _, e := range events {
+		if e == string(event) {
+			return true
+		}
+	}
+	return false
+}
+
+// Sign computes the signature header value of a delivery: the HMAC-SHA256
+// of "<timestamp>.<body>", so receivers can reject replays. Transcript
+// webhooks are signed the same way.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func uniqueUsers(users []string) []string {
+	seen := make(map[string]bool, len(users))
+	unique := make([]string, 0, len(users))
+	for _, user := range users {
+		if user != "" && !seen[user] {
+			seen[user] = true
+			unique = append(unique, user)
+		}
+	}
+	return unique
+}
+
+func newDeliveryID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
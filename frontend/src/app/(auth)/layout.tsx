@@ -1,7 +1,7 @@
 'use client';
 
 import { useEffect } from 'react';
-import { useRouter } from 'next/navigation';
+import { usePathname, useRouter } from 'next/navigation';
 import { useAuthStore } from '@/stores';
 
 export default function AuthLayout({
@@ -10,13 +10,15 @@ export default function AuthLayout({
   children: React.ReactNode;
 }) {
   const router = useRouter();
+  const pathname = usePathname();
   const { isAuthenticated, isLoading, requires2FA } = useAuthStore();
 
   useEffect(() => {
-    if (!isLoading && isAuthenticated && !requires2FA) {
+    // Signed in users come back to the SSO callback when linking an identity
+    if (!isLoading && isAuthenticated && !requires2FA && pathname !== '/sso-callback') {
       router.replace('/home');
     }
-  }, [isAuthenticated, isLoading, requires2FA, router]);
+  }, [isAuthenticated, isLoading, requires2FA, router, pathname]);
 
   return (
     <div className="min-h-screen flex items-center justify-center bg-background-primary p-4">
@@ -1,14 +1,15 @@
 'use client';
 
-import { useState } from 'react';
+import { useEffect, useState } from 'react';
 import { useRouter } from 'next/navigation';
 import Link from 'next/link';
 import { useForm } from 'react-hook-form';
 import { zodResolver } from '@hookform/resolvers/zod';
 import { z } from 'zod';
-import { Mail, Lock, Tv } from 'lucide-react';
+import { Mail, Lock, Tv, KeyRound } from 'lucide-react';
 import { Button, Input, Card, toast } from '@/components/ui';
 import { useAuthStore } from '@/stores';
+import { ssoHelpers } from '@/lib/pocketbase/client';
 
 const loginSchema = z.object({
   email: z.string().email('Please enter a valid email address'),
@@ -20,8 +21,24 @@ type LoginForm = z.infer<typeof loginSchema>;
 export default function LoginPage() {
   const router = useRouter();
   const [isLoading, setIsLoading] = useState(false);
+  const [ssoName, setSsoName] = useState<string | null>(null);
   const { login } = useAuthStore();
 
+  useEffect(() => {
+    ssoHelpers
+      .getProvider()
+      .then((provider) => setSsoName(provider?.displayName || null))
+      .catch(() => setSsoName(null));
+  }, []);
+
+  const onSSO = async () => {
+    try {
+      await ssoHelpers.start();
+    } catch (error) {
+      toast.error((error as Error).message || 'Single sign-on failed');
+    }
+  };
+
   const {
     register,
     handleSubmit,
@@ -96,6 +113,19 @@ export default function LoginPage() {
         </Button>
       </form>
 
+      {ssoName && (
+        <Button
+          variant="secondary"
+          fullWidth
+          size="lg"
+          className="mt-3"
+          leftIcon={<KeyRound className="w-5 h-5" />}
+          onClick={onSSO}
+        >
+          Sign in with {ssoName}
+        </Button>
+      )}
+
       <div className="mt-6 text-center">
         <p className="text-text-secondary">
           Don&apos;t have an account?{' '}
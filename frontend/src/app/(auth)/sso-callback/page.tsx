@@ -0,0 +1,61 @@
+'use client';
+
+import { Suspense, useEffect, useRef } from 'react';
+import { useRouter, useSearchParams } from 'next/navigation';
+import { Loader2 } from 'lucide-react';
+import { Card, toast } from '@/components/ui';
+import { useAuthStore } from '@/stores';
+
+function SSOCallback() {
+  const router = useRouter();
+  const searchParams = useSearchParams();
+  const { completeSSO } = useAuthStore();
+  const handled = useRef(false);
+
+  useEffect(() => {
+    if (handled.current) return;
+    handled.current = true;
+
+    const code = searchParams.get('code');
+    const state = searchParams.get('state');
+    if (!code || !state) {
+      toast.error(searchParams.get('error_description') || 'Single sign-on was cancelled');
+      router.replace('/login');
+      return;
+    }
+
+    completeSSO(code, state)
+      .then((outcome) => {
+        if (outcome === 'linked') {
+          toast.success('Single sign-on linked to your account');
+          router.replace('/settings/security');
+        } else if (outcome === 'authenticated') {
+          toast.success('Welcome back!');
+          router.replace('/home');
+        } else {
+          router.replace('/verify-2fa');
+        }
+      })
+      .catch((error) => {
+        toast.error((error as Error).message || 'Single sign-on failed');
+        router.replace('/login');
+      });
+  }, [completeSSO, router, searchParams]);
+
+  return (
+    <Card variant="elevated" padding="lg" className="auth-card">
+      <div className="flex flex-col items-center gap-4 py-8">
+        <Loader2 className="w-8 h-8 text-primary animate-spin" />
+        <p className="text-text-secondary">Signing you in...</p>
+      </div>
+    </Card>
+  );
+}
+
+export default function SSOCallbackPage() {
+  return (
+    <Suspense>
+      <SSOCallback />
+    </Suspense>
+  );
+}
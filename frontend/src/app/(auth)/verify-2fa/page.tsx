@@ -10,6 +10,7 @@ export default function Verify2FAPage() {
   const router = useRouter();
   const [code, setCode] = useState(['', '', '', '', '', '']);
   const [isLoading, setIsLoading] = useState(false);
+  const [remember, setRemember] = useState(false);
   const inputRefs = useRef<(HTMLInputElement | null)[]>([]);
   const { verify2FA, requires2FA, clearPending2FA } = useAuthStore();
 
@@ -61,7 +62,7 @@ export default function Verify2FAPage() {
 
     setIsLoading(true);
     try {
-      await verify2FA(fullCode);
+      await verify2FA(fullCode, remember);
       toast.success('Authentication successful!');
       router.push('/home');
     } catch (error) {
@@ -121,6 +122,18 @@ export default function Verify2FAPage() {
           ))}
         </div>
 
+        <label className="flex items-center justify-center gap-3 cursor-pointer">
+          <input
+            type="checkbox"
+            checked={remember}
+            onChange={(e) => setRemember(e.target.checked)}
+            className="w-5 h-5 rounded border-border bg-surface text-primary focus:ring-primary"
+          />
+          <span className="text-text-secondary text-sm">
+            Trust this device for 30 days
+          </span>
+        </label>
+
         <Button type="submit" fullWidth size="lg" isLoading={isLoading}>
           Verify Code
         </Button>
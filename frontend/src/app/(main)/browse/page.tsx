@@ -5,7 +5,7 @@ import { Grid, List, Filter, Search, Tv } from 'lucide-react';
 import { ChannelCard } from '@/components/features/channels';
 import { Button, Input, Select, ChannelCardSkeleton } from '@/components/ui';
 import { useChannelStore, useThumbnailStore } from '@/stores';
-import { cn } from '@/lib/utils';
+import { channelGroups, cn } from '@/lib/utils';
 
 type ViewMode = 'grid' | 'list';
 
@@ -20,6 +20,7 @@ export default function BrowsePage() {
       if (channel.group_title) {
         cats.add(channel.group_title);
       }
+      channel.virtual_groups?.forEach((group) => cats.add(group));
     });
     return ['All', ...Array.from(cats).sort()];
   }, [channels]);
@@ -38,7 +39,7 @@ export default function BrowsePage() {
     }
 
     if (selectedCategory && selectedCategory !== 'All') {
-      result = result.filter((c) => c.group_title === selectedCategory);
+      result = result.filter((c) => channelGroups(c).includes(selectedCategory));
     }
 
     return result;
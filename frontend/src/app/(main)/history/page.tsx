@@ -5,7 +5,7 @@ import { History, Tv, Trash2 } from 'lucide-react';
 import { ChannelCard } from '@/components/features/channels';
 import { Button, Spinner, ConfirmDialog } from '@/components/ui';
 import { useProfileStore } from '@/stores';
-import { collections } from '@/lib/pocketbase/client';
+import { collections, watchHelpers } from '@/lib/pocketbase/client';
 import type { Channel } from '@/types';
 import Link from 'next/link';
 
@@ -21,6 +21,17 @@ export default function HistoryPage() {
   const [isLoading, setIsLoading] = useState(true);
   const [showClearConfirm, setShowClearConfirm] = useState(false);
   const [isClearing, setIsClearing] = useState(false);
+  const [weekHours, setWeekHours] = useState<number | null>(null);
+
+  // Time watched this week, computed by the server
+  useEffect(() => {
+    if (!activeProfile?.id) return;
+
+    watchHelpers
+      .stats('week', activeProfile.id)
+      .then((stats) => setWeekHours(stats.total_hours))
+      .catch((error) => console.error('Error fetching watch statistics:', error));
+  }, [activeProfile?.id]);
 
   useEffect(() => {
     const fetchHistory = async () => {
@@ -113,6 +124,7 @@ export default function HistoryPage() {
             <h1 className="text-2xl font-bold text-text-primary">Watch History</h1>
             <p className="text-text-secondary">
               {history.length} channel{history.length !== 1 ? 's' : ''} watched
+              {weekHours !== null && ` · ${weekHours.toFixed(1)} h this week`}
             </p>
           </div>
         </div>
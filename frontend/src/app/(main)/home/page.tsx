@@ -1,17 +1,67 @@
 'use client';
 
-import { useMemo, useEffect } from 'react';
+import { useMemo, useEffect, useState } from 'react';
 import { Plus, Tv } from 'lucide-react';
 import Link from 'next/link';
 import { ChannelCarousel } from '@/components/features/channels';
 import { ChannelCard } from '@/components/features/channels';
 import { Button } from '@/components/ui';
-import { useChannelStore, useAuthStore, useThumbnailStore } from '@/stores';
+import { useChannelStore, useAuthStore, useThumbnailStore, useProfileStore } from '@/stores';
+import { epgHelpers, recommendationHelpers, watchHelpers } from '@/lib/pocketbase/client';
+import { channelGroups } from '@/lib/utils';
+import type { Channel } from '@/types';
 
 export default function HomePage() {
   const { channels, playlists, isLoading } = useChannelStore();
   const { user } = useAuthStore();
   const { fetchThumbnailsBatch } = useThumbnailStore();
+  const { activeProfile } = useProfileStore();
+  const [continueChannels, setContinueChannels] = useState<Channel[]>([]);
+  const [recommendedChannels, setRecommendedChannels] = useState<Channel[]>([]);
+  const [favoriteChannels, setFavoriteChannels] = useState<Channel[]>([]);
+
+  // Channels the active profile can resume
+  useEffect(() => {
+    if (!activeProfile?.id) {
+      setContinueChannels([]);
+      return;
+    }
+
+    watchHelpers
+      .continueWatching(activeProfile.id, 20)
+      .then((items) =>
+        setContinueChannels(
+          items.filter((item) => item.channel).map((item) => item.channel as Channel)
+        )
+      )
+      .catch((error) => console.error('Error loading continue watching:', error));
+  }, [activeProfile?.id]);
+
+  // Channels suggested from the active profile's watch history
+  useEffect(() => {
+    if (!activeProfile?.id) {
+      setRecommendedChannels([]);
+      return;
+    }
+
+    recommendationHelpers
+      .get(activeProfile.id, { limit: 20 })
+      .then((result) => setRecommendedChannels(result.channels.map((pick) => pick.channel)))
+      .catch((error) => console.error('Error loading recommendations:', error));
+  }, [activeProfile?.id]);
+
+  // Favorite channels, in the profile's order
+  useEffect(() => {
+    if (!activeProfile?.id) {
+      setFavoriteChannels([]);
+      return;
+    }
+
+    epgHelpers
+      .now(activeProfile.id)
+      .then((items) => setFavoriteChannels(items.map((item) => item.channel)))
+      .catch((error) => console.error('Error loading favorites:', error));
+  }, [activeProfile?.id]);
 
   // Preload thumbnails in parallel when channels are loaded
   useEffect(() => {
@@ -27,11 +77,12 @@ export default function HomePage() {
 
   const channelsByCategory = useMemo(() => {
     return channels.reduce((acc, channel) => {
-      const category = channel.group_title || 'Uncategorized';
-      if (!acc[category]) {
-        acc[category] = [];
-      }
-      acc[category].push(channel);
+      channelGroups(channel).forEach((category) => {
+        if (!acc[category]) {
+          acc[category] = [];
+        }
+        acc[category].push(channel);
+      });
       return acc;
     }, {} as Record<string, typeof channels>);
   }, [channels]);
@@ -76,8 +127,20 @@ export default function HomePage() {
         </section>
       )}
 
-      {/* Continue Watching - TODO: Implement with watch history */}
-      {/* <ChannelCarousel title="Continue Watching" channels={[]} /> */}
+      {/* Continue Watching */}
+      {continueChannels.length > 0 && (
+        <ChannelCarousel title="Continue Watching" channels={continueChannels} />
+      )}
+
+      {/* Favorites, on now */}
+      {favoriteChannels.length > 0 && (
+        <ChannelCarousel title="Your Favorites" channels={favoriteChannels} />
+      )}
+
+      {/* Recommended */}
+      {recommendedChannels.length > 0 && (
+        <ChannelCarousel title="Recommended for You" channels={recommendedChannels} />
+      )}
 
       {/* Categories */}
       {categories.map((category) => (
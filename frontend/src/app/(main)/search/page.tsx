@@ -1,25 +1,80 @@
 'use client';
 
-import { useState, useMemo } from 'react';
+import { useState, useEffect } from 'react';
+import Link from 'next/link';
 import { Search as SearchIcon, Tv } from 'lucide-react';
 import { ChannelCard } from '@/components/features/channels';
-import { Input } from '@/components/ui';
-import { useChannelStore } from '@/stores';
+import { Button, Input, Spinner } from '@/components/ui';
+import { searchHelpers } from '@/lib/pocketbase/client';
+import type { SearchResult } from '@/types';
+
+// Format programme times for display
+function formatTime(value: string): string {
+  return new Date(value).toLocaleTimeString('fr-FR', {
+    hour: '2-digit',
+    minute: '2-digit',
+  });
+}
 
 export default function SearchPage() {
-  const { channels } = useChannelStore();
   const [query, setQuery] = useState('');
+  const [result, setResult] = useState<SearchResult | null>(null);
+  const [isLoading, setIsLoading] = useState(false);
+  const [isLoadingMore, setIsLoadingMore] = useState(false);
+
+  // Search as the user types, once they pause
+  useEffect(() => {
+    if (!query.trim()) {
+      setResult(null);
+      return;
+    }
+
+    let cancelled = false;
+    const timer = setTimeout(() => {
+      setIsLoading(true);
+      searchHelpers
+        .search(query)
+        .then((data) => {
+          if (!cancelled) setResult(data);
+        })
+        .catch((error) => console.error('Error searching:', error))
+        .finally(() => {
+          if (!cancelled) setIsLoading(false);
+        });
+    }, 250);
+
+    return () => {
+      cancelled = true;
+      clearTimeout(timer);
+    };
+  }, [query]);
 
-  const results = useMemo(() => {
-    if (!query.trim()) return [];
-    const q = query.toLowerCase();
-    return channels.filter(
-      (c) =>
-        c.name.toLowerCase().includes(q) ||
-        c.group_title?.toLowerCase().includes(q) ||
-        c.tvg_name?.toLowerCase().includes(q)
-    );
-  }, [channels, query]);
+  // Append the next page of channels
+  const loadMoreChannels = async () => {
+    if (!result?.channels) return;
+    setIsLoadingMore(true);
+    try {
+      const next = await searchHelpers.search(query, {
+        type: 'channels',
+        page: result.channels.page + 1,
+        perPage: result.channels.perPage,
+      });
+      if (next.channels) {
+        setResult({
+          ...result,
+          channels: { ...next.channels, items: [...result.channels.items, ...next.channels.items] },
+        });
+      }
+    } catch (error) {
+      console.error('Error loading more results:', error);
+    } finally {
+      setIsLoadingMore(false);
+    }
+  };
+
+  const channels = result?.channels;
+  const programs = result?.programs;
+  const total = (channels?.totalItems ?? 0) + (programs?.totalItems ?? 0);
 
   return (
     <div className="p-4 lg:p-6">
@@ -27,7 +82,7 @@ export default function SearchPage() {
 
       <div className="max-w-2xl mb-8">
         <Input
-          placeholder="Search channels..."
+          placeholder="Search channels and programmes..."
           value={query}
           onChange={(e) => setQuery(e.target.value)}
           leftIcon={<SearchIcon className="w-5 h-5" />}
@@ -36,16 +91,69 @@ export default function SearchPage() {
       </div>
 
       {query.trim() ? (
-        results.length > 0 ? (
-          <div>
-            <p className="text-sm text-text-muted mb-4">
-              {results.length} result{results.length !== 1 ? 's' : ''} for "{query}"
-            </p>
-            <div className="grid grid-cols-2 sm:grid-cols-3 md:grid-cols-4 lg:grid-cols-5 xl:grid-cols-6 gap-4">
-              {results.map((channel) => (
-                <ChannelCard key={channel.id} channel={channel} />
-              ))}
-            </div>
+        isLoading && !result ? (
+          <div className="flex justify-center py-16">
+            <Spinner />
+          </div>
+        ) : total > 0 ? (
+          <div className="space-y-10">
+            {channels && channels.items.length > 0 && (
+              <section>
+                <p className="text-sm text-text-muted mb-4">
+                  {channels.totalItems} channel{channels.totalItems !== 1 ? 's' : ''} for "{query}"
+                </p>
+                <div className="grid grid-cols-2 sm:grid-cols-3 md:grid-cols-4 lg:grid-cols-5 xl:grid-cols-6 gap-4">
+                  {channels.items.map((channel) => (
+                    <ChannelCard key={channel.id} channel={channel} />
+                  ))}
+                </div>
+                {channels.page < channels.totalPages && (
+                  <div className="flex justify-center mt-6">
+                    <Button variant="secondary" onClick={loadMoreChannels} isLoading={isLoadingMore}>
+                      Show more channels
+                    </Button>
+                  </div>
+                )}
+              </section>
+            )}
+
+            {programs && programs.items.length > 0 && (
+              <section>
+                <p className="text-sm text-text-muted mb-4">
+                  {programs.totalItems} programme{programs.totalItems !== 1 ? 's' : ''} on now or
+                  coming up
+                </p>
+                <ul className="divide-y divide-border rounded-lg bg-surface">
+                  {programs.items.map(({ program, channel }) => {
+                    const content = (
+                      <div className="flex items-center gap-4 p-4">
+                        <span className="w-28 shrink-0 text-sm text-text-muted">
+                          {formatTime(program.start_time)} – {formatTime(program.end_time)}
+                        </span>
+                        <div className="min-w-0">
+                          <p className="font-medium text-text-primary truncate">{program.title}</p>
+                          <p className="text-sm text-text-secondary truncate">
+                            {channel?.name ?? program.channel_id}
+                            {program.category ? ` · ${program.category}` : ''}
+                          </p>
+                        </div>
+                      </div>
+                    );
+                    return (
+                      <li key={program.id}>
+                        {channel ? (
+                          <Link href={`/watch/${channel.id}`} className="block hover:bg-surface-hover">
+                            {content}
+                          </Link>
+                        ) : (
+                          content
+                        )}
+                      </li>
+                    );
+                  })}
+                </ul>
+              </section>
+            )}
           </div>
         ) : (
           <div className="flex flex-col items-center justify-center py-16">
@@ -56,7 +164,7 @@ export default function SearchPage() {
               No results found
             </h3>
             <p className="text-text-secondary">
-              No channels match "{query}"
+              No channels or programmes match "{query}"
             </p>
           </div>
         )
@@ -69,7 +177,7 @@ export default function SearchPage() {
             Search for channels
           </h3>
           <p className="text-text-secondary">
-            Enter a channel name or category to search
+            Enter a channel name, category or programme title to search
           </p>
         </div>
       )}
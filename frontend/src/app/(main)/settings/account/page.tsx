@@ -12,10 +12,11 @@ import {
   AlertTriangle,
   Check,
   X,
+  Download,
 } from 'lucide-react';
 import { Card, Button, Input, Modal, toast } from '@/components/ui';
 import { useAuthStore } from '@/stores';
-import { pb, authHelpers } from '@/lib/pocketbase/client';
+import { pb, authHelpers, accountHelpers } from '@/lib/pocketbase/client';
 import { cn } from '@/lib/utils';
 
 export default function AccountSettingsPage() {
@@ -49,8 +50,12 @@ export default function AccountSettingsPage() {
   const [showDeleteModal, setShowDeleteModal] = useState(false);
   const [deleteConfirmText, setDeleteConfirmText] = useState('');
   const [deletePassword, setDeletePassword] = useState('');
+  const [deleteCode, setDeleteCode] = useState('');
   const [isDeletingAccount, setIsDeletingAccount] = useState(false);
 
+  // Data export state
+  const [isExporting, setIsExporting] = useState(false);
+
   // Get avatar URL
   const getAvatarUrl = () => {
     if (avatarPreview) return avatarPreview;
@@ -220,13 +225,15 @@ export default function AccountSettingsPage() {
       return;
     }
 
+    if (user.totp_enabled && deleteCode.length !== 6) {
+      toast.error('Please enter your 6-digit 2FA code');
+      return;
+    }
+
     setIsDeletingAccount(true);
     try {
-      // Verify password first
-      await pb.collection('users').authWithPassword(user.email, deletePassword);
-
-      // Delete account
-      await pb.collection('users').delete(user.id);
+      // The server checks the password and removes recordings and files too
+      await accountHelpers.deleteAccount(deletePassword, deleteCode);
 
       toast.success('Account deleted successfully');
       logout();
@@ -237,6 +244,18 @@ export default function AccountSettingsPage() {
     }
   };
 
+  // Export data
+  const handleExportData = async () => {
+    setIsExporting(true);
+    try {
+      await accountHelpers.exportData();
+    } catch (error) {
+      toast.error((error as Error).message || 'Failed to export data');
+    } finally {
+      setIsExporting(false);
+    }
+  };
+
   return (
     <div className="p-4 lg:p-6 max-w-3xl mx-auto">
       <div className="flex items-center gap-4 mb-6">
@@ -448,6 +467,30 @@ export default function AccountSettingsPage() {
         </div>
       </Card>
 
+      {/* Your Data */}
+      <h2 className="text-lg font-semibold text-text-primary mb-4">Your Data</h2>
+      <Card variant="bordered" padding="md" className="mb-8">
+        <div className="flex items-center justify-between">
+          <div>
+            <h3 className="text-base font-medium text-text-primary">
+              Export Data
+            </h3>
+            <p className="text-sm text-text-secondary">
+              Download your profiles, playlists, favorites, watch history,
+              recordings list and subtitle transcripts as a zip archive.
+            </p>
+          </div>
+          <Button
+            variant="secondary"
+            onClick={handleExportData}
+            isLoading={isExporting}
+            leftIcon={<Download className="w-4 h-4" />}
+          >
+            Export
+          </Button>
+        </div>
+      </Card>
+
       {/* Danger Zone */}
       <h2 className="text-lg font-semibold text-red-500 mb-4">Danger Zone</h2>
       <Card
@@ -594,6 +637,7 @@ export default function AccountSettingsPage() {
           setShowDeleteModal(false);
           setDeleteConfirmText('');
           setDeletePassword('');
+          setDeleteCode('');
         }}
         title="Delete Account"
         description="This action is permanent and cannot be undone"
@@ -605,6 +649,7 @@ export default function AccountSettingsPage() {
                 setShowDeleteModal(false);
                 setDeleteConfirmText('');
                 setDeletePassword('');
+                setDeleteCode('');
               }}
             >
               Cancel
@@ -629,7 +674,8 @@ export default function AccountSettingsPage() {
             <ul className="text-sm text-red-400 list-disc list-inside mt-2 space-y-1">
               <li>All your playlists and channels</li>
               <li>Your watch history and favorites</li>
-              <li>All recordings and scheduled recordings</li>
+              <li>All recordings, including protected ones, and scheduled recordings</li>
+              <li>Your subtitle transcripts and exports</li>
               <li>Your profile and settings</li>
             </ul>
           </div>
@@ -646,6 +692,15 @@ export default function AccountSettingsPage() {
             onChange={(e) => setDeletePassword(e.target.value)}
             hint="Enter your password to confirm"
           />
+          {user?.totp_enabled && (
+            <Input
+              label="2FA Code"
+              value={deleteCode}
+              onChange={(e) => setDeleteCode(e.target.value.replace(/\D/g, '').slice(0, 6))}
+              placeholder="000000"
+              inputMode="numeric"
+            />
+          )}
         </div>
       </Modal>
     </div>
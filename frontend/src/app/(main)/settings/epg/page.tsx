@@ -113,20 +113,28 @@ export default function EPGSettingsPage() {
   const handleSyncSource = async (source: EPGSource) => {
     setIsSyncing(source.id);
     try {
-      // Call the sync API endpoint
-      const response = await fetch(`${pb.baseUrl}/api/epg/sync`, {
+      // Queue an import, then wait for it to finish
+      const response = await fetch(`${pb.baseUrl}/api/epg/sources/${source.id}/sync`, {
         method: 'POST',
         headers: {
-          'Content-Type': 'application/json',
           Authorization: `Bearer ${pb.authStore.token}`,
         },
-        body: JSON.stringify({ source_id: source.id }),
       });
 
       if (!response.ok) {
         throw new Error('Failed to sync EPG source');
       }
 
+      for (let i = 0; i < 120; i++) {
+        await new Promise((resolve) => setTimeout(resolve, 2000));
+        const status = await fetch(`${pb.baseUrl}/api/epg/sources/${source.id}/sync-status`, {
+          headers: {
+            Authorization: `Bearer ${pb.authStore.token}`,
+          },
+        }).then((r) => r.json());
+        if (['completed', 'failed', 'cancelled'].includes(status.job?.status)) break;
+      }
+
       // Update the last_synced timestamp locally
       const updated = await pb.collection('epg_sources').getOne(source.id);
       setSources((prev) =>
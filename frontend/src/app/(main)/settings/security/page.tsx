@@ -16,21 +16,13 @@ import {
   RefreshCw,
   Eye,
   EyeOff,
+  KeyRound,
 } from 'lucide-react';
 import { Card, Button, Input, Modal, toast } from '@/components/ui';
 import { useAuthStore } from '@/stores';
-import { pb, totpHelpers } from '@/lib/pocketbase/client';
+import { pb, totpHelpers, ssoHelpers, sessionHelpers, trustedDeviceHelpers } from '@/lib/pocketbase/client';
 import { cn } from '@/lib/utils';
-
-interface Session {
-  id: string;
-  device: string;
-  browser: string;
-  ip: string;
-  location: string;
-  lastActive: string;
-  current: boolean;
-}
+import type { Session, TrustedDevice } from '@/types';
 
 export default function SecuritySettingsPage() {
   const { user, setup2FA, disable2FA, refreshAuth } = useAuthStore();
@@ -50,11 +42,20 @@ export default function SecuritySettingsPage() {
   const [disablePassword, setDisablePassword] = useState('');
   const [isDisabling2FA, setIsDisabling2FA] = useState(false);
 
+  // Single sign-on state
+  const [ssoName, setSsoName] = useState<string | null>(null);
+  const [ssoLinked, setSsoLinked] = useState(false);
+  const [isUnlinkingSSO, setIsUnlinkingSSO] = useState(false);
+
   // Sessions state
   const [sessions, setSessions] = useState<Session[]>([]);
   const [isLoadingSessions, setIsLoadingSessions] = useState(true);
   const [isRevokingSession, setIsRevokingSession] = useState<string | null>(null);
 
+  // Trusted devices state
+  const [trustedDevices, setTrustedDevices] = useState<TrustedDevice[]>([]);
+  const [isForgettingDevice, setIsForgettingDevice] = useState<string | null>(null);
+
   // Security log state
   const [securityLogs, setSecurityLogs] = useState<Array<{
     id: string;
@@ -65,28 +66,50 @@ export default function SecuritySettingsPage() {
     status: 'success' | 'failed';
   }>>([]);
 
-  // Load sessions (mock data for now)
+  // Load the SSO provider and whether the account is linked to it
+  useEffect(() => {
+    if (!user) return;
+    ssoHelpers.getProvider().then((provider) => {
+      setSsoName(provider?.displayName || null);
+      if (provider) {
+        ssoHelpers.isLinked(user.id).then(setSsoLinked);
+      }
+    });
+  }, [user?.id]);
+
+  const handleLinkSSO = async () => {
+    try {
+      await ssoHelpers.start(true);
+    } catch (error) {
+      toast.error((error as Error).message || 'Failed to link single sign-on');
+    }
+  };
+
+  const handleUnlinkSSO = async () => {
+    if (!user) return;
+    setIsUnlinkingSSO(true);
+    try {
+      await ssoHelpers.unlink(user.id);
+      setSsoLinked(false);
+      toast.success('Single sign-on unlinked');
+    } catch (error) {
+      toast.error((error as Error).message || 'Failed to unlink single sign-on');
+    } finally {
+      setIsUnlinkingSSO(false);
+    }
+  };
+
+  // Load sessions
   useEffect(() => {
     loadSessions();
+    loadTrustedDevices();
     loadSecurityLogs();
   }, []);
 
   const loadSessions = async () => {
     setIsLoadingSessions(true);
     try {
-      // In a real app, this would fetch from an API
-      // For now, we'll show mock data
-      setSessions([
-        {
-          id: '1',
-          device: 'MacBook Pro',
-          browser: 'Chrome 120',
-          ip: '192.168.1.100',
-          location: 'Paris, France',
-          lastActive: new Date().toISOString(),
-          current: true,
-        },
-      ]);
+      setSessions(await sessionHelpers.list());
     } catch (error) {
       console.error('Failed to load sessions:', error);
     } finally {
@@ -94,6 +117,14 @@ export default function SecuritySettingsPage() {
     }
   };
 
+  const loadTrustedDevices = async () => {
+    try {
+      setTrustedDevices(await trustedDeviceHelpers.list());
+    } catch (error) {
+      console.error('Failed to load trusted devices:', error);
+    }
+  };
+
   const loadSecurityLogs = async () => {
     // Mock security logs
     setSecurityLogs([
@@ -143,7 +174,8 @@ export default function SecuritySettingsPage() {
     try {
       await totpHelpers.verify(verifyCode);
       await refreshAuth();
-      toast.success('Two-factor authentication enabled successfully');
+      await loadSessions();
+      toast.success('Two-factor authentication enabled, other sessions were signed out');
       setShow2FASetupModal(false);
       resetSetupState();
     } catch (error) {
@@ -197,12 +229,11 @@ export default function SecuritySettingsPage() {
   const handleRevokeSession = async (sessionId: string) => {
     setIsRevokingSession(sessionId);
     try {
-      // In a real app, this would call an API to revoke the session
-      await new Promise((resolve) => setTimeout(resolve, 1000));
+      await sessionHelpers.revoke(sessionId);
       setSessions(sessions.filter((s) => s.id !== sessionId));
       toast.success('Session revoked');
     } catch (error) {
-      toast.error('Failed to revoke session');
+      toast.error((error as Error).message || 'Failed to revoke session');
     } finally {
       setIsRevokingSession(null);
     }
@@ -211,12 +242,26 @@ export default function SecuritySettingsPage() {
   // Revoke all other sessions
   const handleRevokeAllSessions = async () => {
     try {
-      // In a real app, this would call an API
-      await new Promise((resolve) => setTimeout(resolve, 1000));
+      await sessionHelpers.revokeOthers();
       setSessions(sessions.filter((s) => s.current));
+      setTrustedDevices([]);
       toast.success('All other sessions revoked');
     } catch (error) {
-      toast.error('Failed to revoke sessions');
+      toast.error((error as Error).message || 'Failed to revoke sessions');
+    }
+  };
+
+  // Forget a trusted device
+  const handleForgetDevice = async (deviceId: string) => {
+    setIsForgettingDevice(deviceId);
+    try {
+      await trustedDeviceHelpers.revoke(deviceId);
+      setTrustedDevices(trustedDevices.filter((d) => d.id !== deviceId));
+      toast.success('Device will ask for a code again');
+    } catch (error) {
+      toast.error((error as Error).message || 'Failed to forget device');
+    } finally {
+      setIsForgettingDevice(null);
     }
   };
 
@@ -312,6 +357,45 @@ export default function SecuritySettingsPage() {
         </div>
       </Card>
 
+      {/* Single Sign-On */}
+      {ssoName && (
+        <>
+          <h2 className="text-lg font-semibold text-text-primary mb-4">
+            Single Sign-On
+          </h2>
+          <Card variant="bordered" padding="md" className="mb-8">
+            <div className="flex items-start gap-4">
+              <div className="w-12 h-12 rounded-lg bg-surface-hover flex items-center justify-center flex-shrink-0">
+                <KeyRound className="w-6 h-6 text-text-muted" />
+              </div>
+              <div className="flex-1 min-w-0">
+                <h3 className="text-base font-medium text-text-primary mb-1">
+                  {ssoName}
+                </h3>
+                <p className="text-sm text-text-secondary mb-4">
+                  {ssoLinked
+                    ? `You can sign in with ${ssoName}. Two-factor authentication still applies.`
+                    : `Link your ${ssoName} identity to sign in without your password.`}
+                </p>
+                {ssoLinked ? (
+                  <Button
+                    variant="secondary"
+                    onClick={handleUnlinkSSO}
+                    isLoading={isUnlinkingSSO}
+                  >
+                    Unlink
+                  </Button>
+                ) : (
+                  <Button onClick={handleLinkSSO} leftIcon={<KeyRound className="w-4 h-4" />}>
+                    Link {ssoName}
+                  </Button>
+                )}
+              </div>
+            </div>
+          </Card>
+        </>
+      )}
+
       {/* Recovery Options */}
       <h2 className="text-lg font-semibold text-text-primary mb-4">
         Recovery Options
@@ -368,11 +452,11 @@ export default function SecuritySettingsPage() {
                         </span>
                       )}
                     </div>
-                    <p className="text-sm text-text-secondary">
-                      {session.browser} - {session.location}
+                    <p className="text-sm text-text-secondary truncate" title={session.user_agent}>
+                      {session.user_agent || 'Unknown client'}
                     </p>
                     <p className="text-xs text-text-muted">
-                      {session.ip} - {formatDate(session.lastActive)}
+                      {session.ip} - {formatDate(session.last_seen)}
                     </p>
                   </div>
                   {!session.current && (
@@ -405,6 +489,50 @@ export default function SecuritySettingsPage() {
         )}
       </Card>
 
+      {/* Trusted Devices */}
+      {trustedDevices.length > 0 && (
+        <>
+          <h2 className="text-lg font-semibold text-text-primary mb-4">
+            Trusted Devices
+          </h2>
+          <Card variant="bordered" padding="none" className="mb-8 overflow-hidden">
+            <div className="divide-y divide-border">
+              {trustedDevices.map((device) => (
+                <div key={device.id} className="p-4 flex items-center gap-4">
+                  <div className="w-10 h-10 rounded-lg bg-surface-hover flex items-center justify-center flex-shrink-0">
+                    <Shield className="w-5 h-5 text-text-secondary" />
+                  </div>
+                  <div className="flex-1 min-w-0">
+                    <div className="flex items-center gap-2">
+                      <span className="font-medium text-text-primary">
+                        {device.device}
+                      </span>
+                      {device.current && (
+                        <span className="px-1.5 py-0.5 text-xs font-medium bg-green-500/10 text-green-500 rounded">
+                          This device
+                        </span>
+                      )}
+                    </div>
+                    <p className="text-xs text-text-muted">
+                      {device.ip} - skips 2FA until {new Date(device.expires_at).toLocaleDateString()}
+                    </p>
+                  </div>
+                  <Button
+                    variant="ghost"
+                    size="sm"
+                    onClick={() => handleForgetDevice(device.id)}
+                    isLoading={isForgettingDevice === device.id}
+                    className="text-red-500 hover:bg-red-500/10"
+                  >
+                    <LogOut className="w-4 h-4" />
+                  </Button>
+                </div>
+              ))}
+            </div>
+          </Card>
+        </>
+      )}
+
       {/* Security Activity */}
       <h2 className="text-lg font-semibold text-text-primary mb-4">
         Recent Security Activity
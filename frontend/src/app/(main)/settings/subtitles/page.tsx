@@ -87,31 +87,50 @@ export default function SubtitleSettingsPage() {
     }
   };
 
-  const loadSettings = () => {
-    // Load settings from localStorage
-    const savedSettings = localStorage.getItem('subtitle_settings');
-    if (savedSettings) {
-      const settings = JSON.parse(savedSettings);
-      setDefaultLanguage(settings.defaultLanguage || 'en');
-      setDefaultTargetLanguage(settings.defaultTargetLanguage || '');
-      setAutoStartSubtitles(settings.autoStartSubtitles || false);
-      setSubtitlePosition(settings.subtitlePosition || 'bottom');
-      setSubtitleFontSize(settings.subtitleFontSize || 'medium');
-      setSubtitleBackground(settings.subtitleBackground || 'semi');
+  const loadSettings = async () => {
+    // Display settings are the user's own, stored by the backend
+    try {
+      const response = await fetch(`${POCKETBASE_URL}/api/account/settings/subtitle_display`, {
+        headers: getAuthHeaders(),
+      });
+      if (response.ok) {
+        const { value: settings } = await response.json();
+        setDefaultLanguage(settings.language || 'en');
+        setDefaultTargetLanguage(settings.target_language || '');
+        setAutoStartSubtitles(settings.auto_start || false);
+        setSubtitlePosition(settings.position || 'bottom');
+        setSubtitleFontSize(settings.font_size || 'medium');
+        setSubtitleBackground(settings.background || 'semi');
+      }
+    } catch (error) {
+      console.error('Failed to load subtitle settings:', error);
     }
   };
 
-  const saveSettings = () => {
+  const saveSettings = async () => {
     const settings = {
-      defaultLanguage,
-      defaultTargetLanguage,
-      autoStartSubtitles,
-      subtitlePosition,
-      subtitleFontSize,
-      subtitleBackground,
+      language: defaultLanguage,
+      target_language: defaultTargetLanguage,
+      auto_start: autoStartSubtitles,
+      position: subtitlePosition,
+      font_size: subtitleFontSize,
+      background: subtitleBackground,
     };
-    localStorage.setItem('subtitle_settings', JSON.stringify(settings));
-    toast.success('Settings saved');
+    try {
+      const response = await fetch(`${POCKETBASE_URL}/api/account/settings/subtitle_display`, {
+        method: 'PUT',
+        headers: getAuthHeaders(),
+        body: JSON.stringify(settings),
+      });
+      if (!response.ok) {
+        const data = await response.json().catch(() => ({}));
+        toast.error(data.message || 'Failed to save settings');
+        return;
+      }
+      toast.success('Settings saved');
+    } catch (error) {
+      toast.error('Failed to save settings');
+    }
   };
 
   const fetchSessions = async () => {
@@ -142,7 +161,9 @@ export default function SubtitleSettingsPage() {
 
   const fetchOllamaConfig = async () => {
     try {
-      const response = await fetch(`${POCKETBASE_URL}/api/subtitle/ollama/config`);
+      const response = await fetch(`${POCKETBASE_URL}/api/subtitle/ollama/config`, {
+        headers: getAuthHeaders(),
+      });
       if (response.ok) {
         const data = await response.json();
         setOllamaUrl(data.url || 'http://localhost:11434');
@@ -160,7 +181,7 @@ export default function SubtitleSettingsPage() {
     try {
       const response = await fetch(`${POCKETBASE_URL}/api/subtitle/ollama/test`, {
         method: 'POST',
-        headers: { 'Content-Type': 'application/json' },
+        headers: getAuthHeaders(),
         body: JSON.stringify({ url: ollamaUrl }),
       });
       if (response.ok) {
@@ -198,6 +219,8 @@ export default function SubtitleSettingsPage() {
         const data = await response.json();
         setOllamaStatus({ available: data.available, message: data.message });
         toast.success('Ollama configuration saved');
+      } else if (response.status === 403) {
+        toast.error('Only admins can change the server configuration');
       } else {
         toast.error('Failed to save configuration');
       }
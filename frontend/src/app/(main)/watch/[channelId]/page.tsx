@@ -8,7 +8,7 @@ import { VideoPlayer } from '@/components/features/player';
 import { ChannelCard } from '@/components/features/channels';
 import { Button, Spinner, Badge, toast } from '@/components/ui';
 import { useChannelStore, usePlayerStore, useProfileStore } from '@/stores';
-import { collections } from '@/lib/pocketbase/client';
+import { collections, watchHelpers } from '@/lib/pocketbase/client';
 import type { Channel } from '@/types';
 
 export default function WatchPage() {
@@ -74,37 +74,32 @@ export default function WatchPage() {
     // Note: We don't reset the player state here anymore to allow PiP to continue
   }, [channelId, channels, setChannel, router]);
 
-  // Record watch history
+  // Record watch history, reporting the playback position periodically so
+  // playback can be resumed (the server saves it in batches)
   useEffect(() => {
-    const recordHistory = async () => {
-      if (!activeProfile?.id || !channelId || !channel) return;
+    if (!activeProfile?.id || !channelId || !channel) return;
 
+    const reportProgress = async () => {
+      const { currentTime, duration } = usePlayerStore.getState();
       try {
-        // Check if already in history
-        const existing = await collections.watchHistory.getFullList({
-          filter: `profile ~ "${activeProfile.id}" && channel ~ "${channelId}"`,
+        await watchHelpers.reportProgress({
+          profile: activeProfile.id,
+          channel: channelId,
+          position: currentTime || 0,
+          // Live streams have no length
+          duration: Number.isFinite(duration) ? duration : 0,
         });
-
-        if (existing.length > 0) {
-          // Update watched_at time
-          await collections.watchHistory.update(existing[0].id, {
-            watched_at: new Date().toISOString(),
-          });
-        } else {
-          // Create new history entry
-          await collections.watchHistory.create({
-            profile: activeProfile.id,
-            channel: channelId,
-            watched_at: new Date().toISOString(),
-            duration: 0,
-          });
-        }
       } catch (error) {
         console.error('Error recording history:', error);
       }
     };
 
-    recordHistory();
+    reportProgress();
+    const interval = setInterval(reportProgress, 15000);
+    return () => {
+      clearInterval(interval);
+      reportProgress();
+    };
   }, [activeProfile?.id, channelId, channel]);
 
   // Check if channel is favorite
@@ -138,7 +138,9 @@ export function SubtitleControls({
 
   const checkOllamaStatus = async () => {
     try {
-      const response = await fetch(`${POCKETBASE_URL}/api/subtitle/ollama/status`);
+      const response = await fetch(`${POCKETBASE_URL}/api/subtitle/ollama/status`, {
+        headers: getAuthHeaders(),
+      });
       if (response.ok) {
         const data = await response.json();
         setOllamaAvailable(data.available);
@@ -10,6 +10,19 @@ export interface SubtitleEntry {
   text: string;
   language?: string;
   processing_time?: number;
+  pending?: boolean; // First sentence of a translation still streaming, completed in a later poll
+  revision?: number;
+  original?: string;
+  words?: SubtitleWord[]; // Timing of the recognized text's words, `original` when the text is a translation
+  confidence?: number; // Speech recognition confidence from 0 to 1, when the engine reports it
+  low_confidence?: boolean; // Below the server's confidence threshold, the text may be made up
+}
+
+export interface SubtitleWord {
+  word: string;
+  start: number;
+  end: number;
+  probability?: number;
 }
 
 interface SubtitleDisplayProps {
@@ -45,7 +58,9 @@ export function SubtitleDisplay({
   const [isVisible, setIsVisible] = useState(false);
 
   const lastSubIdRef = useRef(0);
+  const lastRevisionRef = useRef(0);
   const pollIntervalRef = useRef<NodeJS.Timeout | null>(null);
+  const eventSourceRef = useRef<EventSource | null>(null);
   const displayTimeoutRef = useRef<NodeJS.Timeout | null>(null);
   const syncNotifiedRef = useRef(false);
   const waitingNotifiedRef = useRef(false);
@@ -80,10 +95,10 @@ export function SubtitleDisplay({
   onSyncReadyRef.current = onSyncReady;
   onWaitingForSyncRef.current = onWaitingForSync;
 
-  // Poll for subtitles
+  // Stream subtitles, polling for them where the stream can't get through
   useEffect(() => {
     if (enabled && sessionId) {
-      console.log(`[Sync] Starting polling for session ${sessionId}`);
+      console.log(`[Sync] Starting subtitles for session ${sessionId}`);
 
       // Notify parent that we're starting calibration
       if (!waitingNotifiedRef.current) {
@@ -91,18 +106,79 @@ export function SubtitleDisplay({
         onWaitingForSyncRef.current?.();
       }
 
+      const stored = localStorage.getItem('pocketbase_auth');
+      const authToken: string = (stored ? JSON.parse(stored) : null)?.token || '';
+
+      const handleSubtitles = (subtitles: SubtitleEntry[]) => {
+        const now = Date.now();
+
+        for (const sub of subtitles) {
+          if (sub.id <= lastSubIdRef.current) {
+            // A pending entry was completed, replace it if still on screen
+            setCurrentSubtitle((current) => (current && current.id === sub.id ? sub : current));
+            continue;
+          }
+          lastSubIdRef.current = sub.id;
+
+          // Track arrival time for drift detection
+          const timeSinceLastSub = lastSubtitleTimeRef.current > 0
+            ? now - lastSubtitleTimeRef.current
+            : 0;
+          lastSubtitleTimeRef.current = now;
+
+          console.log(`[Sync] Received subtitle id=${sub.id}, status=${syncStatusRef.current}, text="${sub.text.substring(0, 30)}..."`);
+
+          // Calibration phase - collect processing times
+          if (syncStatusRef.current === 'calibrating') {
+            const processingTime = sub.processing_time || 2000;
+            calibrationSamplesRef.current.push(processingTime);
+
+            console.log(`[Sync] Calibration sample ${calibrationSamplesRef.current.length}/${CALIBRATION_SAMPLES}: ${processingTime}ms`);
+
+            if (calibrationSamplesRef.current.length >= CALIBRATION_SAMPLES) {
+              const maxProcessingTime = Math.max(...calibrationSamplesRef.current);
+              const baseline = maxProcessingTime + SYNC_MARGIN;
+
+              console.log(`[Sync] Calibration complete. Processing times: ${calibrationSamplesRef.current.join(', ')}ms`);
+              console.log(`[Sync] Max processing: ${maxProcessingTime}ms + margin: ${SYNC_MARGIN}ms = ${baseline}ms`);
+
+              syncBaselineRef.current = baseline;
+              videoStartTimeRef.current = now;
+              syncStatusRef.current = 'synced';
+              console.log(`[Sync] Status changed to 'synced' - will display all future subtitles`);
+
+              if (!syncNotifiedRef.current) {
+                syncNotifiedRef.current = true;
+                onSyncReadyRef.current?.(baseline);
+              }
+            }
+          } else if (syncStatusRef.current === 'synced') {
+            // Display subtitle immediately - video and subtitles are now in sync
+            console.log(`[Sync] Displaying subtitle: "${sub.text.substring(0, 50)}..."`);
+            setCurrentSubtitle(sub);
+            setIsVisible(true);
+
+            if (displayTimeoutRef.current) clearTimeout(displayTimeoutRef.current);
+            const displayDuration = Math.min(8000, Math.max(2500, sub.text.length * 70));
+            displayTimeoutRef.current = setTimeout(() => setIsVisible(false), displayDuration);
+
+            // Monitor for drift
+            if (timeSinceLastSub > SUBTITLE_INTERVAL_MS + MAX_DRIFT_MS) {
+              console.log(`[Sync] Warning: subtitle delayed ${timeSinceLastSub}ms (expected ~${SUBTITLE_INTERVAL_MS}ms)`);
+            }
+          }
+        }
+      };
+
       const poll = async () => {
         if (!sessionId || !enabled) return;
 
         try {
-          const token = localStorage.getItem('pocketbase_auth');
-          const authData = token ? JSON.parse(token) : null;
-
           const response = await fetch(
-            `${POCKETBASE_URL}/api/subtitle/session/${sessionId}/subtitles?since=${lastSubIdRef.current}`,
+            `${POCKETBASE_URL}/api/subtitle/session/${sessionId}/subtitles?since_revision=${lastRevisionRef.current}`,
             {
               headers: {
-                Authorization: authData?.token ? `Bearer ${authData.token}` : '',
+                Authorization: authToken ? `Bearer ${authToken}` : '',
               },
             }
           );
@@ -110,73 +186,67 @@ export function SubtitleDisplay({
           if (!response.ok) return;
 
           const data = await response.json();
+          if (typeof data.revision === 'number') {
+            lastRevisionRef.current = data.revision;
+          }
 
           if (data.subtitles && data.subtitles.length > 0) {
-            const now = Date.now();
-
-            for (const sub of data.subtitles) {
-              if (sub.id <= lastSubIdRef.current) continue;
-              lastSubIdRef.current = sub.id;
-
-              // Track arrival time for drift detection
-              const timeSinceLastSub = lastSubtitleTimeRef.current > 0
-                ? now - lastSubtitleTimeRef.current
-                : 0;
-              lastSubtitleTimeRef.current = now;
-
-              console.log(`[Sync] Received subtitle id=${sub.id}, status=${syncStatusRef.current}, text="${sub.text.substring(0, 30)}..."`);
-
-              // Calibration phase - collect processing times
-              if (syncStatusRef.current === 'calibrating') {
-                const processingTime = sub.processing_time || 2000;
-                calibrationSamplesRef.current.push(processingTime);
-
-                console.log(`[Sync] Calibration sample ${calibrationSamplesRef.current.length}/${CALIBRATION_SAMPLES}: ${processingTime}ms`);
-
-                if (calibrationSamplesRef.current.length >= CALIBRATION_SAMPLES) {
-                  const maxProcessingTime = Math.max(...calibrationSamplesRef.current);
-                  const baseline = maxProcessingTime + SYNC_MARGIN;
-
-                  console.log(`[Sync] Calibration complete. Processing times: ${calibrationSamplesRef.current.join(', ')}ms`);
-                  console.log(`[Sync] Max processing: ${maxProcessingTime}ms + margin: ${SYNC_MARGIN}ms = ${baseline}ms`);
-
-                  syncBaselineRef.current = baseline;
-                  videoStartTimeRef.current = now;
-                  syncStatusRef.current = 'synced';
-                  console.log(`[Sync] Status changed to 'synced' - will display all future subtitles`);
-
-                  if (!syncNotifiedRef.current) {
-                    syncNotifiedRef.current = true;
-                    onSyncReadyRef.current?.(baseline);
-                  }
-                }
-              } else if (syncStatusRef.current === 'synced') {
-                // Display subtitle immediately - video and subtitles are now in sync
-                console.log(`[Sync] Displaying subtitle: "${sub.text.substring(0, 50)}..."`);
-                setCurrentSubtitle(sub);
-                setIsVisible(true);
-
-                if (displayTimeoutRef.current) clearTimeout(displayTimeoutRef.current);
-                const displayDuration = Math.min(8000, Math.max(2500, sub.text.length * 70));
-                displayTimeoutRef.current = setTimeout(() => setIsVisible(false), displayDuration);
-
-                // Monitor for drift
-                if (timeSinceLastSub > SUBTITLE_INTERVAL_MS + MAX_DRIFT_MS) {
-                  console.log(`[Sync] Warning: subtitle delayed ${timeSinceLastSub}ms (expected ~${SUBTITLE_INTERVAL_MS}ms)`);
-                }
-              }
-            }
+            handleSubtitles(data.subtitles);
           }
         } catch (error) {
           console.error('Failed to fetch subtitles:', error);
         }
       };
 
-      pollIntervalRef.current = setInterval(poll, 500);
-      poll(); // Initial fetch
+      const startPolling = () => {
+        if (pollIntervalRef.current) return;
+        console.log(`[Sync] Polling for session ${sessionId}`);
+        pollIntervalRef.current = setInterval(poll, 500);
+        poll(); // Initial fetch
+      };
+
+      // EventSource can't send headers, the stream takes the token as ?token=
+      if (typeof EventSource !== 'undefined') {
+        const params = new URLSearchParams({
+          token: authToken,
+          since_revision: String(lastRevisionRef.current),
+        });
+        const source = new EventSource(`${POCKETBASE_URL}/api/subtitle/session/${sessionId}/stream?${params}`);
+        eventSourceRef.current = source;
+        let opened = false;
+
+        source.onopen = () => {
+          opened = true;
+        };
+        source.addEventListener('entry', (event) => {
+          const sub: SubtitleEntry = JSON.parse((event as MessageEvent).data);
+          const revision = Number((event as MessageEvent).lastEventId);
+          if (revision > lastRevisionRef.current) {
+            lastRevisionRef.current = revision;
+          }
+          handleSubtitles([sub]);
+        });
+        source.addEventListener('end', () => {
+          source.close();
+        });
+        source.onerror = () => {
+          // Browsers reconnect on their own once streaming, fall back to
+          // polling when the stream never got through
+          if (!opened || source.readyState === EventSource.CLOSED) {
+            source.close();
+            startPolling();
+          }
+        };
+      } else {
+        startPolling();
+      }
 
       return () => {
-        console.log(`[Sync] Stopping polling for session ${sessionId}`);
+        console.log(`[Sync] Stopping subtitles for session ${sessionId}`);
+        if (eventSourceRef.current) {
+          eventSourceRef.current.close();
+          eventSourceRef.current = null;
+        }
         if (pollIntervalRef.current) {
           clearInterval(pollIntervalRef.current);
           pollIntervalRef.current = null;
@@ -198,6 +268,7 @@ export function SubtitleDisplay({
   useEffect(() => {
     console.log(`[Sync] Session changed to ${sessionId}, resetting state`);
     lastSubIdRef.current = 0;
+    lastRevisionRef.current = 0;
     syncNotifiedRef.current = false;
     waitingNotifiedRef.current = false;
     calibrationSamplesRef.current = [];
@@ -221,7 +292,7 @@ export function SubtitleDisplay({
         backgrounds[backgroundColor],
         fontSizes[fontSize],
         'text-white text-center font-medium shadow-lg',
-        isVisible ? 'opacity-100' : 'opacity-0',
+        isVisible ? (currentSubtitle.low_confidence ? 'opacity-60 italic' : 'opacity-100') : 'opacity-0',
         className
       )}
     >
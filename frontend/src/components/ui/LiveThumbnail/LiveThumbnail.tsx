@@ -4,6 +4,7 @@ import { useState, useEffect, useRef, useCallback } from 'react';
 import Image from 'next/image';
 import { Tv, RefreshCw, Wifi } from 'lucide-react';
 import { cn } from '@/lib/utils';
+import pb from '@/lib/pocketbase/client';
 import { useThumbnailStore } from '@/stores';
 
 interface LiveThumbnailProps {
@@ -41,6 +42,10 @@ interface LiveThumbnailProps {
    * Enable hover refresh button
    */
   enableRefresh?: boolean;
+  /**
+   * Play the animated preview on hover, when the server has them enabled
+   */
+  enablePreview?: boolean;
 }
 
 const DEFAULT_REFRESH_INTERVAL = 5 * 60 * 1000; // 5 minutes
@@ -58,11 +63,14 @@ export function LiveThumbnail({
   onLoad,
   onError,
   enableRefresh = true,
+  enablePreview = true,
 }: LiveThumbnailProps) {
   const [isVisible, setIsVisible] = useState(!lazyLoad);
   const [isHovered, setIsHovered] = useState(false);
   const [isRefreshing, setIsRefreshing] = useState(false);
   const [imageError, setImageError] = useState(false);
+  const [previewUrl, setPreviewUrl] = useState<string | null>(null);
+  const previewRequestedRef = useRef(false);
   const containerRef = useRef<HTMLDivElement>(null);
   const refreshTimerRef = useRef<NodeJS.Timeout | null>(null);
 
@@ -160,6 +168,38 @@ export function LiveThumbnail({
     };
   }, [refreshInterval, isVisible, thumbnailUrl, channelId, needsRefresh, loadThumbnail]);
 
+  // Fetch the animated preview on first hover; a 404 means previews are disabled
+  useEffect(() => {
+    if (!enablePreview || !isHovered || previewRequestedRef.current) return;
+    previewRequestedRef.current = true;
+
+    let url = `${pb.baseUrl}/api/thumbnail/${channelId}/preview`;
+    if (streamUrl) {
+      url += `?url=${encodeURIComponent(streamUrl)}`;
+    }
+
+    fetch(url, {
+      headers: pb.authStore.token
+        ? { Authorization: `Bearer ${pb.authStore.token}` }
+        : {},
+    })
+      .then((response) => (response.ok ? response.blob() : null))
+      .then((blob) => {
+        if (blob) {
+          setPreviewUrl(URL.createObjectURL(blob));
+        }
+      })
+      .catch(() => {});
+  }, [enablePreview, isHovered, channelId, streamUrl]);
+
+  useEffect(() => {
+    return () => {
+      if (previewUrl) {
+        URL.revokeObjectURL(previewUrl);
+      }
+    };
+  }, [previewUrl]);
+
   const handleRefresh = (e: React.MouseEvent) => {
     e.preventDefault();
     e.stopPropagation();
@@ -217,6 +257,17 @@ export function LiveThumbnail({
             unoptimized // Required for blob URLs
           />
 
+          {/* Animated preview */}
+          {isHovered && previewUrl && (
+            <Image
+              src={previewUrl}
+              alt={channelName || 'Channel preview'}
+              fill
+              className="object-cover"
+              unoptimized
+            />
+          )}
+
           {/* Live indicator */}
           {showLiveIndicator && (
             <div className="absolute top-2 left-2 z-10 flex items-center gap-1.5 px-2 py-1 bg-red-600 rounded text-xs font-medium text-white">
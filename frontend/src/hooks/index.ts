@@ -1,2 +1,3 @@
+export * from './useEvents';
 export * from './useRecorder';
 export * from './useThumbnail';
@@ -0,0 +1,104 @@
+import { useEffect, useRef } from 'react';
+import pb from '@/lib/pocketbase/client';
+
+export type EventTopic = 'recorder' | 'jobs' | 'playlists' | 'notifications' | 'channels' | `subtitle:${string}`;
+
+export interface BackendEvent<T = unknown> {
+  topic?: string;
+  type: string;
+  data?: T;
+  time: string;
+}
+
+type Listener = (event: BackendEvent) => void;
+
+// One WebSocket per page, shared by every component listening to events
+const listeners = new Map<string, Set<Listener>>();
+let socket: WebSocket | null = null;
+let reconnectTimer: ReturnType<typeof setTimeout> | null = null;
+let retryDelay = 1000;
+
+function send(action: 'subscribe' | 'unsubscribe', topics: string[]) {
+  if (socket?.readyState === WebSocket.OPEN && topics.length > 0) {
+    socket.send(JSON.stringify({ action, topics }));
+  }
+}
+
+function connect() {
+  if (socket || typeof WebSocket === 'undefined' || !pb.authStore.token) return;
+
+  // WebSocket can't send headers, /api/events takes the token as ?token=
+  const url = new URL('/api/events', pb.baseUrl);
+  url.protocol = url.protocol === 'https:' ? 'wss:' : 'ws:';
+  url.searchParams.set('token', pb.authStore.token);
+
+  const ws = new WebSocket(url.toString());
+  socket = ws;
+
+  ws.onopen = () => {
+    retryDelay = 1000;
+    // Topics listened to while connecting included
+    send('subscribe', Array.from(listeners.keys()));
+  };
+  ws.onmessage = (message) => {
+    const event: BackendEvent = JSON.parse(message.data);
+    if (!event.topic) return;
+    listeners.get(event.topic)?.forEach((listener) => listener(event));
+  };
+  ws.onclose = () => {
+    // Closed on purpose, or replaced already
+    if (socket !== ws) return;
+    socket = null;
+    if (listeners.size === 0 || reconnectTimer) return;
+    // Reconnect with backoff, listeners reload what they missed on their own
+    reconnectTimer = setTimeout(() => {
+      reconnectTimer = null;
+      connect();
+    }, retryDelay);
+    retryDelay = Math.min(retryDelay * 2, 30000);
+  };
+}
+
+function addListener(topic: string, listener: Listener) {
+  let set = listeners.get(topic);
+  if (!set) {
+    set = new Set();
+    listeners.set(topic, set);
+    send('subscribe', [topic]);
+  }
+  set.add(listener);
+  connect();
+}
+
+function removeListener(topic: string, listener: Listener) {
+  const set = listeners.get(topic);
+  if (!set) return;
+  set.delete(listener);
+  if (set.size > 0) return;
+
+  listeners.delete(topic);
+  send('unsubscribe', [topic]);
+  if (listeners.size === 0 && socket) {
+    const ws = socket;
+    socket = null;
+    ws.close();
+  }
+}
+
+// Calls handler with the events of the topics, pushed by the backend
+// instead of polled
+export function useEvents(topics: EventTopic[], handler: (event: BackendEvent) => void) {
+  const handlerRef = useRef(handler);
+  handlerRef.current = handler;
+
+  const key = topics.join(',');
+  useEffect(() => {
+    if (!key) return;
+    const listener: Listener = (event) => handlerRef.current(event);
+    const subscribed = key.split(',');
+    subscribed.forEach((topic) => addListener(topic, listener));
+    return () => {
+      subscribed.forEach((topic) => removeListener(topic, listener));
+    };
+  }, [key]);
+}
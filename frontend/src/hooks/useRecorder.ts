@@ -1,5 +1,7 @@
 import { useState, useCallback, useEffect } from 'react';
 import pb from '@/lib/pocketbase/client';
+import type { RecordingMode } from '@/types';
+import { useEvents } from './useEvents';
 
 export type RecordingStatus = 'idle' | 'recording' | 'paused' | 'stopping';
 
@@ -7,6 +9,7 @@ interface RecordingInfo {
   id: string;
   channel_url: string;
   output_path: string;
+  mode?: RecordingMode;
   status: string;
   started_at: string;
   paused_at?: string;
@@ -43,7 +46,33 @@ export function useRecorder(channelId: string, channelUrl: string, channelName:
     };
   }, [status]);
 
-  const startRecording = useCallback(async () => {
+  // Follow the recording as the server reports it, such as when it fails or
+  // is paused from another device
+  useEvents(currentRecordingId ? ['recorder'] : [], (event) => {
+    const info = event.data as (RecordingInfo & { error?: string }) | undefined;
+    if (!info || info.id !== currentRecordingId) return;
+
+    setRecordingInfo(info);
+    switch (event.type) {
+      case 'paused':
+        setStatus('paused');
+        break;
+      case 'resumed':
+        setStatus('recording');
+        break;
+      case 'failed':
+        setError(info.error || 'Recording failed');
+        setStatus('idle');
+        setCurrentRecordingId(null);
+        break;
+      case 'finished':
+        setStatus('idle');
+        setCurrentRecordingId(null);
+        break;
+    }
+  });
+
+  const startRecording = useCallback(async (mode?: RecordingMode) => {
     setError(null);
     const newRecordingId = `rec_${channelId}_${Date.now()}`;
 
@@ -58,6 +87,7 @@ export function useRecorder(channelId: string, channelUrl: string, channelName:
           recording_id: newRecordingId,
           channel_url: channelUrl,
           title: channelName,
+          mode,
         }),
       });
 
@@ -206,7 +206,30 @@ export function useThumbnailBatch(
         throw new Error('Batch generation failed');
       }
 
-      const results = await response.json();
+      // The batch runs as a background job, poll it until it finishes
+      const { job_id: jobId } = await response.json();
+      let batch: {
+        status: string;
+        total: number;
+        done: number;
+        results?: Record<string, { success: boolean }>;
+      };
+      for (;;) {
+        await new Promise((resolve) => setTimeout(resolve, 1000));
+        const statusResponse = await fetch(`${pb.baseUrl}/api/thumbnails/batch/${jobId}`, {
+          headers: { Authorization: `Bearer ${pb.authStore.token}` },
+        });
+        if (!statusResponse.ok) {
+          throw new Error('Batch generation failed');
+        }
+        batch = await statusResponse.json();
+        setProgress({ completed: batch.done, total: batch.total });
+        if (batch.status !== 'pending' && batch.status !== 'running') {
+          break;
+        }
+      }
+
+      const results = batch.results || {};
 
       // Build thumbnail URLs for successful generations
       const newThumbnails: Record<string, string> = {};
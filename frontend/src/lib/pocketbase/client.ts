@@ -1,5 +1,44 @@
 import PocketBase from 'pocketbase';
-import type { User } from '@/types';
+import type {
+  Channel,
+  ChannelBulkOperation,
+  ChannelBulkResult,
+  ChannelExtra,
+  ChannelGroup,
+  ChannelGroupInput,
+  ChannelSource,
+  ConnectionUsage,
+  ContinueItem,
+  DuplicateGroup,
+  EnrichedChannel,
+  EPGAutoResult,
+  EPGMapping,
+  EPGUnmatched,
+  FavoriteNow,
+  FavoritesCopyResult,
+  FavoritesImportResult,
+  PaginatedResponse,
+  PlaylistSyncJob,
+  PlaylistSyncStatus,
+  Recommendations,
+  RecordedFile,
+  RecordedFileQuery,
+  Recording,
+  RecordingBatchDelete,
+  RecordingBatchResult,
+  RecordingChapters,
+  RecordingConflict,
+  RecordingKeepRange,
+  RecordingSkips,
+  Reminder,
+  SearchResult,
+  Session,
+  StatsPeriod,
+  TrustedDevice,
+  User,
+  WatchProgress,
+  WatchStats,
+} from '@/types';
 
 const POCKETBASE_URL = process.env.NEXT_PUBLIC_POCKETBASE_URL || 'http://localhost:8090';
 
@@ -9,6 +48,30 @@ export const pb = new PocketBase(POCKETBASE_URL);
 // Disable auto cancellation for better control
 pb.autoCancellation(false);
 
+// Profile being watched, so the server applies its parental controls, and
+// the token of a profile unlocked with its PIN
+let activeProfileId: string | null = null;
+let profileUnlockToken: string | null = null;
+
+export const setActiveProfileId = (id: string | null) => {
+  if (id !== activeProfileId) {
+    profileUnlockToken = null;
+  }
+  activeProfileId = id;
+};
+
+pb.beforeSend = (url, options) => {
+  const headers: Record<string, string> = { ...(options.headers as Record<string, string>) };
+  if (activeProfileId) {
+    headers['X-Profile-Id'] = activeProfileId;
+  }
+  if (profileUnlockToken) {
+    headers['X-Profile-Unlock'] = profileUnlockToken;
+  }
+  options.headers = headers;
+  return { url, options };
+};
+
 // Auth helpers
 export const authHelpers = {
   // Get current user
@@ -49,8 +112,16 @@ export const authHelpers = {
     return user;
   },
 
-  // Logout
+  // Logout, ending the session on the server
   logout: () => {
+    if (pb.authStore.isValid) {
+      fetch(`${POCKETBASE_URL}/api/auth/logout`, {
+        method: 'POST',
+        headers: {
+          Authorization: `Bearer ${pb.authStore.token}`,
+        },
+      }).catch(() => {});
+    }
     pb.authStore.clear();
   },
 
@@ -108,17 +179,24 @@ export const totpHelpers = {
       const error = await response.json();
       throw new Error(error.message || 'Invalid TOTP code');
     }
-    return response.json();
+    const data = await response.json();
+    // Enabling 2FA signs out other sessions and replaces the token
+    if (data.token) {
+      pb.authStore.save(data.token, pb.authStore.model);
+    }
+    return data;
   },
 
-  // Validate TOTP during login (when not fully authenticated)
-  validate: async (userId: string, code: string) => {
+  // Validate TOTP during login (when not fully authenticated). Remembering
+  // the device sets a cookie letting this browser skip the code for 30 days.
+  validate: async (mfaToken: string, code: string, remember = false) => {
     const response = await fetch(`${POCKETBASE_URL}/api/auth/totp/validate`, {
       method: 'POST',
       headers: {
         'Content-Type': 'application/json',
       },
-      body: JSON.stringify({ userId, code }),
+      credentials: 'same-origin',
+      body: JSON.stringify({ mfaToken, code, remember }),
     });
     if (!response.ok) {
       const error = await response.json();
@@ -162,6 +240,1013 @@ export const totpHelpers = {
   },
 };
 
+// Account helpers (data export and deletion)
+export const accountHelpers = {
+  // Download everything stored about the user as a zip archive
+  exportData: async () => {
+    const response = await fetch(`${POCKETBASE_URL}/api/account/export`, {
+      method: 'POST',
+      headers: {
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to export data');
+    }
+    const filename =
+      response.headers.get('Content-Disposition')?.match(/filename="(.+)"/)?.[1] ||
+      'streamvault-export.zip';
+    const url = URL.createObjectURL(await response.blob());
+    const link = document.createElement('a');
+    link.href = url;
+    link.download = filename;
+    link.click();
+    URL.revokeObjectURL(url);
+  },
+
+  // Delete the account with its recordings and files, the TOTP code is
+  // needed when 2FA is enabled
+  deleteAccount: async (password: string, code?: string) => {
+    const response = await fetch(`${POCKETBASE_URL}/api/account`, {
+      method: 'DELETE',
+      headers: {
+        'Content-Type': 'application/json',
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+      body: JSON.stringify({ password, code }),
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to delete account');
+    }
+    return response.json();
+  },
+};
+
+// Watch progress helpers (resume playback)
+export const watchHelpers = {
+  // Report the playback position of a channel or recording, saved in batches
+  reportProgress: async (progress: WatchProgress) => {
+    const response = await fetch(`${POCKETBASE_URL}/api/watch/progress`, {
+      method: 'PUT',
+      headers: {
+        'Content-Type': 'application/json',
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+      body: JSON.stringify(progress),
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to save watch progress');
+    }
+  },
+
+  // Items the profile can resume, most recently watched first
+  continueWatching: async (profileId: string, limit?: number): Promise<ContinueItem[]> => {
+    const params = new URLSearchParams({ profile: profileId });
+    if (limit) params.set('limit', String(limit));
+    const response = await fetch(`${POCKETBASE_URL}/api/watch/continue?${params}`, {
+      headers: {
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to load continue watching');
+    }
+    const data = await response.json();
+    return data.items;
+  },
+
+  // Viewing statistics of all profiles, or one, in the browser's time zone
+  stats: async (period: StatsPeriod = 'week', profileId?: string): Promise<WatchStats> => {
+    const params = new URLSearchParams({
+      period,
+      tz: Intl.DateTimeFormat().resolvedOptions().timeZone,
+    });
+    if (profileId) params.set('profile', profileId);
+    const response = await fetch(`${POCKETBASE_URL}/api/stats/watch?${params}`, {
+      headers: {
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to load statistics');
+    }
+    return response.json();
+  },
+};
+
+// Recommendation helpers
+export const recommendationHelpers = {
+  // Channels and programmes suggested to a profile; refine has the
+  // configured Ollama model pick among the programmes
+  get: async (
+    profileId: string,
+    options: { limit?: number; hours?: number; refine?: boolean } = {}
+  ): Promise<Recommendations> => {
+    const params = new URLSearchParams();
+    if (options.limit) params.set('limit', String(options.limit));
+    if (options.hours) params.set('hours', String(options.hours));
+    if (options.refine) params.set('refine', 'true');
+    const response = await fetch(
+      `${POCKETBASE_URL}/api/recommendations/${profileId}?${params}`,
+      {
+        headers: {
+          Authorization: `Bearer ${pb.authStore.token}`,
+        },
+      }
+    );
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to load recommendations');
+    }
+    return response.json();
+  },
+};
+
+// Reminder helpers (notifications before programmes start)
+export const reminderHelpers = {
+  // Remind minutesBefore a programme starts, replaces the existing reminder
+  create: async (programId: string, minutesBefore?: number, profileId?: string): Promise<Reminder> => {
+    const response = await fetch(`${POCKETBASE_URL}/api/reminders`, {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+      body: JSON.stringify({
+        program: programId,
+        profile: profileId,
+        minutes_before: minutesBefore,
+      }),
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to save reminder');
+    }
+    return response.json();
+  },
+
+  // Schedule a recording of the reminder's programme instead
+  record: async (reminderId: string, profileId?: string): Promise<Recording> => {
+    const response = await fetch(`${POCKETBASE_URL}/api/reminders/${reminderId}/record`, {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+      body: JSON.stringify({ profile: profileId }),
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to schedule recording');
+    }
+    return response.json();
+  },
+};
+
+// Search helpers
+export const searchHelpers = {
+  // Search channels and upcoming programmes, page applies to each list
+  search: async (
+    query: string,
+    options: { type?: 'all' | 'channels' | 'programs'; page?: number; perPage?: number } = {}
+  ): Promise<SearchResult> => {
+    const params = new URLSearchParams({ q: query });
+    if (options.type) params.set('type', options.type);
+    if (options.page) params.set('page', String(options.page));
+    if (options.perPage) params.set('perPage', String(options.perPage));
+
+    const headers: Record<string, string> = {
+      Authorization: `Bearer ${pb.authStore.token}`,
+    };
+    if (activeProfileId) {
+      headers['X-Profile-Id'] = activeProfileId;
+    }
+    if (profileUnlockToken) {
+      headers['X-Profile-Unlock'] = profileUnlockToken;
+    }
+
+    const response = await fetch(`${POCKETBASE_URL}/api/search?${params}`, { headers });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Search failed');
+    }
+    return response.json();
+  },
+};
+
+// Duplicate channel helpers
+export const dedupeHelpers = {
+  // Groups of channels that look like the same channel
+  duplicates: async (playlistId?: string): Promise<DuplicateGroup[]> => {
+    const params = playlistId ? `?playlist=${encodeURIComponent(playlistId)}` : '';
+    const response = await fetch(`${POCKETBASE_URL}/api/channels/duplicates${params}`, {
+      headers: {
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to analyze channels');
+    }
+    const data = await response.json();
+    return data.groups;
+  },
+
+  // Sources of a channel by priority
+  sources: async (channelId: string): Promise<ChannelSource[]> => {
+    const response = await fetch(`${POCKETBASE_URL}/api/channels/${channelId}/sources`, {
+      headers: {
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to load channel sources');
+    }
+    const data = await response.json();
+    return data.sources;
+  },
+
+  // Merge channels into channelId as its sources, in priority order
+  merge: async (channelId: string, sourceIds: string[]): Promise<Channel[]> => {
+    const response = await fetch(`${POCKETBASE_URL}/api/channels/${channelId}/merge`, {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+      body: JSON.stringify({ sources: sourceIds }),
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to merge channels');
+    }
+    const data = await response.json();
+    return data.sources;
+  },
+
+  // Detach a source from its channel, or all the sources of a channel
+  unmerge: async (channelId: string): Promise<Channel[]> => {
+    const response = await fetch(`${POCKETBASE_URL}/api/channels/${channelId}/unmerge`, {
+      method: 'POST',
+      headers: {
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to unmerge channels');
+    }
+    const data = await response.json();
+    return data.detached;
+  },
+};
+
+// Playlist import helpers
+export const playlistHelpers = {
+  // Queue a sync from the playlist's URL, or get the one already queued
+  sync: async (playlistId: string, prune = false): Promise<PlaylistSyncJob> => {
+    const response = await fetch(`${POCKETBASE_URL}/api/playlists/${playlistId}/sync`, {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+      body: JSON.stringify({ prune }),
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to sync playlist');
+    }
+    return response.json();
+  },
+
+  // Progress of the current sync and what the last one changed
+  syncStatus: async (playlistId: string): Promise<PlaylistSyncStatus> => {
+    const response = await fetch(`${POCKETBASE_URL}/api/playlists/${playlistId}/sync-status`, {
+      headers: {
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to load sync status');
+    }
+    return response.json();
+  },
+
+  // Connections in use of the playlists that have a limit
+  connections: async (): Promise<ConnectionUsage[]> => {
+    const response = await fetch(`${POCKETBASE_URL}/api/connections`, {
+      headers: {
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to load connections');
+    }
+    return response.json();
+  },
+};
+
+// Recording schedule helpers
+export const recordingHelpers = {
+  // The user's recorded files, a page at a time, newest first by default
+  files: async (query: RecordedFileQuery = {}): Promise<PaginatedResponse<RecordedFile>> => {
+    const params = new URLSearchParams();
+    for (const [key, value] of Object.entries(query)) {
+      if (value !== undefined && value !== '') {
+        params.set(key, String(value));
+      }
+    }
+    const response = await fetch(`${POCKETBASE_URL}/api/recorder/files?${params}`, {
+      headers: {
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to list recordings');
+    }
+    return response.json();
+  },
+
+  // Delete many recordings at once; a rolled back batch (409) still
+  // reports why each item couldn't be deleted
+  deleteMany: async (batch: RecordingBatchDelete): Promise<RecordingBatchResult> => {
+    const response = await fetch(`${POCKETBASE_URL}/api/recorder/files/delete`, {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+      body: JSON.stringify(batch),
+    });
+    if (!response.ok && response.status !== 409) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to delete recordings');
+    }
+    return response.json();
+  },
+
+  // A ZIP of a recording with its subtitles, a poster and its metadata
+  bundle: async (recordingId: string): Promise<Blob> => {
+    const response = await fetch(`${POCKETBASE_URL}/api/recordings/${recordingId}/bundle`, {
+      headers: {
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to download recording');
+    }
+    return response.blob();
+  },
+
+  chapters: async (recordingId: string): Promise<RecordingChapters> => {
+    const response = await fetch(`${POCKETBASE_URL}/api/recordings/${recordingId}/chapters`, {
+      headers: {
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to load chapters');
+    }
+    return response.json();
+  },
+
+  // Probable ad segments to skip, from a confidence between 0 and 1
+  skips: async (recordingId: string, minConfidence?: number): Promise<RecordingSkips> => {
+    const params = new URLSearchParams();
+    if (minConfidence !== undefined) {
+      params.set('min_confidence', String(minConfidence));
+    }
+    const response = await fetch(`${POCKETBASE_URL}/api/recordings/${recordingId}/skips?${params}`, {
+      headers: {
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to load skips');
+    }
+    return response.json();
+  },
+
+  // Analyze the recording again in the background for its chapters and
+  // ads, such as once the guide covers it
+  analyze: async (recordingId: string): Promise<{ job_id: string; status: string }> => {
+    const response = await fetch(`${POCKETBASE_URL}/api/recordings/${recordingId}/analyze`, {
+      method: 'POST',
+      headers: {
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to analyze recording');
+    }
+    return response.json();
+  },
+
+  // Scheduled recordings exceeding their provider's connections, or with a
+  // schedule, the conflicts recording it would take part in
+  conflicts: async (schedule?: { channel: string; start: string; end: string }): Promise<RecordingConflict[]> => {
+    const params = new URLSearchParams(schedule);
+    const response = await fetch(`${POCKETBASE_URL}/api/recorder/conflicts?${params}`, {
+      headers: {
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to check recording conflicts');
+    }
+    return response.json();
+  },
+
+  // Cut a finished recording down to the ranges to keep (seconds from its
+  // start, end 0 for the rest) into a new recording; returns the job to poll
+  trim: async (recordingId: string, ranges: RecordingKeepRange[]): Promise<{ job_id: string; status: string }> => {
+    const response = await fetch(`${POCKETBASE_URL}/api/recordings/${recordingId}/trim`, {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+      body: JSON.stringify({ ranges }),
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to trim the recording');
+    }
+    return response.json();
+  },
+};
+
+// Bulk channel management helpers
+export const channelHelpers = {
+  // Apply one action to many channels in a single transaction
+  bulk: async (operation: ChannelBulkOperation): Promise<ChannelBulkResult> => {
+    const response = await fetch(`${POCKETBASE_URL}/api/channels/bulk`, {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+      body: JSON.stringify(operation),
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to update channels');
+    }
+    return response.json();
+  },
+
+  // A page of channels with what they air, their thumbnail and their
+  // health, in one request instead of several per row
+  list: async (
+    options: {
+      with?: ChannelExtra[];
+      page?: number;
+      perPage?: number;
+      sort?: string;
+      filter?: string;
+      size?: 'small' | 'medium' | 'large';
+    } = {}
+  ): Promise<PaginatedResponse<EnrichedChannel>> => {
+    const params = new URLSearchParams();
+    if (options.with?.length) params.set('with', options.with.join(','));
+    if (options.page) params.set('page', String(options.page));
+    if (options.perPage) params.set('perPage', String(options.perPage));
+    if (options.sort) params.set('sort', options.sort);
+    if (options.filter) params.set('filter', options.filter);
+    if (options.size) params.set('size', options.size);
+
+    const headers: Record<string, string> = {
+      Authorization: `Bearer ${pb.authStore.token}`,
+    };
+    if (activeProfileId) {
+      headers['X-Profile-Id'] = activeProfileId;
+    }
+    if (profileUnlockToken) {
+      headers['X-Profile-Unlock'] = profileUnlockToken;
+    }
+
+    const response = await fetch(`${POCKETBASE_URL}/api/channels?${params}`, { headers });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to load channels');
+    }
+    return response.json();
+  },
+};
+
+// Favorites helpers
+export const favoriteHelpers = {
+  // Put the channels first in the profile's favorites, in that order
+  reorder: async (profileId: string, channelIds: string[]): Promise<number> => {
+    const response = await fetch(`${POCKETBASE_URL}/api/favorites/order`, {
+      method: 'PUT',
+      headers: {
+        'Content-Type': 'application/json',
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+      body: JSON.stringify({ profile: profileId, channels: channelIds }),
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to reorder favorites');
+    }
+    const data = await response.json();
+    return data.reordered;
+  },
+
+  // Copy the favorites of a profile to another, replacing its own or not
+  copy: async (fromProfileId: string, toProfileId: string, replace = false): Promise<FavoritesCopyResult> => {
+    const response = await fetch(`${POCKETBASE_URL}/api/favorites/copy`, {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+      body: JSON.stringify({ from: fromProfileId, to: toProfileId, replace }),
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to copy favorites');
+    }
+    return response.json();
+  },
+
+  // Add channels to the profile's favorites by name
+  importNames: async (profileId: string, names: string[]): Promise<FavoritesImportResult> => {
+    const response = await fetch(`${POCKETBASE_URL}/api/favorites/import`, {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+      body: JSON.stringify({ profile: profileId, names }),
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to import favorites');
+    }
+    return response.json();
+  },
+};
+
+// Channel group helpers: the user's own names and groupings of channels
+export const groupHelpers = {
+  // The user's groups, then the provider groups left as is
+  list: async (): Promise<ChannelGroup[]> => {
+    const response = await fetch(`${POCKETBASE_URL}/api/groups`, {
+      headers: {
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to load groups');
+    }
+    const data = await response.json();
+    return data.items;
+  },
+
+  create: async (input: ChannelGroupInput): Promise<ChannelGroup> => {
+    const response = await fetch(`${POCKETBASE_URL}/api/groups`, {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+      body: JSON.stringify(input),
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to create group');
+    }
+    return response.json();
+  },
+
+  update: async (groupId: string, input: ChannelGroupInput): Promise<ChannelGroup> => {
+    const response = await fetch(`${POCKETBASE_URL}/api/groups/${groupId}`, {
+      method: 'PUT',
+      headers: {
+        'Content-Type': 'application/json',
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+      body: JSON.stringify(input),
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to update group');
+    }
+    return response.json();
+  },
+
+  remove: async (groupId: string) => {
+    const response = await fetch(`${POCKETBASE_URL}/api/groups/${groupId}`, {
+      method: 'DELETE',
+      headers: {
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to delete group');
+    }
+  },
+
+  // Rename a group of the user, or list a provider group under another name
+  rename: async (from: string, to: string): Promise<ChannelGroup> => {
+    const response = await fetch(`${POCKETBASE_URL}/api/groups/rename`, {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+      body: JSON.stringify({ from, to }),
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to rename group');
+    }
+    return response.json();
+  },
+
+  // List the groups under into
+  merge: async (into: string, groups: string[]): Promise<ChannelGroup> => {
+    const response = await fetch(`${POCKETBASE_URL}/api/groups/merge`, {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+      body: JSON.stringify({ into, groups }),
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to merge groups');
+    }
+    return response.json();
+  },
+};
+
+// Programme guide helpers
+export const epgHelpers = {
+  // What the profile's favorite channels air now and next
+  now: async (profileId: string): Promise<FavoriteNow[]> => {
+    const response = await fetch(
+      `${POCKETBASE_URL}/api/epg/now?profile=${encodeURIComponent(profileId)}`,
+      {
+        headers: {
+          Authorization: `Bearer ${pb.authStore.token}`,
+        },
+      }
+    );
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to load the guide');
+    }
+    const data = await response.json();
+    return data.items;
+  },
+
+  mappings: async (): Promise<EPGMapping[]> => {
+    const response = await fetch(`${POCKETBASE_URL}/api/epg/mappings`, {
+      headers: {
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to load guide mappings');
+    }
+    const data = await response.json();
+    return data.items;
+  },
+
+  // Channels without guide data, with the guide channels they may be
+  unmatched: async (
+    playlistId?: string,
+    page = 1,
+    perPage = 50
+  ): Promise<PaginatedResponse<EPGUnmatched> & { guide_channels: number }> => {
+    const params = new URLSearchParams({ page: String(page), perPage: String(perPage) });
+    if (playlistId) params.set('playlist', playlistId);
+    const response = await fetch(`${POCKETBASE_URL}/api/epg/mappings/unmatched?${params}`, {
+      headers: {
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to load unmatched channels');
+    }
+    return response.json();
+  },
+
+  // Map unmatched channels to their best suggestion
+  autoMatch: async (options: { playlist?: string; min_score?: number; dry_run?: boolean } = {}): Promise<EPGAutoResult> => {
+    const response = await fetch(`${POCKETBASE_URL}/api/epg/mappings/auto`, {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+      body: JSON.stringify(options),
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to match channels');
+    }
+    return response.json();
+  },
+
+  // Map a channel to a guide channel, an empty epgId for none
+  map: async (channelId: string, epgId: string): Promise<EPGMapping> => {
+    const response = await fetch(`${POCKETBASE_URL}/api/epg/mappings/${channelId}`, {
+      method: 'PUT',
+      headers: {
+        'Content-Type': 'application/json',
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+      body: JSON.stringify({ epg_id: epgId }),
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to map channel');
+    }
+    return response.json();
+  },
+
+  unmap: async (channelId: string) => {
+    const response = await fetch(`${POCKETBASE_URL}/api/epg/mappings/${channelId}`, {
+      method: 'DELETE',
+      headers: {
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to remove mapping');
+    }
+  },
+};
+
+// Session helpers (clients the user is signed in on)
+export const sessionHelpers = {
+  // List sessions, the current one is flagged
+  list: async (): Promise<Session[]> => {
+    const response = await fetch(`${POCKETBASE_URL}/api/auth/sessions`, {
+      headers: {
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+    });
+    if (!response.ok) {
+      throw new Error('Failed to load sessions');
+    }
+    const data: { sessions: Session[] } = await response.json();
+    return data.sessions;
+  },
+
+  // Sign out a session
+  revoke: async (sessionId: string) => {
+    const response = await fetch(`${POCKETBASE_URL}/api/auth/sessions/${sessionId}`, {
+      method: 'DELETE',
+      headers: {
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to revoke session');
+    }
+  },
+
+  // Sign out every other session, the current token is replaced
+  revokeOthers: async () => {
+    const response = await fetch(`${POCKETBASE_URL}/api/auth/sessions/revoke-others`, {
+      method: 'POST',
+      headers: {
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to revoke sessions');
+    }
+    const data: { token: string; revoked: number } = await response.json();
+    pb.authStore.save(data.token, pb.authStore.model);
+    return data;
+  },
+};
+
+// Trusted device helpers (browsers skipping the 2FA code)
+export const trustedDeviceHelpers = {
+  // List trusted devices, this browser is flagged as current
+  list: async (): Promise<TrustedDevice[]> => {
+    const response = await fetch(`${POCKETBASE_URL}/api/auth/trusted-devices`, {
+      headers: {
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+      credentials: 'same-origin',
+    });
+    if (!response.ok) {
+      throw new Error('Failed to load trusted devices');
+    }
+    const data: { devices: TrustedDevice[] } = await response.json();
+    return data.devices;
+  },
+
+  // Forget a trusted device, it has to enter the 2FA code again
+  revoke: async (deviceId: string) => {
+    const response = await fetch(`${POCKETBASE_URL}/api/auth/trusted-devices/${deviceId}`, {
+      method: 'DELETE',
+      headers: {
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+      credentials: 'same-origin',
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to forget device');
+    }
+  },
+};
+
+// Parental control helpers
+export const parentalHelpers = {
+  // Verify the PIN of a profile, lifting its restrictions for a while
+  unlock: async (profileId: string, pin: string) => {
+    const response = await fetch(`${POCKETBASE_URL}/api/profiles/${profileId}/pin/verify`, {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+        Authorization: `Bearer ${pb.authStore.token}`,
+      },
+      body: JSON.stringify({ pin }),
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Invalid PIN');
+    }
+    const data: { token: string; expires_at: string } = await response.json();
+    if (profileId === activeProfileId) {
+      profileUnlockToken = data.token;
+    }
+    return data;
+  },
+
+  // Set or change the PIN of a profile, changing one needs the profile unlocked
+  setPin: async (profileId: string, pin: string) => {
+    const response = await fetch(`${POCKETBASE_URL}/api/profiles/${profileId}/pin`, {
+      method: 'PUT',
+      headers: {
+        'Content-Type': 'application/json',
+        Authorization: `Bearer ${pb.authStore.token}`,
+        ...(profileUnlockToken ? { 'X-Profile-Unlock': profileUnlockToken } : {}),
+      },
+      body: JSON.stringify({ pin }),
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to set PIN');
+    }
+    return response.json();
+  },
+
+  // Remove the PIN of an unlocked profile
+  removePin: async (profileId: string) => {
+    const response = await fetch(`${POCKETBASE_URL}/api/profiles/${profileId}/pin`, {
+      method: 'DELETE',
+      headers: {
+        Authorization: `Bearer ${pb.authStore.token}`,
+        ...(profileUnlockToken ? { 'X-Profile-Unlock': profileUnlockToken } : {}),
+      },
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to remove PIN');
+    }
+    return response.json();
+  },
+
+  // Restore the restrictions of the active profile
+  lock: () => {
+    profileUnlockToken = null;
+  },
+};
+
+// Single sign-on helpers (OpenID Connect provider configured on the server)
+const SSO_PROVIDER = 'oidc';
+const SSO_STORAGE_KEY = 'sso-pending';
+
+export interface SSOProvider {
+  name: string;
+  displayName: string;
+  state: string;
+  codeVerifier: string;
+  authUrl: string;
+}
+
+export const ssoHelpers = {
+  // Get the SSO provider, null when none is configured
+  getProvider: async (): Promise<SSOProvider | null> => {
+    const response = await fetch(`${POCKETBASE_URL}/api/collections/users/auth-methods`);
+    if (!response.ok) return null;
+    const data = await response.json();
+    const providers: SSOProvider[] = data.authProviders || [];
+    return providers.find((p) => p.name === SSO_PROVIDER) || null;
+  },
+
+  // Redirect to the provider, to sign in or to link the signed in account
+  start: async (link = false) => {
+    const provider = await ssoHelpers.getProvider();
+    if (!provider) {
+      throw new Error('Single sign-on is not configured');
+    }
+    const redirectUrl = `${window.location.origin}/sso-callback`;
+    sessionStorage.setItem(
+      SSO_STORAGE_KEY,
+      JSON.stringify({ state: provider.state, codeVerifier: provider.codeVerifier, redirectUrl, link })
+    );
+    window.location.href = provider.authUrl + encodeURIComponent(redirectUrl);
+  },
+
+  // Whether the signed in account is linked to an SSO identity
+  isLinked: async (userId: string): Promise<boolean> => {
+    const response = await fetch(
+      `${POCKETBASE_URL}/api/collections/users/records/${userId}/external-auths`,
+      { headers: { Authorization: `Bearer ${pb.authStore.token}` } }
+    );
+    if (!response.ok) return false;
+    const links: Array<{ provider: string }> = await response.json();
+    return links.some((l) => l.provider === SSO_PROVIDER);
+  },
+
+  // Unlink the SSO identity of the signed in account
+  unlink: async (userId: string) => {
+    const response = await fetch(
+      `${POCKETBASE_URL}/api/collections/users/records/${userId}/external-auths/${SSO_PROVIDER}`,
+      { method: 'DELETE', headers: { Authorization: `Bearer ${pb.authStore.token}` } }
+    );
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Failed to unlink single sign-on');
+    }
+  },
+
+  // Exchange the code the provider redirected back with
+  complete: async (code: string, state: string) => {
+    const pending = JSON.parse(sessionStorage.getItem(SSO_STORAGE_KEY) || 'null');
+    sessionStorage.removeItem(SSO_STORAGE_KEY);
+    if (!pending || pending.state !== state) {
+      throw new Error('Single sign-on session expired, please try again');
+    }
+
+    const headers: Record<string, string> = { 'Content-Type': 'application/json' };
+    if (pending.link && pb.authStore.token) {
+      headers.Authorization = `Bearer ${pb.authStore.token}`;
+    }
+
+    const response = await fetch(`${POCKETBASE_URL}/api/collections/users/auth-with-oauth2`, {
+      method: 'POST',
+      headers,
+      body: JSON.stringify({
+        provider: SSO_PROVIDER,
+        code,
+        codeVerifier: pending.codeVerifier,
+        redirectUrl: pending.redirectUrl,
+      }),
+    });
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.message || 'Single sign-on failed');
+    }
+    const data = await response.json();
+    // Users with 2FA get their token after entering a code
+    if (data.token) {
+      pb.authStore.save(data.token, data.record);
+    }
+    return {
+      ...data,
+      linked: !!pending.link,
+      requires2FA: !data.token && !!data.meta?.requires_2fa,
+      mfaToken: data.meta?.mfa_token as string | undefined,
+    };
+  },
+};
+
 // Collection helpers
 export const collections = {
   profiles: pb.collection('profiles'),
@@ -173,6 +1258,7 @@ export const collections = {
   epgSources: pb.collection('epg_sources'),
   epgPrograms: pb.collection('epg_programs'),
   recordings: pb.collection('recordings'),
+  reminders: pb.collection('reminders'),
   settings: pb.collection('settings'),
 };
 
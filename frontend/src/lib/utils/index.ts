@@ -106,6 +106,11 @@ export function getInitials(name: string): string {
     .join('');
 }
 
+// Groups a channel is listed in: its own, then the groups picking it
+export function channelGroups(channel: { group_title: string; virtual_groups?: string[] }): string[] {
+  return [channel.group_title || 'Uncategorized', ...(channel.virtual_groups ?? [])];
+}
+
 // Capitalize first letter
 export function capitalize(str: string): string {
   return str.charAt(0).toUpperCase() + str.slice(1);
@@ -1,11 +1,12 @@
 import { create } from 'zustand';
 import { persist } from 'zustand/middleware';
-import { pb, authHelpers, totpHelpers } from '@/lib/pocketbase/client';
+import { pb, authHelpers, totpHelpers, ssoHelpers } from '@/lib/pocketbase/client';
 import type { User, AuthState } from '@/types';
 
 interface AuthStore extends AuthState {
   // Actions
   login: (email: string, password: string) => Promise<boolean>;
+  completeSSO: (code: string, state: string) => Promise<'authenticated' | 'linked' | '2fa'>;
   register: (data: {
     email: string;
     username: string;
@@ -14,10 +15,10 @@ interface AuthStore extends AuthState {
   }) => Promise<void>;
   logout: () => void;
   refreshAuth: () => Promise<void>;
-  verify2FA: (code: string) => Promise<void>;
+  verify2FA: (code: string, remember?: boolean) => Promise<void>;
   setup2FA: () => Promise<{ secret: string; qrCode: string }>;
   disable2FA: (code: string, password: string) => Promise<void>;
-  setRequires2FA: (requires: boolean, userId?: string) => void;
+  setRequires2FA: (requires: boolean, mfaToken?: string) => void;
   clearPending2FA: () => void;
 }
 
@@ -29,18 +30,19 @@ export const useAuthStore = create<AuthStore>()(
       isAuthenticated: false,
       isLoading: true,
       requires2FA: false,
-      pendingUserId: undefined,
+      pendingMFAToken: undefined,
 
       login: async (email: string, password: string) => {
         try {
           const authData = await authHelpers.login(email, password);
           const user = authData.record as unknown as User;
 
-          // Check if 2FA is required
-          if (user.totp_enabled) {
+          // The server holds back the token until the 2FA code is entered,
+          // unless this browser is a trusted device
+          if (!authData.token) {
             set({
               requires2FA: true,
-              pendingUserId: user.id,
+              pendingMFAToken: authData.meta?.mfa_token,
               isAuthenticated: false,
             });
             // Clear the auth store since we're not fully authenticated
@@ -54,7 +56,7 @@ export const useAuthStore = create<AuthStore>()(
             isAuthenticated: true,
             isLoading: false,
             requires2FA: false,
-            pendingUserId: undefined,
+            pendingMFAToken: undefined,
           });
           return true;
         } catch (error) {
@@ -63,6 +65,32 @@ export const useAuthStore = create<AuthStore>()(
         }
       },
 
+      completeSSO: async (code: string, state: string) => {
+        const result = await ssoHelpers.complete(code, state);
+        const user = result.record as unknown as User;
+
+        // Same as a password login, 2FA users still have to enter a code
+        if (result.requires2FA) {
+          set({
+            requires2FA: true,
+            pendingMFAToken: result.mfaToken,
+            isAuthenticated: false,
+          });
+          pb.authStore.clear();
+          return '2fa';
+        }
+
+        set({
+          user,
+          token: result.token,
+          isAuthenticated: true,
+          isLoading: false,
+          requires2FA: false,
+          pendingMFAToken: undefined,
+        });
+        return result.linked ? 'linked' : 'authenticated';
+      },
+
       register: async (data) => {
         try {
           const user = await authHelpers.register(data);
@@ -86,7 +114,7 @@ export const useAuthStore = create<AuthStore>()(
           isAuthenticated: false,
           isLoading: false,
           requires2FA: false,
-          pendingUserId: undefined,
+          pendingMFAToken: undefined,
         });
       },
 
@@ -119,21 +147,21 @@ export const useAuthStore = create<AuthStore>()(
         }
       },
 
-      verify2FA: async (code: string) => {
-        const { pendingUserId } = get();
-        if (!pendingUserId) {
+      verify2FA: async (code: string, remember = false) => {
+        const { pendingMFAToken } = get();
+        if (!pendingMFAToken) {
           throw new Error('No pending 2FA verification');
         }
 
         try {
-          const result = await totpHelpers.validate(pendingUserId, code);
+          const result = await totpHelpers.validate(pendingMFAToken, code, remember);
           set({
             user: result.record as unknown as User,
             token: result.token,
             isAuthenticated: true,
             isLoading: false,
             requires2FA: false,
-            pendingUserId: undefined,
+            pendingMFAToken: undefined,
           });
         } catch (error) {
           throw error;
@@ -158,17 +186,17 @@ export const useAuthStore = create<AuthStore>()(
         }
       },
 
-      setRequires2FA: (requires: boolean, userId?: string) => {
+      setRequires2FA: (requires: boolean, mfaToken?: string) => {
         set({
           requires2FA: requires,
-          pendingUserId: userId,
+          pendingMFAToken: mfaToken,
         });
       },
 
       clearPending2FA: () => {
         set({
           requires2FA: false,
-          pendingUserId: undefined,
+          pendingMFAToken: undefined,
         });
       },
     }),
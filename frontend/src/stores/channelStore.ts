@@ -1,5 +1,6 @@
 import { create } from 'zustand';
 import { collections } from '@/lib/pocketbase/client';
+import { channelGroups } from '@/lib/utils';
 import type { Channel, Playlist, Category } from '@/types';
 
 interface ChannelStore {
@@ -52,11 +53,13 @@ export const useChannelStore = create<ChannelStore>((set, get) => ({
 
       // Then get channels from those playlists
       const playlistIds = playlists.map((p) => p.id);
-      const filter = playlistIds.map((id) => `playlist ~ "${id}"`).join(' || ');
+      // Duplicates merged into another channel are played through it,
+      // disabled channels are hidden
+      const filter = `(${playlistIds.map((id) => `playlist ~ "${id}"`).join(' || ')}) && merged_into = "" && is_active = true`;
 
       const records = await collections.channels.getFullList({
         filter: filter,
-        sort: 'name',
+        sort: 'sort_order,name',
       });
 
       // Deduplicate channels by URL to avoid showing the same stream multiple times
@@ -243,11 +246,12 @@ export const useChannelStore = create<ChannelStore>((set, get) => ({
   getChannelsByCategory: () => {
     const { channels } = get();
     return channels.reduce((acc, channel) => {
-      const category = channel.group_title || 'Uncategorized';
-      if (!acc[category]) {
-        acc[category] = [];
-      }
-      acc[category].push(channel);
+      channelGroups(channel).forEach((category) => {
+        if (!acc[category]) {
+          acc[category] = [];
+        }
+        acc[category].push(channel);
+      });
       return acc;
     }, {} as Record<string, Channel[]>);
   },
@@ -267,7 +271,7 @@ export const useChannelStore = create<ChannelStore>((set, get) => ({
     }
 
     if (selectedCategory) {
-      filtered = filtered.filter((c) => c.group_title === selectedCategory);
+      filtered = filtered.filter((c) => channelGroups(c).includes(selectedCategory));
     }
 
     return filtered;
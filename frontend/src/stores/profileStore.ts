@@ -1,6 +1,6 @@
 import { create } from 'zustand';
 import { persist } from 'zustand/middleware';
-import { collections } from '@/lib/pocketbase/client';
+import { collections, parentalHelpers, setActiveProfileId } from '@/lib/pocketbase/client';
 import type { Profile } from '@/types';
 
 interface ProfileStore {
@@ -12,6 +12,8 @@ interface ProfileStore {
   // Actions
   fetchProfiles: (userId: string) => Promise<void>;
   setActiveProfile: (profile: Profile) => void;
+  unlockProfile: (pin: string) => Promise<void>;
+  lockProfile: () => void;
   createProfile: (data: Partial<Profile>) => Promise<Profile>;
   updateProfile: (id: string, data: Partial<Profile>) => Promise<Profile>;
   deleteProfile: (id: string) => Promise<void>;
@@ -63,11 +65,13 @@ export const useProfileStore = create<ProfileStore>()(
           // If no active profile, set the first one
           const { activeProfile } = get();
           if (!activeProfile && records.length > 0) {
+            setActiveProfileId(records[0].id);
             set({ activeProfile: records[0] as unknown as Profile });
           } else if (activeProfile && records.length > 0) {
             // Verify active profile still exists in fetched records
             const exists = records.some(r => r.id === activeProfile.id);
             if (!exists) {
+              setActiveProfileId(records[0].id);
               set({ activeProfile: records[0] as unknown as Profile });
             }
           }
@@ -80,9 +84,22 @@ export const useProfileStore = create<ProfileStore>()(
       },
 
       setActiveProfile: (profile: Profile) => {
+        setActiveProfileId(profile.id);
         set({ activeProfile: profile });
       },
 
+      unlockProfile: async (pin: string) => {
+        const { activeProfile } = get();
+        if (!activeProfile) {
+          throw new Error('No active profile');
+        }
+        await parentalHelpers.unlock(activeProfile.id, pin);
+      },
+
+      lockProfile: () => {
+        parentalHelpers.lock();
+      },
+
       createProfile: async (data: Partial<Profile>) => {
         set({ isLoading: true, error: null });
         try {
@@ -133,6 +150,9 @@ export const useProfileStore = create<ProfileStore>()(
           await collections.profiles.delete(id);
           set((state) => {
             const newProfiles = state.profiles.filter((p) => p.id !== id);
+            if (state.activeProfile?.id === id) {
+              setActiveProfileId(newProfiles[0]?.id || null);
+            }
             return {
               profiles: newProfiles,
               activeProfile:
@@ -152,6 +172,7 @@ export const useProfileStore = create<ProfileStore>()(
       },
 
       clearProfiles: () => {
+        setActiveProfileId(null);
         set({
           profiles: [],
           activeProfile: null,
@@ -164,6 +185,9 @@ export const useProfileStore = create<ProfileStore>()(
       partialize: (state) => ({
         activeProfile: state.activeProfile,
       }),
+      onRehydrateStorage: () => (state) => {
+        setActiveProfileId(state?.activeProfile?.id || null);
+      },
     }
   )
 );
@@ -5,6 +5,8 @@ export interface User {
   username: string;
   avatar?: string;
   totp_enabled: boolean;
+  role?: 'user' | 'admin'; // Admins manage server configuration
+  storage_quota_mb?: number;
   created: string;
   updated: string;
 }
@@ -15,9 +17,18 @@ export interface Profile {
   user: string;
   name: string;
   avatar?: string;
-  pin?: string;
+  has_pin?: boolean;
   is_kids: boolean;
   language: string;
+  blocked_groups?: string[];
+  blocked_channels?: string[];
+  // Normalize the loudness of the profile's recordings (EBU R128)
+  recording_loudnorm?: boolean;
+  // Target in LUFS, -23 when 0
+  recording_loudness?: number;
+  // Cut the ads found out of the profile's recordings, into a copy or in
+  // place of the original
+  recording_ad_cut?: '' | 'copy' | 'replace';
   created: string;
   updated: string;
 }
@@ -33,10 +44,93 @@ export interface Playlist {
   last_synced?: string;
   auto_sync: boolean;
   sync_interval: number;
+  // Sent with every request to the provider
+  user_agent?: string;
+  referrer?: string;
+  headers?: Record<string, string> | null;
+  // Connections the provider allows at once, 0 for no limit
+  max_connections?: number;
   created: string;
   updated: string;
 }
 
+// Connections in use of a playlist with a limit
+export interface ConnectionUsage {
+  playlist_id: string;
+  name: string;
+  max_connections: number;
+  recordings: number;
+  viewers: number;
+}
+
+// Counts of a playlist import, partial while it runs
+export interface PlaylistImportResult {
+  playlist_id: string;
+  parsed: number;
+  inserted: number;
+  updated: number;
+  unchanged: number;
+  removed: number;
+  missing: number;
+  failed: number;
+  epg_url?: string;
+  diff?: PlaylistDiff;
+}
+
+export interface PlaylistDiffChannel {
+  id?: string;
+  name: string;
+  group?: string;
+}
+
+// What an import changed, each list capped at 100 channels
+export interface PlaylistDiff {
+  added: PlaylistDiffChannel[];
+  changed: (PlaylistDiffChannel & { fields: string[]; old_name?: string })[];
+  removed: PlaylistDiffChannel[];
+  missing: PlaylistDiffChannel[];
+}
+
+export interface PlaylistSyncJob {
+  id: string;
+  status: 'pending' | 'running' | 'completed' | 'failed' | 'cancelled';
+  progress: number;
+  message?: string;
+  error?: string;
+  attempts: number;
+  result?: PlaylistImportResult;
+  created_at: string;
+  started_at?: string;
+  finished_at?: string;
+}
+
+// A finished import, from the playlist_syncs collection
+export interface PlaylistSync {
+  id: string;
+  playlist: string;
+  job: string;
+  status: 'completed' | 'failed';
+  error?: string;
+  parsed: number;
+  inserted: number;
+  changed: number;
+  unchanged: number;
+  removed: number;
+  missing: number;
+  failed: number;
+  diff?: PlaylistDiff;
+  started_at?: string;
+  finished_at: string;
+  created: string;
+}
+
+export interface PlaylistSyncStatus {
+  playlist_id: string;
+  last_synced: string | null;
+  job: PlaylistSyncJob | null;
+  last_sync: PlaylistSync | null;
+}
+
 // Channel types
 export interface Channel {
   id: string;
@@ -54,10 +148,65 @@ export interface Channel {
   sort_order: number;
   custom_name?: string;
   custom_logo?: string;
+  merged_into?: string;
+  priority?: number;
+  locked?: boolean;
+  // Set when the user has channel groups: group_title is then the group
+  // listing the channel, provider_group the provider's
+  provider_group?: string;
+  virtual_groups?: string[];
+  // Guide sources taking precedence for this channel, in order
+  epg_sources?: string[];
   created: string;
   updated: string;
 }
 
+// One action applied to many channels at once
+export interface ChannelBulkOperation {
+  action: 'enable' | 'disable' | 'set_group' | 'reorder' | 'reset' | 'delete';
+  ids: string[];
+  group?: string;
+  start?: number;
+}
+
+export interface ChannelBulkResult {
+  action: ChannelBulkOperation['action'];
+  affected: number;
+}
+
+// Channels that look like the same channel, the first one is the
+// suggested primary
+export interface DuplicateGroup {
+  key: string;
+  channels: { channel: Channel; quality: Channel['quality'] | ''; sources: number }[];
+}
+
+// Source of a merged channel, current is the one played now
+export interface ChannelSource {
+  channel: Channel;
+  quality: Channel['quality'] | '';
+  down: boolean;
+  current: boolean;
+}
+
+// A group of the channel lists: one of the user's own, with an id, or a
+// provider group left as is
+export interface ChannelGroup {
+  id?: string;
+  name: string;
+  provider_groups: string[];
+  channels: string[];
+  sort_order: number;
+  count: number;
+}
+
+export interface ChannelGroupInput {
+  name: string;
+  provider_groups?: string[];
+  channels?: string[];
+  sort_order?: number;
+}
+
 // Category types
 export interface Category {
   id: string;
@@ -84,20 +233,89 @@ export interface Favorite {
   };
 }
 
+export interface FavoritesCopyResult {
+  copied: number;
+  skipped: number;
+}
+
+// Channels added by name, names of channels already favorites or hidden
+// from the profile are counted
+export interface FavoritesImportResult {
+  added: string[];
+  existing: number;
+  skipped: number;
+  not_found: string[];
+}
+
 // Watch history types
 export interface WatchHistory {
   id: string;
   profile: string;
   channel: string;
+  recording?: string;
   watched_at: string;
+  position?: number;
   duration: number;
   progress?: number;
   created: string;
   expand?: {
     channel?: Channel;
+    recording?: Recording;
   };
 }
 
+// Playback position reported by the player, in seconds
+export interface WatchProgress {
+  profile: string;
+  channel?: string;
+  recording?: string;
+  position: number;
+  duration: number;
+}
+
+// Viewing statistics over a period, times in hours
+export type StatsPeriod = 'day' | 'week' | 'month' | 'year';
+
+export interface WatchStats {
+  period: StatsPeriod;
+  from: string;
+  to: string;
+  timezone: string;
+  total_hours: number;
+  profiles: { id: string; name: string; hours: number }[];
+  top_channels: { id: string; name: string; group: string; hours: number }[];
+  top_groups: { group: string; hours: number }[];
+  // Hours watched by hour of day, 0 to 23
+  heatmap: number[];
+}
+
+// Suggestions for a profile, best first; scores are between 0 and 1
+export interface Recommendations {
+  profile: string;
+  generated_at: string;
+  refined: boolean;
+  refine_error?: string;
+  channels: { channel: Channel; score: number; reasons: string[] }[];
+  programs: {
+    program: EPGProgram;
+    channel: Channel;
+    score: number;
+    on_now: boolean;
+    reasons: string[];
+  }[];
+}
+
+// Entry of the continue watching list, progress is between 0 and 1
+export interface ContinueItem {
+  id: string;
+  channel?: Channel;
+  recording?: Recording;
+  position: number;
+  duration: number;
+  progress: number;
+  watched_at: string;
+}
+
 // EPG types
 export interface EPGSource {
   id: string;
@@ -106,7 +324,10 @@ export interface EPGSource {
   url: string;
   is_active: boolean;
   last_synced?: string;
+  last_error?: string;
   sync_interval: number;
+  // Lower first when several sources have a channel
+  priority?: number;
   created: string;
   updated: string;
 }
@@ -126,7 +347,97 @@ export interface EPGProgram {
   created: string;
 }
 
+// Search results, best match first, each list paginated like PocketBase lists
+export interface SearchPage<T> {
+  page: number;
+  perPage: number;
+  totalItems: number;
+  totalPages: number;
+  items: T[];
+}
+
+export interface SearchResult {
+  query: string;
+  full_text: boolean;
+  channels?: SearchPage<Channel>;
+  programs?: SearchPage<{ program: EPGProgram; channel: Channel | null }>;
+}
+
+// Favorite channel with what it airs, progress into now is between 0 and 1
+export interface FavoriteNow {
+  favorite: string;
+  channel: Channel;
+  now: EPGProgram | null;
+  next: EPGProgram | null;
+  progress: number;
+}
+
+// Extras GET /api/channels joins to channels with its with parameter
+export type ChannelExtra = 'now_next' | 'thumbnail_url' | 'health';
+
+export interface ChannelHealth {
+  status: 'up' | 'degraded' | 'down';
+  sources: number;
+  sources_down: number;
+  down_since?: string;
+}
+
+export interface EnrichedChannel extends Channel {
+  now_next?: { now: EPGProgram | null; next: EPGProgram | null; progress: number };
+  thumbnail_url?: string;
+  thumbnail_blurhash?: string;
+  health?: ChannelHealth;
+}
+
+// Guide channel picked for a channel whose tvg_id doesn't match the guide
+export interface EPGMapping {
+  id: string;
+  user: string;
+  channel: string;
+  epg_id: string;
+  provider_tvg_id: string;
+  auto: boolean;
+  score: number;
+  created: string;
+  updated: string;
+}
+
+export interface EPGSuggestion {
+  epg_id: string;
+  score: number;
+}
+
+export interface EPGUnmatched {
+  channel: Channel;
+  suggestions: EPGSuggestion[];
+}
+
+export interface EPGAutoResult {
+  matched: { channel: string; name: string; epg_id: string; score: number }[];
+  unmatched: number;
+}
+
+// Notification before a programme starts, sent at remind_at
+export interface Reminder {
+  id: string;
+  user: string;
+  profile?: string;
+  program: string;
+  channel?: string;
+  title: string;
+  start_time: string;
+  minutes_before: number;
+  remind_at: string;
+  status: 'pending' | 'sent' | 'missed';
+  sent_at?: string;
+  created: string;
+  updated: string;
+}
+
 // Recording types
+// What a recording keeps: the video, or the audio only
+export type RecordingMode = 'video' | 'aac' | 'mp3' | 'opus';
+
 export interface Recording {
   id: string;
   profile: string;
@@ -136,9 +447,25 @@ export interface Recording {
   scheduled_end: string;
   actual_start?: string;
   actual_end?: string;
-  status: 'scheduled' | 'recording' | 'completed' | 'failed';
+  status: 'scheduled' | 'recording' | 'completed' | 'completed_partial' | 'failed';
   file_path?: string;
   file_size?: number;
+  // Schedule the recording was expanded from
+  schedule?: string;
+  mode?: RecordingMode;
+  // Why it failed, or what it waits for
+  error?: string;
+  // Found by ffprobe once finished; duration in seconds
+  duration?: number;
+  width?: number;
+  height?: number;
+  video_codec?: string;
+  audio_codec?: string;
+  probed_at?: string;
+  // From the guide and ad break detection, once probed
+  chapters?: RecordingChapter[] | null;
+  ad_breaks?: RecordingAdBreak[] | null;
+  chapters_at?: string;
   created: string;
   updated: string;
   expand?: {
@@ -146,6 +473,142 @@ export interface Recording {
   };
 }
 
+// Recurring recording, expanded into recordings a week ahead
+export interface Schedule {
+  id: string;
+  user: string;
+  profile: string;
+  channel: string;
+  title: string;
+  frequency: 'daily' | 'weekly';
+  days?: ('mon' | 'tue' | 'wed' | 'thu' | 'fri' | 'sat' | 'sun')[];
+  start_time: string; // HH:MM
+  duration: number; // Minutes
+  timezone?: string;
+  start_date?: string; // YYYY-MM-DD
+  end_date?: string;
+  skip_dates?: string[] | null;
+  mode?: RecordingMode;
+  is_active: boolean;
+  expanded_until?: string;
+  created: string;
+  updated: string;
+}
+
+// Part of a recording kept when trimming, in seconds from its start; an
+// end of 0 keeps the rest
+export interface RecordingKeepRange {
+  start: number;
+  end: number;
+}
+
+// Recorded file, from GET /api/recorder/files
+export interface RecordedFile {
+  id: string; // Of its record in recordings
+  name: string;
+  title: string;
+  profile: string;
+  channel?: string;
+  channel_name?: string;
+  schedule?: string;
+  status: Recording['status'];
+  mode: RecordingMode;
+  started_at?: string;
+  ended_at?: string;
+  size: number;
+  duration: number; // Seconds, 0 until probed
+  width?: number;
+  height?: number;
+  video_codec?: string;
+  audio_codec?: string;
+  subtitles?: string;
+  protected: boolean;
+  error?: string;
+}
+
+// Recordings to delete at once; without "partial", none are deleted when
+// one of them can't be
+export interface RecordingBatchDelete {
+  ids?: string[];
+  files?: string[];
+  watched?: boolean; // Watched to the end
+  older_than?: string; // YYYY-MM-DD or RFC 3339, on the start
+  profile?: string; // Restricts watched and older_than
+  partial?: boolean;
+  dry_run?: boolean;
+}
+
+// Chapter of a recording, in seconds from its start
+export interface RecordingChapter {
+  start: number;
+  end: number;
+  title: string;
+  kind: 'programme' | 'break';
+}
+
+// Probable ad segment of a recording, in seconds from its start
+export interface RecordingAdBreak {
+  start: number;
+  end: number;
+  confidence: number;
+  signals: ('blanks' | 'silences' | 'no_logo')[];
+}
+
+export interface RecordingSkips {
+  recording_id: string;
+  analyzed_at: string | null;
+  skips: RecordingAdBreak[];
+}
+
+export interface RecordingChapters {
+  recording_id: string;
+  duration: number;
+  generated_at: string | null;
+  chapters: RecordingChapter[];
+}
+
+export interface RecordingBatchResult {
+  deleted: number;
+  failed: number;
+  dry_run?: boolean;
+  items: {
+    id?: string;
+    file?: string;
+    status: 'deleted' | 'deletable' | 'failed' | 'not_deleted';
+    error?: string;
+  }[];
+}
+
+export interface RecordedFileQuery {
+  page?: number;
+  perPage?: number;
+  sort?: 'started' | '-started' | 'title' | '-title' | 'size' | '-size' | 'duration' | '-duration' | 'channel' | '-channel';
+  channel?: string;
+  profile?: string;
+  status?: Recording['status'];
+  from?: string; // YYYY-MM-DD or RFC 3339, on the start
+  to?: string;
+}
+
+// Time range where more recordings are scheduled than a provider allows
+// connections; the schedule being checked has no id
+export interface RecordingConflict {
+  playlist_id: string;
+  playlist: string;
+  max_connections: number;
+  start: string;
+  end: string;
+  recordings: {
+    id?: string;
+    title: string;
+    channel_id: string;
+    start: string;
+    end: string;
+  }[];
+  // Recordings of other users
+  others: number;
+}
+
 // Settings types
 export interface UserSettings {
   id: string;
@@ -163,7 +626,7 @@ export interface AuthState {
   isAuthenticated: boolean;
   isLoading: boolean;
   requires2FA: boolean;
-  pendingUserId?: string;
+  pendingMFAToken?: string;
 }
 
 export interface LoginCredentials {
@@ -178,6 +641,29 @@ export interface RegisterData {
   passwordConfirm: string;
 }
 
+// A client the user is signed in on
+export interface Session {
+  id: string;
+  device: string;
+  user_agent: string;
+  ip: string;
+  created: string;
+  last_seen: string;
+  current: boolean;
+}
+
+// A browser allowed to skip the 2FA code
+export interface TrustedDevice {
+  id: string;
+  device: string;
+  user_agent: string;
+  ip: string;
+  created: string;
+  expires_at: string;
+  last_used_at: string;
+  current: boolean;
+}
+
 export interface TOTPSetupResponse {
   secret: string;
   qrCode: string;